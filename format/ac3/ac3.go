@@ -0,0 +1,173 @@
+package ac3
+
+// Shared AC-3 and E-AC-3 syntax, tables and CRC
+// ATSC A/52:2018 Digital Audio Compression (AC-3, E-AC-3) Standard
+// ETSI TS 102 366 Digital Audio Compression (AC-3, Enhanced AC-3) Standard
+
+import (
+	"fmt"
+
+	"github.com/wader/fq/pkg/checksum"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+const syncWord = 0x0b77
+
+// number of audio blocks in an AC-3 syncframe, E-AC-3 can have fewer
+const ac3NumBlocks = 6
+
+var sampleRateNames = scalar.UintMapSymUint{
+	0b00: 48000,
+	0b01: 44100,
+	0b10: 32000,
+}
+
+const (
+	acmodDualMono = 0b000
+	acmodMono     = 0b001
+	acmodStereo   = 0b010
+)
+
+var acmodNames = scalar.UintMap{
+	acmodDualMono: {Sym: "1+1", Description: "Ch1, Ch2"},
+	acmodMono:     {Sym: "1/0", Description: "C"},
+	acmodStereo:   {Sym: "2/0", Description: "L, R"},
+	0b011:         {Sym: "3/0", Description: "L, C, R"},
+	0b100:         {Sym: "2/1", Description: "L, R, S"},
+	0b101:         {Sym: "3/1", Description: "L, C, R, S"},
+	0b110:         {Sym: "2/2", Description: "L, R, SL, SR"},
+	0b111:         {Sym: "3/2", Description: "L, C, R, SL, SR"},
+}
+
+// full bandwidth channels per acmod
+var acmodChannels = [...]uint64{2, 1, 2, 3, 3, 4, 4, 5}
+
+var bsmodNames = scalar.UintMapSymStr{
+	0b000: "main_complete",
+	0b001: "main_music_and_effects",
+	0b010: "associated_visually_impaired",
+	0b011: "associated_hearing_impaired",
+	0b100: "associated_dialogue",
+	0b101: "associated_commentary",
+	0b110: "associated_emergency",
+	0b111: "associated_voice_over",
+}
+
+var dsurmodNames = scalar.UintMapSymStr{
+	0b00: "not_indicated",
+	0b01: "not_dolby_surround",
+	0b10: "dolby_surround",
+	0b11: "reserved",
+}
+
+var roomtypNames = scalar.UintMapSymStr{
+	0b00: "not_indicated",
+	0b01: "large_room",
+	0b10: "small_room",
+	0b11: "reserved",
+}
+
+// dialogue normalization is -dialnorm dB, 0 is reserved and means -31 dB
+var dialnormDescription = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	v := s.Actual
+	if v == 0 {
+		v = 31
+	}
+	s.Description = fmt.Sprintf("-%d dB", v)
+	return s, nil
+})
+
+// peak mixing level is 80 + mixlevel dB SPL
+var mixlevelDescription = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	s.Description = fmt.Sprintf("%d dB SPL", 80+s.Actual)
+	return s, nil
+})
+
+func crc16(bs []byte) []byte {
+	crc := &checksum.CRC{Bits: 16, Table: checksum.ANSI16Table}
+	_, _ = crc.Write(bs)
+	return crc.Sum(nil)
+}
+
+// multiply polynomials modulo the CRC polynomial x^16 + x^15 + x^2 + 1
+func crc16MulMod(a, b uint) uint {
+	var r uint
+	for ; b != 0; b >>= 1 {
+		if b&1 != 0 {
+			r ^= a
+		}
+		a <<= 1
+		if a&0x1_0000 != 0 {
+			a ^= 0x1_8005
+		}
+	}
+	return r
+}
+
+// inverse of x modulo CRC polynomial, x * (x^15 + x^14 + x) = 1
+const crc16XInverse = 0xc002
+
+// crc1Sum returns the crc1 value that makes the CRC of the first 5/8 of frame
+// after the sync word zero. As crc1 is at the start it is the CRC of the rest
+// of the region shifted back over the region and CRC length.
+func crc1Sum(frame58 []byte) []byte {
+	rest := frame58[4:]
+	restSum := crc16(rest)
+	r := uint(restSum[0])<<8 | uint(restSum[1])
+
+	xInvPow := uint(1)
+	base := uint(crc16XInverse)
+	for e := len(rest)*8 + 16; e > 0; e >>= 1 {
+		if e&1 != 0 {
+			xInvPow = crc16MulMod(xInvPow, base)
+		}
+		base = crc16MulMod(base, base)
+	}
+	c := crc16MulMod(r, xInvPow)
+
+	return []byte{byte(c >> 8), byte(c)}
+}
+
+func bitAt(bs []byte, pos int64) uint64 {
+	return uint64(bs[pos/8]>>(7-pos%8)) & 1
+}
+
+func bitsAt(bs []byte, pos int64, nBits int) uint64 {
+	var v uint64
+	for i := int64(0); i < int64(nBits); i++ {
+		v = v<<1 | bitAt(bs, pos+i)
+	}
+	return v
+}
+
+// decodeAudioBlocksAndErrorCheck decodes from end of bsi to end of frame. Audio
+// block boundaries depend on exponents and bit allocation so all blocks are
+// one raw field. Aux data length is found by reading backwards from end of frame.
+func decodeAudioBlocksAndErrorCheck(d *decode.D, frame []byte, encInfoName string, crc2Sum []byte) {
+	end := int64(len(frame)) * 8
+	// auxdatae, crcrsv/encinfo and crc2
+	blocksEnd := end - 18
+	auxdatae := bitAt(frame, blocksEnd) == 1
+	var auxdatal int64
+	if auxdatae {
+		auxdatal = int64(bitsAt(frame, end-32, 14))
+		blocksEnd = end - 32 - auxdatal
+	}
+	if blocksEnd < d.Pos() {
+		d.Fatalf("aux data overlaps bsi")
+	}
+
+	d.FieldRawLen("audio_blocks", blocksEnd-d.Pos())
+	d.FieldStruct("auxdata", func(d *decode.D) {
+		if auxdatae {
+			d.FieldRawLen("auxbits", auxdatal)
+			d.FieldU14("auxdatal")
+		}
+		d.FieldBool("auxdatae")
+	})
+	d.FieldStruct("errorcheck", func(d *decode.D) {
+		d.FieldU1(encInfoName)
+		d.FieldU16("crc2", d.UintValidateBytes(crc2Sum), scalar.UintHex)
+	})
+}
@@ -0,0 +1,162 @@
+package ac3
+
+// ATSC A/52:2018 5 Bit stream syntax
+
+import (
+	"embed"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed ac3_frame.md
+var ac3FrameFS embed.FS
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:        format.AC3_FRAME,
+		Description: "AC-3 (Dolby Digital) syncframe",
+		DecodeFn:    ac3FrameDecode,
+	})
+	interp.RegisterFS(ac3FrameFS)
+}
+
+// kbit/s for frmsizecod / 2
+var ac3Bitrates = [...]uint64{32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 448, 512, 576, 640}
+
+// A/52 Table 5.18 Frame size code table, syncframe size in 16 bit words. 44.1 kHz
+// frames are padded with one word for odd codes to keep average bitrate.
+func ac3FrameWords(fscod uint64, frmsizecod uint64) uint64 {
+	bitrate := ac3Bitrates[frmsizecod/2]
+	switch fscod {
+	case 0b00:
+		return bitrate * 2
+	case 0b01:
+		return bitrate*320/147 + frmsizecod&1
+	default:
+		return bitrate * 3
+	}
+}
+
+// bsid above 10 is E-AC-3
+const ac3MaxBSID = 10
+
+// A/52 Annex D alternate bit stream syntax
+const ac3BSIDAlternateSyntax = 6
+
+var frmsizecodDescription = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	if s.Actual/2 < uint64(len(ac3Bitrates)) {
+		s.Sym = ac3Bitrates[s.Actual/2] * 1000
+	}
+	return s, nil
+})
+
+func decodeAC3LanguageAndProduction(d *decode.D, suffix string) {
+	if d.FieldBool("compr" + suffix + "e") {
+		d.FieldU8("compr" + suffix)
+	}
+	if d.FieldBool("langcod" + suffix + "e") {
+		d.FieldU8("langcod" + suffix)
+	}
+	if d.FieldBool("audprodi" + suffix + "e") {
+		d.FieldU5("mixlevel"+suffix, mixlevelDescription)
+		d.FieldU2("roomtyp"+suffix, roomtypNames)
+	}
+}
+
+func decodeAC3BSI(d *decode.D) {
+	bsid := d.FieldU5("bsid", d.UintAssertRange(0, ac3MaxBSID))
+	d.FieldU3("bsmod", bsmodNames)
+	acmod := d.FieldU3("acmod", acmodNames)
+	// 3 front channels
+	if acmod&0b001 != 0 && acmod != acmodMono {
+		d.FieldU2("cmixlev")
+	}
+	// surround channels
+	if acmod&0b100 != 0 {
+		d.FieldU2("surmixlev")
+	}
+	if acmod == acmodStereo {
+		d.FieldU2("dsurmod", dsurmodNames)
+	}
+	lfeon := d.FieldBool("lfeon")
+	channels := acmodChannels[acmod]
+	if lfeon {
+		channels++
+	}
+	d.FieldValueUint("channels", channels)
+
+	d.FieldU5("dialnorm", dialnormDescription)
+	decodeAC3LanguageAndProduction(d, "")
+	if acmod == acmodDualMono {
+		d.FieldU5("dialnorm2", dialnormDescription)
+		decodeAC3LanguageAndProduction(d, "2")
+	}
+	d.FieldBool("copyrightb")
+	d.FieldBool("origbs")
+	if bsid == ac3BSIDAlternateSyntax {
+		// A/52 Annex D alternate bit stream syntax
+		if d.FieldBool("xbsi1e") {
+			d.FieldU2("dmixmod")
+			d.FieldU3("ltrtcmixlev")
+			d.FieldU3("ltrtsurmixlev")
+			d.FieldU3("lorocmixlev")
+			d.FieldU3("lorosurmixlev")
+		}
+		if d.FieldBool("xbsi2e") {
+			d.FieldU2("dsurexmod")
+			d.FieldU2("dheadphonmod")
+			d.FieldU1("adconvtyp")
+			d.FieldU8("xbsi2")
+			d.FieldU1("encinfo")
+		}
+	} else {
+		if d.FieldBool("timecod1e") {
+			d.FieldU14("timecod1")
+		}
+		if d.FieldBool("timecod2e") {
+			d.FieldU14("timecod2")
+		}
+	}
+	if d.FieldBool("addbsie") {
+		addbsil := d.FieldU6("addbsil")
+		d.FieldRawLen("addbsi", int64(addbsil+1)*8)
+	}
+}
+
+func ac3FrameDecode(d *decode.D) any {
+	var frameWords uint64
+	var crc1Value *decode.Value
+
+	d.FieldStruct("syncinfo", func(d *decode.D) {
+		d.FieldU16("syncword", d.UintAssert(syncWord), scalar.UintHex)
+		d.FieldU16("crc1", scalar.UintHex)
+		crc1Value = d.FieldGet("crc1")
+		fscod := d.FieldU2("fscod", sampleRateNames)
+		if fscod == 0b11 {
+			d.Fatalf("reserved fscod")
+		}
+		frmsizecod := d.FieldU6("frmsizecod", d.UintAssertRange(0, uint64(len(ac3Bitrates)*2-1)), frmsizecodDescription)
+		frameWords = ac3FrameWords(fscod, frmsizecod)
+		d.FieldValueUint("frame_size", frameWords*2)
+	})
+
+	frame, err := d.TryBytesRange(0, int(frameWords*2))
+	if err != nil {
+		d.Fatalf("frame size %d bytes outside buffer", frameWords*2)
+	}
+
+	// crc1 is over first 5/8 of syncframe after sync word and is placed first
+	frame58 := frame[:((frameWords>>1)+(frameWords>>3))*2]
+	_ = crc1Value.TryUintScalarFn(d.UintValidateBytes(crc1Sum(frame58)))
+
+	d.FramedFn(int64(len(frame))*8-d.Pos(), func(d *decode.D) {
+		d.FieldStruct("bsi", decodeAC3BSI)
+		d.FieldValueUint("num_blocks", ac3NumBlocks)
+		decodeAudioBlocksAndErrorCheck(d, frame, "crcrsv", crc16(frame[2:len(frame)-2]))
+	})
+
+	return nil
+}
@@ -0,0 +1,269 @@
+package ac3
+
+// ATSC A/52:2018 Annex E Enhanced AC-3 bit stream syntax
+
+import (
+	"embed"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed eac3_frame.md
+var eac3FrameFS embed.FS
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:        format.EAC3_FRAME,
+		Description: "E-AC-3 (Dolby Digital Plus) syncframe",
+		DecodeFn:    eac3FrameDecode,
+	})
+	interp.RegisterFS(eac3FrameFS)
+}
+
+// bsid for E-AC-3, 11-15 are reserved for decodable extensions
+const (
+	eac3MinBSID = 11
+	eac3BSID    = 16
+)
+
+const (
+	strmtypIndependent = 0b00
+	strmtypDependent   = 0b01
+	strmtypAC3Convert  = 0b10
+)
+
+var strmtypNames = scalar.UintMapSymStr{
+	strmtypIndependent: "independent",
+	strmtypDependent:   "dependent",
+	strmtypAC3Convert:  "ac3_convert",
+	0b11:               "reserved",
+}
+
+var sampleRate2Names = scalar.UintMapSymUint{
+	0b00: 24000,
+	0b01: 22050,
+	0b10: 16000,
+}
+
+const numblkscodSixBlocks = 0b11
+
+var numblkscodNames = scalar.UintMapSymUint{
+	0b00: 1,
+	0b01: 2,
+	0b10: 3,
+	0b11: 6,
+}
+
+var numBlocks = [...]int{1, 2, 3, 6}
+
+const (
+	mixdefLength12 = 0b10
+	mixdefLength   = 0b11
+)
+
+// A/52 Table E.1.2 Dependent substream custom channel map, bit 15 first
+var chanmapNames = []string{"L", "C", "R", "Ls", "Rs", "Lc/Rc", "Lrs/Rrs", "Cs", "Ts", "Lsd/Rsd", "Lw/Rw", "Vhl/Vhr", "Vhc", "Lts/Rts", "LFE2", "LFE"}
+
+var chanmapDescription = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	desc := ""
+	for i, n := range chanmapNames {
+		if s.Actual&(1<<(15-i)) == 0 {
+			continue
+		}
+		if desc != "" {
+			desc += ", "
+		}
+		desc += n
+	}
+	s.Description = desc
+	return s, nil
+})
+
+func decodeEAC3MixingMetadata(d *decode.D, strmtyp uint64, acmod uint64, lfeon bool, numblkscod uint64) {
+	if acmod > acmodStereo {
+		d.FieldU2("dmixmod")
+	}
+	// 3 front channels
+	if acmod&0b001 != 0 && acmod > acmodStereo {
+		d.FieldU3("ltrtcmixlev")
+		d.FieldU3("lorocmixlev")
+	}
+	// surround channels
+	if acmod&0b100 != 0 {
+		d.FieldU3("ltrtsurmixlev")
+		d.FieldU3("lorosurmixlev")
+	}
+	if lfeon {
+		if d.FieldBool("lfemixlevcode") {
+			d.FieldU5("lfemixlevcod")
+		}
+	}
+	if strmtyp != strmtypIndependent {
+		return
+	}
+
+	if d.FieldBool("pgmscle") {
+		d.FieldU6("pgmscl")
+	}
+	if acmod == acmodDualMono {
+		if d.FieldBool("pgmscl2e") {
+			d.FieldU6("pgmscl2")
+		}
+	}
+	if d.FieldBool("extpgmscle") {
+		d.FieldU6("extpgmscl")
+	}
+	switch d.FieldU2("mixdef") {
+	case 0b01:
+		d.FieldU1("premixcmpsel")
+		d.FieldU1("drcsrc")
+		d.FieldU3("premixcmpscl")
+	case mixdefLength12:
+		d.FieldRawLen("mixdata", 12)
+	case mixdefLength:
+		mixdeflen := d.FieldU5("mixdeflen")
+		d.FieldRawLen("mixdata", int64(mixdeflen+2)*8)
+	}
+	if acmod < acmodStereo {
+		if d.FieldBool("paninfoe") {
+			d.FieldU8("panmean")
+			d.FieldU6("paninfo")
+		}
+		if acmod == acmodDualMono {
+			if d.FieldBool("paninfo2e") {
+				d.FieldU8("panmean2")
+				d.FieldU6("paninfo2")
+			}
+		}
+	}
+	if d.FieldBool("frmmixcfginfoe") {
+		if numblkscod == 0b00 {
+			d.FieldU5("blkmixcfginfo")
+		} else {
+			d.FieldStructNArray("blocks", "block", int64(numBlocks[numblkscod]), func(d *decode.D) {
+				if d.FieldBool("blkmixcfginfoe") {
+					d.FieldU5("blkmixcfginfo")
+				}
+			})
+		}
+	}
+}
+
+func decodeEAC3InformationalMetadata(d *decode.D, acmod uint64, fscod uint64) {
+	d.FieldU3("bsmod", bsmodNames)
+	d.FieldBool("copyrightb")
+	d.FieldBool("origbs")
+	if acmod == acmodStereo {
+		d.FieldU2("dsurmod", dsurmodNames)
+		d.FieldU2("dheadphonmod")
+	}
+	if acmod >= 0b110 {
+		d.FieldU2("dsurexmod")
+	}
+	if d.FieldBool("audprodie") {
+		d.FieldU5("mixlevel", mixlevelDescription)
+		d.FieldU2("roomtyp", roomtypNames)
+		d.FieldU1("adconvtyp")
+	}
+	if acmod == acmodDualMono {
+		if d.FieldBool("audprodi2e") {
+			d.FieldU5("mixlevel2", mixlevelDescription)
+			d.FieldU2("roomtyp2", roomtypNames)
+			d.FieldU1("adconvtyp2")
+		}
+	}
+	if fscod < 0b11 {
+		d.FieldBool("sourcefscod")
+	}
+}
+
+func decodeEAC3BSI(d *decode.D) uint64 {
+	strmtyp := d.FieldU2("strmtyp", strmtypNames)
+	d.FieldU3("substreamid")
+	frmsiz := d.FieldU11("frmsiz")
+	d.FieldValueUint("frame_size", (frmsiz+1)*2)
+	fscod := d.FieldU2("fscod", sampleRateNames)
+	var numblkscod uint64
+	if fscod == 0b11 {
+		d.FieldU2("fscod2", sampleRate2Names)
+		numblkscod = numblkscodSixBlocks
+		d.FieldValueUint("numblkscod", numblkscod, numblkscodNames)
+	} else {
+		numblkscod = d.FieldU2("numblkscod", numblkscodNames)
+	}
+	acmod := d.FieldU3("acmod", acmodNames)
+	lfeon := d.FieldBool("lfeon")
+	channels := acmodChannels[acmod]
+	if lfeon {
+		channels++
+	}
+	d.FieldValueUint("channels", channels)
+	d.FieldU5("bsid", d.UintAssertRange(eac3MinBSID, eac3BSID))
+	d.FieldU5("dialnorm", dialnormDescription)
+	if d.FieldBool("compre") {
+		d.FieldU8("compr")
+	}
+	if acmod == acmodDualMono {
+		d.FieldU5("dialnorm2", dialnormDescription)
+		if d.FieldBool("compr2e") {
+			d.FieldU8("compr2")
+		}
+	}
+	if strmtyp == strmtypDependent {
+		if d.FieldBool("chanmape") {
+			d.FieldU16("chanmap", scalar.UintHex, chanmapDescription)
+		}
+	}
+	if d.FieldBool("mixmdate") {
+		decodeEAC3MixingMetadata(d, strmtyp, acmod, lfeon, numblkscod)
+	}
+	if d.FieldBool("infomdate") {
+		decodeEAC3InformationalMetadata(d, acmod, fscod)
+	}
+	if strmtyp == strmtypIndependent && numblkscod != numblkscodSixBlocks {
+		d.FieldBool("convsync")
+	}
+	if strmtyp == strmtypAC3Convert {
+		blkid := true
+		if numblkscod != numblkscodSixBlocks {
+			blkid = d.FieldBool("blkid")
+		}
+		if blkid {
+			d.FieldU6("frmsizecod", frmsizecodDescription)
+		}
+	}
+	if d.FieldBool("addbsie") {
+		addbsil := d.FieldU6("addbsil")
+		d.FieldRawLen("addbsi", int64(addbsil+1)*8)
+	}
+
+	return numblkscod
+}
+
+func eac3FrameDecode(d *decode.D) any {
+	d.FieldStruct("syncinfo", func(d *decode.D) {
+		d.FieldU16("syncword", d.UintAssert(syncWord), scalar.UintHex)
+	})
+
+	// strmtyp 2 bits, substreamid 3 bits and frmsiz 11 bits
+	frameBytes := (d.PeekUintBits(16)&0x7ff + 1) * 2
+	frame, err := d.TryBytesRange(0, int(frameBytes))
+	if err != nil {
+		d.Fatalf("frame size %d bytes outside buffer", frameBytes)
+	}
+
+	d.FramedFn(int64(len(frame))*8-d.Pos(), func(d *decode.D) {
+		var numblkscod uint64
+		d.FieldStruct("bsi", func(d *decode.D) {
+			numblkscod = decodeEAC3BSI(d)
+		})
+		// audfrm is included in audio blocks
+		d.FieldValueUint("num_blocks", uint64(numBlocks[numblkscod]))
+		decodeAudioBlocksAndErrorCheck(d, frame, "encinfo", crc16(frame[2:len(frame)-2]))
+	})
+
+	return nil
+}
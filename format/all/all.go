@@ -2,11 +2,13 @@
 package all
 
 import (
+	_ "github.com/wader/fq/format/ac3"
 	_ "github.com/wader/fq/format/ape"
 	_ "github.com/wader/fq/format/apple/bookmark"
 	_ "github.com/wader/fq/format/apple/bplist"
 	_ "github.com/wader/fq/format/apple/macho"
 	_ "github.com/wader/fq/format/ar"
+	_ "github.com/wader/fq/format/asm"
 	_ "github.com/wader/fq/format/asn1"
 	_ "github.com/wader/fq/format/av1"
 	_ "github.com/wader/fq/format/avro"
@@ -22,13 +24,17 @@ import (
 	_ "github.com/wader/fq/format/elf"
 	_ "github.com/wader/fq/format/fairplay"
 	_ "github.com/wader/fq/format/flac"
+	_ "github.com/wader/fq/format/flv"
 	_ "github.com/wader/fq/format/gif"
 	_ "github.com/wader/fq/format/gzip"
+	_ "github.com/wader/fq/format/http"
+	_ "github.com/wader/fq/format/http2"
 	_ "github.com/wader/fq/format/icc"
 	_ "github.com/wader/fq/format/id3"
 	_ "github.com/wader/fq/format/inet"
 	_ "github.com/wader/fq/format/jpeg"
 	_ "github.com/wader/fq/format/json"
+	_ "github.com/wader/fq/format/kaitai"
 	_ "github.com/wader/fq/format/markdown"
 	_ "github.com/wader/fq/format/math"
 	_ "github.com/wader/fq/format/matroska"
@@ -39,9 +45,11 @@ import (
 	_ "github.com/wader/fq/format/ogg"
 	_ "github.com/wader/fq/format/opus"
 	_ "github.com/wader/fq/format/pcap"
+	_ "github.com/wader/fq/format/pe"
 	_ "github.com/wader/fq/format/png"
 	_ "github.com/wader/fq/format/prores"
 	_ "github.com/wader/fq/format/protobuf"
+	_ "github.com/wader/fq/format/quic"
 	_ "github.com/wader/fq/format/riff"
 	_ "github.com/wader/fq/format/rtmp"
 	_ "github.com/wader/fq/format/tar"
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/wader/fq/format"
+	"github.com/wader/fq/format/dwarf"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
@@ -17,12 +18,22 @@ import (
 //go:embed macho.md
 var machoFS embed.FS
 
+var arm64Format decode.Group
+var x86_64Format decode.Group
+
 func init() {
 	interp.RegisterFormat(decode.Format{
 		Name:        format.MACHO,
 		Description: "Mach-O macOS executable",
 		Groups:      []string{format.PROBE},
 		DecodeFn:    machoDecode,
+		DefaultInArg: format.MachoIn{
+			Disassemble: false,
+		},
+		Dependencies: []decode.Dependency{
+			{Names: []string{format.ARM64}, Group: &arm64Format},
+			{Names: []string{format.X86_64}, Group: &x86_64Format},
+		},
 	})
 	interp.RegisterFS(machoFS)
 }
@@ -59,6 +70,11 @@ var magicSymMapper = scalar.UintMap{
 	MH_CIGAM_64: scalar.Uint{Sym: "64be", Description: "64-bit big endian"},
 }
 
+const (
+	CPU_TYPE_X86_64 = 0x1000007
+	CPU_TYPE_ARM64  = 0x100000c
+)
+
 var cpuTypes = scalar.UintMapSymStr{
 	0xff_ff_ff_ff: "any",
 	1:             "vax",
@@ -362,7 +378,117 @@ var sectionTypes = scalar.UintMapSymStr{
 	0x15: "thread_local_init_function_pointers",
 }
 
+const dwarfSegName = "__DWARF"
+
+// machoReadDWARFSections reads all DWARF sections so that they can reference each other
+func machoReadDWARFSections(d *decode.D, archBits int, ncmds uint64) dwarf.Sections {
+	ss := dwarf.Sections{}
+	pos := d.Pos()
+	defer d.SeekAbs(pos)
+
+	loadCommandsNext := pos
+	for i := uint64(0); i < ncmds; i++ {
+		d.SeekAbs(loadCommandsNext)
+		cmd := d.U32()
+		cmdSize := d.U32()
+		if cmdSize == 0 {
+			break
+		}
+		loadCommandsNext += int64(cmdSize) * 8
+		if cmd != LC_SEGMENT && cmd != LC_SEGMENT_64 {
+			continue
+		}
+
+		// segname, vmaddr, vmsize, fileoff, filesize, maxprot, initprot
+		d.SeekRel(16*8 + int64(archBits)*4 + 2*32)
+		nsects := d.U32()
+		d.U32() // flags
+		for j := uint64(0); j < nsects; j++ {
+			sectName := d.UTF8NullFixedLen(16)
+			segName := d.UTF8NullFixedLen(16)
+			d.U(archBits) // addr
+			size := d.U(archBits)
+			offset := d.U32()
+			// align, reloff, nreloc, flags, reserved1, reserved2
+			d.SeekRel(6 * 32)
+			if archBits == 64 {
+				d.U32() // reserved3
+			}
+
+			name, zdebug, ok := dwarf.SectionName(sectName)
+			if segName != dwarfSegName || !ok {
+				continue
+			}
+			bs, err := d.TryBytesRange(int64(offset)*8, int(size))
+			if err != nil {
+				continue
+			}
+			if bs, err = dwarf.ReadSection(bs, zdebug); err == nil {
+				ss[name] = bs
+			}
+		}
+	}
+
+	return ss
+}
+
+const (
+	N_STAB = 0xe0
+	N_TYPE = 0x0e
+	N_SECT = 0x0e
+)
+
+// read names of symbols defined in sections, used to resolve addresses when disassembling
+func machoReadSymbols(d *decode.D, archBits int, ncmds uint64) map[uint64]string {
+	m := map[uint64]string{}
+	pos := d.Pos()
+	defer d.SeekAbs(pos)
+
+	nlistSize := int64(8 + archBits/8)
+	loadCommandsNext := pos
+	for i := uint64(0); i < ncmds; i++ {
+		d.SeekAbs(loadCommandsNext)
+		cmd := d.U32()
+		cmdSize := d.U32()
+		if cmdSize == 0 {
+			break
+		}
+		loadCommandsNext += int64(cmdSize) * 8
+		if cmd != LC_SYMTAB {
+			continue
+		}
+
+		symOff := int64(d.U32())
+		nSyms := int64(d.U32())
+		strOff := int64(d.U32())
+		strSize := int(d.U32())
+		strTab, err := d.TryBytesRange(strOff*8, strSize)
+		if err != nil || (symOff+nSyms*nlistSize)*8 > d.Len() {
+			continue
+		}
+		d.SeekAbs(symOff * 8)
+		for j := int64(0); j < nSyms; j++ {
+			strx := d.U32()
+			typ := d.U8()
+			d.U8()  // sect
+			d.U16() // desc
+			value := d.U(archBits)
+			if typ&N_STAB != 0 || typ&N_TYPE != N_SECT {
+				continue
+			}
+			if name := strIndexNull(int(strx), string(strTab)); name != "" {
+				m[value] = name
+			}
+		}
+	}
+
+	return m
+}
+
 func machoDecode(d *decode.D) any {
+	var mi format.MachoIn
+	d.ArgAs(&mi)
+
 	var archBits int
 	var cpuType uint64
 	var ncmds uint64
@@ -402,6 +528,11 @@ func machoDecode(d *decode.D) any {
 		}
 	})
 	loadCommandsNext := d.Pos()
+	dwarfSections := machoReadDWARFSections(d, archBits, ncmds)
+	var symbols map[uint64]string
+	if mi.Disassemble {
+		symbols = machoReadSymbols(d, archBits, ncmds)
+	}
 	d.FieldArray("load_commands", func(d *decode.D) {
 		for i := uint64(0); i < ncmds; i++ {
 			d.FieldStruct("load_command", func(d *decode.D) {
@@ -452,19 +583,22 @@ func machoDecode(d *decode.D) any {
 							d.FieldStruct("section", func(d *decode.D) {
 								// OPCODE_DECODER sectname==__text
 								sectName := d.FieldUTF8NullFixedLen("sectname", 16)
-								d.FieldUTF8NullFixedLen("segname", 16)
+								segName := d.FieldUTF8NullFixedLen("segname", 16)
+								var address uint64
 								var size uint64
 								if archBits == 32 {
-									d.FieldU32("address", scalar.UintHex)
+									address = d.FieldU32("address", scalar.UintHex)
 									size = d.FieldU32("size")
 								} else {
-									d.FieldU64("address", scalar.UintHex)
+									address = d.FieldU64("address", scalar.UintHex)
 									size = d.FieldU64("size")
 								}
 								offset := d.FieldU32("offset", scalar.UintHex)
 								d.FieldU32("align")
 								d.FieldU32("reloff")
 								d.FieldU32("nreloc")
+								sectFlags := d.U32()
+								d.SeekRel(-32)
 								// get section type
 								d.FieldStruct("flags", parseSectionFlags)
 								d.FieldU8("type", sectionTypes)
@@ -481,21 +615,24 @@ func machoDecode(d *decode.D) any {
 									// TODO: more?
 								default:
 									d.RangeFn(int64(offset)*8, int64(size)*8, func(d *decode.D) {
-										switch sectName {
-										case "__cstring":
+										dwarfName, zdebug, isDWARF := dwarf.SectionName(sectName)
+										switch {
+										case segName == dwarfSegName && isDWARF:
+											dwarf.FieldSection(d, dwarfName, zdebug, dwarfSections, archBits/8)
+										case sectName == "__cstring":
 											d.FieldArray("cstrings", func(d *decode.D) {
 												for !d.End() {
 													d.FieldUTF8Null("cstring")
 												}
 											})
-										case "__ustring":
+										case sectName == "__ustring":
 											d.FieldArray("ustrings", func(d *decode.D) {
 												for !d.End() {
 													// TODO: always LE?
 													d.FieldUTF16LENull("ustring")
 												}
 											})
-										case "__cfstring":
+										case sectName == "__cfstring":
 											d.FieldArray("cfstrings", func(d *decode.D) {
 												for !d.End() {
 													d.FieldStruct("cfstring", func(d *decode.D) {
@@ -522,6 +659,8 @@ func machoDecode(d *decode.D) any {
 													})
 												}
 											})
+										case mi.Disassemble && sectFlags&(S_ATTR_PURE_INSTRUCTIONS|S_ATTR_SOME_INSTRUCTIONS) != 0:
+											machoDecodeCode(d, cpuType, address, symbols)
 										default:
 											d.FieldRawLen("data", d.BitsLeft())
 										}
@@ -799,6 +938,32 @@ func parseSegmentFlags(d *decode.D) {
 	d.FieldBool("highvm")
 }
 
+const (
+	S_ATTR_PURE_INSTRUCTIONS = 0x8000_0000
+	S_ATTR_SOME_INSTRUCTIONS = 0x0000_0400
+)
+
+// machoDecodeCode disassembles section if cpu type is supported, otherwise raw data
+func machoDecodeCode(d *decode.D, cpuType uint64, address uint64, symbols map[uint64]string) {
+	var group *decode.Group
+	switch cpuType {
+	case CPU_TYPE_X86_64:
+		group = &x86_64Format
+	case CPU_TYPE_ARM64:
+		group = &arm64Format
+	}
+	if group != nil {
+		inArg := format.AsmIn{
+			Base:    address,
+			Symbols: symbols,
+		}
+		if dv, _, _ := d.TryFieldFormatLen("code", d.BitsLeft(), *group, inArg); dv != nil {
+			return
+		}
+	}
+	d.FieldRawLen("data", d.BitsLeft())
+}
+
 func parseSectionFlags(d *decode.D) {
 	d.FieldBool("attr_pure_instructions")
 	d.FieldBool("attr_no_toc")
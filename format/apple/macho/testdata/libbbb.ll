@@ -0,0 +1,34 @@
+; libbbb.c with debug info, equivalent to clang -g -S -emit-llvm -target x86_64-apple-macos10.12
+; used to build a Mach-O object with __DWARF sections without a darwin toolchain
+target datalayout = "e-m:o-p270:32:32-p271:32:32-p272:64:64-i64:64-f80:128-n8:16:32:64-S128"
+target triple = "x86_64-apple-macosx10.12.0"
+
+@.str = private unnamed_addr constant [12 x i8] c"libbbb_bbb\0A\00", align 1
+
+define void @libbbb_bbb() #0 !dbg !8 {
+entry:
+  %call = call i32 (i8*, ...) @printf(i8* getelementptr inbounds ([12 x i8], [12 x i8]* @.str, i64 0, i64 0)), !dbg !12
+  ret void, !dbg !13
+}
+
+declare i32 @printf(i8*, ...)
+
+attributes #0 = { noinline nounwind optnone uwtable "frame-pointer"="all" }
+
+!llvm.dbg.cu = !{!0}
+!llvm.module.flags = !{!2, !3, !4, !5}
+!llvm.ident = !{!6}
+
+!0 = distinct !DICompileUnit(language: DW_LANG_C99, file: !1, producer: "clang version 14.0.6", isOptimized: false, runtimeVersion: 0, emissionKind: FullDebug, splitDebugInlining: false, nameTableKind: None, sysroot: "/")
+!1 = !DIFile(filename: "libbbb.c", directory: "/tmp")
+!2 = !{i32 7, !"Dwarf Version", i32 4}
+!3 = !{i32 2, !"Debug Info Version", i32 3}
+!4 = !{i32 1, !"wchar_size", i32 4}
+!5 = !{i32 7, !"uwtable", i32 1}
+!6 = !{!"clang version 14.0.6"}
+!8 = distinct !DISubprogram(name: "libbbb_bbb", scope: !1, file: !1, line: 3, type: !9, scopeLine: 4, spFlags: DISPFlagDefinition, unit: !0, retainedNodes: !11)
+!9 = !DISubroutineType(types: !10)
+!10 = !{null}
+!11 = !{}
+!12 = !DILocation(line: 5, column: 5, scope: !8)
+!13 = !DILocation(line: 6, column: 1, scope: !8)
@@ -0,0 +1,58 @@
+package asm
+
+import (
+	"strings"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"golang.org/x/arch/arm64/arm64asm"
+)
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:         format.ARM64,
+		Description:  "AArch64 machine code",
+		DecodeFn:     func(d *decode.D) any { return decodeInstructions(d, arm64Arch) },
+		DefaultInArg: format.AsmIn{},
+	})
+}
+
+var arm64Arch = arch{
+	minLength: 4,
+	maxLength: 4,
+	decode: func(bs []byte, pc uint64, syms symbols) (instruction, error) {
+		inst, err := arm64asm.Decode(bs)
+		if err != nil {
+			return instruction{}, err
+		}
+
+		mnemonic, operands := splitSyntax(arm64asm.GNUSyntax(inst))
+		i := instruction{
+			length:   4,
+			mnemonic: mnemonic,
+			operands: operands,
+		}
+		for _, a := range inst.Args {
+			r, ok := a.(arm64asm.PCRel)
+			if !ok {
+				continue
+			}
+			i.target = pc + uint64(r)
+			if inst.Op == arm64asm.ADRP {
+				// relative to 4K page
+				i.target = pc&^0xfff + uint64(r)
+			}
+			i.hasTarget = true
+			// replace relative offset, ex: ".+0x10", with address or symbol
+			rel := strings.ToLower(r.String())
+			for j, o := range i.operands {
+				if o == rel {
+					i.operands[j] = syms.targetStr(i.target)
+				}
+			}
+		}
+
+		return i, nil
+	},
+}
@@ -0,0 +1,165 @@
+package asm
+
+// Disassemble machine code using golang.org/x/arch
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+// instruction is a decoded instruction in a architecture independent form
+type instruction struct {
+	length    int
+	prefixes  []string
+	mnemonic  string
+	operands  []string
+	target    uint64
+	hasTarget bool
+}
+
+type arch struct {
+	// minimum number of bytes to skip for invalid instructions
+	minLength int
+	maxLength int
+	decode    func(bs []byte, pc uint64, symbols symbols) (instruction, error)
+}
+
+// symbols by address, or if relocation is set all addresses are the relocation symbol
+// as the actual address is not known until linked
+type symbols struct {
+	names      map[uint64]string
+	relocation string
+}
+
+func (m symbols) MapUint(s scalar.Uint) (scalar.Uint, error) {
+	if name, ok := m.names[s.Actual]; ok {
+		s.Description = name
+	}
+	return s, nil
+}
+
+// lookup in the form used by x/arch, symbol name and address of symbol
+func (m symbols) lookup(addr uint64) (string, uint64) {
+	if m.relocation != "" {
+		return m.relocation, addr
+	}
+	if name, ok := m.names[addr]; ok {
+		return name, addr
+	}
+	return "", 0
+}
+
+// targetStr is symbol name or hex address
+func (m symbols) targetStr(addr uint64) string {
+	if name, _ := m.lookup(addr); name != "" {
+		return name
+	}
+	return fmt.Sprintf("%#x", addr)
+}
+
+// relocation returns symbol of first relocation in instruction bytes
+func relocation(relocations map[uint64]string, pc uint64, length int) (string, bool) {
+	for i := 0; i < length; i++ {
+		if name, ok := relocations[pc+uint64(i)]; ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// splitSyntax splits assembler syntax into mnemonic and operands, commas
+// inside brackets, ex: arm64 "[x0, #8]", are part of the operand
+func splitSyntax(s string) (string, []string) {
+	mnemonic, rest, _ := strings.Cut(strings.TrimSpace(s), " ")
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return mnemonic, nil
+	}
+
+	var operands []string
+	depth := 0
+	start := 0
+	for i, c := range rest {
+		switch c {
+		case '[', '(', '{':
+			depth++
+		case ']', ')', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				operands = append(operands, strings.TrimSpace(rest[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	operands = append(operands, strings.TrimSpace(rest[start:]))
+
+	return mnemonic, operands
+}
+
+func decodeInstruction(d *decode.D, a arch, pc uint64, syms symbols, relocations map[uint64]string) {
+	bs := d.PeekBytes(int(mathex.Min(int64(a.maxLength), d.BitsLeft()/8)))
+	inst, err := a.decode(bs, pc, syms)
+	if err != nil || inst.length <= 0 {
+		inst = instruction{
+			length:   mathex.Min(a.minLength, len(bs)),
+			mnemonic: "(bad)",
+		}
+	}
+	// operands of a relocated instruction refer to the relocation symbol and the
+	// target is not known
+	relocName, relocated := relocation(relocations, pc, inst.length)
+	if relocated && err == nil {
+		if rinst, rerr := a.decode(bs, pc, symbols{names: syms.names, relocation: relocName}); rerr == nil {
+			inst = rinst
+		}
+		inst.hasTarget = false
+	}
+
+	d.FieldValueUint("address", pc, syms, scalar.UintHex)
+	d.FieldRawLen("bytes", int64(inst.length)*8)
+	if len(inst.prefixes) > 0 {
+		d.FieldArray("prefixes", func(d *decode.D) {
+			for _, p := range inst.prefixes {
+				d.FieldValueStr("prefix", p)
+			}
+		})
+	}
+	d.FieldValueStr("mnemonic", inst.mnemonic)
+	d.FieldArray("operands", func(d *decode.D) {
+		for _, o := range inst.operands {
+			d.FieldValueStr("operand", o)
+		}
+	})
+	if inst.hasTarget {
+		d.FieldValueUint("target", inst.target, syms, scalar.UintHex)
+	}
+	if relocated {
+		d.FieldValueStr("relocation", relocName)
+	}
+}
+
+func decodeInstructions(d *decode.D, a arch) any {
+	var ai format.AsmIn
+	d.ArgAs(&ai)
+	syms := symbols{names: ai.Symbols}
+
+	d.FieldArray("instructions", func(d *decode.D) {
+		for d.BitsLeft() >= 8 {
+			pc := ai.Base + uint64(d.Pos()/8)
+			d.FieldStruct("instruction", func(d *decode.D) {
+				decodeInstruction(d, a, pc, syms, ai.Relocations)
+			})
+		}
+	})
+	if d.BitsLeft() > 0 {
+		d.FieldRawLen("unknown", d.BitsLeft())
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package asm
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"golang.org/x/arch/riscv64/riscv64asm"
+)
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:         format.RISCV64,
+		Description:  "RISC-V 64-bit machine code",
+		DecodeFn:     func(d *decode.D) any { return decodeInstructions(d, riscv64Arch) },
+		DefaultInArg: format.AsmIn{},
+	})
+}
+
+var riscv64Arch = arch{
+	// compressed instructions are 16 bit
+	minLength: 2,
+	maxLength: 4,
+	decode: func(bs []byte, pc uint64, syms symbols) (instruction, error) {
+		inst, err := riscv64asm.Decode(bs)
+		if err != nil {
+			return instruction{}, err
+		}
+
+		mnemonic, operands := splitSyntax(riscv64asm.GNUSyntax(inst))
+		i := instruction{
+			length:   inst.Len,
+			mnemonic: mnemonic,
+			operands: operands,
+		}
+		switch inst.Op {
+		case riscv64asm.JAL,
+			riscv64asm.BEQ, riscv64asm.BNE,
+			riscv64asm.BLT, riscv64asm.BGE,
+			riscv64asm.BLTU, riscv64asm.BGEU:
+			// last argument is pc relative offset, also last operand for pseudo instructions
+			for _, a := range inst.Args {
+				if s, ok := a.(riscv64asm.Simm); ok {
+					i.target = pc + uint64(int64(s.Imm))
+					i.hasTarget = true
+				}
+			}
+			if i.hasTarget && len(i.operands) > 0 {
+				i.operands[len(i.operands)-1] = syms.targetStr(i.target)
+			}
+		}
+
+		return i, nil
+	},
+}
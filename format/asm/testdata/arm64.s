@@ -0,0 +1,21 @@
+	.text
+	.globl _start
+_start:
+	stp x29, x30, [sp, #-16]!
+	mov x8, #64
+	adrp x1, msg
+	add x1, x1, :lo12:msg
+	svc #0
+	bl f
+	cbz x0, 1f
+	b.ne 1f
+	ldr x2, lit
+1:
+	ldp x29, x30, [sp], #16
+	ret
+f:
+	ret
+lit:
+	.quad 0
+msg:
+	.ascii "hello\n"
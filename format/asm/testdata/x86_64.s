@@ -0,0 +1,23 @@
+	.text
+	.globl _start
+_start:
+	push rbp
+	mov rbp, rsp
+	mov eax, 1
+	mov edi, 1
+	lea rsi, [rip + msg]
+	mov edx, 6
+	syscall
+	call f
+	test eax, eax
+	je 1f
+	rep movsb
+	lock add dword ptr [rsi], 1
+1:
+	mov eax, 60
+	xor edi, edi
+	syscall
+f:
+	ret
+msg:
+	.ascii "hello\n"
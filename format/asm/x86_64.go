@@ -0,0 +1,66 @@
+package asm
+
+import (
+	"strings"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"golang.org/x/arch/x86/x86asm"
+)
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:         format.X86_64,
+		Description:  "x86-64 machine code",
+		DecodeFn:     func(d *decode.D) any { return decodeInstructions(d, x86_64Arch) },
+		DefaultInArg: format.AsmIn{},
+	})
+}
+
+var x86_64Arch = arch{
+	minLength: 1,
+	maxLength: 15,
+	decode: func(bs []byte, pc uint64, syms symbols) (instruction, error) {
+		inst, err := x86asm.Decode(bs, 64)
+		if err != nil {
+			return instruction{}, err
+		}
+
+		// 32 bit mov and push immediates are otherwise looked up as symbols which
+		// is misleading for small values, ex: in object files where code starts at 0
+		lookup := syms.lookup
+		for _, a := range inst.Args {
+			if _, ok := a.(x86asm.Imm); ok && (inst.Op == x86asm.MOV || inst.Op == x86asm.PUSH) {
+				lookup = nil
+			}
+		}
+
+		// prefixes are split out by formatting again without them
+		text := x86asm.IntelSyntax(inst, pc, lookup)
+		noPrefixInst := inst
+		noPrefixInst.Prefix = x86asm.Prefixes{}
+		noPrefixText := x86asm.IntelSyntax(noPrefixInst, pc, lookup)
+		var prefixes []string
+		if strings.HasSuffix(text, noPrefixText) {
+			prefixes = strings.Fields(strings.TrimSuffix(text, noPrefixText))
+			text = noPrefixText
+		}
+
+		mnemonic, operands := splitSyntax(text)
+		i := instruction{
+			length:   inst.Len,
+			prefixes: prefixes,
+			mnemonic: mnemonic,
+			operands: operands,
+		}
+		for _, a := range inst.Args {
+			if r, ok := a.(x86asm.Rel); ok {
+				i.target = pc + uint64(inst.Len) + uint64(r)
+				i.hasTarget = true
+			}
+		}
+
+		return i, nil
+	},
+}
@@ -184,12 +184,23 @@ func decodeASN1BERValue(d *decode.D, bib *bitio.Buffer, sb *strings.Builder, par
 		tag = d.FieldUintFn("tag", decodeTagNumber)
 	}
 
-	length := d.FieldUintFn("length", decodeLength, lengthMap)
+	// zero length in short form is definite, ex: null or empty set, indefinite length
+	// is long form without length bytes
+	indefinite := d.PeekUintBits(8) == 0b1000_0000
+	var length uint64
+	if indefinite {
+		length = d.FieldUintFn("length", decodeLength, lengthMap)
+	} else {
+		length = d.FieldUintFn("length", decodeLength)
+	}
+	// end of content is only valid as end marker of a indefinite length value
+	if class == classUniversal && tag == universalTypeEndOfContent {
+		d.Fatalf("end of content outside indefinite length value")
+	}
 	var l int64
-	switch length {
-	case lengthIndefinite:
-		// null has zero length byte
-		if !(class == classUniversal && tag == universalTypeNull) && form == formPrimitive {
+	switch {
+	case indefinite:
+		if form == formPrimitive {
 			d.Fatalf("primitive with indefinite length")
 		}
 		l = d.BitsLeft()
@@ -202,7 +213,7 @@ func decodeASN1BERValue(d *decode.D, bib *bitio.Buffer, sb *strings.Builder, par
 		case form == formConstructed || tag == universalTypeSequence || tag == universalTypeSet:
 			d.FieldArray("constructed", func(d *decode.D) {
 				for !d.End() {
-					if length == lengthIndefinite && d.PeekUintBits(16) == lengthEndMarker {
+					if indefinite && d.PeekUintBits(16) == lengthEndMarker {
 						break
 					}
 
@@ -229,7 +240,7 @@ func decodeASN1BERValue(d *decode.D, bib *bitio.Buffer, sb *strings.Builder, par
 				}
 			})
 
-			if length == lengthIndefinite {
+			if indefinite {
 				d.FieldU16("end_marker")
 			}
 			if form == formConstructed {
@@ -0,0 +1,266 @@
+package dwarf
+
+// Shared DWARF debug information decoding used by ELF and Mach-O
+// https://dwarfstd.org/doc/DWARF5.pdf
+// https://dwarfstd.org/doc/DWARF4.pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+// Sections are raw, possibly inflated, DWARF sections by name without prefix,
+// ex: "abbrev" for .debug_abbrev, .zdebug_abbrev or __debug_abbrev
+type Sections map[string][]byte
+
+var sectionPrefixes = []struct {
+	prefix string
+	zdebug bool
+}{
+	{".debug_", false},
+	{".zdebug_", true},
+	{"__debug_", false},
+	{"__zdebug_", true},
+}
+
+// Mach-O section names are truncated to 16 bytes
+var truncatedSectionNames = map[string]string{
+	"str_offs": "str_offsets",
+}
+
+// SectionName returns DWARF section name without prefix and if it is a
+// legacy zlib compressed .zdebug section
+func SectionName(s string) (name string, zdebug bool, ok bool) {
+	for _, p := range sectionPrefixes {
+		if strings.HasPrefix(s, p.prefix) {
+			name := strings.TrimPrefix(s, p.prefix)
+			if n, ok := truncatedSectionNames[name]; ok {
+				name = n
+			}
+			return name, p.zdebug, true
+		}
+	}
+	return "", false, false
+}
+
+func decodeSection(d *decode.D, name string, ss Sections, addrSize int) {
+	switch name {
+	case "abbrev":
+		decodeAbbrev(d)
+	case "info":
+		decodeInfo(d, ss)
+	case "line":
+		decodeLine(d, ss, addrSize)
+	case "str", "line_str":
+		d.FieldArray("strings", func(d *decode.D) {
+			for !d.End() {
+				d.FieldUTF8Null("string")
+			}
+		})
+	case "aranges":
+		decodeAranges(d)
+	case "ranges":
+		decodeRanges(d, addrSize)
+	case "loc":
+		decodeLoc(d, ss, addrSize)
+	case "rnglists":
+		decodeRngLists(d)
+	case "loclists":
+		decodeLocLists(d)
+	case "str_offsets":
+		decodeStrOffsets(d)
+	case "addr":
+		decodeAddr(d)
+	default:
+		d.Fatalf("unknown section %s", name)
+	}
+}
+
+func sectionFormat(name string, ss Sections, addrSize int, endian decode.Endian) decode.Group {
+	return decode.FormatFn(func(d *decode.D) any {
+		d.Endian = endian
+		decodeSection(d, name, ss, addrSize)
+		return nil
+	})
+}
+
+func tryFieldSectionLen(d *decode.D, fieldName string, nBits int64, name string, ss Sections, addrSize int) bool {
+	dv, _, _ := d.TryFieldFormatLen(fieldName, nBits, sectionFormat(name, ss, addrSize, d.Endian), nil)
+	return dv != nil
+}
+
+func tryFieldSectionBitBuf(d *decode.D, fieldName string, br bitio.ReaderAtSeeker, name string, ss Sections, addrSize int) bool {
+	dv, _, _ := d.TryFieldFormatBitBuf(fieldName, br, sectionFormat(name, ss, addrSize, d.Endian), nil)
+	return dv != nil
+}
+
+// FieldSection decodes rest of d as DWARF section name using other sections
+// to resolve abbreviations, strings etc. Legacy zdebug sections are decoded
+// from their inflated version in ss. addrSize is address size in bytes to
+// use for sections that has no header with address size.
+// Unknown or broken sections are added as raw data.
+func FieldSection(d *decode.D, name string, zdebug bool, ss Sections, addrSize int) {
+	if zdebug && isZdebugCompressed(d.PeekBytes(mathex.Min(int(d.BitsLeft()/8), zdebugHeaderSize))) {
+		d.FieldUTF8("magic", len(zdebugMagic))
+		d.FieldU64BE("uncompressed_size")
+		d.FieldRawLen("compressed", d.BitsLeft())
+		FieldInflatedSection(d, name, ss, addrSize)
+		return
+	}
+	if !tryFieldSectionLen(d, "dwarf", d.BitsLeft(), name, ss, addrSize) {
+		d.FieldRawLen("data", d.BitsLeft())
+	}
+}
+
+// FieldInflatedSection decodes DWARF section name from inflated version in ss,
+// used for compressed sections
+func FieldInflatedSection(d *decode.D, name string, ss Sections, addrSize int) {
+	bs, ok := ss[name]
+	if !ok {
+		return
+	}
+	br := bitio.NewBitReader(bs, -1)
+	if !tryFieldSectionBitBuf(d, "dwarf", br, name, ss, addrSize) {
+		d.FieldRootBitBuf("uncompressed", br)
+	}
+}
+
+const maxUncompressedSize = 100_000_000
+
+// Inflate inflates zlib stream in r that is expected to be size bytes
+func Inflate(r io.Reader, size uint64) ([]byte, error) {
+	if size > maxUncompressedSize {
+		return nil, fmt.Errorf("uncompressed size too large %d > %d", size, maxUncompressedSize)
+	}
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(io.LimitReader(zr, int64(size)))
+}
+
+// legacy .zdebug sections are "ZLIB", 64 bit big endian uncompressed size and zlib stream
+const zdebugMagic = "ZLIB"
+const zdebugHeaderSize = 12
+
+func isZdebugCompressed(bs []byte) bool {
+	return len(bs) >= zdebugHeaderSize && string(bs[0:len(zdebugMagic)]) == zdebugMagic
+}
+
+// ReadSection returns section content, inflated if it is a compressed legacy
+// zdebug section
+func ReadSection(bs []byte, zdebug bool) ([]byte, error) {
+	if !zdebug || !isZdebugCompressed(bs) {
+		return bs, nil
+	}
+	size := binary.BigEndian.Uint64(bs[len(zdebugMagic):zdebugHeaderSize])
+	return Inflate(bytes.NewReader(bs[zdebugHeaderSize:]), size)
+}
+
+const dwarf64Length = 0xffff_ffff
+
+var unitLengthNames = scalar.UintMap{
+	dwarf64Length: {Sym: "dwarf64", Description: "64-bit DWARF, actual length follows"},
+}
+
+// decodeUnitLength decodes initial length and returns offset size in bytes
+// and unit length in bytes
+func decodeUnitLength(d *decode.D) (int, int64) {
+	length := d.FieldU32("unit_length", unitLengthNames)
+	if length == dwarf64Length {
+		return 8, int64(d.FieldU64("unit_length64"))
+	}
+	if length >= 0xffff_fff0 {
+		d.Fatalf("reserved unit length %x", length)
+	}
+	return 4, int64(length)
+}
+
+// peekUnitSize returns size in bits of unit including initial length
+func peekUnitSize(d *decode.D) int64 {
+	pos := d.Pos()
+	defer d.SeekAbs(pos)
+	if d.BitsLeft() < 4*8 {
+		return d.BitsLeft()
+	}
+	length := d.U32()
+	if length == dwarf64Length {
+		if d.BitsLeft() < 8*8 {
+			return d.BitsLeft() + 4*8
+		}
+		return mathex.Min(d.BitsLeft()+4*8, (12+int64(d.U64()))*8)
+	}
+	return mathex.Min(d.BitsLeft()+4*8, (4+int64(length))*8)
+}
+
+// decodeUnits decodes units prefixed with initial length, units are decoded
+// lazily if enabled as debug info can be huge
+func decodeUnits(d *decode.D, name string, fn func(d *decode.D, offsetSize int)) {
+	d.FieldArray("units", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStructLazyFn(name, peekUnitSize(d), func(d *decode.D) {
+				offsetSize, length := decodeUnitLength(d)
+				d.FramedFn(length*8, func(d *decode.D) {
+					fn(d, offsetSize)
+				})
+			})
+		}
+	})
+}
+
+func readUint(bs []byte, offset uint64, size int, endian decode.Endian) (uint64, bool) {
+	if offset+uint64(size) > uint64(len(bs)) || offset+uint64(size) < offset {
+		return 0, false
+	}
+	b := bs[offset : offset+uint64(size)]
+	var order binary.ByteOrder = binary.LittleEndian
+	if endian == decode.BigEndian {
+		order = binary.BigEndian
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), true
+	case 2:
+		return uint64(order.Uint16(b)), true
+	case 3:
+		if endian == decode.BigEndian {
+			return uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2]), true
+		}
+		return uint64(b[2])<<16 | uint64(b[1])<<8 | uint64(b[0]), true
+	case 4:
+		return uint64(order.Uint32(b)), true
+	case 8:
+		return order.Uint64(b), true
+	}
+	return 0, false
+}
+
+func readStr(bs []byte, offset uint64) (string, bool) {
+	if offset >= uint64(len(bs)) {
+		return "", false
+	}
+	s := bs[offset:]
+	if i := strings.IndexByte(string(s), 0); i != -1 {
+		s = s[:i]
+	}
+	return string(s), true
+}
+
+// string section offset to string
+type strSection []byte
+
+func (m strSection) MapUint(s scalar.Uint) (scalar.Uint, error) {
+	if str, ok := readStr(m, s.Actual); ok {
+		s.Sym = str
+	}
+	return s, nil
+}
@@ -0,0 +1,488 @@
+package dwarf
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+// max DIE tree depth, protects against stack overflow
+const maxDIEDepth = 1000
+
+type abbrevAttribute struct {
+	name          uint64
+	form          uint64
+	implicitConst int64
+}
+
+type abbrev struct {
+	tag         uint64
+	hasChildren bool
+	attributes  []abbrevAttribute
+}
+
+var errShortBuffer = errors.New("short buffer")
+
+// minimal byte reader used to read abbreviation tables from other section
+type byteReader struct {
+	bs  []byte
+	pos int
+}
+
+func (r *byteReader) u8() (uint64, error) {
+	if r.pos >= len(r.bs) {
+		return 0, errShortBuffer
+	}
+	b := r.bs[r.pos]
+	r.pos++
+	return uint64(b), nil
+}
+
+func (r *byteReader) uleb128() (uint64, error) {
+	var v uint64
+	for shift := 0; ; shift += 7 {
+		b, err := r.u8()
+		if err != nil {
+			return 0, err
+		}
+		if shift < 64 {
+			v |= (b & 0x7f) << shift
+		}
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+}
+
+func (r *byteReader) sleb128() (int64, error) {
+	var v int64
+	var shift int
+	for {
+		b, err := r.u8()
+		if err != nil {
+			return 0, err
+		}
+		if shift < 64 {
+			v |= int64(b&0x7f) << shift
+		}
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				v |= -1 << shift
+			}
+			return v, nil
+		}
+	}
+}
+
+func (r *byteReader) skip(n uint64) error {
+	if n > uint64(len(r.bs)-r.pos) {
+		return errShortBuffer
+	}
+	r.pos += int(n)
+	return nil
+}
+
+func (r *byteReader) skipLength(n uint64, err error) error {
+	if err != nil {
+		return err
+	}
+	return r.skip(n)
+}
+
+func (r *byteReader) uint(size int, endian decode.Endian) (uint64, error) {
+	v, ok := readUint(r.bs, uint64(r.pos), size, endian)
+	if !ok {
+		return 0, errShortBuffer
+	}
+	r.pos += size
+	return v, nil
+}
+
+// formValue reads attribute value, blocks and strings are skipped and has value 0
+func (r *byteReader) formValue(uc *unitContext, form uint64) (uint64, error) {
+	switch form {
+	case formAddr:
+		return r.uint(uc.addrSize, uc.endian)
+	case formBlock1:
+		return 0, r.skipLength(r.uint(1, uc.endian))
+	case formBlock2:
+		return 0, r.skipLength(r.uint(2, uc.endian))
+	case formBlock4:
+		return 0, r.skipLength(r.uint(4, uc.endian))
+	case formBlock, formExprloc:
+		return 0, r.skipLength(r.uleb128())
+	case formData1, formFlag, formRef1, formStrx1, formAddrx1:
+		return r.uint(1, uc.endian)
+	case formData2, formRef2, formStrx2, formAddrx2:
+		return r.uint(2, uc.endian)
+	case formStrx3, formAddrx3:
+		return r.uint(3, uc.endian)
+	case formData4, formRef4, formRefSup4, formStrx4, formAddrx4:
+		return r.uint(4, uc.endian)
+	case formData8, formRef8, formRefSig8, formRefSup8:
+		return r.uint(8, uc.endian)
+	case formData16:
+		return 0, r.skip(16)
+	case formSdata:
+		v, err := r.sleb128()
+		return uint64(v), err
+	case formUdata, formRefUdata, formStrx, formAddrx, formLoclistx, formRnglistx, formGNUStrIndex, formGNUAddrIndex:
+		return r.uleb128()
+	case formString:
+		for {
+			b, err := r.u8()
+			if err != nil || b == 0 {
+				return 0, err
+			}
+		}
+	case formFlagPresent, formImplicitConst:
+		return 0, nil
+	case formStrp, formLineStrp, formStrpSup, formGNUStrpAlt, formGNURefAlt, formSecOffset:
+		return r.uint(uc.offsetSize, uc.endian)
+	case formRefAddr:
+		if uc.version == 2 {
+			return r.uint(uc.addrSize, uc.endian)
+		}
+		return r.uint(uc.offsetSize, uc.endian)
+	case formIndirect:
+		form, err := r.uleb128()
+		if err != nil {
+			return 0, err
+		}
+		if form == formIndirect {
+			return 0, fmt.Errorf("recursive indirect form")
+		}
+		return r.formValue(uc, form)
+	default:
+		return 0, fmt.Errorf("unknown form %x", form)
+	}
+}
+
+// readAbbrevs reads abbreviation table at offset into a code to abbreviation map
+func readAbbrevs(bs []byte, offset uint64) (map[uint64]abbrev, error) {
+	if offset > uint64(len(bs)) {
+		return nil, fmt.Errorf("abbrev offset %d outside section", offset)
+	}
+	r := &byteReader{bs: bs, pos: int(offset)}
+	abbrevs := map[uint64]abbrev{}
+	for {
+		code, err := r.uleb128()
+		if err != nil {
+			return nil, err
+		}
+		if code == 0 {
+			return abbrevs, nil
+		}
+		var a abbrev
+		if a.tag, err = r.uleb128(); err != nil {
+			return nil, err
+		}
+		children, err := r.u8()
+		if err != nil {
+			return nil, err
+		}
+		a.hasChildren = children != 0
+		for {
+			var at abbrevAttribute
+			if at.name, err = r.uleb128(); err != nil {
+				return nil, err
+			}
+			if at.form, err = r.uleb128(); err != nil {
+				return nil, err
+			}
+			if at.name == 0 && at.form == 0 {
+				break
+			}
+			if at.form == formImplicitConst {
+				if at.implicitConst, err = r.sleb128(); err != nil {
+					return nil, err
+				}
+			}
+			a.attributes = append(a.attributes, at)
+		}
+		abbrevs[code] = a
+	}
+}
+
+func decodeAbbrev(d *decode.D) {
+	d.FieldArray("abbrev_tables", func(d *decode.D) {
+		for !d.End() {
+			d.FieldArray("abbrev_table", func(d *decode.D) {
+				for {
+					var code uint64
+					d.FieldStruct("abbrev", func(d *decode.D) {
+						code = d.FieldULEB128("code")
+						if code == 0 {
+							return
+						}
+						d.FieldULEB128("tag", tagNames)
+						d.FieldU8("children", scalar.UintMapSymBool{0: false, 1: true})
+						d.FieldArray("attributes", func(d *decode.D) {
+							for {
+								var name, form uint64
+								d.FieldStruct("attribute", func(d *decode.D) {
+									name = d.FieldULEB128("name", attributeNames)
+									form = d.FieldULEB128("form", formNames)
+									if form == formImplicitConst {
+										d.FieldSLEB128("implicit_const")
+									}
+								})
+								if name == 0 && form == 0 {
+									break
+								}
+							}
+						})
+					})
+					if code == 0 {
+						break
+					}
+				}
+			})
+		}
+	})
+}
+
+type unitContext struct {
+	ss         Sections
+	endian     decode.Endian
+	version    uint64
+	offsetSize int
+	addrSize   int
+	abbrevs    map[uint64]abbrev
+	// DWARF 5 default bases are after section header
+	strOffsetsBase uint64
+	addrBase       uint64
+}
+
+func (uc *unitContext) strx(s scalar.Uint) (scalar.Uint, error) {
+	offset, ok := readUint(uc.ss["str_offsets"], uc.strOffsetsBase+s.Actual*uint64(uc.offsetSize), uc.offsetSize, uc.endian)
+	if !ok {
+		return s, nil
+	}
+	if str, ok := readStr(uc.ss["str"], offset); ok {
+		s.Sym = str
+	}
+	return s, nil
+}
+
+func (uc *unitContext) addrx(s scalar.Uint) (scalar.Uint, error) {
+	addr, ok := readUint(uc.ss["addr"], uc.addrBase+s.Actual*uint64(uc.addrSize), uc.addrSize, uc.endian)
+	if !ok {
+		return s, nil
+	}
+	s.Description = fmt.Sprintf("0x%x", addr)
+	return s, nil
+}
+
+func decodeBlock(d *decode.D, length uint64) {
+	if length > 0 {
+		d.FieldRawLen("value", int64(length)*8)
+	}
+}
+
+// decodeFormValue decodes attribute value field based on form
+func decodeFormValue(d *decode.D, uc *unitContext, name uint64, form uint64, implicitConst int64) uint64 {
+	var constNames scalar.UintMapper = scalar.UintMapSymStr(nil)
+	if m, ok := attributeValueNames[name]; ok {
+		constNames = m
+	}
+	strx := scalar.UintFn(uc.strx)
+	addrx := scalar.UintFn(uc.addrx)
+
+	switch form {
+	case formAddr:
+		return d.FieldU("value", uc.addrSize*8, scalar.UintHex)
+	case formBlock1:
+		decodeBlock(d, d.FieldU8("length"))
+	case formBlock2:
+		decodeBlock(d, d.FieldU16("length"))
+	case formBlock4:
+		decodeBlock(d, d.FieldU32("length"))
+	case formBlock, formExprloc:
+		// TODO: decode DW_OP expressions
+		decodeBlock(d, d.FieldULEB128("length"))
+	case formData1:
+		return d.FieldU8("value", constNames)
+	case formData2:
+		return d.FieldU16("value", constNames)
+	case formData4:
+		return d.FieldU32("value", constNames)
+	case formData8:
+		return d.FieldU64("value", constNames)
+	case formData16:
+		d.FieldRawLen("value", 16*8)
+	case formSdata:
+		d.FieldSLEB128("value")
+	case formUdata:
+		return d.FieldULEB128("value", constNames)
+	case formString:
+		d.FieldUTF8Null("value")
+	case formFlag:
+		return d.FieldU8("value")
+	case formFlagPresent:
+		d.FieldValueBool("value", true)
+	case formStrp:
+		return d.FieldU("value", uc.offsetSize*8, strSection(uc.ss["str"]))
+	case formLineStrp:
+		return d.FieldU("value", uc.offsetSize*8, strSection(uc.ss["line_str"]))
+	case formStrpSup, formGNUStrpAlt, formGNURefAlt:
+		// in supplementary object file
+		return d.FieldU("value", uc.offsetSize*8, scalar.UintHex)
+	case formStrx, formGNUStrIndex:
+		return d.FieldULEB128("value", strx)
+	case formStrx1:
+		return d.FieldU8("value", strx)
+	case formStrx2:
+		return d.FieldU16("value", strx)
+	case formStrx3:
+		return d.FieldU24("value", strx)
+	case formStrx4:
+		return d.FieldU32("value", strx)
+	case formAddrx, formGNUAddrIndex:
+		return d.FieldULEB128("value", addrx)
+	case formAddrx1:
+		return d.FieldU8("value", addrx)
+	case formAddrx2:
+		return d.FieldU16("value", addrx)
+	case formAddrx3:
+		return d.FieldU24("value", addrx)
+	case formAddrx4:
+		return d.FieldU32("value", addrx)
+	case formRef1:
+		return d.FieldU8("value", scalar.UintHex)
+	case formRef2:
+		return d.FieldU16("value", scalar.UintHex)
+	case formRef4:
+		return d.FieldU32("value", scalar.UintHex)
+	case formRef8, formRefSig8, formRefSup8:
+		return d.FieldU64("value", scalar.UintHex)
+	case formRefSup4:
+		return d.FieldU32("value", scalar.UintHex)
+	case formRefUdata:
+		return d.FieldULEB128("value", scalar.UintHex)
+	case formRefAddr:
+		// DWARF 2 used address size
+		if uc.version == 2 {
+			return d.FieldU("value", uc.addrSize*8, scalar.UintHex)
+		}
+		return d.FieldU("value", uc.offsetSize*8, scalar.UintHex)
+	case formSecOffset:
+		return d.FieldU("value", uc.offsetSize*8, scalar.UintHex)
+	case formLoclistx, formRnglistx:
+		return d.FieldULEB128("value")
+	case formImplicitConst:
+		d.FieldValueSint("value", implicitConst)
+	case formIndirect:
+		form := d.FieldULEB128("indirect_form", formNames)
+		if form == formIndirect {
+			d.Fatalf("recursive indirect form")
+		}
+		return decodeFormValue(d, uc, name, form, implicitConst)
+	default:
+		d.Fatalf("unknown form %x", form)
+	}
+
+	return 0
+}
+
+func decodeDIEs(d *decode.D, uc *unitContext, depth int) {
+	if depth > maxDIEDepth {
+		d.Fatalf("max DIE depth %d reached", maxDIEDepth)
+	}
+
+	for !d.End() {
+		var code uint64
+		d.FieldStruct("die", func(d *decode.D) {
+			code = d.FieldULEB128("abbrev_code")
+			if code == 0 {
+				return
+			}
+			a, ok := uc.abbrevs[code]
+			if !ok {
+				d.Fatalf("unknown abbrev code %d", code)
+			}
+			d.FieldValueUint("tag", a.tag, tagNames)
+
+			d.FieldArray("attributes", func(d *decode.D) {
+				for _, at := range a.attributes {
+					d.FieldStruct("attribute", func(d *decode.D) {
+						d.FieldValueUint("name", at.name, attributeNames)
+						d.FieldValueUint("form", at.form, formNames)
+						v := decodeFormValue(d, uc, at.name, at.form, at.implicitConst)
+						// bases are usually in the unit DIE so following
+						// index forms can be resolved
+						switch at.name {
+						case atStrOffsetsBase:
+							uc.strOffsetsBase = v
+						case atAddrBase, atGNUAddrBase:
+							uc.addrBase = v
+						}
+					})
+				}
+			})
+
+			if a.hasChildren {
+				d.FieldArray("children", func(d *decode.D) {
+					decodeDIEs(d, uc, depth+1)
+				})
+			}
+		})
+		// null entry ends siblings, top level has no siblings but can be padded
+		if code == 0 && depth > 0 {
+			return
+		}
+	}
+}
+
+func decodeInfo(d *decode.D, ss Sections) {
+	decodeUnits(d, "unit", func(d *decode.D, offsetSize int) {
+		uc := &unitContext{
+			ss:         ss,
+			endian:     d.Endian,
+			offsetSize: offsetSize,
+		}
+
+		uc.version = d.FieldU16("version")
+		var unitType uint64
+		var abbrevOffset uint64
+		switch {
+		case uc.version >= 5:
+			unitType = d.FieldU8("unit_type", unitTypeNames)
+			uc.addrSize = int(d.FieldU8("address_size"))
+			abbrevOffset = d.FieldU("debug_abbrev_offset", offsetSize*8, scalar.UintHex)
+			switch unitType {
+			case 0x02, 0x06:
+				d.FieldU64("type_signature", scalar.UintHex)
+				d.FieldU("type_offset", offsetSize*8, scalar.UintHex)
+			case 0x04, 0x05:
+				d.FieldU64("dwo_id", scalar.UintHex)
+			}
+			// default to first entry after str_offsets and addr header
+			uc.strOffsetsBase = uint64(offsetSize) * 2
+			uc.addrBase = uint64(offsetSize) * 2
+		case uc.version >= 2:
+			abbrevOffset = d.FieldU("debug_abbrev_offset", offsetSize*8, scalar.UintHex)
+			uc.addrSize = int(d.FieldU8("address_size"))
+		default:
+			d.Fatalf("unsupported version %d", uc.version)
+		}
+		switch uc.addrSize {
+		case 1, 2, 4, 8:
+		default:
+			d.Fatalf("unsupported address size %d", uc.addrSize)
+		}
+
+		abbrevs, err := readAbbrevs(ss["abbrev"], abbrevOffset)
+		if err != nil {
+			d.Fatalf("failed to read abbrev table: %s", err)
+		}
+		uc.abbrevs = abbrevs
+
+		d.FieldArray("dies", func(d *decode.D) {
+			decodeDIEs(d, uc, 0)
+		})
+	})
+}
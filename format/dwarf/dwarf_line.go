@@ -0,0 +1,252 @@
+package dwarf
+
+import (
+	"fmt"
+
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+const (
+	lnsCopy             = 0x01
+	lnsAdvancePC        = 0x02
+	lnsAdvanceLine      = 0x03
+	lnsSetFile          = 0x04
+	lnsSetColumn        = 0x05
+	lnsNegateStmt       = 0x06
+	lnsSetBasicBlock    = 0x07
+	lnsConstAddPC       = 0x08
+	lnsFixedAdvancePC   = 0x09
+	lnsSetPrologueEnd   = 0x0a
+	lnsSetEpilogueBegin = 0x0b
+	lnsSetISA           = 0x0c
+
+	lneEndSequence      = 0x01
+	lneSetAddress       = 0x02
+	lneDefineFile       = 0x03
+	lneSetDiscriminator = 0x04
+)
+
+type lineEntryFormat struct {
+	contentType uint64
+	form        uint64
+}
+
+// line number state machine registers needed to show rows
+type lineState struct {
+	address uint64
+	line    int64
+	file    uint64
+}
+
+func decodeLineEntryFormats(d *decode.D, name string) []lineEntryFormat {
+	var formats []lineEntryFormat
+	count := d.FieldU8(name + "_format_count")
+	d.FieldArray(name+"_formats", func(d *decode.D) {
+		for i := uint64(0); i < count; i++ {
+			d.FieldStruct("format", func(d *decode.D) {
+				formats = append(formats, lineEntryFormat{
+					contentType: d.FieldULEB128("content_type", lineContentTypeNames),
+					form:        d.FieldULEB128("form", formNames),
+				})
+			})
+		}
+	})
+	return formats
+}
+
+func decodeLineEntries(d *decode.D, uc *unitContext, name string, entryName string, formats []lineEntryFormat) {
+	count := d.FieldULEB128(name + "_count")
+	d.FieldArray(name, func(d *decode.D) {
+		for i := uint64(0); i < count; i++ {
+			d.FieldStruct(entryName, func(d *decode.D) {
+				for _, f := range formats {
+					// content types appear at most once so use as field name
+					contentName, ok := lineContentTypeNames[f.contentType]
+					if !ok {
+						contentName = fmt.Sprintf("content_type_%d", f.contentType)
+					}
+					d.FieldStruct(contentName, func(d *decode.D) {
+						d.FieldValueUint("form", f.form, formNames)
+						decodeFormValue(d, uc, 0, f.form, 0)
+					})
+				}
+			})
+		}
+	})
+}
+
+func decodeLineProgramHeaderV2(d *decode.D) {
+	d.FieldArray("include_directories", func(d *decode.D) {
+		for d.PeekUintBits(8) != 0 {
+			d.FieldUTF8Null("include_directory")
+		}
+	})
+	d.FieldU8("include_directories_terminator")
+	d.FieldArray("file_names", func(d *decode.D) {
+		for d.PeekUintBits(8) != 0 {
+			d.FieldStruct("file_name", func(d *decode.D) {
+				d.FieldUTF8Null("name")
+				d.FieldULEB128("directory_index")
+				d.FieldULEB128("modification_time")
+				d.FieldULEB128("length")
+			})
+		}
+	})
+	d.FieldU8("file_names_terminator")
+}
+
+func decodeLineProgram(d *decode.D, minInstLength uint64, lineBase int64, lineRange uint64, opcodeBase uint64, standardOpcodeLengths []uint64) {
+	newState := func() lineState { return lineState{line: 1, file: 1} }
+	s := newState()
+
+	row := func(d *decode.D) {
+		d.FieldValueUint("address", s.address, scalar.UintHex)
+		d.FieldValueUint("file", s.file)
+		d.FieldValueSint("line", s.line)
+	}
+
+	for !d.End() {
+		d.FieldStruct("instruction", func(d *decode.D) {
+			opcode := d.PeekUintBits(8)
+			switch {
+			case opcode == 0:
+				d.FieldU8("opcode", scalar.UintMapSymStr{0: "extended"})
+				length := d.FieldULEB128("length")
+				if length == 0 {
+					return
+				}
+				d.FramedFn(int64(length)*8, func(d *decode.D) {
+					extOpcode := d.FieldU8("extended_opcode", lineExtendedOpcodeNames)
+					switch extOpcode {
+					case lneEndSequence:
+						row(d)
+						s = newState()
+					case lneSetAddress:
+						if d.BitsLeft() > 64 {
+							d.Fatalf("address too large %d bits", d.BitsLeft())
+						}
+						s.address = d.FieldU("address", int(d.BitsLeft()), scalar.UintHex)
+					case lneDefineFile:
+						d.FieldUTF8Null("name")
+						d.FieldULEB128("directory_index")
+						d.FieldULEB128("modification_time")
+						d.FieldULEB128("file_length")
+					case lneSetDiscriminator:
+						d.FieldULEB128("discriminator")
+					default:
+						d.FieldRawLen("data", d.BitsLeft())
+					}
+				})
+			case opcode < opcodeBase:
+				d.FieldU8("opcode", lineStandardOpcodeNames)
+				switch opcode {
+				case lnsCopy:
+					row(d)
+				case lnsAdvancePC:
+					s.address += d.FieldULEB128("operation_advance") * minInstLength
+				case lnsAdvanceLine:
+					s.line += d.FieldSLEB128("line_advance")
+				case lnsSetFile:
+					s.file = d.FieldULEB128("file")
+				case lnsSetColumn:
+					d.FieldULEB128("column")
+				case lnsConstAddPC:
+					advance := ((255 - opcodeBase) / lineRange) * minInstLength
+					s.address += advance
+					d.FieldValueUint("address_advance", advance)
+				case lnsFixedAdvancePC:
+					s.address += d.FieldU16("address_advance")
+				case lnsSetISA:
+					d.FieldULEB128("isa")
+				case lnsNegateStmt,
+					lnsSetBasicBlock,
+					lnsSetPrologueEnd,
+					lnsSetEpilogueBegin:
+				default:
+					// unknown standard opcode, skip uleb128 operands
+					d.FieldArray("operands", func(d *decode.D) {
+						for i := uint64(0); i < standardOpcodeLengths[opcode-1]; i++ {
+							d.FieldULEB128("operand")
+						}
+					})
+				}
+			default:
+				d.FieldU8("opcode", scalar.UintMapSymStr{opcode: "special"})
+				adjusted := opcode - opcodeBase
+				addressAdvance := (adjusted / lineRange) * minInstLength
+				lineAdvance := lineBase + int64(adjusted%lineRange)
+				d.FieldValueUint("address_advance", addressAdvance)
+				d.FieldValueSint("line_advance", lineAdvance)
+				s.address += addressAdvance
+				s.line += lineAdvance
+				row(d)
+			}
+		})
+	}
+}
+
+func decodeLine(d *decode.D, ss Sections, addrSize int) {
+	decodeUnits(d, "unit", func(d *decode.D, offsetSize int) {
+		uc := &unitContext{
+			ss:         ss,
+			endian:     d.Endian,
+			offsetSize: offsetSize,
+			addrSize:   addrSize,
+		}
+
+		uc.version = d.FieldU16("version")
+		if uc.version < 2 || uc.version > 5 {
+			d.Fatalf("unsupported version %d", uc.version)
+		}
+		if uc.version >= 5 {
+			uc.addrSize = int(d.FieldU8("address_size"))
+			d.FieldU8("segment_selector_size")
+		}
+		headerLength := d.FieldU("header_length", offsetSize*8)
+
+		var minInstLength uint64
+		var lineBase int64
+		var lineRange uint64
+		var opcodeBase uint64
+		var standardOpcodeLengths []uint64
+
+		d.FramedFn(int64(headerLength)*8, func(d *decode.D) {
+			minInstLength = d.FieldU8("minimum_instruction_length")
+			if uc.version >= 4 {
+				d.FieldU8("maximum_operations_per_instruction")
+			}
+			d.FieldU8("default_is_stmt")
+			lineBase = d.FieldS8("line_base")
+			lineRange = d.FieldU8("line_range")
+			if lineRange == 0 {
+				d.Fatalf("line_range is zero")
+			}
+			opcodeBase = d.FieldU8("opcode_base")
+			if opcodeBase == 0 {
+				d.Fatalf("opcode_base is zero")
+			}
+			d.FieldArray("standard_opcode_lengths", func(d *decode.D) {
+				for i := uint64(1); i < opcodeBase; i++ {
+					standardOpcodeLengths = append(standardOpcodeLengths, d.FieldU8("standard_opcode_length"))
+				}
+			})
+
+			if uc.version >= 5 {
+				directoryFormats := decodeLineEntryFormats(d, "directory_entry")
+				decodeLineEntries(d, uc, "directories", "directory", directoryFormats)
+				fileNameFormats := decodeLineEntryFormats(d, "file_name_entry")
+				decodeLineEntries(d, uc, "file_names", "file_name", fileNameFormats)
+			} else {
+				decodeLineProgramHeaderV2(d)
+			}
+			if d.BitsLeft() > 0 {
+				d.FieldRawLen("unknown", d.BitsLeft())
+			}
+		})
+
+		d.FieldArray("program", func(d *decode.D) {
+			decodeLineProgram(d, minInstLength, lineBase, lineRange, opcodeBase, standardOpcodeLengths)
+		})
+	})
+}
@@ -0,0 +1,445 @@
+package dwarf
+
+import (
+	"sort"
+
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+const (
+	rleEndOfList    = 0x00
+	rleBaseAddressx = 0x01
+	rleStartxEndx   = 0x02
+	rleStartxLength = 0x03
+	rleOffsetPair   = 0x04
+	rleBaseAddress  = 0x05
+	rleStartEnd     = 0x06
+	rleStartLength  = 0x07
+
+	lleEndOfList       = 0x00
+	lleBaseAddressx    = 0x01
+	lleStartxEndx      = 0x02
+	lleStartxLength    = 0x03
+	lleOffsetPair      = 0x04
+	lleDefaultLocation = 0x05
+	lleBaseAddress     = 0x06
+	lleStartEnd        = 0x07
+	lleStartLength     = 0x08
+	lleGNUViewPair     = 0x09
+)
+
+func assertAddrSize(d *decode.D, addrSize int) {
+	switch addrSize {
+	case 1, 2, 4, 8:
+	default:
+		d.Fatalf("unsupported address size %d", addrSize)
+	}
+}
+
+func decodeAddressSize(d *decode.D) int {
+	addrSize := int(d.FieldU8("address_size"))
+	assertAddrSize(d, addrSize)
+	return addrSize
+}
+
+// max address is used as base address selection marker in DWARF 2-4 lists
+func maxAddr(addrSize int) uint64 {
+	return ^uint64(0) >> (64 - uint(addrSize)*8)
+}
+
+func decodeExpression(d *decode.D, length uint64) {
+	// TODO: decode DW_OP expressions
+	if length > 0 {
+		d.FieldRawLen("expression", int64(length)*8)
+	}
+}
+
+func decodeAranges(d *decode.D) {
+	decodeUnits(d, "unit", func(d *decode.D, offsetSize int) {
+		d.FieldU16("version")
+		d.FieldU("debug_info_offset", offsetSize*8, scalar.UintHex)
+		addrSize := decodeAddressSize(d)
+		segmentSize := int(d.FieldU8("segment_selector_size"))
+
+		// tuples are aligned to tuple size relative to start of unit
+		unitLengthSize := 4
+		if offsetSize == 8 {
+			unitLengthSize = 12
+		}
+		tupleSize := segmentSize + addrSize*2
+		headerSize := unitLengthSize + 2 + offsetSize + 2
+		if padding := (tupleSize - headerSize%tupleSize) % tupleSize; padding > 0 {
+			d.FieldRawLen("padding", int64(padding)*8, d.BitBufIsZero())
+		}
+
+		d.FieldArray("tuples", func(d *decode.D) {
+			for d.BitsLeft() >= int64(tupleSize)*8 {
+				d.FieldStruct("tuple", func(d *decode.D) {
+					if segmentSize > 0 {
+						d.FieldU("segment", segmentSize*8)
+					}
+					d.FieldU("address", addrSize*8, scalar.UintHex)
+					d.FieldU("length", addrSize*8)
+				})
+			}
+		})
+		if d.BitsLeft() > 0 {
+			d.FieldRawLen("unknown", d.BitsLeft())
+		}
+	})
+}
+
+// decodeRanges decodes DWARF 2-4 address range lists
+func decodeRanges(d *decode.D, addrSize int) {
+	assertAddrSize(d, addrSize)
+	max := maxAddr(addrSize)
+
+	d.FieldArray("range_lists", func(d *decode.D) {
+		for d.BitsLeft() >= int64(addrSize)*8*2 {
+			d.FieldArray("range_list", func(d *decode.D) {
+				for d.BitsLeft() >= int64(addrSize)*8*2 {
+					var begin, end uint64
+					d.FieldStruct("entry", func(d *decode.D) {
+						begin = d.FieldU("begin", addrSize*8, scalar.UintHex)
+						end = d.FieldU("end", addrSize*8, scalar.UintHex)
+						if begin == max {
+							d.FieldValueStr("kind", "base_address")
+						}
+					})
+					if begin == 0 && end == 0 {
+						break
+					}
+				}
+			})
+		}
+	})
+	if d.BitsLeft() > 0 {
+		d.FieldRawLen("unknown", d.BitsLeft())
+	}
+}
+
+const atGNULocviews = 0x2137
+
+// attributes that can be location list pointers in DWARF 2-4
+var locationListAttributes = map[uint64]bool{
+	0x02: true, // location
+	0x19: true, // string_length
+	0x2a: true, // return_addr
+	0x38: true, // data_member_location
+	0x40: true, // frame_base
+	0x46: true, // segment
+	0x48: true, // static_link
+	0x4a: true, // use_location
+	0x4d: true, // vtable_elem_location
+}
+
+// readLocationListOffsets reads offsets to DWARF 2-4 location lists and GNU
+// location views used by debug info. Views are mapped to offset of the
+// location list that follows them.
+func readLocationListOffsets(ss Sections, endian decode.Endian) (map[uint64]bool, map[uint64]uint64) {
+	lists := map[uint64]bool{}
+	views := map[uint64]uint64{}
+
+	r := &byteReader{bs: ss["info"]}
+	for r.pos < len(r.bs) {
+		uc := &unitContext{ss: ss, endian: endian, offsetSize: 4}
+		length, err := r.uint(4, endian)
+		if err != nil {
+			break
+		}
+		if length == dwarf64Length {
+			uc.offsetSize = 8
+			if length, err = r.uint(8, endian); err != nil {
+				break
+			}
+		}
+		if length > uint64(len(r.bs)-r.pos) {
+			break
+		}
+		unitEnd := r.pos + int(length)
+		ur := &byteReader{bs: r.bs[:unitEnd], pos: r.pos}
+		r.pos = unitEnd
+
+		if uc.version, err = ur.uint(2, endian); err != nil || uc.version < 2 || uc.version > 4 {
+			continue
+		}
+		abbrevOffset, err := ur.uint(uc.offsetSize, endian)
+		if err != nil {
+			continue
+		}
+		addrSize, err := ur.u8()
+		if err != nil {
+			continue
+		}
+		uc.addrSize = int(addrSize)
+		abbrevs, err := readAbbrevs(ss["abbrev"], abbrevOffset)
+		if err != nil {
+			continue
+		}
+
+	dies:
+		for ur.pos < len(ur.bs) {
+			code, err := ur.uleb128()
+			if err != nil {
+				break
+			}
+			if code == 0 {
+				continue
+			}
+			a, ok := abbrevs[code]
+			if !ok {
+				break
+			}
+			var location, locviews uint64
+			var hasLocation, hasLocviews bool
+			for _, at := range a.attributes {
+				v, err := ur.formValue(uc, at.form)
+				if err != nil {
+					break dies
+				}
+				isOffset := at.form == formSecOffset ||
+					(uc.version < 4 && (at.form == formData4 || at.form == formData8))
+				switch {
+				case !isOffset:
+				case locationListAttributes[at.name]:
+					lists[v] = true
+					location, hasLocation = v, true
+				case at.name == atGNULocviews:
+					locviews, hasLocviews = v, true
+				}
+			}
+			if hasLocation && hasLocviews && locviews < location {
+				views[locviews] = location
+			}
+		}
+	}
+
+	return lists, views
+}
+
+func decodeLocList(d *decode.D, addrSize int) {
+	max := maxAddr(addrSize)
+	for d.BitsLeft() >= int64(addrSize)*8*2 {
+		var begin, end uint64
+		d.FieldStruct("entry", func(d *decode.D) {
+			begin = d.FieldU("begin", addrSize*8, scalar.UintHex)
+			end = d.FieldU("end", addrSize*8, scalar.UintHex)
+			switch {
+			case begin == 0 && end == 0:
+			case begin == max:
+				d.FieldValueStr("kind", "base_address")
+			default:
+				decodeExpression(d, d.FieldU16("expression_length"))
+			}
+		})
+		if begin == 0 && end == 0 {
+			break
+		}
+	}
+}
+
+// decodeLoc decodes DWARF 2-4 location lists. Lists are decoded at offsets
+// used by debug info as there can be GNU location views and padding between
+// them, if there is no debug info lists are assumed to be consecutive.
+func decodeLoc(d *decode.D, ss Sections, addrSize int) {
+	assertAddrSize(d, addrSize)
+
+	lists, views := readLocationListOffsets(ss, d.Endian)
+	if len(lists) == 0 {
+		d.FieldArray("location_lists", func(d *decode.D) {
+			for d.BitsLeft() >= int64(addrSize)*8*2 {
+				d.FieldArray("location_list", func(d *decode.D) { decodeLocList(d, addrSize) })
+			}
+		})
+		if d.BitsLeft() > 0 {
+			d.FieldRawLen("unknown", d.BitsLeft())
+		}
+		return
+	}
+
+	var offsets []uint64
+	for o := range lists {
+		offsets = append(offsets, o)
+	}
+	for o := range views {
+		offsets = append(offsets, o)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	start := d.Pos()
+	end := start + d.BitsLeft()
+	d.FieldArray("location_lists", func(d *decode.D) {
+		next := start
+		for _, o := range offsets {
+			pos := start + int64(o)*8
+			// skip shared or out of bounds lists
+			if pos < next || pos >= end {
+				continue
+			}
+			d.SeekAbs(pos)
+			if listOffset, ok := views[o]; ok {
+				listPos := mathex.Min(end, start+int64(listOffset)*8)
+				d.FieldArray("location_views", func(d *decode.D) {
+					for d.Pos() < listPos {
+						d.FieldStruct("view_pair", func(d *decode.D) {
+							d.FieldULEB128("begin")
+							d.FieldULEB128("end")
+						})
+					}
+				})
+			} else {
+				d.FieldArray("location_list", func(d *decode.D) { decodeLocList(d, addrSize) })
+			}
+			next = d.Pos()
+		}
+	})
+}
+
+// decodeListsHeader decodes DWARF 5 range and location lists header and
+// returns address size
+func decodeListsHeader(d *decode.D, offsetSize int) int {
+	d.FieldU16("version")
+	addrSize := decodeAddressSize(d)
+	d.FieldU8("segment_selector_size")
+	offsetEntryCount := d.FieldU32("offset_entry_count")
+	d.FieldArray("offsets", func(d *decode.D) {
+		for i := uint64(0); i < offsetEntryCount; i++ {
+			d.FieldU("offset", offsetSize*8, scalar.UintHex)
+		}
+	})
+	return addrSize
+}
+
+func decodeRngLists(d *decode.D) {
+	decodeUnits(d, "unit", func(d *decode.D, offsetSize int) {
+		addrSize := decodeListsHeader(d, offsetSize)
+
+		d.FieldArray("range_lists", func(d *decode.D) {
+			for !d.End() {
+				d.FieldArray("range_list", func(d *decode.D) {
+					for !d.End() {
+						var kind uint64
+						d.FieldStruct("entry", func(d *decode.D) {
+							kind = d.FieldU8("kind", rangeListEntryNames)
+							switch kind {
+							case rleEndOfList:
+							case rleBaseAddressx:
+								d.FieldULEB128("address_index")
+							case rleStartxEndx:
+								d.FieldULEB128("start_index")
+								d.FieldULEB128("end_index")
+							case rleStartxLength:
+								d.FieldULEB128("start_index")
+								d.FieldULEB128("length")
+							case rleOffsetPair:
+								d.FieldULEB128("start_offset", scalar.UintHex)
+								d.FieldULEB128("end_offset", scalar.UintHex)
+							case rleBaseAddress:
+								d.FieldU("address", addrSize*8, scalar.UintHex)
+							case rleStartEnd:
+								d.FieldU("start", addrSize*8, scalar.UintHex)
+								d.FieldU("end", addrSize*8, scalar.UintHex)
+							case rleStartLength:
+								d.FieldU("start", addrSize*8, scalar.UintHex)
+								d.FieldULEB128("length")
+							default:
+								d.Fatalf("unknown range list entry kind %d", kind)
+							}
+						})
+						if kind == rleEndOfList {
+							break
+						}
+					}
+				})
+			}
+		})
+	})
+}
+
+func decodeLocLists(d *decode.D) {
+	decodeUnits(d, "unit", func(d *decode.D, offsetSize int) {
+		addrSize := decodeListsHeader(d, offsetSize)
+
+		d.FieldArray("location_lists", func(d *decode.D) {
+			for !d.End() {
+				d.FieldArray("location_list", func(d *decode.D) {
+					for !d.End() {
+						var kind uint64
+						d.FieldStruct("entry", func(d *decode.D) {
+							kind = d.FieldU8("kind", locationListEntryNames)
+							switch kind {
+							case lleEndOfList:
+								return
+							case lleBaseAddressx:
+								d.FieldULEB128("address_index")
+								return
+							case lleStartxEndx:
+								d.FieldULEB128("start_index")
+								d.FieldULEB128("end_index")
+							case lleStartxLength:
+								d.FieldULEB128("start_index")
+								d.FieldULEB128("length")
+							case lleOffsetPair:
+								d.FieldULEB128("start_offset", scalar.UintHex)
+								d.FieldULEB128("end_offset", scalar.UintHex)
+							case lleDefaultLocation:
+							case lleBaseAddress:
+								d.FieldU("address", addrSize*8, scalar.UintHex)
+								return
+							case lleStartEnd:
+								d.FieldU("start", addrSize*8, scalar.UintHex)
+								d.FieldU("end", addrSize*8, scalar.UintHex)
+							case lleStartLength:
+								d.FieldU("start", addrSize*8, scalar.UintHex)
+								d.FieldULEB128("length")
+							case lleGNUViewPair:
+								d.FieldULEB128("start_view")
+								d.FieldULEB128("end_view")
+								return
+							default:
+								d.Fatalf("unknown location list entry kind %d", kind)
+							}
+							decodeExpression(d, d.FieldULEB128("expression_length"))
+						})
+						if kind == lleEndOfList {
+							break
+						}
+					}
+				})
+			}
+		})
+	})
+}
+
+func decodeStrOffsets(d *decode.D) {
+	decodeUnits(d, "unit", func(d *decode.D, offsetSize int) {
+		d.FieldU16("version")
+		d.FieldU16("padding")
+		d.FieldArray("offsets", func(d *decode.D) {
+			for d.BitsLeft() >= int64(offsetSize)*8 {
+				d.FieldU("offset", offsetSize*8, scalar.UintHex)
+			}
+		})
+		if d.BitsLeft() > 0 {
+			d.FieldRawLen("unknown", d.BitsLeft())
+		}
+	})
+}
+
+func decodeAddr(d *decode.D) {
+	decodeUnits(d, "unit", func(d *decode.D, offsetSize int) {
+		d.FieldU16("version")
+		addrSize := decodeAddressSize(d)
+		d.FieldU8("segment_selector_size")
+		d.FieldArray("addresses", func(d *decode.D) {
+			for d.BitsLeft() >= int64(addrSize)*8 {
+				d.FieldU("address", addrSize*8, scalar.UintHex)
+			}
+		})
+		if d.BitsLeft() > 0 {
+			d.FieldRawLen("unknown", d.BitsLeft())
+		}
+	})
+}
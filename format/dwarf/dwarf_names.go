@@ -0,0 +1,546 @@
+package dwarf
+
+import (
+	"github.com/wader/fq/pkg/scalar"
+)
+
+var unitTypeNames = scalar.UintMapSymStr{
+	0x01: "compile",
+	0x02: "type",
+	0x03: "partial",
+	0x04: "skeleton",
+	0x05: "split_compile",
+	0x06: "split_type",
+	0x80: "lo_user",
+	0xff: "hi_user",
+}
+
+var tagNames = scalar.UintMapSymStr{
+	0x01:   "array_type",
+	0x02:   "class_type",
+	0x03:   "entry_point",
+	0x04:   "enumeration_type",
+	0x05:   "formal_parameter",
+	0x08:   "imported_declaration",
+	0x0a:   "label",
+	0x0b:   "lexical_block",
+	0x0d:   "member",
+	0x0f:   "pointer_type",
+	0x10:   "reference_type",
+	0x11:   "compile_unit",
+	0x12:   "string_type",
+	0x13:   "structure_type",
+	0x15:   "subroutine_type",
+	0x16:   "typedef",
+	0x17:   "union_type",
+	0x18:   "unspecified_parameters",
+	0x19:   "variant",
+	0x1a:   "common_block",
+	0x1b:   "common_inclusion",
+	0x1c:   "inheritance",
+	0x1d:   "inlined_subroutine",
+	0x1e:   "module",
+	0x1f:   "ptr_to_member_type",
+	0x20:   "set_type",
+	0x21:   "subrange_type",
+	0x22:   "with_stmt",
+	0x23:   "access_declaration",
+	0x24:   "base_type",
+	0x25:   "catch_block",
+	0x26:   "const_type",
+	0x27:   "constant",
+	0x28:   "enumerator",
+	0x29:   "file_type",
+	0x2a:   "friend",
+	0x2b:   "namelist",
+	0x2c:   "namelist_item",
+	0x2d:   "packed_type",
+	0x2e:   "subprogram",
+	0x2f:   "template_type_parameter",
+	0x30:   "template_value_parameter",
+	0x31:   "thrown_type",
+	0x32:   "try_block",
+	0x33:   "variant_part",
+	0x34:   "variable",
+	0x35:   "volatile_type",
+	0x36:   "dwarf_procedure",
+	0x37:   "restrict_type",
+	0x38:   "interface_type",
+	0x39:   "namespace",
+	0x3a:   "imported_module",
+	0x3b:   "unspecified_type",
+	0x3c:   "partial_unit",
+	0x3d:   "imported_unit",
+	0x3f:   "condition",
+	0x40:   "shared_type",
+	0x41:   "type_unit",
+	0x42:   "rvalue_reference_type",
+	0x43:   "template_alias",
+	0x44:   "coarray_type",
+	0x45:   "generic_subrange",
+	0x46:   "dynamic_type",
+	0x47:   "atomic_type",
+	0x48:   "call_site",
+	0x49:   "call_site_parameter",
+	0x4a:   "skeleton_unit",
+	0x4b:   "immutable_type",
+	0x4081: "mips_loop",
+	0x4101: "format_label",
+	0x4102: "function_template",
+	0x4103: "class_template",
+	0x4106: "gnu_template_template_param",
+	0x4107: "gnu_template_parameter_pack",
+	0x4108: "gnu_formal_parameter_pack",
+	0x4109: "gnu_call_site",
+	0x410a: "gnu_call_site_parameter",
+}
+
+const (
+	atName              = 0x03
+	atLanguage          = 0x13
+	atInline            = 0x20
+	atAccessibility     = 0x32
+	atEncoding          = 0x3e
+	atVirtuality        = 0x4c
+	atStrOffsetsBase    = 0x72
+	atAddrBase          = 0x73
+	atGNUAddrBase       = 0x2133
+	atIdentifierCase    = 0x42
+	atCallingConvention = 0x36
+)
+
+var attributeNames = scalar.UintMapSymStr{
+	0x01:                "sibling",
+	0x02:                "location",
+	atName:              "name",
+	0x09:                "ordering",
+	0x0b:                "byte_size",
+	0x0c:                "bit_offset",
+	0x0d:                "bit_size",
+	0x10:                "stmt_list",
+	0x11:                "low_pc",
+	0x12:                "high_pc",
+	atLanguage:          "language",
+	0x15:                "discr",
+	0x16:                "discr_value",
+	0x17:                "visibility",
+	0x18:                "import",
+	0x19:                "string_length",
+	0x1a:                "common_reference",
+	0x1b:                "comp_dir",
+	0x1c:                "const_value",
+	0x1d:                "containing_type",
+	0x1e:                "default_value",
+	atInline:            "inline",
+	0x21:                "is_optional",
+	0x22:                "lower_bound",
+	0x25:                "producer",
+	0x27:                "prototyped",
+	0x2a:                "return_addr",
+	0x2c:                "start_scope",
+	0x2e:                "bit_stride",
+	0x2f:                "upper_bound",
+	0x31:                "abstract_origin",
+	atAccessibility:     "accessibility",
+	0x33:                "address_class",
+	0x34:                "artificial",
+	0x35:                "base_types",
+	atCallingConvention: "calling_convention",
+	0x37:                "count",
+	0x38:                "data_member_location",
+	0x39:                "decl_column",
+	0x3a:                "decl_file",
+	0x3b:                "decl_line",
+	0x3c:                "declaration",
+	0x3d:                "discr_list",
+	atEncoding:          "encoding",
+	0x3f:                "external",
+	0x40:                "frame_base",
+	0x41:                "friend",
+	atIdentifierCase:    "identifier_case",
+	0x43:                "macro_info",
+	0x44:                "namelist_item",
+	0x45:                "priority",
+	0x46:                "segment",
+	0x47:                "specification",
+	0x48:                "static_link",
+	0x49:                "type",
+	0x4a:                "use_location",
+	0x4b:                "variable_parameter",
+	atVirtuality:        "virtuality",
+	0x4d:                "vtable_elem_location",
+	0x4e:                "allocated",
+	0x4f:                "associated",
+	0x50:                "data_location",
+	0x51:                "byte_stride",
+	0x52:                "entry_pc",
+	0x53:                "use_utf8",
+	0x54:                "extension",
+	0x55:                "ranges",
+	0x56:                "trampoline",
+	0x57:                "call_column",
+	0x58:                "call_file",
+	0x59:                "call_line",
+	0x5a:                "description",
+	0x5b:                "binary_scale",
+	0x5c:                "decimal_scale",
+	0x5d:                "small",
+	0x5e:                "decimal_sign",
+	0x5f:                "digit_count",
+	0x60:                "picture_string",
+	0x61:                "mutable",
+	0x62:                "threads_scaled",
+	0x63:                "explicit",
+	0x64:                "object_pointer",
+	0x65:                "endianity",
+	0x66:                "elemental",
+	0x67:                "pure",
+	0x68:                "recursive",
+	0x69:                "signature",
+	0x6a:                "main_subprogram",
+	0x6b:                "data_bit_offset",
+	0x6c:                "const_expr",
+	0x6d:                "enum_class",
+	0x6e:                "linkage_name",
+	0x6f:                "string_length_bit_size",
+	0x70:                "string_length_byte_size",
+	0x71:                "rank",
+	atStrOffsetsBase:    "str_offsets_base",
+	atAddrBase:          "addr_base",
+	0x74:                "rnglists_base",
+	0x76:                "dwo_name",
+	0x77:                "reference",
+	0x78:                "rvalue_reference",
+	0x79:                "macros",
+	0x7a:                "call_all_calls",
+	0x7b:                "call_all_source_calls",
+	0x7c:                "call_all_tail_calls",
+	0x7d:                "call_return_pc",
+	0x7e:                "call_value",
+	0x7f:                "call_origin",
+	0x80:                "call_parameter",
+	0x81:                "call_pc",
+	0x82:                "call_tail_call",
+	0x83:                "call_target",
+	0x84:                "call_target_clobbered",
+	0x85:                "call_data_location",
+	0x86:                "call_data_value",
+	0x87:                "noreturn",
+	0x88:                "alignment",
+	0x89:                "export_symbols",
+	0x8a:                "deleted",
+	0x8b:                "defaulted",
+	0x8c:                "loclists_base",
+	0x2007:              "mips_linkage_name",
+	0x2101:              "sf_names",
+	0x2102:              "src_info",
+	0x2103:              "mac_info",
+	0x2104:              "src_coords",
+	0x2105:              "body_begin",
+	0x2106:              "body_end",
+	0x2107:              "gnu_vector",
+	0x2110:              "gnu_template_name",
+	0x2111:              "gnu_call_site_value",
+	0x2112:              "gnu_call_site_data_value",
+	0x2113:              "gnu_call_site_target",
+	0x2114:              "gnu_call_site_target_clobbered",
+	0x2115:              "gnu_tail_call",
+	0x2116:              "gnu_all_tail_call_sites",
+	0x2117:              "gnu_all_call_sites",
+	0x2118:              "gnu_all_source_call_sites",
+	0x2119:              "gnu_macros",
+	0x211a:              "gnu_deleted",
+	0x2130:              "gnu_dwo_name",
+	0x2131:              "gnu_dwo_id",
+	0x2132:              "gnu_ranges_base",
+	atGNUAddrBase:       "gnu_addr_base",
+	0x2134:              "gnu_pubnames",
+	0x2135:              "gnu_pubtypes",
+	0x2136:              "gnu_discriminator",
+	0x2137:              "gnu_locviews",
+	0x2138:              "gnu_entry_view",
+	0x3e00:              "llvm_include_path",
+	0x3e01:              "llvm_config_macros",
+	0x3e02:              "llvm_sysroot",
+	0x3e03:              "llvm_tag_offset",
+	0x3fe1:              "apple_optimized",
+	0x3fe2:              "apple_flags",
+	0x3fe3:              "apple_isa",
+	0x3fe4:              "apple_block",
+	0x3fe5:              "apple_major_runtime_vers",
+	0x3fe6:              "apple_runtime_class",
+	0x3fe7:              "apple_omit_frame_ptr",
+}
+
+const (
+	formAddr          = 0x01
+	formBlock2        = 0x03
+	formBlock4        = 0x04
+	formData2         = 0x05
+	formData4         = 0x06
+	formData8         = 0x07
+	formString        = 0x08
+	formBlock         = 0x09
+	formBlock1        = 0x0a
+	formData1         = 0x0b
+	formFlag          = 0x0c
+	formSdata         = 0x0d
+	formStrp          = 0x0e
+	formUdata         = 0x0f
+	formRefAddr       = 0x10
+	formRef1          = 0x11
+	formRef2          = 0x12
+	formRef4          = 0x13
+	formRef8          = 0x14
+	formRefUdata      = 0x15
+	formIndirect      = 0x16
+	formSecOffset     = 0x17
+	formExprloc       = 0x18
+	formFlagPresent   = 0x19
+	formStrx          = 0x1a
+	formAddrx         = 0x1b
+	formRefSup4       = 0x1c
+	formStrpSup       = 0x1d
+	formData16        = 0x1e
+	formLineStrp      = 0x1f
+	formRefSig8       = 0x20
+	formImplicitConst = 0x21
+	formLoclistx      = 0x22
+	formRnglistx      = 0x23
+	formRefSup8       = 0x24
+	formStrx1         = 0x25
+	formStrx2         = 0x26
+	formStrx3         = 0x27
+	formStrx4         = 0x28
+	formAddrx1        = 0x29
+	formAddrx2        = 0x2a
+	formAddrx3        = 0x2b
+	formAddrx4        = 0x2c
+	formGNUAddrIndex  = 0x1f01
+	formGNUStrIndex   = 0x1f02
+	formGNURefAlt     = 0x1f20
+	formGNUStrpAlt    = 0x1f21
+)
+
+var formNames = scalar.UintMapSymStr{
+	formAddr:          "addr",
+	formBlock2:        "block2",
+	formBlock4:        "block4",
+	formData2:         "data2",
+	formData4:         "data4",
+	formData8:         "data8",
+	formString:        "string",
+	formBlock:         "block",
+	formBlock1:        "block1",
+	formData1:         "data1",
+	formFlag:          "flag",
+	formSdata:         "sdata",
+	formStrp:          "strp",
+	formUdata:         "udata",
+	formRefAddr:       "ref_addr",
+	formRef1:          "ref1",
+	formRef2:          "ref2",
+	formRef4:          "ref4",
+	formRef8:          "ref8",
+	formRefUdata:      "ref_udata",
+	formIndirect:      "indirect",
+	formSecOffset:     "sec_offset",
+	formExprloc:       "exprloc",
+	formFlagPresent:   "flag_present",
+	formStrx:          "strx",
+	formAddrx:         "addrx",
+	formRefSup4:       "ref_sup4",
+	formStrpSup:       "strp_sup",
+	formData16:        "data16",
+	formLineStrp:      "line_strp",
+	formRefSig8:       "ref_sig8",
+	formImplicitConst: "implicit_const",
+	formLoclistx:      "loclistx",
+	formRnglistx:      "rnglistx",
+	formRefSup8:       "ref_sup8",
+	formStrx1:         "strx1",
+	formStrx2:         "strx2",
+	formStrx3:         "strx3",
+	formStrx4:         "strx4",
+	formAddrx1:        "addrx1",
+	formAddrx2:        "addrx2",
+	formAddrx3:        "addrx3",
+	formAddrx4:        "addrx4",
+	formGNUAddrIndex:  "gnu_addr_index",
+	formGNUStrIndex:   "gnu_str_index",
+	formGNURefAlt:     "gnu_ref_alt",
+	formGNUStrpAlt:    "gnu_strp_alt",
+}
+
+var languageNames = scalar.UintMapSymStr{
+	0x01:   "c89",
+	0x02:   "c",
+	0x03:   "ada83",
+	0x04:   "c_plus_plus",
+	0x05:   "cobol74",
+	0x06:   "cobol85",
+	0x07:   "fortran77",
+	0x08:   "fortran90",
+	0x09:   "pascal83",
+	0x0a:   "modula2",
+	0x0b:   "java",
+	0x0c:   "c99",
+	0x0d:   "ada95",
+	0x0e:   "fortran95",
+	0x0f:   "pli",
+	0x10:   "objc",
+	0x11:   "objc_plus_plus",
+	0x12:   "upc",
+	0x13:   "d",
+	0x14:   "python",
+	0x15:   "opencl",
+	0x16:   "go",
+	0x17:   "modula3",
+	0x18:   "haskell",
+	0x19:   "c_plus_plus_03",
+	0x1a:   "c_plus_plus_11",
+	0x1b:   "ocaml",
+	0x1c:   "rust",
+	0x1d:   "c11",
+	0x1e:   "swift",
+	0x1f:   "julia",
+	0x20:   "dylan",
+	0x21:   "c_plus_plus_14",
+	0x22:   "fortran03",
+	0x23:   "fortran08",
+	0x24:   "renderscript",
+	0x25:   "bliss",
+	0x26:   "kotlin",
+	0x27:   "zig",
+	0x28:   "crystal",
+	0x29:   "c_plus_plus_17",
+	0x2a:   "c_plus_plus_20",
+	0x2b:   "c17",
+	0x2c:   "fortran18",
+	0x2d:   "ada2005",
+	0x2e:   "ada2012",
+	0x8001: "mips_assembler",
+}
+
+var encodingNames = scalar.UintMapSymStr{
+	0x01: "address",
+	0x02: "boolean",
+	0x03: "complex_float",
+	0x04: "float",
+	0x05: "signed",
+	0x06: "signed_char",
+	0x07: "unsigned",
+	0x08: "unsigned_char",
+	0x09: "imaginary_float",
+	0x0a: "packed_decimal",
+	0x0b: "numeric_string",
+	0x0c: "edited",
+	0x0d: "signed_fixed",
+	0x0e: "unsigned_fixed",
+	0x0f: "decimal_float",
+	0x10: "utf",
+	0x11: "ucs",
+	0x12: "ascii",
+}
+
+var inlineNames = scalar.UintMapSymStr{
+	0x00: "not_inlined",
+	0x01: "inlined",
+	0x02: "declared_not_inlined",
+	0x03: "declared_inlined",
+}
+
+var accessibilityNames = scalar.UintMapSymStr{
+	0x01: "public",
+	0x02: "protected",
+	0x03: "private",
+}
+
+var virtualityNames = scalar.UintMapSymStr{
+	0x00: "none",
+	0x01: "virtual",
+	0x02: "pure_virtual",
+}
+
+var identifierCaseNames = scalar.UintMapSymStr{
+	0x00: "case_sensitive",
+	0x01: "up_case",
+	0x02: "down_case",
+	0x03: "case_insensitive",
+}
+
+var callingConventionNames = scalar.UintMapSymStr{
+	0x01: "normal",
+	0x02: "program",
+	0x03: "nocall",
+	0x04: "pass_by_reference",
+	0x05: "pass_by_value",
+}
+
+// constant attribute values with known meaning
+var attributeValueNames = map[uint64]scalar.UintMapSymStr{
+	atLanguage:          languageNames,
+	atEncoding:          encodingNames,
+	atInline:            inlineNames,
+	atAccessibility:     accessibilityNames,
+	atVirtuality:        virtualityNames,
+	atIdentifierCase:    identifierCaseNames,
+	atCallingConvention: callingConventionNames,
+}
+
+var lineContentTypeNames = scalar.UintMapSymStr{
+	0x01:   "path",
+	0x02:   "directory_index",
+	0x03:   "timestamp",
+	0x04:   "size",
+	0x05:   "md5",
+	0x2001: "llvm_source",
+}
+
+var lineStandardOpcodeNames = scalar.UintMapSymStr{
+	0x01: "copy",
+	0x02: "advance_pc",
+	0x03: "advance_line",
+	0x04: "set_file",
+	0x05: "set_column",
+	0x06: "negate_stmt",
+	0x07: "set_basic_block",
+	0x08: "const_add_pc",
+	0x09: "fixed_advance_pc",
+	0x0a: "set_prologue_end",
+	0x0b: "set_epilogue_begin",
+	0x0c: "set_isa",
+}
+
+var lineExtendedOpcodeNames = scalar.UintMapSymStr{
+	0x01: "end_sequence",
+	0x02: "set_address",
+	0x03: "define_file",
+	0x04: "set_discriminator",
+	0x80: "lo_user",
+	0xff: "hi_user",
+}
+
+var rangeListEntryNames = scalar.UintMapSymStr{
+	0x00: "end_of_list",
+	0x01: "base_addressx",
+	0x02: "startx_endx",
+	0x03: "startx_length",
+	0x04: "offset_pair",
+	0x05: "base_address",
+	0x06: "start_end",
+	0x07: "start_length",
+}
+
+var locationListEntryNames = scalar.UintMapSymStr{
+	0x00: "end_of_list",
+	0x01: "base_addressx",
+	0x02: "startx_endx",
+	0x03: "startx_length",
+	0x04: "offset_pair",
+	0x05: "default_location",
+	0x06: "base_address",
+	0x07: "start_end",
+	0x08: "start_length",
+	0x09: "gnu_view_pair",
+}
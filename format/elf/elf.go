@@ -5,23 +5,35 @@ package elf
 // https://github.com/torvalds/linux/blob/master/include/uapi/linux/elf.h
 // https://sourceware.org/git/?p=binutils-gdb.git;a=blob;f=include/elf/external.h;hb=HEAD
 
-// TODO: dwarf
-
 import (
 	"strings"
 
 	"github.com/wader/fq/format"
+	"github.com/wader/fq/format/dwarf"
+	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
 	"github.com/wader/fq/pkg/scalar"
 )
 
+var arm64Format decode.Group
+var riscv64Format decode.Group
+var x86_64Format decode.Group
+
 func init() {
 	interp.RegisterFormat(decode.Format{
 		Name:        format.ELF,
 		Description: "Executable and Linkable Format",
 		Groups:      []string{format.PROBE},
 		DecodeFn:    elfDecode,
+		DefaultInArg: format.ElfIn{
+			Disassemble: false,
+		},
+		Dependencies: []decode.Dependency{
+			{Names: []string{format.ARM64}, Group: &arm64Format},
+			{Names: []string{format.RISCV64}, Group: &riscv64Format},
+			{Names: []string{format.X86_64}, Group: &x86_64Format},
+		},
 	})
 }
 
@@ -82,15 +94,18 @@ var typeNames = scalar.UintRangeToScalar{
 }
 
 const (
+	EM_386    = 0x03
+	EM_ARM    = 0x28
 	EM_X86_64 = 0x3e
 	EM_ARM64  = 0xb7
+	EM_RISCV  = 0xf3
 )
 
 var machineNames = scalar.UintMap{
 	0x00:      {Description: "No specific instruction set"},
 	0x01:      {Sym: "we_32100", Description: "AT&T WE 32100"},
 	0x02:      {Sym: "sparc", Description: "SPARC"},
-	0x03:      {Sym: "x86", Description: "x86"},
+	EM_386:    {Sym: "x86", Description: "x86"},
 	0x04:      {Sym: "m68k", Description: "Motorola 68000 (M68k)"},
 	0x05:      {Sym: "m88k", Description: "Motorola 88000 (M88k)"},
 	0x06:      {Sym: "intel_mcu", Description: "Intel MCU"},
@@ -109,7 +124,7 @@ var machineNames = scalar.UintMap{
 	0x25:      {Sym: "fr20", Description: "Fujitsu FR20"},
 	0x26:      {Sym: "trw_rh_32", Description: "TRW RH-32"},
 	0x27:      {Sym: "motorola_rce", Description: "Motorola RCE"},
-	0x28:      {Sym: "arm", Description: "ARM (up to ARMv7/Aarch32)"},
+	EM_ARM:    {Sym: "arm", Description: "ARM (up to ARMv7/Aarch32)"},
 	0x29:      {Sym: "alpha", Description: "Digital Alpha"},
 	0x2a:      {Sym: "superh", Description: "SuperH"},
 	0x2b:      {Sym: "sparc_v9", Description: "SPARC Version 9"},
@@ -134,7 +149,7 @@ var machineNames = scalar.UintMap{
 	EM_X86_64: {Sym: "x86_64", Description: "AMD x86-64"},
 	0x8c:      {Sym: "tms320C6000", Description: "TMS320C6000 Family"},
 	EM_ARM64:  {Sym: "arm64", Description: "ARM 64-bits (ARMv8/Aarch64)"},
-	0xf3:      {Sym: "risc_v", Description: "RISC-V"},
+	EM_RISCV:  {Sym: "risc_v", Description: "RISC-V"},
 	0xf7:      {Sym: "bpf", Description: "Berkeley Packet Filter"},
 	0x101:     {Sym: "wdc_65C816", Description: "WDC 65C816"},
 }
@@ -148,6 +163,8 @@ const (
 	PT_SHLIB   = 5
 	PT_PHDR    = 6
 	PT_TLS     = 7
+
+	PT_GNU_PROPERTY = 0x6474e553
 )
 
 var phTypeNames = scalar.UintRangeToScalar{
@@ -162,6 +179,7 @@ var phTypeNames = scalar.UintRangeToScalar{
 	{Range: [2]uint64{0x6474e550, 0x6474e550}, S: scalar.Uint{Sym: "gnu_eh_frame", Description: "GNU frame unwind information"}},
 	{Range: [2]uint64{0x6474e551, 0x6474e551}, S: scalar.Uint{Sym: "gnu_stack", Description: "GNU stack permission"}},
 	{Range: [2]uint64{0x6474e552, 0x6474e552}, S: scalar.Uint{Sym: "gnu_relro", Description: "GNU read-only after relocation"}},
+	{Range: [2]uint64{PT_GNU_PROPERTY, PT_GNU_PROPERTY}, S: scalar.Uint{Sym: "gnu_property", Description: "GNU property notes"}},
 	{Range: [2]uint64{0x60000000, 0x6fffffff}, S: scalar.Uint{Sym: "os", Description: "Operating system-specific"}},
 	{Range: [2]uint64{0x70000000, 0x7fffffff}, S: scalar.Uint{Sym: "proc", Description: "Processor-specific"}},
 }
@@ -303,6 +321,102 @@ var coreNoteNames = scalar.UintMap{
 	NT_LOONGARCH_LBT:        {Sym: "loongarch_lbt", Description: "LoongArch Loongson Binary Translation registers"},
 }
 
+const (
+	NT_GNU_ABI_TAG         = 1
+	NT_GNU_HWCAP           = 2
+	NT_GNU_BUILD_ID        = 3
+	NT_GNU_GOLD_VERSION    = 4
+	NT_GNU_PROPERTY_TYPE_0 = 5
+)
+
+var gnuNoteNames = scalar.UintMap{
+	NT_GNU_ABI_TAG:         {Sym: "gnu_abi_tag", Description: "ABI version tag"},
+	NT_GNU_HWCAP:           {Sym: "gnu_hwcap", Description: "Hardware capabilities"},
+	NT_GNU_BUILD_ID:        {Sym: "gnu_build_id", Description: "Unique build ID bitstring"},
+	NT_GNU_GOLD_VERSION:    {Sym: "gnu_gold_version", Description: "Gold linker version"},
+	NT_GNU_PROPERTY_TYPE_0: {Sym: "gnu_property_type_0", Description: "Program properties"},
+	0x100:                  {Sym: "gnu_build_attribute_open"},
+	0x101:                  {Sym: "gnu_build_attribute_func"},
+}
+
+const (
+	NT_GO_BUILD_ID = 4
+)
+
+var goNoteNames = scalar.UintMap{
+	NT_GO_BUILD_ID: {Sym: "go_build_id", Description: "Go build ID"},
+}
+
+var gnuABITagOSNames = scalar.UintMapSymStr{
+	0: "linux",
+	1: "hurd",
+	2: "solaris",
+	3: "freebsd",
+}
+
+const (
+	GNU_PROPERTY_STACK_SIZE            = 1
+	GNU_PROPERTY_NO_COPY_ON_PROTECTED  = 2
+	GNU_PROPERTY_1_NEEDED              = 0xb0008000
+	GNU_PROPERTY_AARCH64_FEATURE_1_AND = 0xc0000000
+	GNU_PROPERTY_X86_FEATURE_1_AND     = 0xc0000002
+	GNU_PROPERTY_X86_FEATURE_2_NEEDED  = 0xc0008001
+	GNU_PROPERTY_X86_ISA_1_NEEDED      = 0xc0008002
+	GNU_PROPERTY_X86_FEATURE_2_USED    = 0xc0010001
+	GNU_PROPERTY_X86_ISA_1_USED        = 0xc0010002
+)
+
+var gnuPropertyTypeNames = scalar.UintMap{
+	GNU_PROPERTY_STACK_SIZE:           {Sym: "stack_size", Description: "Stack size"},
+	GNU_PROPERTY_NO_COPY_ON_PROTECTED: {Sym: "no_copy_on_protected", Description: "No copy relocation on protected data symbol"},
+	GNU_PROPERTY_1_NEEDED:             {Sym: "1_needed", Description: "Features needed by the object"},
+}
+
+// processor specific property types overlap so they depend on machine
+var gnuPropertyMachineTypeNames = map[uint64]scalar.UintMap{
+	EM_386: {
+		GNU_PROPERTY_X86_FEATURE_1_AND:    {Sym: "x86_feature_1_and", Description: "x86 features"},
+		GNU_PROPERTY_X86_FEATURE_2_NEEDED: {Sym: "x86_feature_2_needed", Description: "x86 features needed"},
+		GNU_PROPERTY_X86_ISA_1_NEEDED:     {Sym: "x86_isa_1_needed", Description: "x86 ISA needed"},
+		GNU_PROPERTY_X86_FEATURE_2_USED:   {Sym: "x86_feature_2_used", Description: "x86 features used"},
+		GNU_PROPERTY_X86_ISA_1_USED:       {Sym: "x86_isa_1_used", Description: "x86 ISA used"},
+	},
+	EM_X86_64: {
+		GNU_PROPERTY_X86_FEATURE_1_AND:    {Sym: "x86_feature_1_and", Description: "x86 features"},
+		GNU_PROPERTY_X86_FEATURE_2_NEEDED: {Sym: "x86_feature_2_needed", Description: "x86 features needed"},
+		GNU_PROPERTY_X86_ISA_1_NEEDED:     {Sym: "x86_isa_1_needed", Description: "x86 ISA needed"},
+		GNU_PROPERTY_X86_FEATURE_2_USED:   {Sym: "x86_feature_2_used", Description: "x86 features used"},
+		GNU_PROPERTY_X86_ISA_1_USED:       {Sym: "x86_isa_1_used", Description: "x86 ISA used"},
+	},
+	EM_ARM64: {
+		GNU_PROPERTY_AARCH64_FEATURE_1_AND: {Sym: "aarch64_feature_1_and", Description: "AArch64 features"},
+	},
+}
+
+// bit index to flag name
+type propertyFlagNames []string
+
+func (m propertyFlagNames) MapUint(s scalar.Uint) (scalar.Uint, error) {
+	var names []string
+	for i, n := range m {
+		if s.Actual&(1<<i) != 0 {
+			names = append(names, n)
+		}
+	}
+	s.Description = strings.Join(names, ",")
+	return s, nil
+}
+
+var gnuPropertyFlagNames = map[uint64]propertyFlagNames{
+	GNU_PROPERTY_1_NEEDED:              {"indirect_extern_access"},
+	GNU_PROPERTY_AARCH64_FEATURE_1_AND: {"bti", "pac", "gcs"},
+	GNU_PROPERTY_X86_FEATURE_1_AND:     {"ibt", "shstk", "lam_u48", "lam_u57"},
+	GNU_PROPERTY_X86_FEATURE_2_NEEDED:  {"x86", "x87", "mmx", "xmm", "ymm", "zmm", "fxsr", "xsave", "xsaveopt", "xsavec", "tmm", "mask"},
+	GNU_PROPERTY_X86_FEATURE_2_USED:    {"x86", "x87", "mmx", "xmm", "ymm", "zmm", "fxsr", "xsave", "xsaveopt", "xsavec", "tmm", "mask"},
+	GNU_PROPERTY_X86_ISA_1_NEEDED:      {"x86_64_baseline", "x86_64_v2", "x86_64_v3", "x86_64_v4"},
+	GNU_PROPERTY_X86_ISA_1_USED:        {"x86_64_baseline", "x86_64_v2", "x86_64_v3", "x86_64_v4"},
+}
+
 const (
 	SHT_NULL          = 0x0
 	SHT_PROGBITS      = 0x1
@@ -322,6 +436,9 @@ const (
 	SHT_GROUP         = 0x11
 	SHT_SYMTAB_SHNDX  = 0x12
 	SHT_GNU_HASH      = 0x6ffffff6
+	SHT_GNU_VERDEF    = 0x6ffffffd
+	SHT_GNU_VERNEED   = 0x6ffffffe
+	SHT_GNU_VERSYM    = 0x6fffffff
 )
 
 var sectionHeaderTypeMap = scalar.UintMap{
@@ -343,6 +460,24 @@ var sectionHeaderTypeMap = scalar.UintMap{
 	SHT_GROUP:         {Sym: "group", Description: "Section group"},
 	SHT_SYMTAB_SHNDX:  {Sym: "symtab_shndx", Description: ""},
 	SHT_GNU_HASH:      {Sym: "gnu_hash", Description: "GNU symbol hash table"},
+	SHT_GNU_VERDEF:    {Sym: "gnu_verdef", Description: "GNU symbol version definitions"},
+	SHT_GNU_VERNEED:   {Sym: "gnu_verneed", Description: "GNU symbol version requirements"},
+	SHT_GNU_VERSYM:    {Sym: "gnu_versym", Description: "GNU symbol version table"},
+}
+
+const (
+	SHF_EXECINSTR  = 0x4
+	SHF_COMPRESSED = 0x800
+)
+
+const (
+	ELFCOMPRESS_ZLIB = 1
+	ELFCOMPRESS_ZSTD = 2
+)
+
+var compressionTypeNames = scalar.UintMapSymStr{
+	ELFCOMPRESS_ZLIB: "zlib",
+	ELFCOMPRESS_ZSTD: "zstd",
 }
 
 const (
@@ -473,6 +608,8 @@ var symbolTableBindingMap = scalar.UintMapSymStr{
 	15: "proc",
 }
 
+const STT_SECTION = 3
+
 var symbolTableTypeMap = scalar.UintMapSymStr{
 	0:  "notype",
 	1:  "object",
@@ -495,6 +632,99 @@ var symbolTableVisibilityMap = scalar.UintMapSymStr{
 	3: "protected",
 }
 
+func elfDecodeGNUProperties(d *decode.D, ec elfContext) {
+	typeNames := gnuPropertyMachineTypeNames[uint64(ec.machine)]
+	d.FieldArray("properties", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("property", func(d *decode.D) {
+				prType := d.FieldU32("pr_type", gnuPropertyTypeNames, typeNames, scalar.UintHex)
+				prDataSz := d.FieldU32("pr_datasz")
+				d.FramedFn(int64(prDataSz)*8, func(d *decode.D) {
+					_, isGeneric := gnuPropertyTypeNames[prType]
+					_, isMachine := typeNames[prType]
+					flagNames, isFlags := gnuPropertyFlagNames[prType]
+					switch {
+					case prType == GNU_PROPERTY_STACK_SIZE && (prDataSz == 4 || prDataSz == 8):
+						d.FieldU("pr_data", int(prDataSz)*8)
+					case (isGeneric || isMachine) && isFlags && prDataSz == 4:
+						d.FieldU32("pr_data", flagNames, scalar.UintHex)
+					default:
+						d.FieldRawLen("pr_data", d.BitsLeft())
+					}
+				})
+				// properties are aligned to pointer size
+				padding := d.AlignBits(ec.archBits)
+				if padding != 0 && padding <= int(d.BitsLeft()) {
+					d.FieldRawLen("pr_padding", int64(padding), d.BitBufIsZero())
+				}
+			})
+		}
+	})
+}
+
+func elfDecodeNoteDesc(d *decode.D, ec elfContext, name string, typ uint64) {
+	switch {
+	case name == "GNU" && typ == NT_GNU_ABI_TAG:
+		d.FieldStruct("abi_tag", func(d *decode.D) {
+			d.FieldU32("os", gnuABITagOSNames)
+			d.FieldU32("major")
+			d.FieldU32("minor")
+			d.FieldU32("subminor")
+		})
+	case name == "GNU" && typ == NT_GNU_BUILD_ID:
+		d.FieldRawLen("build_id", d.BitsLeft())
+	case name == "GNU" && typ == NT_GNU_GOLD_VERSION:
+		d.FieldUTF8NullFixedLen("gold_version", int(d.BitsLeft()/8))
+	case name == "GNU" && typ == NT_GNU_PROPERTY_TYPE_0:
+		elfDecodeGNUProperties(d, ec)
+	case name == "Go" && typ == NT_GO_BUILD_ID:
+		d.FieldUTF8NullFixedLen("build_id", int(d.BitsLeft()/8))
+	default:
+		d.FieldRawLen("desc", d.BitsLeft())
+	}
+}
+
+func elfDecodeNotes(d *decode.D, ec elfContext) {
+	d.FieldArray("notes", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("note", func(d *decode.D) {
+				// elf manpage says this is 32 or 64 bit but it seems it is always 32
+				// and that is also what readelf external.h says
+				nameSz := d.FieldU32("n_namesz")
+				descSz := d.FieldU32("n_descsz")
+				// type meaning depends on name so peek it
+				var name string
+				d.RangeFn(d.Pos()+32, int64(nameSz)*8, func(d *decode.D) {
+					name = d.UTF8NullFixedLen(int(nameSz))
+				})
+				var typ uint64
+				switch {
+				case ec.typ == ET_CORE:
+					typ = d.FieldU32("n_type", coreNoteNames, scalar.UintHex)
+				case name == "GNU":
+					typ = d.FieldU32("n_type", gnuNoteNames, scalar.UintHex)
+				case name == "Go":
+					typ = d.FieldU32("n_type", goNoteNames, scalar.UintHex)
+				default:
+					typ = d.FieldU32("n_type", scalar.UintHex)
+				}
+				d.FieldUTF8NullFixedLen("name", int(nameSz))
+				nameAlign := d.AlignBits(4 * 8)
+				if nameAlign != 0 {
+					d.FieldRawLen("name_align", int64(nameAlign))
+				}
+				d.FramedFn(int64(descSz)*8, func(d *decode.D) {
+					elfDecodeNoteDesc(d, ec, name, typ)
+				})
+				descAlign := d.AlignBits(4 * 8)
+				if descAlign != 0 {
+					d.FieldRawLen("desc_align", int64(descAlign))
+				}
+			})
+		}
+	})
+}
+
 func strIndexNull(idx int, s string) string {
 	if idx > len(s) {
 		return ""
@@ -624,7 +854,9 @@ func elfReadDynamicTags(d *decode.D, ec *elfContext) dynamicContext {
 
 type symbol struct {
 	name  uint64
+	typ   uint64
 	value uint64
+	shndx uint64
 }
 
 func elfReadSymbolTable(d *decode.D, ec *elfContext, sh sectionHeader) []symbol {
@@ -632,33 +864,58 @@ func elfReadSymbolTable(d *decode.D, ec *elfContext, sh sectionHeader) []symbol
 
 	for i := 0; i < int(sh.size/sh.entSize); i++ {
 		var name uint64
+		var typ uint64
 		var value uint64
+		var shndx uint64
 		switch ec.archBits {
 		case 32:
 			name = d.U32()  // name
 			value = d.U32() // value
 			d.U32()         // size
 			d.U4()          // bind
-			d.U4()          // type
+			typ = d.U4()    // type
 			d.U6()          // other_unused
 			d.U2()          // visibility
-			d.U16()         // shndx
+			shndx = d.U16() // shndx
 		case 64:
 			name = d.U32()  // name
 			d.U4()          // bind
-			d.U4()          // type
+			typ = d.U4()    // type
 			d.U6()          // other_unused
 			d.U2()          // visibility
-			d.U16()         // shndx
+			shndx = d.U16() // shndx
 			value = d.U64() // value
 			d.U64()         // size
 		}
-		ss = append(ss, symbol{name: name, value: value})
+		ss = append(ss, symbol{name: name, typ: typ, value: value, shndx: shndx})
 	}
 
 	return ss
 }
 
+type relocation struct {
+	offset uint64
+	symbol uint64
+}
+
+func elfReadRelocations(d *decode.D, ec *elfContext, sh sectionHeader) []relocation {
+	var rs []relocation
+
+	for i := 0; i < int(sh.size/sh.entSize); i++ {
+		d.SeekAbs(sh.offset + int64(i)*sh.entSize)
+		offset := d.U(ec.archBits)
+		info := d.U(ec.archBits)
+		// info is sym<<32|type for 64 bit and sym<<8|type for 32 bit
+		symbol := info >> 8
+		if ec.archBits == 64 {
+			symbol = info >> 32
+		}
+		rs = append(rs, relocation{offset: offset, symbol: symbol})
+	}
+
+	return rs
+}
+
 type sectionHeader struct {
 	addr    int64
 	offset  int64
@@ -666,8 +923,13 @@ type sectionHeader struct {
 	entSize int64
 	name    int
 	typ     int
+	flags   uint64
+	link    int
+	info    int
 	dc      dynamicContext // if SHT_DYNAMIC
-	symbols []symbol
+	symbols []symbol       // if SHT_SYMTAB or SHT_DYNSYM
+	relocs  []relocation   // if SHT_REL or SHT_RELA in a relocatable file to disassemble
+	strTab  string         // if SHT_STRTAB
 }
 
 const maxStrTabSize = 100_000_000
@@ -688,23 +950,23 @@ func elfReadSectionHeaders(d *decode.D, ec *elfContext) {
 		case 32:
 			sh.name = int(d.U32())
 			sh.typ = int(d.U32())
-			d.U32()                      // flags
-			sh.addr = int64(d.U32() * 8) // addr
+			sh.flags = d.U32()
+			sh.addr = int64(d.U32() * 8)
 			sh.offset = int64(d.U32()) * 8
 			sh.size = int64(d.U32()) * 8
-			d.U32() // link
-			d.U32() // info
+			sh.link = int(d.U32())
+			sh.info = int(d.U32())
 			d.U32() // addralign
 			sh.entSize = int64(d.U32()) * 8
 		case 64:
 			sh.name = int(d.U32())
 			sh.typ = int(d.U32())
-			d.U64()                      // addr
-			sh.addr = int64(d.U64() * 8) // flags
+			sh.flags = d.U64()
+			sh.addr = int64(d.U64() * 8)
 			sh.offset = int64(d.U64()) * 8
 			sh.size = int64(d.U64()) * 8
-			d.U32() // link
-			d.U32() // info
+			sh.link = int(d.U32())
+			sh.info = int(d.U32())
 			d.U64() // addralign
 			sh.entSize = int64(d.U64()) * 8
 		default:
@@ -715,9 +977,16 @@ func elfReadSectionHeaders(d *decode.D, ec *elfContext) {
 		case SHT_DYNAMIC:
 			d.SeekAbs(sh.offset)
 			sh.dc = elfReadDynamicTags(d, ec)
-		case SHT_SYMTAB:
-			d.SeekAbs(sh.offset)
-			sh.symbols = elfReadSymbolTable(d, ec, sh)
+		case SHT_SYMTAB, SHT_DYNSYM:
+			if sh.entSize != 0 {
+				d.SeekAbs(sh.offset)
+				sh.symbols = elfReadSymbolTable(d, ec, sh)
+			}
+		case SHT_REL, SHT_RELA:
+			// only needed to symbolize code that is not linked
+			if ec.typ == ET_REL && ec.disassemble && sh.entSize != 0 {
+				sh.relocs = elfReadRelocations(d, ec, sh)
+			}
 		}
 
 		ec.sections = append(ec.sections, sh)
@@ -747,12 +1016,79 @@ func elfReadSectionHeaders(d *decode.D, ec *elfContext) {
 		shStr := ec.sections[ec.shStrNdx]
 		shStrTab = readStrTab(d, shStr.offset, shStr.size/8)
 
-		for _, sh := range ec.sections {
+		for i := range ec.sections {
+			sh := &ec.sections[i]
 			if sh.typ != SHT_STRTAB {
 				continue
 			}
-			ec.strTabMap[strIndexNull(sh.name, shStrTab)] = readStrTab(d, sh.offset, sh.size/8)
+			sh.strTab = readStrTab(d, sh.offset, sh.size/8)
+			ec.strTabMap[strIndexNull(sh.name, shStrTab)] = sh.strTab
+		}
+		// section names can be in a shared string table, ex: ".strtab" produced by llvm
+		ec.strTabMap[STRTAB_SHSTRTAB] = shStrTab
+	}
+
+	elfReadVersionNames(d, ec)
+	elfReadDWARFSections(d, ec)
+}
+
+type compressionHeader struct {
+	typ    uint64
+	size   uint64
+	nBytes int64
+}
+
+func elfReadCompressionHeader(d *decode.D, ec *elfContext) compressionHeader {
+	var ch compressionHeader
+	switch ec.archBits {
+	case 32:
+		ch.typ = d.U32()
+		ch.size = d.U32()
+		d.U32() // addralign
+		ch.nBytes = 12
+	case 64:
+		ch.typ = d.U32()
+		d.U32() // reserved
+		ch.size = d.U64()
+		d.U64() // addralign
+		ch.nBytes = 24
+	}
+	return ch
+}
+
+// read all, possibly compressed, DWARF sections so that they can reference each other
+func elfReadDWARFSections(d *decode.D, ec *elfContext) {
+	ec.dwarf = dwarf.Sections{}
+	for _, sh := range ec.sections {
+		if sh.typ == SHT_NOBITS || sh.offset+sh.size > d.Len() {
+			continue
+		}
+		name, zdebug, ok := dwarf.SectionName(strIndexNull(sh.name, ec.strTabMap[STRTAB_SHSTRTAB]))
+		if !ok {
+			continue
+		}
+
+		var bs []byte
+		var err error
+		if sh.flags&SHF_COMPRESSED != 0 {
+			// compression header is 3 words
+			if sh.size < int64(ec.archBits)*3 {
+				continue
+			}
+			d.SeekAbs(sh.offset)
+			ch := elfReadCompressionHeader(d, ec)
+			if ch.typ != ELFCOMPRESS_ZLIB {
+				// TODO: zstd
+				continue
+			}
+			bs, err = dwarf.Inflate(bitio.NewIOReader(d.BitBufRange(sh.offset+ch.nBytes*8, sh.size-ch.nBytes*8)), ch.size)
+		} else {
+			bs, err = dwarf.ReadSection(d.BytesRange(sh.offset, int(sh.size/8)), zdebug)
+		}
+		if err != nil {
+			continue
 		}
+		ec.dwarf[name] = bs
 	}
 }
 
@@ -772,8 +1108,100 @@ type elfContext struct {
 
 	shStrNdx int
 
-	sections  []sectionHeader
-	strTabMap map[string]string
+	sections     []sectionHeader
+	strTabMap    map[string]string
+	versionNames map[uint64]string
+	dwarf        dwarf.Sections
+	disassemble  bool
+}
+
+func (ec *elfContext) sectionStrTab(i int) string {
+	if i < 0 || i >= len(ec.sections) {
+		return ""
+	}
+	return ec.sections[i].strTab
+}
+
+// names of symbols in symbol table section i
+func (ec *elfContext) sectionSymbolNames(i int) symbolNames {
+	if i < 0 || i >= len(ec.sections) {
+		return nil
+	}
+	sh := ec.sections[i]
+	strTab := ec.sectionStrTab(sh.link)
+	names := make(symbolNames, len(sh.symbols))
+	for j, s := range sh.symbols {
+		names[j] = strIndexNull(int(s.name), strTab)
+	}
+	return names
+}
+
+// symbol addresses to names, prefer static symbol table
+func (ec *elfContext) symbolAddrNames() symbolAddrNames {
+	m := symbolAddrNames{}
+	for _, typ := range []int{SHT_DYNSYM, SHT_SYMTAB} {
+		for i, sh := range ec.sections {
+			if sh.typ != typ {
+				continue
+			}
+			for j, name := range ec.sectionSymbolNames(i) {
+				if name == "" || sh.symbols[j].value == 0 {
+					continue
+				}
+				m[sh.symbols[j].value] = name
+			}
+		}
+	}
+	return m
+}
+
+// codeSymbolNames returns symbol names by address for code in section i. Relocatable
+// files are not linked so symbol values are offsets into the section they are defined in.
+func (ec *elfContext) codeSymbolNames(i int) symbolAddrNames {
+	if ec.typ != ET_REL {
+		return ec.symbolAddrNames()
+	}
+	m := symbolAddrNames{}
+	for j, sh := range ec.sections {
+		if sh.typ != SHT_SYMTAB {
+			continue
+		}
+		for k, name := range ec.sectionSymbolNames(j) {
+			if name == "" || sh.symbols[k].shndx != uint64(i) {
+				continue
+			}
+			m[sh.symbols[k].value] = name
+		}
+	}
+	return m
+}
+
+// codeRelocations returns relocation symbol names by address of relocated bytes for
+// code in section i. Section symbols have no name so the section name is used.
+func (ec *elfContext) codeRelocations(i int) map[uint64]string {
+	m := map[uint64]string{}
+	base := uint64(ec.sections[i].addr / 8)
+	for _, sh := range ec.sections {
+		if (sh.typ != SHT_REL && sh.typ != SHT_RELA) || sh.info != i || sh.link < 0 || sh.link >= len(ec.sections) {
+			continue
+		}
+		syms := ec.sections[sh.link].symbols
+		names := ec.sectionSymbolNames(sh.link)
+		for _, r := range sh.relocs {
+			if r.symbol >= uint64(len(syms)) {
+				continue
+			}
+			s := syms[r.symbol]
+			name := names[r.symbol]
+			if name == "" && s.typ == STT_SECTION && s.shndx < uint64(len(ec.sections)) {
+				name = strIndexNull(ec.sections[s.shndx].name, ec.strTabMap[STRTAB_SHSTRTAB])
+			}
+			if name != "" {
+				m[base+r.offset] = name
+			}
+		}
+	}
+	return m
 }
 
 func (ec *elfContext) sectionIndexByAddr(addr int64) (int, bool) {
@@ -890,32 +1318,8 @@ func elfDecodeProgramHeader(d *decode.D, ec elfContext) {
 
 	d.RangeFn(int64(offset*8), int64(size*8), func(d *decode.D) {
 		switch {
-		case typ == PT_NOTE:
-			d.FieldArray("notes", func(d *decode.D) {
-				for !d.End() {
-					d.FieldStruct("note", func(d *decode.D) {
-						// elf manpage says this is 32 or 64 bit but it seems it is always 32
-						// and that is also what readelf external.h says
-						nameSz := d.FieldU32("n_namesz")
-						descSz := d.FieldU32("n_descsz")
-						if ec.typ == ET_CORE {
-							d.FieldU32("n_type", coreNoteNames, scalar.UintHex)
-						} else {
-							d.FieldU32("n_type", scalar.UintHex)
-						}
-						d.FieldUTF8NullFixedLen("name", int(nameSz))
-						nameAlign := d.AlignBits(4 * 8)
-						if nameAlign != 0 {
-							d.FieldRawLen("name_align", int64(nameAlign))
-						}
-						d.FieldRawLen("desc", int64(descSz)*8)
-						descAlign := d.AlignBits(4 * 8)
-						if descAlign != 0 {
-							d.FieldRawLen("decs_align", int64(descAlign))
-						}
-					})
-				}
-			})
+		case typ == PT_NOTE, typ == PT_GNU_PROPERTY:
+			elfDecodeNotes(d, ec)
 		default:
 			d.FieldRawLen("data", d.BitsLeft())
 		}
@@ -978,7 +1382,274 @@ func elfDecodeDynamicTags(d *decode.D, ec elfContext, dc dynamicContext) {
 	}
 }
 
-func elfDecodeSectionHeader(d *decode.D, ec elfContext, sh sectionHeader) {
+// symbol table index to name
+type symbolNames []string
+
+func (m symbolNames) MapUint(s scalar.Uint) (scalar.Uint, error) {
+	if s.Actual != 0 && s.Actual < uint64(len(m)) {
+		s.Sym = m[s.Actual]
+	}
+	return s, nil
+}
+
+// symbol address to name
+type symbolAddrNames map[uint64]string
+
+func (m symbolAddrNames) MapUint(s scalar.Uint) (scalar.Uint, error) {
+	if n, ok := m[s.Actual]; ok {
+		s.Description = n
+	}
+	return s, nil
+}
+
+func elfDecodeRelocations(d *decode.D, ec elfContext, sh sectionHeader, addend bool) {
+	syms := ec.sectionSymbolNames(sh.link)
+	typeNames := relocTypeMachineNames[uint64(ec.machine)]
+
+	for !d.End() {
+		d.FieldStruct("relocation", func(d *decode.D) {
+			d.FieldU("offset", ec.archBits, scalar.UintHex)
+			// info is sym<<32|type for 64 bit and sym<<8|type for 32 bit
+			switch {
+			case ec.archBits == 64 && d.Endian == decode.LittleEndian:
+				d.FieldU32("type", typeNames)
+				d.FieldU32("symbol", syms)
+			case ec.archBits == 64:
+				d.FieldU32("symbol", syms)
+				d.FieldU32("type", typeNames)
+			case d.Endian == decode.LittleEndian:
+				d.FieldU8("type", typeNames)
+				d.FieldU24("symbol", syms)
+			default:
+				d.FieldU24("symbol", syms)
+				d.FieldU8("type", typeNames)
+			}
+			if addend {
+				d.FieldS("addend", ec.archBits)
+			}
+		})
+	}
+}
+
+func elfDecodeFunctionArray(d *decode.D, ec elfContext) {
+	addrNames := ec.symbolAddrNames()
+	for !d.End() {
+		d.FieldU("function", ec.archBits, addrNames, scalar.UintHex)
+	}
+}
+
+const (
+	VER_NDX_LOCAL  = 0
+	VER_NDX_GLOBAL = 1
+	VER_FLG_BASE   = 0x1
+	VER_FLG_WEAK   = 0x2
+	VERSYM_HIDDEN  = 0x8000
+)
+
+var versionFlagNames = scalar.UintMapSymStr{
+	VER_FLG_BASE: "base",
+	VER_FLG_WEAK: "weak",
+}
+
+func elfDecodeVersionSymbols(d *decode.D, ec elfContext, sh sectionHeader) {
+	syms := ec.sectionSymbolNames(sh.link)
+	for i := 0; !d.End(); i++ {
+		d.FieldU16("version", scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+			if n, ok := ec.versionNames[s.Actual&^VERSYM_HIDDEN]; ok {
+				s.Sym = n
+			}
+			if i < len(syms) {
+				s.Description = syms[i]
+			}
+			if s.Actual&VERSYM_HIDDEN != 0 {
+				s.Description += " (hidden)"
+			}
+			return s, nil
+		}))
+	}
+}
+
+func elfDecodeVersionNeeds(d *decode.D, ec elfContext, sh sectionHeader) {
+	strTab := ec.sectionStrTab(sh.link)
+	pos := sh.offset
+	for i := 0; i < sh.info; i++ {
+		d.SeekAbs(pos)
+		var next uint64
+		d.FieldStruct("version_need", func(d *decode.D) {
+			d.FieldU16("vn_version")
+			cnt := d.FieldU16("vn_cnt")
+			d.FieldU32("vn_file", strTable(strTab))
+			aux := d.FieldU32("vn_aux")
+			next = d.FieldU32("vn_next")
+			auxPos := pos + int64(aux)*8
+			d.FieldArray("auxs", func(d *decode.D) {
+				for j := 0; j < int(cnt); j++ {
+					d.SeekAbs(auxPos)
+					var auxNext uint64
+					d.FieldStruct("aux", func(d *decode.D) {
+						d.FieldU32("vna_hash", scalar.UintHex)
+						d.FieldU16("vna_flags", versionFlagNames)
+						d.FieldU16("vna_other")
+						d.FieldU32("vna_name", strTable(strTab))
+						auxNext = d.FieldU32("vna_next")
+					})
+					if auxNext == 0 {
+						break
+					}
+					auxPos += int64(auxNext) * 8
+				}
+			})
+		})
+		if next == 0 {
+			break
+		}
+		pos += int64(next) * 8
+	}
+}
+
+func elfDecodeVersionDefinitions(d *decode.D, ec elfContext, sh sectionHeader) {
+	strTab := ec.sectionStrTab(sh.link)
+	pos := sh.offset
+	for i := 0; i < sh.info; i++ {
+		d.SeekAbs(pos)
+		var next uint64
+		d.FieldStruct("version_definition", func(d *decode.D) {
+			d.FieldU16("vd_version")
+			d.FieldU16("vd_flags", versionFlagNames)
+			d.FieldU16("vd_ndx")
+			cnt := d.FieldU16("vd_cnt")
+			d.FieldU32("vd_hash", scalar.UintHex)
+			aux := d.FieldU32("vd_aux")
+			next = d.FieldU32("vd_next")
+			auxPos := pos + int64(aux)*8
+			d.FieldArray("auxs", func(d *decode.D) {
+				for j := 0; j < int(cnt); j++ {
+					d.SeekAbs(auxPos)
+					var auxNext uint64
+					d.FieldStruct("aux", func(d *decode.D) {
+						d.FieldU32("vda_name", strTable(strTab))
+						auxNext = d.FieldU32("vda_next")
+					})
+					if auxNext == 0 {
+						break
+					}
+					auxPos += int64(auxNext) * 8
+				}
+			})
+		})
+		if next == 0 {
+			break
+		}
+		pos += int64(next) * 8
+	}
+}
+
+// read version index to name mappings used by the version symbol table
+func elfReadVersionNames(d *decode.D, ec *elfContext) {
+	ec.versionNames = map[uint64]string{
+		VER_NDX_LOCAL:  "local",
+		VER_NDX_GLOBAL: "global",
+	}
+
+	for _, sh := range ec.sections {
+		strTab := ec.sectionStrTab(sh.link)
+		pos := sh.offset
+
+		switch sh.typ {
+		case SHT_GNU_VERNEED:
+			for i := 0; i < sh.info; i++ {
+				d.SeekAbs(pos)
+				d.U16() // vn_version
+				cnt := d.U16()
+				d.U32() // vn_file
+				aux := d.U32()
+				next := d.U32()
+				auxPos := pos + int64(aux)*8
+				for j := 0; j < int(cnt); j++ {
+					d.SeekAbs(auxPos)
+					d.U32() // vna_hash
+					d.U16() // vna_flags
+					other := d.U16()
+					name := d.U32()
+					auxNext := d.U32()
+					ec.versionNames[other] = strIndexNull(int(name), strTab)
+					if auxNext == 0 {
+						break
+					}
+					auxPos += int64(auxNext) * 8
+				}
+				if next == 0 {
+					break
+				}
+				pos += int64(next) * 8
+			}
+		case SHT_GNU_VERDEF:
+			for i := 0; i < sh.info; i++ {
+				d.SeekAbs(pos)
+				d.U16() // vd_version
+				flags := d.U16()
+				ndx := d.U16()
+				cnt := d.U16()
+				d.U32() // vd_hash
+				aux := d.U32()
+				next := d.U32()
+				// base definition is the file itself
+				if flags&VER_FLG_BASE == 0 && cnt > 0 {
+					d.SeekAbs(pos + int64(aux)*8)
+					ec.versionNames[ndx] = strIndexNull(int(d.U32()), strTab)
+				}
+				if next == 0 {
+					break
+				}
+				pos += int64(next) * 8
+			}
+		}
+	}
+}
+
+const goBuildInfoMagic = "\xff Go buildinf:"
+
+// https://github.com/golang/go/blob/master/src/debug/buildinfo/buildinfo.go
+func elfDecodeGoBuildInfo(d *decode.D) {
+	d.FieldRawLen("magic", int64(len(goBuildInfoMagic))*8, d.AssertBitBuf([]byte(goBuildInfoMagic)))
+	ptrSize := d.FieldU8("ptr_size", d.UintAssert(4, 8))
+	var inlineStrings bool
+	var bigEndian bool
+	d.FieldStruct("flags", func(d *decode.D) {
+		d.FieldU6("unused")
+		inlineStrings = d.FieldBool("inline_strings")
+		bigEndian = d.FieldBool("big_endian")
+	})
+
+	if inlineStrings {
+		// go 1.18+ has varint length prefixed strings after a 32 byte header
+		d.FieldRawLen("padding", 16*8, d.BitBufIsZero())
+		versionLen := d.FieldULEB128("version_length")
+		d.FieldUTF8("version", int(versionLen))
+		modInfoLen := d.FieldULEB128("modinfo_length")
+		// modinfo is surrounded by 16 byte sentinels
+		if modInfoLen >= 33 {
+			d.FieldRawLen("modinfo_start", 16*8)
+			d.FieldUTF8("modinfo", int(modInfoLen)-32)
+			d.FieldRawLen("modinfo_end", 16*8)
+		} else {
+			d.FieldUTF8("modinfo", int(modInfoLen))
+		}
+	} else {
+		// TODO: resolve pointers to string headers
+		d.Endian = decode.LittleEndian
+		if bigEndian {
+			d.Endian = decode.BigEndian
+		}
+		d.FieldU("version_ptr", int(ptrSize)*8, scalar.UintHex)
+		d.FieldU("modinfo_ptr", int(ptrSize)*8, scalar.UintHex)
+	}
+	if d.BitsLeft() > 0 {
+		d.FieldRawLen("data", d.BitsLeft())
+	}
+}
+
+func elfDecodeSectionHeader(d *decode.D, ec elfContext, i int, sh sectionHeader) {
 	shFlags := func(d *decode.D, archBits int) {
 		d.FieldStruct("flags", func(d *decode.D) {
 			if d.Endian == decode.LittleEndian {
@@ -990,16 +1661,20 @@ func elfDecodeSectionHeader(d *decode.D, ec elfContext, sh sectionHeader) {
 				d.FieldBool("execinstr")
 				d.FieldBool("alloc")
 				d.FieldBool("write")
+
+				d.FieldU4("unused1")
+				d.FieldBool("compressed")
 				d.FieldBool("tls")
 				d.FieldBool("group")
 				d.FieldBool("os_nonconforming")
 
-				d.FieldU9("unused1")
-
-				d.FieldU8("os_specific")
+				// os specific mask 0x0ff00000 is split in little endian
+				d.FieldU4("os_specific0")
+				d.FieldU4("unused2")
 				d.FieldU4("processor_specific")
+				d.FieldU4("os_specific1")
 				if archBits == 64 {
-					d.FieldU32("unused2")
+					d.FieldU32("unused3")
 				}
 			} else {
 				// TODO: add.FieldUnused that is per decoder?
@@ -1008,7 +1683,8 @@ func elfDecodeSectionHeader(d *decode.D, ec elfContext, sh sectionHeader) {
 				}
 				d.FieldU4("processor_specific")
 				d.FieldU8("os_specific")
-				d.FieldU9("unused1")
+				d.FieldU8("unused1")
+				d.FieldBool("compressed")
 				d.FieldBool("tls")
 				d.FieldBool("group")
 				d.FieldBool("os_nonconforming")
@@ -1024,6 +1700,7 @@ func elfDecodeSectionHeader(d *decode.D, ec elfContext, sh sectionHeader) {
 		})
 	}
 
+	var name uint64
 	var offset int64
 	var size int64
 	var entSize int64
@@ -1031,7 +1708,7 @@ func elfDecodeSectionHeader(d *decode.D, ec elfContext, sh sectionHeader) {
 
 	switch ec.archBits {
 	case 32:
-		d.FieldU32("name", strTable(ec.strTabMap[STRTAB_SHSTRTAB]))
+		name = d.FieldU32("name", strTable(ec.strTabMap[STRTAB_SHSTRTAB]))
 		typ = d.FieldU32("type", sectionHeaderTypeMap, scalar.UintHex)
 		shFlags(d, ec.archBits)
 		d.FieldU("addr", ec.archBits, scalar.UintHex)
@@ -1042,7 +1719,7 @@ func elfDecodeSectionHeader(d *decode.D, ec elfContext, sh sectionHeader) {
 		d.FieldU32("addralign")
 		entSize = int64(d.FieldU32("entsize") * 8)
 	case 64:
-		d.FieldU32("name", strTable(ec.strTabMap[STRTAB_SHSTRTAB]))
+		name = d.FieldU32("name", strTable(ec.strTabMap[STRTAB_SHSTRTAB]))
 		typ = d.FieldU32("type", sectionHeaderTypeMap, scalar.UintHex)
 		shFlags(d, ec.archBits)
 		d.FieldU("addr", ec.archBits, scalar.UintHex)
@@ -1078,29 +1755,127 @@ func elfDecodeSectionHeader(d *decode.D, ec elfContext, sh sectionHeader) {
 			elfDecodeSymbolTable(d, ec, int(size/entSize), ec.strTabMap[STRTAB_DYNSTR])
 		})
 	case SHT_PROGBITS:
-		// TODO: name progbits?
-		// TODO: decode opcodes
-		d.FieldRawLen("data", size)
+		sectionName := strIndexNull(int(name), ec.strTabMap[STRTAB_SHSTRTAB])
+		dwarfName, zdebug, isDWARF := dwarf.SectionName(sectionName)
+		switch {
+		case isDWARF:
+			d.FramedFn(size, func(d *decode.D) {
+				elfDecodeDWARFSection(d, ec, sh, dwarfName, zdebug)
+			})
+		case sectionName == ".go.buildinfo" &&
+			size >= int64(len(goBuildInfoMagic))*8 &&
+			string(d.PeekBytes(len(goBuildInfoMagic))) == goBuildInfoMagic:
+			d.FramedFn(size, func(d *decode.D) {
+				d.FieldStruct("go_buildinfo", elfDecodeGoBuildInfo)
+			})
+		case ec.disassemble && sh.flags&SHF_EXECINSTR != 0:
+			elfDecodeCode(d, ec, i, sh, size)
+		default:
+			d.FieldRawLen("data", size)
+		}
 	case SHT_GNU_HASH:
 		d.FieldStruct("gnu_hash", func(d *decode.D) {
 			elfDecodeGNUHash(d, ec, size, ec.strTabMap[STRTAB_DYNSTR])
 		})
+	case SHT_REL, SHT_RELA:
+		d.FramedFn(size, func(d *decode.D) {
+			d.FieldArray("relocations", func(d *decode.D) {
+				elfDecodeRelocations(d, ec, sh, typ == SHT_RELA)
+			})
+		})
+	case SHT_NOTE:
+		d.FramedFn(size, func(d *decode.D) {
+			elfDecodeNotes(d, ec)
+		})
+	case SHT_INIT_ARRAY, SHT_FINI_ARRAY, SHT_PREINIT_ARRAY:
+		d.FramedFn(size, func(d *decode.D) {
+			d.FieldArray("functions", func(d *decode.D) {
+				elfDecodeFunctionArray(d, ec)
+			})
+		})
+	case SHT_GNU_VERSYM:
+		d.FramedFn(size, func(d *decode.D) {
+			d.FieldArray("symbol_versions", func(d *decode.D) {
+				elfDecodeVersionSymbols(d, ec, sh)
+			})
+		})
+	case SHT_GNU_VERNEED:
+		d.FieldArray("version_needs", func(d *decode.D) {
+			elfDecodeVersionNeeds(d, ec, sh)
+		})
+	case SHT_GNU_VERDEF:
+		d.FieldArray("version_definitions", func(d *decode.D) {
+			elfDecodeVersionDefinitions(d, ec, sh)
+		})
 	default:
 		d.FieldRawLen("data", size)
 	}
 }
 
+func elfDecodeCompressionHeader(d *decode.D, ec elfContext) {
+	switch ec.archBits {
+	case 32:
+		d.FieldU32("type", compressionTypeNames)
+		d.FieldU32("size")
+		d.FieldU32("addralign")
+	case 64:
+		d.FieldU32("type", compressionTypeNames)
+		d.FieldU32("reserved")
+		d.FieldU64("size")
+		d.FieldU64("addralign")
+	}
+}
+
+func elfDecodeDWARFSection(d *decode.D, ec elfContext, sh sectionHeader, name string, zdebug bool) {
+	addrSize := ec.archBits / 8
+	if sh.flags&SHF_COMPRESSED != 0 && d.BitsLeft() >= int64(ec.archBits)*3 {
+		d.FieldStruct("compression_header", func(d *decode.D) { elfDecodeCompressionHeader(d, ec) })
+		d.FieldRawLen("compressed", d.BitsLeft())
+		dwarf.FieldInflatedSection(d, name, ec.dwarf, addrSize)
+		return
+	}
+	dwarf.FieldSection(d, name, zdebug, ec.dwarf, addrSize)
+}
+
+// elfDecodeCode disassembles section if machine is supported, otherwise raw data
+func elfDecodeCode(d *decode.D, ec elfContext, i int, sh sectionHeader, size int64) {
+	var group *decode.Group
+	switch {
+	case ec.machine == EM_X86_64:
+		group = &x86_64Format
+	case ec.machine == EM_ARM64:
+		group = &arm64Format
+	case ec.machine == EM_RISCV && ec.archBits == 64:
+		group = &riscv64Format
+	}
+	if group != nil {
+		inArg := format.AsmIn{
+			Base:        uint64(sh.addr / 8),
+			Symbols:     ec.codeSymbolNames(i),
+			Relocations: ec.codeRelocations(i),
+		}
+		if dv, _, _ := d.TryFieldFormatLen("code", size, *group, inArg); dv != nil {
+			return
+		}
+	}
+	d.FieldRawLen("data", size)
+}
+
 func elfDecodeSectionHeaders(d *decode.D, ec elfContext) {
 	for i := 0; i < ec.shNum; i++ {
 		d.SeekAbs(ec.shOff + int64(i)*ec.shEntSize)
 		d.FieldStruct("section_header", func(d *decode.D) {
-			elfDecodeSectionHeader(d, ec, ec.sections[i])
+			elfDecodeSectionHeader(d, ec, i, ec.sections[i])
 		})
 	}
 }
 
 func elfDecode(d *decode.D) any {
+	var ei format.ElfIn
+	d.ArgAs(&ei)
+
 	var ec elfContext
+	ec.disassemble = ei.Disassemble
 
 	d.FieldStruct("header", func(d *decode.D) { elfDecodeHeader(d, &ec) })
 	d.Endian = ec.endian
@@ -0,0 +1,447 @@
+package elf
+
+// Relocation type names per machine
+// https://refspecs.linuxbase.org/elf/x86_64-abi-0.99.pdf
+// https://github.com/ARM-software/abi-aa/blob/main/aaelf64/aaelf64.rst
+// https://github.com/ARM-software/abi-aa/blob/main/aaelf32/aaelf32.rst
+// https://github.com/riscv-non-isa/riscv-elf-psabi-doc/blob/master/riscv-elf.adoc
+
+import (
+	"github.com/wader/fq/pkg/scalar"
+)
+
+var relocTypeMachineNames = map[uint64]scalar.UintMapSymStr{
+	EM_386:    relocType386Names,
+	EM_ARM:    relocTypeARMNames,
+	EM_X86_64: relocTypeX86_64Names,
+	EM_ARM64:  relocTypeAARCH64Names,
+	EM_RISCV:  relocTypeRISCVNames,
+}
+
+var relocTypeX86_64Names = scalar.UintMapSymStr{
+	0:  "none",
+	1:  "64",
+	2:  "pc32",
+	3:  "got32",
+	4:  "plt32",
+	5:  "copy",
+	6:  "glob_dat",
+	7:  "jmp_slot",
+	8:  "relative",
+	9:  "gotpcrel",
+	10: "32",
+	11: "32s",
+	12: "16",
+	13: "pc16",
+	14: "8",
+	15: "pc8",
+	16: "dtpmod64",
+	17: "dtpoff64",
+	18: "tpoff64",
+	19: "tlsgd",
+	20: "tlsld",
+	21: "dtpoff32",
+	22: "gottpoff",
+	23: "tpoff32",
+	24: "pc64",
+	25: "gotoff64",
+	26: "gotpc32",
+	27: "got64",
+	28: "gotpcrel64",
+	29: "gotpc64",
+	30: "gotplt64",
+	31: "pltoff64",
+	32: "size32",
+	33: "size64",
+	34: "gotpc32_tlsdesc",
+	35: "tlsdesc_call",
+	36: "tlsdesc",
+	37: "irelative",
+	38: "relative64",
+	39: "pc32_bnd",
+	40: "plt32_bnd",
+	41: "gotpcrelx",
+	42: "rex_gotpcrelx",
+}
+
+var relocType386Names = scalar.UintMapSymStr{
+	0:  "none",
+	1:  "32",
+	2:  "pc32",
+	3:  "got32",
+	4:  "plt32",
+	5:  "copy",
+	6:  "glob_dat",
+	7:  "jmp_slot",
+	8:  "relative",
+	9:  "gotoff",
+	10: "gotpc",
+	11: "32plt",
+	14: "tls_tpoff",
+	15: "tls_ie",
+	16: "tls_gotie",
+	17: "tls_le",
+	18: "tls_gd",
+	19: "tls_ldm",
+	20: "16",
+	21: "pc16",
+	22: "8",
+	23: "pc8",
+	24: "tls_gd_32",
+	25: "tls_gd_push",
+	26: "tls_gd_call",
+	27: "tls_gd_pop",
+	28: "tls_ldm_32",
+	29: "tls_ldm_push",
+	30: "tls_ldm_call",
+	31: "tls_ldm_pop",
+	32: "tls_ldo_32",
+	33: "tls_ie_32",
+	34: "tls_le_32",
+	35: "tls_dtpmod32",
+	36: "tls_dtpoff32",
+	37: "tls_tpoff32",
+	38: "size32",
+	39: "tls_gotdesc",
+	40: "tls_desc_call",
+	41: "tls_desc",
+	42: "irelative",
+	43: "got32x",
+}
+
+var relocTypeAARCH64Names = scalar.UintMapSymStr{
+	0:    "none",
+	1:    "p32_abs32",
+	2:    "p32_abs16",
+	3:    "p32_prel32",
+	4:    "p32_prel16",
+	5:    "p32_movw_uabs_g0",
+	6:    "p32_movw_uabs_g0_nc",
+	7:    "p32_movw_uabs_g1",
+	8:    "p32_movw_sabs_g0",
+	9:    "p32_ld_prel_lo19",
+	10:   "p32_adr_prel_lo21",
+	11:   "p32_adr_prel_pg_hi21",
+	12:   "p32_add_abs_lo12_nc",
+	13:   "p32_ldst8_abs_lo12_nc",
+	14:   "p32_ldst16_abs_lo12_nc",
+	15:   "p32_ldst32_abs_lo12_nc",
+	16:   "p32_ldst64_abs_lo12_nc",
+	17:   "p32_ldst128_abs_lo12_nc",
+	18:   "p32_tstbr14",
+	19:   "p32_condbr19",
+	20:   "p32_jump26",
+	21:   "p32_call26",
+	25:   "p32_got_ld_prel19",
+	26:   "p32_adr_got_page",
+	27:   "p32_ld32_got_lo12_nc",
+	81:   "p32_tlsgd_adr_page21",
+	82:   "p32_tlsgd_add_lo12_nc",
+	103:  "p32_tlsie_adr_gottprel_page21",
+	104:  "p32_tlsie_ld32_gottprel_lo12_nc",
+	105:  "p32_tlsie_ld_gottprel_prel19",
+	106:  "p32_tlsle_movw_tprel_g1",
+	107:  "p32_tlsle_movw_tprel_g0",
+	108:  "p32_tlsle_movw_tprel_g0_nc",
+	109:  "p32_tlsle_add_tprel_hi12",
+	110:  "p32_tlsle_add_tprel_lo12",
+	111:  "p32_tlsle_add_tprel_lo12_nc",
+	122:  "p32_tlsdesc_ld_prel19",
+	123:  "p32_tlsdesc_adr_prel21",
+	124:  "p32_tlsdesc_adr_page21",
+	125:  "p32_tlsdesc_ld32_lo12_nc",
+	126:  "p32_tlsdesc_add_lo12_nc",
+	127:  "p32_tlsdesc_call",
+	180:  "p32_copy",
+	181:  "p32_glob_dat",
+	182:  "p32_jump_slot",
+	183:  "p32_relative",
+	184:  "p32_tls_dtpmod",
+	185:  "p32_tls_dtprel",
+	186:  "p32_tls_tprel",
+	187:  "p32_tlsdesc",
+	188:  "p32_irelative",
+	256:  "null",
+	257:  "abs64",
+	258:  "abs32",
+	259:  "abs16",
+	260:  "prel64",
+	261:  "prel32",
+	262:  "prel16",
+	263:  "movw_uabs_g0",
+	264:  "movw_uabs_g0_nc",
+	265:  "movw_uabs_g1",
+	266:  "movw_uabs_g1_nc",
+	267:  "movw_uabs_g2",
+	268:  "movw_uabs_g2_nc",
+	269:  "movw_uabs_g3",
+	270:  "movw_sabs_g0",
+	271:  "movw_sabs_g1",
+	272:  "movw_sabs_g2",
+	273:  "ld_prel_lo19",
+	274:  "adr_prel_lo21",
+	275:  "adr_prel_pg_hi21",
+	276:  "adr_prel_pg_hi21_nc",
+	277:  "add_abs_lo12_nc",
+	278:  "ldst8_abs_lo12_nc",
+	279:  "tstbr14",
+	280:  "condbr19",
+	282:  "jump26",
+	283:  "call26",
+	284:  "ldst16_abs_lo12_nc",
+	285:  "ldst32_abs_lo12_nc",
+	286:  "ldst64_abs_lo12_nc",
+	299:  "ldst128_abs_lo12_nc",
+	309:  "got_ld_prel19",
+	310:  "ld64_gotoff_lo15",
+	311:  "adr_got_page",
+	312:  "ld64_got_lo12_nc",
+	313:  "ld64_gotpage_lo15",
+	512:  "tlsgd_adr_prel21",
+	513:  "tlsgd_adr_page21",
+	514:  "tlsgd_add_lo12_nc",
+	515:  "tlsgd_movw_g1",
+	516:  "tlsgd_movw_g0_nc",
+	517:  "tlsld_adr_prel21",
+	518:  "tlsld_adr_page21",
+	539:  "tlsie_movw_gottprel_g1",
+	540:  "tlsie_movw_gottprel_g0_nc",
+	541:  "tlsie_adr_gottprel_page21",
+	542:  "tlsie_ld64_gottprel_lo12_nc",
+	543:  "tlsie_ld_gottprel_prel19",
+	544:  "tlsle_movw_tprel_g2",
+	545:  "tlsle_movw_tprel_g1",
+	546:  "tlsle_movw_tprel_g1_nc",
+	547:  "tlsle_movw_tprel_g0",
+	548:  "tlsle_movw_tprel_g0_nc",
+	549:  "tlsle_add_tprel_hi12",
+	550:  "tlsle_add_tprel_lo12",
+	551:  "tlsle_add_tprel_lo12_nc",
+	560:  "tlsdesc_ld_prel19",
+	561:  "tlsdesc_adr_prel21",
+	562:  "tlsdesc_adr_page21",
+	563:  "tlsdesc_ld64_lo12_nc",
+	564:  "tlsdesc_add_lo12_nc",
+	565:  "tlsdesc_off_g1",
+	566:  "tlsdesc_off_g0_nc",
+	567:  "tlsdesc_ldr",
+	568:  "tlsdesc_add",
+	569:  "tlsdesc_call",
+	570:  "tlsle_ldst128_tprel_lo12",
+	571:  "tlsle_ldst128_tprel_lo12_nc",
+	572:  "tlsld_ldst128_dtprel_lo12",
+	573:  "tlsld_ldst128_dtprel_lo12_nc",
+	1024: "copy",
+	1025: "glob_dat",
+	1026: "jump_slot",
+	1027: "relative",
+	1028: "tls_dtpmod64",
+	1029: "tls_dtprel64",
+	1030: "tls_tprel64",
+	1031: "tlsdesc",
+	1032: "irelative",
+}
+
+var relocTypeARMNames = scalar.UintMapSymStr{
+	0:   "none",
+	1:   "pc24",
+	2:   "abs32",
+	3:   "rel32",
+	4:   "pc13",
+	5:   "abs16",
+	6:   "abs12",
+	7:   "thm_abs5",
+	8:   "abs8",
+	9:   "sbrel32",
+	10:  "thm_pc22",
+	11:  "thm_pc8",
+	12:  "amp_vcall9",
+	13:  "swi24",
+	14:  "thm_swi8",
+	15:  "xpc25",
+	16:  "thm_xpc22",
+	17:  "tls_dtpmod32",
+	18:  "tls_dtpoff32",
+	19:  "tls_tpoff32",
+	20:  "copy",
+	21:  "glob_dat",
+	22:  "jump_slot",
+	23:  "relative",
+	24:  "gotoff",
+	25:  "gotpc",
+	26:  "got32",
+	27:  "plt32",
+	28:  "call",
+	29:  "jump24",
+	30:  "thm_jump24",
+	31:  "base_abs",
+	32:  "alu_pcrel_7_0",
+	33:  "alu_pcrel_15_8",
+	34:  "alu_pcrel_23_15",
+	35:  "ldr_sbrel_11_10_nc",
+	36:  "alu_sbrel_19_12_nc",
+	37:  "alu_sbrel_27_20_ck",
+	38:  "target1",
+	39:  "sbrel31",
+	40:  "v4bx",
+	41:  "target2",
+	42:  "prel31",
+	43:  "movw_abs_nc",
+	44:  "movt_abs",
+	45:  "movw_prel_nc",
+	46:  "movt_prel",
+	47:  "thm_movw_abs_nc",
+	48:  "thm_movt_abs",
+	49:  "thm_movw_prel_nc",
+	50:  "thm_movt_prel",
+	51:  "thm_jump19",
+	52:  "thm_jump6",
+	53:  "thm_alu_prel_11_0",
+	54:  "thm_pc12",
+	55:  "abs32_noi",
+	56:  "rel32_noi",
+	57:  "alu_pc_g0_nc",
+	58:  "alu_pc_g0",
+	59:  "alu_pc_g1_nc",
+	60:  "alu_pc_g1",
+	61:  "alu_pc_g2",
+	62:  "ldr_pc_g1",
+	63:  "ldr_pc_g2",
+	64:  "ldrs_pc_g0",
+	65:  "ldrs_pc_g1",
+	66:  "ldrs_pc_g2",
+	67:  "ldc_pc_g0",
+	68:  "ldc_pc_g1",
+	69:  "ldc_pc_g2",
+	70:  "alu_sb_g0_nc",
+	71:  "alu_sb_g0",
+	72:  "alu_sb_g1_nc",
+	73:  "alu_sb_g1",
+	74:  "alu_sb_g2",
+	75:  "ldr_sb_g0",
+	76:  "ldr_sb_g1",
+	77:  "ldr_sb_g2",
+	78:  "ldrs_sb_g0",
+	79:  "ldrs_sb_g1",
+	80:  "ldrs_sb_g2",
+	81:  "ldc_sb_g0",
+	82:  "ldc_sb_g1",
+	83:  "ldc_sb_g2",
+	84:  "movw_brel_nc",
+	85:  "movt_brel",
+	86:  "movw_brel",
+	87:  "thm_movw_brel_nc",
+	88:  "thm_movt_brel",
+	89:  "thm_movw_brel",
+	90:  "tls_gotdesc",
+	91:  "tls_call",
+	92:  "tls_descseq",
+	93:  "thm_tls_call",
+	94:  "plt32_abs",
+	95:  "got_abs",
+	96:  "got_prel",
+	97:  "got_brel12",
+	98:  "gotoff12",
+	99:  "gotrelax",
+	100: "gnu_vtentry",
+	101: "gnu_vtinherit",
+	102: "thm_jump11",
+	103: "thm_jump8",
+	104: "tls_gd32",
+	105: "tls_ldm32",
+	106: "tls_ldo32",
+	107: "tls_ie32",
+	108: "tls_le32",
+	109: "tls_ldo12",
+	110: "tls_le12",
+	111: "tls_ie12gp",
+	112: "private_0",
+	113: "private_1",
+	114: "private_2",
+	115: "private_3",
+	116: "private_4",
+	117: "private_5",
+	118: "private_6",
+	119: "private_7",
+	120: "private_8",
+	121: "private_9",
+	122: "private_10",
+	123: "private_11",
+	124: "private_12",
+	125: "private_13",
+	126: "private_14",
+	127: "private_15",
+	128: "me_too",
+	129: "thm_tls_descseq16",
+	130: "thm_tls_descseq32",
+	131: "thm_got_brel12",
+	132: "thm_alu_abs_g0_nc",
+	133: "thm_alu_abs_g1_nc",
+	134: "thm_alu_abs_g2_nc",
+	135: "thm_alu_abs_g3",
+	160: "irelative",
+	249: "rxpc25",
+	250: "rsbrel32",
+	251: "thm_rpc22",
+	252: "rrel32",
+	253: "rabs32",
+	254: "rpc24",
+	255: "rbase",
+}
+
+var relocTypeRISCVNames = scalar.UintMapSymStr{
+	0:  "none",
+	1:  "32",
+	2:  "64",
+	3:  "relative",
+	4:  "copy",
+	5:  "jump_slot",
+	6:  "tls_dtpmod32",
+	7:  "tls_dtpmod64",
+	8:  "tls_dtprel32",
+	9:  "tls_dtprel64",
+	10: "tls_tprel32",
+	11: "tls_tprel64",
+	16: "branch",
+	17: "jal",
+	18: "call",
+	19: "call_plt",
+	20: "got_hi20",
+	21: "tls_got_hi20",
+	22: "tls_gd_hi20",
+	23: "pcrel_hi20",
+	24: "pcrel_lo12_i",
+	25: "pcrel_lo12_s",
+	26: "hi20",
+	27: "lo12_i",
+	28: "lo12_s",
+	29: "tprel_hi20",
+	30: "tprel_lo12_i",
+	31: "tprel_lo12_s",
+	32: "tprel_add",
+	33: "add8",
+	34: "add16",
+	35: "add32",
+	36: "add64",
+	37: "sub8",
+	38: "sub16",
+	39: "sub32",
+	40: "sub64",
+	41: "gnu_vtinherit",
+	42: "gnu_vtentry",
+	43: "align",
+	44: "rvc_branch",
+	45: "rvc_jump",
+	46: "rvc_lui",
+	47: "gprel_i",
+	48: "gprel_s",
+	49: "tprel_i",
+	50: "tprel_s",
+	51: "relax",
+	52: "sub6",
+	53: "set6",
+	54: "set8",
+	55: "set16",
+	56: "set32",
+	57: "32_pcrel",
+}
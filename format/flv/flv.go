@@ -1,186 +1,124 @@
-//go:build ignore
-
 package flv
 
-// TODO: make it useful
 // https://rtmp.veriskope.com/pdf/video_file_format_spec_v10.pdf
+// https://veovera.org/docs/enhanced/enhanced-rtmp-v2
 
 import (
+	"embed"
+
 	"github.com/wader/fq/format"
+	"github.com/wader/fq/format/flvtag"
 	"github.com/wader/fq/pkg/decode"
-	"github.com/wader/fq/pkg/registry"
+	"github.com/wader/fq/pkg/interp"
 	"github.com/wader/fq/pkg/scalar"
 )
 
+var flvTagGroups flvtag.Groups
+
+//go:embed flv.md
+var flvFS embed.FS
+
 func init() {
-	registry.MustRegister(decode.Format{
-		Name:        format.FLV,
-		Description: "Flash video",
-		Groups:      []string{format.PROBE},
-		DecodeFn:    flvDecode,
+	interp.RegisterFormat(decode.Format{
+		Name:         format.FLV,
+		Description:  "Flash video",
+		Groups:       []string{format.PROBE},
+		DecodeFn:     flvDecode,
+		StreamFn:     flvDecodeStream,
+		Dependencies: flvTagGroups.Dependencies(),
 	})
+	interp.RegisterFS(flvFS)
 }
 
 const (
-	audioData        = 8
-	videoData        = 9
-	scriptDataObject = 18
+	tagTypeAudio      = 8
+	tagTypeVideo      = 9
+	tagTypeScriptData = 18
 )
 
 var tagTypeNames = scalar.UintMapSymStr{
-	audioData:        "audioData",
-	videoData:        "videoData",
-	scriptDataObject: "scriptDataObject",
+	tagTypeAudio:      "audio",
+	tagTypeVideo:      "video",
+	tagTypeScriptData: "script_data",
 }
 
-const (
-	typeNumber      = 0
-	typeBoolean     = 1
-	typeString      = 2
-	typeObject      = 3
-	typeMovieClip   = 4
-	typeNull        = 5
-	typeUndefined   = 6
-	typeReference   = 7
-	typeECMAArray   = 8
-	typeObjectEnd   = 9
-	typeStrictArray = 10
-	typeDate        = 11
-	typeLongString  = 12
-)
+// tag header is 11 bytes and previous tag size includes it
+const tagHeaderSize = 11
+
+func flvDecodeHeader(d *decode.D) {
+	var dataOffset uint64
+	d.FieldStruct("header", func(d *decode.D) {
+		d.FieldUTF8("signature", 3, d.StrAssert("FLV"))
+		d.FieldU8("version")
+		d.FieldU5("type_flags_reserved0", d.UintAssert(0))
+		d.FieldBool("type_flags_audio")
+		d.FieldU1("type_flags_reserved1", d.UintAssert(0))
+		d.FieldBool("type_flags_video")
+		dataOffset = d.FieldU32("data_offset")
+		if dataOffset*8 > uint64(d.Pos()) {
+			d.FieldRawLen("extra", int64(dataOffset*8)-d.Pos())
+		}
+	})
 
-var typeNames = scalar.UintMapSymStr{
-	typeNumber:      "Number",
-	typeBoolean:     "Boolean",
-	typeString:      "String",
-	typeObject:      "Object",
-	typeMovieClip:   "MovieClip",
-	typeNull:        "Null",
-	typeUndefined:   "Undefined",
-	typeReference:   "Reference",
-	typeECMAArray:   "ECMAArray",
-	typeObjectEnd:   "ObjectEnd",
-	typeStrictArray: "StrictArray",
-	typeDate:        "Date",
-	typeLongString:  "LongString",
+	d.FieldU32("previous_tag_size0", d.UintValidate(0))
 }
 
-func flvDecode(d *decode.D) any {
-	var fieldScriptDataObject func()
-	var fieldScriptDataVariable func(d *decode.D, name string)
-
-	fieldScriptDataString := func(d *decode.D, name string) {
-		d.FieldStrFn(name, func(d *decode.D) string {
-			l := d.U16()
-			return d.UTF8(int(l))
-		})
-	}
-	fieldScriptDataStringLong := func(d *decode.D, name string) {
-		d.FieldStrFn(name, func(d *decode.D) string {
-			l := d.U32()
-			return d.UTF8(int(l))
-		})
-	}
-
-	fieldScriptDataVariable = func(d *decode.D, name string) {
-		d.FieldStruct(name, func(d *decode.D) {
-			fieldScriptDataString(d, "name")
-			fieldScriptDataString(d, "data")
-		})
-	}
-
-	fieldScriptDataValue := func(d *decode.D, _ string) uint64 {
-		typ := d.FieldU8("type", typeNames)
-		if typ == typeECMAArray {
-			d.FieldU32("ecma_array_length")
-		}
-
-		switch typ {
-		case typeNumber:
-			d.FieldF64("number")
-		case typeBoolean:
-			d.FieldU8("boolean")
-		case typeString:
-			fieldScriptDataString(d, "string")
-		case typeObject:
-			fieldScriptDataObject()
-		case typeMovieClip:
-			fieldScriptDataString(d, "path")
-		case typeNull:
-		case typeUndefined:
-		case typeReference:
-			d.FieldU16("reference")
-		case typeECMAArray:
-			d.FieldArray("array", func(d *decode.D) {
-				for {
-					if d.PeekBits(24) == typeObjectEnd { // variableEnd?
-						d.FieldU24("end")
-						break
-					}
-					fieldScriptDataVariable(d, "sasdadas")
-				}
-			})
-		case typeStrictArray:
-			length := d.FieldU32("length")
-			for i := uint64(0); i < length; i++ {
-				fieldScriptDataVariable(d, "sasdadas")
+func flvDecodeTag(d *decode.D, tags *flvtag.Decoder) {
+	d.FieldStruct("tag", func(d *decode.D) {
+		d.FieldU2("reserved")
+		filter := d.FieldBool("filter")
+		tagType := d.FieldU5("tag_type", tagTypeNames)
+		dataSize := d.FieldU24("data_size")
+		timestamp := d.FieldU24("timestamp")
+		timestampExtended := d.FieldU8("timestamp_extended")
+		d.FieldValueUint("calculated_timestamp", timestampExtended<<24|timestamp)
+		d.FieldU24("stream_id")
+
+		d.FramedFn(int64(dataSize)*8, func(d *decode.D) {
+			if filter {
+				// TODO: encryption header and filter params
+				d.FieldRawLen("data", d.BitsLeft())
+				return
+			}
+			switch tagType {
+			case tagTypeAudio:
+				d.FieldStruct("audio", tags.DecodeAudioData)
+			case tagTypeVideo:
+				d.FieldStruct("video", tags.DecodeVideoData)
+			case tagTypeScriptData:
+				d.FieldStruct("script_data", tags.DecodeScriptData)
+			default:
+				d.FieldRawLen("data", d.BitsLeft())
 			}
-		case typeDate:
-			d.FieldF64("date_time")
-			d.FieldS16("local_data_time_offset")
-
-		case typeLongString:
-			fieldScriptDataStringLong(d, "asdsad")
-
-		case typeObjectEnd: // variableEnd also?
-
-		}
-
-		return typ
-	}
-
-	fieldScriptDataObject = func() {
-		d.FieldStruct("object", func(d *decode.D) {
-			fieldScriptDataString(d, "name")
-			fieldScriptDataValue(d, "data")
 		})
-	}
 
-	d.FieldUTF8("signature", 3, d.StrAssert("FLV"))
-	d.FieldU8("version")
-	d.FieldU5("type_flags_reserved", d.UintAssert(0))
-	d.FieldU1("type_flags_audio")
-	d.FieldU1("type_flags_reserved", d.UintAssert(0))
-	d.FieldU1("type_flags_video")
-	dataOffset := d.FieldU32("data_offset")
+		d.FieldU32("previous_tag_size", d.UintValidate(tagHeaderSize+dataSize))
+	})
+}
 
-	d.SeekAbs(int64(dataOffset) * 8)
+func flvDecode(d *decode.D) any {
+	flvDecodeHeader(d)
+
+	tags := flvtag.NewDecoder(&flvTagGroups)
 
 	d.FieldArray("tags", func(d *decode.D) {
 		for !d.End() {
-			d.FieldStruct("tag", func(d *decode.D) {
-				d.FieldU32("previous_tag_size")
-				tagType := d.FieldU8("tag_type", tagTypeNames)
-				dataSize := d.FieldU24("data_size")
-				d.FieldU24("timestamp")
-				d.FieldU8("timestamp_extended")
-				d.FieldU24("stream_id")
-
-				switch tagType {
-				case audioData, videoData:
-					d.SeekRel(int64(dataSize) * 8)
-				case scriptDataObject:
-					for {
-						if d.PeekBits(24) == typeObjectEnd {
-							d.FieldU24("end")
-							break
-						}
-						fieldScriptDataObject()
-					}
-				}
-			})
+			flvDecodeTag(d, tags)
 		}
 	})
 
 	return nil
 }
+
+// first record is the header, then one record per tag. Track state from
+// sequence headers is kept between tags, a tag decode that is retried with more
+// input sets the same state again.
+func flvDecodeStream(d *decode.D, state any) any {
+	tags, ok := state.(*flvtag.Decoder)
+	if !ok {
+		flvDecodeHeader(d)
+		return flvtag.NewDecoder(&flvTagGroups)
+	}
+	flvDecodeTag(d, tags)
+	return tags
+}
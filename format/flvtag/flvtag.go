@@ -0,0 +1,553 @@
+package flvtag
+
+// Audio, video and script data tag bodies shared by FLV tags and RTMP messages.
+// Field names and symbols for legacy headers are the ones used by RTMP.
+// https://rtmp.veriskope.com/pdf/video_file_format_spec_v10.pdf
+// https://veovera.org/docs/enhanced/enhanced-rtmp-v2
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+// Groups are the formats used to decode tag data. A format using a Decoder
+// adds Dependencies() to its own dependencies.
+type Groups struct {
+	AMF0     decode.Group
+	AvcDcr   decode.Group
+	AvcAu    decode.Group
+	HevcDcr  decode.Group
+	HevcAu   decode.Group
+	MPEGASC  decode.Group
+	AACFrame decode.Group
+}
+
+func (g *Groups) Dependencies() []decode.Dependency {
+	return []decode.Dependency{
+		{Names: []string{format.AMF0}, Group: &g.AMF0},
+		{Names: []string{format.AVC_DCR}, Group: &g.AvcDcr},
+		{Names: []string{format.AVC_AU}, Group: &g.AvcAu},
+		{Names: []string{format.HEVC_DCR}, Group: &g.HevcDcr},
+		{Names: []string{format.HEVC_AU}, Group: &g.HevcAu},
+		{Names: []string{format.MPEG_ASC}, Group: &g.MPEGASC},
+		{Names: []string{format.AAC_FRAME}, Group: &g.AACFrame},
+	}
+}
+
+// tagTrack is codec configuration from sequence headers used by later tags.
+// Legacy tags and single track enhanced tags use track 0.
+type tagTrack struct {
+	avcAuIn    format.AvcAuIn
+	hevcAuIn   format.HevcAuIn
+	aacFrameIn any
+}
+
+// Decoder decodes tag data and keeps codec configuration between tags
+type Decoder struct {
+	groups      *Groups
+	audioTracks map[uint64]*tagTrack
+	videoTracks map[uint64]*tagTrack
+}
+
+func NewDecoder(groups *Groups) *Decoder {
+	return &Decoder{
+		groups:      groups,
+		audioTracks: map[uint64]*tagTrack{},
+		videoTracks: map[uint64]*tagTrack{},
+	}
+}
+
+func track(tracks map[uint64]*tagTrack, id uint64) *tagTrack {
+	t, ok := tracks[id]
+	if !ok {
+		t = &tagTrack{
+			// no sequence header seen yet, assume common length size
+			avcAuIn:  format.AvcAuIn{LengthSize: 4},
+			hevcAuIn: format.HevcAuIn{LengthSize: 4},
+		}
+		tracks[id] = t
+	}
+	return t
+}
+
+const (
+	audioCodecExHeader = 9
+	audioCodecAAC      = 10
+)
+
+// based on https://github.com/wireshark/wireshark/blob/master/epan/dissectors/packet-rtmpt.c
+// which in turn is based on rtmp and swf specifications and FLV v10.1 section E.4.3.1
+var audioCodecNames = scalar.UintMapSymStr{
+	0:                  "uncompressed",
+	1:                  "adpcm",
+	2:                  "mp3",
+	3:                  "uncompressed_le",
+	4:                  "nellymoser_16khz",
+	5:                  "nellymoser_8khz",
+	6:                  "nellymoser",
+	7:                  "g711a",
+	8:                  "g711u",
+	audioCodecExHeader: "ex_header",
+	audioCodecAAC:      "aac",
+	11:                 "speex",
+	14:                 "mp3_8khz",
+	15:                 "device_specific",
+}
+
+var audioRateNames = scalar.UintMapSymUint{
+	0: 5500,
+	1: 11025,
+	2: 22050,
+	3: 44100,
+}
+
+var audioSampleSize = scalar.UintMapSymUint{
+	0: 8,
+	1: 16,
+}
+
+var audioChannels = scalar.UintMapSymUint{
+	0: 1,
+	1: 2,
+}
+
+const (
+	audioAACPacketTypeASC = 0
+	audioAACPacketTypeRaw = 1
+)
+
+var audioAACPacketTypeNames = scalar.UintMapSymStr{
+	audioAACPacketTypeASC: "asc",
+	audioAACPacketTypeRaw: "raw",
+}
+
+const (
+	videoTypeCommand = 5
+)
+
+var videoTypeNames = scalar.UintMapSymStr{
+	1:                "keyframe",
+	2:                "inter_frame",
+	3:                "disposable_inter_frame",
+	4:                "generated_key_frame",
+	videoTypeCommand: "video_info_or_command_frame",
+}
+
+const (
+	videoCodecH264 = 7
+)
+
+var videoCodecNames = scalar.UintMapSymStr{
+	2:              "h263",
+	3:              "screen_video",
+	4:              "vp6",
+	5:              "vp6_alpha",
+	6:              "screen_video_v2",
+	videoCodecH264: "h264",
+}
+
+const (
+	videoH264PacketTypeDCR   = 0
+	videoH264PacketTypeAU    = 1
+	videoH264PacketTypeEmpty = 2
+)
+
+var videoH264PacketTypeNames = scalar.UintMapSymStr{
+	videoH264PacketTypeDCR:   "dcr",
+	videoH264PacketTypeAU:    "au",
+	videoH264PacketTypeEmpty: "empty",
+}
+
+const (
+	videoPacketTypeSequenceStart        = 0
+	videoPacketTypeCodedFrames          = 1
+	videoPacketTypeSequenceEnd          = 2
+	videoPacketTypeCodedFramesX         = 3
+	videoPacketTypeMetadata             = 4
+	videoPacketTypeMPEG2TSSequenceStart = 5
+	videoPacketTypeMultitrack           = 6
+	videoPacketTypeModEx                = 7
+)
+
+const (
+	audioPacketTypeSequenceStart      = 0
+	audioPacketTypeCodedFrames        = 1
+	audioPacketTypeSequenceEnd        = 2
+	audioPacketTypeMultichannelConfig = 4
+	audioPacketTypeMultitrack         = 5
+	audioPacketTypeModEx              = 7
+)
+
+const (
+	multitrackTypeOneTrack             = 0
+	multitrackTypeManyTracksManyCodecs = 2
+)
+
+const modExTypeTimestampOffsetNano = 0
+
+// mod_ex_data_size is 1-256, 256 means a 16 bit size follows
+const modExDataSizeExtended = 256
+
+const (
+	audioChannelOrderNative = 1
+	audioChannelOrderCustom = 2
+)
+
+var videoPacketTypeNames = scalar.UintMapSymStr{
+	videoPacketTypeSequenceStart:        "sequence_start",
+	videoPacketTypeCodedFrames:          "coded_frames",
+	videoPacketTypeSequenceEnd:          "sequence_end",
+	videoPacketTypeCodedFramesX:         "coded_frames_x",
+	videoPacketTypeMetadata:             "metadata",
+	videoPacketTypeMPEG2TSSequenceStart: "mpeg2ts_sequence_start",
+	videoPacketTypeMultitrack:           "multitrack",
+	videoPacketTypeModEx:                "mod_ex",
+}
+
+var audioPacketTypeNames = scalar.UintMapSymStr{
+	audioPacketTypeSequenceStart:      "sequence_start",
+	audioPacketTypeCodedFrames:        "coded_frames",
+	audioPacketTypeSequenceEnd:        "sequence_end",
+	audioPacketTypeMultichannelConfig: "multichannel_config",
+	audioPacketTypeMultitrack:         "multitrack",
+	audioPacketTypeModEx:              "mod_ex",
+}
+
+var multitrackTypeNames = scalar.UintMapSymStr{
+	multitrackTypeOneTrack:             "one_track",
+	1:                                  "many_tracks",
+	multitrackTypeManyTracksManyCodecs: "many_tracks_many_codecs",
+}
+
+var modExTypeNames = scalar.UintMapSymStr{
+	modExTypeTimestampOffsetNano: "timestamp_offset_nano",
+}
+
+var videoCommandNames = scalar.UintMapSymStr{
+	0: "start_seek",
+	1: "end_seek",
+}
+
+var audioChannelOrderNames = scalar.UintMapSymStr{
+	0:                       "unspecified",
+	audioChannelOrderNative: "native",
+	audioChannelOrderCustom: "custom",
+}
+
+var audioChannelNames = scalar.UintMapSymStr{
+	0:    "front_left",
+	1:    "front_right",
+	2:    "front_center",
+	3:    "low_frequency1",
+	4:    "back_left",
+	5:    "back_right",
+	6:    "front_left_center",
+	7:    "front_right_center",
+	8:    "back_center",
+	9:    "side_left",
+	10:   "side_right",
+	11:   "top_center",
+	12:   "top_front_left",
+	13:   "top_front_center",
+	14:   "top_front_right",
+	15:   "top_back_left",
+	16:   "top_back_center",
+	17:   "top_back_right",
+	18:   "low_frequency2",
+	19:   "top_side_left",
+	20:   "top_side_right",
+	21:   "bottom_front_center",
+	22:   "bottom_front_left",
+	23:   "bottom_front_right",
+	0xfe: "unused",
+	0xff: "unknown",
+}
+
+const (
+	fourCCAVC  = "avc1"
+	fourCCHEVC = "hvc1"
+	fourCCAAC  = "mp4a"
+)
+
+var fourCCNames = scalar.StrMapDescription{
+	fourCCAVC:  "H.264/AVC",
+	fourCCHEVC: "H.265/HEVC",
+	"av01":     "AV1",
+	"vp08":     "VP8",
+	"vp09":     "VP9",
+	fourCCAAC:  "AAC",
+	".mp3":     "MP3",
+	"ac-3":     "AC-3",
+	"ec-3":     "E-AC-3",
+	"fLaC":     "FLAC",
+	"Opus":     "Opus",
+}
+
+func (td *Decoder) decodeAVCSequenceHeader(d *decode.D, t *tagTrack) {
+	_, v := d.FieldFormatOrRawLen("data", d.BitsLeft(), td.groups.AvcDcr, nil)
+	if avcDcrOut, ok := v.(format.AvcDcrOut); ok {
+		t.avcAuIn = format.AvcAuIn{LengthSize: avcDcrOut.LengthSize} //nolint:gosimple
+	}
+}
+
+func (td *Decoder) decodeHEVCSequenceHeader(d *decode.D, t *tagTrack) {
+	_, v := d.FieldFormatOrRawLen("data", d.BitsLeft(), td.groups.HevcDcr, nil)
+	if hevcDcrOut, ok := v.(format.HevcDcrOut); ok {
+		t.hevcAuIn = format.HevcAuIn{LengthSize: hevcDcrOut.LengthSize} //nolint:gosimple
+	}
+}
+
+func (td *Decoder) decodeAACSequenceHeader(d *decode.D, t *tagTrack) {
+	_, v := d.FieldFormatOrRawLen("data", d.BitsLeft(), td.groups.MPEGASC, nil)
+	if mpegASCOut, ok := v.(format.MPEGASCOut); ok {
+		t.aacFrameIn = format.AACFrameIn{ObjectType: mpegASCOut.ObjectType}
+	}
+}
+
+// decodeModEx decodes mod_ex entries and returns the packet type following them
+func decodeModEx(d *decode.D, packetType uint64, modExPacketType uint64, packetTypeNames scalar.UintMapSymStr) uint64 {
+	if packetType != modExPacketType {
+		return packetType
+	}
+	d.FieldArray("mod_exs", func(d *decode.D) {
+		for packetType == modExPacketType {
+			d.FieldStruct("mod_ex", func(d *decode.D) {
+				modExDataSize := d.FieldU8("mod_ex_data_size", scalar.UintActualAdd(1))
+				if modExDataSize == modExDataSizeExtended {
+					modExDataSize = d.FieldU16("mod_ex_data_size_extended", scalar.UintActualAdd(1))
+				}
+				// type is after data
+				var modExType uint64
+				d.RangeFn(d.Pos()+int64(modExDataSize)*8, 4, func(d *decode.D) {
+					modExType = d.U4()
+				})
+				d.FramedFn(int64(modExDataSize)*8, func(d *decode.D) {
+					if modExType == modExTypeTimestampOffsetNano && d.BitsLeft() >= 24 {
+						d.FieldU24("timestamp_offset_nano")
+					}
+					if d.BitsLeft() > 0 {
+						d.FieldRawLen("mod_ex_data", d.BitsLeft())
+					}
+				})
+				d.FieldU4("mod_ex_type", modExTypeNames)
+				packetType = d.FieldU4("packet_type", packetTypeNames)
+			})
+		}
+	})
+	return packetType
+}
+
+// decodeTracks decodes one track body or, for multitrack packets, all tracks
+// with their fourcc, track id and size prefix
+func decodeTracks(d *decode.D, multitrack bool, multitrackType uint64, fourCC string, fn func(d *decode.D, trackID uint64, fourCC string)) {
+	if !multitrack {
+		fn(d, 0, fourCC)
+		return
+	}
+
+	d.FieldArray("tracks", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("track", func(d *decode.D) {
+				fourCC := fourCC
+				if multitrackType == multitrackTypeManyTracksManyCodecs {
+					fourCC = d.FieldUTF8("fourcc", 4, fourCCNames)
+				}
+				trackID := d.FieldU8("track_id")
+				if multitrackType == multitrackTypeOneTrack {
+					fn(d, trackID, fourCC)
+					return
+				}
+				sizeOfTrack := d.FieldU24("size_of_track")
+				d.FramedFn(int64(sizeOfTrack)*8, func(d *decode.D) {
+					fn(d, trackID, fourCC)
+				})
+			})
+			if multitrackType == multitrackTypeOneTrack {
+				break
+			}
+		}
+	})
+}
+
+// DecodeAudioData decodes an AUDIODATA tag body or RTMP audio message
+func (td *Decoder) DecodeAudioData(d *decode.D) {
+	codec := d.FieldU4("codec", audioCodecNames)
+	if codec == audioCodecExHeader {
+		td.decodeExAudioData(d)
+		return
+	}
+
+	d.FieldU2("sample_rate", audioRateNames)
+	d.FieldU1("sample_size", audioSampleSize)
+	d.FieldU1("channels", audioChannels)
+	if codec != audioCodecAAC {
+		d.FieldRawLen("data", d.BitsLeft())
+		return
+	}
+
+	t := track(td.audioTracks, 0)
+	switch d.FieldU8("type", audioAACPacketTypeNames) {
+	case audioAACPacketTypeASC:
+		td.decodeAACSequenceHeader(d, t)
+	case audioAACPacketTypeRaw:
+		d.FieldFormatOrRawLen("data", d.BitsLeft(), td.groups.AACFrame, t.aacFrameIn)
+	default:
+		d.FieldRawLen("data", d.BitsLeft())
+	}
+}
+
+func (td *Decoder) decodeExAudioData(d *decode.D) {
+	packetType := d.FieldU4("audio_packet_type", audioPacketTypeNames)
+	packetType = decodeModEx(d, packetType, audioPacketTypeModEx, audioPacketTypeNames)
+
+	var fourCC string
+	var multitrackType uint64
+	multitrack := packetType == audioPacketTypeMultitrack
+	if multitrack {
+		d.FieldStruct("multitrack", func(d *decode.D) {
+			multitrackType = d.FieldU4("multitrack_type", multitrackTypeNames)
+			packetType = d.FieldU4("packet_type", audioPacketTypeNames)
+			if multitrackType != multitrackTypeManyTracksManyCodecs {
+				fourCC = d.FieldUTF8("fourcc", 4, fourCCNames)
+			}
+		})
+	} else {
+		fourCC = d.FieldUTF8("fourcc", 4, fourCCNames)
+	}
+
+	decodeTracks(d, multitrack, multitrackType, fourCC, func(d *decode.D, trackID uint64, fourCC string) {
+		t := track(td.audioTracks, trackID)
+
+		switch packetType {
+		case audioPacketTypeSequenceStart:
+			if fourCC == fourCCAAC {
+				td.decodeAACSequenceHeader(d, t)
+				return
+			}
+		case audioPacketTypeCodedFrames:
+			if fourCC == fourCCAAC {
+				d.FieldFormatOrRawLen("data", d.BitsLeft(), td.groups.AACFrame, t.aacFrameIn)
+				return
+			}
+		case audioPacketTypeSequenceEnd:
+			return
+		case audioPacketTypeMultichannelConfig:
+			channelOrder := d.FieldU8("audio_channel_order", audioChannelOrderNames)
+			channelCount := d.FieldU8("channel_count")
+			switch channelOrder {
+			case audioChannelOrderCustom:
+				d.FieldArray("audio_channel_mapping", func(d *decode.D) {
+					for i := uint64(0); i < channelCount; i++ {
+						d.FieldU8("channel", audioChannelNames)
+					}
+				})
+			case audioChannelOrderNative:
+				d.FieldU32("audio_channel_flags", scalar.UintHex)
+			}
+			return
+		}
+		d.FieldRawLen("data", d.BitsLeft())
+	})
+}
+
+// DecodeVideoData decodes a VIDEODATA tag body or RTMP video message
+func (td *Decoder) DecodeVideoData(d *decode.D) {
+	// legacy frame type is 4 bits but frame types only use 3 bits
+	if d.PeekUintBits(1) == 1 {
+		d.FieldBool("is_ex_header")
+		td.decodeExVideoData(d)
+		return
+	}
+	d.FieldU4("type", videoTypeNames)
+	codec := d.FieldU4("codec", videoCodecNames)
+	if codec != videoCodecH264 {
+		d.FieldRawLen("data", d.BitsLeft())
+		return
+	}
+
+	t := track(td.videoTracks, 0)
+	h264PacketType := d.FieldU8("h264_packet_type", videoH264PacketTypeNames)
+	d.FieldS24("composition_time")
+	switch h264PacketType {
+	case videoH264PacketTypeDCR:
+		td.decodeAVCSequenceHeader(d, t)
+	case videoH264PacketTypeAU:
+		d.FieldFormatOrRawLen("data", d.BitsLeft(), td.groups.AvcAu, t.avcAuIn)
+	case videoH264PacketTypeEmpty:
+		// nop
+	default:
+		d.FieldRawLen("data", d.BitsLeft())
+	}
+}
+
+func (td *Decoder) decodeExVideoData(d *decode.D) {
+	videoType := d.FieldU3("type", videoTypeNames)
+	packetType := d.FieldU4("video_packet_type", videoPacketTypeNames)
+	packetType = decodeModEx(d, packetType, videoPacketTypeModEx, videoPacketTypeNames)
+
+	if packetType != videoPacketTypeMetadata && videoType == videoTypeCommand {
+		d.FieldU8("video_command", videoCommandNames)
+		return
+	}
+
+	var fourCC string
+	var multitrackType uint64
+	multitrack := packetType == videoPacketTypeMultitrack
+	if multitrack {
+		d.FieldStruct("multitrack", func(d *decode.D) {
+			multitrackType = d.FieldU4("multitrack_type", multitrackTypeNames)
+			packetType = d.FieldU4("packet_type", videoPacketTypeNames)
+			if multitrackType != multitrackTypeManyTracksManyCodecs {
+				fourCC = d.FieldUTF8("fourcc", 4, fourCCNames)
+			}
+		})
+	} else {
+		fourCC = d.FieldUTF8("fourcc", 4, fourCCNames)
+	}
+
+	decodeTracks(d, multitrack, multitrackType, fourCC, func(d *decode.D, trackID uint64, fourCC string) {
+		t := track(td.videoTracks, trackID)
+
+		switch packetType {
+		case videoPacketTypeSequenceStart:
+			switch fourCC {
+			case fourCCAVC:
+				td.decodeAVCSequenceHeader(d, t)
+				return
+			case fourCCHEVC:
+				td.decodeHEVCSequenceHeader(d, t)
+				return
+			}
+		case videoPacketTypeCodedFrames, videoPacketTypeCodedFramesX:
+			if fourCC != fourCCAVC && fourCC != fourCCHEVC {
+				break
+			}
+			// only coded frames has composition time, coded frames x implies zero
+			if packetType == videoPacketTypeCodedFrames {
+				d.FieldS24("composition_time")
+			}
+			if fourCC == fourCCAVC {
+				d.FieldFormatOrRawLen("data", d.BitsLeft(), td.groups.AvcAu, t.avcAuIn)
+			} else {
+				d.FieldFormatOrRawLen("data", d.BitsLeft(), td.groups.HevcAu, t.hevcAuIn)
+			}
+			return
+		case videoPacketTypeSequenceEnd:
+			return
+		case videoPacketTypeMetadata:
+			td.DecodeScriptData(d)
+			return
+		}
+		d.FieldRawLen("data", d.BitsLeft())
+	})
+}
+
+// DecodeScriptData decodes a SCRIPTDATA tag body, a sequence of AMF0 values
+func (td *Decoder) DecodeScriptData(d *decode.D) {
+	d.FieldArray("values", func(d *decode.D) {
+		for !d.End() {
+			d.FieldFormat("value", td.groups.AMF0, nil)
+		}
+	})
+}
@@ -19,12 +19,14 @@ const (
 	IP_PACKET      = "ip_packet"   // ex: tcp
 	TCP_STREAM     = "tcp_stream"  // ex: http
 	UDP_PAYLOAD    = "udp_payload" // ex: dns
+	UDP_FLOW       = "udp_flow"    // ex: quic
 	MP3_FRAME_TAGS = "mp3_frame_tags"
 
 	BYTES = "bytes"
 	BITS  = "bits"
 
 	AAC_FRAME           = "aac_frame"
+	AC3_FRAME           = "ac3_frame"
 	ADTS                = "adts"
 	ADTS_FRAME          = "adts_frame"
 	AIFF                = "aiff"
@@ -32,6 +34,7 @@ const (
 	APEV2               = "apev2"
 	APPLE_BOOKMARK      = "apple_bookmark"
 	AR                  = "ar"
+	ARM64               = "arm64"
 	ASN1_BER            = "asn1_ber"
 	AV1_CCR             = "av1_ccr"
 	AV1_FRAME           = "av1_frame"
@@ -55,9 +58,11 @@ const (
 	BSON                = "bson"
 	BZIP2               = "bzip2"
 	CBOR                = "cbor"
+	COFF                = "coff"
 	CSV                 = "csv"
 	DNS                 = "dns"
 	DNS_TCP             = "dns_tcp"
+	EAC3_FRAME          = "eac3_frame"
 	ELF                 = "elf"
 	ETHER8023_FRAME     = "ether8023_frame"
 	EXIF                = "exif"
@@ -68,8 +73,9 @@ const (
 	FLAC_METADATABLOCKS = "flac_metadatablocks"
 	FLAC_PICTURE        = "flac_picture"
 	FLAC_STREAMINFO     = "flac_streaminfo"
-	FLV                 = "flv" // TODO:
+	FLV                 = "flv"
 	GIF                 = "gif"
+	GRPC                = "grpc"
 	GZIP                = "gzip"
 	HEVC_ANNEXB         = "hevc_annexb"
 	HEVC_AU             = "hevc_au"
@@ -79,6 +85,8 @@ const (
 	HEVC_SPS            = "hevc_sps"
 	HEVC_VPS            = "hevc_vps"
 	HTML                = "html"
+	HTTP                = "http"
+	HTTP2               = "http2"
 	ICC_PROFILE         = "icc_profile"
 	ICMP                = "icmp"
 	ICMPV6              = "icmpv6"
@@ -90,6 +98,7 @@ const (
 	JPEG                = "jpeg"
 	JSON                = "json"
 	JSONL               = "jsonl"
+	KAITAI              = "kaitai"
 	MACHO               = "macho"
 	MACHO_FAT           = "macho_fat"
 	MARKDOWN            = "markdown"
@@ -103,6 +112,7 @@ const (
 	MPEG_ES             = "mpeg_es"
 	MPEG_PES            = "mpeg_pes"
 	MPEG_PES_PACKET     = "mpeg_pes_packet"
+	MPEG_PS             = "mpeg_ps"
 	MPEG_SPU            = "mpeg_spu"
 	MPEG_TS             = "mpeg_ts"
 	MSGPACK             = "msgpack"
@@ -111,11 +121,14 @@ const (
 	OPUS_PACKET         = "opus_packet"
 	PCAP                = "pcap"
 	PCAPNG              = "pcapng"
+	PE                  = "pe"
 	PNG                 = "png"
 	PRORES_FRAME        = "prores_frame"
 	PROTOBUF            = "protobuf"
 	PROTOBUF_WIDEVINE   = "protobuf_widevine"
 	PSSH_PLAYREADY      = "pssh_playready"
+	QUIC                = "quic"
+	RISCV64             = "riscv64"
 	RTMP                = "rtmp"
 	SLL_PACKET          = "sll_packet"
 	SLL2_PACKET         = "sll2_packet"
@@ -132,9 +145,19 @@ const (
 	VP9_CFM             = "vp9_cfm"
 	VP9_FRAME           = "vp9_frame"
 	VPX_CCR             = "vpx_ccr"
+	VVC_ANNEXB          = "vvc_annexb"
+	VVC_APS             = "vvc_aps"
+	VVC_AU              = "vvc_au"
+	VVC_DCR             = "vvc_dcr"
+	VVC_NALU            = "vvc_nalu"
+	VVC_PH              = "vvc_ph"
+	VVC_PPS             = "vvc_pps"
+	VVC_SPS             = "vvc_sps"
+	VVC_VPS             = "vvc_vps"
 	WASM                = "wasm"
 	WAV                 = "wav"
 	WEBP                = "webp"
+	X86_64              = "x86_64"
 	XML                 = "xml"
 	YAML                = "yaml"
 	ZIP                 = "zip"
@@ -201,14 +224,28 @@ type HevcDcrOut struct {
 	LengthSize uint64
 }
 
+type VvcAuIn struct {
+	LengthSize uint64 `doc:"Length value size"`
+}
+
+type VvcDcrOut struct {
+	LengthSize uint64
+}
+
 type ProtoBufIn struct {
-	Message ProtoBufMessage
+	Message     ProtoBufMessage
+	Schema      string `doc:".proto source or FileDescriptorSet"`
+	MessageName string `doc:"Fully qualified message name, default first message in schema"`
 }
 
 type MatroskaIn struct {
 	DecodeSamples bool `doc:"Decode samples"`
 }
 
+type MpegPesPacketIn struct {
+	DvdSubstreams bool `doc:"Private stream 1 payload has DVD sub stream header"`
+}
+
 type MpegDecoderConfig struct {
 	ObjectType    int
 	ASCObjectType int
@@ -274,6 +311,19 @@ func (u UDPPayloadIn) MustIsPort(fn func(format string, a ...any), ports ...int)
 	}
 }
 
+// UDPFlowIn describes the datagrams of a UDP flow, the decoded bytes are the
+// datagram payloads concatenated in capture order
+type UDPFlowIn struct {
+	ClientPort int
+	ServerPort int
+	Datagrams  []UDPFlowDatagram
+}
+
+type UDPFlowDatagram struct {
+	IsClient bool
+	Length   int // in bytes
+}
+
 type TCPStreamIn struct {
 	IsClient        bool
 	HasStart        bool
@@ -305,14 +355,23 @@ func (t TCPStreamIn) MustIsPort(fn func(format string, a ...any), ports ...int)
 }
 
 type Mp4In struct {
-	DecodeSamples  bool `doc:"Decode samples"`
-	AllowTruncated bool `doc:"Allow box to be truncated"`
+	DecodeSamples  bool              `doc:"Decode samples"`
+	AllowTruncated bool              `doc:"Allow box to be truncated"`
+	DecryptionKeys map[string]string `doc:"Map of hex KID to hex key to decrypt samples"`
 }
 
 type AviIn struct {
 	DecodeSamples bool `doc:"Decode samples"`
 }
 
+type JpegIn struct {
+	DecodeScans bool `doc:"Huffman decode entropy coded scan data"`
+}
+
+type PngIn struct {
+	Unfilter bool `doc:"Unfilter image data into pixel rows"`
+}
+
 type ZipIn struct {
 	Uncompress bool `doc:"Uncompress and probe files"`
 }
@@ -341,3 +400,46 @@ type BitCoinBlockIn struct {
 type TLSIn struct {
 	Keylog string `doc:"NSS Key Log content"`
 }
+
+// TLSHandshakeIn decodes handshake messages without record layer, ex: QUIC CRYPTO frames
+type TLSHandshakeIn struct{}
+
+type QUICIn struct {
+	Keylog string `doc:"NSS Key Log content"`
+}
+
+type GRPCIn struct {
+	Schema     string `doc:"Protobuf schema with services, .proto source or FileDescriptorSet"`
+	Path       string `doc:"Method path, ex: /package.Service/Method"`
+	IsResponse bool   `doc:"Messages are responses"`
+	Encoding   string `doc:"Message encoding, ex: gzip"`
+}
+
+type KaitaiIn struct {
+	Schema string `doc:"Kaitai Struct schema (.ksy) YAML"`
+}
+
+// AsmIn is used by the machine code formats, Symbols maps addresses to names
+// that are used for labels and branch targets. Relocations maps address of
+// relocated bytes to symbol name, used for unlinked code.
+type AsmIn struct {
+	Base        uint64            `doc:"Address of first instruction"`
+	Symbols     map[uint64]string `doc:"Map of address to symbol name used for labels and branch targets"`
+	Relocations map[uint64]string `doc:"Map of address of relocated bytes to symbol name"`
+}
+
+type ElfIn struct {
+	Disassemble bool `doc:"Disassemble executable sections"`
+}
+
+type MachoIn struct {
+	Disassemble bool `doc:"Disassemble executable sections"`
+}
+
+type PeIn struct {
+	Disassemble bool `doc:"Disassemble executable sections"`
+}
+
+type CoffIn struct {
+	Disassemble bool `doc:"Disassemble executable sections"`
+}
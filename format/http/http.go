@@ -0,0 +1,542 @@
+package http
+
+// https://www.rfc-editor.org/rfc/rfc9112
+// https://www.rfc-editor.org/rfc/rfc9110
+
+// TODO: multipart bodies
+// TODO: brotli content encoding
+// TODO: decode upgraded connections, ex: websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"embed"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed http.jq
+//go:embed http.md
+var httpFS embed.FS
+
+var probeGroup decode.Group
+var jsonGroup decode.Group
+var xmlGroup decode.Group
+var htmlGroup decode.Group
+var protobufGroup decode.Group
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:        format.HTTP,
+		Description: "Hypertext Transfer Protocol 1.x",
+		Groups:      []string{format.TCP_STREAM},
+		DecodeFn:    httpDecode,
+		Dependencies: []decode.Dependency{
+			{Names: []string{format.PROBE}, Group: &probeGroup},
+			{Names: []string{format.JSON}, Group: &jsonGroup},
+			{Names: []string{format.XML}, Group: &xmlGroup},
+			{Names: []string{format.HTML}, Group: &htmlGroup},
+			{Names: []string{format.PROTOBUF}, Group: &protobufGroup},
+		},
+	})
+	interp.RegisterFS(httpFS)
+}
+
+// longest request, status or header line
+const maxLineLength = 64 * 1024
+
+var methods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"DELETE":  true,
+	"CONNECT": true,
+	"OPTIONS": true,
+	"TRACE":   true,
+	"PATCH":   true,
+	// WebDAV
+	"PROPFIND":  true,
+	"PROPPATCH": true,
+	"MKCOL":     true,
+	"COPY":      true,
+	"MOVE":      true,
+	"LOCK":      true,
+	"UNLOCK":    true,
+}
+
+// https://www.iana.org/assignments/http-status-codes/http-status-codes.xhtml
+var statusCodeNames = scalar.UintMapDescription{
+	100: "Continue",
+	101: "Switching Protocols",
+	102: "Processing",
+	103: "Early Hints",
+	200: "OK",
+	201: "Created",
+	202: "Accepted",
+	203: "Non-Authoritative Information",
+	204: "No Content",
+	205: "Reset Content",
+	206: "Partial Content",
+	207: "Multi-Status",
+	300: "Multiple Choices",
+	301: "Moved Permanently",
+	302: "Found",
+	303: "See Other",
+	304: "Not Modified",
+	307: "Temporary Redirect",
+	308: "Permanent Redirect",
+	400: "Bad Request",
+	401: "Unauthorized",
+	403: "Forbidden",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	406: "Not Acceptable",
+	408: "Request Timeout",
+	409: "Conflict",
+	410: "Gone",
+	411: "Length Required",
+	412: "Precondition Failed",
+	413: "Content Too Large",
+	414: "URI Too Long",
+	415: "Unsupported Media Type",
+	416: "Range Not Satisfiable",
+	417: "Expectation Failed",
+	421: "Misdirected Request",
+	422: "Unprocessable Content",
+	426: "Upgrade Required",
+	429: "Too Many Requests",
+	500: "Internal Server Error",
+	501: "Not Implemented",
+	502: "Bad Gateway",
+	503: "Service Unavailable",
+	504: "Gateway Timeout",
+	505: "HTTP Version Not Supported",
+}
+
+type header struct {
+	name  string
+	value string
+}
+
+type headers []header
+
+func (hs headers) get(name string) (string, bool) {
+	for _, h := range hs {
+		if strings.EqualFold(h.name, name) {
+			return h.value, true
+		}
+	}
+	return "", false
+}
+
+// request info needed to decode response
+type request struct {
+	method string
+}
+
+type httpCtx struct {
+	requests []request
+}
+
+// returns line without line ending and length including line ending
+func peekLine(d *decode.D) (string, int64, bool) {
+	n := d.BitsLeft() / 8
+	if n > maxLineLength {
+		n = maxLineLength
+	}
+	b := d.PeekBytes(int(n))
+	i := bytes.IndexByte(b, '\n')
+	if i == -1 {
+		return "", 0, false
+	}
+	return strings.TrimSuffix(string(b[0:i]), "\r"), int64(i) + 1, true
+}
+
+// request line: method SP request-target SP HTTP-version
+func parseRequestLine(line string) ([]string, bool) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) != 3 || !methods[parts[0]] || parts[1] == "" || !strings.HasPrefix(parts[2], "HTTP/1.") {
+		return nil, false
+	}
+	return parts, true
+}
+
+// status line: HTTP-version SP status-code SP [reason-phrase]
+func isStatusLine(line string) bool {
+	version, rest, _ := strings.Cut(line, " ")
+	if !strings.HasPrefix(version, "HTTP/1.") || len(rest) < 3 || (len(rest) > 3 && rest[3] != ' ') {
+		return false
+	}
+	for _, c := range rest[0:3] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// add string field covering s and n bytes of delimiter after it
+func fieldStrDelim(d *decode.D, name string, s string, delimLen int, sms ...scalar.StrMapper) string {
+	return d.FieldStrFn(name, func(d *decode.D) string {
+		d.SeekRel(int64(len(s)+delimLen) * 8)
+		return s
+	}, sms...)
+}
+
+func decodeHeaders(d *decode.D) headers {
+	var hs headers
+	d.FieldArray("headers", func(d *decode.D) {
+		for {
+			line, n, ok := peekLine(d)
+			if !ok {
+				d.Fatalf("header line not found")
+			}
+			if line == "" {
+				return
+			}
+			d.FieldStruct("header", func(d *decode.D) {
+				i := strings.IndexByte(line, ':')
+				if i <= 0 {
+					d.Fatalf("invalid header line %q", line)
+				}
+				name := line[0:i]
+				rest := line[i+1:]
+				value := strings.TrimLeft(rest, " \t")
+				fieldStrDelim(d, "name", name, 1+len(rest)-len(value))
+				trimmed := strings.TrimRight(value, " \t")
+				fieldStrDelim(d, "value", trimmed, len(value)-len(trimmed)+int(n)-len(line))
+				hs = append(hs, header{name: name, value: trimmed})
+			})
+		}
+	})
+	_, n, _ := peekLine(d)
+	d.FieldUTF8("header_end", int(n))
+
+	return hs
+}
+
+func contentGroup(contentType string) *decode.Group {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json",
+		strings.HasSuffix(mediaType, "+json"):
+		return &jsonGroup
+	case mediaType == "application/xml",
+		mediaType == "text/xml",
+		strings.HasSuffix(mediaType, "+xml"):
+		return &xmlGroup
+	case mediaType == "text/html":
+		return &htmlGroup
+	case mediaType == "application/protobuf",
+		mediaType == "application/x-protobuf",
+		mediaType == "application/vnd.google.protobuf":
+		return &protobufGroup
+	case strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "audio/"),
+		strings.HasPrefix(mediaType, "video/"),
+		mediaType == "application/octet-stream":
+		return &probeGroup
+	default:
+		return nil
+	}
+}
+
+func decodeContentEncoding(encoding string, b []byte) ([]byte, bool) {
+	var r io.Reader
+	var err error
+	switch strings.ToLower(encoding) {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(b))
+	case "deflate":
+		// should be zlib but some implementations use raw deflate
+		r, err = zlib.NewReader(bytes.NewReader(b))
+		if err != nil {
+			r, err = flate.NewReader(bytes.NewReader(b)), nil
+		}
+	default:
+		return nil, false
+	}
+	if err != nil {
+		return nil, false
+	}
+	ub, err := io.ReadAll(r)
+	if err != nil {
+		return nil, false
+	}
+	return ub, true
+}
+
+// decode chunked transfer coding and return body
+func decodeChunks(d *decode.D) []byte {
+	body := &bytes.Buffer{}
+	d.FieldArray("chunks", func(d *decode.D) {
+		for {
+			line, n, ok := peekLine(d)
+			if !ok {
+				d.Fatalf("chunk size line not found")
+			}
+			size, _, _ := strings.Cut(line, ";")
+			l, err := strconv.ParseUint(strings.TrimSpace(size), 16, 63)
+			if err != nil {
+				d.Fatalf("invalid chunk size %q", line)
+			}
+			d.FieldStruct("chunk", func(d *decode.D) {
+				d.FieldUintFn("size", func(d *decode.D) uint64 {
+					d.SeekRel(n * 8)
+					return l
+				})
+				if l == 0 {
+					return
+				}
+				body.Write(d.PeekBytes(int(l)))
+				d.FieldRawLen("data", int64(l)*8)
+				_, n, _ := peekLine(d)
+				d.FieldUTF8("data_end", int(n), d.StrAssert("\r\n", "\n"))
+			})
+			if l == 0 {
+				break
+			}
+		}
+	})
+	// optional trailer fields
+	d.FieldStruct("trailer", func(d *decode.D) {
+		decodeHeaders(d)
+	})
+
+	return body.Bytes()
+}
+
+func decodeBody(d *decode.D, hs headers, length int64, isChunked bool) {
+	contentType, _ := hs.get("Content-Type")
+	contentEncoding, _ := hs.get("Content-Encoding")
+	group := contentGroup(contentType)
+
+	var body []byte
+	switch {
+	case isChunked:
+		body = decodeChunks(d)
+	case contentEncoding != "" && !strings.EqualFold(contentEncoding, "identity"):
+		body = d.PeekBytes(int(length))
+		d.FieldRawLen("encoded_body", length*8)
+	case group != nil:
+		// body is decoded in place in stream
+		d.FieldFormatOrRawLen("body", length*8, *group, nil)
+		return
+	default:
+		d.FieldRawLen("body", length*8)
+		return
+	}
+
+	if contentEncoding != "" {
+		if ub, ok := decodeContentEncoding(contentEncoding, body); ok {
+			body = ub
+		}
+	}
+	if len(body) == 0 {
+		return
+	}
+	br := bitio.NewBitReader(body, -1)
+	if group != nil {
+		if dv, _, _ := d.TryFieldFormatBitBuf("body", br, *group, nil); dv != nil {
+			return
+		}
+	}
+	d.FieldRootBitBuf("body", br)
+}
+
+// https://www.rfc-editor.org/rfc/rfc9112#section-6.3
+func decodeRequest(d *decode.D, parts []string, n int64) request {
+	r := request{method: parts[0]}
+	fieldStrDelim(d, "method", parts[0], 1)
+	fieldStrDelim(d, "target", parts[1], 1)
+	fieldStrDelim(d, "version", parts[2], int(n)-len(parts[0])-len(parts[1])-len(parts[2])-2)
+	hs := decodeHeaders(d)
+
+	transferEncoding, _ := hs.get("Transfer-Encoding")
+	contentLength, hasContentLength := hs.get("Content-Length")
+	switch {
+	case strings.HasSuffix(strings.ToLower(transferEncoding), "chunked"):
+		decodeBody(d, hs, 0, true)
+	case hasContentLength:
+		l, err := strconv.ParseInt(strings.TrimSpace(contentLength), 10, 64)
+		if err != nil || l < 0 {
+			d.Fatalf("invalid content-length %q", contentLength)
+		}
+		if l > 0 {
+			decodeBody(d, hs, l, false)
+		}
+	}
+
+	return r
+}
+
+// returns status code and if rest of stream is a tunnel or another protocol
+func decodeResponse(d *decode.D, line string, n int64, req *request) (uint64, bool) {
+	version, rest, _ := strings.Cut(line, " ")
+	fieldStrDelim(d, "version", version, 1)
+	status := d.FieldUintFn("status_code", func(d *decode.D) uint64 {
+		s, _ := strconv.ParseUint(rest[0:3], 10, 64)
+		d.SeekRel(3 * 8)
+		return s
+	}, statusCodeNames)
+	// reason phrase is optional
+	d.FieldStrFn("reason", func(d *decode.D) string {
+		d.SeekRel((n - int64(len(version)) - 1 - 3) * 8)
+		return strings.TrimPrefix(rest[3:], " ")
+	})
+	hs := decodeHeaders(d)
+
+	method := ""
+	if req != nil {
+		method = req.method
+	}
+
+	transferEncoding, _ := hs.get("Transfer-Encoding")
+	contentLength, hasContentLength := hs.get("Content-Length")
+	switch {
+	case method == "HEAD",
+		status >= 100 && status < 200 && status != 101,
+		status == 204,
+		status == 304:
+		// no body
+	case status == 101,
+		method == "CONNECT" && status >= 200 && status < 300:
+		return status, true
+	case strings.HasSuffix(strings.ToLower(transferEncoding), "chunked"):
+		decodeBody(d, hs, 0, true)
+	case hasContentLength:
+		l, err := strconv.ParseInt(strings.TrimSpace(contentLength), 10, 64)
+		if err != nil || l < 0 {
+			d.Fatalf("invalid content-length %q", contentLength)
+		}
+		if l > 0 {
+			decodeBody(d, hs, l, false)
+		}
+	default:
+		// body until connection is closed
+		if d.BitsLeft() > 0 {
+			decodeBody(d, hs, d.BitsLeft()/8, false)
+		}
+	}
+
+	return status, false
+}
+
+func decodeRequests(d *decode.D, hc *httpCtx) {
+	d.FieldArray("messages", func(d *decode.D) {
+		for !d.End() {
+			line, n, ok := peekLine(d)
+			if !ok {
+				break
+			}
+			parts, ok := parseRequestLine(line)
+			if !ok {
+				break
+			}
+			d.FieldStruct("request", func(d *decode.D) {
+				hc.requests = append(hc.requests, decodeRequest(d, parts, n))
+			})
+		}
+	})
+	if len(hc.requests) == 0 {
+		d.Fatalf("no requests found")
+	}
+	// tunneled connection
+	if d.BitsLeft() > 0 && hc.requests[len(hc.requests)-1].method == "CONNECT" {
+		d.FieldRawLen("tunnel", d.BitsLeft())
+	}
+}
+
+// requests can be nil if not known
+func decodeResponses(d *decode.D, requests []request) {
+	requestIndex := 0
+	isTunnel := false
+	d.FieldArray("messages", func(d *decode.D) {
+		for !d.End() {
+			line, n, ok := peekLine(d)
+			if !ok {
+				break
+			}
+			if !isStatusLine(line) {
+				break
+			}
+			var req *request
+			if requestIndex < len(requests) {
+				req = &requests[requestIndex]
+			}
+			var status uint64
+			d.FieldStruct("response", func(d *decode.D) {
+				status, isTunnel = decodeResponse(d, line, n, req)
+			})
+			// informational 1xx responses are followed by more responses for same request
+			if status < 100 || status >= 200 || status == 101 {
+				requestIndex++
+			}
+			if isTunnel {
+				break
+			}
+		}
+	})
+	if isTunnel && d.BitsLeft() > 0 {
+		d.FieldRawLen("tunnel", d.BitsLeft())
+	}
+}
+
+func httpDecode(d *decode.D) any {
+	var tsi format.TCPStreamIn
+	hasTsi := d.ArgAs(&tsi)
+	if hasTsi && !tsi.HasStart {
+		d.Fatalf("http requires start of byte stream")
+	}
+
+	line, _, ok := peekLine(d)
+	if !ok {
+		d.Fatalf("no request or status line found")
+	}
+	_, isRequest := parseRequestLine(line)
+	isResponse := isStatusLine(line)
+	switch {
+	case !isRequest && !isResponse:
+		d.Fatalf("no request or status line found")
+	case hasTsi && tsi.IsClient != isRequest:
+		d.Fatalf("request or response in wrong direction")
+	}
+
+	if isRequest {
+		hc := &httpCtx{}
+		decodeRequests(d, hc)
+		if hasTsi {
+			return format.TCPStreamOut{InArg: hc}
+		}
+		return nil
+	}
+
+	// responses are decoded after pairing to know request methods, ex: response
+	// to HEAD has no body
+	if hasTsi {
+		return format.TCPStreamOut{
+			PostFn: func(peerIn any) {
+				var requests []request
+				if clientHc, ok := peerIn.(*httpCtx); ok {
+					requests = clientHc.requests
+				}
+				d.PostDecodeFn(func(d *decode.D) { decodeResponses(d, requests) })
+			},
+			InArg: &httpCtx{},
+		}
+	}
+	decodeResponses(d, nil)
+
+	return nil
+}
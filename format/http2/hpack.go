@@ -0,0 +1,248 @@
+package http2
+
+// HPACK header compression
+// https://www.rfc-editor.org/rfc/rfc7541
+
+import (
+	"github.com/wader/fq/pkg/decode"
+	"golang.org/x/net/http2/hpack"
+)
+
+type headerField struct {
+	name  string
+	value string
+}
+
+// entry size is name and value length plus 32 bytes overhead
+func (hf headerField) size() int { return len(hf.name) + len(hf.value) + 32 }
+
+// https://www.rfc-editor.org/rfc/rfc7541#appendix-A
+var staticTable = []headerField{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
+
+// default SETTINGS_HEADER_TABLE_SIZE
+const defaultDynamicTableSize = 4096
+
+// decoder state for one direction, dynamic table is shared by all header blocks
+type hpackDecoder struct {
+	maxSize int
+	size    int
+	dynamic []headerField // newest first
+}
+
+func newHPACKDecoder() *hpackDecoder {
+	return &hpackDecoder{maxSize: defaultDynamicTableSize}
+}
+
+func (hd *hpackDecoder) evict() {
+	for hd.size > hd.maxSize && len(hd.dynamic) > 0 {
+		last := hd.dynamic[len(hd.dynamic)-1]
+		hd.size -= last.size()
+		hd.dynamic = hd.dynamic[0 : len(hd.dynamic)-1]
+	}
+}
+
+func (hd *hpackDecoder) add(hf headerField) {
+	hd.dynamic = append([]headerField{hf}, hd.dynamic...)
+	hd.size += hf.size()
+	hd.evict()
+}
+
+func (hd *hpackDecoder) setMaxSize(n int) {
+	hd.maxSize = n
+	hd.evict()
+}
+
+func (hd *hpackDecoder) lookup(index uint64) (headerField, bool) {
+	switch {
+	case index == 0:
+		return headerField{}, false
+	case index <= uint64(len(staticTable)):
+		return staticTable[index-1], true
+	case index-uint64(len(staticTable)) <= uint64(len(hd.dynamic)):
+		return hd.dynamic[index-uint64(len(staticTable))-1], true
+	default:
+		return headerField{}, false
+	}
+}
+
+// https://www.rfc-editor.org/rfc/rfc7541#section-5.1
+func hpackPrefixInt(d *decode.D, prefixBits int) uint64 {
+	max := uint64(1)<<prefixBits - 1
+	n := d.U(prefixBits)
+	if n < max {
+		return n
+	}
+	for shift := 0; ; shift += 7 {
+		if shift > 56 {
+			d.Fatalf("integer overflow")
+		}
+		b := d.U8()
+		n += (b & 0x7f) << shift
+		if b&0x80 == 0 {
+			return n
+		}
+	}
+}
+
+// https://www.rfc-editor.org/rfc/rfc7541#section-5.2
+func hpackFieldString(d *decode.D, name string) string {
+	huffman := d.FieldBool(name + "_huffman")
+	length := d.FieldUintFn(name+"_length", func(d *decode.D) uint64 { return hpackPrefixInt(d, 7) })
+	return d.FieldStrFn(name, func(d *decode.D) string {
+		b := d.BytesLen(int(length))
+		if !huffman {
+			return string(b)
+		}
+		s, err := hpack.HuffmanDecodeToString(b)
+		if err != nil {
+			d.Fatalf("huffman: %s", err)
+		}
+		return s
+	})
+}
+
+const (
+	representationIndexed                = "indexed"
+	representationIncrementalIndexing    = "literal_incremental_indexing"
+	representationWithoutIndexing        = "literal_without_indexing"
+	representationNeverIndexed           = "literal_never_indexed"
+	representationDynamicTableSizeUpdate = "dynamic_table_size_update"
+)
+
+// https://www.rfc-editor.org/rfc/rfc7541#section-6
+func hpackDecodeRepresentation(d *decode.D) (string, int) {
+	switch {
+	case d.PeekUintBits(1) == 0b1:
+		d.SeekRel(1)
+		return representationIndexed, 7
+	case d.PeekUintBits(2) == 0b01:
+		d.SeekRel(2)
+		return representationIncrementalIndexing, 6
+	case d.PeekUintBits(3) == 0b001:
+		d.SeekRel(3)
+		return representationDynamicTableSizeUpdate, 5
+	case d.PeekUintBits(4) == 0b0001:
+		d.SeekRel(4)
+		return representationNeverIndexed, 4
+	default:
+		d.SeekRel(4)
+		return representationWithoutIndexing, 4
+	}
+}
+
+// decode header block and return decoded header fields, dynamic table is
+// updated in hd
+func hpackDecodeBlock(d *decode.D, hd *hpackDecoder) []headerField {
+	var hfs []headerField
+
+	d.FieldArray("fields", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("field", func(d *decode.D) {
+				var prefixBits int
+				representation := d.FieldStrFn("representation", func(d *decode.D) string {
+					var r string
+					r, prefixBits = hpackDecodeRepresentation(d)
+					return r
+				})
+				if representation == representationDynamicTableSizeUpdate {
+					maxSize := d.FieldUintFn("max_size", func(d *decode.D) uint64 { return hpackPrefixInt(d, prefixBits) })
+					hd.setMaxSize(int(maxSize))
+					return
+				}
+				index := d.FieldUintFn("index", func(d *decode.D) uint64 { return hpackPrefixInt(d, prefixBits) })
+
+				switch representation {
+				case representationIndexed:
+					hf, ok := hd.lookup(index)
+					if !ok {
+						d.Fatalf("invalid index %d", index)
+					}
+					d.FieldValueStr("name", hf.name)
+					d.FieldValueStr("value", hf.value)
+					hfs = append(hfs, hf)
+					return
+				}
+
+				var hf headerField
+				if index == 0 {
+					hf.name = hpackFieldString(d, "name")
+				} else {
+					var ok bool
+					if hf, ok = hd.lookup(index); !ok {
+						d.Fatalf("invalid index %d", index)
+					}
+					d.FieldValueStr("name", hf.name)
+				}
+				hf.value = hpackFieldString(d, "value")
+				if representation == representationIncrementalIndexing {
+					hd.add(hf)
+				}
+				hfs = append(hfs, hf)
+			})
+		}
+	})
+
+	return hfs
+}
@@ -0,0 +1,434 @@
+package http2
+
+// https://www.rfc-editor.org/rfc/rfc9113
+
+// TODO: server push streams
+// TODO: decode non-grpc data using content-type?
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"strings"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed http2.md
+var http2FS embed.FS
+
+var grpcGroup decode.Group
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:        format.HTTP2,
+		Description: "Hypertext Transfer Protocol 2",
+		Groups:      []string{format.TCP_STREAM},
+		DecodeFn:    http2Decode,
+		Dependencies: []decode.Dependency{
+			{Names: []string{format.GRPC}, Group: &grpcGroup},
+		},
+	})
+	interp.RegisterFS(http2FS)
+}
+
+const clientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+const frameHeaderLen = 9
+
+const (
+	frameTypeData         = 0x0
+	frameTypeHeaders      = 0x1
+	frameTypePriority     = 0x2
+	frameTypeRstStream    = 0x3
+	frameTypeSettings     = 0x4
+	frameTypePushPromise  = 0x5
+	frameTypePing         = 0x6
+	frameTypeGoaway       = 0x7
+	frameTypeWindowUpdate = 0x8
+	frameTypeContinuation = 0x9
+)
+
+var frameTypeNames = scalar.UintMapSymStr{
+	frameTypeData:         "data",
+	frameTypeHeaders:      "headers",
+	frameTypePriority:     "priority",
+	frameTypeRstStream:    "rst_stream",
+	frameTypeSettings:     "settings",
+	frameTypePushPromise:  "push_promise",
+	frameTypePing:         "ping",
+	frameTypeGoaway:       "goaway",
+	frameTypeWindowUpdate: "window_update",
+	frameTypeContinuation: "continuation",
+}
+
+const (
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+// flag names for bit 7 to 0 per frame type, empty is unused
+var frameTypeFlags = map[uint64][8]string{
+	frameTypeData:         {"", "", "", "", "padded", "", "", "end_stream"},
+	frameTypeHeaders:      {"", "", "priority", "", "padded", "end_headers", "", "end_stream"},
+	frameTypeSettings:     {"", "", "", "", "", "", "", "ack"},
+	frameTypePushPromise:  {"", "", "", "", "padded", "end_headers", "", ""},
+	frameTypePing:         {"", "", "", "", "", "", "", "ack"},
+	frameTypeContinuation: {"", "", "", "", "", "end_headers", "", ""},
+}
+
+var errorCodeNames = scalar.UintMapSymStr{
+	0x0: "no_error",
+	0x1: "protocol_error",
+	0x2: "internal_error",
+	0x3: "flow_control_error",
+	0x4: "settings_timeout",
+	0x5: "stream_closed",
+	0x6: "frame_size_error",
+	0x7: "refused_stream",
+	0x8: "cancel",
+	0x9: "compression_error",
+	0xa: "connect_error",
+	0xb: "enhance_your_calm",
+	0xc: "inadequate_security",
+	0xd: "http_1_1_required",
+}
+
+var settingNames = scalar.UintMapSymStr{
+	0x1: "header_table_size",
+	0x2: "enable_push",
+	0x3: "max_concurrent_streams",
+	0x4: "initial_window_size",
+	0x5: "max_frame_size",
+	0x6: "max_header_list_size",
+	0x8: "enable_connect_protocol",
+}
+
+type stream struct {
+	id           uint64
+	headerBlocks [][]headerField
+	data         bytes.Buffer
+}
+
+// first header block is headers, later ones are trailers
+func (s *stream) header(name string) (string, bool) {
+	if len(s.headerBlocks) == 0 {
+		return "", false
+	}
+	for _, hf := range s.headerBlocks[0] {
+		if hf.name == name {
+			return hf.value, true
+		}
+	}
+	return "", false
+}
+
+type http2Ctx struct {
+	streams     map[uint64]*stream
+	streamOrder []uint64
+}
+
+func (hc *http2Ctx) stream(id uint64) *stream {
+	s, ok := hc.streams[id]
+	if !ok {
+		s = &stream{id: id}
+		hc.streams[id] = s
+		hc.streamOrder = append(hc.streamOrder, id)
+	}
+	return s
+}
+
+func decodeFlags(d *decode.D, typ uint64) uint64 {
+	names, ok := frameTypeFlags[typ]
+	if !ok {
+		return d.FieldU8("flags", scalar.UintBin)
+	}
+
+	flags := d.PeekUintBits(8)
+	d.FieldStruct("flags", func(d *decode.D) {
+		unused := 0
+		for i := 0; i < 8; {
+			if names[i] != "" {
+				d.FieldBool(names[i])
+				i++
+				continue
+			}
+			n := 1
+			for i+n < 8 && names[i+n] == "" {
+				n++
+			}
+			d.FieldU(fmt.Sprintf("unused%d", unused), n)
+			unused++
+			i += n
+		}
+	})
+
+	return flags
+}
+
+// returns payload length without padding
+func decodePadLength(d *decode.D, flags uint64, length int64) (int64, int64) {
+	if flags&flagPadded == 0 {
+		return 0, length
+	}
+	padLength := int64(d.FieldU8("pad_length"))
+	if padLength > length-1 {
+		d.Fatalf("pad length %d larger than payload", padLength)
+	}
+	return padLength, length - 1 - padLength
+}
+
+func decodePriority(d *decode.D) {
+	d.FieldBool("exclusive")
+	d.FieldU31("stream_dependency")
+	d.FieldU8("weight", scalar.UintActualAdd(1))
+}
+
+// decode state for one direction
+type frameDecoder struct {
+	hc *http2Ctx
+	hd *hpackDecoder
+
+	// header block split into HEADERS/PUSH_PROMISE and CONTINUATION frames
+	fragmentStreamID uint64
+	fragments        bytes.Buffer
+	hasFragments     bool
+}
+
+func (fd *frameDecoder) decodeHeaderBlock(d *decode.D, streamID uint64, nBytes int64, endHeaders bool) {
+	if !endHeaders || fd.hasFragments {
+		fd.fragments.Write(d.PeekBytes(int(nBytes)))
+		d.FieldRawLen("header_block_fragment", nBytes*8)
+		if !fd.hasFragments {
+			fd.hasFragments = true
+			fd.fragmentStreamID = streamID
+		}
+		if !endHeaders {
+			return
+		}
+
+		b := fd.fragments.Bytes()
+		fd.fragments = bytes.Buffer{}
+		fd.hasFragments = false
+		var hfs []headerField
+		d.FieldStructRootBitBufFn("header_block", bitio.NewBitReader(b, -1), func(d *decode.D) {
+			hfs = hpackDecodeBlock(d, fd.hd)
+		})
+		s := fd.hc.stream(fd.fragmentStreamID)
+		s.headerBlocks = append(s.headerBlocks, hfs)
+		return
+	}
+
+	d.FramedFn(nBytes*8, func(d *decode.D) {
+		d.FieldStruct("header_block", func(d *decode.D) {
+			s := fd.hc.stream(streamID)
+			s.headerBlocks = append(s.headerBlocks, hpackDecodeBlock(d, fd.hd))
+		})
+	})
+}
+
+func (fd *frameDecoder) decodePayload(d *decode.D, typ uint64, flags uint64, streamID uint64, length int64) {
+	switch typ {
+	case frameTypeData:
+		padLength, dataLength := decodePadLength(d, flags, length)
+		fd.hc.stream(streamID).data.Write(d.PeekBytes(int(dataLength)))
+		d.FieldRawLen("data", dataLength*8)
+		d.FieldRawLen("padding", padLength*8)
+	case frameTypeHeaders:
+		padLength, blockLength := decodePadLength(d, flags, length)
+		if flags&flagPriority != 0 {
+			decodePriority(d)
+			blockLength -= 5
+		}
+		fd.decodeHeaderBlock(d, streamID, blockLength, flags&flagEndHeaders != 0)
+		d.FieldRawLen("padding", padLength*8)
+	case frameTypePriority:
+		decodePriority(d)
+	case frameTypeRstStream:
+		d.FieldU32("error_code", errorCodeNames)
+	case frameTypeSettings:
+		if length%6 != 0 {
+			d.Fatalf("settings length %d not a multiple of 6", length)
+		}
+		d.FieldArray("settings", func(d *decode.D) {
+			for !d.End() {
+				d.FieldStruct("setting", func(d *decode.D) {
+					d.FieldU16("identifier", settingNames)
+					d.FieldU32("value")
+				})
+			}
+		})
+	case frameTypePushPromise:
+		padLength, blockLength := decodePadLength(d, flags, length)
+		d.FieldU1("reserved")
+		promisedStreamID := d.FieldU31("promised_stream_id")
+		fd.decodeHeaderBlock(d, promisedStreamID, blockLength-4, flags&flagEndHeaders != 0)
+		d.FieldRawLen("padding", padLength*8)
+	case frameTypePing:
+		d.FieldRawLen("opaque_data", d.BitsLeft())
+	case frameTypeGoaway:
+		d.FieldU1("reserved")
+		d.FieldU31("last_stream_id")
+		d.FieldU32("error_code", errorCodeNames)
+		d.FieldUTF8("debug_data", int(d.BitsLeft()/8))
+	case frameTypeWindowUpdate:
+		d.FieldU1("reserved")
+		d.FieldU31("window_size_increment")
+	case frameTypeContinuation:
+		if !fd.hasFragments {
+			d.Fatalf("continuation without header block fragment")
+		}
+		fd.decodeHeaderBlock(d, streamID, length, flags&flagEndHeaders != 0)
+	}
+}
+
+func decodeFrames(d *decode.D, hc *http2Ctx, isServer bool) {
+	fd := &frameDecoder{hc: hc, hd: newHPACKDecoder()}
+
+	framesDecoded := 0
+	d.FieldArray("frames", func(d *decode.D) {
+		for !d.End() {
+			// stop at truncated frame
+			if d.BitsLeft() < frameHeaderLen*8 {
+				break
+			}
+			length := int64(d.PeekUintBits(24))
+			if d.BitsLeft() < (frameHeaderLen+length)*8 {
+				break
+			}
+
+			d.FieldStruct("frame", func(d *decode.D) {
+				d.FieldU24("length")
+				var typ uint64
+				if isServer && framesDecoded == 0 {
+					// server connection preface must be a SETTINGS frame
+					typ = d.FieldU8("type", frameTypeNames, d.UintAssert(frameTypeSettings))
+				} else {
+					typ = d.FieldU8("type", frameTypeNames)
+				}
+				flags := decodeFlags(d, typ)
+				d.FieldU1("reserved")
+				streamID := d.FieldU31("stream_id")
+
+				d.FramedFn(length*8, func(d *decode.D) {
+					if _, ok := frameTypeNames[typ]; !ok {
+						d.FieldRawLen("payload", d.BitsLeft())
+						return
+					}
+					d.FieldStruct("payload", func(d *decode.D) {
+						fd.decodePayload(d, typ, flags, streamID, length)
+					})
+				})
+			})
+			framesDecoded++
+		}
+	})
+
+	if framesDecoded == 0 {
+		d.Fatalf("no frames found")
+	}
+}
+
+func decodeHeaderFields(d *decode.D, name string, hfs []headerField) {
+	d.FieldArray(name, func(d *decode.D) {
+		for _, hf := range hfs {
+			d.FieldStruct("header", func(d *decode.D) {
+				d.FieldValueStr("name", hf.name)
+				d.FieldValueStr("value", hf.value)
+			})
+		}
+	})
+}
+
+// add streams with headers and data, for a server peer is the client context
+// used to know request paths
+func decodeStreams(d *decode.D, hc *http2Ctx, isServer bool, peer *http2Ctx) {
+	d.FieldArray("streams", func(d *decode.D) {
+		for _, id := range hc.streamOrder {
+			s := hc.streams[id]
+			// connection control stream
+			if id == 0 {
+				continue
+			}
+
+			d.FieldStruct("stream", func(d *decode.D) {
+				d.FieldValueUint("stream_id", s.id)
+				if len(s.headerBlocks) > 0 {
+					decodeHeaderFields(d, "headers", s.headerBlocks[0])
+				}
+				for _, hfs := range s.headerBlocks[1:] {
+					decodeHeaderFields(d, "trailers", hfs)
+				}
+
+				if s.data.Len() == 0 {
+					return
+				}
+				br := bitio.NewBitReader(s.data.Bytes(), -1)
+
+				contentType, _ := s.header("content-type")
+				if !strings.HasPrefix(contentType, "application/grpc") {
+					d.FieldRootBitBuf("data", br)
+					return
+				}
+
+				gi := format.GRPCIn{IsResponse: isServer}
+				gi.Path, _ = s.header(":path")
+				if peer != nil {
+					if ps, ok := peer.streams[id]; ok {
+						gi.Path, _ = ps.header(":path")
+					}
+				}
+				gi.Encoding, _ = s.header("grpc-encoding")
+				if dv, _, _ := d.TryFieldFormatBitBuf("data", br, grpcGroup, gi); dv == nil {
+					d.FieldRootBitBuf("data", br)
+				}
+			})
+		}
+	})
+}
+
+func http2Decode(d *decode.D) any {
+	var tsi format.TCPStreamIn
+	hasTsi := d.ArgAs(&tsi)
+
+	isClient := d.BitsLeft() >= int64(len(clientPreface))*8 &&
+		string(d.PeekBytes(len(clientPreface))) == clientPreface
+	if hasTsi {
+		if !tsi.HasStart {
+			d.Fatalf("http2 requires start of byte stream")
+		}
+		if tsi.IsClient != isClient {
+			d.Fatalf("http2 client connection preface mismatch")
+		}
+	}
+
+	if isClient {
+		d.FieldUTF8("preface", len(clientPreface))
+	}
+
+	hc := &http2Ctx{streams: map[uint64]*stream{}}
+	decodeFrames(d, hc, !isClient)
+
+	// server streams are added after pairing to know request paths
+	if hasTsi && !isClient {
+		return format.TCPStreamOut{
+			PostFn: func(peerIn any) {
+				clientHc, _ := peerIn.(*http2Ctx)
+				d.PostDecodeFn(func(d *decode.D) { decodeStreams(d, hc, true, clientHc) })
+			},
+			InArg: hc,
+		}
+	}
+
+	decodeStreams(d, hc, !isClient, nil)
+	if hasTsi {
+		return format.TCPStreamOut{InArg: hc}
+	}
+
+	return nil
+}
@@ -91,12 +91,46 @@ func (t *TCPConnection) ReassemblyComplete(ac reassembly.AssemblerContext) bool
 	return false
 }
 
+type UDPEndpoint struct {
+	IP   net.IP
+	Port int
+}
+
+type UDPDatagram struct {
+	IsClient bool
+	Payload  []byte
+}
+
+// UDPFlow is datagrams with the same addresses and ports in both directions,
+// the client is the sender of the first datagram
+type UDPFlow struct {
+	Client    UDPEndpoint
+	Server    UDPEndpoint
+	Datagrams []UDPDatagram
+}
+
+type udpFlowKey struct {
+	clientIP   string
+	clientPort int
+	serverIP   string
+	serverPort int
+}
+
 type IPV4Reassembled struct {
 	SourceIP      net.IP
 	DestinationIP net.IP
 	Datagram      []byte
 }
 
+// IPV6Reassembled is a reassembled datagram or if Err is set a failed
+// reassembly with fragment data in arrival order as Datagram
+type IPV6Reassembled struct {
+	SourceIP      net.IP
+	DestinationIP net.IP
+	Datagram      []byte
+	Err           error
+}
+
 func (fd *Decoder) New(net, transport gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
 	fsmOptions := reassembly.TCPSimpleFSMOptions{
 		SupportMissingEstablishment: true,
@@ -149,9 +183,14 @@ type Decoder struct {
 	Options DecoderOptions
 
 	TCPConnections  []*TCPConnection
+	UDPFlows        []*UDPFlow
 	IPV4Reassembled []IPV4Reassembled
+	IPV6Reassembled []IPV6Reassembled
+
+	udpFlows map[udpFlowKey]*UDPFlow
 
 	ipv4Defrag   *ip4defrag.IPv4Defragmenter
+	ipv6Defrag   *ipv6Defragmenter
 	tcpAssembler *reassembly.Assembler
 }
 
@@ -161,12 +200,14 @@ type DecoderOptions struct {
 
 func New(options DecoderOptions) *Decoder {
 	flowDecoder := &Decoder{
-		Options: options,
+		Options:  options,
+		udpFlows: map[udpFlowKey]*UDPFlow{},
 	}
 	streamPool := reassembly.NewStreamPool(flowDecoder)
 	tcpAssembler := reassembly.NewAssembler(streamPool)
 	flowDecoder.tcpAssembler = tcpAssembler
 	flowDecoder.ipv4Defrag = ip4defrag.NewIPv4Defragmenter()
+	flowDecoder.ipv6Defrag = newIPv6Defragmenter()
 
 	return flowDecoder
 }
@@ -209,6 +250,9 @@ func (fd *Decoder) RAWIPFrame(bs []byte) error {
 
 func (fd *Decoder) packet(p gopacket.Packet) error {
 	// TODO: linkType
+	// fragment that is not yet reassembled
+	isFragment := false
+
 	ip4Layer := p.Layer(layers.LayerTypeIPv4)
 	if ip4Layer != nil {
 		ip4, _ := ip4Layer.(*layers.IPv4)
@@ -216,7 +260,9 @@ func (fd *Decoder) packet(p gopacket.Packet) error {
 		newIPv4, err := fd.ipv4Defrag.DefragIPv4(ip4)
 		if err != nil {
 			return err
-		} else if newIPv4 != nil {
+		} else if newIPv4 == nil {
+			isFragment = true
+		} else {
 			// TODO: correct way to detect finished reassemble?
 			if newIPv4.Length != l {
 				// TODO: better way to reconstruct package?
@@ -251,15 +297,103 @@ func (fd *Decoder) packet(p gopacket.Packet) error {
 		}
 	}
 
+	ip6Layer := p.Layer(layers.LayerTypeIPv6)
+	ip6FragLayer := p.Layer(layers.LayerTypeIPv6Fragment)
+	if ip6Layer != nil && ip6FragLayer != nil {
+		ip6, _ := ip6Layer.(*layers.IPv6)
+		ip6Frag, _ := ip6FragLayer.(*layers.IPv6Fragment)
+
+		// ipv6 header and extension headers before the fragment header, next header
+		// field of ipv6 header or the last extension header points to the fragment header
+		var unfragmentable []byte
+		nextHeaderPos := 6
+		found := false
+		for _, l := range p.Layers() {
+			if l == ip6Layer {
+				found = true
+			} else if l == ip6FragLayer {
+				break
+			} else if found {
+				nextHeaderPos = len(unfragmentable)
+			}
+			if found {
+				unfragmentable = append(unfragmentable, l.LayerContents()...)
+			}
+		}
+
+		dg, err := fd.ipv6Defrag.defrag(ip6, ip6Frag, unfragmentable, nextHeaderPos)
+		if err != nil {
+			return err
+		} else if dg == nil {
+			isFragment = true
+		} else {
+			fd.IPV6Reassembled = append(fd.IPV6Reassembled, IPV6Reassembled{
+				SourceIP:      ip6.SrcIP,
+				DestinationIP: ip6.DstIP,
+				Datagram:      dg.datagram,
+			})
+
+			// same as for ipv4, decode reassembled payload into p
+			pb, ok := p.(gopacket.PacketBuilder)
+			if !ok {
+				panic("not a PacketBuilder")
+			}
+			if err := dg.nextHeader.LayerType().Decode(dg.datagram[dg.payloadPos:], pb); err != nil {
+				return err
+			}
+		}
+	}
+
 	tcp := p.Layer(layers.LayerTypeTCP)
 	if tcp != nil {
 		tcp, _ := tcp.(*layers.TCP)
 		fd.tcpAssembler.Assemble(p.NetworkLayer().NetworkFlow(), tcp)
 	}
 
+	udp := p.Layer(layers.LayerTypeUDP)
+	if udp != nil && !isFragment && p.NetworkLayer() != nil {
+		udp, _ := udp.(*layers.UDP)
+		fd.udpDatagram(p.NetworkLayer().NetworkFlow(), udp)
+	}
+
 	return nil
 }
 
+func (fd *Decoder) udpDatagram(netFlow gopacket.Flow, udp *layers.UDP) {
+	srcIP := net.IP(netFlow.Src().Raw())
+	dstIP := net.IP(netFlow.Dst().Raw())
+	srcPort := int(udp.SrcPort)
+	dstPort := int(udp.DstPort)
+
+	isClient := true
+	f, ok := fd.udpFlows[udpFlowKey{srcIP.String(), srcPort, dstIP.String(), dstPort}]
+	if !ok {
+		f, ok = fd.udpFlows[udpFlowKey{dstIP.String(), dstPort, srcIP.String(), srcPort}]
+		isClient = false
+	}
+	if !ok {
+		f = &UDPFlow{
+			Client: UDPEndpoint{
+				IP:   append([]byte(nil), srcIP...),
+				Port: srcPort,
+			},
+			Server: UDPEndpoint{
+				IP:   append([]byte(nil), dstIP...),
+				Port: dstPort,
+			},
+		}
+		isClient = true
+		fd.udpFlows[udpFlowKey{srcIP.String(), srcPort, dstIP.String(), dstPort}] = f
+		fd.UDPFlows = append(fd.UDPFlows, f)
+	}
+
+	f.Datagrams = append(f.Datagrams, UDPDatagram{
+		IsClient: isClient,
+		Payload:  append([]byte(nil), udp.Payload...),
+	})
+}
+
 func (fd *Decoder) Flush() {
 	fd.tcpAssembler.FlushAll()
+	fd.IPV6Reassembled = append(fd.IPV6Reassembled, fd.ipv6Defrag.flush()...)
 }
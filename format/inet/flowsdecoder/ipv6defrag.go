@@ -0,0 +1,210 @@
+package flowsdecoder
+
+// IPv6 fragment reassembly, gopacket only has IPv4 defragmentation
+// https://www.rfc-editor.org/rfc/rfc8200#section-4.5
+// https://www.rfc-editor.org/rfc/rfc5722
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/gopacket/gopacket/layers"
+)
+
+const ipv6MaxPayloadLength = 65535
+
+type ipv6FragmentKey struct {
+	sourceIP      string
+	destinationIP string
+	id            uint32
+}
+
+type ipv6Fragment struct {
+	offset int
+	data   []byte
+}
+
+type ipv6Datagram struct {
+	datagram []byte
+	// position of payload after ipv6 header and extension headers
+	payloadPos int
+	nextHeader layers.IPProtocol
+}
+
+type ipv6FragmentList struct {
+	sourceIP      net.IP
+	destinationIP net.IP
+	// ipv6 header and extension headers before the fragment header of the
+	// first fragment, nil until the first fragment has been seen
+	unfragmentable []byte
+	nextHeaderPos  int
+	nextHeader     layers.IPProtocol
+	fragments      []ipv6Fragment
+	// total length of fragmentable part, -1 until last fragment has been seen
+	length int
+	// all fragment data in arrival order, used for failed reassemblies
+	received bytes.Buffer
+	err      error
+}
+
+func (fl *ipv6FragmentList) add(offset int, data []byte, more bool) error {
+	end := offset + len(data)
+	if more && len(data)%8 != 0 {
+		return fmt.Errorf("fragment at offset %d length %d is not a multiple of 8", offset, len(data))
+	}
+	if end > ipv6MaxPayloadLength {
+		return fmt.Errorf("fragment at offset %d length %d exceeds max payload length", offset, len(data))
+	}
+	if !more {
+		if fl.length != -1 && fl.length != end {
+			return fmt.Errorf("last fragment ends at %d but previous last fragment ended at %d", end, fl.length)
+		}
+		for _, f := range fl.fragments {
+			if f.offset+len(f.data) > end {
+				return fmt.Errorf("last fragment ends at %d before fragment at offset %d-%d", end, f.offset, f.offset+len(f.data))
+			}
+		}
+		fl.length = end
+	} else if fl.length != -1 && end > fl.length {
+		return fmt.Errorf("fragment at offset %d-%d is after last fragment end %d", offset, end, fl.length)
+	}
+
+	for _, f := range fl.fragments {
+		fEnd := f.offset + len(f.data)
+		if offset >= fEnd || end <= f.offset {
+			continue
+		}
+		// exact duplicates are allowed, ex: retransmission
+		if offset == f.offset && bytes.Equal(data, f.data) {
+			return nil
+		}
+		return fmt.Errorf("fragment at offset %d-%d overlaps fragment at offset %d-%d", offset, end, f.offset, fEnd)
+	}
+
+	fl.fragments = append(fl.fragments, ipv6Fragment{offset: offset, data: data})
+
+	return nil
+}
+
+// datagram returns reassembled datagram if all fragments has been seen
+func (fl *ipv6FragmentList) datagram() (*ipv6Datagram, bool) {
+	if fl.length == -1 || fl.unfragmentable == nil {
+		return nil, false
+	}
+	n := 0
+	for _, f := range fl.fragments {
+		n += len(f.data)
+	}
+	// no overlaps so all fragments seen if lengths add up
+	if n != fl.length {
+		return nil, false
+	}
+
+	b := make([]byte, len(fl.unfragmentable)+fl.length)
+	copy(b, fl.unfragmentable)
+	for _, f := range fl.fragments {
+		copy(b[len(fl.unfragmentable)+f.offset:], f.data)
+	}
+	b[fl.nextHeaderPos] = byte(fl.nextHeader)
+	binary.BigEndian.PutUint16(b[4:6], uint16(len(b)-40))
+
+	return &ipv6Datagram{
+		datagram:   b,
+		payloadPos: len(fl.unfragmentable),
+		nextHeader: fl.nextHeader,
+	}, true
+}
+
+type ipv6Defragmenter struct {
+	lists map[ipv6FragmentKey]*ipv6FragmentList
+	// keys in order of first fragment
+	keys []ipv6FragmentKey
+}
+
+func newIPv6Defragmenter() *ipv6Defragmenter {
+	return &ipv6Defragmenter{
+		lists: map[ipv6FragmentKey]*ipv6FragmentList{},
+	}
+}
+
+// defrag adds a fragment, unfragmentable is the ipv6 header and extension headers
+// before the fragment header and nextHeaderPos is the position of the next header
+// field that points to the fragment header. Returns reassembled datagram when
+// all fragments has been seen. A reassembly that fails stays failed and collect
+// more fragments until flushed.
+func (dd *ipv6Defragmenter) defrag(ip6 *layers.IPv6, frag *layers.IPv6Fragment, unfragmentable []byte, nextHeaderPos int) (*ipv6Datagram, error) {
+	k := ipv6FragmentKey{
+		sourceIP:      ip6.SrcIP.String(),
+		destinationIP: ip6.DstIP.String(),
+		id:            frag.Identification,
+	}
+	fl, ok := dd.lists[k]
+	if !ok {
+		fl = &ipv6FragmentList{
+			sourceIP:      append([]byte(nil), ip6.SrcIP...),
+			destinationIP: append([]byte(nil), ip6.DstIP...),
+			length:        -1,
+		}
+		dd.lists[k] = fl
+		dd.keys = append(dd.keys, k)
+	}
+
+	data := append([]byte(nil), frag.Payload...)
+	fl.received.Write(data)
+	if fl.err != nil {
+		return nil, nil
+	}
+
+	offset := int(frag.FragmentOffset) * 8
+	if err := fl.add(offset, data, frag.MoreFragments); err != nil {
+		fl.err = err
+		return nil, err
+	}
+	if offset == 0 {
+		fl.unfragmentable = append([]byte(nil), unfragmentable...)
+		fl.nextHeaderPos = nextHeaderPos
+		fl.nextHeader = frag.NextHeader
+	}
+
+	dg, ok := fl.datagram()
+	if !ok {
+		return nil, nil
+	}
+	dd.remove(k)
+
+	return dg, nil
+}
+
+func (dd *ipv6Defragmenter) remove(k ipv6FragmentKey) {
+	delete(dd.lists, k)
+	for i, kk := range dd.keys {
+		if kk == k {
+			dd.keys = append(dd.keys[0:i], dd.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// flush returns failed and incomplete reassemblies
+func (dd *ipv6Defragmenter) flush() []IPV6Reassembled {
+	var rs []IPV6Reassembled
+	for _, k := range dd.keys {
+		fl := dd.lists[k]
+		err := fl.err
+		if err == nil {
+			err = fmt.Errorf("incomplete, missing fragments")
+		}
+		rs = append(rs, IPV6Reassembled{
+			SourceIP:      fl.sourceIP,
+			DestinationIP: fl.destinationIP,
+			Datagram:      fl.received.Bytes(),
+			Err:           err,
+		})
+	}
+	dd.lists = map[ipv6FragmentKey]*ipv6FragmentList{}
+	dd.keys = nil
+
+	return rs
+}
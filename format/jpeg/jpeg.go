@@ -6,8 +6,11 @@ package jpeg
 
 import (
 	"bytes"
+	"embed"
+	"fmt"
 
 	"github.com/wader/fq/format"
+	"github.com/wader/fq/internal/mathex"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
@@ -17,17 +20,24 @@ import (
 var exifFormat decode.Group
 var iccProfileFormat decode.Group
 
+//go:embed jpeg.md
+var jpegFS embed.FS
+
 func init() {
 	interp.RegisterFormat(decode.Format{
 		Name:        format.JPEG,
 		Description: "Joint Photographic Experts Group file",
 		Groups:      []string{format.PROBE, format.IMAGE},
 		DecodeFn:    jpegDecode,
+		DefaultInArg: format.JpegIn{
+			DecodeScans: false,
+		},
 		Dependencies: []decode.Dependency{
 			{Names: []string{format.EXIF}, Group: &exifFormat},
 			{Names: []string{format.ICC_PROFILE}, Group: &iccProfileFormat},
 		},
 	})
+	interp.RegisterFS(jpegFS)
 }
 
 const (
@@ -165,6 +175,9 @@ var markers = scalar.UintMap{
 }
 
 func jpegDecode(d *decode.D) any {
+	var ji format.JpegIn
+	d.ArgAs(&ji)
+
 	d.AssertLeastBytesLeft(2)
 	if !bytes.Equal(d.PeekBytes(2), []byte{0xff, SOI}) {
 		d.Errorf("no SOI marker")
@@ -174,26 +187,56 @@ func jpegDecode(d *decode.D) any {
 	soiMarkerFound := false
 	eoiMarkerFound := false
 
+	// state for decoding scans
+	var dcTables, acTables [4]*huffmanTable
+	restartInterval := 0
+	var fr *frame
+	var sc *scan
+
 	d.FieldArray("segments", func(d *decode.D) {
 		inECD := false
 		for d.NotEnd() && !eoiMarkerFound {
 			if inECD {
 				ecdLen := int64(0)
-				for {
-					if d.PeekUintBits(8) == 0xff && d.PeekUintBits(16) != 0xff00 {
+				// truncated files can end in entropy coded data
+				for d.NotEnd() {
+					if d.BitsLeft() >= 16 && d.PeekUintBits(8) == 0xff && d.PeekUintBits(16) != 0xff00 {
 						break
 					}
 					d.SeekRel(8)
 					ecdLen++
 				}
 				d.SeekRel(-ecdLen * 8)
-				d.FieldRawLen("entropy_coded_data", ecdLen*8)
+				if sc != nil {
+					var err error
+					v := d.FieldStruct("entropy_coded_data", func(d *decode.D) {
+						d.FramedFn(ecdLen*8, func(d *decode.D) {
+							if err = sc.decodeSegment(d); err != nil {
+								err = fmt.Errorf("%w at position %s", err, mathex.Bits(d.Pos()).StringByteBits(16))
+							}
+							if d.BitsLeft() > 0 {
+								d.FieldRawLen("trailing", d.BitsLeft())
+							}
+						})
+					}).Value
+					if err != nil {
+						v.Err = decode.FormatError{Err: err, Format: *d.Value.FormatRoot().Format}
+					}
+				} else {
+					d.FieldRawLen("entropy_coded_data", ecdLen*8)
+				}
 				inECD = false
 			} else {
 				d.FieldStruct("marker", func(d *decode.D) {
 					prefixLen := d.PeekFindByte(0xff, -1) + 1
 					d.FieldRawLen("prefix", prefixLen*8, d.AssertBitBuf([]byte{0xff}))
-					markerCode := d.FieldU8("code", markers)
+					codeSms := []scalar.UintMapper{markers}
+					if peekCode := d.PeekUintBits(8); sc != nil && peekCode >= RST0 && peekCode <= RST7 {
+						// restart markers count modulo 8 from start of scan
+						sc.restarts++
+						codeSms = append(codeSms, d.UintValidate(RST0+uint64((sc.restarts-1)%8)))
+					}
+					markerCode := d.FieldU8("code", codeSms...)
 					_, markerFound := markers[markerCode]
 
 					// RST*, SOI, EOI, TEM does not have a length field. All others have a
@@ -206,38 +249,70 @@ func jpegDecode(d *decode.D) any {
 					case SOF0, SOF1, SOF2, SOF3, SOF5, SOF6, SOF7, SOF9, SOF10, SOF11:
 						d.FieldU16("lf")
 						d.FieldU8("p")
-						d.FieldU16("y")
-						d.FieldU16("x")
+						y := d.FieldU16("y")
+						x := d.FieldU16("x")
 						nf := d.FieldU8("nf")
+						var components []*frameComponent
 						d.FieldArray("frame_components", func(d *decode.D) {
 							for i := uint64(0); i < nf; i++ {
 								d.FieldStruct("frame_component", func(d *decode.D) {
-									d.FieldU8("c")
-									d.FieldU4("h")
-									d.FieldU4("v")
+									components = append(components, &frameComponent{
+										id: int(d.FieldU8("c")),
+										h:  int(d.FieldU4("h")),
+										v:  int(d.FieldU4("v")),
+									})
 									d.FieldU8("tq")
 								})
 							}
 						})
+						fr = nil
+						sc = nil
+						// only huffman coded DCT frames, not lossless, hierarchical or arithmetic coded
+						switch markerCode {
+						case SOF0, SOF1, SOF2:
+							if ji.DecodeScans {
+								fr = newFrame(markerCode == SOF2, int(x), int(y), components)
+							}
+						}
 					case COM:
 						comLen := d.FieldU16("lc")
 						d.FieldUTF8("cm", int(comLen)-2)
 					case SOS:
 						d.FieldU16("ls")
 						ns := d.FieldU8("ns")
+						var components []*scanComponent
+						componentsFound := true
 						d.FieldArray("scan_components", func(d *decode.D) {
 							for i := uint64(0); i < ns; i++ {
 								d.FieldStruct("scan_component", func(d *decode.D) {
-									d.FieldU8("cs")
-									d.FieldU4("td")
-									d.FieldU4("ta")
+									cs := int(d.FieldU8("cs"))
+									td := d.FieldU4("td")
+									ta := d.FieldU4("ta")
+									if fr == nil {
+										return
+									}
+									var fc *frameComponent
+									for _, c := range fr.components {
+										if c.id == cs {
+											fc = c
+										}
+									}
+									if fc == nil || td > 3 || ta > 3 {
+										componentsFound = false
+										return
+									}
+									components = append(components, &scanComponent{fc: fc, dc: dcTables[td], ac: acTables[ta]})
 								})
 							}
 						})
-						d.FieldU8("ss")
-						d.FieldU8("se")
-						d.FieldU4("ah")
-						d.FieldU4("al")
+						ss := d.FieldU8("ss")
+						se := d.FieldU8("se")
+						ah := d.FieldU4("ah")
+						al := d.FieldU4("al")
+						sc = nil
+						if fr != nil && componentsFound && len(components) > 0 && se < 64 && ss <= se {
+							sc = newScan(fr, components, int(ss), int(se), int(ah), int(al), restartInterval)
+						}
 						inECD = true
 					case DQT:
 						lQ := int64(d.FieldU16("lq"))
@@ -261,6 +336,44 @@ func jpegDecode(d *decode.D) any {
 								}
 							})
 						})
+					case DHT:
+						lh := d.FieldU16("lh")
+						d.FramedFn(int64(lh)*8-16, func(d *decode.D) {
+							d.FieldArray("hts", func(d *decode.D) {
+								for d.NotEnd() {
+									d.FieldStruct("ht", func(d *decode.D) {
+										tc := d.FieldU4("tc", scalar.UintMapSymStr{0: "dc", 1: "ac"})
+										th := d.FieldU4("th")
+										var counts [16]int
+										d.FieldArray("li", func(d *decode.D) {
+											for i := range counts {
+												counts[i] = int(d.FieldU8("l"))
+											}
+										})
+										var values []uint64
+										d.FieldArray("vij", func(d *decode.D) {
+											for _, n := range counts {
+												for i := 0; i < n; i++ {
+													values = append(values, d.FieldU8("v"))
+												}
+											}
+										})
+										if th > 3 {
+											return
+										}
+										switch tc {
+										case 0:
+											dcTables[th] = newHuffmanTable(counts, values)
+										case 1:
+											acTables[th] = newHuffmanTable(counts, values)
+										}
+									})
+								}
+							})
+						})
+					case DRI:
+						d.FieldU16("lr")
+						restartInterval = int(d.FieldU16("ri"))
 					case RST0, RST1, RST2, RST3, RST4, RST5, RST6, RST7:
 						inECD = true
 					case TEM:
@@ -0,0 +1,521 @@
+package jpeg
+
+// Huffman entropy coded segment decoding for baseline, extended sequential and
+// progressive DCT scans
+// https://www.w3.org/Graphics/JPEG/itu-t81.pdf annex F and G
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+var errUnexpectedEnd = errors.New("unexpected end of entropy coded data")
+
+type huffmanTable struct {
+	// per code length, maxCode is -1 if no codes of that length
+	minCode [17]int
+	maxCode [17]int
+	valPtr  [17]int
+	values  []uint64
+}
+
+// F.2.2.3 and C.2
+func newHuffmanTable(counts [16]int, values []uint64) *huffmanTable {
+	t := &huffmanTable{values: values}
+	code := 0
+	k := 0
+	for l := 1; l <= 16; l++ {
+		n := counts[l-1]
+		t.maxCode[l] = -1
+		if n > 0 {
+			t.valPtr[l] = k
+			t.minCode[l] = code
+			code += n
+			k += n
+			t.maxCode[l] = code - 1
+		}
+		code <<= 1
+	}
+	return t
+}
+
+type frameComponent struct {
+	id int
+	h  int
+	v  int
+	// blocks padded to whole MCUs
+	blocksPerLine   int
+	blocksPerColumn int
+	// blocks covering the component, used by non-interleaved scans
+	scanBlocksPerLine   int
+	scanBlocksPerColumn int
+	// coefficients in zigzag order by block index, only used by progressive
+	// scans that refine previous scans
+	coefs map[int]*[64]int
+}
+
+type frame struct {
+	progressive bool
+	mcusPerLine int
+	mcusPerCol  int
+	components  []*frameComponent
+}
+
+func ceilDiv(a, b int) int { return (a + b - 1) / b }
+
+// newFrame returns nil if frame can't be decoded, ex: number of lines defined by DNL
+func newFrame(progressive bool, x int, y int, components []*frameComponent) *frame {
+	if x == 0 || y == 0 || len(components) == 0 {
+		return nil
+	}
+	hMax, vMax := 0, 0
+	for _, c := range components {
+		if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 {
+			return nil
+		}
+		hMax = mathex.Max(hMax, c.h)
+		vMax = mathex.Max(vMax, c.v)
+	}
+	f := &frame{
+		progressive: progressive,
+		mcusPerLine: ceilDiv(x, 8*hMax),
+		mcusPerCol:  ceilDiv(y, 8*vMax),
+		components:  components,
+	}
+	for _, c := range components {
+		c.blocksPerLine = f.mcusPerLine * c.h
+		c.blocksPerColumn = f.mcusPerCol * c.v
+		c.scanBlocksPerLine = ceilDiv(ceilDiv(x*c.h, hMax), 8)
+		c.scanBlocksPerColumn = ceilDiv(ceilDiv(y*c.v, vMax), 8)
+		c.coefs = map[int]*[64]int{}
+	}
+	return f
+}
+
+type scanComponent struct {
+	fc   *frameComponent
+	dc   *huffmanTable
+	ac   *huffmanTable
+	pred int64
+}
+
+type scan struct {
+	frame           *frame
+	components      []*scanComponent
+	ss, se, ah, al  int
+	restartInterval int
+	mcus            int
+	mcu             int
+	restarts        int
+	eobRun          int
+}
+
+func newScan(f *frame, components []*scanComponent, ss int, se int, ah int, al int, restartInterval int) *scan {
+	s := &scan{
+		frame:           f,
+		components:      components,
+		ss:              ss,
+		se:              se,
+		ah:              ah,
+		al:              al,
+		restartInterval: restartInterval,
+	}
+	if !f.progressive {
+		// sequential scans always have all coefficients
+		s.ss, s.se, s.ah, s.al = 0, 63, 0, 0
+	}
+	if len(components) == 1 {
+		c := components[0].fc
+		s.mcus = c.scanBlocksPerLine * c.scanBlocksPerColumn
+	} else {
+		s.mcus = f.mcusPerLine * f.mcusPerCol
+	}
+	return s
+}
+
+// entropyReader reads bits skipping zero bytes stuffed after 0xff bytes
+type entropyReader struct {
+	ff bool
+}
+
+func (r *entropyReader) bit(d *decode.D) (uint64, error) {
+	if d.BitsLeft() < 1 {
+		return 0, errUnexpectedEnd
+	}
+	if d.Pos()%8 == 0 {
+		r.ff = d.PeekUintBits(8) == 0xff
+	}
+	b := d.U1()
+	if d.Pos()%8 == 0 && r.ff && d.BitsLeft() >= 8 {
+		d.SeekRel(8)
+	}
+	return b, nil
+}
+
+func (r *entropyReader) bits(d *decode.D, n int) (uint64, error) {
+	var v uint64
+	for i := 0; i < n; i++ {
+		b, err := r.bit(d)
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | b
+	}
+	return v, nil
+}
+
+// F.2.2.3 DECODE
+func (r *entropyReader) huffman(d *decode.D, t *huffmanTable) (uint64, error) {
+	if t == nil {
+		return 0, errors.New("huffman table not defined")
+	}
+	code := 0
+	for l := 1; l <= 16; l++ {
+		b, err := r.bit(d)
+		if err != nil {
+			return 0, err
+		}
+		code = code<<1 | int(b)
+		if code <= t.maxCode[l] {
+			return t.values[t.valPtr[l]+code-t.minCode[l]], nil
+		}
+	}
+	return 0, errors.New("invalid huffman code")
+}
+
+// F.2.2.1 EXTEND
+func extend(v uint64, size uint64) int64 {
+	if size == 0 {
+		return 0
+	}
+	if v < 1<<(size-1) {
+		return int64(v) - (1 << size) + 1
+	}
+	return int64(v)
+}
+
+// fieldUint adds a field if fn succeeds, on error position is restored
+func (r *entropyReader) fieldUint(d *decode.D, name string, fn func() (uint64, error), sms ...scalar.UintMapper) (uint64, error) {
+	start := d.Pos()
+	v, err := d.TryFieldUintFn(name, func(d *decode.D) (uint64, error) { return fn() }, sms...)
+	if err != nil {
+		d.SeekAbs(start)
+	}
+	return v, err
+}
+
+func (r *entropyReader) fieldExtended(d *decode.D, name string, size uint64) (int64, error) {
+	start := d.Pos()
+	v, err := d.TryFieldSintFn(name, func(d *decode.D) (int64, error) {
+		v, err := r.bits(d, int(size))
+		return extend(v, size), err
+	})
+	if err != nil {
+		d.SeekAbs(start)
+	}
+	return v, err
+}
+
+var runSizeMapper = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	run, size := s.Actual>>4, s.Actual&0xf
+	switch {
+	case size == 0 && run == 15:
+		s.Description = "zrl"
+	case size == 0 && run == 0:
+		s.Description = "eob"
+	case size == 0:
+		s.Description = fmt.Sprintf("eob%d", run)
+	default:
+		s.Description = fmt.Sprintf("run %d size %d", run, size)
+	}
+	return s, nil
+})
+
+// decodeSegment decodes MCUs up to next restart marker
+func (s *scan) decodeSegment(d *decode.D) error {
+	r := &entropyReader{}
+	for _, c := range s.components {
+		c.pred = 0
+	}
+	s.eobRun = 0
+
+	n := s.mcus - s.mcu
+	if s.restartInterval > 0 {
+		n = mathex.Min(n, s.restartInterval)
+	}
+
+	var err error
+	d.FieldArray("mcus", func(d *decode.D) {
+		for i := 0; i < n && err == nil; i++ {
+			d.FieldStruct("mcu", func(d *decode.D) {
+				err = s.decodeMCU(d, r)
+			})
+			s.mcu++
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// segment ends with one bits up to a byte boundary
+	if p := d.Pos() % 8; p != 0 {
+		n := int(8 - p)
+		if _, err := r.fieldUint(d, "padding", func() (uint64, error) { return r.bits(d, n) }, d.UintValidate(1<<n-1)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *scan) decodeMCU(d *decode.D, r *entropyReader) error {
+	var err error
+	d.FieldArray("blocks", func(d *decode.D) {
+		if len(s.components) == 1 {
+			c := s.components[0]
+			x := s.mcu % c.fc.scanBlocksPerLine
+			y := s.mcu / c.fc.scanBlocksPerLine
+			err = s.decodeBlock(d, r, c, y*c.fc.blocksPerLine+x)
+			return
+		}
+
+		mx := s.mcu % s.frame.mcusPerLine
+		my := s.mcu / s.frame.mcusPerLine
+		for _, c := range s.components {
+			for v := 0; v < c.fc.v; v++ {
+				for h := 0; h < c.fc.h; h++ {
+					if err != nil {
+						return
+					}
+					err = s.decodeBlock(d, r, c, (my*c.fc.v+v)*c.fc.blocksPerLine+mx*c.fc.h+h)
+				}
+			}
+		}
+	})
+	return err
+}
+
+func (s *scan) decodeBlock(d *decode.D, r *entropyReader, c *scanComponent, blockIndex int) error {
+	var err error
+	d.FieldStruct("block", func(d *decode.D) {
+		d.FieldValueUint("component", uint64(c.fc.id))
+
+		// sequential scans don't need previous coefficients
+		coefs := &[64]int{}
+		if s.frame.progressive {
+			if cs, ok := c.fc.coefs[blockIndex]; ok {
+				coefs = cs
+			} else {
+				c.fc.coefs[blockIndex] = coefs
+			}
+		}
+
+		switch {
+		case s.ss == 0 && s.ah == 0:
+			err = s.decodeDCFirst(d, r, c, coefs)
+			if err == nil && !s.frame.progressive {
+				err = s.decodeACFirst(d, r, c, coefs, 1)
+			}
+		case s.ss == 0:
+			_, err = r.fieldUint(d, "dc_bit", func() (uint64, error) {
+				b, err := r.bit(d)
+				coefs[0] |= int(b) << s.al
+				return b, err
+			})
+		case s.ah == 0:
+			err = s.decodeACFirst(d, r, c, coefs, s.ss)
+		default:
+			err = s.decodeACRefine(d, r, c, coefs)
+		}
+	})
+	return err
+}
+
+// F.2.2.1 and G.1.2.1
+func (s *scan) decodeDCFirst(d *decode.D, r *entropyReader, c *scanComponent, coefs *[64]int) error {
+	size, err := r.fieldUint(d, "dc_size", func() (uint64, error) { return r.huffman(d, c.dc) })
+	if err != nil {
+		return err
+	}
+	if size > 16 {
+		return fmt.Errorf("invalid dc size %d", size)
+	}
+	diff, err := r.fieldExtended(d, "dc_diff", size)
+	if err != nil {
+		return err
+	}
+	c.pred += diff
+	coefs[0] = int(c.pred) << s.al
+	d.FieldValueSint("dc", int64(coefs[0]))
+
+	return nil
+}
+
+// F.2.2.2 and G.1.2.2
+func (s *scan) decodeACFirst(d *decode.D, r *entropyReader, c *scanComponent, coefs *[64]int, k int) error {
+	var err error
+	d.FieldArray("ac", func(d *decode.D) {
+		if s.eobRun > 0 {
+			s.eobRun--
+			return
+		}
+		for k <= s.se && err == nil {
+			eob := false
+			d.FieldStruct("coefficient", func(d *decode.D) {
+				var rs uint64
+				rs, err = r.fieldUint(d, "run_size", func() (uint64, error) { return r.huffman(d, c.ac) }, runSizeMapper)
+				if err != nil {
+					return
+				}
+				run, size := int(rs>>4), rs&0xf
+				if size == 0 {
+					if run == 15 {
+						k += 16
+						return
+					}
+					eob = true
+					if s.frame.progressive {
+						err = s.decodeEOBRun(d, r, run)
+					}
+					return
+				}
+				k += run
+				if k > s.se {
+					err = fmt.Errorf("coefficient index %d outside spectral selection end %d", k, s.se)
+					return
+				}
+				var v int64
+				v, err = r.fieldExtended(d, "value", size)
+				if err != nil {
+					return
+				}
+				coefs[k] = int(v) << s.al
+				d.FieldValueUint("index", uint64(k))
+				k++
+			})
+			if eob {
+				break
+			}
+		}
+	})
+	return err
+}
+
+// G.1.2.2 EOBRUN, current block is included in run
+func (s *scan) decodeEOBRun(d *decode.D, r *entropyReader, run int) error {
+	s.eobRun = 1 << run
+	if run > 0 {
+		v, err := r.fieldUint(d, "eob_run", func() (uint64, error) { return r.bits(d, run) })
+		if err != nil {
+			return err
+		}
+		s.eobRun += int(v)
+	}
+	s.eobRun--
+	return nil
+}
+
+// G.1.2.3 successive approximation refinement, new coefficients are +-1 and
+// already non-zero coefficients gets a correction bit when passed
+func (s *scan) decodeACRefine(d *decode.D, r *entropyReader, c *scanComponent, coefs *[64]int) error {
+	p1 := 1 << s.al
+	m1 := -1 << s.al
+	k := s.ss
+
+	correction := func(d *decode.D, k int) error {
+		_, err := r.fieldUint(d, "correction", func() (uint64, error) {
+			b, err := r.bit(d)
+			if b == 1 && coefs[k]&p1 == 0 {
+				if coefs[k] >= 0 {
+					coefs[k] += p1
+				} else {
+					coefs[k] += m1
+				}
+			}
+			return b, err
+		})
+		return err
+	}
+
+	var err error
+	d.FieldArray("ac", func(d *decode.D) {
+		for s.eobRun == 0 && k <= s.se && err == nil {
+			d.FieldStruct("coefficient", func(d *decode.D) {
+				var rs uint64
+				rs, err = r.fieldUint(d, "run_size", func() (uint64, error) { return r.huffman(d, c.ac) }, runSizeMapper)
+				if err != nil {
+					return
+				}
+				run, size := int(rs>>4), rs&0xf
+				value := 0
+				switch {
+				case size == 1:
+					var sign uint64
+					sign, err = r.fieldUint(d, "sign", func() (uint64, error) { return r.bit(d) })
+					if err != nil {
+						return
+					}
+					value = m1
+					if sign == 1 {
+						value = p1
+					}
+				case size != 0:
+					err = fmt.Errorf("invalid refinement size %d", size)
+					return
+				case run != 15:
+					err = s.decodeEOBRun(d, r, run)
+					// decodeEOBRun counts current block, refined below
+					s.eobRun++
+					return
+				}
+
+				// skip run zero coefficients, zrl skips 16
+				d.FieldArray("corrections", func(d *decode.D) {
+					for ; k <= s.se && err == nil; k++ {
+						if coefs[k] != 0 {
+							err = correction(d, k)
+						} else {
+							if run == 0 {
+								break
+							}
+							run--
+						}
+					}
+				})
+				if err != nil {
+					return
+				}
+				if value != 0 {
+					if k > s.se {
+						err = fmt.Errorf("coefficient index %d outside spectral selection end %d", k, s.se)
+						return
+					}
+					coefs[k] = value
+					d.FieldValueUint("index", uint64(k))
+				}
+				k++
+			})
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.eobRun > 0 {
+		// rest of band in end of band run only has correction bits
+		d.FieldArray("eob_corrections", func(d *decode.D) {
+			for ; k <= s.se && err == nil; k++ {
+				if coefs[k] != 0 {
+					err = correction(d, k)
+				}
+			}
+		})
+		s.eobRun--
+	}
+
+	return err
+}
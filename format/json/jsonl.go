@@ -3,10 +3,16 @@ package json
 import (
 	"bytes"
 	"embed"
+	stdjson "encoding/json"
+	"errors"
+	"io"
 
 	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+	"github.com/wader/gojq"
 )
 
 //go:embed jsonl.jq
@@ -21,6 +27,7 @@ func init() {
 		ProbeOrder:  format.ProbeOrderTextFuzzy,
 		Groups:      []string{format.PROBE},
 		DecodeFn:    decodeJSONL,
+		StreamFn:    decodeJSONLStream,
 		Functions:   []string{"_todisplay"},
 	})
 	interp.RegisterFS(jsonlFS)
@@ -31,6 +38,36 @@ func decodeJSONL(d *decode.D) any {
 	return decodeJSONEx(d, true)
 }
 
+// one record per value
+func decodeJSONLStream(d *decode.D, _ any) any {
+	jd := stdjson.NewDecoder(bitio.NewIOReader(d.RawLen(d.Len())))
+	jd.UseNumber()
+
+	var v any
+	if err := jd.Decode(&v); err != nil {
+		switch {
+		case errors.Is(err, io.EOF):
+			// only whitespace left, record with no value
+			d.Value.Range.Len = d.Len()
+			return nil
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			d.IOPanic(err, "decodeJSONLStream: Decode")
+		default:
+			d.Fatalf("%s", err)
+		}
+	}
+	// a number at end might continue
+	// TODO: last line in stream can't be a number without a new line
+	if _, ok := v.(stdjson.Number); ok && jd.InputOffset()*8 == d.Len() {
+		d.IOPanic(io.ErrUnexpectedEOF, "decodeJSONLStream: Decode")
+	}
+
+	d.Value.V = &scalar.Any{Actual: gojq.NormalizeNumbers(v)}
+	d.Value.Range.Len = jd.InputOffset() * 8
+
+	return nil
+}
+
 func toJSONL(i *interp.Interp, c []any) any {
 	cj := makeEncoder(ToJSONOpts{})
 	bb := &bytes.Buffer{}
@@ -0,0 +1,988 @@
+package kaitai
+
+// Kaitai Struct expression language
+// https://doc.kaitai.io/user_guide.html#_expression_language
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOp
+)
+
+type token struct {
+	kind tokenKind
+	s    string
+	v    any // number or string value
+}
+
+var exprOps = []string{
+	"::", "<<", ">>", "<=", ">=", "==", "!=",
+	"+", "-", "*", "/", "%", "&", "|", "^", "~", "<", ">", "?", ":", "(", ")", "[", "]", ".", ",",
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+func lexNumber(s string) (token, int, error) {
+	i := 0
+	for i < len(s) && (isIdentChar(s[i]) || s[i] == '.') {
+		// 1.foo is member access on integer
+		if s[i] == '.' && (i+1 >= len(s) || s[i+1] < '0' || s[i+1] > '9') {
+			break
+		}
+		i++
+	}
+	ns := strings.ReplaceAll(s[0:i], "_", "")
+	if strings.ContainsAny(ns, ".") || (!strings.HasPrefix(ns, "0x") && strings.ContainsAny(ns, "eE")) {
+		f, err := strconv.ParseFloat(ns, 64)
+		if err != nil {
+			return token{}, 0, fmt.Errorf("invalid number %q", s[0:i])
+		}
+		return token{kind: tokenNumber, s: s[0:i], v: f}, i, nil
+	}
+	base := 10
+	switch {
+	case strings.HasPrefix(ns, "0x"), strings.HasPrefix(ns, "0X"):
+		base, ns = 16, ns[2:]
+	case strings.HasPrefix(ns, "0b"), strings.HasPrefix(ns, "0B"):
+		base, ns = 2, ns[2:]
+	case strings.HasPrefix(ns, "0o"), strings.HasPrefix(ns, "0O"):
+		base, ns = 8, ns[2:]
+	}
+	n, err := strconv.ParseUint(ns, base, 64)
+	if err != nil {
+		return token{}, 0, fmt.Errorf("invalid number %q", s[0:i])
+	}
+	return token{kind: tokenNumber, s: s[0:i], v: int64(n)}, i, nil
+}
+
+func lexString(s string) (token, int, error) {
+	q := s[0]
+	sb := &strings.Builder{}
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == q:
+			return token{kind: tokenString, s: s[0 : i+1], v: sb.String()}, i + 1, nil
+		case c == '\\' && q == '"' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case '0':
+				sb.WriteByte(0)
+			default:
+				sb.WriteByte(s[i])
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return token{}, 0, errors.New("unterminated string")
+}
+
+func lex(s string) ([]token, error) {
+	var ts []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c >= '0' && c <= '9':
+			t, n, err := lexNumber(s[i:])
+			if err != nil {
+				return nil, err
+			}
+			ts = append(ts, t)
+			i += n
+		case c == '"' || c == '\'':
+			t, n, err := lexString(s[i:])
+			if err != nil {
+				return nil, err
+			}
+			ts = append(ts, t)
+			i += n
+		case isIdentStart(c):
+			j := i
+			for j < len(s) && isIdentChar(s[j]) {
+				j++
+			}
+			ts = append(ts, token{kind: tokenIdent, s: s[i:j]})
+			i = j
+		default:
+			found := false
+			for _, op := range exprOps {
+				if strings.HasPrefix(s[i:], op) {
+					ts = append(ts, token{kind: tokenOp, s: op})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+		}
+	}
+	return append(ts, token{kind: tokenEOF}), nil
+}
+
+type node interface{}
+
+type nodeLit struct{ v any }
+type nodeIdent struct{ name string }
+type nodeEnum struct{ path []string } // enum_name::value or type::enum_name::value
+type nodeUnary struct {
+	op string
+	x  node
+}
+type nodeBinary struct {
+	op   string
+	l, r node
+}
+type nodeTernary struct{ c, t, f node }
+type nodeMember struct {
+	x    node
+	name string
+}
+type nodeIndex struct{ x, i node }
+type nodeCall struct {
+	x    node
+	args []node
+}
+type nodeArray struct{ elems []node }
+
+type parser struct {
+	ts []token
+	i  int
+}
+
+func (p *parser) peek() token { return p.ts[p.i] }
+func (p *parser) next() token { t := p.ts[p.i]; p.i++; return t }
+func (p *parser) isOp(ops ...string) bool {
+	t := p.peek()
+	if t.kind != tokenOp {
+		return false
+	}
+	for _, op := range ops {
+		if t.s == op {
+			return true
+		}
+	}
+	return false
+}
+func (p *parser) isKeyword(kw string) bool {
+	t := p.peek()
+	return t.kind == tokenIdent && t.s == kw
+}
+func (p *parser) expectOp(op string) error {
+	if !p.isOp(op) {
+		return fmt.Errorf("expected %q found %q", op, p.peek().s)
+	}
+	p.next()
+	return nil
+}
+
+// precedence climbing, lowest first
+var binaryOpLevels = [][]string{
+	{"or"},
+	{"and"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) parseTernary() (node, error) {
+	c, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.isOp("?") {
+		return c, nil
+	}
+	p.next()
+	t, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectOp(":"); err != nil {
+		return nil, err
+	}
+	f, err := p.parseTernary()
+	if err != nil {
+		return nil, err
+	}
+	return nodeTernary{c: c, t: t, f: f}, nil
+}
+
+func (p *parser) isBinaryOp(level int) (string, bool) {
+	t := p.peek()
+	if t.kind != tokenOp && t.kind != tokenIdent {
+		return "", false
+	}
+	for _, op := range binaryOpLevels[level] {
+		if t.s == op {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) parseBinary(level int) (node, error) {
+	if level == len(binaryOpLevels) {
+		return p.parseUnary()
+	}
+	// not binds lower than comparison
+	if level == 2 && p.isKeyword("not") {
+		p.next()
+		x, err := p.parseBinary(level)
+		if err != nil {
+			return nil, err
+		}
+		return nodeUnary{op: "not", x: x}, nil
+	}
+	l, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.isBinaryOp(level)
+		if !ok {
+			return l, nil
+		}
+		p.next()
+		r, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = nodeBinary{op: op, l: l, r: r}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOp("-", "~") {
+		op := p.next().s
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return nodeUnary{op: op, x: x}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parseArgs(end string) ([]node, error) {
+	var args []node
+	for !p.isOp(end) {
+		a, err := p.parseTernary()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expectOp(end); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.isOp("."):
+			p.next()
+			t := p.next()
+			if t.kind != tokenIdent {
+				return nil, fmt.Errorf("expected name after \".\" found %q", t.s)
+			}
+			x = nodeMember{x: x, name: t.s}
+		case p.isOp("["):
+			p.next()
+			i, err := p.parseTernary()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp("]"); err != nil {
+				return nil, err
+			}
+			x = nodeIndex{x: x, i: i}
+		case p.isOp("("):
+			p.next()
+			args, err := p.parseArgs(")")
+			if err != nil {
+				return nil, err
+			}
+			x = nodeCall{x: x, args: args}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber, tokenString:
+		return nodeLit{v: t.v}, nil
+	case tokenIdent:
+		switch t.s {
+		case "true":
+			return nodeLit{v: true}, nil
+		case "false":
+			return nodeLit{v: false}, nil
+		}
+		if p.isOp("::") {
+			path := []string{t.s}
+			for p.isOp("::") {
+				p.next()
+				nt := p.next()
+				if nt.kind != tokenIdent {
+					return nil, fmt.Errorf("expected name after \"::\" found %q", nt.s)
+				}
+				path = append(path, nt.s)
+			}
+			return nodeEnum{path: path}, nil
+		}
+		return nodeIdent{name: t.s}, nil
+	case tokenOp:
+		switch t.s {
+		case "(":
+			x, err := p.parseTernary()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		case "[":
+			elems, err := p.parseArgs("]")
+			if err != nil {
+				return nil, err
+			}
+			return nodeArray{elems: elems}, nil
+		}
+	case tokenEOF:
+		return nil, errors.New("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q", t.s)
+}
+
+type expr struct {
+	s string
+	n node
+}
+
+func parseExpr(s string) (*expr, error) {
+	ts, err := lex(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s, err)
+	}
+	p := &parser{ts: ts}
+	n, err := p.parseTernary()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s, err)
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("%s: unexpected %q", s, p.peek().s)
+	}
+	return &expr{s: s, n: n}, nil
+}
+
+// exprEnv resolves names used in expressions
+type exprEnv interface {
+	lookup(name string) (any, error)
+	enumValue(path []string) (any, error)
+}
+
+// memberer is a value with members, ex: a struct or _io
+type memberer interface {
+	member(name string) (any, error)
+}
+
+// enumValue is a integer value mapped using an enum
+type enumValue struct {
+	e *ksyEnum
+	n int64
+}
+
+func (e *expr) eval(env exprEnv) (any, error) {
+	v, err := evalNode(e.n, env)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", e.s, err)
+	}
+	return v, nil
+}
+
+func (e *expr) evalInt(env exprEnv) (int64, error) {
+	v, err := e.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	n, err := toInt(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", e.s, err)
+	}
+	return n, nil
+}
+
+func (e *expr) evalBool(env exprEnv) (bool, error) {
+	v, err := e.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s: expected a boolean got %s", e.s, typeName(v))
+	}
+	return b, nil
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case int64, enumValue:
+		return "integer"
+	case float64:
+		return "float"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []byte:
+		return "bytes"
+	case []any:
+		return "array"
+	case *kStruct:
+		return "struct"
+	case kIO:
+		return "io"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func toInt(v any) (int64, error) {
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case enumValue:
+		return v.n, nil
+	case float64:
+		return int64(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("expected an integer got %s", typeName(v))
+	}
+}
+
+func toBytes(v any) ([]byte, bool) {
+	switch v := v.(type) {
+	case []byte:
+		return v, true
+	case []any:
+		b := make([]byte, len(v))
+		for i, e := range v {
+			n, err := toInt(e)
+			if err != nil || n < 0 || n > 255 {
+				return nil, false
+			}
+			b[i] = byte(n)
+		}
+		return b, true
+	default:
+		return nil, false
+	}
+}
+
+func evalNode(n node, env exprEnv) (any, error) {
+	switch n := n.(type) {
+	case nodeLit:
+		return n.v, nil
+	case nodeIdent:
+		return env.lookup(n.name)
+	case nodeEnum:
+		return env.enumValue(n.path)
+	case nodeArray:
+		vs := make([]any, len(n.elems))
+		for i, e := range n.elems {
+			v, err := evalNode(e, env)
+			if err != nil {
+				return nil, err
+			}
+			vs[i] = v
+		}
+		return vs, nil
+	case nodeTernary:
+		c, err := evalNode(n.c, env)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := c.(bool)
+		if !ok {
+			return nil, fmt.Errorf("condition is not a boolean got %s", typeName(c))
+		}
+		if b {
+			return evalNode(n.t, env)
+		}
+		return evalNode(n.f, env)
+	case nodeUnary:
+		x, err := evalNode(n.x, env)
+		if err != nil {
+			return nil, err
+		}
+		return evalUnary(n.op, x)
+	case nodeBinary:
+		l, err := evalNode(n.l, env)
+		if err != nil {
+			return nil, err
+		}
+		// short circuit
+		if n.op == "and" || n.op == "or" {
+			lb, ok := l.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%s expects booleans got %s", n.op, typeName(l))
+			}
+			if (n.op == "and" && !lb) || (n.op == "or" && lb) {
+				return lb, nil
+			}
+			r, err := evalNode(n.r, env)
+			if err != nil {
+				return nil, err
+			}
+			rb, ok := r.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%s expects booleans got %s", n.op, typeName(r))
+			}
+			return rb, nil
+		}
+		r, err := evalNode(n.r, env)
+		if err != nil {
+			return nil, err
+		}
+		return evalBinary(n.op, l, r)
+	case nodeIndex:
+		x, err := evalNode(n.x, env)
+		if err != nil {
+			return nil, err
+		}
+		iv, err := evalNode(n.i, env)
+		if err != nil {
+			return nil, err
+		}
+		i, err := toInt(iv)
+		if err != nil {
+			return nil, err
+		}
+		switch x := x.(type) {
+		case []any:
+			if i < 0 || i >= int64(len(x)) {
+				return nil, fmt.Errorf("index %d out of range", i)
+			}
+			return x[i], nil
+		case []byte:
+			if i < 0 || i >= int64(len(x)) {
+				return nil, fmt.Errorf("index %d out of range", i)
+			}
+			return int64(x[i]), nil
+		default:
+			return nil, fmt.Errorf("can't index %s", typeName(x))
+		}
+	case nodeMember:
+		x, err := evalNode(n.x, env)
+		if err != nil {
+			return nil, err
+		}
+		return evalMethod(x, n.name, nil)
+	case nodeCall:
+		m, ok := n.x.(nodeMember)
+		if !ok {
+			return nil, errors.New("only methods can be called")
+		}
+		x, err := evalNode(m.x, env)
+		if err != nil {
+			return nil, err
+		}
+		var args []any
+		for _, a := range n.args {
+			v, err := evalNode(a, env)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, v)
+		}
+		return evalMethod(x, m.name, args)
+	default:
+		return nil, fmt.Errorf("unknown node %T", n)
+	}
+}
+
+func evalUnary(op string, x any) (any, error) {
+	switch op {
+	case "not":
+		b, ok := x.(bool)
+		if !ok {
+			return nil, fmt.Errorf("not expects a boolean got %s", typeName(x))
+		}
+		return !b, nil
+	case "-":
+		switch x := x.(type) {
+		case float64:
+			return -x, nil
+		default:
+			n, err := toInt(x)
+			if err != nil {
+				return nil, err
+			}
+			return -n, nil
+		}
+	case "~":
+		n, err := toInt(x)
+		if err != nil {
+			return nil, err
+		}
+		return ^n, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+func compare(l, r any) (int, error) {
+	switch lv := l.(type) {
+	case string:
+		rv, ok := r.(string)
+		if !ok {
+			return 0, fmt.Errorf("can't compare string with %s", typeName(r))
+		}
+		return strings.Compare(lv, rv), nil
+	case bool:
+		rv, ok := r.(bool)
+		if !ok {
+			return 0, fmt.Errorf("can't compare boolean with %s", typeName(r))
+		}
+		if lv == rv {
+			return 0, nil
+		}
+		return 1, nil
+	case float64:
+		rf, err := toFloat(r)
+		if err != nil {
+			return 0, err
+		}
+		return compareFloat(lv, rf), nil
+	}
+	if lb, ok := toBytes(l); ok {
+		rb, ok := toBytes(r)
+		if !ok {
+			return 0, fmt.Errorf("can't compare bytes with %s", typeName(r))
+		}
+		return bytes.Compare(lb, rb), nil
+	}
+	if rf, ok := r.(float64); ok {
+		lf, err := toFloat(l)
+		if err != nil {
+			return 0, err
+		}
+		return compareFloat(lf, rf), nil
+	}
+	ln, err := toInt(l)
+	if err != nil {
+		return 0, err
+	}
+	rn, err := toInt(r)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case ln < rn:
+		return -1, nil
+	case ln > rn:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+func compareFloat(l, r float64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func toFloat(v any) (float64, error) {
+	if f, ok := v.(float64); ok {
+		return f, nil
+	}
+	n, err := toInt(v)
+	return float64(n), err
+}
+
+func evalBinary(op string, l, r any) (any, error) {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		c, err := compare(l, r)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "==":
+			return c == 0, nil
+		case "!=":
+			return c != 0, nil
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	case "+":
+		switch lv := l.(type) {
+		case string:
+			rv, ok := r.(string)
+			if !ok {
+				return nil, fmt.Errorf("can't add %s to string", typeName(r))
+			}
+			return lv + rv, nil
+		case []byte:
+			rv, ok := toBytes(r)
+			if !ok {
+				return nil, fmt.Errorf("can't add %s to bytes", typeName(r))
+			}
+			return append(append([]byte{}, lv...), rv...), nil
+		}
+	}
+
+	_, lIsFloat := l.(float64)
+	_, rIsFloat := r.(float64)
+	if lIsFloat || rIsFloat {
+		lf, err := toFloat(l)
+		if err != nil {
+			return nil, err
+		}
+		rf, err := toFloat(r)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "+":
+			return lf + rf, nil
+		case "-":
+			return lf - rf, nil
+		case "*":
+			return lf * rf, nil
+		case "/":
+			return lf / rf, nil
+		case "%":
+			return math.Mod(lf, rf), nil
+		}
+		return nil, fmt.Errorf("%s not supported for floats", op)
+	}
+
+	ln, err := toInt(l)
+	if err != nil {
+		return nil, err
+	}
+	rn, err := toInt(r)
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case "+":
+		return ln + rn, nil
+	case "-":
+		return ln - rn, nil
+	case "*":
+		return ln * rn, nil
+	case "/", "%":
+		if rn == 0 {
+			return nil, errors.New("division by zero")
+		}
+		// integer division and modulo rounds towards negative infinity
+		q, m := ln/rn, ln%rn
+		if m != 0 && (m < 0) != (rn < 0) {
+			q--
+			m += rn
+		}
+		if op == "/" {
+			return q, nil
+		}
+		return m, nil
+	case "<<":
+		return ln << rn, nil
+	case ">>":
+		return ln >> rn, nil
+	case "&":
+		return ln & rn, nil
+	case "|":
+		return ln | rn, nil
+	case "^":
+		return ln ^ rn, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", op)
+}
+
+func evalMethod(x any, name string, args []any) (any, error) {
+	if m, ok := x.(memberer); ok && args == nil {
+		return m.member(name)
+	}
+
+	switch name {
+	case "to_i":
+		switch x := x.(type) {
+		case string:
+			base := int64(10)
+			if len(args) == 1 {
+				b, err := toInt(args[0])
+				if err != nil {
+					return nil, err
+				}
+				base = b
+			}
+			n, err := strconv.ParseInt(x, int(base), 64)
+			if err != nil {
+				return nil, err
+			}
+			return n, nil
+		default:
+			return toInt(x)
+		}
+	case "to_s":
+		switch x := x.(type) {
+		case string:
+			return x, nil
+		case []byte:
+			enc := "UTF-8"
+			if len(args) == 1 {
+				if s, ok := args[0].(string); ok {
+					enc = s
+				}
+			}
+			e, err := textEncoding(enc)
+			if err != nil {
+				return nil, err
+			}
+			return e.NewDecoder().String(string(x))
+		case float64:
+			return strconv.FormatFloat(x, 'f', -1, 64), nil
+		default:
+			n, err := toInt(x)
+			if err != nil {
+				return nil, err
+			}
+			return strconv.FormatInt(n, 10), nil
+		}
+	case "length", "size":
+		switch x := x.(type) {
+		case string:
+			return int64(len([]rune(x))), nil
+		case []byte:
+			return int64(len(x)), nil
+		case []any:
+			return int64(len(x)), nil
+		}
+	case "first", "last", "min", "max":
+		var vs []any
+		switch x := x.(type) {
+		case []byte:
+			for _, b := range x {
+				vs = append(vs, int64(b))
+			}
+		case []any:
+			vs = x
+		default:
+			return nil, fmt.Errorf("%s not supported for %s", name, typeName(x))
+		}
+		if len(vs) == 0 {
+			return nil, fmt.Errorf("%s of empty array", name)
+		}
+		switch name {
+		case "first":
+			return vs[0], nil
+		case "last":
+			return vs[len(vs)-1], nil
+		}
+		m := vs[0]
+		for _, v := range vs[1:] {
+			c, err := compare(v, m)
+			if err != nil {
+				return nil, err
+			}
+			if (name == "min" && c < 0) || (name == "max" && c > 0) {
+				m = v
+			}
+		}
+		return m, nil
+	case "reverse":
+		if s, ok := x.(string); ok {
+			rs := []rune(s)
+			for i, j := 0, len(rs)-1; i < j; i, j = i+1, j-1 {
+				rs[i], rs[j] = rs[j], rs[i]
+			}
+			return string(rs), nil
+		}
+	case "substring":
+		if s, ok := x.(string); ok && len(args) == 2 {
+			from, err := toInt(args[0])
+			if err != nil {
+				return nil, err
+			}
+			to, err := toInt(args[1])
+			if err != nil {
+				return nil, err
+			}
+			rs := []rune(s)
+			if from < 0 || to > int64(len(rs)) || from > to {
+				return nil, fmt.Errorf("substring %d-%d out of range", from, to)
+			}
+			return string(rs[from:to]), nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s has no method or member %s", typeName(x), name)
+}
@@ -0,0 +1,623 @@
+package kaitai
+
+// https://kaitai.io
+// https://doc.kaitai.io/ksy_reference.html
+// TODO: params, imports, io, bit-endian le, type terminator substreams
+
+import (
+	"bytes"
+	"compress/zlib"
+	"embed"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+)
+
+//go:embed kaitai.md
+var kaitaiFS embed.FS
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:         format.KAITAI,
+		Description:  "Kaitai Struct schema",
+		DecodeFn:     decodeKaitai,
+		DefaultInArg: format.KaitaiIn{},
+	})
+	interp.RegisterFS(kaitaiFS)
+}
+
+func textEncoding(name string) (encoding.Encoding, error) {
+	switch strings.ToUpper(name) {
+	case "", "UTF-8", "UTF8", "ASCII":
+		return unicode.UTF8, nil
+	case "UTF-16LE":
+		return decode.UTF16LE, nil
+	case "UTF-16BE":
+		return decode.UTF16BE, nil
+	}
+	e, err := ianaindex.IANA.Encoding(name)
+	if err != nil || e == nil {
+		return nil, fmt.Errorf("unsupported encoding %q", name)
+	}
+	return e, nil
+}
+
+// kIO is the _io value
+type kIO struct{ s *kStruct }
+
+func (i kIO) member(name string) (any, error) {
+	d := i.s.d
+	switch name {
+	case "pos":
+		return (d.Pos() - i.s.ioStart) / 8, nil
+	case "size":
+		return i.s.ioLen / 8, nil
+	case "eof":
+		return i.s.ioLeft() <= 0, nil
+	default:
+		return nil, fmt.Errorf("io has no member %s", name)
+	}
+}
+
+// kStruct is a decoded instance of a user type
+type kStruct struct {
+	typ    *ksyType
+	parent *kStruct
+	root   *kStruct
+	d      *decode.D
+
+	// stream of struct in bits
+	ioStart int64
+	ioLen   int64
+
+	values        map[string]any
+	instanceBusy  map[string]bool
+	repeatItem    any
+	hasRepeatItem bool
+	repeatIndex   int64
+}
+
+func newStruct(typ *ksyType, parent *kStruct) *kStruct {
+	s := &kStruct{
+		typ:          typ,
+		parent:       parent,
+		values:       map[string]any{},
+		instanceBusy: map[string]bool{},
+	}
+	s.root = s
+	if parent != nil {
+		s.root = parent.root
+	}
+	return s
+}
+
+func (s *kStruct) ioLeft() int64 { return s.ioStart + s.ioLen - s.d.Pos() }
+
+func (s *kStruct) lookup(name string) (any, error) {
+	switch name {
+	case "_root":
+		return s.root, nil
+	case "_parent":
+		if s.parent == nil {
+			return nil, fmt.Errorf("%s has no parent", s.typ.name)
+		}
+		return s.parent, nil
+	case "_io":
+		return kIO{s: s}, nil
+	case "_":
+		if !s.hasRepeatItem {
+			return nil, fmt.Errorf("_ used outside repeat-until")
+		}
+		return s.repeatItem, nil
+	case "_index":
+		return s.repeatIndex, nil
+	}
+	if v, ok := s.values[name]; ok {
+		return v, nil
+	}
+	for _, a := range s.typ.instances {
+		if a.id == name {
+			return s.instance(a)
+		}
+	}
+	return nil, fmt.Errorf("%s has no member %s", s.typ.name, name)
+}
+
+func (s *kStruct) member(name string) (any, error) { return s.lookup(name) }
+
+func (s *kStruct) enumValue(path []string) (any, error) {
+	if len(path) < 2 {
+		return nil, fmt.Errorf("invalid enum reference %s", strings.Join(path, "::"))
+	}
+	e := s.typ.lookupEnum(path[0 : len(path)-1])
+	if e == nil {
+		return nil, fmt.Errorf("enum %s not found", strings.Join(path[0:len(path)-1], "::"))
+	}
+	n, ok := e.values[path[len(path)-1]]
+	if !ok {
+		return nil, fmt.Errorf("enum %s has no value %s", e.name, path[len(path)-1])
+	}
+	return enumValue{e: e, n: n}, nil
+}
+
+func (s *kStruct) eval(d *decode.D, e *expr) any {
+	v, err := e.eval(s)
+	if err != nil {
+		d.Fatalf("%s", err)
+	}
+	return v
+}
+
+func (s *kStruct) evalInt(d *decode.D, e *expr) int64 {
+	n, err := e.evalInt(s)
+	if err != nil {
+		d.Fatalf("%s", err)
+	}
+	return n
+}
+
+func (s *kStruct) evalBool(d *decode.D, e *expr) bool {
+	b, err := e.evalBool(s)
+	if err != nil {
+		d.Fatalf("%s", err)
+	}
+	return b
+}
+
+func (s *kStruct) decode(d *decode.D) {
+	s.d = d
+	for _, a := range s.typ.seq {
+		s.decodeAttr(d, a)
+	}
+	for _, a := range s.typ.instances {
+		if _, err := s.instance(a); err != nil {
+			d.Fatalf("%s", err)
+		}
+	}
+}
+
+// instances are decoded or evaluated on first use
+func (s *kStruct) instance(a *ksyAttr) (any, error) {
+	if v, ok := s.values[a.id]; ok {
+		return v, nil
+	}
+	if s.instanceBusy[a.id] {
+		return nil, fmt.Errorf("instance %s depends on itself", a.id)
+	}
+	s.instanceBusy[a.id] = true
+	defer delete(s.instanceBusy, a.id)
+
+	d := s.d
+	if a.value != nil {
+		if a.if_ != nil && !s.evalBool(d, a.if_) {
+			s.values[a.id] = nil
+			return nil, nil
+		}
+		v := s.eval(d, a.value)
+		s.values[a.id] = v
+		s.fieldValue(d, a.id, v)
+		return v, nil
+	}
+
+	pos := d.Pos()
+	if a.pos != nil {
+		d.SeekAbs(s.ioStart + s.evalInt(d, a.pos)*8)
+	}
+	s.decodeAttr(d, a)
+	d.SeekAbs(pos)
+
+	return s.values[a.id], nil
+}
+
+// add value instance as a field if it's a scalar
+func (s *kStruct) fieldValue(d *decode.D, name string, v any) {
+	switch v := v.(type) {
+	case int64:
+		d.FieldValueSint(name, v)
+	case enumValue:
+		d.FieldValueSint(name, v.n, scalar.SintMapSymStr(v.e.names))
+	case float64:
+		d.FieldValueFlt(name, v)
+	case bool:
+		d.FieldValueBool(name, v)
+	case string:
+		d.FieldValueStr(name, v)
+	case []byte:
+		d.FieldValueBitBuf(name, bitio.NewBitReader(v, -1))
+	}
+}
+
+func (s *kStruct) decodeAttr(d *decode.D, a *ksyAttr) {
+	if a.if_ != nil && !s.evalBool(d, a.if_) {
+		s.values[a.id] = nil
+		return
+	}
+	if a.repeat == "" {
+		s.values[a.id] = s.decodeAttrValue(d, a, a.id)
+		return
+	}
+
+	var vs []any
+	d.FieldArray(a.id, func(d *decode.D) {
+		defer func() { s.repeatIndex, s.hasRepeatItem = 0, false }()
+
+		switch a.repeat {
+		case "expr":
+			n := s.evalInt(d, a.repeatExpr)
+			for i := int64(0); i < n; i++ {
+				s.repeatIndex = i
+				vs = append(vs, s.decodeAttrValue(d, a, a.id))
+			}
+		case "eos":
+			for i := int64(0); s.ioLeft() > 0; i++ {
+				s.repeatIndex = i
+				vs = append(vs, s.decodeAttrValue(d, a, a.id))
+			}
+		case "until":
+			for i := int64(0); ; i++ {
+				s.repeatIndex = i
+				v := s.decodeAttrValue(d, a, a.id)
+				vs = append(vs, v)
+				s.repeatItem, s.hasRepeatItem = v, true
+				if s.evalBool(d, a.repeatUntil) {
+					break
+				}
+			}
+		}
+	})
+	s.values[a.id] = vs
+}
+
+func (s *kStruct) switchType(d *decode.D, a *ksyAttr) string {
+	v := s.eval(d, a.switchOn)
+	for _, c := range a.cases {
+		if c.key == nil {
+			return c.typ
+		}
+		if cmp, err := compare(v, s.eval(d, c.key)); err == nil && cmp == 0 {
+			return c.typ
+		}
+	}
+	return ""
+}
+
+func alignToByte(d *decode.D) {
+	if r := d.Pos() % 8; r != 0 {
+		d.SeekRel(8 - r)
+	}
+}
+
+func (s *kStruct) validate(d *decode.D, a *ksyAttr, v any) {
+	if a.valid == nil {
+		return
+	}
+	vd := a.valid
+	cmp := func(e *expr) int {
+		c, err := compare(v, s.eval(d, e))
+		if err != nil {
+			d.Fatalf("%s: valid: %s", a.id, err)
+		}
+		return c
+	}
+	valid := true
+	if vd.eq != nil && cmp(vd.eq) != 0 {
+		valid = false
+	}
+	if vd.min != nil && cmp(vd.min) < 0 {
+		valid = false
+	}
+	if vd.max != nil && cmp(vd.max) > 0 {
+		valid = false
+	}
+	if len(vd.anyOf) > 0 {
+		anyValid := false
+		for _, e := range vd.anyOf {
+			if cmp(e) == 0 {
+				anyValid = true
+				break
+			}
+		}
+		valid = valid && anyValid
+	}
+	if !valid {
+		d.Errorf("%s: not valid", a.id)
+	}
+}
+
+func (s *kStruct) decodeAttrValue(d *decode.D, a *ksyAttr, name string) any {
+	typ := a.typ
+	if a.switchOn != nil {
+		typ = s.switchType(d, a)
+	}
+
+	if a.contents != nil {
+		alignToByte(d)
+		d.FieldRawLen(name, int64(len(a.contents))*8, d.AssertBitBuf(a.contents))
+		return a.contents
+	}
+
+	if bt, ok := parseBuiltinType(typ); ok {
+		v := s.decodeBuiltin(d, a, name, bt)
+		s.validate(d, a, v)
+		return v
+	}
+
+	alignToByte(d)
+	nBytes := int64(-1)
+	switch {
+	case a.size != nil:
+		nBytes = s.evalInt(d, a.size)
+	case a.sizeEOS:
+		nBytes = s.ioLeft() / 8
+	}
+
+	if typ == "" || typ == "str" {
+		v := s.decodeBytes(d, a, name, typ, nBytes)
+		s.validate(d, a, v)
+		return v
+	}
+
+	ut := s.typ.lookupType(typ)
+	if ut == nil {
+		d.Fatalf("%s: unknown type %s", a.id, typ)
+	}
+	cs := newStruct(ut, s)
+	switch {
+	case a.process != nil:
+		if nBytes < 0 {
+			d.Fatalf("%s: process requires size or size-eos", a.id)
+		}
+		b := d.BytesRange(d.Pos(), int(nBytes))
+		d.FieldRawLen(name+"_raw", nBytes*8)
+		pb := s.process(d, a, b)
+		cs.ioLen = int64(len(pb)) * 8
+		d.FieldStructRootBitBufFn(name, bitio.NewBitReader(pb, -1), cs.decode)
+	case nBytes >= 0:
+		d.FramedFn(nBytes*8, func(d *decode.D) {
+			cs.ioStart, cs.ioLen = d.Pos(), nBytes*8
+			d.FieldStruct(name, cs.decode)
+		})
+	default:
+		cs.ioStart, cs.ioLen = s.ioStart, s.ioLen
+		d.FieldStruct(name, cs.decode)
+	}
+
+	return cs
+}
+
+func (s *kStruct) decodeBuiltin(d *decode.D, a *ksyAttr, name string, bt builtinType) any {
+	var e *ksyEnum
+	if a.enum != "" {
+		e = s.typ.lookupEnum(strings.Split(a.enum, "::"))
+		if e == nil {
+			d.Fatalf("%s: enum %s not found", a.id, a.enum)
+		}
+	}
+
+	endian := s.typ.endian
+	switch bt.endian {
+	case "le":
+		endian = decode.LittleEndian
+	case "be":
+		endian = decode.BigEndian
+	default:
+		if !s.typ.hasEndian && bt.kind != "b" && bt.nBits > 8 {
+			d.Fatalf("%s: no default endian, use meta endian or explicit le or be", a.id)
+		}
+	}
+
+	var n int64
+	switch bt.kind {
+	case "u":
+		alignToByte(d)
+		var sms []scalar.UintMapper
+		if e != nil {
+			sms = append(sms, enumUintMapper(e))
+		}
+		n = int64(d.FieldUE(name, bt.nBits, endian, sms...))
+	case "s":
+		alignToByte(d)
+		var sms []scalar.SintMapper
+		if e != nil {
+			sms = append(sms, scalar.SintMapSymStr(e.names))
+		}
+		n = d.FieldSE(name, bt.nBits, endian, sms...)
+	case "f":
+		alignToByte(d)
+		return d.FieldFE(name, bt.nBits, endian)
+	case "b":
+		if bt.nBits == 1 && e == nil {
+			return d.FieldBool(name)
+		}
+		var sms []scalar.UintMapper
+		if e != nil {
+			sms = append(sms, enumUintMapper(e))
+		}
+		n = int64(d.FieldU(name, bt.nBits, sms...))
+	}
+
+	if e != nil {
+		return enumValue{e: e, n: n}
+	}
+	return n
+}
+
+func enumUintMapper(e *ksyEnum) scalar.UintMapSymStr {
+	m := scalar.UintMapSymStr{}
+	for n, s := range e.names {
+		m[uint64(n)] = s
+	}
+	return m
+}
+
+// raw bytes or string, nBytes -1 means use terminator
+func (s *kStruct) decodeBytes(d *decode.D, a *ksyAttr, name string, typ string, nBytes int64) any {
+	start := d.Pos()
+	var b []byte
+	switch {
+	case nBytes >= 0:
+		if a.process != nil {
+			b = d.BytesRange(d.Pos(), int(nBytes))
+			d.FieldRawLen(name+"_raw", nBytes*8)
+			b = s.process(d, a, b)
+		} else {
+			b = d.BytesLen(int(nBytes))
+		}
+		if a.terminator >= 0 {
+			if i := bytes.IndexByte(b, byte(a.terminator)); i != -1 {
+				if a.include {
+					i++
+				}
+				b = b[0:i]
+			}
+		}
+		if a.padRight >= 0 {
+			b = bytes.TrimRight(b, string([]byte{byte(a.padRight)}))
+		}
+	case a.terminator >= 0:
+		for {
+			c := d.U8()
+			if c == uint64(a.terminator) {
+				if a.include {
+					b = append(b, byte(c))
+				}
+				if !a.consume {
+					d.SeekRel(-8)
+				}
+				break
+			}
+			b = append(b, byte(c))
+		}
+	default:
+		d.Fatalf("%s: size, size-eos or terminator required", a.id)
+	}
+
+	var v any = b
+	var sv any
+	if typ == "str" {
+		enc := a.encoding
+		if enc == "" {
+			enc = s.typ.encoding
+		}
+		e, err := textEncoding(enc)
+		if err != nil {
+			d.Fatalf("%s: %s", a.id, err)
+		}
+		str, err := e.NewDecoder().String(string(b))
+		if err != nil {
+			d.Fatalf("%s: %s", a.id, err)
+		}
+		v = str
+		sv = &scalar.Str{Actual: str}
+	}
+
+	if a.process != nil && typ != "str" {
+		d.FieldRootBitBuf(name, bitio.NewBitReader(b, -1))
+		return v
+	}
+	if sv == nil {
+		sv = &scalar.BitBuf{Actual: d.BitBufRange(start, d.Pos()-start)}
+	}
+	d.FieldRangeFn(name, start, d.Pos()-start, func() *decode.Value {
+		return &decode.Value{V: sv}
+	})
+
+	return v
+}
+
+// process is xor(key), rol(n), ror(n) or zlib
+func (s *kStruct) process(d *decode.D, a *ksyAttr, b []byte) []byte {
+	var name string
+	var args []any
+	switch n := a.process.n.(type) {
+	case nodeIdent:
+		name = n.name
+	case nodeCall:
+		id, ok := n.x.(nodeIdent)
+		if !ok {
+			d.Fatalf("%s: invalid process %s", a.id, a.process.s)
+		}
+		name = id.name
+		for _, an := range n.args {
+			v, err := evalNode(an, s)
+			if err != nil {
+				d.Fatalf("%s: process: %s", a.id, err)
+			}
+			args = append(args, v)
+		}
+	default:
+		d.Fatalf("%s: invalid process %s", a.id, a.process.s)
+	}
+
+	switch {
+	case name == "zlib":
+		zr, err := zlib.NewReader(bytes.NewReader(b))
+		if err != nil {
+			d.Fatalf("%s: zlib: %s", a.id, err)
+		}
+		pb, err := io.ReadAll(zr)
+		if err != nil {
+			d.Fatalf("%s: zlib: %s", a.id, err)
+		}
+		return pb
+	case name == "xor" && len(args) == 1:
+		key, ok := toBytes(args[0])
+		if !ok {
+			n, err := toInt(args[0])
+			if err != nil {
+				d.Fatalf("%s: xor: %s", a.id, err)
+			}
+			key = []byte{byte(n)}
+		}
+		if len(key) == 0 {
+			d.Fatalf("%s: xor: empty key", a.id)
+		}
+		pb := make([]byte, len(b))
+		for i, c := range b {
+			pb[i] = c ^ key[i%len(key)]
+		}
+		return pb
+	case (name == "rol" || name == "ror") && len(args) == 1:
+		n, err := toInt(args[0])
+		if err != nil {
+			d.Fatalf("%s: %s: %s", a.id, name, err)
+		}
+		n = ((n % 8) + 8) % 8
+		if name == "ror" {
+			n = (8 - n) % 8
+		}
+		pb := make([]byte, len(b))
+		for i, c := range b {
+			pb[i] = c<<n | c>>(8-n)
+		}
+		return pb
+	default:
+		d.Fatalf("%s: unsupported process %s", a.id, a.process.s)
+		return nil
+	}
+}
+
+func decodeKaitai(d *decode.D) any {
+	var ki format.KaitaiIn
+	d.ArgAs(&ki)
+
+	t, err := parseSchema(ki.Schema)
+	if err != nil {
+		d.Fatalf("schema: %s", err)
+	}
+
+	s := newStruct(t, nil)
+	s.ioLen = d.Len()
+	s.decode(d)
+
+	return nil
+}
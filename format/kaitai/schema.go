@@ -0,0 +1,502 @@
+package kaitai
+
+// parses Kaitai Struct YAML schema (.ksy)
+// https://doc.kaitai.io/ksy_reference.html
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/wader/fq/internal/gojqex"
+	"github.com/wader/fq/pkg/decode"
+	"gopkg.in/yaml.v3"
+)
+
+type ksyEnum struct {
+	name   string
+	names  map[int64]string
+	values map[string]int64
+}
+
+type ksyCase struct {
+	key *expr // nil for default case "_"
+	typ string
+}
+
+type ksyValid struct {
+	eq    *expr
+	min   *expr
+	max   *expr
+	anyOf []*expr
+}
+
+type ksyAttr struct {
+	id  string
+	doc string
+
+	typ      string
+	switchOn *expr
+	cases    []ksyCase
+
+	contents   []byte
+	size       *expr
+	sizeEOS    bool
+	encoding   string
+	terminator int // -1 if none
+	consume    bool
+	include    bool
+	padRight   int // -1 if none
+	enum       string
+	process    *expr
+
+	valid       *ksyValid
+	if_         *expr
+	repeat      string // "", "eos", "expr" or "until"
+	repeatExpr  *expr
+	repeatUntil *expr
+
+	// instances only
+	pos   *expr
+	value *expr
+}
+
+type ksyType struct {
+	name   string
+	parent *ksyType // enclosing type, used to look up types and enums
+
+	endian    decode.Endian
+	hasEndian bool
+	encoding  string
+
+	seq       []*ksyAttr
+	instances []*ksyAttr
+	types     map[string]*ksyType
+	enums     map[string]*ksyEnum
+}
+
+// schema errors are panics with schemaError
+type schemaError struct{ err error }
+
+func schemaErrorf(format string, a ...any) {
+	panic(schemaError{err: fmt.Errorf(format, a...)})
+}
+
+func asMap(v any, what string) map[string]any {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case map[string]any:
+		return v
+	default:
+		schemaErrorf("%s: expected an object", what)
+		return nil
+	}
+}
+
+func asString(v any, what string) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		schemaErrorf("%s: expected a string", what)
+		return ""
+	}
+}
+
+func asExpr(v any, what string) *expr {
+	if v == nil {
+		return nil
+	}
+	e, err := parseExpr(asString(v, what))
+	if err != nil {
+		schemaErrorf("%s: %s", what, err)
+	}
+	return e
+}
+
+func asInt(v any, what string) int {
+	switch v := v.(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	default:
+		schemaErrorf("%s: expected an integer", what)
+		return 0
+	}
+}
+
+func asBool(v any, what string) bool {
+	b, ok := v.(bool)
+	if !ok {
+		schemaErrorf("%s: expected a boolean", what)
+	}
+	return b
+}
+
+// contents can be a string, array of bytes and strings or a single byte
+func parseContents(v any, what string) []byte {
+	switch v := v.(type) {
+	case string:
+		return []byte(v)
+	case []any:
+		var b []byte
+		for _, e := range v {
+			b = append(b, parseContents(e, what)...)
+		}
+		return b
+	case int:
+		if v < 0 || v > 255 {
+			schemaErrorf("%s: %d is not a byte", what, v)
+		}
+		return []byte{byte(v)}
+	default:
+		schemaErrorf("%s: expected a string or array", what)
+		return nil
+	}
+}
+
+func parseEndian(v any, what string) decode.Endian {
+	switch v {
+	case "le":
+		return decode.LittleEndian
+	case "be":
+		return decode.BigEndian
+	default:
+		schemaErrorf("%s: only le or be endian is supported", what)
+		return decode.BigEndian
+	}
+}
+
+func parseAttr(id string, m map[string]any, what string) *ksyAttr {
+	a := &ksyAttr{
+		id:         id,
+		terminator: -1,
+		consume:    true,
+		padRight:   -1,
+	}
+	for k, v := range m {
+		kWhat := what + "." + k
+		switch k {
+		case "id":
+		case "doc":
+			a.doc = asString(v, kWhat)
+		case "doc-ref", "-orig-id", "eos-error":
+			// ignored
+		case "type":
+			switch v := v.(type) {
+			case map[string]any:
+				a.switchOn = asExpr(v["switch-on"], kWhat+".switch-on")
+				if a.switchOn == nil {
+					schemaErrorf("%s: switch-on missing", kWhat)
+				}
+				cases := asMap(v["cases"], kWhat+".cases")
+				// sort to make order stable, default case last
+				var keys []string
+				for ck := range cases {
+					keys = append(keys, ck)
+				}
+				sort.Strings(keys)
+				var defaultCase *ksyCase
+				for _, ck := range keys {
+					typ := asString(cases[ck], kWhat+".cases."+ck)
+					if ck == "_" {
+						defaultCase = &ksyCase{typ: typ}
+						continue
+					}
+					a.cases = append(a.cases, ksyCase{key: asExpr(ck, kWhat+".cases"), typ: typ})
+				}
+				if defaultCase != nil {
+					a.cases = append(a.cases, *defaultCase)
+				}
+			default:
+				a.typ = asString(v, kWhat)
+			}
+		case "contents":
+			a.contents = parseContents(v, kWhat)
+		case "size":
+			a.size = asExpr(v, kWhat)
+		case "size-eos":
+			a.sizeEOS = asBool(v, kWhat)
+		case "encoding":
+			a.encoding = asString(v, kWhat)
+		case "terminator":
+			a.terminator = asInt(v, kWhat)
+		case "consume":
+			a.consume = asBool(v, kWhat)
+		case "include":
+			a.include = asBool(v, kWhat)
+		case "pad-right":
+			a.padRight = asInt(v, kWhat)
+		case "enum":
+			a.enum = asString(v, kWhat)
+		case "process":
+			a.process = asExpr(v, kWhat)
+		case "if":
+			a.if_ = asExpr(v, kWhat)
+		case "repeat":
+			a.repeat = asString(v, kWhat)
+			switch a.repeat {
+			case "eos", "expr", "until":
+			default:
+				schemaErrorf("%s: unknown repeat %q", kWhat, a.repeat)
+			}
+		case "repeat-expr":
+			a.repeatExpr = asExpr(v, kWhat)
+		case "repeat-until":
+			a.repeatUntil = asExpr(v, kWhat)
+		case "valid":
+			a.valid = parseValid(v, kWhat)
+		case "pos":
+			a.pos = asExpr(v, kWhat)
+		case "value":
+			a.value = asExpr(v, kWhat)
+		default:
+			schemaErrorf("%s: %s not supported", what, k)
+		}
+	}
+
+	if a.repeat == "expr" && a.repeatExpr == nil {
+		schemaErrorf("%s: repeat-expr missing", what)
+	}
+	if a.repeat == "until" && a.repeatUntil == nil {
+		schemaErrorf("%s: repeat-until missing", what)
+	}
+	if a.typ == "strz" {
+		a.typ = "str"
+		a.terminator = 0
+	}
+
+	return a
+}
+
+// valid is a value to be equal to or an object with eq, min, max or any-of
+func parseValid(v any, what string) *ksyValid {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return &ksyValid{eq: asExpr(v, what)}
+	}
+	vd := &ksyValid{}
+	for k, v := range m {
+		kWhat := what + "." + k
+		switch k {
+		case "eq":
+			vd.eq = asExpr(v, kWhat)
+		case "min":
+			vd.min = asExpr(v, kWhat)
+		case "max":
+			vd.max = asExpr(v, kWhat)
+		case "any-of":
+			vs, ok := v.([]any)
+			if !ok {
+				schemaErrorf("%s: expected an array", kWhat)
+			}
+			for _, e := range vs {
+				vd.anyOf = append(vd.anyOf, asExpr(e, kWhat))
+			}
+		default:
+			schemaErrorf("%s: %s not supported", what, k)
+		}
+	}
+	return vd
+}
+
+func parseEnum(name string, m map[string]any, what string) *ksyEnum {
+	e := &ksyEnum{
+		name:   name,
+		names:  map[int64]string{},
+		values: map[string]int64{},
+	}
+	for k, v := range m {
+		n, err := strconv.ParseInt(k, 0, 64)
+		if err != nil {
+			schemaErrorf("%s: %s: not an integer", what, k)
+		}
+		var id string
+		switch v := v.(type) {
+		case map[string]any:
+			id = asString(v["id"], what+"."+k+".id")
+		default:
+			id = asString(v, what+"."+k)
+		}
+		e.names[n] = id
+		e.values[id] = n
+	}
+	return e
+}
+
+func parseType(name string, parent *ksyType, m map[string]any, what string) *ksyType {
+	t := &ksyType{
+		name:   name,
+		parent: parent,
+		types:  map[string]*ksyType{},
+		enums:  map[string]*ksyEnum{},
+	}
+	if parent != nil {
+		t.endian = parent.endian
+		t.hasEndian = parent.hasEndian
+		t.encoding = parent.encoding
+	}
+
+	if meta := asMap(m["meta"], what+".meta"); meta != nil {
+		if v, ok := meta["endian"]; ok {
+			t.endian = parseEndian(v, what+".meta.endian")
+			t.hasEndian = true
+		}
+		if v, ok := meta["encoding"]; ok {
+			t.encoding = asString(v, what+".meta.encoding")
+		}
+		if v, ok := meta["bit-endian"]; ok && v != "be" {
+			schemaErrorf("%s.meta.bit-endian: only be is supported", what)
+		}
+		if _, ok := meta["imports"]; ok {
+			schemaErrorf("%s.meta.imports: not supported", what)
+		}
+	}
+	if _, ok := m["params"]; ok {
+		schemaErrorf("%s.params: not supported", what)
+	}
+
+	for k, v := range asMap(m["types"], what+".types") {
+		t.types[k] = parseType(k, t, asMap(v, what+".types."+k), what+".types."+k)
+	}
+	for k, v := range asMap(m["enums"], what+".enums") {
+		t.enums[k] = parseEnum(k, asMap(v, what+".enums."+k), what+".enums."+k)
+	}
+
+	if seq, ok := m["seq"]; ok {
+		seqVs, ok := seq.([]any)
+		if !ok {
+			schemaErrorf("%s.seq: expected an array", what)
+		}
+		for i, v := range seqVs {
+			aWhat := fmt.Sprintf("%s.seq[%d]", what, i)
+			am := asMap(v, aWhat)
+			id := fmt.Sprintf("unnamed%d", i)
+			if v, ok := am["id"]; ok {
+				id = asString(v, aWhat+".id")
+			}
+			t.seq = append(t.seq, parseAttr(id, am, aWhat))
+		}
+	}
+
+	instances := asMap(m["instances"], what+".instances")
+	var instanceNames []string
+	for k := range instances {
+		instanceNames = append(instanceNames, k)
+	}
+	sort.Strings(instanceNames)
+	for _, k := range instanceNames {
+		t.instances = append(t.instances, parseAttr(k, asMap(instances[k], what+".instances."+k), what+".instances."+k))
+	}
+
+	return t
+}
+
+// parseSchema parses YAML schema
+func parseSchema(schema string) (t *ksyType, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(schemaError)
+			if !ok {
+				panic(r)
+			}
+			err = se.err
+		}
+	}()
+
+	if schema == "" {
+		return nil, fmt.Errorf("no schema, use schema option, ex: -o schema=@file.ksy")
+	}
+	var m any
+	if err := yaml.Unmarshal([]byte(schema), &m); err != nil {
+		return nil, err
+	}
+
+	sm := asMap(gojqex.Normalize(m), "schema")
+	name := "root"
+	if meta := asMap(sm["meta"], "meta"); meta != nil {
+		if id, ok := meta["id"]; ok {
+			name = asString(id, "meta.id")
+		}
+	}
+
+	return parseType(name, nil, sm, name), nil
+}
+
+// look up user type, can be a path like a::b
+func (t *ksyType) lookupType(name string) *ksyType {
+	parts := strings.Split(name, "::")
+	for st := t; st != nil; st = st.parent {
+		ft := st
+		for _, p := range parts {
+			ft = ft.types[p]
+			if ft == nil {
+				break
+			}
+		}
+		if ft != nil {
+			return ft
+		}
+	}
+	return nil
+}
+
+// look up enum, can be a path like type::enum
+func (t *ksyType) lookupEnum(path []string) *ksyEnum {
+	for st := t; st != nil; st = st.parent {
+		ft := st
+		for _, p := range path[0 : len(path)-1] {
+			ft = ft.types[p]
+			if ft == nil {
+				break
+			}
+		}
+		if ft == nil {
+			continue
+		}
+		if e, ok := ft.enums[path[len(path)-1]]; ok {
+			return e
+		}
+	}
+	return nil
+}
+
+var builtinTypeRe = regexp.MustCompile(`^(?:([us])([1248])|f([48])|b([1-9][0-9]*))(le|be)?$`)
+
+type builtinType struct {
+	kind   string // u, s, f or b
+	nBits  int
+	endian string // "", le or be
+}
+
+func parseBuiltinType(typ string) (builtinType, bool) {
+	sm := builtinTypeRe.FindStringSubmatch(typ)
+	if sm == nil {
+		return builtinType{}, false
+	}
+	bt := builtinType{endian: sm[5]}
+	switch {
+	case sm[1] != "":
+		bt.kind = sm[1]
+		n, _ := strconv.Atoi(sm[2])
+		bt.nBits = n * 8
+	case sm[3] != "":
+		bt.kind = "f"
+		n, _ := strconv.Atoi(sm[3])
+		bt.nBits = n * 8
+	default:
+		bt.kind = "b"
+		bt.nBits, _ = strconv.Atoi(sm[4])
+	}
+	return bt, true
+}
@@ -29,8 +29,10 @@ import (
 var matroskaFS embed.FS
 
 var aacFrameFormat decode.Group
+var ac3FrameFormat decode.Group
 var av1CCRFormat decode.Group
 var av1FrameFormat decode.Group
+var eac3FrameFormat decode.Group
 var flacFrameFormat decode.Group
 var flacMetadatablocksFormat decode.Group
 var imageFormat decode.Group
@@ -47,6 +49,8 @@ var vorbisPacketFormat decode.Group
 var vp8FrameFormat decode.Group
 var vp9CFMFormat decode.Group
 var vp9FrameFormat decode.Group
+var vvcAUFormat decode.Group
+var vvcDCRFormat decode.Group
 
 var codecToFormat map[string]*decode.Group
 
@@ -61,10 +65,12 @@ func init() {
 		},
 		Dependencies: []decode.Dependency{
 			{Names: []string{format.AAC_FRAME}, Group: &aacFrameFormat},
+			{Names: []string{format.AC3_FRAME}, Group: &ac3FrameFormat},
 			{Names: []string{format.AV1_CCR}, Group: &av1CCRFormat},
 			{Names: []string{format.AV1_FRAME}, Group: &av1FrameFormat},
 			{Names: []string{format.AVC_AU}, Group: &mpegAVCAUFormat},
 			{Names: []string{format.AVC_DCR}, Group: &mpegAVCDCRFormat},
+			{Names: []string{format.EAC3_FRAME}, Group: &eac3FrameFormat},
 			{Names: []string{format.FLAC_FRAME}, Group: &flacFrameFormat},
 			{Names: []string{format.FLAC_METADATABLOCKS}, Group: &flacMetadatablocksFormat},
 			{Names: []string{format.HEVC_AU}, Group: &mpegHEVCSampleFormat},
@@ -79,6 +85,8 @@ func init() {
 			{Names: []string{format.VP8_FRAME}, Group: &vp8FrameFormat},
 			{Names: []string{format.VP9_CFM}, Group: &vp9CFMFormat},
 			{Names: []string{format.VP9_FRAME}, Group: &vp9FrameFormat},
+			{Names: []string{format.VVC_AU}, Group: &vvcAUFormat},
+			{Names: []string{format.VVC_DCR}, Group: &vvcDCRFormat},
 		},
 	})
 	interp.RegisterFS(matroskaFS)
@@ -89,12 +97,17 @@ func init() {
 		"A_FLAC":           &flacFrameFormat,
 		"A_AAC":            &aacFrameFormat,
 		"A_OPUS":           &opusPacketFrameFormat,
+		"A_AC3":            &ac3FrameFormat,
+		"A_AC3/BSID9":      &ac3FrameFormat,
+		"A_AC3/BSID10":     &ac3FrameFormat,
+		"A_EAC3":           &eac3FrameFormat,
 		"V_VP8":            &vp8FrameFormat,
 		"V_VP9":            &vp9FrameFormat,
 		"V_AV1":            &av1FrameFormat,
 		"V_VOBSUB":         &mpegSPUFrameFormat,
 		"V_MPEG4/ISO/AVC":  &mpegAVCAUFormat,
 		"V_MPEGH/ISO/HEVC": &mpegHEVCSampleFormat,
+		"V_MPEGI/ISO/VVC":  &vvcAUFormat,
 		"V_MPEG2":          &mpegPESPacketSampleFormat,
 		"S_VOBSUB":         &mpegSPUFrameFormat,
 	}
@@ -249,9 +262,37 @@ type block struct {
 }
 
 type decodeContext struct {
+	mi           format.MatroskaIn
 	currentTrack *track
 	tracks       []*track
 	blocks       []block
+	// set when codec private for all tracks has been decoded
+	trackNumberToTrack map[int]*track
+}
+
+// lazyElementLen returns length in bits of element at current position if it
+// can be decoded lazily. Clusters are most of a file and don't change tracks.
+func lazyElementLen(d *decode.D, bitsLimit int64) (int64, bool) {
+	// id and size vint are at most 8 bytes each
+	if d.BitsLeft() < 16*8 {
+		return 0, false
+	}
+	var nBits int64
+	var ok bool
+	d.SeekRel(0, func(d *decode.D) {
+		start := d.Pos()
+		if decodeRawVint(d) != ebml_matroska.ClusterID {
+			return
+		}
+		tagSize := decodeVint(d)
+		if tagSize == tagSizeUnknown || tagSize > uint64(d.BitsLeft()/8) {
+			return
+		}
+		nBits = d.Pos() - start + int64(tagSize)*8
+		ok = nBits <= bitsLimit
+	})
+
+	return nBits, ok
 }
 
 func decodeMaster(d *decode.D, bitsLimit int64, elm *ebml.Master, unknownSize bool, dc *decodeContext) {
@@ -275,169 +316,187 @@ func decodeMaster(d *decode.D, bitsLimit int64, elm *ebml.Master, unknownSize bo
 				}
 			}
 
-			d.FieldStruct("element", func(d *decode.D) {
-				var childElm ebml.Element
-				childElm = &ebml.Unknown{}
-
-				tagID := d.FieldUintFn("id", decodeRawVint, scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
-					n := s.Actual
-					var ok bool
-					childElm, ok = elm.Master[ebml.ID(n)]
-					if !ok {
-						childElm, ok = ebml.Global.Master[ebml.ID(n)]
-						if !ok {
-							childElm = &ebml.Unknown{}
-							return scalar.Uint{Actual: n, DisplayFormat: scalar.NumberHex, Description: "Unknown"}, nil
-						}
+			if nBits, ok := lazyElementLen(d, tagEndBit-d.Pos()); ok {
+				d.FieldStructLazyFn("element", nBits, func(d *decode.D) {
+					// own context as element might be decoded later
+					edc := &decodeContext{mi: dc.mi}
+					decodeElement(d, elm, edc)
+					if dc.trackNumberToTrack == nil {
+						dc.blocks = append(dc.blocks, edc.blocks...)
+						return
+					}
+					for _, b := range edc.blocks {
+						decodeBlock(b, dc)
 					}
-					return scalar.Uint{
-						Actual:        n,
-						DisplayFormat: scalar.NumberHex,
-						Sym:           childElm.GetName(),
-						Description:   childElm.GetDefinition(),
-					}, nil
-				}))
-				d.FieldValueStr("type", childElm.GetType())
-
-				if tagID == ebml_matroska.TrackEntryID {
-					dc.currentTrack = &track{}
-					dc.tracks = append(dc.tracks, dc.currentTrack)
-				}
-
-				const maxStringTagSize = 100 * 1024 * 1024
-				tagSize := d.FieldUintFn("size", decodeVint, scalar.UintMapDescription{
-					0xffffffffffffff: "Unknown size",
 				})
-				unknownSize := tagSize == tagSizeUnknown
-				if unknownSize {
-					tagSize = uint64(d.BitsLeft() / 8)
-				}
+				continue
+			}
 
-				// assert sane tag size
-				// TODO: strings are limited for now because they are read into memory
-				switch childElm.(type) {
-				case *ebml.Integer,
-					*ebml.Uinteger,
-					*ebml.Float:
-					if tagSize > 8 {
-						d.Fatalf("invalid tagSize %d for number type", tagSize)
-					}
-				case *ebml.String,
-					*ebml.UTF8:
-					if tagSize > maxStringTagSize {
-						d.Errorf("tagSize %d > maxStringTagSize %d", tagSize, maxStringTagSize)
-					}
-				case *ebml.Unknown,
-					*ebml.Binary,
-					*ebml.Date,
-					*ebml.Master:
-					// nop
-				}
+			d.FieldStruct("element", func(d *decode.D) { decodeElement(d, elm, dc) })
+		}
+	})
+}
 
-				switch childElm := childElm.(type) {
-				case *ebml.Unknown:
-					d.FieldRawLen("data", int64(tagSize)*8)
-				case *ebml.Integer:
-					var sm []scalar.SintMapper
-					if childElm.Enums != nil {
-						sm = append(sm, scalar.SintFn(func(s scalar.Sint) (scalar.Sint, error) {
-							if e, ok := childElm.Enums[s.Actual]; ok {
-								s.Sym = e.Name
-								s.Description = e.Description
-							}
-							return s, nil
-						}))
-					}
-					d.FieldS("value", int(tagSize)*8, sm...)
-				case *ebml.Uinteger:
-					var sm []scalar.UintMapper
-					if childElm.Enums != nil {
-						sm = append(sm, scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
-							if e, ok := childElm.Enums[s.Actual]; ok {
-								s.Sym = e.Name
-								s.Description = e.Description
-							}
-							return s, nil
-						}))
-					}
-					v := d.FieldU("value", int(tagSize)*8, sm...)
-					if dc.currentTrack != nil && tagID == ebml_matroska.TrackNumberID {
-						dc.currentTrack.number = int(v)
-					}
-				case *ebml.Float:
-					d.FieldF("value", int(tagSize)*8)
-				case *ebml.String:
-					var sm []scalar.StrMapper
-					sm = append(sm, scalar.StrFn(func(s scalar.Str) (scalar.Str, error) {
-						if e, ok := childElm.Enums[s.Actual]; ok {
-							s.Sym = e.Name
-							s.Description = e.Description
-						}
-						return s, nil
-					}))
-					v := d.FieldUTF8("value", int(tagSize), sm...)
-					if dc.currentTrack != nil && tagID == ebml_matroska.CodecIDID {
-						dc.currentTrack.codec = v
-					}
-				case *ebml.UTF8:
-					d.FieldUTF8NullFixedLen("value", int(tagSize))
-				case *ebml.Date:
-					// TODO:
-					/*
-						proc type_date {size label _extra} {
-						    set s [clock scan {2001-01-01 00:00:00}]
-						    set frac 0
-						    switch $size {
-						        0 {}
-						        8 {
-						            set nano [int64]
-						            set s [clock add $s [expr $nano/1000000000] seconds]
-						            set frac [expr ($nano%1000000000)/1000000000.0]
-						        }
-						        default {
-						            bytes $size $label
-						            return
-						        }
-						    }
-
-						    entry $label "[clock format $s] ${frac}s" $size [expr [pos]-$size]
-						}
-					*/
-					d.FieldRawLen("value", int64(tagSize)*8)
-				case *ebml.Binary:
-					switch tagID {
-					case ebml_matroska.SimpleBlockID:
-						dc.blocks = append(dc.blocks, block{
-							d:      d,
-							r:      ranges.Range{Start: d.Pos(), Len: int64(tagSize) * 8},
-							simple: true,
-						})
-						d.SeekRel(int64(tagSize) * 8)
-					case ebml_matroska.BlockID:
-						dc.blocks = append(dc.blocks, block{
-							d: d,
-							r: ranges.Range{Start: d.Pos(), Len: int64(tagSize) * 8},
-						})
-						d.SeekRel(int64(tagSize) * 8)
-					case ebml_matroska.CodecPrivateID:
-						if dc.currentTrack != nil {
-							dc.currentTrack.parentD = d
-							dc.currentTrack.codecPrivatePos = d.Pos()
-							dc.currentTrack.codecPrivateTagSize = int64(tagSize) * 8
-						}
-						d.SeekRel(int64(tagSize) * 8)
-					case ebml_matroska.FileDataID:
-						d.FieldFormatOrRawLen("value", int64(tagSize)*8, imageFormat, nil)
-					default:
-						d.FieldRawLen("value", int64(tagSize)*8)
-					}
+func decodeElement(d *decode.D, elm *ebml.Master, dc *decodeContext) {
+	var childElm ebml.Element
+	childElm = &ebml.Unknown{}
+
+	tagID := d.FieldUintFn("id", decodeRawVint, scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+		n := s.Actual
+		var ok bool
+		childElm, ok = elm.Master[ebml.ID(n)]
+		if !ok {
+			childElm, ok = ebml.Global.Master[ebml.ID(n)]
+			if !ok {
+				childElm = &ebml.Unknown{}
+				return scalar.Uint{Actual: n, DisplayFormat: scalar.NumberHex, Description: "Unknown"}, nil
+			}
+		}
+		return scalar.Uint{
+			Actual:        n,
+			DisplayFormat: scalar.NumberHex,
+			Sym:           childElm.GetName(),
+			Description:   childElm.GetDefinition(),
+		}, nil
+	}))
+	d.FieldValueStr("type", childElm.GetType())
+
+	if tagID == ebml_matroska.TrackEntryID {
+		dc.currentTrack = &track{}
+		dc.tracks = append(dc.tracks, dc.currentTrack)
+	}
+
+	const maxStringTagSize = 100 * 1024 * 1024
+	tagSize := d.FieldUintFn("size", decodeVint, scalar.UintMapDescription{
+		0xffffffffffffff: "Unknown size",
+	})
+	unknownSize := tagSize == tagSizeUnknown
+	if unknownSize {
+		tagSize = uint64(d.BitsLeft() / 8)
+	}
+
+	// assert sane tag size
+	// TODO: strings are limited for now because they are read into memory
+	switch childElm.(type) {
+	case *ebml.Integer,
+		*ebml.Uinteger,
+		*ebml.Float:
+		if tagSize > 8 {
+			d.Fatalf("invalid tagSize %d for number type", tagSize)
+		}
+	case *ebml.String,
+		*ebml.UTF8:
+		if tagSize > maxStringTagSize {
+			d.Errorf("tagSize %d > maxStringTagSize %d", tagSize, maxStringTagSize)
+		}
+	case *ebml.Unknown,
+		*ebml.Binary,
+		*ebml.Date,
+		*ebml.Master:
+		// nop
+	}
 
-				case *ebml.Master:
-					decodeMaster(d, int64(tagSize)*8, childElm, unknownSize, dc)
+	switch childElm := childElm.(type) {
+	case *ebml.Unknown:
+		d.FieldRawLen("data", int64(tagSize)*8)
+	case *ebml.Integer:
+		var sm []scalar.SintMapper
+		if childElm.Enums != nil {
+			sm = append(sm, scalar.SintFn(func(s scalar.Sint) (scalar.Sint, error) {
+				if e, ok := childElm.Enums[s.Actual]; ok {
+					s.Sym = e.Name
+					s.Description = e.Description
 				}
+				return s, nil
+			}))
+		}
+		d.FieldS("value", int(tagSize)*8, sm...)
+	case *ebml.Uinteger:
+		var sm []scalar.UintMapper
+		if childElm.Enums != nil {
+			sm = append(sm, scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+				if e, ok := childElm.Enums[s.Actual]; ok {
+					s.Sym = e.Name
+					s.Description = e.Description
+				}
+				return s, nil
+			}))
+		}
+		v := d.FieldU("value", int(tagSize)*8, sm...)
+		if dc.currentTrack != nil && tagID == ebml_matroska.TrackNumberID {
+			dc.currentTrack.number = int(v)
+		}
+	case *ebml.Float:
+		d.FieldF("value", int(tagSize)*8)
+	case *ebml.String:
+		var sm []scalar.StrMapper
+		sm = append(sm, scalar.StrFn(func(s scalar.Str) (scalar.Str, error) {
+			if e, ok := childElm.Enums[s.Actual]; ok {
+				s.Sym = e.Name
+				s.Description = e.Description
+			}
+			return s, nil
+		}))
+		v := d.FieldUTF8("value", int(tagSize), sm...)
+		if dc.currentTrack != nil && tagID == ebml_matroska.CodecIDID {
+			dc.currentTrack.codec = v
+		}
+	case *ebml.UTF8:
+		d.FieldUTF8NullFixedLen("value", int(tagSize))
+	case *ebml.Date:
+		// TODO:
+		/*
+			proc type_date {size label _extra} {
+			    set s [clock scan {2001-01-01 00:00:00}]
+			    set frac 0
+			    switch $size {
+			        0 {}
+			        8 {
+			            set nano [int64]
+			            set s [clock add $s [expr $nano/1000000000] seconds]
+			            set frac [expr ($nano%1000000000)/1000000000.0]
+			        }
+			        default {
+			            bytes $size $label
+			            return
+			        }
+			    }
+
+			    entry $label "[clock format $s] ${frac}s" $size [expr [pos]-$size]
+			}
+		*/
+		d.FieldRawLen("value", int64(tagSize)*8)
+	case *ebml.Binary:
+		switch tagID {
+		case ebml_matroska.SimpleBlockID:
+			dc.blocks = append(dc.blocks, block{
+				d:      d,
+				r:      ranges.Range{Start: d.Pos(), Len: int64(tagSize) * 8},
+				simple: true,
+			})
+			d.SeekRel(int64(tagSize) * 8)
+		case ebml_matroska.BlockID:
+			dc.blocks = append(dc.blocks, block{
+				d: d,
+				r: ranges.Range{Start: d.Pos(), Len: int64(tagSize) * 8},
 			})
+			d.SeekRel(int64(tagSize) * 8)
+		case ebml_matroska.CodecPrivateID:
+			if dc.currentTrack != nil {
+				dc.currentTrack.parentD = d
+				dc.currentTrack.codecPrivatePos = d.Pos()
+				dc.currentTrack.codecPrivateTagSize = int64(tagSize) * 8
+			}
+			d.SeekRel(int64(tagSize) * 8)
+		case ebml_matroska.FileDataID:
+			d.FieldFormatOrRawLen("value", int64(tagSize)*8, imageFormat, nil)
+		default:
+			d.FieldRawLen("value", int64(tagSize)*8)
 		}
-	})
+
+	case *ebml.Master:
+		decodeMaster(d, int64(tagSize)*8, childElm, unknownSize, dc)
+	}
 }
 
 func matroskaDecode(d *decode.D) any {
@@ -448,7 +507,7 @@ func matroskaDecode(d *decode.D) any {
 	if d.PeekUintBits(32) != ebmlHeaderID {
 		d.Errorf("no EBML header found")
 	}
-	dc := &decodeContext{tracks: []*track{}}
+	dc := &decodeContext{mi: mi, tracks: []*track{}}
 	decodeMaster(d, d.BitsLeft(), ebml_matroska.RootElement, false, dc)
 
 	trackNumberToTrack := map[int]*track{}
@@ -512,6 +571,13 @@ func matroskaDecode(d *decode.D) any {
 				panic(fmt.Sprintf("expected HevcDcrOut got %#+v", v))
 			}
 			t.formatInArg = format.HevcAuIn{LengthSize: hevcDcrOut.LengthSize} //nolint:gosimple
+		case "V_MPEGI/ISO/VVC":
+			_, v := t.parentD.FieldFormatRange("value", t.codecPrivatePos, t.codecPrivateTagSize, vvcDCRFormat, nil)
+			vvcDcrOut, ok := v.(format.VvcDcrOut)
+			if !ok {
+				panic(fmt.Sprintf("expected VvcDcrOut got %#+v", v))
+			}
+			t.formatInArg = format.VvcAuIn{LengthSize: vvcDcrOut.LengthSize} //nolint:gosimple
 		case "V_AV1":
 			t.parentD.FieldFormatRange("value", t.codecPrivatePos, t.codecPrivateTagSize, av1CCRFormat, nil)
 		case "V_VP9":
@@ -523,44 +589,49 @@ func matroskaDecode(d *decode.D) any {
 		}
 	}
 
+	dc.trackNumberToTrack = trackNumberToTrack
 	for _, b := range dc.blocks {
-		b.d.RangeFn(b.r.Start, b.r.Len, func(d *decode.D) {
-			var lacing uint64
-			trackNumber := d.FieldUintFn("track_number", decodeVint)
-			d.FieldU16("timestamp")
-			if b.simple {
-				d.FieldStruct("flags", func(d *decode.D) {
-					d.FieldBool("key_frame")
-					d.FieldU3("reserved")
-					d.FieldBool("invisible")
-					lacing = d.FieldU2("lacing", lacingTypeNames)
-					d.FieldBool("discardable")
-				})
-			} else {
-				d.FieldStruct("flags", func(d *decode.D) {
-					d.FieldU4("reserved")
-					d.FieldBool("invisible")
-					lacing = d.FieldU2("lacing", lacingTypeNames)
-					d.FieldBool("not_used")
-				})
-			}
+		decodeBlock(b, dc)
+	}
 
-			var f *decode.Group
-			var track *track
-			track, trackOk := trackNumberToTrack[int(trackNumber)]
-			if trackOk {
-				f = codecToFormat[track.codec]
-			}
+	return nil
+}
 
-			decodeLacingFn(d, int(lacing), func(d *decode.D) {
-				if mi.DecodeSamples && f != nil {
-					d.FieldFormat("packet", *f, track.formatInArg)
-				} else {
-					d.FieldRawLen("packet", d.BitsLeft())
-				}
+func decodeBlock(b block, dc *decodeContext) {
+	b.d.RangeFn(b.r.Start, b.r.Len, func(d *decode.D) {
+		var lacing uint64
+		trackNumber := d.FieldUintFn("track_number", decodeVint)
+		d.FieldS16("timestamp")
+		if b.simple {
+			d.FieldStruct("flags", func(d *decode.D) {
+				d.FieldBool("key_frame")
+				d.FieldU3("reserved")
+				d.FieldBool("invisible")
+				lacing = d.FieldU2("lacing", lacingTypeNames)
+				d.FieldBool("discardable")
 			})
-		})
-	}
+		} else {
+			d.FieldStruct("flags", func(d *decode.D) {
+				d.FieldU4("reserved")
+				d.FieldBool("invisible")
+				lacing = d.FieldU2("lacing", lacingTypeNames)
+				d.FieldBool("not_used")
+			})
+		}
 
-	return nil
+		var f *decode.Group
+		var track *track
+		track, trackOk := dc.trackNumberToTrack[int(trackNumber)]
+		if trackOk {
+			f = codecToFormat[track.codec]
+		}
+
+		decodeLacingFn(d, int(lacing), func(d *decode.D) {
+			if dc.mi.DecodeSamples && f != nil {
+				d.FieldFormat("packet", *f, track.formatInArg)
+			} else {
+				d.FieldRawLen("packet", d.BitsLeft())
+			}
+		})
+	})
 }
@@ -169,6 +169,8 @@ var dataFormatNames = scalar.StrMapDescription{
 	"vc-1": "SMPTE VC-1",
 	"vp08": "VP8 video",
 	"vp09": "VP9 video",
+	"vvc1": "Versatile Video Coding",
+	"vvi1": "Versatile Video Coding",
 	"wvtt": "WebVTT",
 }
 
@@ -286,12 +288,65 @@ func decodeBoxes(ctx *decodeContext, d *decode.D, extraTypeMappers ...scalar.Str
 	decodeBoxesWithParentData(ctx, d, nil, extraTypeMappers...)
 }
 
+// boxes that only have raw data and don't use or update the decode context
+var lazyBoxTypes = map[string]bool{
+	"mdat": true,
+	"free": true,
+	"skip": true,
+	"wide": true,
+}
+
+// lazyBoxLen returns length in bits of box at current position if it can be decoded lazily
+func lazyBoxLen(ctx *decodeContext, d *decode.D) (int64, bool) {
+	// parents that decode unknown boxes differently
+	switch ctx.path[len(ctx.path)-1].typ {
+	case "ipco", "iref", "ilst", "udta":
+		return 0, false
+	}
+	if d.BitsLeft() < 16*8 {
+		return 0, false
+	}
+	typ := string(d.PeekBytes(8)[4:])
+	if !lazyBoxTypes[typ] {
+		return 0, false
+	}
+
+	var nBits int64
+	var headerBits int64 = 8 * 8
+	switch boxSize := d.PeekUintBits(32); boxSize {
+	case boxSizeRestOfFile:
+		nBits = d.BitsLeft()
+	case boxSizeUse64bitSize:
+		headerBits = 16 * 8
+		d.SeekRel(8*8, func(d *decode.D) { boxSize = d.U64() })
+		nBits = int64(boxSize) * 8
+	default:
+		nBits = int64(boxSize) * 8
+	}
+	// let broken or truncated boxes be decoded and reported as usual
+	if nBits < headerBits || nBits > d.BitsLeft() {
+		return 0, false
+	}
+
+	return nBits, true
+}
+
 func decodeBoxesWithParentData(ctx *decodeContext, d *decode.D, parentData any, extraTypeMappers ...scalar.StrMapper) {
-	d.FieldStructArrayLoop("boxes", "box",
-		func() bool { return d.BitsLeft() >= 8*8 },
-		func(d *decode.D) {
-			decodeBoxWithParentData(ctx, d, parentData, extraTypeMappers...)
-		})
+	d.FieldArray("boxes", func(d *decode.D) {
+		for d.BitsLeft() >= 8*8 {
+			if nBits, ok := lazyBoxLen(ctx, d); ok {
+				d.FieldStructLazyFn("box", nBits, func(d *decode.D) {
+					// own context as box might be decoded later
+					lctx := &decodeContext{opts: ctx.opts, path: []pathEntry{{typ: "root"}}}
+					decodeBoxWithParentData(lctx, d, nil, extraTypeMappers...)
+				})
+				continue
+			}
+			d.FieldStruct("box", func(d *decode.D) {
+				decodeBoxWithParentData(ctx, d, parentData, extraTypeMappers...)
+			})
+		}
+	})
 
 	if d.BitsLeft() > 0 {
 		// "Some sample descriptions terminate with four zero bytes that are not otherwise indicated."
@@ -325,6 +380,15 @@ type trafBox struct {
 type metaBox struct {
 	subType string
 	keys    *keysBox
+
+	// heif items
+	items          []*item
+	properties     []*itemProperty
+	hasPrimaryItem bool
+	primaryItemID  int
+	hasIdat        bool
+	idatOffset     int64
+	idatSize       int64
 }
 
 type keysBoxKey struct {
@@ -336,7 +400,7 @@ type keysBox struct {
 	keys []keysBoxKey
 }
 
-func decodeBoxIrefEntry(ctx *decodeContext, d *decode.D) {
+func decodeBoxIrefEntry(ctx *decodeContext, d *decode.D, typ string) {
 	irefBox, ok := ctx.parent().data.(*irefBox)
 	if !ok {
 		d.FieldRawLen("data", d.BitsLeft())
@@ -348,14 +412,20 @@ func decodeBoxIrefEntry(ctx *decodeContext, d *decode.D) {
 		idSize = 32
 	}
 
-	d.FieldU("from_id", idSize)
+	fromID := d.FieldU("from_id", idSize)
 	count := d.FieldU16("count")
 
+	r := itemReference{typ: typ}
 	d.FieldArray("ids", func(d *decode.D) {
 		for i := uint64(0); i < count; i++ {
-			d.FieldU("id", idSize)
+			r.toIDs = append(r.toIDs, int(d.FieldU("id", idSize)))
 		}
 	})
+
+	if mb := ctx.currentMetaBox(); mb != nil {
+		i := mb.lookupItem(int(fromID))
+		i.references = append(i.references, r)
+	}
 }
 
 func decodeBoxFtyp(d *decode.D) {
@@ -380,6 +450,18 @@ func decodeBoxFtyp(d *decode.D) {
 }
 
 func decodeBox(ctx *decodeContext, d *decode.D, typ string) {
+	// item properties are referenced by 1-based index of box in ipco
+	if ctx.isParent("ipco") {
+		if mb := ctx.currentMetaBox(); mb != nil {
+			mb.properties = append(mb.properties, &itemProperty{typ: typ})
+		}
+	}
+	// iref children are item references with reference type as box type
+	if ctx.isParent("iref") {
+		decodeBoxIrefEntry(ctx, d, typ)
+		return
+	}
+
 	switch typ {
 	case "ftyp":
 		decodeBoxFtyp(d)
@@ -693,6 +775,20 @@ func decodeBox(ctx *decodeContext, d *decode.D, typ string) {
 		if t := ctx.currentTrack(); t != nil {
 			t.formatInArg = format.HevcAuIn{LengthSize: hevcDcrOut.LengthSize} //nolint:gosimple
 		}
+		if p := ctx.currentItemProperty(); p != nil {
+			p.lengthSize = int(hevcDcrOut.LengthSize)
+		}
+	case "vvcC":
+		d.FieldU8("version")
+		d.FieldU24("flags")
+		_, v := d.FieldFormat("descriptor", vvcDCRFormat, nil)
+		vvcDcrOut, ok := v.(format.VvcDcrOut)
+		if !ok {
+			panic(fmt.Sprintf("expected VvcDcrOut got %#+v", v))
+		}
+		if t := ctx.currentTrack(); t != nil {
+			t.formatInArg = format.VvcAuIn{LengthSize: vvcDcrOut.LengthSize} //nolint:gosimple
+		}
 	case "dfLa":
 		d.FieldU8("version")
 		d.FieldU24("flags")
@@ -914,7 +1010,9 @@ func decodeBox(ctx *decodeContext, d *decode.D, typ string) {
 			// TODO: rename?
 			d.FieldU32("maybe_flags")
 		}
-		decodeBoxesWithParentData(ctx, d, &metaBox{})
+		mb := &metaBox{}
+		ctx.metaBoxes = append(ctx.metaBoxes, mb)
+		decodeBoxesWithParentData(ctx, d, mb)
 	case "ilst":
 		if mb := ctx.currentMetaBox(); mb != nil && mb.keys != nil && len(mb.keys.keys) > 0 {
 			// meta box had a keys box
@@ -962,7 +1060,8 @@ func decodeBox(ctx *decodeContext, d *decode.D, typ string) {
 		defaultSampleSizePresent := false
 		defaultSampleFlagsPresent := false
 		d.FieldStruct("flags", func(d *decode.D) {
-			d.FieldU7("unused0")
+			d.FieldU6("unused0")
+			d.FieldBool("default_base_is_moof")
 			d.FieldBool("duration_is_empty")
 			d.FieldU10("unused1")
 			defaultSampleFlagsPresent = d.FieldBool("default_sample_flags_present")
@@ -1143,45 +1242,62 @@ func decodeBox(ctx *decodeContext, d *decode.D, typ string) {
 		} else {
 			itemCount = d.FieldU32("item_count")
 		}
+		mb := ctx.currentMetaBox()
 		d.FieldArray("items", func(d *decode.D) {
 			for i := uint64(0); i < itemCount; i++ {
 				d.FieldStruct("item", func(d *decode.D) {
+					var id uint64
 					switch version {
 					case 0, 1:
-						d.FieldU16("id")
+						id = d.FieldU16("id")
 					case 2:
-						d.FieldU32("id")
+						id = d.FieldU32("id")
 					}
+					var constructionMethod uint64
 					switch version {
 					case 1, 2:
 						d.FieldU12("reserved")
-						d.FieldU4("construction_method")
+						constructionMethod = d.FieldU4("construction_method", constructionMethodNames)
 					}
-					d.FieldU16("data_reference_index")
-					d.FieldU("base_offset", int(baseOffsetSize)*8)
+					dataReferenceIndex := d.FieldU16("data_reference_index")
+					baseOffset := d.FieldU("base_offset", int(baseOffsetSize)*8)
 					extentCount := d.FieldU16("extent_count")
+					var extents []itemExtent
 					d.FieldArray("extends", func(d *decode.D) {
 						for i := uint64(0); i < extentCount; i++ {
 							d.FieldStruct("extent", func(d *decode.D) {
+								var e itemExtent
 								if (version == 1 || version == 2) && indexSize > 0 {
-									d.FieldU("index", int(offsetSize)*8)
+									e.index = int64(d.FieldU("index", int(indexSize)*8))
 								}
-								d.FieldU("offset", int(offsetSize)*8)
-								d.FieldU("length", int(lengthSize)*8)
+								e.offset = int64(d.FieldU("offset", int(offsetSize)*8))
+								e.length = int64(d.FieldU("length", int(lengthSize)*8))
+								extents = append(extents, e)
 							})
 						}
 					})
+
+					if mb != nil {
+						it := mb.lookupItem(int(id))
+						it.hasLocation = true
+						it.constructionMethod = int(constructionMethod)
+						it.dataReferenceIndex = int(dataReferenceIndex)
+						it.baseOffset = int64(baseOffset)
+						it.extents = extents
+					}
 				})
 			}
 		})
 	case "infe":
 		version := d.FieldU8("version")
 		d.FieldU24("flags")
+		var itemID uint64
+		var itemType, itemName, contentType string
 		if version == 0 || version == 1 {
-			d.FieldU16("item_id")
+			itemID = d.FieldU16("item_id")
 			d.FieldU16("item_protection_index")
-			d.FieldUTF8Null("item_name")
-			d.FieldUTF8Null("content_type")
+			itemName = d.FieldUTF8Null("item_name")
+			contentType = d.FieldUTF8Null("content_type")
 			if !d.End() {
 				d.FieldUTF8Null("content_encoding")
 			}
@@ -1197,16 +1313,16 @@ func decodeBox(ctx *decodeContext, d *decode.D, typ string) {
 		if version >= 2 {
 			switch version {
 			case 2:
-				d.FieldU16("item_id")
+				itemID = d.FieldU16("item_id")
 			case 3:
-				d.FieldU32("item_id")
+				itemID = d.FieldU32("item_id")
 			}
 			d.FieldU16("item_protection_index")
-			itemType := d.FieldUTF8("item_type", 4)
-			d.FieldUTF8Null("item_name")
+			itemType = d.FieldUTF8("item_type", 4, itemTypeNames)
+			itemName = d.FieldUTF8Null("item_name")
 			switch itemType {
 			case "mime":
-				d.FieldUTF8Null("content_type")
+				contentType = d.FieldUTF8Null("content_type")
 				if !d.End() {
 					d.FieldUTF8Null("content_encoding")
 				}
@@ -1214,6 +1330,12 @@ func decodeBox(ctx *decodeContext, d *decode.D, typ string) {
 				d.FieldUTF8Null("item_uri_type")
 			}
 		}
+		if mb := ctx.currentMetaBox(); mb != nil {
+			i := mb.lookupItem(int(itemID))
+			i.typ = itemType
+			i.name = itemName
+			i.contentType = contentType
+		}
 	case "iinf":
 		version := d.FieldU8("version")
 		d.FieldU24("flags")
@@ -1303,11 +1425,14 @@ func decodeBox(ctx *decodeContext, d *decode.D, typ string) {
 	case "schm":
 		d.FieldU8("version")
 		d.FieldU24("flags")
-		d.FieldUTF8("encryption_type", 4)
+		schemeType := d.FieldUTF8("encryption_type", 4)
 		d.FieldU16("encryption_version")
 		if d.BitsLeft() > 0 {
 			d.FieldUTF8("uri", int(d.BitsLeft())/8)
 		}
+		if t := ctx.currentTrack(); t != nil {
+			t.schemeType = schemeType
+		}
 	case "schi":
 		decodeBoxes(ctx, d)
 	case "btrt":
@@ -1367,7 +1492,10 @@ func decodeBox(ctx *decodeContext, d *decode.D, typ string) {
 	case "sgpd":
 		version := d.FieldU8("version")
 		d.FieldU24("flags")
-		d.FieldUTF8("grouping_type", 4)
+		groupingType := d.FieldUTF8("grouping_type", 4)
+		if t := ctx.currentTrack(); t != nil && groupingType == "seig" {
+			t.seigSampleGroups = true
+		}
 		var defaultLength uint64
 		if version == 1 {
 			defaultLength = d.FieldU32("default_length")
@@ -1395,7 +1523,10 @@ func decodeBox(ctx *decodeContext, d *decode.D, typ string) {
 		version := d.FieldU8("version")
 		d.FieldU24("flags")
 
-		d.FieldUTF8("grouping_type", 4)
+		groupingType := d.FieldUTF8("grouping_type", 4)
+		if t := ctx.currentTrack(); t != nil && groupingType == "seig" {
+			t.seigSampleGroups = true
+		}
 		if version == 1 {
 			d.FieldU32("grouping_type_parameter")
 		}
@@ -1444,25 +1575,27 @@ func decodeBox(ctx *decodeContext, d *decode.D, typ string) {
 		sampleCount := d.FieldU32("sample_count")
 		d.FieldArray("samples", func(d *decode.D) {
 			for i := uint64(0); i < sampleCount; i++ {
+				var se sencSample
 				d.FieldStruct("entry", func(d *decode.D) {
 					if t.defaultIVSize != 0 {
-						d.FieldRawLen("iv", int64(t.defaultIVSize*8))
+						se.iv = d.ReadAllBits(d.FieldRawLen("iv", int64(t.defaultIVSize*8)))
 					}
 					if flags&0b10 != 0 {
 						subSampleCount := d.FieldU16("subsample_count")
 						d.FieldArray("subsamples", func(d *decode.D) {
 							for i := uint64(0); i < subSampleCount; i++ {
 								d.FieldStruct("entry", func(d *decode.D) {
-									d.FieldU16("bytes_of_clean_data")
-									d.FieldU32("bytes_of_encrypted_data")
+									se.subSamples = append(se.subSamples, subSample{
+										clearBytes:     int(d.FieldU16("bytes_of_clean_data")),
+										protectedBytes: int(d.FieldU32("bytes_of_encrypted_data")),
+									})
 								})
 							}
 						})
 					}
 				})
 
-				// TODO: add iv etc
-				s.entries = append(s.entries, struct{}{})
+				s.entries = append(s.entries, se)
 			}
 		})
 		m.sencs = append(m.sencs, s)
@@ -1471,48 +1604,69 @@ func decodeBox(ctx *decodeContext, d *decode.D, typ string) {
 		d.FieldU24("flags")
 
 		d.FieldU8("reserved0")
+		var defaultCryptByteBlock uint64
+		var defaultSkipByteBlock uint64
 		switch version {
 		case 0:
 			d.FieldU8("reserved1")
 		default:
-			d.FieldU4("default_crypto_bytes")
-			d.FieldU4("default_skip_bytes")
+			defaultCryptByteBlock = d.FieldU4("default_crypto_bytes")
+			defaultSkipByteBlock = d.FieldU4("default_skip_bytes")
 		}
 
 		defaultIsEncrypted := d.FieldU8("default_is_encrypted")
 		defaultIVSize := d.FieldU8("default_iv_size")
-		d.FieldRawLen("default_kid", 8*16)
+		defaultKID := d.ReadAllBits(d.FieldRawLen("default_kid", 8*16))
 
+		var defaultConstantIV []byte
 		if defaultIsEncrypted != 0 && defaultIVSize == 0 {
 			defaultConstantIVSize := d.FieldU8("default_constant_iv_size")
-			d.FieldRawLen("default_constant_iv", int64(defaultConstantIVSize)*8)
+			defaultConstantIV = d.ReadAllBits(d.FieldRawLen("default_constant_iv", int64(defaultConstantIVSize)*8))
 		}
 		if t := ctx.currentTrack(); t != nil {
+			t.defaultIsProtected = defaultIsEncrypted != 0
 			t.defaultIVSize = int(defaultIVSize)
+			t.defaultKID = defaultKID
+			t.defaultConstantIV = defaultConstantIV
+			t.defaultCryptByteBlock = int(defaultCryptByteBlock)
+			t.defaultSkipByteBlock = int(defaultSkipByteBlock)
 		}
 	case "covr":
 		decodeBoxes(ctx, d)
-	case "dec3":
-		d.FieldU13("data_rate")
-		d.FieldU3("num_ind_sub")
+	case "dac3":
+		// ETSI TS 102 366 F.4 AC3SpecificBox
 		d.FieldU2("fscod")
 		d.FieldU5("bsid")
-		d.FieldU5("bsmod")
+		d.FieldU3("bsmod")
 		d.FieldU3("acmod")
 		d.FieldU1("lfeon")
-		d.FieldU3("reserved0")
-		numDepSub := d.FieldU4("num_dep_sub")
-		if numDepSub > 0 {
-			d.FieldU9("chan_loc")
-		} else {
-			d.FieldU1("reserved1")
-		}
+		d.FieldU5("bit_rate_code")
+		d.FieldU5("reserved")
+	case "dec3":
+		// ETSI TS 102 366 F.6 EC3SpecificBox
+		d.FieldU13("data_rate")
+		numIndSub := d.FieldU3("num_ind_sub")
+		d.FieldStructNArray("independent_substreams", "independent_substream", int64(numIndSub+1), func(d *decode.D) {
+			d.FieldU2("fscod")
+			d.FieldU5("bsid")
+			d.FieldU1("reserved0")
+			d.FieldU1("asvc")
+			d.FieldU3("bsmod")
+			d.FieldU3("acmod")
+			d.FieldU1("lfeon")
+			d.FieldU3("reserved1")
+			numDepSub := d.FieldU4("num_dep_sub")
+			if numDepSub > 0 {
+				d.FieldU9("chan_loc")
+			} else {
+				d.FieldU1("reserved2")
+			}
+		})
 
 		if d.BitsLeft() >= 16 {
-			d.FieldU7("reserved2")
-			ec3JocFlag := d.FieldBool("ec3_job_flag")
-			if ec3JocFlag {
-				d.FieldU1("ec3_job_complexity")
+			d.FieldU7("reserved")
+			if d.FieldBool("flag_ec3_extension_type_a") {
+				d.FieldU8("complexity_index_type_a")
 			}
 		}
 	case "dac4":
@@ -1595,6 +1749,10 @@ func decodeBox(ctx *decodeContext, d *decode.D, typ string) {
 	case "colr":
 		parameterType := d.FieldUTF8("parameter_type", 4)
 
+		if p := ctx.currentItemProperty(); p != nil {
+			p.colourType = parameterType
+		}
+
 		switch parameterType {
 		case "nclx", "nclc":
 			d.FieldU16("primaries_index", format.ISO_23091_2_ColourPrimariesMap)
@@ -1612,54 +1770,86 @@ func decodeBox(ctx *decodeContext, d *decode.D, typ string) {
 	case "ispe":
 		d.FieldU8("version")
 		d.FieldU24("flags")
-		d.FieldU32("image_width")
-		d.FieldU32("image_height")
+		width := d.FieldU32("image_width")
+		height := d.FieldU32("image_height")
+		if p := ctx.currentItemProperty(); p != nil {
+			p.imageWidth = int(width)
+			p.imageHeight = int(height)
+		}
+	case "pixi":
+		d.FieldU8("version")
+		d.FieldU24("flags")
+		numChannels := d.FieldU8("num_channels")
+		var bitsPerChannel []int
+		d.FieldArray("bits_per_channel", func(d *decode.D) {
+			for i := uint64(0); i < numChannels; i++ {
+				bitsPerChannel = append(bitsPerChannel, int(d.FieldU8("bits")))
+			}
+		})
+		if p := ctx.currentItemProperty(); p != nil {
+			p.bitsPerChannel = bitsPerChannel
+		}
+	case "idat":
+		if mb := ctx.currentMetaBox(); mb != nil {
+			mb.hasIdat = true
+			mb.idatOffset = d.Pos() / 8
+			mb.idatSize = d.BitsLeft() / 8
+		}
+		d.FieldRawLen("data", d.BitsLeft())
 	case "ipma":
 		version := d.FieldU8("version")
 		flags := d.FieldU24("flags")
 		entryCount := d.FieldU32("entry_count")
+		mb := ctx.currentMetaBox()
 		d.FieldArray("entries", func(d *decode.D) {
 			for i := uint64(0); i < entryCount; i++ {
 				d.FieldStruct("entry", func(d *decode.D) {
+					var itemID uint64
 					if version < 1 {
-						d.FieldU16("item_id")
+						itemID = d.FieldU16("item_id")
 					} else {
-						d.FieldU32("item_id")
+						itemID = d.FieldU32("item_id")
 					}
+					var associations []itemPropertyAssociation
 					associationCount := d.FieldU8("association_count")
 					d.FieldArray("associations", func(d *decode.D) {
 						for j := uint64(0); j < associationCount; j++ {
 							d.FieldStruct("association", func(d *decode.D) {
-								d.FieldBool("essential")
+								var a itemPropertyAssociation
+								a.essential = d.FieldBool("essential")
 								if flags&0b1 != 0 {
-									d.FieldU15("property_index")
+									a.index = int(d.FieldU15("property_index"))
 								} else {
-									d.FieldU7("item_id")
+									a.index = int(d.FieldU7("property_index"))
 								}
+								associations = append(associations, a)
 							})
 						}
 					})
+					if mb != nil {
+						it := mb.lookupItem(int(itemID))
+						it.associations = append(it.associations, associations...)
+					}
 				})
 			}
 		})
 	case "pitm":
 		version := d.FieldU8("version")
 		d.FieldU24("flags")
+		var itemID uint64
 		if version == 0 {
-			d.FieldU16("item_id")
+			itemID = d.FieldU16("item_id")
 		} else {
-			d.FieldU32("item_id")
+			itemID = d.FieldU32("item_id")
+		}
+		if mb := ctx.currentMetaBox(); mb != nil {
+			mb.hasPrimaryItem = true
+			mb.primaryItemID = int(itemID)
 		}
 	case "iref":
 		version := d.FieldU8("version")
 		d.FieldU24("flags")
 		decodeBoxesWithParentData(ctx, d, &irefBox{version: int(version)})
-	case "dimg":
-		decodeBoxIrefEntry(ctx, d)
-	case "thmb":
-		decodeBoxIrefEntry(ctx, d)
-	case "cdsc":
-		decodeBoxIrefEntry(ctx, d)
 	case "irot":
 		d.FieldU8("rotation", scalar.UintMapSymUint{
 			0: 0,
@@ -0,0 +1,138 @@
+package mp4
+
+// Common encryption sample decryption
+// ISO/IEC 23001-7
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	schemeCENC = "cenc" // AES-CTR
+	schemeCENS = "cens" // AES-CTR with pattern
+	schemeCBC1 = "cbc1" // AES-CBC
+	schemeCBCS = "cbcs" // AES-CBC with pattern and constant IV
+)
+
+type subSample struct {
+	clearBytes     int
+	protectedBytes int
+}
+
+type sencSample struct {
+	iv         []byte
+	subSamples []subSample
+}
+
+// normalized hex string without dashes, so that uuid style KIDs also work
+func normalizeKID(s string) string {
+	return strings.ToLower(strings.ReplaceAll(s, "-", ""))
+}
+
+func parseDecryptionKeys(keys map[string]string) (map[string][]byte, error) {
+	m := map[string][]byte{}
+	for kid, key := range keys {
+		kidBs, err := hex.DecodeString(normalizeKID(kid))
+		if err != nil || len(kidBs) != 16 {
+			return nil, fmt.Errorf("invalid KID %q", kid)
+		}
+		keyBs, err := hex.DecodeString(normalizeKID(key))
+		if err != nil || len(keyBs) != 16 {
+			return nil, fmt.Errorf("invalid key %q for KID %q", key, kid)
+		}
+		m[hex.EncodeToString(kidBs)] = keyBs
+	}
+	return m, nil
+}
+
+// patternBlocks calls fn for each 16 byte block in n bytes that should be decrypted
+// using crypt/skip pattern, trailing partial block is not encrypted. No pattern means
+// all blocks.
+func patternBlocks(n int, cryptBlocks int, skipBlocks int, fn func(start int)) {
+	if cryptBlocks == 0 && skipBlocks == 0 {
+		cryptBlocks = 1
+	}
+	for i := 0; i+aes.BlockSize <= n; {
+		for j := 0; j < cryptBlocks && i+aes.BlockSize <= n; j++ {
+			fn(i)
+			i += aes.BlockSize
+		}
+		i += skipBlocks * aes.BlockSize
+	}
+}
+
+// cencDecrypt decrypts sample in place. Subsamples are pairs of clear and
+// protected byte ranges, if none the whole sample is protected.
+// https://www.iso.org/standard/84637.html section 9 and 10
+func cencDecrypt(scheme string, key []byte, iv []byte, cryptBlocks int, skipBlocks int, sample []byte, subSamples []subSample) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	if len(subSamples) == 0 {
+		subSamples = []subSample{{clearBytes: 0, protectedBytes: len(sample)}}
+	}
+	n := 0
+	for _, ss := range subSamples {
+		n += ss.clearBytes + ss.protectedBytes
+	}
+	if n != len(sample) {
+		return fmt.Errorf("subsamples size %d does not match sample size %d", n, len(sample))
+	}
+
+	// 8 byte IVs are zero padded, lower 8 bytes is the block counter for CTR
+	fullIV := make([]byte, aes.BlockSize)
+	copy(fullIV, iv)
+
+	switch scheme {
+	case schemeCENC, schemeCENS:
+		// one key stream for all protected bytes in sample
+		stream := cipher.NewCTR(block, fullIV)
+		pos := 0
+		for _, ss := range subSamples {
+			pos += ss.clearBytes
+			p := sample[pos : pos+ss.protectedBytes]
+			if scheme == schemeCENC {
+				stream.XORKeyStream(p, p)
+			} else {
+				patternBlocks(len(p), cryptBlocks, skipBlocks, func(start int) {
+					b := p[start : start+aes.BlockSize]
+					stream.XORKeyStream(b, b)
+				})
+			}
+			pos += ss.protectedBytes
+		}
+	case schemeCBC1, schemeCBCS:
+		chainIV := fullIV
+		pos := 0
+		for _, ss := range subSamples {
+			pos += ss.clearBytes
+			p := sample[pos : pos+ss.protectedBytes]
+			// cbcs restarts with constant IV for each subsample, cbc1 chains all
+			// protected blocks in sample
+			if scheme == schemeCBCS {
+				chainIV = fullIV
+			}
+			pCryptBlocks, pSkipBlocks := 0, 0
+			if scheme == schemeCBCS {
+				pCryptBlocks, pSkipBlocks = cryptBlocks, skipBlocks
+			}
+			patternBlocks(len(p), pCryptBlocks, pSkipBlocks, func(start int) {
+				b := p[start : start+aes.BlockSize]
+				nextIV := append([]byte(nil), b...)
+				cipher.NewCBCDecrypter(block, chainIV).CryptBlocks(b, b)
+				chainIV = nextIV
+			})
+			pos += ss.protectedBytes
+		}
+	default:
+		return fmt.Errorf("unsupported scheme %q", scheme)
+	}
+
+	return nil
+}
@@ -0,0 +1,369 @@
+package mp4
+
+// HEIF/AVIF item resolution
+// ISO/IEC 23008-12 High efficiency image file format
+// ISO/IEC 14496-12 8.11 Metadata
+// https://aomediacodec.github.io/av1-avif/
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	constructionMethodFile = 0
+	constructionMethodIdat = 1
+	constructionMethodItem = 2
+)
+
+var constructionMethodNames = scalar.UintMapSymStr{
+	constructionMethodFile: "file",
+	constructionMethodIdat: "idat",
+	constructionMethodItem: "item",
+}
+
+var itemTypeNames = scalar.StrMapDescription{
+	"av01": "AV1 image",
+	"hvc1": "HEVC image",
+	"jpeg": "JPEG image",
+	"grid": "Image grid",
+	"iovl": "Image overlay",
+	"iden": "Identity transformation",
+	"Exif": "Exif metadata",
+	"mime": "MIME typed data",
+	"uri ": "URI typed data",
+}
+
+// item references are "from" item to "to" items, ex: a thumbnail references
+// the master image, exif references described image and grid references tiles
+var itemReferenceTypeNames = scalar.StrMapDescription{
+	"auxl": "Auxiliary image",
+	"base": "Pre-derived image base",
+	"cdsc": "Content describes",
+	"dimg": "Derived image input",
+	"dpnd": "Depends on",
+	"iloc": "Item data location",
+	"prem": "Pre-multiplied alpha",
+	"thmb": "Thumbnail",
+}
+
+const maxItemConstructionDepth = 8
+
+type itemExtent struct {
+	index  int64
+	offset int64
+	length int64
+}
+
+type itemPropertyAssociation struct {
+	index     int
+	essential bool
+}
+
+type itemReference struct {
+	typ   string
+	toIDs []int
+}
+
+type itemProperty struct {
+	typ            string
+	imageWidth     int
+	imageHeight    int
+	bitsPerChannel []int
+	colourType     string
+	lengthSize     int // hvcC nal length size
+}
+
+type item struct {
+	id                 int
+	typ                string
+	name               string
+	contentType        string
+	hasLocation        bool
+	constructionMethod int
+	dataReferenceIndex int
+	baseOffset         int64
+	extents            []itemExtent
+	associations       []itemPropertyAssociation
+	references         []itemReference
+}
+
+func (mb *metaBox) lookupItem(id int) *item {
+	for _, i := range mb.items {
+		if i.id == id {
+			return i
+		}
+	}
+	i := &item{id: id}
+	mb.items = append(mb.items, i)
+	return i
+}
+
+// currentItemProperty returns property for box being decoded if it's a child of ipco
+func (ctx *decodeContext) currentItemProperty() *itemProperty {
+	if !ctx.isParent("ipco") {
+		return nil
+	}
+	mb := ctx.currentMetaBox()
+	if mb == nil || len(mb.properties) == 0 {
+		return nil
+	}
+	return mb.properties[len(mb.properties)-1]
+}
+
+// property index is 1-based, 0 means no property
+func (mb *metaBox) lookupProperty(index int) *itemProperty {
+	if index < 1 || index > len(mb.properties) {
+		return nil
+	}
+	return mb.properties[index-1]
+}
+
+func (mb *metaBox) itemProperty(i *item, typ string) *itemProperty {
+	for _, a := range i.associations {
+		if p := mb.lookupProperty(a.index); p != nil && p.typ == typ {
+			return p
+		}
+	}
+	return nil
+}
+
+// itemData assembles item extents into one buffer, on error returns data assembled so far
+func (mb *metaBox) itemData(d *decode.D, i *item, depth int) ([]byte, error) {
+	if depth > maxItemConstructionDepth {
+		return nil, fmt.Errorf("item %d: construction depth above %d", i.id, maxItemConstructionDepth)
+	}
+	if i.dataReferenceIndex != 0 {
+		return nil, fmt.Errorf("item %d: data in other file not supported", i.id)
+	}
+
+	var bs []byte
+	for _, e := range i.extents {
+		var src []byte
+		var srcOffset int64
+		switch i.constructionMethod {
+		case constructionMethodFile:
+			srcOffset = 0
+		case constructionMethodIdat:
+			if !mb.hasIdat {
+				return bs, fmt.Errorf("item %d: idat construction without idat box", i.id)
+			}
+			srcOffset = mb.idatOffset
+		case constructionMethodItem:
+			// extent index is 1-based index into iloc references, 1 if no index
+			index := e.index
+			if index == 0 {
+				index = 1
+			}
+			var ids []int
+			for _, r := range i.references {
+				if r.typ == "iloc" {
+					ids = append(ids, r.toIDs...)
+				}
+			}
+			if index > int64(len(ids)) {
+				return bs, fmt.Errorf("item %d: extent index %d outside iloc references", i.id, index)
+			}
+			var err error
+			src, err = mb.itemData(d, mb.lookupItem(ids[index-1]), depth+1)
+			if err != nil {
+				return bs, err
+			}
+		default:
+			return bs, fmt.Errorf("item %d: unknown construction method %d", i.id, i.constructionMethod)
+		}
+
+		offset := i.baseOffset + e.offset
+		length := e.length
+
+		if src != nil {
+			// length zero means rest of source
+			if length == 0 {
+				length = int64(len(src)) - offset
+			}
+			if offset < 0 || length < 0 || offset+length > int64(len(src)) {
+				return bs, fmt.Errorf("item %d: extent %d-%d outside referenced item", i.id, offset, offset+length)
+			}
+			bs = append(bs, src[offset:offset+length]...)
+			continue
+		}
+
+		srcLen := d.Len() / 8
+		if i.constructionMethod == constructionMethodIdat {
+			srcLen = mb.idatSize
+		}
+		if length == 0 {
+			length = srcLen - offset
+		}
+		if offset < 0 || length < 0 || offset+length > srcLen {
+			return bs, fmt.Errorf("item %d: extent %d-%d outside %s", i.id, offset, offset+length, constructionMethodNames[uint64(i.constructionMethod)])
+		}
+		eb, err := d.TryBytesRange((srcOffset+offset)*8, int(length))
+		if err != nil {
+			return bs, err
+		}
+		bs = append(bs, eb...)
+	}
+
+	return bs, nil
+}
+
+// output size fields are 32 bit if flags bit 0 is set
+func itemGridFieldSize(flags byte) int {
+	if flags&0b1 != 0 {
+		return 32
+	}
+	return 16
+}
+
+// ISO/IEC 23008-12 6.6.2.3 Grid derivation
+func decodeItemGrid(d *decode.D) {
+	d.FieldU8("version")
+	flags := d.FieldU8("flags")
+	d.FieldU8("rows_minus_one")
+	d.FieldU8("columns_minus_one")
+	fieldSize := itemGridFieldSize(byte(flags))
+	d.FieldU("output_width", fieldSize)
+	d.FieldU("output_height", fieldSize)
+}
+
+func decodeItemData(d *decode.D, mb *metaBox, i *item, bs []byte) {
+	br := bitio.NewBitReader(bs, -1)
+
+	tryFormat := func(g decode.Group, inArg any) *decode.Value {
+		dv, _, _ := d.TryFieldFormatBitBuf("data", br, g, inArg)
+		return dv
+	}
+
+	var dv *decode.Value
+	switch i.typ {
+	case "Exif":
+		// exif_tiff_header_offset is offset to tiff header after the field, usually
+		// skipping a "Exif\0\0" jpeg APP1 like prefix
+		if len(bs) < 4 || int64(binary.BigEndian.Uint32(bs))+4 > int64(len(bs)) {
+			break
+		}
+		dv = d.FieldStructRootBitBufFn("data", br, func(d *decode.D) {
+			tiffHeaderOffset := d.FieldU32("tiff_header_offset")
+			if tiffHeaderOffset > 0 {
+				d.FieldRawLen("prefix", int64(tiffHeaderOffset)*8)
+			}
+			d.FieldFormatOrRaw("exif", exifFormat, nil)
+		})
+	case "av01":
+		// item data is a sequence of OBUs
+		dv = tryFormat(av1FrameFormat, nil)
+	case "hvc1":
+		lengthSize := 4
+		if p := mb.itemProperty(i, "hvcC"); p != nil && p.lengthSize != 0 {
+			lengthSize = p.lengthSize
+		}
+		dv = tryFormat(hevcAUFormat, format.HevcAuIn{LengthSize: uint64(lengthSize)})
+	case "jpeg":
+		dv = tryFormat(jpegFormat, nil)
+	case "mime":
+		dv = tryFormat(probeFormat, nil)
+	case "grid":
+		if len(bs) < 2 || len(bs) < 4+2*itemGridFieldSize(bs[1])/8 {
+			break
+		}
+		dv = d.FieldStructRootBitBufFn("data", br, func(d *decode.D) {
+			decodeItemGrid(d)
+			if d.BitsLeft() > 0 {
+				d.FieldRawLen("trailing", d.BitsLeft())
+			}
+		})
+	}
+	if dv == nil {
+		d.FieldRootBitBuf("data", br)
+	}
+}
+
+func decodeItemProperty(d *decode.D, p *itemProperty) {
+	d.FieldValueStr("type", p.typ, boxDescriptions)
+	switch p.typ {
+	case "ispe":
+		d.FieldValueUint("image_width", uint64(p.imageWidth))
+		d.FieldValueUint("image_height", uint64(p.imageHeight))
+	case "pixi":
+		d.FieldArray("bits_per_channel", func(d *decode.D) {
+			for _, b := range p.bitsPerChannel {
+				d.FieldValueUint("bits", uint64(b))
+			}
+		})
+	case "colr":
+		d.FieldValueStr("parameter_type", p.colourType)
+	case "hvcC":
+		d.FieldValueUint("length_size", uint64(p.lengthSize))
+	}
+}
+
+func mp4Items(d *decode.D, ctx *decodeContext) {
+	d.FieldArray("items", func(d *decode.D) {
+		for _, mb := range ctx.metaBoxes {
+			// keep item order stable
+			items := append([]*item{}, mb.items...)
+			slices.SortFunc(items, func(a, b *item) bool { return a.id < b.id })
+
+			for _, i := range items {
+				d.FieldStruct("item", func(d *decode.D) {
+					d.FieldValueUint("id", uint64(i.id))
+					if i.typ != "" {
+						d.FieldValueStr("type", i.typ, itemTypeNames)
+					}
+					if i.name != "" {
+						d.FieldValueStr("name", i.name)
+					}
+					if i.contentType != "" {
+						d.FieldValueStr("content_type", i.contentType)
+					}
+					d.FieldValueBool("primary", mb.hasPrimaryItem && i.id == mb.primaryItemID)
+
+					d.FieldArray("properties", func(d *decode.D) {
+						for _, a := range i.associations {
+							d.FieldStruct("property", func(d *decode.D) {
+								d.FieldValueUint("index", uint64(a.index))
+								d.FieldValueBool("essential", a.essential)
+								if p := mb.lookupProperty(a.index); p != nil {
+									decodeItemProperty(d, p)
+								}
+							})
+						}
+					})
+
+					d.FieldArray("references", func(d *decode.D) {
+						for _, r := range i.references {
+							d.FieldStruct("reference", func(d *decode.D) {
+								d.FieldValueStr("type", r.typ, itemReferenceTypeNames)
+								d.FieldArray("ids", func(d *decode.D) {
+									for _, id := range r.toIDs {
+										d.FieldValueUint("id", uint64(id))
+									}
+								})
+							})
+						}
+					})
+
+					if !i.hasLocation {
+						return
+					}
+					d.FieldValueUint("construction_method", uint64(i.constructionMethod), constructionMethodNames)
+					bs, err := mb.itemData(d, i, 0)
+					if err != nil {
+						// data assembled so far as raw with error
+						dv := d.FieldRootBitBuf("data", bitio.NewBitReader(bs, -1))
+						dv.Err = decode.FormatError{Err: err, Format: *d.Value.FormatRoot().Format}
+						return
+					}
+					decodeItemData(d, mb, i, bs)
+				})
+			}
+		}
+	})
+}
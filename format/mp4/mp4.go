@@ -17,9 +17,12 @@ package mp4
 
 import (
 	"embed"
+	"encoding/hex"
+	"errors"
 	"fmt"
 
 	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
 	"golang.org/x/exp/slices"
@@ -30,10 +33,13 @@ import (
 var mp4FS embed.FS
 
 var aacFrameFormat decode.Group
+var ac3FrameFormat decode.Group
 var av1CCRFormat decode.Group
 var av1FrameFormat decode.Group
 var avcAUFormat decode.Group
 var avcDCRFormat decode.Group
+var eac3FrameFormat decode.Group
+var exifFormat decode.Group
 var flacFrameFormat decode.Group
 var flacMetadatablocksFormat decode.Group
 var hevcAUFormat decode.Group
@@ -47,12 +53,15 @@ var mpegESFormat decode.Group
 var mpegPESPacketSampleFormat decode.Group
 var opusPacketFrameFormat decode.Group
 var pngFormat decode.Group
+var probeFormat decode.Group
 var proResFrameFormat decode.Group
 var protoBufWidevineFormat decode.Group
 var psshPlayreadyFormat decode.Group
 var vorbisPacketFormat decode.Group
 var vp9FrameFormat decode.Group
 var vpxCCRFormat decode.Group
+var vvcAUFormat decode.Group
+var vvcDCRFormat decode.Group
 
 func init() {
 	interp.RegisterFormat(decode.Format{
@@ -66,13 +75,17 @@ func init() {
 		DefaultInArg: format.Mp4In{
 			DecodeSamples:  true,
 			AllowTruncated: false,
+			DecryptionKeys: map[string]string{},
 		},
 		Dependencies: []decode.Dependency{
 			{Names: []string{format.AAC_FRAME}, Group: &aacFrameFormat},
+			{Names: []string{format.AC3_FRAME}, Group: &ac3FrameFormat},
 			{Names: []string{format.AV1_CCR}, Group: &av1CCRFormat},
 			{Names: []string{format.AV1_FRAME}, Group: &av1FrameFormat},
 			{Names: []string{format.AVC_AU}, Group: &avcAUFormat},
 			{Names: []string{format.AVC_DCR}, Group: &avcDCRFormat},
+			{Names: []string{format.EAC3_FRAME}, Group: &eac3FrameFormat},
+			{Names: []string{format.EXIF}, Group: &exifFormat},
 			{Names: []string{format.FLAC_FRAME}, Group: &flacFrameFormat},
 			{Names: []string{format.FLAC_METADATABLOCKS}, Group: &flacMetadatablocksFormat},
 			{Names: []string{format.HEVC_AU}, Group: &hevcAUFormat},
@@ -86,12 +99,15 @@ func init() {
 			{Names: []string{format.MPEG_PES_PACKET}, Group: &mpegPESPacketSampleFormat},
 			{Names: []string{format.OPUS_PACKET}, Group: &opusPacketFrameFormat},
 			{Names: []string{format.PNG}, Group: &pngFormat},
+			{Names: []string{format.PROBE}, Group: &probeFormat},
 			{Names: []string{format.PRORES_FRAME}, Group: &proResFrameFormat},
 			{Names: []string{format.PROTOBUF_WIDEVINE}, Group: &protoBufWidevineFormat},
 			{Names: []string{format.PSSH_PLAYREADY}, Group: &psshPlayreadyFormat},
 			{Names: []string{format.VORBIS_PACKET}, Group: &vorbisPacketFormat},
 			{Names: []string{format.VP9_FRAME}, Group: &vp9FrameFormat},
 			{Names: []string{format.VPX_CCR}, Group: &vpxCCRFormat},
+			{Names: []string{format.VVC_AU}, Group: &vvcAUFormat},
+			{Names: []string{format.VVC_DCR}, Group: &vvcDCRFormat},
 		},
 	})
 	interp.RegisterFS(mp4FS)
@@ -110,9 +126,8 @@ type moof struct {
 	sencs                         []senc
 }
 
-// TODO: nothing for now
 type senc struct {
-	entries []struct{}
+	entries []sencSample
 }
 
 type trun struct {
@@ -139,9 +154,19 @@ type track struct {
 	stsc               []stsc
 	stsz               []stsz
 	formatInArg        any
-	objectType         int // if data format is "mp4a"
-	defaultIVSize      int
+	objectType         int     // if data format is "mp4a"
 	moofs              []*moof // for fmp4
+
+	// common encryption from schm and tenc
+	schemeType            string
+	defaultIsProtected    bool
+	defaultIVSize         int
+	defaultKID            []byte
+	defaultConstantIV     []byte
+	defaultCryptByteBlock int
+	defaultSkipByteBlock  int
+	// seig sample groups can override KID and IV per sample, not supported
+	seigSampleGroups bool
 }
 
 type pathEntry struct {
@@ -150,9 +175,11 @@ type pathEntry struct {
 }
 
 type decodeContext struct {
-	opts   format.Mp4In
-	path   []pathEntry
-	tracks map[int]*track
+	opts           format.Mp4In
+	decryptionKeys map[string][]byte // hex KID to key
+	path           []pathEntry
+	tracks         map[int]*track
+	metaBoxes      []*metaBox
 }
 
 func (ctx *decodeContext) lookupTrack(id int) *track {
@@ -212,6 +239,53 @@ func (ctx *decodeContext) currentTrack() *track {
 	return nil
 }
 
+func sampleFormatGroup(t *track, dataFormat string) decode.Group {
+	switch {
+	case dataFormat == "fLaC":
+		return flacFrameFormat
+	case dataFormat == "Opus":
+		return opusPacketFrameFormat
+	case dataFormat == "vp09":
+		return vp9FrameFormat
+	case dataFormat == "avc1":
+		return avcAUFormat
+	case dataFormat == "hev1",
+		dataFormat == "hvc1":
+		return hevcAUFormat
+	case dataFormat == "vvc1",
+		dataFormat == "vvi1":
+		return vvcAUFormat
+	case dataFormat == "av01":
+		return av1FrameFormat
+	case dataFormat == "ac-3":
+		return ac3FrameFormat
+	case dataFormat == "ec-3":
+		return eac3FrameFormat
+	case dataFormat == "mp4a" && t.objectType == format.MPEGObjectTypeMP3:
+		return mp3FrameFormat
+	case dataFormat == "mp4a" && t.objectType == format.MPEGObjectTypeAAC:
+		return aacFrameFormat
+	case dataFormat == "mp4a" && t.objectType == format.MPEGObjectTypeVORBIS:
+		return vorbisPacketFormat
+	case dataFormat == "mp4v" && t.objectType == format.MPEGObjectTypeMPEG2VideoMain:
+		return mpegPESPacketSampleFormat
+	case dataFormat == "mp4v" && t.objectType == format.MPEGObjectTypeMJPEG:
+		return jpegFormat
+	case dataFormat == "mp4v" && t.objectType == format.MPEGObjectTypePNG:
+		return pngFormat
+	case dataFormat == "jpeg":
+		return jpegFormat
+	case dataFormat == "apch",
+		dataFormat == "apcn",
+		dataFormat == "scpa",
+		dataFormat == "apco",
+		dataFormat == "ap4h":
+		return proResFrameFormat
+	default:
+		return nil
+	}
+}
+
 func mp4Tracks(d *decode.D, ctx *decodeContext) {
 	// keep track order stable
 	var sortedTracks []*track
@@ -224,48 +298,50 @@ func mp4Tracks(d *decode.D, ctx *decodeContext) {
 		for _, t := range sortedTracks {
 			decodeSampleRange := func(d *decode.D, t *track, decodeSample bool, dataFormat string, name string, firstBit int64, nBits int64, inArg any) {
 				d.RangeFn(firstBit, nBits, func(d *decode.D) {
-					if !decodeSample {
+					if g := sampleFormatGroup(t, dataFormat); decodeSample && g != nil {
+						d.FieldFormatLen(name, nBits, g, inArg)
+					} else {
 						d.FieldRawLen(name, d.BitsLeft())
-						return
 					}
+				})
+			}
 
-					switch {
-					case dataFormat == "fLaC":
-						d.FieldFormatLen(name, nBits, flacFrameFormat, inArg)
-					case dataFormat == "Opus":
-						d.FieldFormatLen(name, nBits, opusPacketFrameFormat, inArg)
-					case dataFormat == "vp09":
-						d.FieldFormatLen(name, nBits, vp9FrameFormat, inArg)
-					case dataFormat == "avc1":
-						d.FieldFormatLen(name, nBits, avcAUFormat, inArg)
-					case dataFormat == "hev1",
-						dataFormat == "hvc1":
-						d.FieldFormatLen(name, nBits, hevcAUFormat, inArg)
-					case dataFormat == "av01":
-						d.FieldFormatLen(name, nBits, av1FrameFormat, inArg)
-					case dataFormat == "mp4a" && t.objectType == format.MPEGObjectTypeMP3:
-						d.FieldFormatLen(name, nBits, mp3FrameFormat, inArg)
-					case dataFormat == "mp4a" && t.objectType == format.MPEGObjectTypeAAC:
-						d.FieldFormatLen(name, nBits, aacFrameFormat, inArg)
-					case dataFormat == "mp4a" && t.objectType == format.MPEGObjectTypeVORBIS:
-						d.FieldFormatLen(name, nBits, vorbisPacketFormat, inArg)
-					case dataFormat == "mp4v" && t.objectType == format.MPEGObjectTypeMPEG2VideoMain:
-						d.FieldFormatLen(name, nBits, mpegPESPacketSampleFormat, inArg)
-					case dataFormat == "mp4v" && t.objectType == format.MPEGObjectTypeMJPEG:
-						d.FieldFormatLen(name, nBits, jpegFormat, inArg)
-					case dataFormat == "mp4v" && t.objectType == format.MPEGObjectTypePNG:
-						d.FieldFormatLen(name, nBits, pngFormat, inArg)
-					case dataFormat == "jpeg":
-						d.FieldFormatLen(name, nBits, jpegFormat, inArg)
-					case dataFormat == "apch",
-						dataFormat == "apcn",
-						dataFormat == "scpa",
-						dataFormat == "apco",
-						dataFormat == "ap4h":
-						d.FieldFormatLen(name, nBits, proResFrameFormat, inArg)
-					default:
-						d.FieldRawLen(name, d.BitsLeft())
+			// decrypt sample if there is a key for the track KID, decrypted sample is
+			// added as a root buffer and decoded using original data format if possible
+			decodeEncryptedSampleRange := func(d *decode.D, t *track, se sencSample, decodeSample bool, dataFormat string, name string, firstBit int64, nBits int64, inArg any) {
+				key, ok := ctx.decryptionKeys[hex.EncodeToString(t.defaultKID)]
+				if !ok || !t.defaultIsProtected {
+					decodeSampleRange(d, t, false, dataFormat, name, firstBit, nBits, inArg)
+					return
+				}
+				iv := se.iv
+				if t.defaultIVSize == 0 {
+					iv = t.defaultConstantIV
+				}
+				bs := d.BytesRange(firstBit, int(nBits/8))
+				var err error
+				if t.seigSampleGroups {
+					err = errors.New("seig sample group KIDs and IVs are not supported")
+				} else {
+					err = cencDecrypt(t.schemeType, key, iv, t.defaultCryptByteBlock, t.defaultSkipByteBlock, bs, se.subSamples)
+				}
+				if err != nil {
+					// keep encrypted sample with error to tell it apart from a sample that is not encrypted
+					d.RangeFn(firstBit, nBits, func(d *decode.D) {
+						dv := d.FieldRootBitBuf(name, d.RawLen(d.BitsLeft()))
+						dv.Err = decode.FormatError{Err: fmt.Errorf("decrypt: %w", err), Format: *d.Value.FormatRoot().Format}
+					})
+					return
+				}
+
+				d.RangeFn(firstBit, nBits, func(d *decode.D) {
+					br := bitio.NewBitReader(bs, -1)
+					if g := sampleFormatGroup(t, dataFormat); decodeSample && g != nil {
+						if dv, _, _ := d.TryFieldFormatBitBuf(name, br, g, inArg); dv != nil {
+							return
+						}
 					}
+					d.FieldRootBitBuf(name, br)
 				})
 			}
 
@@ -398,14 +474,12 @@ func mp4Tracks(d *decode.D, ctx *decodeContext) {
 								// }
 								// log.Println(logStrFn())
 
-								decodeSample := ctx.opts.DecodeSamples
 								if trunSampleNr < len(senc.entries) {
-									// TODO: encrypted
-									decodeSample = false
+									decodeEncryptedSampleRange(d, t, senc.entries[trunSampleNr], ctx.opts.DecodeSamples, dataFormat, "sample", sampleOffset*8, sz*8, t.formatInArg)
+								} else {
+									decodeSampleRange(d, t, ctx.opts.DecodeSamples, dataFormat, "sample", sampleOffset*8, sz*8, t.formatInArg)
 								}
 
-								decodeSampleRange(d, t, decodeSample, dataFormat, "sample", sampleOffset*8, sz*8, t.formatInArg)
-
 								sampleOffset += sz
 								sampleNr++
 							}
@@ -421,10 +495,16 @@ func mp4Decode(d *decode.D) any {
 	var mi format.Mp4In
 	d.ArgAs(&mi)
 
+	decryptionKeys, err := parseDecryptionKeys(mi.DecryptionKeys)
+	if err != nil {
+		d.Fatalf("decryption_keys: %s", err)
+	}
+
 	ctx := &decodeContext{
-		opts:   mi,
-		path:   []pathEntry{{typ: "root"}},
-		tracks: map[int]*track{},
+		opts:           mi,
+		decryptionKeys: decryptionKeys,
+		path:           []pathEntry{{typ: "root"}},
+		tracks:         map[int]*track{},
 	}
 
 	// TODO: nicer, validate functions without field?
@@ -451,6 +531,9 @@ func mp4Decode(d *decode.D) any {
 	if len(ctx.tracks) > 0 {
 		mp4Tracks(d, ctx)
 	}
+	if slices.ContainsFunc(ctx.metaBoxes, func(mb *metaBox) bool { return len(mb.items) > 0 }) {
+		mp4Items(d, ctx)
+	}
 
 	return nil
 }
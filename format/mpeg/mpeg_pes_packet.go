@@ -15,14 +15,21 @@ func init() {
 		Name:        format.MPEG_PES_PACKET,
 		Description: "MPEG Packetized elementary stream packet",
 		DecodeFn:    pesPacketDecode,
+		DefaultInArg: format.MpegPesPacketIn{
+			DvdSubstreams: true,
+		},
 	})
 }
 
 const (
-	sequenceHeader = 0xb3
-	packHeader     = 0xba
-	systemHeader   = 0xbb
-	privateStream1 = 0xbd
+	sequenceHeader   = 0xb3
+	programEnd       = 0xb9
+	packHeader       = 0xba
+	systemHeader     = 0xbb
+	programStreamMap = 0xbc
+	privateStream1   = 0xbd
+	paddingStream    = 0xbe
+	privateStream2   = 0xbf
 )
 
 type subStreamPacket struct {
@@ -30,6 +37,71 @@ type subStreamPacket struct {
 	buf    []byte
 }
 
+// payload of a pes packet that is not a private stream 1 packet
+type streamPacket struct {
+	streamID int
+	buf      []byte
+}
+
+// stream id to elementary stream type
+type programStreamMapPacket struct {
+	streamTypes map[int]int
+}
+
+// ISO/IEC 13818-1 Table 2-34 Stream type assignments
+const (
+	streamTypeMPEG1Video = 0x01
+	streamTypeMPEG2Video = 0x02
+	streamTypeMPEG1Audio = 0x03
+	streamTypeMPEG2Audio = 0x04
+	streamTypePrivatePES = 0x06
+	streamTypeADTS       = 0x0f
+	streamTypeMPEG4Video = 0x10
+	streamTypeLATM       = 0x11
+	streamTypeAVC        = 0x1b
+	streamTypeHEVC       = 0x24
+	streamTypeAC3        = 0x81
+	streamTypeEAC3       = 0x87
+)
+
+var elementaryStreamTypeNames = scalar.UintMap{
+	0x00:                 {Sym: "reserved"},
+	streamTypeMPEG1Video: {Sym: "mpeg1_video", Description: "ISO/IEC 11172-2 Video"},
+	streamTypeMPEG2Video: {Sym: "mpeg2_video", Description: "ISO/IEC 13818-2 Video"},
+	streamTypeMPEG1Audio: {Sym: "mpeg1_audio", Description: "ISO/IEC 11172-3 Audio"},
+	streamTypeMPEG2Audio: {Sym: "mpeg2_audio", Description: "ISO/IEC 13818-3 Audio"},
+	0x05:                 {Sym: "private_sections", Description: "ISO/IEC 13818-1 private sections"},
+	streamTypePrivatePES: {Sym: "private_pes", Description: "ISO/IEC 13818-1 PES packets with private data"},
+	0x07:                 {Sym: "mheg", Description: "ISO/IEC 13522 MHEG"},
+	0x08:                 {Sym: "dsm_cc", Description: "ISO/IEC 13818-1 Annex A DSM-CC"},
+	streamTypeADTS:       {Sym: "adts", Description: "ISO/IEC 13818-7 Audio with ADTS transport syntax"},
+	streamTypeMPEG4Video: {Sym: "mpeg4_video", Description: "ISO/IEC 14496-2 Visual"},
+	streamTypeLATM:       {Sym: "latm", Description: "ISO/IEC 14496-3 Audio with LATM transport syntax"},
+	streamTypeAVC:        {Sym: "avc", Description: "ITU-T H.264 | ISO/IEC 14496-10 Video"},
+	streamTypeHEVC:       {Sym: "hevc", Description: "ITU-T H.265 | ISO/IEC 23008-2 Video"},
+	streamTypeAC3:        {Sym: "ac3", Description: "ATSC A/52 AC-3 Audio"},
+	streamTypeEAC3:       {Sym: "eac3", Description: "ATSC A/52 E-AC-3 Audio"},
+}
+
+// DVD private stream 1 sub streams
+var subStreamNames = scalar.UintRangeToScalar{
+	{Range: [2]uint64{0x20, 0x3f}, S: scalar.Uint{Sym: "subpicture"}},
+	{Range: [2]uint64{0x80, 0x87}, S: scalar.Uint{Sym: "ac3"}},
+	{Range: [2]uint64{0x88, 0x8f}, S: scalar.Uint{Sym: "dts"}},
+	{Range: [2]uint64{0xa0, 0xa7}, S: scalar.Uint{Sym: "lpcm"}},
+}
+
+var lpcmQuantizationNames = scalar.UintMapSymUint{
+	0: 16,
+	1: 20,
+	2: 24,
+}
+
+var lpcmSampleRateNames = scalar.UintMapSymUint{
+	0: 48000,
+	1: 96000,
+}
+
 var startAndStreamNames = scalar.UintRangeToScalar{
 	{Range: [2]uint64{0x00, 0x00}, S: scalar.Uint{Sym: "picture"}},
 	{Range: [2]uint64{0x01, 0xaf}, S: scalar.Uint{Sym: "slice"}},
@@ -69,7 +141,153 @@ var mpegVersion = scalar.UintMapDescription{
 	0b10: "MPEG1",
 }
 
+// 33 bit timestamp split into 3, 15 and 15 bits with marker bits in between
+func fieldTimestamp(d *decode.D, name string, prefixBits int) uint64 {
+	return d.FieldUintFn(name, func(d *decode.D) uint64 {
+		d.U(prefixBits)
+		ts0 := d.U3()
+		d.U1()
+		ts1 := d.U15()
+		d.U1()
+		ts2 := d.U15()
+		d.U1()
+		return ts0<<30 | ts1<<15 | ts2
+	})
+}
+
+func decodeMPEG2PESHeader(d *decode.D) {
+	var ptsDTSFlags uint64
+	var escrFlag bool
+	var esRateFlag bool
+	var dsmTrickModeFlag bool
+	var additionalCopyInfoFlag bool
+	var pesCRCFlag bool
+	var pesExtFlag bool
+	var headerDataLength uint64
+	d.FieldStruct("extension", func(d *decode.D) {
+		d.FieldU2("skip0")
+		d.FieldU2("scramble_control")
+		d.FieldU1("priority")
+		d.FieldU1("data_alignment_indicator")
+		d.FieldU1("copyright")
+		d.FieldU1("original")
+		ptsDTSFlags = d.FieldU2("pts_dts_flags")
+		escrFlag = d.FieldBool("escr_flag")
+		esRateFlag = d.FieldBool("es_rate_flag")
+		dsmTrickModeFlag = d.FieldBool("dsm_trick_mode_flag")
+		additionalCopyInfoFlag = d.FieldBool("additional_copy_info_flag")
+		pesCRCFlag = d.FieldBool("pes_crc_flag")
+		pesExtFlag = d.FieldBool("pes_ext_flag")
+		headerDataLength = d.FieldU8("header_data_length")
+	})
+	d.FieldStruct("header_data", func(d *decode.D) {
+		d.FramedFn(int64(headerDataLength)*8, func(d *decode.D) {
+			switch ptsDTSFlags {
+			case 0b10:
+				fieldTimestamp(d, "pts", 4)
+			case 0b11:
+				fieldTimestamp(d, "pts", 4)
+				fieldTimestamp(d, "dts", 4)
+			}
+			if escrFlag {
+				d.FieldUintFn("escr", func(d *decode.D) uint64 {
+					d.U2()
+					escr0 := d.U3()
+					d.U1()
+					escr1 := d.U15()
+					d.U1()
+					escr2 := d.U15()
+					d.U1()
+					return escr0<<30 | escr1<<15 | escr2
+				})
+				d.FieldU9("escr_ext")
+				d.FieldU1("marker")
+			}
+			if esRateFlag {
+				d.FieldU1("marker0")
+				d.FieldU22("es_rate")
+				d.FieldU1("marker1")
+			}
+			if dsmTrickModeFlag {
+				d.FieldU8("dsm_trick_mode")
+			}
+			if additionalCopyInfoFlag {
+				d.FieldU1("marker2")
+				d.FieldU7("additional_copy_info")
+			}
+			if pesCRCFlag {
+				d.FieldU16("previous_pes_packet_crc", scalar.UintHex)
+			}
+			if d.BitsLeft() > 0 {
+				// TODO: pes extension
+				if pesExtFlag {
+					d.FieldRawLen("pes_extension", d.BitsLeft())
+				} else {
+					d.FieldRawLen("stuffing", d.BitsLeft())
+				}
+			}
+		})
+	})
+}
+
+// ISO/IEC 11172-1 2.4.3.6 Packet layer
+func decodeMPEG1PESHeader(d *decode.D) {
+	d.FieldStruct("header_data", func(d *decode.D) {
+		stuffingBytes := 0
+		for d.BitsLeft() >= 8 && d.PeekUintBits(8) == 0xff {
+			stuffingBytes++
+			d.SeekRel(8)
+		}
+		d.SeekRel(int64(-stuffingBytes) * 8)
+		if stuffingBytes > 0 {
+			d.FieldRawLen("stuffing", int64(stuffingBytes)*8)
+		}
+		if d.PeekUintBits(2) == 0b01 {
+			d.FieldU2("std_marker")
+			d.FieldU1("std_buffer_scale")
+			d.FieldU13("std_buffer_size")
+		}
+		switch d.PeekUintBits(4) {
+		case 0b0010:
+			fieldTimestamp(d, "pts", 4)
+		case 0b0011:
+			fieldTimestamp(d, "pts", 4)
+			fieldTimestamp(d, "dts", 4)
+		default:
+			d.FieldU8("no_timestamps", d.UintValidate(0x0f), scalar.UintHex)
+		}
+	})
+}
+
+func decodeProgramStreamMap(d *decode.D) programStreamMapPacket {
+	psm := programStreamMapPacket{streamTypes: map[int]int{}}
+
+	d.FieldBool("current_next_indicator")
+	d.FieldU2("reserved0")
+	d.FieldU5("program_stream_map_version")
+	d.FieldU7("reserved1")
+	d.FieldU1("marker_bit")
+	programStreamInfoLength := d.FieldU16("program_stream_info_length")
+	d.FieldRawLen("descriptors", int64(programStreamInfoLength)*8)
+	elementaryStreamMapLength := d.FieldU16("elementary_stream_map_length")
+	d.FramedFn(int64(elementaryStreamMapLength)*8, func(d *decode.D) {
+		d.FieldStructArrayLoop("elementary_streams", "elementary_stream", d.NotEnd, func(d *decode.D) {
+			streamType := d.FieldU8("stream_type", elementaryStreamTypeNames, scalar.UintHex)
+			streamID := d.FieldU8("elementary_stream_id", startAndStreamNames, scalar.UintHex)
+			elementaryStreamInfoLength := d.FieldU16("elementary_stream_info_length")
+			d.FieldRawLen("descriptors", int64(elementaryStreamInfoLength)*8)
+			psm.streamTypes[int(streamID)] = int(streamType)
+		})
+	})
+	d.FieldU32("crc", scalar.UintHex)
+
+	return psm
+}
+
 func pesPacketDecode(d *decode.D) any {
+	var pi format.MpegPesPacketIn
+	d.ArgAs(&pi)
+
 	var v any
 
 	d.FieldU24("prefix", d.UintAssert(0b0000_0000_0000_0000_0000_0001), scalar.UintBin)
@@ -126,77 +344,95 @@ func pesPacketDecode(d *decode.D) any {
 			}
 		}
 	case startCode == systemHeader:
-		d.FieldU16("length")
-		d.FieldU1("skip0")
-		d.FieldU22("rate_bound")
-		d.FieldU1("skip1")
-		d.FieldU6("audio_bound")
-		d.FieldU1("fixed_flag")
-		d.FieldU1("csps_flag")
-		d.FieldU1("system_audio_lock_flag")
-		d.FieldU1("system_video_lock_flag")
-		d.FieldU1("skip2")
-		d.FieldU5("video_bound")
-		d.FieldU1("packet_rate_restriction_flag")
-		d.FieldU7("reserved")
-		d.FieldArray("stream_bound_entries", func(d *decode.D) {
-			for d.PeekUintBits(1) == 1 {
-				d.FieldStruct("stream_bound_entry", func(d *decode.D) {
-					d.FieldU8("stream_id")
-					d.FieldU2("skip0")
-					d.FieldU1("pstd_buffer_bound_scale")
-					d.FieldU13("pstd_buffer_size_bound")
-				})
-			}
+		length := d.FieldU16("length")
+		d.FramedFn(int64(length)*8, func(d *decode.D) {
+			d.FieldU1("skip0")
+			d.FieldU22("rate_bound")
+			d.FieldU1("skip1")
+			d.FieldU6("audio_bound")
+			d.FieldU1("fixed_flag")
+			d.FieldU1("csps_flag")
+			d.FieldU1("system_audio_lock_flag")
+			d.FieldU1("system_video_lock_flag")
+			d.FieldU1("skip2")
+			d.FieldU5("video_bound")
+			d.FieldU1("packet_rate_restriction_flag")
+			d.FieldU7("reserved")
+			d.FieldArray("stream_bound_entries", func(d *decode.D) {
+				for d.BitsLeft() >= 24 && d.PeekUintBits(1) == 1 {
+					d.FieldStruct("stream_bound_entry", func(d *decode.D) {
+						d.FieldU8("stream_id", startAndStreamNames, scalar.UintHex)
+						d.FieldU2("skip0")
+						d.FieldU1("pstd_buffer_bound_scale")
+						d.FieldU13("pstd_buffer_size_bound")
+					})
+				}
+			})
+		})
+	case startCode == programStreamMap:
+		length := d.FieldU16("length")
+		d.FramedFn(int64(length)*8, func(d *decode.D) {
+			v = decodeProgramStreamMap(d)
 		})
-	case startCode >= 0xbd:
+	case startCode >= privateStream1:
 		length := d.FieldU16("length")
+		// zero length is only allowed for video streams in transport streams and
+		// means rest of packet
+		dataLen := int64(length) * 8
+		if length == 0 {
+			dataLen = d.BitsLeft()
+		}
 		// 0xbd-0xbd // Privatestream1
 		// 0xc0-0xdf // MPEG1OrMPEG2AudioStream
 		// 0xe0-0xef // MPEG1OrMPEG2VideoStream
-		hasExtension := startCode == 0xbd || (startCode >= 0xc0 && startCode <= 0xef)
-		var headerDataLength uint64
-		var extensionLength uint64
-		if hasExtension {
-			extensionLength = 3
-			d.FieldStruct("extension", func(d *decode.D) {
-				d.FieldU2("skip0")
-				d.FieldU2("scramble_control")
-				d.FieldU1("priority")
-				d.FieldU1("data_alignment_indicator")
-				d.FieldU1("copyright")
-				d.FieldU1("original")
-				d.FieldU2("pts_dts_flags")
-				d.FieldU1("escr_flag")
-				d.FieldU1("es_rate_flag")
-				d.FieldU1("dsm_trick_mode_flag")
-				d.FieldU1("additional_copy_info_flag")
-				d.FieldU1("pes_crc_flag")
-				d.FieldU1("pes_ext_flag")
-				headerDataLength = d.FieldU8("header_data_length")
-			})
-			// TODO:
-			d.FieldRawLen("header_data", int64(headerDataLength)*8)
-		}
+		hasHeader := startCode == privateStream1 || (startCode >= 0xc0 && startCode <= 0xef)
 
-		dataLen := int64(length-headerDataLength-extensionLength) * 8
+		d.FramedFn(dataLen, func(d *decode.D) {
+			if hasHeader {
+				// MPEG2 header starts with 0b10
+				if d.PeekUintBits(2) == 0b10 {
+					decodeMPEG2PESHeader(d)
+				} else {
+					decodeMPEG1PESHeader(d)
+				}
+			}
 
-		switch startCode {
-		case privateStream1:
-			d.FieldStruct("data", func(d *decode.D) {
-				d.FramedFn(dataLen, func(d *decode.D) {
-					substreamNumber := d.FieldU8("substream")
-					substreamBR := d.FieldRawLen("data", dataLen-8)
+			switch {
+			case startCode == privateStream1 && pi.DvdSubstreams:
+				d.FieldStruct("data", func(d *decode.D) {
+					substreamNumber := d.FieldU8("substream", subStreamNames, scalar.UintHex)
+					switch {
+					case substreamNumber >= 0x80 && substreamNumber <= 0x8f:
+						d.FieldU8("number_of_frames")
+						d.FieldU16("first_access_unit_pointer")
+					case substreamNumber >= 0xa0 && substreamNumber <= 0xa7:
+						d.FieldU8("number_of_frames")
+						d.FieldU16("first_access_unit_pointer")
+						d.FieldBool("emphasis")
+						d.FieldBool("mute")
+						d.FieldU1("reserved0")
+						d.FieldU5("frame_number")
+						d.FieldU2("quantization_word_length", lpcmQuantizationNames)
+						d.FieldU2("sample_rate", lpcmSampleRateNames)
+						d.FieldU1("reserved1")
+						d.FieldU3("channels", scalar.UintActualAdd(1))
+						d.FieldU8("dynamic_range")
+					}
+					substreamBR := d.FieldRawLen("data", d.BitsLeft())
 
 					v = subStreamPacket{
 						number: int(substreamNumber),
 						buf:    d.ReadAllBits(substreamBR),
 					}
 				})
-			})
-		default:
-			d.FieldRawLen("stream_data", dataLen)
-		}
+			default:
+				streamDataBR := d.FieldRawLen("stream_data", d.BitsLeft())
+				v = streamPacket{
+					streamID: int(startCode),
+					buf:      d.ReadAllBits(streamDataBR),
+				}
+			}
+		})
 	default:
 		// nop
 	}
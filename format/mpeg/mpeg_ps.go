@@ -0,0 +1,288 @@
+package mpeg
+
+// MPEG program stream, .mpg and DVD .vob files
+// ISO/IEC 13818-1 2.5 Program stream bitstream requirements
+// ISO/IEC 11172-1 System
+// http://dvdnav.mplayerhq.hu/dvdinfo/mpeghdrs.html
+// http://stnsoft.com/DVD/packhdr.html
+
+import (
+	"embed"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+	"golang.org/x/exp/slices"
+)
+
+//go:embed mpeg_ps.md
+var mpegPSFS embed.FS
+
+var ac3FrameFormat decode.Group
+var adtsFormat decode.Group
+var avcAnnexBFormat decode.Group
+var eac3FrameFormat decode.Group
+var hevcAnnexBFormat decode.Group
+var mp3FrameFormat decode.Group
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:        format.MPEG_PS,
+		Description: "MPEG Program Stream",
+		Groups:      []string{format.PROBE},
+		DecodeFn:    psDecode,
+		StreamFn:    psDecodeStream,
+		Dependencies: []decode.Dependency{
+			{Names: []string{format.AC3_FRAME}, Group: &ac3FrameFormat},
+			{Names: []string{format.ADTS}, Group: &adtsFormat},
+			{Names: []string{format.AVC_ANNEXB}, Group: &avcAnnexBFormat},
+			{Names: []string{format.HEVC_ANNEXB}, Group: &hevcAnnexBFormat},
+			{Names: []string{format.MP3_FRAME}, Group: &mp3FrameFormat},
+			{Names: []string{format.MPEG_PES_PACKET}, Group: &pesPacketFormat},
+			{Names: []string{format.MPEG_SPU}, Group: &spuFormat},
+		},
+	})
+	interp.RegisterFS(mpegPSFS)
+}
+
+// psPacketLength returns length in bytes of packet starting at b, 0 if more bytes
+// are needed to know and -1 if b does not start with a program stream packet
+func psPacketLength(b []byte) int {
+	if len(b) < 4 {
+		return 0
+	}
+	if b[0] != 0 || b[1] != 0 || b[2] != 1 {
+		return -1
+	}
+
+	switch startCode := b[3]; {
+	case startCode == packHeader:
+		if len(b) < 5 {
+			return 0
+		}
+		// MPEG1 pack header starts with 0b0010
+		if b[4]>>6 != 0b01 {
+			return 12
+		}
+		if len(b) < 14 {
+			return 0
+		}
+		return 14 + int(b[13]&0b111)
+	case startCode == programEnd:
+		return 4
+	case startCode >= systemHeader:
+		if len(b) < 6 {
+			return 0
+		}
+		return 6 + (int(b[4])<<8 | int(b[5]))
+	default:
+		return -1
+	}
+}
+
+// longest fixed part needed to know packet length
+const psPacketLengthPeekBytes = 14
+
+type psStream struct {
+	streamID  int
+	subStream int // -1 if not private stream 1
+	buf       []byte
+}
+
+func (s *psStream) key() int { return s.streamID<<8 | (s.subStream & 0xff) }
+
+// split mpeg1/2 video elementary stream into units at start codes, slices are
+// kept together with the picture they belong to
+func decodeVideoUnits(d *decode.D, bs []byte) {
+	var starts []int
+	for i := 0; i+3 < len(bs); i++ {
+		if bs[i] != 0 || bs[i+1] != 0 || bs[i+2] != 1 {
+			continue
+		}
+		if code := bs[i+3]; code < 0x01 || code > 0xaf {
+			starts = append(starts, i)
+		}
+		i += 2
+	}
+
+	d.FieldArrayRootBitBufFn("units", bitio.NewBitReader(bs, -1), func(d *decode.D) {
+		// data before first start code
+		if len(starts) == 0 {
+			d.FieldRawLen("data", d.BitsLeft())
+		} else if starts[0] > 0 {
+			d.FieldRawLen("data", int64(starts[0])*8)
+		}
+		for i, start := range starts {
+			end := len(bs)
+			if i+1 < len(starts) {
+				end = starts[i+1]
+			}
+			d.SeekAbs(int64(start) * 8)
+			if dv, _, _ := d.TryFieldFormatLen("unit", int64(end-start)*8, pesPacketFormat, nil); dv == nil {
+				d.FieldRawLen("unit", int64(end-start)*8)
+			}
+		}
+	})
+}
+
+// decode frames until a frame fails, rest is added as raw data
+func decodeFrames(d *decode.D, bs []byte, group decode.Group) {
+	d.FieldArrayRootBitBufFn("frames", bitio.NewBitReader(bs, -1), func(d *decode.D) {
+		for d.NotEnd() {
+			if dv, _, _ := d.TryFieldFormat("frame", group, nil); dv == nil {
+				break
+			}
+		}
+		if d.BitsLeft() > 0 {
+			d.FieldRawLen("data", d.BitsLeft())
+		}
+	})
+}
+
+// subpicture units are split into packets, first two bytes is total size
+func decodeSPUs(d *decode.D, bs []byte) {
+	d.FieldArrayRootBitBufFn("spus", bitio.NewBitReader(bs, -1), func(d *decode.D) {
+		for d.BitsLeft() >= 16 {
+			size := int64(d.PeekUintBits(16)) * 8
+			if size == 0 || size > d.BitsLeft() {
+				break
+			}
+			if dv, _, _ := d.TryFieldFormatLen("spu", size, spuFormat, nil); dv == nil {
+				d.FieldRawLen("spu", size)
+			}
+		}
+		if d.BitsLeft() > 0 {
+			d.FieldRawLen("data", d.BitsLeft())
+		}
+	})
+}
+
+func decodePSStream(d *decode.D, s *psStream, streamTypes map[int]int) {
+	d.FieldValueUint("stream_id", uint64(s.streamID), startAndStreamNames, scalar.UintHex)
+	// stream type is for the whole private stream 1 so skip for sub streams
+	streamType, hasStreamType := streamTypes[s.streamID]
+	if s.subStream != -1 {
+		d.FieldValueUint("substream", uint64(s.subStream), subStreamNames, scalar.UintHex)
+	} else if hasStreamType {
+		d.FieldValueUint("stream_type", uint64(streamType), elementaryStreamTypeNames, scalar.UintHex)
+	}
+
+	switch {
+	case s.streamID >= 0xe0 && s.streamID <= 0xef:
+		switch streamType {
+		case streamTypeAVC:
+			if dv, _, _ := d.TryFieldFormatBitBuf("data", bitio.NewBitReader(s.buf, -1), avcAnnexBFormat, nil); dv != nil {
+				return
+			}
+		case streamTypeHEVC:
+			if dv, _, _ := d.TryFieldFormatBitBuf("data", bitio.NewBitReader(s.buf, -1), hevcAnnexBFormat, nil); dv != nil {
+				return
+			}
+		case streamTypeMPEG4Video:
+			// no decoder
+		default:
+			decodeVideoUnits(d, s.buf)
+			return
+		}
+	case s.streamID >= 0xc0 && s.streamID <= 0xdf:
+		switch streamType {
+		case streamTypeADTS:
+			if dv, _, _ := d.TryFieldFormatBitBuf("data", bitio.NewBitReader(s.buf, -1), adtsFormat, nil); dv != nil {
+				return
+			}
+		default:
+			decodeFrames(d, s.buf, mp3FrameFormat)
+			return
+		}
+	case s.streamID == privateStream1 && s.subStream >= 0x20 && s.subStream <= 0x3f:
+		decodeSPUs(d, s.buf)
+		return
+	case s.streamID == privateStream1 && s.subStream >= 0x80 && s.subStream <= 0x87:
+		decodeFrames(d, s.buf, ac3FrameFormat)
+		return
+	}
+
+	d.FieldRootBitBuf("data", bitio.NewBitReader(s.buf, -1))
+}
+
+func psDecode(d *decode.D) any {
+	if d.PeekUintBits(32) != 0x00_00_01_00|packHeader {
+		d.Errorf("no pack header found")
+	}
+
+	var streams []*psStream
+	streamTypes := map[int]int{}
+	lookupStream := func(streamID int, subStream int) *psStream {
+		for _, s := range streams {
+			if s.streamID == streamID && s.subStream == subStream {
+				return s
+			}
+		}
+		s := &psStream{streamID: streamID, subStream: subStream}
+		streams = append(streams, s)
+		return s
+	}
+
+	d.FieldArray("packets", func(d *decode.D) {
+		for d.NotEnd() {
+			b, _ := d.TryBytesRange(d.Pos(), int(mathex.Min(psPacketLengthPeekBytes, d.BitsLeft()/8)))
+			n := psPacketLength(b)
+			if n <= 0 || int64(n)*8 > d.BitsLeft() {
+				break
+			}
+			dv, v, _ := d.TryFieldFormatLen("packet", int64(n)*8, pesPacketFormat, nil)
+			if dv == nil {
+				break
+			}
+
+			switch v := v.(type) {
+			case subStreamPacket:
+				s := lookupStream(privateStream1, v.number)
+				s.buf = append(s.buf, v.buf...)
+			case streamPacket:
+				if v.streamID == paddingStream {
+					continue
+				}
+				s := lookupStream(v.streamID, -1)
+				s.buf = append(s.buf, v.buf...)
+			case programStreamMapPacket:
+				for id, t := range v.streamTypes {
+					streamTypes[id] = t
+				}
+			}
+		}
+	})
+	if d.NotEnd() {
+		d.FieldRawLen("trailing", d.BitsLeft())
+	}
+
+	slices.SortFunc(streams, func(a, b *psStream) bool { return a.key() < b.key() })
+	d.FieldArray("streams", func(d *decode.D) {
+		for _, s := range streams {
+			d.FieldStruct("stream", func(d *decode.D) {
+				decodePSStream(d, s, streamTypes)
+			})
+		}
+	})
+
+	return nil
+}
+
+// one record per packet, streams are not reassembled
+func psDecodeStream(d *decode.D, _ any) any {
+	n := psPacketLength(d.BytesRange(d.Pos(), int(mathex.Min(psPacketLengthPeekBytes, d.BitsLeft()/8))))
+	switch {
+	case n == 0:
+		// more input needed, fails with a too short read
+		d.BytesRange(d.Pos(), psPacketLengthPeekBytes)
+	case n < 0:
+		d.Fatalf("no packet start code found")
+	}
+	d.FramedFn(int64(n)*8, func(d *decode.D) {
+		pesPacketDecode(d)
+	})
+	return nil
+}
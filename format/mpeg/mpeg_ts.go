@@ -1,12 +1,27 @@
 package mpeg
 
+// MPEG transport stream, .ts and .m2ts files without timecode prefix
+// ISO/IEC 13818-1 2.4 Transport stream bitstream requirements
+// ETSI TR 101 290 Measurement guidelines for DVB systems
+
 import (
+	"embed"
+	"fmt"
+	"math"
+	"time"
+
 	"github.com/wader/fq/format"
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
 	"github.com/wader/fq/pkg/scalar"
+	"golang.org/x/exp/slices"
 )
 
+//go:embed mpeg_ts.md
+var mpegTSFS embed.FS
+
 func init() {
 	interp.RegisterFormat(decode.Format{
 		Name:        format.MPEG_TS,
@@ -14,20 +29,550 @@ func init() {
 		Description: "MPEG Transport Stream",
 		Groups:      []string{format.PROBE},
 		DecodeFn:    tsDecode,
+		StreamFn:    tsDecodeStream,
+		Dependencies: []decode.Dependency{
+			{Names: []string{format.AC3_FRAME}, Group: &ac3FrameFormat},
+			{Names: []string{format.ADTS}, Group: &adtsFormat},
+			{Names: []string{format.AVC_ANNEXB}, Group: &avcAnnexBFormat},
+			{Names: []string{format.EAC3_FRAME}, Group: &eac3FrameFormat},
+			{Names: []string{format.HEVC_ANNEXB}, Group: &hevcAnnexBFormat},
+			{Names: []string{format.MP3_FRAME}, Group: &mp3FrameFormat},
+			{Names: []string{format.MPEG_PES_PACKET}, Group: &pesPacketFormat},
+		},
 	})
+	interp.RegisterFS(mpegTSFS)
 }
 
-// TODO: ts_packet
+const tsPacketLength = 188
+const tsSyncByte = 0x47
 
-func tsDecode(d *decode.D) any {
-	d.FieldU8("sync", d.UintAssert(0x47), scalar.UintHex)
-	d.FieldBool("transport_error_indicator")
-	d.FieldBool("payload_unit_start")
+// max number of bytes to search for next sync when sync is lost
+const tsResyncMaxBytes = 10 * tsPacketLength
+
+// pcr is a 27MHz clock that wraps at 2^33 * 300
+const pcrHz = 27_000_000
+const pcrWrap = (1 << 33) * 300
+
+var scramblingControlNames = scalar.UintMapSymStr{
+	0b00: "not_scrambled",
+	0b01: "reserved",
+	0b10: "even_key",
+	0b11: "odd_key",
+}
+
+var adaptationFieldControlNames = scalar.UintMapSymStr{
+	0b00: "reserved",
+	0b01: "payload",
+	0b10: "adaptation_field",
+	0b11: "adaptation_field_and_payload",
+}
+
+type tsPacket struct {
+	pid               int
+	payloadUnitStart  bool
+	transportError    bool
+	scrambled         bool
+	hasPayload        bool
+	continuityCounter int
+	discontinuity     bool
+	hasPCR            bool
+	pcr               uint64
+	payload           []byte
+}
+
+// 33 bit 90kHz base and 9 bit 27MHz extension as a 27MHz clock
+func fieldPCR(d *decode.D, name string) uint64 {
+	return d.FieldUintFn(name, func(d *decode.D) uint64 {
+		base := d.U33()
+		d.U6()
+		extension := d.U9()
+		return base*300 + extension
+	})
+}
+
+var durationNsDescription = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	s.Description = time.Duration(s.Actual).String()
+	return s, nil
+})
+
+func decodeAdaptationField(d *decode.D, p *tsPacket) {
+	length := d.FieldU8("adaptation_field_length")
+	d.FramedFn(mathex.Min(int64(length)*8, d.BitsLeft()), func(d *decode.D) {
+		if d.BitsLeft() < 8 {
+			return
+		}
+		p.discontinuity = d.FieldBool("discontinuity_indicator")
+		d.FieldBool("random_access_indicator")
+		d.FieldBool("elementary_stream_priority_indicator")
+		pcrFlag := d.FieldBool("pcr_flag")
+		opcrFlag := d.FieldBool("opcr_flag")
+		splicingPointFlag := d.FieldBool("splicing_point_flag")
+		transportPrivateDataFlag := d.FieldBool("transport_private_data_flag")
+		extensionFlag := d.FieldBool("adaptation_field_extension_flag")
+
+		if pcrFlag && d.BitsLeft() >= 48 {
+			p.pcr = fieldPCR(d, "pcr")
+			p.hasPCR = true
+		}
+		if opcrFlag && d.BitsLeft() >= 48 {
+			fieldPCR(d, "opcr")
+		}
+		if splicingPointFlag && d.BitsLeft() >= 8 {
+			d.FieldS8("splice_countdown")
+		}
+		if transportPrivateDataFlag && d.BitsLeft() >= 8 {
+			dataLength := d.FieldU8("transport_private_data_length")
+			d.FieldRawLen("transport_private_data", mathex.Min(int64(dataLength)*8, d.BitsLeft()))
+		}
+		if extensionFlag && d.BitsLeft() >= 8 {
+			extensionLength := d.FieldU8("adaptation_field_extension_length")
+			d.FieldRawLen("adaptation_field_extension", mathex.Min(int64(extensionLength)*8, d.BitsLeft()))
+		}
+		if d.BitsLeft() > 0 {
+			d.FieldRawLen("stuffing", d.BitsLeft())
+		}
+	})
+}
+
+func tsPacketDecode(d *decode.D) tsPacket {
+	var p tsPacket
+
+	d.FieldU8("sync", d.UintAssert(tsSyncByte), scalar.UintHex)
+	p.transportError = d.FieldBool("transport_error_indicator")
+	p.payloadUnitStart = d.FieldBool("payload_unit_start")
 	d.FieldBool("transport_priority")
-	d.FieldU13("pid")
-	d.FieldU2("transport_scrambling_control")
-	d.FieldU2("adaptation_field_control")
-	d.FieldU4("continuity_counter")
+	p.pid = int(d.FieldU13("pid", pidNames, scalar.UintHex))
+	p.scrambled = d.FieldU2("transport_scrambling_control", scramblingControlNames) != 0
+	adaptationFieldControl := d.FieldU2("adaptation_field_control", adaptationFieldControlNames)
+	p.continuityCounter = int(d.FieldU4("continuity_counter"))
+
+	if adaptationFieldControl&0b10 != 0 {
+		d.FieldStruct("adaptation_field", func(d *decode.D) {
+			decodeAdaptationField(d, &p)
+		})
+	}
+	if adaptationFieldControl&0b01 != 0 {
+		p.hasPayload = true
+		p.payload = d.ReadAllBits(d.FieldRawLen("payload", d.BitsLeft()))
+	} else if d.BitsLeft() > 0 {
+		d.FieldRawLen("data", d.BitsLeft())
+	}
+
+	return p
+}
+
+// continuity counter is incremented for each packet with payload per pid, one
+// duplicate packet is allowed and the discontinuity indicator resets it
+type tsContinuity struct {
+	hasLast   bool
+	last      int
+	duplicate bool
+}
+
+func (c *tsContinuity) check(p tsPacket) (int, bool) {
+	if p.pid == pidNull || !p.hasPayload {
+		return 0, true
+	}
+	if !c.hasLast || p.discontinuity {
+		c.hasLast = true
+		c.last = p.continuityCounter
+		c.duplicate = false
+		return 0, true
+	}
+	if p.continuityCounter == c.last && !c.duplicate {
+		c.duplicate = true
+		return 0, true
+	}
+	expected := (c.last + 1) & 0xf
+	c.last = p.continuityCounter
+	c.duplicate = false
+	return expected, p.continuityCounter == expected
+}
+
+// checkContinuity checks packet and annotates continuity counter field on error
+func checkContinuity(d *decode.D, c *tsContinuity, p tsPacket) bool {
+	expected, ok := c.check(p)
+	if !ok {
+		_ = d.FieldGet("continuity_counter").TryUintScalarFn(scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+			s.Description = fmt.Sprintf("discontinuity, expected %d", expected)
+			return s, nil
+		}))
+	}
+	return ok
+}
+
+// sections can span packets and a packet can have many sections, a new
+// section starts at pointer field in a payload unit start packet
+type tsSectionBuffer struct {
+	started bool
+	buf     []byte
+}
+
+func (b *tsSectionBuffer) write(p tsPacket) [][]byte {
+	payload := p.payload
+	var sections [][]byte
+
+	if p.payloadUnitStart {
+		if len(payload) == 0 {
+			return nil
+		}
+		pointer := mathex.Min(int(payload[0]), len(payload)-1)
+		payload = payload[1:]
+		if b.started {
+			b.buf = append(b.buf, payload[:pointer]...)
+			sections = append(sections, b.sections()...)
+		}
+		b.started = true
+		b.buf = append([]byte{}, payload[pointer:]...)
+	} else if b.started {
+		b.buf = append(b.buf, payload...)
+	}
+
+	return append(sections, b.sections()...)
+}
+
+// sections returns complete sections in buffer, stuffing ends sections in packet
+func (b *tsSectionBuffer) sections() [][]byte {
+	var sections [][]byte
+	for b.started && len(b.buf) >= 3 {
+		if b.buf[0] == tableIDStuffing {
+			b.started = false
+			b.buf = nil
+			break
+		}
+		n := 3 + (int(b.buf[1]&0xf)<<8 | int(b.buf[2]))
+		if len(b.buf) < n {
+			break
+		}
+		sections = append(sections, b.buf[:n])
+		b.buf = b.buf[n:]
+	}
+	return sections
+}
+
+type tsPCR struct {
+	pos           int64 // byte position of packet
+	pcr           uint64
+	discontinuity bool
+}
+
+type tsPID struct {
+	pid              int
+	packets          int
+	continuityErrors int
+	continuity       tsContinuity
+	pcrs             []tsPCR
+	sections         tsSectionBuffer
+	pes              []byte
+	pesStarted       bool
+	pesPackets       [][]byte
+}
+
+func (p *tsPID) flushPES() {
+	if p.pesStarted && len(p.pes) > 0 {
+		p.pesPackets = append(p.pesPackets, p.pes)
+	}
+	p.pes = nil
+	p.pesStarted = false
+}
+
+type tsStreamInfo struct {
+	programNumber  int
+	streamType     int
+	descriptorTags []int
+}
+
+// codecStreamType returns stream type of the elementary stream. DVB signals
+// Dolby audio as private PES with an AC-3 or Enhanced AC-3 descriptor.
+func (si tsStreamInfo) codecStreamType() int {
+	if si.streamType != streamTypePrivatePES {
+		return si.streamType
+	}
+	for _, tag := range si.descriptorTags {
+		switch tag {
+		case descriptorAC3:
+			return streamTypeAC3
+		case descriptorEnhancedAC3:
+			return streamTypeEAC3
+		}
+	}
+	return si.streamType
+}
+
+// tsResync returns number of bytes to next sync byte followed by another sync
+// byte one packet later, -1 if not found
+func tsResync(d *decode.D) int64 {
+	bs, _ := d.TryBytesRange(d.Pos(), int(mathex.Min(tsResyncMaxBytes+tsPacketLength, d.BitsLeft()/8)))
+	for i := 1; i < len(bs) && i <= tsResyncMaxBytes; i++ {
+		if bs[i] != tsSyncByte {
+			continue
+		}
+		if i+tsPacketLength >= len(bs) || bs[i+tsPacketLength] == tsSyncByte {
+			return int64(i)
+		}
+	}
+	return -1
+}
+
+func decodeTSElementaryStream(d *decode.D, streamType int, bs []byte) {
+	br := bitio.NewBitReader(bs, -1)
+	switch streamType {
+	case streamTypeAVC:
+		if dv, _, _ := d.TryFieldFormatBitBuf("data", br, avcAnnexBFormat, nil); dv != nil {
+			return
+		}
+	case streamTypeHEVC:
+		if dv, _, _ := d.TryFieldFormatBitBuf("data", br, hevcAnnexBFormat, nil); dv != nil {
+			return
+		}
+	case streamTypeADTS:
+		if dv, _, _ := d.TryFieldFormatBitBuf("data", br, adtsFormat, nil); dv != nil {
+			return
+		}
+	case streamTypeMPEG1Audio, streamTypeMPEG2Audio:
+		decodeFrames(d, bs, mp3FrameFormat)
+		return
+	case streamTypeAC3:
+		decodeFrames(d, bs, ac3FrameFormat)
+		return
+	case streamTypeEAC3:
+		decodeFrames(d, bs, eac3FrameFormat)
+		return
+	case streamTypeMPEG1Video, streamTypeMPEG2Video:
+		decodeVideoUnits(d, bs)
+		return
+	}
+	d.FieldRootBitBuf("data", br)
+}
+
+// pcr statistics estimates a constant bitrate from first and last pcr and
+// measures how much each pcr deviates from it. A pcr with discontinuity indicator
+// set starts a new time base so intervals and jitter are measured per segment.
+func decodePCRStats(d *decode.D, pcrs []tsPCR) {
+	d.FieldValueUint("pcr_count", uint64(len(pcrs)))
+
+	var segments [][]tsPCR
+	for i, p := range pcrs {
+		if i == 0 || p.discontinuity {
+			segments = append(segments, nil)
+		}
+		segments[len(segments)-1] = append(segments[len(segments)-1], p)
+	}
+	if len(segments) > 0 {
+		d.FieldValueUint("pcr_discontinuities", uint64(len(segments)-1))
+	}
+	if len(pcrs) < 2 {
+		return
+	}
+
+	// unwrap to monotonic clock per segment, in float64 as an unexpected jump
+	// backwards is a delta close to pcrWrap that would overflow in nanoseconds
+	var maxInterval float64
+	var totalBytes float64
+	var totalTicks float64
+	var maxJitter float64
+	for _, s := range segments {
+		ticks := make([]float64, len(s))
+		for i := 1; i < len(s); i++ {
+			delta := float64((s[i].pcr + pcrWrap - s[i-1].pcr) % pcrWrap)
+			maxInterval = math.Max(maxInterval, delta)
+			ticks[i] = ticks[i-1] + delta
+		}
+
+		segmentBytes := float64(s[len(s)-1].pos - s[0].pos)
+		segmentTicks := ticks[len(ticks)-1]
+		if segmentBytes <= 0 || segmentTicks <= 0 {
+			continue
+		}
+		totalBytes += segmentBytes
+		totalTicks += segmentTicks
+		for i, p := range s {
+			expected := float64(p.pos-s[0].pos) * segmentTicks / segmentBytes
+			maxJitter = math.Max(maxJitter, math.Abs(ticks[i]-expected))
+		}
+	}
+	d.FieldValueUint("pcr_max_interval", uint64(maxInterval*1e9/pcrHz), durationNsDescription)
+
+	if totalBytes <= 0 || totalTicks <= 0 {
+		return
+	}
+	d.FieldValueUint("bitrate", uint64(totalBytes*8*pcrHz/totalTicks))
+	d.FieldValueUint("pcr_max_jitter", uint64(maxJitter*1e9/pcrHz), durationNsDescription)
+}
+
+func tsDecode(d *decode.D) any {
+	if d.BitsLeft() < tsPacketLength*8 {
+		d.Errorf("less than one packet")
+	}
+	if d.PeekUintBits(8) != tsSyncByte {
+		d.Errorf("no sync byte found")
+	}
+	if d.BitsLeft() >= tsPacketLength*8*2 {
+		if b, _ := d.TryBytesRange(tsPacketLength*8, 1); len(b) != 1 || b[0] != tsSyncByte {
+			d.Errorf("no sync byte found for second packet")
+		}
+	}
+
+	pids := map[int]*tsPID{}
+	lookupPID := func(pid int) *tsPID {
+		if p, ok := pids[pid]; ok {
+			return p
+		}
+		p := &tsPID{pid: pid}
+		pids[pid] = p
+		return p
+	}
+	pmtPIDs := map[int]bool{}
+	pcrPIDs := map[int]bool{}
+	streamInfos := map[int]tsStreamInfo{}
+	isPSIPID := func(pid int) bool { return pid < 0x20 || pmtPIDs[pid] }
+
+	packetsD := d.FieldArrayValue("packets")
+	sectionsD := d.FieldArrayValue("sections")
+
+	for d.BitsLeft() >= tsPacketLength*8 {
+		if d.PeekUintBits(8) != tsSyncByte {
+			n := tsResync(d)
+			if n == -1 {
+				break
+			}
+			packetsD.FieldRawLen("unknown", n*8)
+			continue
+		}
+
+		pos := d.Pos() / 8
+		var p tsPacket
+		var tp *tsPID
+		packetsD.FieldStruct("packet", func(d *decode.D) {
+			d.FramedFn(tsPacketLength*8, func(d *decode.D) {
+				p = tsPacketDecode(d)
+			})
+			tp = lookupPID(p.pid)
+			tp.packets++
+			if !checkContinuity(d, &tp.continuity, p) {
+				tp.continuityErrors++
+			}
+		})
+
+		if p.hasPCR {
+			tp.pcrs = append(tp.pcrs, tsPCR{pos: pos, pcr: p.pcr, discontinuity: p.discontinuity})
+		}
+		if p.transportError || p.scrambled || !p.hasPayload || p.pid == pidNull {
+			continue
+		}
+
+		if isPSIPID(p.pid) {
+			for _, bs := range tp.sections.write(p) {
+				s := decodeSection(sectionsD, p.pid, bs)
+				if !s.crcValid {
+					continue
+				}
+				switch s.tableID {
+				case tableIDPAT:
+					for _, pr := range s.programs {
+						if pr.number != 0 {
+							pmtPIDs[pr.pid] = true
+						}
+					}
+				case tableIDPMT:
+					pcrPIDs[s.pcrPID] = true
+					for _, st := range s.streams {
+						streamInfos[st.pid] = tsStreamInfo{programNumber: s.programNumber, streamType: st.streamType, descriptorTags: st.descriptorTags}
+					}
+				}
+			}
+			continue
+		}
+
+		if p.payloadUnitStart {
+			tp.flushPES()
+			tp.pesStarted = true
+		}
+		if tp.pesStarted {
+			tp.pes = append(tp.pes, p.payload...)
+		}
+	}
+	if d.NotEnd() {
+		d.FieldRawLen("trailing", d.BitsLeft())
+	}
+
+	var sortedPIDs []*tsPID
+	for _, tp := range pids {
+		tp.flushPES()
+		sortedPIDs = append(sortedPIDs, tp)
+	}
+	slices.SortFunc(sortedPIDs, func(a, b *tsPID) bool { return a.pid < b.pid })
+
+	d.FieldArray("streams", func(d *decode.D) {
+		for _, tp := range sortedPIDs {
+			// pmt pid only known after pat so it might have been buffered as pes
+			if len(tp.pesPackets) == 0 || isPSIPID(tp.pid) {
+				continue
+			}
+			d.FieldStruct("stream", func(d *decode.D) {
+				d.FieldValueUint("pid", uint64(tp.pid), scalar.UintHex)
+				si, hasStreamInfo := streamInfos[tp.pid]
+				if hasStreamInfo {
+					d.FieldValueUint("program_number", uint64(si.programNumber))
+					d.FieldValueUint("stream_type", uint64(si.streamType), elementaryStreamTypeNames, scalar.UintHex)
+				}
+
+				var es []byte
+				d.FieldArray("packets", func(d *decode.D) {
+					for _, bs := range tp.pesPackets {
+						br := bitio.NewBitReader(bs, -1)
+						dv, v, _ := d.TryFieldFormatBitBuf("packet", br, pesPacketFormat, format.MpegPesPacketIn{})
+						if dv == nil {
+							d.FieldRootBitBuf("packet", br)
+							continue
+						}
+						if sp, ok := v.(streamPacket); ok {
+							es = append(es, sp.buf...)
+						}
+					}
+				})
+
+				if !hasStreamInfo {
+					d.FieldRootBitBuf("data", bitio.NewBitReader(es, -1))
+					return
+				}
+				decodeTSElementaryStream(d, si.codecStreamType(), es)
+			})
+		}
+	})
+
+	d.FieldArray("pids", func(d *decode.D) {
+		for _, tp := range sortedPIDs {
+			d.FieldStruct("pid", func(d *decode.D) {
+				d.FieldValueUint("pid", uint64(tp.pid), pidNames, scalar.UintHex)
+				d.FieldValueUint("packets", uint64(tp.packets))
+				d.FieldValueUint("continuity_errors", uint64(tp.continuityErrors))
+				if pcrPIDs[tp.pid] || len(tp.pcrs) > 0 {
+					decodePCRStats(d, tp.pcrs)
+				}
+			})
+		}
+	})
 
 	return nil
 }
+
+// one record per packet, sections and streams are not reassembled
+func tsDecodeStream(d *decode.D, state any) any {
+	continuity, _ := state.(map[int]*tsContinuity)
+	if continuity == nil {
+		continuity = map[int]*tsContinuity{}
+	}
+
+	d.FramedFn(tsPacketLength*8, func(d *decode.D) {
+		p := tsPacketDecode(d)
+		c, ok := continuity[p.pid]
+		if !ok {
+			c = &tsContinuity{}
+			continuity[p.pid] = c
+		}
+		checkContinuity(d, c, p)
+	})
+
+	return continuity
+}
@@ -0,0 +1,497 @@
+package mpeg
+
+// MPEG transport stream program specific information and DVB service information
+// ISO/IEC 13818-1 2.4.4 Program specific information
+// ETSI EN 300 468 Specification for Service Information (SI) in DVB systems
+
+import (
+	"time"
+
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/checksum"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const (
+	pidPAT  = 0x0000
+	pidCAT  = 0x0001
+	pidTSDT = 0x0002
+	pidNIT  = 0x0010
+	pidSDT  = 0x0011
+	pidEIT  = 0x0012
+	pidRST  = 0x0013
+	pidTDT  = 0x0014
+	pidNull = 0x1fff
+)
+
+var pidNames = scalar.UintMap{
+	pidPAT:  {Sym: "pat", Description: "Program association table"},
+	pidCAT:  {Sym: "cat", Description: "Conditional access table"},
+	pidTSDT: {Sym: "tsdt", Description: "Transport stream description table"},
+	pidNIT:  {Sym: "nit", Description: "Network information table"},
+	pidSDT:  {Sym: "sdt", Description: "Service description table"},
+	pidEIT:  {Sym: "eit", Description: "Event information table"},
+	pidRST:  {Sym: "rst", Description: "Running status table"},
+	pidTDT:  {Sym: "tdt", Description: "Time and date table"},
+	pidNull: {Sym: "null", Description: "Null packets"},
+}
+
+const (
+	tableIDPAT       = 0x00
+	tableIDCAT       = 0x01
+	tableIDPMT       = 0x02
+	tableIDNIT       = 0x40
+	tableIDSDT       = 0x42
+	tableIDSDTOther  = 0x46
+	tableIDEIT       = 0x4e
+	tableIDEITOther  = 0x4f
+	tableIDTDT       = 0x70
+	tableIDTOT       = 0x73
+	tableIDStuffing  = 0xff
+	tableIDEITSchedS = 0x50
+	tableIDEITSchedE = 0x6f
+)
+
+var tableIDNames = scalar.UintRangeToScalar{
+	{Range: [2]uint64{tableIDPAT, tableIDPAT}, S: scalar.Uint{Sym: "pat", Description: "Program association section"}},
+	{Range: [2]uint64{tableIDCAT, tableIDCAT}, S: scalar.Uint{Sym: "cat", Description: "Conditional access section"}},
+	{Range: [2]uint64{tableIDPMT, tableIDPMT}, S: scalar.Uint{Sym: "pmt", Description: "Program map section"}},
+	{Range: [2]uint64{0x03, 0x03}, S: scalar.Uint{Sym: "tsdt", Description: "Transport stream description section"}},
+	{Range: [2]uint64{tableIDNIT, tableIDNIT}, S: scalar.Uint{Sym: "nit_actual", Description: "Network information section, actual network"}},
+	{Range: [2]uint64{0x41, 0x41}, S: scalar.Uint{Sym: "nit_other", Description: "Network information section, other network"}},
+	{Range: [2]uint64{tableIDSDT, tableIDSDT}, S: scalar.Uint{Sym: "sdt_actual", Description: "Service description section, actual transport stream"}},
+	{Range: [2]uint64{tableIDSDTOther, tableIDSDTOther}, S: scalar.Uint{Sym: "sdt_other", Description: "Service description section, other transport stream"}},
+	{Range: [2]uint64{0x4a, 0x4a}, S: scalar.Uint{Sym: "bat", Description: "Bouquet association section"}},
+	{Range: [2]uint64{tableIDEIT, tableIDEIT}, S: scalar.Uint{Sym: "eit_actual_pf", Description: "Event information section, actual transport stream, present/following"}},
+	{Range: [2]uint64{tableIDEITOther, tableIDEITOther}, S: scalar.Uint{Sym: "eit_other_pf", Description: "Event information section, other transport stream, present/following"}},
+	{Range: [2]uint64{tableIDEITSchedS, 0x5f}, S: scalar.Uint{Sym: "eit_actual_schedule", Description: "Event information section, actual transport stream, schedule"}},
+	{Range: [2]uint64{0x60, tableIDEITSchedE}, S: scalar.Uint{Sym: "eit_other_schedule", Description: "Event information section, other transport stream, schedule"}},
+	{Range: [2]uint64{tableIDTDT, tableIDTDT}, S: scalar.Uint{Sym: "tdt", Description: "Time date section"}},
+	{Range: [2]uint64{0x71, 0x71}, S: scalar.Uint{Sym: "rst", Description: "Running status section"}},
+	{Range: [2]uint64{0x72, 0x72}, S: scalar.Uint{Sym: "st", Description: "Stuffing section"}},
+	{Range: [2]uint64{tableIDTOT, tableIDTOT}, S: scalar.Uint{Sym: "tot", Description: "Time offset section"}},
+	{Range: [2]uint64{tableIDStuffing, tableIDStuffing}, S: scalar.Uint{Sym: "stuffing"}},
+}
+
+var runningStatusNames = scalar.UintMapSymStr{
+	0: "undefined",
+	1: "not_running",
+	2: "starts_in_a_few_seconds",
+	3: "pausing",
+	4: "running",
+	5: "service_off_air",
+}
+
+var serviceTypeNames = scalar.UintMap{
+	0x01: {Sym: "digital_television"},
+	0x02: {Sym: "digital_radio_sound"},
+	0x03: {Sym: "teletext"},
+	0x0a: {Sym: "advanced_codec_digital_radio_sound"},
+	0x11: {Sym: "mpeg2_hd_digital_television"},
+	0x16: {Sym: "advanced_codec_sd_digital_television"},
+	0x19: {Sym: "advanced_codec_hd_digital_television"},
+	0x1f: {Sym: "hevc_digital_television"},
+}
+
+const (
+	descriptorRegistration     = 0x05
+	descriptorISO639Language   = 0x0a
+	descriptorService          = 0x48
+	descriptorShortEvent       = 0x4d
+	descriptorStreamIdentifier = 0x52
+	descriptorAC3              = 0x6a
+	descriptorEnhancedAC3      = 0x7a
+)
+
+var descriptorTagNames = scalar.UintMap{
+	0x02:                       {Sym: "video_stream"},
+	0x03:                       {Sym: "audio_stream"},
+	descriptorRegistration:     {Sym: "registration"},
+	0x06:                       {Sym: "data_stream_alignment"},
+	0x09:                       {Sym: "ca"},
+	descriptorISO639Language:   {Sym: "iso_639_language"},
+	0x0e:                       {Sym: "maximum_bitrate"},
+	0x28:                       {Sym: "avc_video"},
+	0x38:                       {Sym: "hevc_video"},
+	0x40:                       {Sym: "network_name"},
+	0x41:                       {Sym: "service_list"},
+	descriptorService:          {Sym: "service"},
+	descriptorShortEvent:       {Sym: "short_event"},
+	0x4e:                       {Sym: "extended_event"},
+	0x50:                       {Sym: "component"},
+	descriptorStreamIdentifier: {Sym: "stream_identifier"},
+	0x54:                       {Sym: "content"},
+	0x55:                       {Sym: "parental_rating"},
+	0x56:                       {Sym: "teletext"},
+	0x59:                       {Sym: "subtitling"},
+	descriptorAC3:              {Sym: "ac3"},
+	descriptorEnhancedAC3:      {Sym: "enhanced_ac3"},
+	0x7c:                       {Sym: "aac"},
+	0x7f:                       {Sym: "extension"},
+}
+
+var iso639AudioTypeNames = scalar.UintMapSymStr{
+	0x00: "undefined",
+	0x01: "clean_effects",
+	0x02: "hearing_impaired",
+	0x03: "visual_impaired_commentary",
+}
+
+// ETSI EN 300 468 Annex A.2 Selection of character table, without a selector
+// byte text is ISO/IEC 6937 which is approximated by ISO/IEC 8859-1
+var dvbEncodings = map[uint64]encoding.Encoding{
+	0x01: charmap.ISO8859_5,
+	0x02: charmap.ISO8859_6,
+	0x03: charmap.ISO8859_7,
+	0x04: charmap.ISO8859_8,
+	0x05: charmap.ISO8859_9,
+	0x06: charmap.ISO8859_10,
+	0x09: charmap.ISO8859_13,
+	0x0a: charmap.ISO8859_14,
+	0x0b: charmap.ISO8859_15,
+	0x15: unicode.UTF8,
+}
+
+var dvbEncodingNames = scalar.UintMapSymStr{
+	0x01: "iso_8859-5",
+	0x02: "iso_8859-6",
+	0x03: "iso_8859-7",
+	0x04: "iso_8859-8",
+	0x05: "iso_8859-9",
+	0x06: "iso_8859-10",
+	0x07: "iso_8859-11",
+	0x09: "iso_8859-13",
+	0x0a: "iso_8859-14",
+	0x0b: "iso_8859-15",
+	0x10: "iso_8859",
+	0x11: "iso_10646",
+	0x12: "ksx1001",
+	0x13: "gb2312",
+	0x14: "big5",
+	0x15: "utf8",
+}
+
+// psiProgram is a program number and its program map or network pid
+type psiProgram struct {
+	number int
+	pid    int
+}
+
+type psiStream struct {
+	streamType     int
+	pid            int
+	descriptorTags []int
+}
+
+// psiSection is the information about a section needed to demux a transport stream
+type psiSection struct {
+	tableID  int
+	crcValid bool
+	// pat
+	programs []psiProgram
+	// pmt
+	programNumber int
+	pcrPID        int
+	streams       []psiStream
+}
+
+// fieldDVBString reads a text field with an optional character table selector
+func fieldDVBString(d *decode.D, name string, nBytes int) {
+	enc := encoding.Encoding(charmap.ISO8859_1)
+	if nBytes > 0 {
+		if selector := d.PeekUintBits(8); selector < 0x20 {
+			selectorBytes := 1
+			if selector == 0x10 {
+				selectorBytes = 3
+			}
+			selectorBytes = mathex.Min(selectorBytes, nBytes)
+			d.FieldU8(name+"_encoding", dvbEncodingNames, scalar.UintHex)
+			if selectorBytes == 3 {
+				d.FieldU16(name + "_encoding_table")
+			}
+			nBytes -= selectorBytes
+			if e, ok := dvbEncodings[selector]; ok {
+				enc = e
+			}
+		}
+	}
+	d.FieldStr(name, nBytes, enc)
+}
+
+func bcdByte(v uint64) uint64 { return (v>>4)*10 + v&0xf }
+
+// 24 bit BCD hours, minutes and seconds as seconds
+func bcdSeconds(v uint64) uint64 {
+	return bcdByte(v>>16&0xff)*3600 + bcdByte(v>>8&0xff)*60 + bcdByte(v&0xff)
+}
+
+// fieldUTCTime reads 16 bit modified julian date and 24 bit BCD time as unix time
+func fieldUTCTime(d *decode.D, name string) {
+	d.FieldUintFn(name, func(d *decode.D) uint64 {
+		mjd := d.U16()
+		hms := d.U24()
+		// 1970-01-01 is MJD 40587
+		return (mjd-40587)*86400 + bcdSeconds(hms)
+	}, scalar.UintActualUnixTime(time.RFC3339))
+}
+
+// ETSI EN 300 468 Annex D.3 and D.5 AC-3 and Enhanced AC-3 descriptors, each
+// flag in the first byte tells if a following one byte field is present
+func decodeAC3DescriptorFields(d *decode.D, flagNames []string, names []string) {
+	var flags []bool
+	for _, n := range flagNames {
+		flags = append(flags, d.FieldBool(n))
+	}
+	if len(flagNames) < 8 {
+		d.FieldU("reserved", 8-len(flagNames))
+	}
+	for i, n := range names {
+		// mixinfoexists has no field
+		if n != "" && flags[i] && d.BitsLeft() >= 8 {
+			d.FieldU8(n)
+		}
+	}
+}
+
+func decodeDescriptor(d *decode.D) {
+	tag := d.FieldU8("tag", descriptorTagNames, scalar.UintHex)
+	length := d.FieldU8("length")
+	d.FramedFn(mathex.Min(int64(length)*8, d.BitsLeft()), func(d *decode.D) {
+		switch tag {
+		case descriptorRegistration:
+			if d.BitsLeft() >= 32 {
+				d.FieldUTF8("format_identifier", 4)
+			}
+		case descriptorISO639Language:
+			d.FieldStructArrayLoop("languages", "language", func() bool { return d.BitsLeft() >= 32 }, func(d *decode.D) {
+				d.FieldUTF8("language", 3)
+				d.FieldU8("audio_type", iso639AudioTypeNames)
+			})
+		case descriptorService:
+			if d.BitsLeft() < 16 {
+				break
+			}
+			d.FieldU8("service_type", serviceTypeNames, scalar.UintHex)
+			providerNameLength := d.FieldU8("service_provider_name_length")
+			fieldDVBString(d, "service_provider_name", int(mathex.Min(int64(providerNameLength), d.BitsLeft()/8)))
+			if d.BitsLeft() < 8 {
+				break
+			}
+			nameLength := d.FieldU8("service_name_length")
+			fieldDVBString(d, "service_name", int(mathex.Min(int64(nameLength), d.BitsLeft()/8)))
+		case descriptorShortEvent:
+			if d.BitsLeft() < 32 {
+				break
+			}
+			d.FieldUTF8("language", 3)
+			nameLength := d.FieldU8("event_name_length")
+			fieldDVBString(d, "event_name", int(mathex.Min(int64(nameLength), d.BitsLeft()/8)))
+			if d.BitsLeft() < 8 {
+				break
+			}
+			textLength := d.FieldU8("text_length")
+			fieldDVBString(d, "text", int(mathex.Min(int64(textLength), d.BitsLeft()/8)))
+		case descriptorStreamIdentifier:
+			if d.BitsLeft() >= 8 {
+				d.FieldU8("component_tag")
+			}
+		case descriptorAC3:
+			if d.BitsLeft() < 8 {
+				break
+			}
+			decodeAC3DescriptorFields(d,
+				[]string{"component_type_flag", "bsid_flag", "mainid_flag", "asvc_flag"},
+				[]string{"component_type", "bsid", "mainid", "asvc"},
+			)
+		case descriptorEnhancedAC3:
+			if d.BitsLeft() < 8 {
+				break
+			}
+			decodeAC3DescriptorFields(d,
+				[]string{"component_type_flag", "bsid_flag", "mainid_flag", "asvc_flag", "mixinfoexists", "substream1_flag", "substream2_flag", "substream3_flag"},
+				[]string{"component_type", "bsid", "mainid", "asvc", "", "substream1", "substream2", "substream3"},
+			)
+		}
+		if d.BitsLeft() > 0 {
+			d.FieldRawLen("data", d.BitsLeft())
+		}
+	})
+}
+
+// descriptor loop prefixed by 4 reserved bits and a 12 bit length, returns
+// descriptor tags
+func decodeDescriptors(d *decode.D, reservedName string, lengthName string) []int {
+	var tags []int
+	d.FieldU4(reservedName)
+	length := d.FieldU12(lengthName)
+	d.FramedFn(mathex.Min(int64(length)*8, d.BitsLeft()), func(d *decode.D) {
+		d.FieldStructArrayLoop("descriptors", "descriptor", func() bool { return d.BitsLeft() >= 16 }, func(d *decode.D) {
+			tags = append(tags, int(d.PeekUintBits(8)))
+			decodeDescriptor(d)
+		})
+		if d.BitsLeft() > 0 {
+			d.FieldRawLen("data", d.BitsLeft())
+		}
+	})
+	return tags
+}
+
+func decodePAT(d *decode.D, s *psiSection) {
+	d.FieldStructArrayLoop("programs", "program", func() bool { return d.BitsLeft() >= 32 }, func(d *decode.D) {
+		number := d.FieldU16("program_number")
+		d.FieldU3("reserved")
+		pidName := "program_map_pid"
+		if number == 0 {
+			pidName = "network_pid"
+		}
+		pid := d.FieldU13(pidName, scalar.UintHex)
+		s.programs = append(s.programs, psiProgram{number: int(number), pid: int(pid)})
+	})
+}
+
+func decodePMT(d *decode.D, s *psiSection) {
+	if d.BitsLeft() < 32 {
+		return
+	}
+	d.FieldU3("reserved2")
+	s.pcrPID = int(d.FieldU13("pcr_pid", scalar.UintHex))
+	decodeDescriptors(d, "reserved3", "program_info_length")
+	d.FieldStructArrayLoop("streams", "stream", func() bool { return d.BitsLeft() >= 40 }, func(d *decode.D) {
+		streamType := d.FieldU8("stream_type", elementaryStreamTypeNames, scalar.UintHex)
+		d.FieldU3("reserved0")
+		pid := d.FieldU13("elementary_pid", scalar.UintHex)
+		tags := decodeDescriptors(d, "reserved1", "es_info_length")
+		s.streams = append(s.streams, psiStream{streamType: int(streamType), pid: int(pid), descriptorTags: tags})
+	})
+}
+
+func decodeSDT(d *decode.D) {
+	if d.BitsLeft() < 24 {
+		return
+	}
+	d.FieldU16("original_network_id")
+	d.FieldU8("reserved2")
+	d.FieldStructArrayLoop("services", "service", func() bool { return d.BitsLeft() >= 40 }, func(d *decode.D) {
+		d.FieldU16("service_id")
+		d.FieldU6("reserved")
+		d.FieldBool("eit_schedule_flag")
+		d.FieldBool("eit_present_following_flag")
+		d.FieldU3("running_status", runningStatusNames)
+		d.FieldBool("free_ca_mode")
+		length := d.FieldU12("descriptors_loop_length")
+		d.FramedFn(mathex.Min(int64(length)*8, d.BitsLeft()), func(d *decode.D) {
+			d.FieldStructArrayLoop("descriptors", "descriptor", func() bool { return d.BitsLeft() >= 16 }, decodeDescriptor)
+		})
+	})
+}
+
+func decodeEIT(d *decode.D) {
+	if d.BitsLeft() < 48 {
+		return
+	}
+	d.FieldU16("transport_stream_id")
+	d.FieldU16("original_network_id")
+	d.FieldU8("segment_last_section_number")
+	d.FieldU8("last_table_id", tableIDNames, scalar.UintHex)
+	d.FieldStructArrayLoop("events", "event", func() bool { return d.BitsLeft() >= 96 }, func(d *decode.D) {
+		d.FieldU16("event_id")
+		fieldUTCTime(d, "start_time")
+		d.FieldU24("duration", scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+			s.Actual = bcdSeconds(s.Actual)
+			return s, nil
+		}))
+		d.FieldU3("running_status", runningStatusNames)
+		d.FieldBool("free_ca_mode")
+		length := d.FieldU12("descriptors_loop_length")
+		d.FramedFn(mathex.Min(int64(length)*8, d.BitsLeft()), func(d *decode.D) {
+			d.FieldStructArrayLoop("descriptors", "descriptor", func() bool { return d.BitsLeft() >= 16 }, decodeDescriptor)
+		})
+	})
+}
+
+// table id extension meaning depends on table
+func tableIDExtensionName(tableID uint64) string {
+	switch {
+	case tableID == tableIDPAT, tableID == tableIDSDT, tableID == tableIDSDTOther:
+		return "transport_stream_id"
+	case tableID == tableIDPMT:
+		return "program_number"
+	case tableID == tableIDNIT, tableID == 0x41:
+		return "network_id"
+	case tableID == tableIDEIT, tableID == tableIDEITOther,
+		tableID >= tableIDEITSchedS && tableID <= tableIDEITSchedE:
+		return "service_id"
+	default:
+		return "table_id_extension"
+	}
+}
+
+// decodeSection decodes one complete section, bs length is 3 bytes header plus section length
+func decodeSection(d *decode.D, pid int, bs []byte) psiSection {
+	var s psiSection
+
+	d.FieldStructRootBitBufFn("section", bitio.NewBitReader(bs, -1), func(d *decode.D) {
+		d.FieldValueUint("pid", uint64(pid), pidNames, scalar.UintHex)
+		tableID := d.FieldU8("table_id", tableIDNames, scalar.UintHex)
+		s.tableID = int(tableID)
+		syntaxIndicator := d.FieldBool("section_syntax_indicator")
+		d.FieldU1("private_indicator")
+		d.FieldU2("reserved0")
+		d.FieldU12("section_length")
+
+		switch {
+		case !syntaxIndicator && tableID == tableIDTDT && d.BitsLeft() >= 40:
+			fieldUTCTime(d, "utc_time")
+		case !syntaxIndicator && tableID == tableIDTOT && d.BitsLeft() >= 88:
+			fieldUTCTime(d, "utc_time")
+			d.FramedFn(d.BitsLeft()-32, func(d *decode.D) {
+				decodeDescriptors(d, "reserved1", "descriptors_loop_length")
+			})
+			decodeSectionCRC(d, &s, bs)
+		case syntaxIndicator && d.BitsLeft() >= 72:
+			programNumber := d.FieldU16(tableIDExtensionName(tableID))
+			s.programNumber = int(programNumber)
+			d.FieldU2("reserved1")
+			d.FieldU5("version_number")
+			d.FieldBool("current_next_indicator")
+			d.FieldU8("section_number")
+			d.FieldU8("last_section_number")
+			d.FramedFn(d.BitsLeft()-32, func(d *decode.D) {
+				switch {
+				case tableID == tableIDPAT:
+					decodePAT(d, &s)
+				case tableID == tableIDPMT:
+					decodePMT(d, &s)
+				case tableID == tableIDSDT, tableID == tableIDSDTOther:
+					decodeSDT(d)
+				case tableID == tableIDEIT, tableID == tableIDEITOther,
+					tableID >= tableIDEITSchedS && tableID <= tableIDEITSchedE:
+					decodeEIT(d)
+				}
+				if d.BitsLeft() > 0 {
+					d.FieldRawLen("data", d.BitsLeft())
+				}
+			})
+			decodeSectionCRC(d, &s, bs)
+		}
+		if d.BitsLeft() > 0 {
+			d.FieldRawLen("data", d.BitsLeft())
+		}
+	})
+
+	return s
+}
+
+// CRC32 of whole section including crc field is zero
+func decodeSectionCRC(d *decode.D, s *psiSection, bs []byte) {
+	crc := &checksum.CRC{Bits: 32, Current: 0xffff_ffff, Table: checksum.Poly04c11db7Table}
+	_, _ = crc.Write(bs[:len(bs)-4])
+	crcBytes := crc.Sum(nil)
+	d.FieldU32("crc32", d.UintValidateBytes(crcBytes), scalar.UintHex)
+	s.crcValid = string(crcBytes) == string(bs[len(bs)-4:])
+}
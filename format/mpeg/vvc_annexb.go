@@ -0,0 +1,24 @@
+package mpeg
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+)
+
+var annexBVVCNALUFormat decode.Group
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:        format.VVC_ANNEXB,
+		Description: "H.266/VVC Annex B",
+		DecodeFn: func(d *decode.D) any {
+			return annexBDecode(d, annexBVVCNALUFormat)
+		},
+		RootArray: true,
+		RootName:  "stream",
+		Dependencies: []decode.Dependency{
+			{Names: []string{format.VVC_NALU}, Group: &annexBVVCNALUFormat},
+		},
+	})
+}
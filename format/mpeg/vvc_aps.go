@@ -0,0 +1,250 @@
+package mpeg
+
+// https://www.itu.int/rec/T-REC-H.266
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:        format.VVC_APS,
+		Description: "H.266/VVC Adaptation Parameter Set",
+		DecodeFn:    vvcAPSDecode,
+	})
+}
+
+const (
+	vvcAPSTypeALF     = 0
+	vvcAPSTypeLMCS    = 1
+	vvcAPSTypeScaling = 2
+)
+
+var vvcAPSTypeNames = scalar.UintMapSymStr{
+	vvcAPSTypeALF:     "alf",
+	vvcAPSTypeLMCS:    "lmcs",
+	vvcAPSTypeScaling: "scaling",
+}
+
+const (
+	vvcNumAlfFilters      = 25
+	vvcAlfLumaCoeffs      = 12
+	vvcAlfChromaCoeffs    = 6
+	vvcAlfCCCoeffs        = 7
+	vvcLmcsMaxBinIdx      = 15
+	vvcNumScalingMatrices = 28
+)
+
+func vvcAlfCoeffs(d *decode.D, name string, n int, clipFlag bool) {
+	d.FieldArray(name+"_coeffs", func(d *decode.D) {
+		for j := 0; j < n; j++ {
+			d.FieldStruct(name+"_coeff", func(d *decode.D) {
+				abs := d.FieldUintFn(name+"_coeff_abs", uEV)
+				if abs != 0 {
+					d.FieldBool(name + "_coeff_sign")
+				}
+			})
+		}
+	})
+	if clipFlag {
+		d.FieldArray(name+"_clip_idxs", func(d *decode.D) {
+			for j := 0; j < n; j++ {
+				d.FieldU2(name + "_clip_idx")
+			}
+		})
+	}
+}
+
+func vvcAlfCCFilters(d *decode.D, name string) {
+	filtersSignalledMinus1 := d.FieldUintFn(name+"_filters_signalled_minus1", uEV)
+	d.FieldArray(name+"_filters", func(d *decode.D) {
+		for k := uint64(0); k <= filtersSignalledMinus1; k++ {
+			d.FieldArray(name+"_filter", func(d *decode.D) {
+				for j := 0; j < vvcAlfCCCoeffs; j++ {
+					d.FieldStruct(name+"_coeff", func(d *decode.D) {
+						abs := d.FieldU3(name + "_mapped_coeff_abs")
+						if abs != 0 {
+							d.FieldBool(name + "_coeff_sign")
+						}
+					})
+				}
+			})
+		}
+	})
+}
+
+// H.266 7.3.2.18 Adaptive loop filter data syntax
+func vvcAlfData(d *decode.D, apsChromaPresentFlag bool) {
+	alfLumaFilterSignalFlag := d.FieldBool("alf_luma_filter_signal_flag")
+	var alfChromaFilterSignalFlag bool
+	var alfCCCbFilterSignalFlag bool
+	var alfCCCrFilterSignalFlag bool
+	if apsChromaPresentFlag {
+		alfChromaFilterSignalFlag = d.FieldBool("alf_chroma_filter_signal_flag")
+		alfCCCbFilterSignalFlag = d.FieldBool("alf_cc_cb_filter_signal_flag")
+		alfCCCrFilterSignalFlag = d.FieldBool("alf_cc_cr_filter_signal_flag")
+	}
+	if alfLumaFilterSignalFlag {
+		alfLumaClipFlag := d.FieldBool("alf_luma_clip_flag")
+		alfLumaNumFiltersSignalledMinus1 := d.FieldUintFn("alf_luma_num_filters_signalled_minus1", uEV)
+		if alfLumaNumFiltersSignalledMinus1 > 0 {
+			coeffDeltaIdxBits := vvcCeilLog2(alfLumaNumFiltersSignalledMinus1 + 1)
+			d.FieldArray("alf_luma_coeff_delta_idxs", func(d *decode.D) {
+				for i := 0; i < vvcNumAlfFilters; i++ {
+					d.FieldU("alf_luma_coeff_delta_idx", coeffDeltaIdxBits)
+				}
+			})
+		}
+		d.FieldArray("alf_luma_filters", func(d *decode.D) {
+			for i := uint64(0); i <= alfLumaNumFiltersSignalledMinus1; i++ {
+				d.FieldStruct("alf_luma_filter", func(d *decode.D) {
+					vvcAlfCoeffs(d, "alf_luma", vvcAlfLumaCoeffs, false)
+				})
+			}
+		})
+		if alfLumaClipFlag {
+			d.FieldArray("alf_luma_clips", func(d *decode.D) {
+				for i := uint64(0); i <= alfLumaNumFiltersSignalledMinus1; i++ {
+					d.FieldArray("alf_luma_clip", func(d *decode.D) {
+						for j := 0; j < vvcAlfLumaCoeffs; j++ {
+							d.FieldU2("alf_luma_clip_idx")
+						}
+					})
+				}
+			})
+		}
+	}
+	if alfChromaFilterSignalFlag {
+		alfChromaClipFlag := d.FieldBool("alf_chroma_clip_flag")
+		alfChromaNumAltFiltersMinus1 := d.FieldUintFn("alf_chroma_num_alt_filters_minus1", uEV)
+		d.FieldArray("alf_chroma_filters", func(d *decode.D) {
+			for i := uint64(0); i <= alfChromaNumAltFiltersMinus1; i++ {
+				d.FieldStruct("alf_chroma_filter", func(d *decode.D) {
+					vvcAlfCoeffs(d, "alf_chroma", vvcAlfChromaCoeffs, alfChromaClipFlag)
+				})
+			}
+		})
+	}
+	if alfCCCbFilterSignalFlag {
+		vvcAlfCCFilters(d, "alf_cc_cb")
+	}
+	if alfCCCrFilterSignalFlag {
+		vvcAlfCCFilters(d, "alf_cc_cr")
+	}
+}
+
+// H.266 7.3.2.19 Luma mapping with chroma scaling data syntax
+func vvcLmcsData(d *decode.D, apsChromaPresentFlag bool) {
+	lmcsMinBinIdx := d.FieldUintFn("lmcs_min_bin_idx", uEV)
+	lmcsDeltaMaxBinIdx := d.FieldUintFn("lmcs_delta_max_bin_idx", uEV)
+	lmcsDeltaCwPrecMinus1 := d.FieldUintFn("lmcs_delta_cw_prec_minus1", uEV)
+	if lmcsDeltaMaxBinIdx > vvcLmcsMaxBinIdx {
+		d.Fatalf("lmcs_delta_max_bin_idx %d out of range", lmcsDeltaMaxBinIdx)
+	}
+	lmcsMaxBinIdx := vvcLmcsMaxBinIdx - lmcsDeltaMaxBinIdx
+	d.FieldArray("bins", func(d *decode.D) {
+		for i := lmcsMinBinIdx; i <= lmcsMaxBinIdx; i++ {
+			d.FieldStruct("bin", func(d *decode.D) {
+				lmcsDeltaAbsCw := d.FieldU("lmcs_delta_abs_cw", int(lmcsDeltaCwPrecMinus1)+1)
+				if lmcsDeltaAbsCw > 0 {
+					d.FieldBool("lmcs_delta_sign_cw_flag")
+				}
+			})
+		}
+	})
+	if apsChromaPresentFlag {
+		lmcsDeltaAbsCrs := d.FieldU3("lmcs_delta_abs_crs")
+		if lmcsDeltaAbsCrs > 0 {
+			d.FieldBool("lmcs_delta_sign_crs_flag")
+		}
+	}
+}
+
+// H.266 6.5.2 Up-right diagonal scan order array initialization process
+func vvcDiagScanOrder(blkSize int) [][2]int {
+	var scan [][2]int
+	x, y := 0, 0
+	for len(scan) < blkSize*blkSize {
+		for y >= 0 {
+			if x < blkSize && y < blkSize {
+				scan = append(scan, [2]int{x, y})
+			}
+			y--
+			x++
+		}
+		y = x
+		x = 0
+	}
+	return scan
+}
+
+// H.266 7.3.2.20 Scaling list data syntax
+func vvcScalingListData(d *decode.D, apsChromaPresentFlag bool) {
+	d.FieldArray("scaling_lists", func(d *decode.D) {
+		for id := 0; id < vvcNumScalingMatrices; id++ {
+			matrixSize := 8
+			switch {
+			case id < 2:
+				matrixSize = 2
+			case id < 8:
+				matrixSize = 4
+			}
+			// chroma lists are only present with chroma
+			if !apsChromaPresentFlag && id%3 != 2 && id != 27 {
+				continue
+			}
+			d.FieldStruct("scaling_list", func(d *decode.D) {
+				d.FieldValueUint("id", uint64(id))
+				scalingListCopyModeFlag := d.FieldBool("scaling_list_copy_mode_flag")
+				var scalingListPredModeFlag bool
+				if !scalingListCopyModeFlag {
+					scalingListPredModeFlag = d.FieldBool("scaling_list_pred_mode_flag")
+				}
+				if (scalingListCopyModeFlag || scalingListPredModeFlag) && id != 0 && id != 2 && id != 8 {
+					d.FieldUintFn("scaling_list_pred_id_delta", uEV)
+				}
+				if scalingListCopyModeFlag {
+					return
+				}
+				if id > 13 {
+					d.FieldSintFn("scaling_list_dc_coef", sEV)
+				}
+				d.FieldArray("scaling_list_delta_coefs", func(d *decode.D) {
+					for _, xy := range vvcDiagScanOrder(matrixSize) {
+						// 64x64 chroma lists only code top left 4x4
+						if id > 25 && xy[0] >= 4 && xy[1] >= 4 {
+							continue
+						}
+						d.FieldSintFn("scaling_list_delta_coef", sEV)
+					}
+				})
+			})
+		}
+	})
+}
+
+// H.266 7.3.2.6 Adaptation parameter set RBSP syntax
+func vvcAPSDecode(d *decode.D) any {
+	apsParamsType := d.FieldU3("aps_params_type", vvcAPSTypeNames)
+	d.FieldU5("aps_adaptation_parameter_set_id")
+	apsChromaPresentFlag := d.FieldBool("aps_chroma_present_flag")
+	switch apsParamsType {
+	case vvcAPSTypeALF:
+		d.FieldStruct("alf_data", func(d *decode.D) { vvcAlfData(d, apsChromaPresentFlag) })
+	case vvcAPSTypeLMCS:
+		d.FieldStruct("lmcs_data", func(d *decode.D) { vvcLmcsData(d, apsChromaPresentFlag) })
+	case vvcAPSTypeScaling:
+		d.FieldStruct("scaling_list_data", func(d *decode.D) { vvcScalingListData(d, apsChromaPresentFlag) })
+	default:
+		return nil
+	}
+	apsExtensionFlag := d.FieldBool("aps_extension_flag")
+	if apsExtensionFlag {
+		d.FieldRawLen("aps_extension_data", d.BitsLeft())
+	}
+
+	return nil
+}
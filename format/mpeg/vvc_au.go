@@ -0,0 +1,44 @@
+package mpeg
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+)
+
+var vvcAUNALFormat decode.Group
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:        format.VVC_AU,
+		Description: "H.266/VVC Access Unit",
+		DecodeFn:    vvcAUDecode,
+		DefaultInArg: format.VvcAuIn{
+			LengthSize: 4,
+		},
+		RootArray: true,
+		RootName:  "access_unit",
+		Dependencies: []decode.Dependency{
+			{Names: []string{format.VVC_NALU}, Group: &vvcAUNALFormat},
+		},
+	})
+}
+
+func vvcAUDecode(d *decode.D) any {
+	var vi format.VvcAuIn
+	d.ArgAs(&vi)
+
+	if vi.LengthSize == 0 {
+		annexBDecode(d, vvcAUNALFormat)
+		return nil
+	}
+
+	for d.NotEnd() {
+		d.FieldStruct("nalu", func(d *decode.D) {
+			l := int64(d.FieldU("length", int(vi.LengthSize)*8)) * 8
+			d.FieldFormatLen("nalu", l, vvcAUNALFormat, nil)
+		})
+	}
+
+	return nil
+}
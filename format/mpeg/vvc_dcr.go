@@ -0,0 +1,101 @@
+package mpeg
+
+// ISO/IEC 14496-15 11.2.4.2 VVC decoder configuration record
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+var vvcDCRNALFormat decode.Group
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:        format.VVC_DCR,
+		Description: "H.266/VVC Decoder Configuration Record",
+		DecodeFn:    vvcDcrDecode,
+		Dependencies: []decode.Dependency{
+			{Names: []string{format.VVC_NALU}, Group: &vvcDCRNALFormat},
+		},
+	})
+}
+
+func vvcPTLRecordDecode(d *decode.D, numSublayers uint64) {
+	d.FieldU2("reserved0")
+	numBytesConstraintInfo := d.FieldU6("num_bytes_constraint_info")
+	d.FieldU7("general_profile_idc", vvcProfileNames)
+	d.FieldBool("general_tier_flag")
+	d.FieldU8("general_level_idc", vvcLevelDescription)
+	d.FieldBool("ptl_frame_only_constraint_flag")
+	d.FieldBool("ptl_multilayer_enabled_flag")
+	if numBytesConstraintInfo > 0 {
+		d.FieldRawLen("general_constraint_info", int64(numBytesConstraintInfo)*8-2)
+	}
+	sublayerLevelPresentFlags := make([]bool, numSublayers)
+	d.FieldArray("ptl_sublayer_level_present_flags", func(d *decode.D) {
+		for i := int(numSublayers) - 2; i >= 0; i-- {
+			sublayerLevelPresentFlags[i] = d.FieldBool("ptl_sublayer_level_present_flag")
+		}
+	})
+	if numSublayers > 1 {
+		d.FieldU("ptl_reserved_zero_bits", 9-int(numSublayers))
+	}
+	d.FieldArray("sublayer_level_idcs", func(d *decode.D) {
+		for i := int(numSublayers) - 2; i >= 0; i-- {
+			if sublayerLevelPresentFlags[i] {
+				d.FieldU8("sublayer_level_idc", vvcLevelDescription)
+			}
+		}
+	})
+	ptlNumSubProfiles := d.FieldU8("ptl_num_sub_profiles")
+	d.FieldArray("general_sub_profile_idcs", func(d *decode.D) {
+		for i := uint64(0); i < ptlNumSubProfiles; i++ {
+			d.FieldU32("general_sub_profile_idc", scalar.UintHex)
+		}
+	})
+}
+
+func vvcDcrDecode(d *decode.D) any {
+	d.FieldU5("reserved0")
+	lengthSize := d.FieldU2("length_size", scalar.UintActualAdd(1))
+	ptlPresentFlag := d.FieldBool("ptl_present_flag")
+	if ptlPresentFlag {
+		d.FieldU9("ols_idx")
+		numSublayers := d.FieldU3("num_sublayers")
+		d.FieldU2("constant_frame_rate")
+		d.FieldU2("chroma_format_idc", chromaFormatMap)
+		d.FieldU3("bit_depth", scalar.UintActualAdd(8))
+		d.FieldU5("reserved1")
+		d.FieldStruct("native_ptl", func(d *decode.D) { vvcPTLRecordDecode(d, numSublayers) })
+		d.FieldU16("max_picture_width")
+		d.FieldU16("max_picture_height")
+		d.FieldU16("avg_frame_rate")
+	}
+	numArrays := d.FieldU8("num_of_arrays")
+	d.FieldArray("arrays", func(d *decode.D) {
+		for i := uint64(0); i < numArrays; i++ {
+			d.FieldStruct("array", func(d *decode.D) {
+				d.FieldU1("array_completeness")
+				d.FieldU2("reserved0")
+				nalUnitType := d.FieldU5("nal_unit_type", vvcNALNames)
+				numNals := uint64(1)
+				// decoding capability and operating point information have no count
+				if nalUnitType != vvcNALNUTDCI && nalUnitType != vvcNALNUTOPI {
+					numNals = d.FieldU16("num_nalus")
+				}
+				d.FieldArray("nals", func(d *decode.D) {
+					for i := uint64(0); i < numNals; i++ {
+						d.FieldStruct("nal", func(d *decode.D) {
+							nalUnitLength := int64(d.FieldU16("nal_unit_length"))
+							d.FieldFormatLen("nal", nalUnitLength*8, vvcDCRNALFormat, nil)
+						})
+					}
+				})
+			})
+		}
+	})
+
+	return format.VvcDcrOut{LengthSize: lengthSize}
+}
@@ -0,0 +1,133 @@
+package mpeg
+
+// https://www.itu.int/rec/T-REC-H.266
+
+import (
+	"fmt"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+var vvcVPSFormat decode.Group
+var vvcSPSFormat decode.Group
+var vvcPPSFormat decode.Group
+var vvcAPSFormat decode.Group
+var vvcPHFormat decode.Group
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:        format.VVC_NALU,
+		Description: "H.266/VVC Network Access Layer Unit",
+		DecodeFn:    vvcNALUDecode,
+		Dependencies: []decode.Dependency{
+			{Names: []string{format.VVC_VPS}, Group: &vvcVPSFormat},
+			{Names: []string{format.VVC_SPS}, Group: &vvcSPSFormat},
+			{Names: []string{format.VVC_PPS}, Group: &vvcPPSFormat},
+			{Names: []string{format.VVC_APS}, Group: &vvcAPSFormat},
+			{Names: []string{format.VVC_PH}, Group: &vvcPHFormat},
+		},
+	})
+}
+
+const (
+	vvcNALNUTOPI       = 12
+	vvcNALNUTDCI       = 13
+	vvcNALNUTVPS       = 14
+	vvcNALNUTSPS       = 15
+	vvcNALNUTPPS       = 16
+	vvcNALNUTPrefixAPS = 17
+	vvcNALNUTSuffixAPS = 18
+	vvcNALNUTPH        = 19
+)
+
+// H.266 Table 5 NAL unit type codes and NAL unit type classes
+var vvcNALNames = scalar.UintMapSymStr{
+	0:                  "TRAIL_NUT",
+	1:                  "STSA_NUT",
+	2:                  "RADL_NUT",
+	3:                  "RASL_NUT",
+	4:                  "RSV_VCL_4",
+	5:                  "RSV_VCL_5",
+	6:                  "RSV_VCL_6",
+	7:                  "IDR_W_RADL",
+	8:                  "IDR_N_LP",
+	9:                  "CRA_NUT",
+	10:                 "GDR_NUT",
+	11:                 "RSV_IRAP_11",
+	vvcNALNUTOPI:       "OPI_NUT",
+	vvcNALNUTDCI:       "DCI_NUT",
+	vvcNALNUTVPS:       "VPS_NUT",
+	vvcNALNUTSPS:       "SPS_NUT",
+	vvcNALNUTPPS:       "PPS_NUT",
+	vvcNALNUTPrefixAPS: "PREFIX_APS_NUT",
+	vvcNALNUTSuffixAPS: "SUFFIX_APS_NUT",
+	vvcNALNUTPH:        "PH_NUT",
+	20:                 "AUD_NUT",
+	21:                 "EOS_NUT",
+	22:                 "EOB_NUT",
+	23:                 "PREFIX_SEI_NUT",
+	24:                 "SUFFIX_SEI_NUT",
+	25:                 "FD_NUT",
+	26:                 "RSV_NVCL_26",
+	27:                 "RSV_NVCL_27",
+	28:                 "UNSPEC_28",
+	29:                 "UNSPEC_29",
+	30:                 "UNSPEC_30",
+	31:                 "UNSPEC_31",
+}
+
+// H.266 Table A.1 general_profile_idc
+var vvcProfileNames = scalar.UintMapSymStr{
+	1:   "main_10",
+	2:   "main_12",
+	10:  "main_12_intra",
+	17:  "multilayer_main_10",
+	33:  "main_10_444",
+	34:  "main_12_444",
+	35:  "main_16_444",
+	42:  "main_12_444_intra",
+	43:  "main_16_444_intra",
+	49:  "multilayer_main_10_444",
+	65:  "main_10_still_picture",
+	66:  "main_12_still_picture",
+	81:  "multilayer_main_10_still_picture",
+	97:  "main_10_444_still_picture",
+	98:  "main_12_444_still_picture",
+	99:  "main_16_444_still_picture",
+	113: "multilayer_main_10_444_still_picture",
+}
+
+// level_idc is 16 times major level plus 3 times minor level
+var vvcLevelDescription = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	s.Description = fmt.Sprintf("%d.%d", s.Actual/16, (s.Actual%16)/3)
+	return s, nil
+})
+
+func vvcNALUDecode(d *decode.D) any {
+	d.FieldBool("forbidden_zero_bit")
+	d.FieldBool("nuh_reserved_zero_bit")
+	d.FieldU6("nuh_layer_id")
+	nalType := d.FieldU5("nal_unit_type", vvcNALNames)
+	d.FieldU3("nuh_temporal_id_plus1")
+	unescapedBR := d.NewBitBufFromReader(nalUnescapeReader{Reader: bitio.NewIOReader(d.BitBufRange(d.Pos(), d.BitsLeft()))})
+
+	switch nalType {
+	case vvcNALNUTVPS:
+		d.FieldFormatBitBuf("vps", unescapedBR, vvcVPSFormat, nil)
+	case vvcNALNUTSPS:
+		d.FieldFormatBitBuf("sps", unescapedBR, vvcSPSFormat, nil)
+	case vvcNALNUTPPS:
+		d.FieldFormatBitBuf("pps", unescapedBR, vvcPPSFormat, nil)
+	case vvcNALNUTPrefixAPS, vvcNALNUTSuffixAPS:
+		d.FieldFormatBitBuf("aps", unescapedBR, vvcAPSFormat, nil)
+	case vvcNALNUTPH:
+		d.FieldFormatBitBuf("ph", unescapedBR, vvcPHFormat, nil)
+	}
+	d.FieldRawLen("data", d.BitsLeft())
+
+	return nil
+}
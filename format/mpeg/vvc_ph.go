@@ -0,0 +1,43 @@
+package mpeg
+
+// https://www.itu.int/rec/T-REC-H.266
+
+import (
+	"embed"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed vvc_ph.md
+var vvcPHFS embed.FS
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:        format.VVC_PH,
+		Description: "H.266/VVC Picture Header",
+		DecodeFn:    vvcPHDecode,
+	})
+	interp.RegisterFS(vvcPHFS)
+}
+
+// H.266 7.3.2.8 Picture header structure syntax
+func vvcPHDecode(d *decode.D) any {
+	phGdrOrIrapPicFlag := d.FieldBool("ph_gdr_or_irap_pic_flag")
+	d.FieldBool("ph_non_ref_pic_flag")
+	if phGdrOrIrapPicFlag {
+		d.FieldBool("ph_gdr_pic_flag")
+	}
+	phInterSliceAllowedFlag := d.FieldBool("ph_inter_slice_allowed_flag")
+	if phInterSliceAllowedFlag {
+		d.FieldBool("ph_intra_slice_allowed_flag")
+	}
+	d.FieldUintFn("ph_pic_parameter_set_id", uEV)
+	// rest of the picture header depends on the active SPS and PPS, ex:
+	// ph_pic_order_cnt_lsb length, so is not decoded without them
+	d.FieldRawLen("ph_remaining", d.BitsLeft(), scalar.BitBufDescription("Depends on active SPS and PPS"))
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package mpeg
+
+// https://www.itu.int/rec/T-REC-H.266
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:        format.VVC_PPS,
+		Description: "H.266/VVC Picture Parameter Set",
+		DecodeFn:    vvcPPSDecode,
+	})
+}
+
+// H.266 6.5.1 tile sizes in ctbs from explicit sizes, remaining size is split
+// into tiles of the last explicit size
+func vvcTileSizes(sizeInCtbs uint64, explicitSizesMinus1 []uint64) []uint64 {
+	remaining := sizeInCtbs
+	var sizes []uint64
+	var uniform uint64
+	for _, s := range explicitSizesMinus1 {
+		uniform = s + 1
+		if uniform > remaining {
+			return append(sizes, remaining)
+		}
+		remaining -= uniform
+		sizes = append(sizes, uniform)
+	}
+	for uniform > 0 && remaining >= uniform {
+		remaining -= uniform
+		sizes = append(sizes, uniform)
+	}
+	if remaining > 0 {
+		sizes = append(sizes, remaining)
+	}
+	return sizes
+}
+
+// H.266 7.3.2.5 rectangular slice layout, slice positions are derived as in 6.5.1
+// from the tile grid and previous slices
+func vvcRectSliceLayout(d *decode.D, ppsNumSlicesInPicMinus1 uint64, numTileColumns uint64, tileRowHeights []uint64) {
+	numTileRows := uint64(len(tileRowHeights))
+	numTiles := numTileColumns * numTileRows
+	var ppsTileIdxDeltaPresentFlag bool
+	if ppsNumSlicesInPicMinus1 > 1 {
+		ppsTileIdxDeltaPresentFlag = d.FieldBool("pps_tile_idx_delta_present_flag")
+	}
+	d.FieldArray("slices", func(d *decode.D) {
+		var tileIdx uint64
+		var sliceHeightInTilesMinus1 uint64
+		for i := uint64(0); i < ppsNumSlicesInPicMinus1; i++ {
+			numSlicesInTile := uint64(1)
+			d.FieldStruct("slice", func(d *decode.D) {
+				if tileIdx >= numTiles {
+					d.Fatalf("slice top left tile index %d outside %d tiles", tileIdx, numTiles)
+				}
+				d.FieldValueUint("slice_top_left_tile_idx", tileIdx)
+				tileX := tileIdx % numTileColumns
+				tileY := tileIdx / numTileColumns
+				var sliceWidthInTilesMinus1 uint64
+				if tileX != numTileColumns-1 {
+					sliceWidthInTilesMinus1 = d.FieldUintFn("pps_slice_width_in_tiles_minus1", uEV)
+				}
+				// inferred from previous slice when not present, except on last tile row
+				if tileY != numTileRows-1 && (ppsTileIdxDeltaPresentFlag || tileX == 0) {
+					sliceHeightInTilesMinus1 = d.FieldUintFn("pps_slice_height_in_tiles_minus1", uEV)
+				} else if tileY == numTileRows-1 {
+					sliceHeightInTilesMinus1 = 0
+				}
+				if sliceWidthInTilesMinus1 == 0 && sliceHeightInTilesMinus1 == 0 && tileRowHeights[tileY] > 1 {
+					ppsNumExpSlicesInTile := d.FieldUintFn("pps_num_exp_slices_in_tile", uEV)
+					if ppsNumExpSlicesInTile > 0 {
+						remainingHeight := tileRowHeights[tileY]
+						var uniformSliceHeight uint64
+						d.FieldArray("pps_exp_slice_heights_in_ctus", func(d *decode.D) {
+							for j := uint64(0); j < ppsNumExpSlicesInTile; j++ {
+								uniformSliceHeight = d.FieldUintFn("pps_exp_slice_height_in_ctus_minus1", uEV) + 1
+								if uniformSliceHeight > remainingHeight {
+									d.Fatalf("explicit slice heights larger than tile row height %d", tileRowHeights[tileY])
+								}
+								remainingHeight -= uniformSliceHeight
+							}
+						})
+						numSlicesInTile = ppsNumExpSlicesInTile + remainingHeight/uniformSliceHeight
+						if remainingHeight%uniformSliceHeight > 0 {
+							numSlicesInTile++
+						}
+					}
+					d.FieldValueUint("num_slices_in_tile", numSlicesInTile)
+				}
+				if i+numSlicesInTile-1 < ppsNumSlicesInPicMinus1 {
+					if ppsTileIdxDeltaPresentFlag {
+						tileIdx = uint64(int64(tileIdx) + d.FieldSintFn("pps_tile_idx_delta_val", sEV))
+					} else {
+						tileIdx += sliceWidthInTilesMinus1 + 1
+						if tileIdx%numTileColumns == 0 {
+							tileIdx += sliceHeightInTilesMinus1 * numTileColumns
+						}
+					}
+				}
+			})
+			// following slices are in the same tile
+			i += numSlicesInTile - 1
+		}
+	})
+}
+
+// H.266 7.3.2.5 Picture parameter set RBSP syntax
+func vvcPPSDecode(d *decode.D) any {
+	d.FieldU6("pps_pic_parameter_set_id")
+	d.FieldU4("pps_seq_parameter_set_id")
+	d.FieldBool("pps_mixed_nalu_types_in_pic_flag")
+	ppsPicWidthInLumaSamples := d.FieldUintFn("pps_pic_width_in_luma_samples", uEV)
+	ppsPicHeightInLumaSamples := d.FieldUintFn("pps_pic_height_in_luma_samples", uEV)
+	ppsConformanceWindowFlag := d.FieldBool("pps_conformance_window_flag")
+	if ppsConformanceWindowFlag {
+		d.FieldUintFn("pps_conf_win_left_offset", uEV)
+		d.FieldUintFn("pps_conf_win_right_offset", uEV)
+		d.FieldUintFn("pps_conf_win_top_offset", uEV)
+		d.FieldUintFn("pps_conf_win_bottom_offset", uEV)
+	}
+	ppsScalingWindowExplicitSignallingFlag := d.FieldBool("pps_scaling_window_explicit_signalling_flag")
+	if ppsScalingWindowExplicitSignallingFlag {
+		d.FieldSintFn("pps_scaling_win_left_offset", sEV)
+		d.FieldSintFn("pps_scaling_win_right_offset", sEV)
+		d.FieldSintFn("pps_scaling_win_top_offset", sEV)
+		d.FieldSintFn("pps_scaling_win_bottom_offset", sEV)
+	}
+	d.FieldBool("pps_output_flag_present_flag")
+	ppsNoPicPartitionFlag := d.FieldBool("pps_no_pic_partition_flag")
+	ppsSubpicIDMappingPresentFlag := d.FieldBool("pps_subpic_id_mapping_present_flag")
+	if ppsSubpicIDMappingPresentFlag {
+		var ppsNumSubpicsMinus1 uint64
+		if !ppsNoPicPartitionFlag {
+			ppsNumSubpicsMinus1 = d.FieldUintFn("pps_num_subpics_minus1", uEV)
+		}
+		ppsSubpicIDLenMinus1 := d.FieldUintFn("pps_subpic_id_len_minus1", uEV)
+		d.FieldArray("pps_subpic_ids", func(d *decode.D) {
+			for i := uint64(0); i <= ppsNumSubpicsMinus1; i++ {
+				d.FieldU("pps_subpic_id", int(ppsSubpicIDLenMinus1)+1)
+			}
+		})
+	}
+	if !ppsNoPicPartitionFlag {
+		ppsLog2CtuSizeMinus5 := d.FieldU2("pps_log2_ctu_size_minus5")
+		ctbSizeY := uint64(1) << (ppsLog2CtuSizeMinus5 + 5)
+		ppsNumExpTileColumnsMinus1 := d.FieldUintFn("pps_num_exp_tile_columns_minus1", uEV)
+		ppsNumExpTileRowsMinus1 := d.FieldUintFn("pps_num_exp_tile_rows_minus1", uEV)
+		var tileColumnWidthsMinus1 []uint64
+		d.FieldArray("pps_tile_column_widths", func(d *decode.D) {
+			for i := uint64(0); i <= ppsNumExpTileColumnsMinus1; i++ {
+				tileColumnWidthsMinus1 = append(tileColumnWidthsMinus1, d.FieldUintFn("pps_tile_column_width_minus1", uEV))
+			}
+		})
+		var tileRowHeightsMinus1 []uint64
+		d.FieldArray("pps_tile_row_heights", func(d *decode.D) {
+			for i := uint64(0); i <= ppsNumExpTileRowsMinus1; i++ {
+				tileRowHeightsMinus1 = append(tileRowHeightsMinus1, d.FieldUintFn("pps_tile_row_height_minus1", uEV))
+			}
+		})
+		numTileColumns := uint64(len(vvcTileSizes((ppsPicWidthInLumaSamples+ctbSizeY-1)/ctbSizeY, tileColumnWidthsMinus1)))
+		tileRowHeights := vvcTileSizes((ppsPicHeightInLumaSamples+ctbSizeY-1)/ctbSizeY, tileRowHeightsMinus1)
+		numTileRows := uint64(len(tileRowHeights))
+		d.FieldValueUint("num_tile_columns", numTileColumns)
+		d.FieldValueUint("num_tile_rows", numTileRows)
+
+		ppsRectSliceFlag := true
+		if numTileColumns*numTileRows > 1 {
+			d.FieldBool("pps_loop_filter_across_tiles_enabled_flag")
+			ppsRectSliceFlag = d.FieldBool("pps_rect_slice_flag")
+		}
+		var ppsSingleSlicePerSubpicFlag bool
+		if ppsRectSliceFlag {
+			ppsSingleSlicePerSubpicFlag = d.FieldBool("pps_single_slice_per_subpic_flag")
+		}
+		var ppsNumSlicesInPicMinus1 uint64
+		if ppsRectSliceFlag && !ppsSingleSlicePerSubpicFlag {
+			ppsNumSlicesInPicMinus1 = d.FieldUintFn("pps_num_slices_in_pic_minus1", uEV)
+			vvcRectSliceLayout(d, ppsNumSlicesInPicMinus1, numTileColumns, tileRowHeights)
+		}
+		if !ppsRectSliceFlag || ppsSingleSlicePerSubpicFlag || ppsNumSlicesInPicMinus1 > 0 {
+			d.FieldBool("pps_loop_filter_across_slices_enabled_flag")
+		}
+	}
+	d.FieldBool("pps_cabac_init_present_flag")
+	d.FieldArray("pps_num_ref_idx_default_active", func(d *decode.D) {
+		for i := 0; i < 2; i++ {
+			d.FieldUintFn("pps_num_ref_idx_default_active_minus1", uEV)
+		}
+	})
+	d.FieldBool("pps_rpl1_idx_present_flag")
+	ppsWeightedPredFlag := d.FieldBool("pps_weighted_pred_flag")
+	ppsWeightedBipredFlag := d.FieldBool("pps_weighted_bipred_flag")
+	ppsRefWraparoundEnabledFlag := d.FieldBool("pps_ref_wraparound_enabled_flag")
+	if ppsRefWraparoundEnabledFlag {
+		d.FieldUintFn("pps_pic_width_minus_wraparound_offset", uEV)
+	}
+	d.FieldSintFn("pps_init_qp_minus26", sEV)
+	d.FieldBool("pps_cu_qp_delta_enabled_flag")
+	ppsChromaToolOffsetsPresentFlag := d.FieldBool("pps_chroma_tool_offsets_present_flag")
+	if ppsChromaToolOffsetsPresentFlag {
+		d.FieldSintFn("pps_cb_qp_offset", sEV)
+		d.FieldSintFn("pps_cr_qp_offset", sEV)
+		ppsJointCbcrQpOffsetPresentFlag := d.FieldBool("pps_joint_cbcr_qp_offset_present_flag")
+		if ppsJointCbcrQpOffsetPresentFlag {
+			d.FieldSintFn("pps_joint_cbcr_qp_offset_value", sEV)
+		}
+		d.FieldBool("pps_slice_chroma_qp_offsets_present_flag")
+		ppsCuChromaQpOffsetListEnabledFlag := d.FieldBool("pps_cu_chroma_qp_offset_list_enabled_flag")
+		if ppsCuChromaQpOffsetListEnabledFlag {
+			ppsChromaQpOffsetListLenMinus1 := d.FieldUintFn("pps_chroma_qp_offset_list_len_minus1", uEV)
+			d.FieldArray("chroma_qp_offset_lists", func(d *decode.D) {
+				for i := uint64(0); i <= ppsChromaQpOffsetListLenMinus1; i++ {
+					d.FieldStruct("chroma_qp_offset_list", func(d *decode.D) {
+						d.FieldSintFn("pps_cb_qp_offset_list", sEV)
+						d.FieldSintFn("pps_cr_qp_offset_list", sEV)
+						if ppsJointCbcrQpOffsetPresentFlag {
+							d.FieldSintFn("pps_joint_cbcr_qp_offset_list", sEV)
+						}
+					})
+				}
+			})
+		}
+	}
+	ppsDeblockingFilterControlPresentFlag := d.FieldBool("pps_deblocking_filter_control_present_flag")
+	if ppsDeblockingFilterControlPresentFlag {
+		ppsDeblockingFilterOverrideEnabledFlag := d.FieldBool("pps_deblocking_filter_override_enabled_flag")
+		ppsDeblockingFilterDisabledFlag := d.FieldBool("pps_deblocking_filter_disabled_flag")
+		if !ppsNoPicPartitionFlag && ppsDeblockingFilterOverrideEnabledFlag {
+			d.FieldBool("pps_dbf_info_in_ph_flag")
+		}
+		if !ppsDeblockingFilterDisabledFlag {
+			d.FieldSintFn("pps_luma_beta_offset_div2", sEV)
+			d.FieldSintFn("pps_luma_tc_offset_div2", sEV)
+			if ppsChromaToolOffsetsPresentFlag {
+				d.FieldSintFn("pps_cb_beta_offset_div2", sEV)
+				d.FieldSintFn("pps_cb_tc_offset_div2", sEV)
+				d.FieldSintFn("pps_cr_beta_offset_div2", sEV)
+				d.FieldSintFn("pps_cr_tc_offset_div2", sEV)
+			}
+		}
+	}
+	if !ppsNoPicPartitionFlag {
+		ppsRplInfoInPhFlag := d.FieldBool("pps_rpl_info_in_ph_flag")
+		d.FieldBool("pps_sao_info_in_ph_flag")
+		d.FieldBool("pps_alf_info_in_ph_flag")
+		if (ppsWeightedPredFlag || ppsWeightedBipredFlag) && ppsRplInfoInPhFlag {
+			d.FieldBool("pps_wp_info_in_ph_flag")
+		}
+		d.FieldBool("pps_qp_delta_info_in_ph_flag")
+	}
+	d.FieldBool("pps_picture_header_extension_present_flag")
+	d.FieldBool("pps_slice_header_extension_present_flag")
+	ppsExtensionFlag := d.FieldBool("pps_extension_flag")
+	if ppsExtensionFlag {
+		d.FieldRawLen("pps_extension_data", d.BitsLeft())
+	}
+
+	return nil
+}
@@ -0,0 +1,731 @@
+package mpeg
+
+// https://www.itu.int/rec/T-REC-H.266
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:        format.VVC_SPS,
+		Description: "H.266/VVC Sequence Parameter Set",
+		DecodeFn:    vvcSPSDecode,
+	})
+}
+
+// number of bits needed to code values below n, Ceil(Log2(n))
+func vvcCeilLog2(n uint64) int {
+	l := 0
+	for (uint64(1) << l) < n {
+		l++
+	}
+	return l
+}
+
+func vvcByteAlignment(d *decode.D, name string) {
+	if n := (8 - d.Pos()%8) % 8; n > 0 {
+		d.FieldU(name, int(n))
+	}
+}
+
+// H.266 D.2.1 VUI payload syntax
+func vvcVuiPayload(d *decode.D) {
+	// H.274 7.2 VUI parameters syntax
+	vuiProgressiveSourceFlag := d.FieldBool("vui_progressive_source_flag")
+	vuiInterlacedSourceFlag := d.FieldBool("vui_interlaced_source_flag")
+	d.FieldBool("vui_non_packed_constraint_flag")
+	d.FieldBool("vui_non_projected_constraint_flag")
+	vuiAspectRatioInfoPresentFlag := d.FieldBool("vui_aspect_ratio_info_present_flag")
+	if vuiAspectRatioInfoPresentFlag {
+		d.FieldBool("vui_aspect_ratio_constant_flag")
+		vuiAspectRatioIdc := d.FieldU8("vui_aspect_ratio_idc", avcAspectRatioIdcMap)
+		const extendedSAR = 255
+		if vuiAspectRatioIdc == extendedSAR {
+			d.FieldU16("vui_sar_width")
+			d.FieldU16("vui_sar_height")
+		}
+	}
+	vuiOverscanInfoPresentFlag := d.FieldBool("vui_overscan_info_present_flag")
+	if vuiOverscanInfoPresentFlag {
+		d.FieldBool("vui_overscan_appropriate_flag")
+	}
+	vuiColourDescriptionPresentFlag := d.FieldBool("vui_colour_description_present_flag")
+	if vuiColourDescriptionPresentFlag {
+		d.FieldU8("vui_colour_primaries", format.ISO_23091_2_ColourPrimariesMap)
+		d.FieldU8("vui_transfer_characteristics", format.ISO_23091_2_TransferCharacteristicMap)
+		d.FieldU8("vui_matrix_coeffs", format.ISO_23091_2_MatrixCoefficients)
+		d.FieldBool("vui_full_range_flag")
+	}
+	vuiChromaLocInfoPresentFlag := d.FieldBool("vui_chroma_loc_info_present_flag")
+	if vuiChromaLocInfoPresentFlag {
+		if vuiProgressiveSourceFlag && !vuiInterlacedSourceFlag {
+			d.FieldUintFn("vui_chroma_sample_loc_type_frame", uEV)
+		} else {
+			d.FieldUintFn("vui_chroma_sample_loc_type_top_field", uEV)
+			d.FieldUintFn("vui_chroma_sample_loc_type_bottom_field", uEV)
+		}
+	}
+
+	if d.BitsLeft() == 0 {
+		return
+	}
+	// payload ends with a one bit and zero bits up to byte alignment, bits
+	// before that is extension data
+	start := d.Pos()
+	oneBitPos := int64(-1)
+	for p := d.Len() - 1; p >= start; p-- {
+		d.SeekAbs(p)
+		if d.U1() == 1 {
+			oneBitPos = p
+			break
+		}
+	}
+	d.SeekAbs(start)
+	if oneBitPos == -1 {
+		d.FieldRawLen("vui_reserved_payload_extension_data", d.BitsLeft())
+		return
+	}
+	if oneBitPos > start {
+		d.FieldRawLen("vui_reserved_payload_extension_data", oneBitPos-start)
+	}
+	d.FieldU1("vui_payload_bit_equal_to_one", d.UintAssert(1))
+	if d.BitsLeft() > 0 {
+		d.FieldU("vui_payload_bit_equal_to_zero", int(d.BitsLeft()), d.UintAssert(0))
+	}
+}
+
+// H.266 7.3.3.2 General constraints information syntax
+func vvcGeneralConstraintsInfo(d *decode.D) {
+	gciPresentFlag := d.FieldBool("gci_present_flag")
+	if gciPresentFlag {
+		// general
+		d.FieldBool("gci_intra_only_constraint_flag")
+		d.FieldBool("gci_all_layers_independent_constraint_flag")
+		d.FieldBool("gci_one_au_only_constraint_flag")
+		// picture format
+		d.FieldU4("gci_sixteen_minus_max_bitdepth_constraint_idc")
+		d.FieldU2("gci_three_minus_max_chroma_format_constraint_idc")
+		// nal unit type related
+		d.FieldBool("gci_no_mixed_nalu_types_in_pic_constraint_flag")
+		d.FieldBool("gci_no_trail_constraint_flag")
+		d.FieldBool("gci_no_stsa_constraint_flag")
+		d.FieldBool("gci_no_rasl_constraint_flag")
+		d.FieldBool("gci_no_radl_constraint_flag")
+		d.FieldBool("gci_no_idr_constraint_flag")
+		d.FieldBool("gci_no_cra_constraint_flag")
+		d.FieldBool("gci_no_gdr_constraint_flag")
+		d.FieldBool("gci_no_aps_constraint_flag")
+		d.FieldBool("gci_no_idr_rpl_constraint_flag")
+		// tile, slice, subpicture partitioning
+		d.FieldBool("gci_one_tile_per_pic_constraint_flag")
+		d.FieldBool("gci_pic_header_in_slice_header_constraint_flag")
+		d.FieldBool("gci_one_slice_per_pic_constraint_flag")
+		d.FieldBool("gci_no_rectangular_slice_constraint_flag")
+		d.FieldBool("gci_one_slice_per_subpic_constraint_flag")
+		d.FieldBool("gci_no_subpic_info_constraint_flag")
+		// ctu and block partitioning
+		d.FieldU2("gci_three_minus_max_log2_ctu_size_constraint_idc")
+		d.FieldBool("gci_no_partition_constraints_override_constraint_flag")
+		d.FieldBool("gci_no_mtt_constraint_flag")
+		d.FieldBool("gci_no_qtbtt_dual_tree_intra_constraint_flag")
+		// intra
+		d.FieldBool("gci_no_palette_constraint_flag")
+		d.FieldBool("gci_no_ibc_constraint_flag")
+		d.FieldBool("gci_no_isp_constraint_flag")
+		d.FieldBool("gci_no_mrl_constraint_flag")
+		d.FieldBool("gci_no_mip_constraint_flag")
+		d.FieldBool("gci_no_cclm_constraint_flag")
+		// inter
+		d.FieldBool("gci_no_ref_pic_resampling_constraint_flag")
+		d.FieldBool("gci_no_res_change_in_clvs_constraint_flag")
+		d.FieldBool("gci_no_weighted_prediction_constraint_flag")
+		d.FieldBool("gci_no_ref_wraparound_constraint_flag")
+		d.FieldBool("gci_no_temporal_mvp_constraint_flag")
+		d.FieldBool("gci_no_sbtmvp_constraint_flag")
+		d.FieldBool("gci_no_amvr_constraint_flag")
+		d.FieldBool("gci_no_bdof_constraint_flag")
+		d.FieldBool("gci_no_smvd_constraint_flag")
+		d.FieldBool("gci_no_dmvr_constraint_flag")
+		d.FieldBool("gci_no_mmvd_constraint_flag")
+		d.FieldBool("gci_no_affine_motion_constraint_flag")
+		d.FieldBool("gci_no_prof_constraint_flag")
+		d.FieldBool("gci_no_bcw_constraint_flag")
+		d.FieldBool("gci_no_ciip_constraint_flag")
+		d.FieldBool("gci_no_gpm_constraint_flag")
+		// transform, quantization, residual
+		d.FieldBool("gci_no_luma_transform_size_64_constraint_flag")
+		d.FieldBool("gci_no_transform_skip_constraint_flag")
+		d.FieldBool("gci_no_bdpcm_constraint_flag")
+		d.FieldBool("gci_no_mts_constraint_flag")
+		d.FieldBool("gci_no_lfnst_constraint_flag")
+		d.FieldBool("gci_no_joint_cbcr_constraint_flag")
+		d.FieldBool("gci_no_sbt_constraint_flag")
+		d.FieldBool("gci_no_act_constraint_flag")
+		d.FieldBool("gci_no_explicit_scaling_list_constraint_flag")
+		d.FieldBool("gci_no_dep_quant_constraint_flag")
+		d.FieldBool("gci_no_sign_data_hiding_constraint_flag")
+		d.FieldBool("gci_no_cu_qp_delta_constraint_flag")
+		d.FieldBool("gci_no_chroma_qp_offset_constraint_flag")
+		// loop filter
+		d.FieldBool("gci_no_sao_constraint_flag")
+		d.FieldBool("gci_no_alf_constraint_flag")
+		d.FieldBool("gci_no_ccalf_constraint_flag")
+		d.FieldBool("gci_no_lmcs_constraint_flag")
+		d.FieldBool("gci_no_ladf_constraint_flag")
+		d.FieldBool("gci_no_virtual_boundaries_constraint_flag")
+		gciNumAdditionalBits := d.FieldU8("gci_num_additional_bits")
+		numAdditionalBitsUsed := uint64(0)
+		if gciNumAdditionalBits > 5 {
+			d.FieldBool("gci_all_rap_pictures_constraint_flag")
+			d.FieldBool("gci_no_extended_precision_processing_constraint_flag")
+			d.FieldBool("gci_no_ts_residual_coding_rice_constraint_flag")
+			d.FieldBool("gci_no_rrc_rice_extension_constraint_flag")
+			d.FieldBool("gci_no_persistent_rice_adaptation_constraint_flag")
+			d.FieldBool("gci_no_reverse_last_sig_coeff_constraint_flag")
+			numAdditionalBitsUsed = 6
+		}
+		if gciNumAdditionalBits > numAdditionalBitsUsed {
+			d.FieldRawLen("gci_reserved_bits", int64(gciNumAdditionalBits-numAdditionalBitsUsed))
+		}
+	}
+	vvcByteAlignment(d, "gci_alignment_zero_bits")
+}
+
+// H.266 7.3.3.1 General profile, tier, and level syntax
+func vvcProfileTierLevel(d *decode.D, profileTierPresentFlag bool, maxNumSubLayersMinus1 uint64) {
+	if profileTierPresentFlag {
+		d.FieldU7("general_profile_idc", vvcProfileNames)
+		d.FieldBool("general_tier_flag")
+	}
+	d.FieldU8("general_level_idc", vvcLevelDescription)
+	d.FieldBool("ptl_frame_only_constraint_flag")
+	d.FieldBool("ptl_multilayer_enabled_flag")
+	if profileTierPresentFlag {
+		d.FieldStruct("general_constraints_info", vvcGeneralConstraintsInfo)
+	}
+	sublayerLevelPresentFlags := make([]bool, maxNumSubLayersMinus1)
+	d.FieldArray("ptl_sublayer_level_present_flags", func(d *decode.D) {
+		for i := int(maxNumSubLayersMinus1) - 1; i >= 0; i-- {
+			sublayerLevelPresentFlags[i] = d.FieldBool("ptl_sublayer_level_present_flag")
+		}
+	})
+	vvcByteAlignment(d, "ptl_reserved_zero_bits")
+	d.FieldArray("sublayer_level_idcs", func(d *decode.D) {
+		for i := int(maxNumSubLayersMinus1) - 1; i >= 0; i-- {
+			if sublayerLevelPresentFlags[i] {
+				d.FieldU8("sublayer_level_idc", vvcLevelDescription)
+			}
+		}
+	})
+	if profileTierPresentFlag {
+		ptlNumSubProfiles := d.FieldU8("ptl_num_sub_profiles")
+		d.FieldArray("general_sub_profile_idcs", func(d *decode.D) {
+			for i := uint64(0); i < ptlNumSubProfiles; i++ {
+				d.FieldU32("general_sub_profile_idc", scalar.UintHex)
+			}
+		})
+	}
+}
+
+// H.266 7.3.4 DPB parameters syntax
+func vvcDpbParameters(d *decode.D, maxSubLayersMinus1 uint64, subLayerInfoFlag bool) {
+	d.FieldArray("dpb_parameters", func(d *decode.D) {
+		i := maxSubLayersMinus1
+		if subLayerInfoFlag {
+			i = 0
+		}
+		for ; i <= maxSubLayersMinus1; i++ {
+			d.FieldStruct("dpb_parameter", func(d *decode.D) {
+				d.FieldUintFn("dpb_max_dec_pic_buffering_minus1", uEV)
+				d.FieldUintFn("dpb_max_num_reorder_pics", uEV)
+				d.FieldUintFn("dpb_max_latency_increase_plus1", uEV)
+			})
+		}
+	})
+}
+
+type vvcGeneralTimingHrd struct {
+	nalHrdParamsPresentFlag bool
+	vclHrdParamsPresentFlag bool
+	duHrdParamsPresentFlag  bool
+	hrdCpbCntMinus1         uint64
+}
+
+// H.266 7.3.5.1 General timing and HRD parameters syntax
+func vvcGeneralTimingHrdParameters(d *decode.D) vvcGeneralTimingHrd {
+	var hrd vvcGeneralTimingHrd
+	d.FieldStruct("general_timing_hrd_parameters", func(d *decode.D) {
+		d.FieldU32("num_units_in_tick")
+		d.FieldU32("time_scale")
+		hrd.nalHrdParamsPresentFlag = d.FieldBool("general_nal_hrd_params_present_flag")
+		hrd.vclHrdParamsPresentFlag = d.FieldBool("general_vcl_hrd_params_present_flag")
+		if hrd.nalHrdParamsPresentFlag || hrd.vclHrdParamsPresentFlag {
+			d.FieldBool("general_same_pic_timing_in_all_ols_flag")
+			hrd.duHrdParamsPresentFlag = d.FieldBool("general_du_hrd_params_present_flag")
+			if hrd.duHrdParamsPresentFlag {
+				d.FieldU8("tick_divisor_minus2")
+			}
+			d.FieldU4("bit_rate_scale")
+			d.FieldU4("cpb_size_scale")
+			if hrd.duHrdParamsPresentFlag {
+				d.FieldU4("cpb_size_du_scale")
+			}
+			hrd.hrdCpbCntMinus1 = d.FieldUintFn("hrd_cpb_cnt_minus1", uEV)
+		}
+	})
+	return hrd
+}
+
+// H.266 7.3.5.3 Sublayer HRD parameters syntax
+func vvcSublayerHrdParameters(d *decode.D, name string, hrd vvcGeneralTimingHrd) {
+	d.FieldArray(name, func(d *decode.D) {
+		for j := uint64(0); j <= hrd.hrdCpbCntMinus1; j++ {
+			d.FieldStruct("cpb", func(d *decode.D) {
+				d.FieldUintFn("bit_rate_value_minus1", uEV)
+				d.FieldUintFn("cpb_size_value_minus1", uEV)
+				if hrd.duHrdParamsPresentFlag {
+					d.FieldUintFn("cpb_size_du_value_minus1", uEV)
+					d.FieldUintFn("bit_rate_du_value_minus1", uEV)
+				}
+				d.FieldBool("cbr_flag")
+			})
+		}
+	})
+}
+
+// H.266 7.3.5.2 OLS timing and HRD parameters syntax
+func vvcOlsTimingHrdParameters(d *decode.D, hrd vvcGeneralTimingHrd, firstSubLayer uint64, maxSubLayersVal uint64) {
+	d.FieldArray("ols_timing_hrd_parameters", func(d *decode.D) {
+		for i := firstSubLayer; i <= maxSubLayersVal; i++ {
+			d.FieldStruct("sublayer", func(d *decode.D) {
+				fixedPicRateGeneralFlag := d.FieldBool("fixed_pic_rate_general_flag")
+				// inferred to be 1 when fixed_pic_rate_general_flag is 1
+				fixedPicRateWithinCvsFlag := true
+				if !fixedPicRateGeneralFlag {
+					fixedPicRateWithinCvsFlag = d.FieldBool("fixed_pic_rate_within_cvs_flag")
+				}
+				if fixedPicRateWithinCvsFlag {
+					d.FieldUintFn("elemental_duration_in_tc_minus1", uEV)
+				} else if (hrd.nalHrdParamsPresentFlag || hrd.vclHrdParamsPresentFlag) && hrd.hrdCpbCntMinus1 == 0 {
+					d.FieldBool("low_delay_hrd_flag")
+				}
+				if hrd.nalHrdParamsPresentFlag {
+					vvcSublayerHrdParameters(d, "nal_sublayer_hrd_parameters", hrd)
+				}
+				if hrd.vclHrdParamsPresentFlag {
+					vvcSublayerHrdParameters(d, "vcl_sublayer_hrd_parameters", hrd)
+				}
+			})
+		}
+	})
+}
+
+type vvcRefPicListSPS struct {
+	longTermRefPicsFlag         bool
+	interLayerPredictionEnabled bool
+	weightedPredOrBipred        bool
+	log2MaxPicOrderCntLsb       int
+	numRefPicLists              uint64
+}
+
+// H.266 7.3.10 Reference picture list structure syntax
+func vvcRefPicListStruct(d *decode.D, sps vvcRefPicListSPS, rplsIdx uint64) {
+	numRefEntries := d.FieldUintFn("num_ref_entries", uEV)
+	var ltrpInHeaderFlag bool
+	if sps.longTermRefPicsFlag && rplsIdx < sps.numRefPicLists && numRefEntries > 0 {
+		ltrpInHeaderFlag = d.FieldBool("ltrp_in_header_flag")
+	}
+	d.FieldArray("entries", func(d *decode.D) {
+		for i := uint64(0); i < numRefEntries; i++ {
+			d.FieldStruct("entry", func(d *decode.D) {
+				var interLayerRefPicFlag bool
+				if sps.interLayerPredictionEnabled {
+					interLayerRefPicFlag = d.FieldBool("inter_layer_ref_pic_flag")
+				}
+				if interLayerRefPicFlag {
+					d.FieldUintFn("ilrp_idx", uEV)
+					return
+				}
+				stRefPicFlag := true
+				if sps.longTermRefPicsFlag {
+					stRefPicFlag = d.FieldBool("st_ref_pic_flag")
+				}
+				if stRefPicFlag {
+					absDeltaPocSt := d.FieldUintFn("abs_delta_poc_st", uEV)
+					if !sps.weightedPredOrBipred || i == 0 {
+						absDeltaPocSt++
+					}
+					if absDeltaPocSt > 0 {
+						d.FieldBool("strp_entry_sign_flag")
+					}
+				} else if !ltrpInHeaderFlag {
+					d.FieldU("rpls_poc_lsb_lt", sps.log2MaxPicOrderCntLsb)
+				}
+			})
+		}
+	})
+}
+
+// H.266 7.3.2.4 Sequence parameter set RBSP syntax
+func vvcSPSDecode(d *decode.D) any {
+	d.FieldU4("sps_seq_parameter_set_id")
+	spsVideoParameterSetID := d.FieldU4("sps_video_parameter_set_id")
+	spsMaxSublayersMinus1 := d.FieldU3("sps_max_sublayers_minus1")
+	spsChromaFormatIdc := d.FieldU2("sps_chroma_format_idc", chromaFormatMap)
+	spsLog2CtuSizeMinus5 := d.FieldU2("sps_log2_ctu_size_minus5")
+	ctbSizeY := uint64(1) << (spsLog2CtuSizeMinus5 + 5)
+	spsPtlDpbHrdParamsPresentFlag := d.FieldBool("sps_ptl_dpb_hrd_params_present_flag")
+	if spsPtlDpbHrdParamsPresentFlag {
+		d.FieldStruct("profile_tier_level", func(d *decode.D) { vvcProfileTierLevel(d, true, spsMaxSublayersMinus1) })
+	}
+	d.FieldBool("sps_gdr_enabled_flag")
+	spsRefPicResamplingEnabledFlag := d.FieldBool("sps_ref_pic_resampling_enabled_flag")
+	if spsRefPicResamplingEnabledFlag {
+		d.FieldBool("sps_res_change_in_clvs_allowed_flag")
+	}
+	spsPicWidthMaxInLumaSamples := d.FieldUintFn("sps_pic_width_max_in_luma_samples", uEV)
+	spsPicHeightMaxInLumaSamples := d.FieldUintFn("sps_pic_height_max_in_luma_samples", uEV)
+	spsConformanceWindowFlag := d.FieldBool("sps_conformance_window_flag")
+	if spsConformanceWindowFlag {
+		d.FieldUintFn("sps_conf_win_left_offset", uEV)
+		d.FieldUintFn("sps_conf_win_right_offset", uEV)
+		d.FieldUintFn("sps_conf_win_top_offset", uEV)
+		d.FieldUintFn("sps_conf_win_bottom_offset", uEV)
+	}
+	spsSubpicInfoPresentFlag := d.FieldBool("sps_subpic_info_present_flag")
+	if spsSubpicInfoPresentFlag {
+		spsNumSubpicsMinus1 := d.FieldUintFn("sps_num_subpics_minus1", uEV)
+		spsIndependentSubpicsFlag := true
+		spsSubpicSameSizeFlag := false
+		if spsNumSubpicsMinus1 > 0 {
+			spsIndependentSubpicsFlag = d.FieldBool("sps_independent_subpics_flag")
+			spsSubpicSameSizeFlag = d.FieldBool("sps_subpic_same_size_flag")
+		}
+		tmpWidthValBits := vvcCeilLog2((spsPicWidthMaxInLumaSamples + ctbSizeY - 1) / ctbSizeY)
+		tmpHeightValBits := vvcCeilLog2((spsPicHeightMaxInLumaSamples + ctbSizeY - 1) / ctbSizeY)
+		d.FieldArray("subpics", func(d *decode.D) {
+			for i := uint64(0); spsNumSubpicsMinus1 > 0 && i <= spsNumSubpicsMinus1; i++ {
+				d.FieldStruct("subpic", func(d *decode.D) {
+					if !spsSubpicSameSizeFlag || i == 0 {
+						if i > 0 && spsPicWidthMaxInLumaSamples > ctbSizeY {
+							d.FieldU("sps_subpic_ctu_top_left_x", tmpWidthValBits)
+						}
+						if i > 0 && spsPicHeightMaxInLumaSamples > ctbSizeY {
+							d.FieldU("sps_subpic_ctu_top_left_y", tmpHeightValBits)
+						}
+						if i < spsNumSubpicsMinus1 && spsPicWidthMaxInLumaSamples > ctbSizeY {
+							d.FieldU("sps_subpic_width_minus1", tmpWidthValBits)
+						}
+						if i < spsNumSubpicsMinus1 && spsPicHeightMaxInLumaSamples > ctbSizeY {
+							d.FieldU("sps_subpic_height_minus1", tmpHeightValBits)
+						}
+					}
+					if !spsIndependentSubpicsFlag {
+						d.FieldBool("sps_subpic_treated_as_pic_flag")
+						d.FieldBool("sps_loop_filter_across_subpic_enabled_flag")
+					}
+				})
+			}
+		})
+		spsSubpicIDLenMinus1 := d.FieldUintFn("sps_subpic_id_len_minus1", uEV)
+		spsSubpicIDMappingExplicitlySignalledFlag := d.FieldBool("sps_subpic_id_mapping_explicitly_signalled_flag")
+		if spsSubpicIDMappingExplicitlySignalledFlag {
+			spsSubpicIDMappingPresentFlag := d.FieldBool("sps_subpic_id_mapping_present_flag")
+			if spsSubpicIDMappingPresentFlag {
+				d.FieldArray("sps_subpic_ids", func(d *decode.D) {
+					for i := uint64(0); i <= spsNumSubpicsMinus1; i++ {
+						d.FieldU("sps_subpic_id", int(spsSubpicIDLenMinus1)+1)
+					}
+				})
+			}
+		}
+	}
+	d.FieldUintFn("sps_bitdepth_minus8", uEV)
+	d.FieldBool("sps_entropy_coding_sync_enabled_flag")
+	d.FieldBool("sps_entry_point_offsets_present_flag")
+	spsLog2MaxPicOrderCntLsbMinus4 := d.FieldU4("sps_log2_max_pic_order_cnt_lsb_minus4")
+	spsPocMsbCycleFlag := d.FieldBool("sps_poc_msb_cycle_flag")
+	if spsPocMsbCycleFlag {
+		d.FieldUintFn("sps_poc_msb_cycle_len_minus1", uEV)
+	}
+	spsNumExtraPhBytes := d.FieldU2("sps_num_extra_ph_bytes")
+	if spsNumExtraPhBytes > 0 {
+		d.FieldU("sps_extra_ph_bit_present_flags", int(spsNumExtraPhBytes)*8, scalar.UintBin)
+	}
+	spsNumExtraShBytes := d.FieldU2("sps_num_extra_sh_bytes")
+	if spsNumExtraShBytes > 0 {
+		d.FieldU("sps_extra_sh_bit_present_flags", int(spsNumExtraShBytes)*8, scalar.UintBin)
+	}
+	if spsPtlDpbHrdParamsPresentFlag {
+		var spsSublayerDpbParamsFlag bool
+		if spsMaxSublayersMinus1 > 0 {
+			spsSublayerDpbParamsFlag = d.FieldBool("sps_sublayer_dpb_params_flag")
+		}
+		vvcDpbParameters(d, spsMaxSublayersMinus1, spsSublayerDpbParamsFlag)
+	}
+	d.FieldUintFn("sps_log2_min_luma_coding_block_size_minus2", uEV)
+	d.FieldBool("sps_partition_constraints_override_enabled_flag")
+	d.FieldUintFn("sps_log2_diff_min_qt_min_cb_intra_slice_luma", uEV)
+	spsMaxMttHierarchyDepthIntraSliceLuma := d.FieldUintFn("sps_max_mtt_hierarchy_depth_intra_slice_luma", uEV)
+	if spsMaxMttHierarchyDepthIntraSliceLuma != 0 {
+		d.FieldUintFn("sps_log2_diff_max_bt_min_qt_intra_slice_luma", uEV)
+		d.FieldUintFn("sps_log2_diff_max_tt_min_qt_intra_slice_luma", uEV)
+	}
+	var spsQtbttDualTreeIntraFlag bool
+	if spsChromaFormatIdc != 0 {
+		spsQtbttDualTreeIntraFlag = d.FieldBool("sps_qtbtt_dual_tree_intra_flag")
+	}
+	if spsQtbttDualTreeIntraFlag {
+		d.FieldUintFn("sps_log2_diff_min_qt_min_cb_intra_slice_chroma", uEV)
+		spsMaxMttHierarchyDepthIntraSliceChroma := d.FieldUintFn("sps_max_mtt_hierarchy_depth_intra_slice_chroma", uEV)
+		if spsMaxMttHierarchyDepthIntraSliceChroma != 0 {
+			d.FieldUintFn("sps_log2_diff_max_bt_min_qt_intra_slice_chroma", uEV)
+			d.FieldUintFn("sps_log2_diff_max_tt_min_qt_intra_slice_chroma", uEV)
+		}
+	}
+	d.FieldUintFn("sps_log2_diff_min_qt_min_cb_inter_slice", uEV)
+	spsMaxMttHierarchyDepthInterSlice := d.FieldUintFn("sps_max_mtt_hierarchy_depth_inter_slice", uEV)
+	if spsMaxMttHierarchyDepthInterSlice != 0 {
+		d.FieldUintFn("sps_log2_diff_max_bt_min_qt_inter_slice", uEV)
+		d.FieldUintFn("sps_log2_diff_max_tt_min_qt_inter_slice", uEV)
+	}
+	var spsMaxLumaTransformSize64Flag bool
+	if ctbSizeY > 32 {
+		spsMaxLumaTransformSize64Flag = d.FieldBool("sps_max_luma_transform_size_64_flag")
+	}
+	spsTransformSkipEnabledFlag := d.FieldBool("sps_transform_skip_enabled_flag")
+	if spsTransformSkipEnabledFlag {
+		d.FieldUintFn("sps_log2_transform_skip_max_size_minus2", uEV)
+		d.FieldBool("sps_bdpcm_enabled_flag")
+	}
+	spsMtsEnabledFlag := d.FieldBool("sps_mts_enabled_flag")
+	if spsMtsEnabledFlag {
+		d.FieldBool("sps_explicit_mts_intra_enabled_flag")
+		d.FieldBool("sps_explicit_mts_inter_enabled_flag")
+	}
+	spsLfnstEnabledFlag := d.FieldBool("sps_lfnst_enabled_flag")
+	if spsChromaFormatIdc != 0 {
+		spsJointCbcrEnabledFlag := d.FieldBool("sps_joint_cbcr_enabled_flag")
+		spsSameQpTableForChromaFlag := d.FieldBool("sps_same_qp_table_for_chroma_flag")
+		numQpTables := 2
+		if spsSameQpTableForChromaFlag {
+			numQpTables = 1
+		} else if spsJointCbcrEnabledFlag {
+			numQpTables = 3
+		}
+		d.FieldArray("qp_tables", func(d *decode.D) {
+			for i := 0; i < numQpTables; i++ {
+				d.FieldStruct("qp_table", func(d *decode.D) {
+					d.FieldSintFn("sps_qp_table_start_minus26", sEV)
+					spsNumPointsInQpTableMinus1 := d.FieldUintFn("sps_num_points_in_qp_table_minus1", uEV)
+					d.FieldArray("points", func(d *decode.D) {
+						for j := uint64(0); j <= spsNumPointsInQpTableMinus1; j++ {
+							d.FieldStruct("point", func(d *decode.D) {
+								d.FieldUintFn("sps_delta_qp_in_val_minus1", uEV)
+								d.FieldUintFn("sps_delta_qp_diff_val", uEV)
+							})
+						}
+					})
+				})
+			}
+		})
+	}
+	d.FieldBool("sps_sao_enabled_flag")
+	spsAlfEnabledFlag := d.FieldBool("sps_alf_enabled_flag")
+	if spsAlfEnabledFlag && spsChromaFormatIdc != 0 {
+		d.FieldBool("sps_ccalf_enabled_flag")
+	}
+	d.FieldBool("sps_lmcs_enabled_flag")
+	spsWeightedPredFlag := d.FieldBool("sps_weighted_pred_flag")
+	spsWeightedBipredFlag := d.FieldBool("sps_weighted_bipred_flag")
+	spsLongTermRefPicsFlag := d.FieldBool("sps_long_term_ref_pics_flag")
+	var spsInterLayerPredictionEnabledFlag bool
+	if spsVideoParameterSetID > 0 {
+		spsInterLayerPredictionEnabledFlag = d.FieldBool("sps_inter_layer_prediction_enabled_flag")
+	}
+	d.FieldBool("sps_idr_rpl_present_flag")
+	spsRpl1SameAsRpl0Flag := d.FieldBool("sps_rpl1_same_as_rpl0_flag")
+	numRefPicListIdxs := 2
+	if spsRpl1SameAsRpl0Flag {
+		numRefPicListIdxs = 1
+	}
+	d.FieldArray("ref_pic_lists", func(d *decode.D) {
+		for i := 0; i < numRefPicListIdxs; i++ {
+			d.FieldStruct("ref_pic_list", func(d *decode.D) {
+				spsNumRefPicLists := d.FieldUintFn("sps_num_ref_pic_lists", uEV)
+				rplSPS := vvcRefPicListSPS{
+					longTermRefPicsFlag:         spsLongTermRefPicsFlag,
+					interLayerPredictionEnabled: spsInterLayerPredictionEnabledFlag,
+					weightedPredOrBipred:        spsWeightedPredFlag || spsWeightedBipredFlag,
+					log2MaxPicOrderCntLsb:       int(spsLog2MaxPicOrderCntLsbMinus4) + 4,
+					numRefPicLists:              spsNumRefPicLists,
+				}
+				d.FieldArray("ref_pic_list_structs", func(d *decode.D) {
+					for j := uint64(0); j < spsNumRefPicLists; j++ {
+						d.FieldStruct("ref_pic_list_struct", func(d *decode.D) { vvcRefPicListStruct(d, rplSPS, j) })
+					}
+				})
+			})
+		}
+	})
+	d.FieldBool("sps_ref_wraparound_enabled_flag")
+	spsTemporalMvpEnabledFlag := d.FieldBool("sps_temporal_mvp_enabled_flag")
+	if spsTemporalMvpEnabledFlag {
+		d.FieldBool("sps_sbtmvp_enabled_flag")
+	}
+	spsAmvrEnabledFlag := d.FieldBool("sps_amvr_enabled_flag")
+	spsBdofEnabledFlag := d.FieldBool("sps_bdof_enabled_flag")
+	if spsBdofEnabledFlag {
+		d.FieldBool("sps_bdof_control_present_in_ph_flag")
+	}
+	d.FieldBool("sps_smvd_enabled_flag")
+	spsDmvrEnabledFlag := d.FieldBool("sps_dmvr_enabled_flag")
+	if spsDmvrEnabledFlag {
+		d.FieldBool("sps_dmvr_control_present_in_ph_flag")
+	}
+	spsMmvdEnabledFlag := d.FieldBool("sps_mmvd_enabled_flag")
+	if spsMmvdEnabledFlag {
+		d.FieldBool("sps_mmvd_fullpel_only_enabled_flag")
+	}
+	spsSixMinusMaxNumMergeCand := d.FieldUintFn("sps_six_minus_max_num_merge_cand", uEV)
+	d.FieldBool("sps_sbt_enabled_flag")
+	spsAffineEnabledFlag := d.FieldBool("sps_affine_enabled_flag")
+	if spsAffineEnabledFlag {
+		d.FieldUintFn("sps_five_minus_max_num_subblock_merge_cand", uEV)
+		d.FieldBool("sps_6param_affine_enabled_flag")
+		if spsAmvrEnabledFlag {
+			d.FieldBool("sps_affine_amvr_enabled_flag")
+		}
+		spsAffineProfEnabledFlag := d.FieldBool("sps_affine_prof_enabled_flag")
+		if spsAffineProfEnabledFlag {
+			d.FieldBool("sps_prof_control_present_in_ph_flag")
+		}
+	}
+	d.FieldBool("sps_bcw_enabled_flag")
+	d.FieldBool("sps_ciip_enabled_flag")
+	// MaxNumMergeCand = 6 - sps_six_minus_max_num_merge_cand
+	if spsSixMinusMaxNumMergeCand <= 4 {
+		spsGpmEnabledFlag := d.FieldBool("sps_gpm_enabled_flag")
+		if spsGpmEnabledFlag && spsSixMinusMaxNumMergeCand <= 3 {
+			d.FieldUintFn("sps_max_num_merge_cand_minus_max_num_gpm_cand", uEV)
+		}
+	}
+	d.FieldUintFn("sps_log2_parallel_merge_level_minus2", uEV)
+	d.FieldBool("sps_isp_enabled_flag")
+	d.FieldBool("sps_mrl_enabled_flag")
+	d.FieldBool("sps_mip_enabled_flag")
+	if spsChromaFormatIdc != 0 {
+		d.FieldBool("sps_cclm_enabled_flag")
+	}
+	if spsChromaFormatIdc == 1 {
+		d.FieldBool("sps_chroma_horizontal_collocated_flag")
+		d.FieldBool("sps_chroma_vertical_collocated_flag")
+	}
+	spsPaletteEnabledFlag := d.FieldBool("sps_palette_enabled_flag")
+	var spsActEnabledFlag bool
+	if spsChromaFormatIdc == 3 && !spsMaxLumaTransformSize64Flag {
+		spsActEnabledFlag = d.FieldBool("sps_act_enabled_flag")
+	}
+	if spsTransformSkipEnabledFlag || spsPaletteEnabledFlag {
+		d.FieldUintFn("sps_min_qp_prime_ts", uEV)
+	}
+	spsIbcEnabledFlag := d.FieldBool("sps_ibc_enabled_flag")
+	if spsIbcEnabledFlag {
+		d.FieldUintFn("sps_six_minus_max_num_ibc_merge_cand", uEV)
+	}
+	spsLadfEnabledFlag := d.FieldBool("sps_ladf_enabled_flag")
+	if spsLadfEnabledFlag {
+		spsNumLadfIntervalsMinus2 := d.FieldU2("sps_num_ladf_intervals_minus2")
+		d.FieldSintFn("sps_ladf_lowest_interval_qp_offset", sEV)
+		d.FieldArray("ladf_intervals", func(d *decode.D) {
+			for i := uint64(0); i < spsNumLadfIntervalsMinus2+1; i++ {
+				d.FieldStruct("ladf_interval", func(d *decode.D) {
+					d.FieldSintFn("sps_ladf_qp_offset", sEV)
+					d.FieldUintFn("sps_ladf_delta_threshold_minus1", uEV)
+				})
+			}
+		})
+	}
+	spsExplicitScalingListEnabledFlag := d.FieldBool("sps_explicit_scaling_list_enabled_flag")
+	if spsLfnstEnabledFlag && spsExplicitScalingListEnabledFlag {
+		d.FieldBool("sps_scaling_matrix_for_lfnst_disabled_flag")
+	}
+	if spsActEnabledFlag && spsExplicitScalingListEnabledFlag {
+		spsScalingMatrixForAlternativeColourSpaceDisabledFlag := d.FieldBool("sps_scaling_matrix_for_alternative_colour_space_disabled_flag")
+		if spsScalingMatrixForAlternativeColourSpaceDisabledFlag {
+			d.FieldBool("sps_scaling_matrix_designated_colour_space_flag")
+		}
+	}
+	d.FieldBool("sps_dep_quant_enabled_flag")
+	d.FieldBool("sps_sign_data_hiding_enabled_flag")
+	spsVirtualBoundariesEnabledFlag := d.FieldBool("sps_virtual_boundaries_enabled_flag")
+	if spsVirtualBoundariesEnabledFlag {
+		spsVirtualBoundariesPresentFlag := d.FieldBool("sps_virtual_boundaries_present_flag")
+		if spsVirtualBoundariesPresentFlag {
+			spsNumVerVirtualBoundaries := d.FieldUintFn("sps_num_ver_virtual_boundaries", uEV)
+			d.FieldArray("sps_virtual_boundary_pos_xs", func(d *decode.D) {
+				for i := uint64(0); i < spsNumVerVirtualBoundaries; i++ {
+					d.FieldUintFn("sps_virtual_boundary_pos_x_minus1", uEV)
+				}
+			})
+			spsNumHorVirtualBoundaries := d.FieldUintFn("sps_num_hor_virtual_boundaries", uEV)
+			d.FieldArray("sps_virtual_boundary_pos_ys", func(d *decode.D) {
+				for i := uint64(0); i < spsNumHorVirtualBoundaries; i++ {
+					d.FieldUintFn("sps_virtual_boundary_pos_y_minus1", uEV)
+				}
+			})
+		}
+	}
+	if spsPtlDpbHrdParamsPresentFlag {
+		spsTimingHrdParamsPresentFlag := d.FieldBool("sps_timing_hrd_params_present_flag")
+		if spsTimingHrdParamsPresentFlag {
+			hrd := vvcGeneralTimingHrdParameters(d)
+			var spsSublayerCpbParamsPresentFlag bool
+			if spsMaxSublayersMinus1 > 0 {
+				spsSublayerCpbParamsPresentFlag = d.FieldBool("sps_sublayer_cpb_params_present_flag")
+			}
+			firstSubLayer := spsMaxSublayersMinus1
+			if spsSublayerCpbParamsPresentFlag {
+				firstSubLayer = 0
+			}
+			vvcOlsTimingHrdParameters(d, hrd, firstSubLayer, spsMaxSublayersMinus1)
+		}
+	}
+	d.FieldBool("sps_field_seq_flag")
+	spsVuiParametersPresentFlag := d.FieldBool("sps_vui_parameters_present_flag")
+	if spsVuiParametersPresentFlag {
+		spsVuiPayloadSizeMinus1 := d.FieldUintFn("sps_vui_payload_size_minus1", uEV)
+		vvcByteAlignment(d, "sps_vui_alignment_zero_bits")
+		d.FramedFn(int64(spsVuiPayloadSizeMinus1+1)*8, func(d *decode.D) {
+			d.FieldStruct("vui_payload", vvcVuiPayload)
+		})
+	}
+	spsExtensionFlag := d.FieldBool("sps_extension_flag")
+	var spsRangeExtensionFlag bool
+	var spsExtension7Bits uint64
+	if spsExtensionFlag {
+		spsRangeExtensionFlag = d.FieldBool("sps_range_extension_flag")
+		spsExtension7Bits = d.FieldU7("sps_extension_7bits")
+	}
+	// H.266 7.3.2.23 Sequence parameter set range extension syntax
+	if spsRangeExtensionFlag {
+		d.FieldStruct("sps_range_extension", func(d *decode.D) {
+			d.FieldBool("sps_extended_precision_flag")
+			if spsTransformSkipEnabledFlag {
+				d.FieldBool("sps_ts_residual_coding_rice_present_in_sh_flag")
+			}
+			d.FieldBool("sps_rrc_rice_extension_flag")
+			d.FieldBool("sps_persistent_rice_adaptation_enabled_flag")
+			d.FieldBool("sps_reverse_last_sig_coeff_enabled_flag")
+		})
+	}
+	if spsExtension7Bits != 0 {
+		d.FieldRawLen("sps_extension_data", d.BitsLeft())
+	}
+
+	return nil
+}
@@ -0,0 +1,242 @@
+package mpeg
+
+// https://www.itu.int/rec/T-REC-H.266
+
+import (
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+)
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:        format.VVC_VPS,
+		Description: "H.266/VVC Video Parameter Set",
+		DecodeFn:    vvcVPSDecode,
+	})
+}
+
+const (
+	vvcOLSModeIdcExplicit = 2
+)
+
+// H.266 7.4.3.3 number of output layer sets with more than one layer, ols 0 has
+// only the base layer and mode 0 and 1 include all layers up to the ols index
+func vvcNumMultiLayerOlss(totalNumOlss uint64, olsModeIdc uint64, olsOutputLayers [][]bool, directRefLayers [][]bool) uint64 {
+	var n uint64
+	for i := uint64(1); i < totalNumOlss; i++ {
+		if olsModeIdc != vvcOLSModeIdcExplicit {
+			n++
+			continue
+		}
+		// output layers and all layers they directly or indirectly reference
+		included := make([]bool, len(directRefLayers))
+		var include func(j int)
+		include = func(j int) {
+			if included[j] {
+				return
+			}
+			included[j] = true
+			for k, ref := range directRefLayers[j] {
+				if ref {
+					include(k)
+				}
+			}
+		}
+		for j, output := range olsOutputLayers[i] {
+			if output {
+				include(j)
+			}
+		}
+		numLayers := 0
+		for _, inc := range included {
+			if inc {
+				numLayers++
+			}
+		}
+		if numLayers > 1 {
+			n++
+		}
+	}
+	return n
+}
+
+// H.266 7.3.2.3 Video parameter set RBSP syntax
+func vvcVPSDecode(d *decode.D) any {
+	d.FieldU4("vps_video_parameter_set_id")
+	vpsMaxLayersMinus1 := d.FieldU6("vps_max_layers_minus1")
+	vpsMaxSublayersMinus1 := d.FieldU3("vps_max_sublayers_minus1")
+	vpsDefaultPtlDpbHrdMaxTidFlag := true
+	if vpsMaxLayersMinus1 > 0 && vpsMaxSublayersMinus1 > 0 {
+		vpsDefaultPtlDpbHrdMaxTidFlag = d.FieldBool("vps_default_ptl_dpb_hrd_max_tid_flag")
+	}
+	vpsAllIndependentLayersFlag := true
+	if vpsMaxLayersMinus1 > 0 {
+		vpsAllIndependentLayersFlag = d.FieldBool("vps_all_independent_layers_flag")
+	}
+	// direct reference layers per layer used to derive layers in output layer sets
+	directRefLayers := make([][]bool, vpsMaxLayersMinus1+1)
+	d.FieldArray("layers", func(d *decode.D) {
+		for i := uint64(0); i <= vpsMaxLayersMinus1; i++ {
+			directRefLayers[i] = make([]bool, i)
+			d.FieldStruct("layer", func(d *decode.D) {
+				d.FieldU6("vps_layer_id")
+				if i == 0 || vpsAllIndependentLayersFlag {
+					return
+				}
+				vpsIndependentLayerFlag := d.FieldBool("vps_independent_layer_flag")
+				if vpsIndependentLayerFlag {
+					return
+				}
+				vpsMaxTidRefPresentFlag := d.FieldBool("vps_max_tid_ref_present_flag")
+				d.FieldArray("direct_ref_layers", func(d *decode.D) {
+					for j := uint64(0); j < i; j++ {
+						d.FieldStruct("direct_ref_layer", func(d *decode.D) {
+							vpsDirectRefLayerFlag := d.FieldBool("vps_direct_ref_layer_flag")
+							directRefLayers[i][j] = vpsDirectRefLayerFlag
+							if vpsMaxTidRefPresentFlag && vpsDirectRefLayerFlag {
+								d.FieldU3("vps_max_tid_il_ref_pics_plus1")
+							}
+						})
+					}
+				})
+			})
+		}
+	})
+
+	// single layer is one output layer set
+	totalNumOlss := vpsMaxLayersMinus1 + 1
+	vpsEachLayerIsAnOlsFlag := vpsMaxLayersMinus1 == 0
+	vpsNumPtlsMinus1 := uint64(0)
+	var vpsOlsModeIdc uint64
+	var olsOutputLayers [][]bool
+	if vpsMaxLayersMinus1 > 0 {
+		if vpsAllIndependentLayersFlag {
+			vpsEachLayerIsAnOlsFlag = d.FieldBool("vps_each_layer_is_an_ols_flag")
+		}
+		if !vpsEachLayerIsAnOlsFlag {
+			vpsOlsModeIdc = vvcOLSModeIdcExplicit
+			if !vpsAllIndependentLayersFlag {
+				vpsOlsModeIdc = d.FieldU2("vps_ols_mode_idc")
+			}
+			if vpsOlsModeIdc == vvcOLSModeIdcExplicit {
+				vpsNumOutputLayerSetsMinus2 := d.FieldU8("vps_num_output_layer_sets_minus2")
+				totalNumOlss = vpsNumOutputLayerSetsMinus2 + 2
+				olsOutputLayers = make([][]bool, totalNumOlss)
+				d.FieldArray("output_layer_sets", func(d *decode.D) {
+					for i := uint64(1); i <= vpsNumOutputLayerSetsMinus2+1; i++ {
+						olsOutputLayers[i] = make([]bool, vpsMaxLayersMinus1+1)
+						d.FieldArray("vps_ols_output_layer_flags", func(d *decode.D) {
+							for j := uint64(0); j <= vpsMaxLayersMinus1; j++ {
+								olsOutputLayers[i][j] = d.FieldBool("vps_ols_output_layer_flag")
+							}
+						})
+					}
+				})
+			}
+		}
+		vpsNumPtlsMinus1 = d.FieldU8("vps_num_ptls_minus1")
+	}
+
+	vpsPtPresentFlags := make([]bool, vpsNumPtlsMinus1+1)
+	vpsPtlMaxTids := make([]uint64, vpsNumPtlsMinus1+1)
+	d.FieldArray("ptls", func(d *decode.D) {
+		for i := uint64(0); i <= vpsNumPtlsMinus1; i++ {
+			d.FieldStruct("ptl", func(d *decode.D) {
+				vpsPtPresentFlags[i] = true
+				if i > 0 {
+					vpsPtPresentFlags[i] = d.FieldBool("vps_pt_present_flag")
+				}
+				vpsPtlMaxTids[i] = vpsMaxSublayersMinus1
+				if !vpsDefaultPtlDpbHrdMaxTidFlag {
+					vpsPtlMaxTids[i] = d.FieldU3("vps_ptl_max_tid")
+				}
+			})
+		}
+	})
+	vvcByteAlignment(d, "vps_ptl_alignment_zero_bits")
+	d.FieldArray("profile_tier_levels", func(d *decode.D) {
+		for i := uint64(0); i <= vpsNumPtlsMinus1; i++ {
+			d.FieldStruct("profile_tier_level", func(d *decode.D) { vvcProfileTierLevel(d, vpsPtPresentFlags[i], vpsPtlMaxTids[i]) })
+		}
+	})
+	if vpsNumPtlsMinus1 > 0 && vpsNumPtlsMinus1+1 != totalNumOlss {
+		d.FieldArray("vps_ols_ptl_idxs", func(d *decode.D) {
+			for i := uint64(0); i < totalNumOlss; i++ {
+				d.FieldU8("vps_ols_ptl_idx")
+			}
+		})
+	}
+	if !vpsEachLayerIsAnOlsFlag {
+		numMultiLayerOlss := vvcNumMultiLayerOlss(totalNumOlss, vpsOlsModeIdc, olsOutputLayers, directRefLayers)
+		d.FieldValueUint("num_multi_layer_olss", numMultiLayerOlss)
+
+		vpsNumDpbParamsMinus1 := d.FieldUintFn("vps_num_dpb_params_minus1", uEV)
+		var vpsSublayerDpbParamsPresentFlag bool
+		if vpsMaxSublayersMinus1 > 0 {
+			vpsSublayerDpbParamsPresentFlag = d.FieldBool("vps_sublayer_dpb_params_present_flag")
+		}
+		d.FieldArray("dpbs", func(d *decode.D) {
+			for i := uint64(0); i <= vpsNumDpbParamsMinus1; i++ {
+				d.FieldStruct("dpb", func(d *decode.D) {
+					vpsDpbMaxTid := vpsMaxSublayersMinus1
+					if !vpsDefaultPtlDpbHrdMaxTidFlag {
+						vpsDpbMaxTid = d.FieldU3("vps_dpb_max_tid")
+					}
+					vvcDpbParameters(d, vpsDpbMaxTid, vpsSublayerDpbParamsPresentFlag)
+				})
+			}
+		})
+		d.FieldArray("ols_dpbs", func(d *decode.D) {
+			for i := uint64(0); i < numMultiLayerOlss; i++ {
+				d.FieldStruct("ols_dpb", func(d *decode.D) {
+					d.FieldUintFn("vps_ols_dpb_pic_width", uEV)
+					d.FieldUintFn("vps_ols_dpb_pic_height", uEV)
+					d.FieldU2("vps_ols_dpb_chroma_format", chromaFormatMap)
+					d.FieldUintFn("vps_ols_dpb_bitdepth_minus8", uEV)
+					if vpsNumDpbParamsMinus1 > 0 && vpsNumDpbParamsMinus1+1 != numMultiLayerOlss {
+						d.FieldUintFn("vps_ols_dpb_params_idx", uEV)
+					}
+				})
+			}
+		})
+
+		vpsTimingHrdParamsPresentFlag := d.FieldBool("vps_timing_hrd_params_present_flag")
+		if vpsTimingHrdParamsPresentFlag {
+			hrd := vvcGeneralTimingHrdParameters(d)
+			var vpsSublayerCpbParamsPresentFlag bool
+			if vpsMaxSublayersMinus1 > 0 {
+				vpsSublayerCpbParamsPresentFlag = d.FieldBool("vps_sublayer_cpb_params_present_flag")
+			}
+			vpsNumOlsTimingHrdParamsMinus1 := d.FieldUintFn("vps_num_ols_timing_hrd_params_minus1", uEV)
+			d.FieldArray("ols_timing_hrds", func(d *decode.D) {
+				for i := uint64(0); i <= vpsNumOlsTimingHrdParamsMinus1; i++ {
+					d.FieldStruct("ols_timing_hrd", func(d *decode.D) {
+						vpsHrdMaxTid := vpsMaxSublayersMinus1
+						if !vpsDefaultPtlDpbHrdMaxTidFlag {
+							vpsHrdMaxTid = d.FieldU3("vps_hrd_max_tid")
+						}
+						firstSubLayer := vpsHrdMaxTid
+						if vpsSublayerCpbParamsPresentFlag {
+							firstSubLayer = 0
+						}
+						vvcOlsTimingHrdParameters(d, hrd, firstSubLayer, vpsHrdMaxTid)
+					})
+				}
+			})
+			if vpsNumOlsTimingHrdParamsMinus1 > 0 && vpsNumOlsTimingHrdParamsMinus1+1 != numMultiLayerOlss {
+				d.FieldArray("vps_ols_timing_hrd_idxs", func(d *decode.D) {
+					for i := uint64(0); i < numMultiLayerOlss; i++ {
+						d.FieldUintFn("vps_ols_timing_hrd_idx", uEV)
+					}
+				})
+			}
+		}
+	}
+	vpsExtensionFlag := d.FieldBool("vps_extension_flag")
+	if vpsExtensionFlag {
+		d.FieldRawLen("vps_extension_data", d.BitsLeft())
+	}
+
+	return nil
+}
@@ -24,6 +24,7 @@ func init() {
 		Description: "OGG file",
 		Groups:      []string{format.PROBE},
 		DecodeFn:    decodeOgg,
+		StreamFn:    decodeOggStream,
 		Dependencies: []decode.Dependency{
 			{Names: []string{format.OGG_PAGE}, Group: &oggPageFormat},
 			{Names: []string{format.VORBIS_PACKET}, Group: &vorbisPacketFormat},
@@ -177,3 +178,9 @@ func decodeOgg(d *decode.D) any {
 
 	return nil
 }
+
+// one record per page, packets are not reassembled
+func decodeOggStream(d *decode.D, _ any) any {
+	d.FieldFormat("page", oggPageFormat, nil)
+	return nil
+}
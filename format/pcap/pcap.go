@@ -7,9 +7,9 @@ import (
 	"embed"
 
 	"github.com/wader/fq/format"
-	"github.com/wader/fq/format/inet/flowsdecoder"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/ranges"
 	"github.com/wader/fq/pkg/scalar"
 )
 
@@ -19,6 +19,9 @@ var pcapFS embed.FS
 var pcapLinkFrameFormat decode.Group
 var pcapTCPStreamFormat decode.Group
 var pcapIPv4PacketFormat decode.Group
+var pcapIPv6PacketFormat decode.Group
+var pcapUDPFlowFormat decode.Group
+var pcapUDPPayloadFormat decode.Group
 
 // writing application writes 0xa1b2c3d4 in native endian
 const (
@@ -31,6 +34,9 @@ const (
 	littleEndianNS = 0x4d3cb2a1
 )
 
+// ts_sec, ts_usec, incl_len and orig_len
+const packetHeaderLen = 16
+
 var endianMap = scalar.UintMapSymStr{
 	bigEndian:      "big_endian",
 	littleEndian:   "little_endian",
@@ -47,16 +53,24 @@ func init() {
 			{Names: []string{format.LINK_FRAME}, Group: &pcapLinkFrameFormat},
 			{Names: []string{format.TCP_STREAM}, Group: &pcapTCPStreamFormat},
 			{Names: []string{format.IPV4_PACKET}, Group: &pcapIPv4PacketFormat},
+			{Names: []string{format.IPV6_PACKET}, Group: &pcapIPv6PacketFormat},
+			{Names: []string{format.UDP_FLOW}, Group: &pcapUDPFlowFormat},
+			{Names: []string{format.UDP_PAYLOAD}, Group: &pcapUDPPayloadFormat},
 		},
 		DecodeFn: decodePcap,
+		StreamFn: decodePcapStream,
 	})
 	interp.RegisterFS(pcapFS)
 }
 
-func decodePcap(d *decode.D) any {
-	var endian decode.Endian
-	linkType := 0
-	timestampUNSStr := "ts_usec"
+type pcapHeader struct {
+	endian          decode.Endian
+	linkType        int
+	timestampUNSStr string
+}
+
+func decodePcapHeader(d *decode.D) pcapHeader {
+	h := pcapHeader{timestampUNSStr: "ts_usec"}
 
 	d.FieldStruct("header", func(d *decode.D) {
 		magic := d.FieldU32("magic", d.UintAssert(
@@ -68,71 +82,96 @@ func decodePcap(d *decode.D) any {
 
 		switch magic {
 		case bigEndian:
-			endian = decode.BigEndian
+			h.endian = decode.BigEndian
 		case littleEndian:
-			endian = decode.LittleEndian
+			h.endian = decode.LittleEndian
 		case bigEndianNS:
-			endian = decode.BigEndian
-			timestampUNSStr = "ts_nsec"
+			h.endian = decode.BigEndian
+			h.timestampUNSStr = "ts_nsec"
 		case littleEndianNS:
-			endian = decode.LittleEndian
-			timestampUNSStr = "ts_nsec"
+			h.endian = decode.LittleEndian
+			h.timestampUNSStr = "ts_nsec"
 		}
 
-		d.Endian = endian
+		d.Endian = h.endian
 
 		d.FieldU16("version_major")
 		d.FieldU16("version_minor")
 		d.FieldS32("thiszone")
 		d.FieldU32("sigfigs")
 		d.FieldU32("snaplen")
-		linkType = int(d.FieldU32("network", format.LinkTypeMap))
+		h.linkType = int(d.FieldU32("network", format.LinkTypeMap))
 	})
 
-	d.Endian = endian
-	fd := flowsdecoder.New(flowsdecoder.DecoderOptions{CheckTCPOptions: false})
+	return h
+}
+
+// packets is nil if flows should not be reassembled
+func decodePcapPacket(d *decode.D, h pcapHeader, packets *[]flowPacket) {
+	var inclLen uint64
+	d.SeekRel(64, func(d *decode.D) { inclLen = d.U32() })
+
+	if packets != nil {
+		// remember packet here as packet struct might be decoded lazily
+		*packets = append(*packets, flowPacket{
+			linkType: h.linkType,
+			r:        ranges.Range{Start: d.Pos() + packetHeaderLen*8, Len: int64(inclLen) * 8},
+		})
+	}
+
+	d.FieldStructLazyFn("packet", (packetHeaderLen+int64(inclLen))*8, func(d *decode.D) {
+		d.FieldU32("ts_sec")
+		d.FieldU32(h.timestampUNSStr)
+		inclLen := d.FieldU32("incl_len")
+		origLen := d.FieldU32("orig_len")
+
+		// "incl_len: the number of bytes of packet data actually captured and saved in the file. This value should never become larger than orig_len or the snaplen value of the global header"
+		// "orig_len: the length of the packet as it appeared on the network when it was captured. If incl_len and orig_len differ, the actually saved packet size was limited by snaplen."
+
+		// TODO: incl_len seems to be larger than snaplen in real pcap files
+		// if inclLen > snapLen {
+		// 	d.Errorf("incl_len %d > snaplen %d", inclLen, snapLen)
+		// }
+
+		if inclLen > origLen {
+			d.Errorf("incl_len %d > orig_len %d", inclLen, origLen)
+		}
+
+		d.FieldFormatOrRawLen(
+			"packet",
+			int64(inclLen)*8,
+			pcapLinkFrameFormat, format.LinkFrameIn{
+				Type:           h.linkType,
+				IsLittleEndian: d.Endian == decode.LittleEndian,
+			},
+		)
+	})
+}
+
+func decodePcap(d *decode.D) any {
+	h := decodePcapHeader(d)
+
+	d.Endian = h.endian
+	var packets []flowPacket
 
 	d.FieldArray("packets", func(d *decode.D) {
 		for !d.End() {
-			d.FieldStruct("packet", func(d *decode.D) {
-				d.FieldU32("ts_sec")
-				d.FieldU32(timestampUNSStr)
-				inclLen := d.FieldU32("incl_len")
-				origLen := d.FieldU32("orig_len")
-
-				// "incl_len: the number of bytes of packet data actually captured and saved in the file. This value should never become larger than orig_len or the snaplen value of the global header"
-				// "orig_len: the length of the packet as it appeared on the network when it was captured. If incl_len and orig_len differ, the actually saved packet size was limited by snaplen."
-
-				// TODO: incl_len seems to be larger than snaplen in real pcap files
-				// if inclLen > snapLen {
-				// 	d.Errorf("incl_len %d > snaplen %d", inclLen, snapLen)
-				// }
-
-				if inclLen > origLen {
-					d.Errorf("incl_len %d > orig_len %d", inclLen, origLen)
-				}
-
-				bs := d.ReadAllBits(d.BitBufRange(d.Pos(), int64(inclLen)*8))
-
-				if fn, ok := linkToDecodeFn[linkType]; ok {
-					// TODO: report decode errors
-					_ = fn(fd, bs)
-				}
-
-				d.FieldFormatOrRawLen(
-					"packet",
-					int64(inclLen)*8,
-					pcapLinkFrameFormat, format.LinkFrameIn{
-						Type:           linkType,
-						IsLittleEndian: d.Endian == decode.LittleEndian,
-					},
-				)
-			})
+			decodePcapPacket(d, h, &packets)
 		}
 	})
-	fd.Flush()
 
-	fieldFlows(d, fd, pcapTCPStreamFormat, pcapIPv4PacketFormat)
+	fieldFlows(d, packets, pcapTCPStreamFormat, pcapIPv4PacketFormat, pcapIPv6PacketFormat, pcapUDPFlowFormat, pcapUDPPayloadFormat)
 
 	return nil
 }
+
+// first record is the header, then one record per packet
+func decodePcapStream(d *decode.D, state any) any {
+	h, ok := state.(pcapHeader)
+	if !ok {
+		return decodePcapHeader(d)
+	}
+	d.Endian = h.endian
+	decodePcapPacket(d, h, nil)
+	return h
+}
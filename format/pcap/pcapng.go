@@ -7,15 +7,18 @@ import (
 	"net"
 
 	"github.com/wader/fq/format"
-	"github.com/wader/fq/format/inet/flowsdecoder"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/ranges"
 	"github.com/wader/fq/pkg/scalar"
 )
 
 var pcapngLinkFrameFormat decode.Group
 var pcapngTCPStreamFormat decode.Group
 var pcapngIPvPacket4Format decode.Group
+var pcapngIPv6PacketFormat decode.Group
+var pcapngUDPFlowFormat decode.Group
+var pcapngUDPPayloadFormat decode.Group
 
 func init() {
 	interp.RegisterFormat(decode.Format{
@@ -27,8 +30,12 @@ func init() {
 			{Names: []string{format.LINK_FRAME}, Group: &pcapngLinkFrameFormat},
 			{Names: []string{format.TCP_STREAM}, Group: &pcapngTCPStreamFormat},
 			{Names: []string{format.IPV4_PACKET}, Group: &pcapngIPvPacket4Format},
+			{Names: []string{format.IPV6_PACKET}, Group: &pcapngIPv6PacketFormat},
+			{Names: []string{format.UDP_FLOW}, Group: &pcapngUDPFlowFormat},
+			{Names: []string{format.UDP_PAYLOAD}, Group: &pcapngUDPPayloadFormat},
 		},
 		DecodeFn: decodePcapng,
+		StreamFn: decodePcapngStream,
 	})
 }
 
@@ -230,13 +237,13 @@ var blockFns = map[uint64]func(d *decode.D, dc *decodeContext){
 		capturedLength := d.FieldU32("capture_packet_length")
 		d.FieldU32("original_packet_length")
 
-		bs := d.ReadAllBits(d.BitBufRange(d.Pos(), int64(capturedLength)*8))
-
 		linkType := dc.interfaceTypes[int(interfaceID)]
 
-		if fn, ok := linkToDecodeFn[linkType]; ok {
-			// TODO: report decode errors
-			_ = fn(dc.flowDecoder, bs)
+		if dc.flowPackets != nil {
+			*dc.flowPackets = append(*dc.flowPackets, flowPacket{
+				linkType: linkType,
+				r:        ranges.Range{Start: d.Pos(), Len: int64(capturedLength) * 8},
+			})
 		}
 
 		d.FieldFormatOrRawLen(
@@ -309,42 +316,50 @@ func decodeBlock(d *decode.D, dc *decodeContext) {
 	d.FieldU32("footer_length")
 }
 
+// returns section length, -1 if not specified
+func decodeSectionHeaderBlock(d *decode.D) int64 {
+	sectionLength := int64(-1)
+
+	d.FieldU32("type", d.UintAssert(blockTypeSectionHeader), blockTypeMap, scalar.UintHex)
+
+	d.SeekRel(32)
+	endian := d.FieldU32("byte_order_magic", ngEndianMap, scalar.UintHex)
+	// peeks length and byte-order magic and marks away length
+	switch endian {
+	case ngBigEndian:
+		d.Endian = decode.BigEndian
+	case ngLittleEndian:
+		d.Endian = decode.LittleEndian
+	default:
+		d.Fatalf("unknown endian %d", endian)
+	}
+	d.SeekRel(-64)
+	length := d.FieldU32("length") - 8 - 4
+	d.SeekRel(32)
+
+	d.FramedFn(int64(length)*8, func(d *decode.D) {
+		d.FieldU16("major_version")
+		d.FieldU16("minor_version")
+		sectionLength = d.FieldS64("section_length")
+		d.FramedFn(d.BitsLeft()-32, func(d *decode.D) {
+			d.FieldArray("options", func(d *decode.D) { decoodeOptions(d, sectionHeaderOptionsMap) })
+		})
+		d.FieldU32("footer_total_length")
+	})
+
+	return sectionLength
+}
+
 func decodeSection(d *decode.D, dc *decodeContext) {
 	d.FieldArray("blocks", func(d *decode.D) {
-		sectionLength := int64(-1)
+		var sectionLength int64
 		sectionD := d
 		sectionStart := d.Pos()
 
 		// treat header block differently as it has endian info
 		d.FieldStruct("block", func(d *decode.D) {
-			d.FieldU32("type", d.UintAssert(blockTypeSectionHeader), blockTypeMap, scalar.UintHex)
-
-			d.SeekRel(32)
-			endian := d.FieldU32("byte_order_magic", ngEndianMap, scalar.UintHex)
-			// peeks length and byte-order magic and marks away length
-			switch endian {
-			case ngBigEndian:
-				d.Endian = decode.BigEndian
-			case ngLittleEndian:
-				d.Endian = decode.LittleEndian
-			default:
-				d.Fatalf("unknown endian %d", endian)
-			}
+			sectionLength = decodeSectionHeaderBlock(d)
 			sectionD.Endian = d.Endian
-			d.SeekRel(-64)
-			length := d.FieldU32("length") - 8 - 4
-			d.SeekRel(32)
-
-			d.FramedFn(int64(length)*8, func(d *decode.D) {
-				d.FieldU16("major_version")
-				d.FieldU16("minor_version")
-				sectionLength = d.FieldS64("section_length")
-				d.FramedFn(d.BitsLeft()-32, func(d *decode.D) {
-					d.FieldArray("options", func(d *decode.D) { decoodeOptions(d, sectionHeaderOptionsMap) })
-				})
-				d.FieldU32("footer_total_length")
-			})
-
 			dc.sectionHeaderFound = true
 		})
 
@@ -358,22 +373,22 @@ func decodeSection(d *decode.D, dc *decodeContext) {
 type decodeContext struct {
 	sectionHeaderFound bool
 	interfaceTypes     map[int]int
-	flowDecoder        *flowsdecoder.Decoder
+	// nil if flows should not be reassembled
+	flowPackets *[]flowPacket
 }
 
 func decodePcapng(d *decode.D) any {
 	sectionHeaders := 0
 	for !d.End() {
-		fd := flowsdecoder.New(flowsdecoder.DecoderOptions{CheckTCPOptions: false})
+		var packets []flowPacket
 		dc := decodeContext{
 			interfaceTypes: map[int]int{},
-			flowDecoder:    fd,
+			flowPackets:    &packets,
 		}
 
 		d.FieldStruct("section", func(d *decode.D) {
 			decodeSection(d, &dc)
-			fd.Flush()
-			fieldFlows(d, dc.flowDecoder, pcapngTCPStreamFormat, pcapngIPvPacket4Format)
+			fieldFlows(d, packets, pcapngTCPStreamFormat, pcapngIPvPacket4Format, pcapngIPv6PacketFormat, pcapngUDPFlowFormat, pcapngUDPPayloadFormat)
 		})
 		if dc.sectionHeaderFound {
 			sectionHeaders++
@@ -387,3 +402,31 @@ func decodePcapng(d *decode.D) any {
 
 	return nil
 }
+
+type pcapngStreamState struct {
+	endian         decode.Endian
+	interfaceTypes map[int]int
+}
+
+// one record per block, a section header block sets endian and starts a new
+// list of interfaces. Interface types are copied per record as a record decode
+// can be retried with more input.
+func decodePcapngStream(d *decode.D, state any) any {
+	s, ok := state.(pcapngStreamState)
+	if !ok || d.PeekUintBits(32) == blockTypeSectionHeader {
+		d.FieldStruct("block", func(d *decode.D) {
+			decodeSectionHeaderBlock(d)
+			s = pcapngStreamState{endian: d.Endian, interfaceTypes: map[int]int{}}
+		})
+		return s
+	}
+
+	dc := decodeContext{interfaceTypes: map[int]int{}}
+	for k, v := range s.interfaceTypes {
+		dc.interfaceTypes[k] = v
+	}
+	d.Endian = s.endian
+	d.FieldStruct("block", func(d *decode.D) { decodeBlock(d, &dc) })
+
+	return pcapngStreamState{endian: s.endian, interfaceTypes: dc.interfaceTypes}
+}
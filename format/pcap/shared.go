@@ -1,10 +1,13 @@
 package pcap
 
 import (
+	"bytes"
+
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/format/inet/flowsdecoder"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/ranges"
 )
 
 var linkToDecodeFn = map[int]func(fd *flowsdecoder.Decoder, bs []byte) error{
@@ -17,10 +20,36 @@ var linkToDecodeFn = map[int]func(fd *flowsdecoder.Decoder, bs []byte) error{
 	format.LinkTypeRAW:        (*flowsdecoder.Decoder).RAWIPFrame,
 }
 
+// flowPacket is the link frame of a captured packet, packet data is read again
+// when flows are reassembled so that it does not have to be kept in memory
+type flowPacket struct {
+	linkType int
+	r        ranges.Range
+}
+
 // TODO: make some of this shared if more packet capture formats are added
-func fieldFlows(d *decode.D, fd *flowsdecoder.Decoder, tcpStreamFormat decode.Group, ipv4PacketFormat decode.Group) {
-	d.FieldArray("ipv4_reassembled", func(d *decode.D) {
-		for _, p := range fd.IPV4Reassembled {
+func fieldFlows(d *decode.D, packets []flowPacket, tcpStreamFormat decode.Group, ipv4PacketFormat decode.Group, ipv6PacketFormat decode.Group, udpFlowFormat decode.Group, udpPayloadFormat decode.Group) {
+	// reassemble on first use, with lazy decoding that is when one of the flow arrays is used
+	var fd *flowsdecoder.Decoder
+	flows := func(d *decode.D) *flowsdecoder.Decoder {
+		if fd != nil {
+			return fd
+		}
+		fd = flowsdecoder.New(flowsdecoder.DecoderOptions{CheckTCPOptions: false})
+		for _, p := range packets {
+			fn, ok := linkToDecodeFn[p.linkType]
+			if !ok {
+				continue
+			}
+			// TODO: report decode errors
+			_ = fn(fd, d.ReadAllBits(d.BitBufRange(p.r.Start, p.r.Len)))
+		}
+		fd.Flush()
+		return fd
+	}
+
+	d.FieldArrayLazyFn("ipv4_reassembled", 0, func(d *decode.D) {
+		for _, p := range flows(d).IPV4Reassembled {
 			br := bitio.NewBitReader(p.Datagram, -1)
 			if dv, _, _ := d.TryFieldFormatBitBuf(
 				"ipv4_packet",
@@ -33,8 +62,28 @@ func fieldFlows(d *decode.D, fd *flowsdecoder.Decoder, tcpStreamFormat decode.Gr
 		}
 	})
 
-	d.FieldArray("tcp_connections", func(d *decode.D) {
-		for _, s := range fd.TCPConnections {
+	d.FieldArrayLazyFn("ipv6_reassembled", 0, func(d *decode.D) {
+		for _, p := range flows(d).IPV6Reassembled {
+			br := bitio.NewBitReader(p.Datagram, -1)
+			if p.Err != nil {
+				// failed reassembly, fragment data in arrival order
+				v := d.FieldRootBitBuf("ipv6_packet", br)
+				v.Err = decode.FormatError{Err: p.Err, Format: *d.Value.FormatRoot().Format}
+				continue
+			}
+			if dv, _, _ := d.TryFieldFormatBitBuf(
+				"ipv6_packet",
+				br,
+				ipv6PacketFormat,
+				nil,
+			); dv == nil {
+				d.FieldRootBitBuf("ipv6_packet", br)
+			}
+		}
+	})
+
+	d.FieldArrayLazyFn("tcp_connections", 0, func(d *decode.D) {
+		for _, s := range flows(d).TCPConnections {
 			d.FieldStruct("tcp_connection", func(d *decode.D) {
 				f := func(d *decode.D, td *flowsdecoder.TCPDirection, tsi format.TCPStreamIn) any {
 					d.FieldValueStr("ip", td.Endpoint.IP.String())
@@ -92,4 +141,54 @@ func fieldFlows(d *decode.D, fd *flowsdecoder.Decoder, tcpStreamFormat decode.Gr
 			})
 		}
 	})
+	d.FieldArrayLazyFn("udp_flows", 0, func(d *decode.D) {
+		for _, f := range flows(d).UDPFlows {
+			d.FieldStruct("udp_flow", func(d *decode.D) {
+				d.FieldStruct("client", func(d *decode.D) {
+					d.FieldValueStr("ip", f.Client.IP.String())
+					d.FieldValueUint("port", uint64(f.Client.Port), format.UDPPortMap)
+				})
+				d.FieldStruct("server", func(d *decode.D) {
+					d.FieldValueStr("ip", f.Server.IP.String())
+					d.FieldValueUint("port", uint64(f.Server.Port), format.UDPPortMap)
+				})
+
+				ufi := format.UDPFlowIn{
+					ClientPort: f.Client.Port,
+					ServerPort: f.Server.Port,
+				}
+				buf := &bytes.Buffer{}
+				for _, dg := range f.Datagrams {
+					ufi.Datagrams = append(ufi.Datagrams, format.UDPFlowDatagram{
+						IsClient: dg.IsClient,
+						Length:   len(dg.Payload),
+					})
+					buf.Write(dg.Payload)
+				}
+
+				br := bitio.NewBitReader(buf.Bytes(), -1)
+				if dv, _, _ := d.TryFieldFormatBitBuf("flow", br, udpFlowFormat, ufi); dv != nil {
+					return
+				}
+				// no flow decoder, decode each datagram as a UDP payload
+				d.FieldStructRootBitBufFn("flow", br, func(d *decode.D) {
+					d.FieldArray("datagrams", func(d *decode.D) {
+						for _, dg := range f.Datagrams {
+							d.FieldStruct("datagram", func(d *decode.D) {
+								d.FieldValueBool("is_client", dg.IsClient)
+								upi := format.UDPPayloadIn{
+									SourcePort:      f.Client.Port,
+									DestinationPort: f.Server.Port,
+								}
+								if !dg.IsClient {
+									upi.SourcePort, upi.DestinationPort = upi.DestinationPort, upi.SourcePort
+								}
+								d.FieldFormatOrRawLen("payload", int64(len(dg.Payload))*8, udpPayloadFormat, upi)
+							})
+						}
+					})
+				})
+			})
+		}
+	})
 }
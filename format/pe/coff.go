@@ -0,0 +1,843 @@
+package pe
+
+// https://learn.microsoft.com/en-us/windows/win32/debug/pe-format
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/format/dwarf"
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+var arm64Format decode.Group
+var riscv64Format decode.Group
+var x86_64Format decode.Group
+
+var codeDependencies = []decode.Dependency{
+	{Names: []string{format.ARM64}, Group: &arm64Format},
+	{Names: []string{format.RISCV64}, Group: &riscv64Format},
+	{Names: []string{format.X86_64}, Group: &x86_64Format},
+}
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:        format.COFF,
+		Description: "Common Object File Format object file",
+		DecodeFn:    coffDecode,
+		DefaultInArg: format.CoffIn{
+			Disassemble: false,
+		},
+		Dependencies: codeDependencies,
+	})
+}
+
+const (
+	IMAGE_FILE_MACHINE_UNKNOWN = 0x0
+	IMAGE_FILE_MACHINE_I386    = 0x14c
+	IMAGE_FILE_MACHINE_ARMNT   = 0x1c4
+	IMAGE_FILE_MACHINE_IA64    = 0x200
+	IMAGE_FILE_MACHINE_AMD64   = 0x8664
+	IMAGE_FILE_MACHINE_ARM64   = 0xaa64
+	IMAGE_FILE_MACHINE_RISCV64 = 0x5064
+)
+
+var machineNames = scalar.UintMapSymStr{
+	IMAGE_FILE_MACHINE_UNKNOWN: "unknown",
+	0x184:                      "alpha",
+	0x284:                      "alpha64",
+	0x1d3:                      "am33",
+	IMAGE_FILE_MACHINE_AMD64:   "amd64",
+	0x1c0:                      "arm",
+	IMAGE_FILE_MACHINE_ARM64:   "arm64",
+	0xa641:                     "arm64ec",
+	0xa64e:                     "arm64x",
+	IMAGE_FILE_MACHINE_ARMNT:   "armnt",
+	0xebc:                      "ebc",
+	IMAGE_FILE_MACHINE_I386:    "i386",
+	IMAGE_FILE_MACHINE_IA64:    "ia64",
+	0x6232:                     "loongarch32",
+	0x6264:                     "loongarch64",
+	0x9041:                     "m32r",
+	0x266:                      "mips16",
+	0x366:                      "mipsfpu",
+	0x466:                      "mipsfpu16",
+	0x1f0:                      "powerpc",
+	0x1f1:                      "powerpcfp",
+	0x166:                      "r4000",
+	0x5032:                     "riscv32",
+	IMAGE_FILE_MACHINE_RISCV64: "riscv64",
+	0x5128:                     "riscv128",
+	0x1a2:                      "sh3",
+	0x1a3:                      "sh3dsp",
+	0x1a6:                      "sh4",
+	0x1a8:                      "sh5",
+	0x1c2:                      "thumb",
+	0x169:                      "wcemipsv2",
+}
+
+// machines with 64 bit addresses, used for object files that has no optional header
+var machine64Bit = map[uint64]bool{
+	0x284:                    true,
+	IMAGE_FILE_MACHINE_AMD64: true,
+	IMAGE_FILE_MACHINE_ARM64: true,
+	0xa641:                   true,
+	0xa64e:                   true,
+	IMAGE_FILE_MACHINE_IA64:  true,
+	0x6264:                   true,
+	0x5064:                   true,
+}
+
+var relocationTypeNames = map[uint64]scalar.UintMapSymStr{
+	IMAGE_FILE_MACHINE_I386: {
+		0x00: "absolute",
+		0x01: "dir16",
+		0x02: "rel16",
+		0x06: "dir32",
+		0x07: "dir32nb",
+		0x09: "seg12",
+		0x0a: "section",
+		0x0b: "secrel",
+		0x0c: "token",
+		0x0d: "secrel7",
+		0x14: "rel32",
+	},
+	IMAGE_FILE_MACHINE_AMD64: {
+		0x00: "absolute",
+		0x01: "addr64",
+		0x02: "addr32",
+		0x03: "addr32nb",
+		0x04: "rel32",
+		0x05: "rel32_1",
+		0x06: "rel32_2",
+		0x07: "rel32_3",
+		0x08: "rel32_4",
+		0x09: "rel32_5",
+		0x0a: "section",
+		0x0b: "secrel",
+		0x0c: "secrel7",
+		0x0d: "token",
+		0x0e: "srel32",
+		0x0f: "pair",
+		0x10: "sspan32",
+	},
+	IMAGE_FILE_MACHINE_ARMNT: {
+		0x00: "absolute",
+		0x01: "addr32",
+		0x02: "addr32nb",
+		0x03: "branch24",
+		0x04: "branch11",
+		0x0a: "rel32",
+		0x0e: "section",
+		0x0f: "secrel",
+		0x10: "mov32",
+		0x11: "thumb_mov32",
+		0x12: "thumb_branch20",
+		0x14: "thumb_branch24",
+		0x15: "thumb_blx23",
+		0x16: "pair",
+	},
+	IMAGE_FILE_MACHINE_ARM64: {
+		0x00: "absolute",
+		0x01: "addr32",
+		0x02: "addr32nb",
+		0x03: "branch26",
+		0x04: "pagebase_rel21",
+		0x05: "rel21",
+		0x06: "pageoffset_12a",
+		0x07: "pageoffset_12l",
+		0x08: "secrel",
+		0x09: "secrel_low12a",
+		0x0a: "secrel_high12a",
+		0x0b: "secrel_low12l",
+		0x0c: "token",
+		0x0d: "section",
+		0x0e: "addr64",
+		0x0f: "branch19",
+		0x10: "branch14",
+		0x11: "rel32",
+	},
+}
+
+const (
+	IMAGE_SYM_CLASS_EXTERNAL      = 2
+	IMAGE_SYM_CLASS_STATIC        = 3
+	IMAGE_SYM_CLASS_FUNCTION      = 101
+	IMAGE_SYM_CLASS_FILE          = 103
+	IMAGE_SYM_CLASS_WEAK_EXTERNAL = 105
+)
+
+var storageClassNames = scalar.UintMapSymStr{
+	0xff:                          "end_of_function",
+	0:                             "null",
+	1:                             "automatic",
+	IMAGE_SYM_CLASS_EXTERNAL:      "external",
+	IMAGE_SYM_CLASS_STATIC:        "static",
+	4:                             "register",
+	5:                             "external_def",
+	6:                             "label",
+	7:                             "undefined_label",
+	8:                             "member_of_struct",
+	9:                             "argument",
+	10:                            "struct_tag",
+	11:                            "member_of_union",
+	12:                            "union_tag",
+	13:                            "type_definition",
+	14:                            "undefined_static",
+	15:                            "enum_tag",
+	16:                            "member_of_enum",
+	17:                            "register_param",
+	18:                            "bit_field",
+	100:                           "block",
+	IMAGE_SYM_CLASS_FUNCTION:      "function",
+	102:                           "end_of_struct",
+	IMAGE_SYM_CLASS_FILE:          "file",
+	104:                           "section",
+	IMAGE_SYM_CLASS_WEAK_EXTERNAL: "weak_external",
+	107:                           "clr_token",
+}
+
+const IMAGE_SYM_DTYPE_FUNCTION = 2
+
+var symbolBaseTypeNames = scalar.UintMapSymStr{
+	0:  "null",
+	1:  "void",
+	2:  "char",
+	3:  "short",
+	4:  "int",
+	5:  "long",
+	6:  "float",
+	7:  "double",
+	8:  "struct",
+	9:  "union",
+	10: "enum",
+	11: "moe",
+	12: "byte",
+	13: "word",
+	14: "uint",
+	15: "dword",
+}
+
+var symbolComplexTypeNames = scalar.UintMapSymStr{
+	0:                        "null",
+	1:                        "pointer",
+	IMAGE_SYM_DTYPE_FUNCTION: "function",
+	3:                        "array",
+}
+
+var comdatSelectionNames = scalar.UintMapSymStr{
+	0: "none",
+	1: "noduplicates",
+	2: "any",
+	3: "same_size",
+	4: "exact_match",
+	5: "associative",
+	6: "largest",
+}
+
+var weakExternalCharacteristicsNames = scalar.UintMapSymStr{
+	1: "search_nolibrary",
+	2: "search_library",
+	3: "search_alias",
+	4: "anti_dependency",
+}
+
+const (
+	IMAGE_SCN_CNT_CODE               = 0x0000_0020
+	IMAGE_SCN_CNT_UNINITIALIZED_DATA = 0x0000_0080
+	IMAGE_SCN_LNK_NRELOC_OVFL        = 0x0100_0000
+	IMAGE_SCN_MEM_EXECUTE            = 0x2000_0000
+)
+
+const (
+	fileHeaderSize    = 20
+	sectionHeaderSize = 40
+	symbolSize        = 18
+	relocationSize    = 10
+)
+
+type section struct {
+	name                 string
+	virtualSize          uint64
+	virtualAddress       uint64
+	sizeOfRawData        uint64
+	pointerToRawData     uint64
+	pointerToRelocations uint64
+	numberOfRelocations  uint64
+	characteristics      uint64
+}
+
+type symbol struct {
+	name          string
+	value         uint64
+	sectionNumber int64
+	storageClass  uint64
+}
+
+type coffContext struct {
+	machine              uint64
+	numberOfSections     uint64
+	pointerToSymbolTable uint64
+	numberOfSymbols      uint64
+	sizeOfOptionalHeader uint64
+	image                bool
+	imageBase            uint64
+	disassemble          bool
+	addrSize             int
+	fileSize             int64
+	strTab               string
+	symbolNames          symbolNames
+	symbols              []symbol
+	addrNames            map[uint64]string
+	sections             []section
+	dwarf                dwarf.Sections
+}
+
+func strIndexNull(idx int, s string) string {
+	if idx > len(s) {
+		return ""
+	}
+	i := strings.IndexByte(s[idx:], 0)
+	if i == -1 {
+		return s[idx:]
+	}
+	return s[idx : idx+i]
+}
+
+type strTable string
+
+func (m strTable) MapUint(s scalar.Uint) (scalar.Uint, error) {
+	s.Sym = strIndexNull(int(s.Actual), string(m))
+	return s, nil
+}
+
+// long section names in object files are "/" and decimal offset into string table
+type sectionNameMapper string
+
+func (m sectionNameMapper) MapStr(s scalar.Str) (scalar.Str, error) {
+	if name, ok := longSectionName(s.Actual, string(m)); ok {
+		s.Sym = name
+	}
+	return s, nil
+}
+
+func longSectionName(name string, strTab string) (string, bool) {
+	if !strings.HasPrefix(name, "/") {
+		return "", false
+	}
+	n, err := strconv.Atoi(name[1:])
+	if err != nil || n < 0 || n >= len(strTab) {
+		return "", false
+	}
+	return strIndexNull(n, strTab), true
+}
+
+type symbolNames map[uint64]string
+
+func (m symbolNames) MapUint(s scalar.Uint) (scalar.Uint, error) {
+	if name, ok := m[s.Actual]; ok {
+		s.Sym = name
+	}
+	return s, nil
+}
+
+type sectionNumberNames []section
+
+func (m sectionNumberNames) MapSint(s scalar.Sint) (scalar.Sint, error) {
+	switch {
+	case s.Actual == 0:
+		s.Sym = "undefined"
+	case s.Actual == -1:
+		s.Sym = "absolute"
+	case s.Actual == -2:
+		s.Sym = "debug"
+	case s.Actual > 0 && int(s.Actual) <= len(m):
+		s.Sym = m[s.Actual-1].name
+	}
+	return s, nil
+}
+
+// inFile returns true if nBytes bytes at byte offset is inside the file
+func (cc *coffContext) inFile(offset int64, nBytes int64) bool {
+	return offset >= 0 && nBytes >= 0 && offset+nBytes <= cc.fileSize
+}
+
+func (cc *coffContext) readBytes(d *decode.D, offset int64, nBytes int64) ([]byte, bool) {
+	if !cc.inFile(offset, nBytes) {
+		return nil, false
+	}
+	return d.BytesRange(offset*8, int(nBytes)), true
+}
+
+func (cc *coffContext) readU32(d *decode.D, offset int64) (uint64, bool) {
+	bs, ok := cc.readBytes(d, offset, 4)
+	if !ok {
+		return 0, false
+	}
+	return uint64(binary.LittleEndian.Uint32(bs)), true
+}
+
+func coffDecodeHeader(d *decode.D, cc *coffContext) {
+	cc.machine = d.FieldU16("machine", machineNames, scalar.UintHex)
+	cc.numberOfSections = d.FieldU16("number_of_sections")
+	d.FieldU32("time_date_stamp", scalar.UintActualUnixTime(time.RFC3339))
+	cc.pointerToSymbolTable = d.FieldU32("pointer_to_symbol_table", scalar.UintHex)
+	cc.numberOfSymbols = d.FieldU32("number_of_symbols")
+	cc.sizeOfOptionalHeader = d.FieldU16("size_of_optional_header")
+	// 16 bit little endian flags, low byte first
+	d.FieldStruct("characteristics", func(d *decode.D) {
+		d.FieldBool("bytes_reversed_lo")
+		d.FieldBool("reserved")
+		d.FieldBool("large_address_aware")
+		d.FieldBool("aggressive_ws_trim")
+		d.FieldBool("local_syms_stripped")
+		d.FieldBool("line_nums_stripped")
+		d.FieldBool("executable_image")
+		d.FieldBool("relocs_stripped")
+		d.FieldBool("bytes_reversed_hi")
+		d.FieldBool("up_system_only")
+		d.FieldBool("dll")
+		d.FieldBool("system")
+		d.FieldBool("net_run_from_swap")
+		d.FieldBool("removable_run_from_swap")
+		d.FieldBool("debug_stripped")
+		d.FieldBool("machine_32bit")
+	})
+
+	cc.addrSize = 4
+	if machine64Bit[cc.machine] {
+		cc.addrSize = 8
+	}
+}
+
+const maxStrTabSize = 100_000_000
+
+// string table follows symbol table and starts with 32 bit size that include the size itself,
+// offsets into it are from start of the size
+func coffReadStringTable(d *decode.D, cc *coffContext) {
+	if cc.pointerToSymbolTable == 0 {
+		return
+	}
+	offset := int64(cc.pointerToSymbolTable + cc.numberOfSymbols*symbolSize)
+	size, ok := cc.readU32(d, offset)
+	if !ok || size < 4 || size > maxStrTabSize {
+		return
+	}
+	if bs, ok := cc.readBytes(d, offset, int64(size)); ok {
+		cc.strTab = string(bs)
+	}
+}
+
+func coffSymbolName(bs []byte, strTab string) string {
+	if binary.LittleEndian.Uint32(bs[0:4]) == 0 {
+		return strIndexNull(int(binary.LittleEndian.Uint32(bs[4:8])), strTab)
+	}
+	return strIndexNull(0, string(bs[0:8]))
+}
+
+// symbolTableEntries returns number of symbol table entries that fit in the file
+func (cc *coffContext) symbolTableEntries() uint64 {
+	if cc.pointerToSymbolTable == 0 || int64(cc.pointerToSymbolTable) > cc.fileSize {
+		return 0
+	}
+	return mathex.Min(cc.numberOfSymbols, uint64(cc.fileSize-int64(cc.pointerToSymbolTable))/symbolSize)
+}
+
+func coffReadSymbolNames(d *decode.D, cc *coffContext) {
+	cc.symbolNames = symbolNames{}
+	n := cc.symbolTableEntries()
+	if n == 0 {
+		return
+	}
+	bs := d.BytesRange(int64(cc.pointerToSymbolTable)*8, int(n*symbolSize))
+	for i := uint64(0); i < n; i++ {
+		e := bs[i*symbolSize : (i+1)*symbolSize]
+		name := coffSymbolName(e, cc.strTab)
+		cc.symbolNames[i] = name
+		cc.symbols = append(cc.symbols, symbol{
+			name:          name,
+			value:         uint64(binary.LittleEndian.Uint32(e[8:12])),
+			sectionNumber: int64(int16(binary.LittleEndian.Uint16(e[12:14]))),
+			storageClass:  uint64(e[16]),
+		})
+		// skip aux symbols
+		i += uint64(e[17])
+	}
+}
+
+func coffReadSectionHeaders(d *decode.D, cc *coffContext, offset int64) {
+	for i := uint64(0); i < cc.numberOfSections; i++ {
+		bs, ok := cc.readBytes(d, offset+int64(i*sectionHeaderSize), sectionHeaderSize)
+		if !ok {
+			d.Errorf("section header %d outside file", i)
+		}
+		name := strIndexNull(0, string(bs[0:8]))
+		if n, ok := longSectionName(name, cc.strTab); ok {
+			name = n
+		}
+		cc.sections = append(cc.sections, section{
+			name:                 name,
+			virtualSize:          uint64(binary.LittleEndian.Uint32(bs[8:12])),
+			virtualAddress:       uint64(binary.LittleEndian.Uint32(bs[12:16])),
+			sizeOfRawData:        uint64(binary.LittleEndian.Uint32(bs[16:20])),
+			pointerToRawData:     uint64(binary.LittleEndian.Uint32(bs[20:24])),
+			pointerToRelocations: uint64(binary.LittleEndian.Uint32(bs[24:28])),
+			numberOfRelocations:  uint64(binary.LittleEndian.Uint16(bs[32:34])),
+			characteristics:      uint64(binary.LittleEndian.Uint32(bs[36:40])),
+		})
+	}
+}
+
+// file range of section data, for images data after virtual size is padding
+func (cc *coffContext) sectionDataRange(s section) (offset int64, dataSize int64, size int64, ok bool) {
+	if s.pointerToRawData == 0 || s.sizeOfRawData == 0 || s.characteristics&IMAGE_SCN_CNT_UNINITIALIZED_DATA != 0 {
+		return 0, 0, 0, false
+	}
+	offset = int64(s.pointerToRawData)
+	if offset >= cc.fileSize {
+		return 0, 0, 0, false
+	}
+	size = mathex.Min(int64(s.sizeOfRawData), cc.fileSize-offset)
+	dataSize = size
+	if cc.image && s.virtualSize != 0 {
+		dataSize = mathex.Min(dataSize, int64(s.virtualSize))
+	}
+	return offset, dataSize, size, true
+}
+
+func coffReadDWARFSections(d *decode.D, cc *coffContext) {
+	cc.dwarf = dwarf.Sections{}
+	for _, s := range cc.sections {
+		name, zdebug, ok := dwarf.SectionName(s.name)
+		if !ok {
+			continue
+		}
+		offset, dataSize, _, ok := cc.sectionDataRange(s)
+		if !ok {
+			continue
+		}
+		bs, err := dwarf.ReadSection(d.BytesRange(offset*8, int(dataSize)), zdebug)
+		if err != nil {
+			continue
+		}
+		cc.dwarf[name] = bs
+	}
+}
+
+// coffReadAddrNames reads names of symbols defined in sections by virtual address, used
+// when disassembling images. External symbols are preferred over static and labels.
+func coffReadAddrNames(cc *coffContext) {
+	cc.addrNames = map[uint64]string{}
+	if !cc.image {
+		return
+	}
+	for _, sym := range cc.symbols {
+		if sym.name == "" || sym.sectionNumber <= 0 || int(sym.sectionNumber) > len(cc.sections) {
+			continue
+		}
+		addr := cc.imageBase + cc.sections[sym.sectionNumber-1].virtualAddress + sym.value
+		if _, ok := cc.addrNames[addr]; ok && sym.storageClass != IMAGE_SYM_CLASS_EXTERNAL {
+			continue
+		}
+		cc.addrNames[addr] = sym.name
+	}
+}
+
+// codeSymbols returns symbol names by address for section i. Object files are not
+// relocated so only symbols in the same section are known by offset in the section.
+func (cc *coffContext) codeSymbols(i int) map[uint64]string {
+	if cc.image {
+		return cc.addrNames
+	}
+	m := map[uint64]string{}
+	for _, sym := range cc.symbols {
+		if sym.name == "" || sym.sectionNumber != int64(i+1) {
+			continue
+		}
+		if _, ok := m[sym.value]; ok && sym.storageClass != IMAGE_SYM_CLASS_EXTERNAL {
+			continue
+		}
+		m[sym.value] = sym.name
+	}
+	return m
+}
+
+// coffDecodeCode disassembles section i if machine is supported, otherwise raw data
+func coffDecodeCode(d *decode.D, cc *coffContext, i int, s section) {
+	var group *decode.Group
+	switch cc.machine {
+	case IMAGE_FILE_MACHINE_AMD64:
+		group = &x86_64Format
+	case IMAGE_FILE_MACHINE_ARM64:
+		group = &arm64Format
+	case IMAGE_FILE_MACHINE_RISCV64:
+		group = &riscv64Format
+	}
+	if group != nil {
+		var base uint64
+		if cc.image {
+			base = cc.imageBase + s.virtualAddress
+		}
+		inArg := format.AsmIn{
+			Base:    base,
+			Symbols: cc.codeSymbols(i),
+		}
+		if dv, _, _ := d.TryFieldFormatLen("code", d.BitsLeft(), *group, inArg); dv != nil {
+			return
+		}
+	}
+	d.FieldRawLen("data", d.BitsLeft())
+}
+
+// coffDecodeSectionFlags decodes 32 bit little endian section characteristics
+func coffDecodeSectionFlags(d *decode.D) {
+	d.FieldStruct("characteristics", func(d *decode.D) {
+		d.FieldBool("cnt_uninitialized_data")
+		d.FieldBool("cnt_initialized_data")
+		d.FieldBool("cnt_code")
+		d.FieldBool("reserved0")
+		d.FieldBool("type_no_pad")
+		d.FieldU3("reserved1")
+		d.FieldBool("gprel")
+		d.FieldU2("reserved2")
+		d.FieldBool("lnk_comdat")
+		d.FieldBool("lnk_remove")
+		d.FieldBool("reserved3")
+		d.FieldBool("lnk_info")
+		d.FieldBool("lnk_other")
+		d.FieldU4("align", scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+			if s.Actual > 0 && s.Actual <= 14 {
+				s.Sym = uint64(1) << (s.Actual - 1)
+			}
+			return s, nil
+		}))
+		d.FieldBool("mem_preload")
+		d.FieldBool("mem_locked")
+		d.FieldBool("mem_16bit")
+		d.FieldBool("reserved4")
+		d.FieldBool("mem_write")
+		d.FieldBool("mem_read")
+		d.FieldBool("mem_execute")
+		d.FieldBool("mem_shared")
+		d.FieldBool("mem_not_paged")
+		d.FieldBool("mem_not_cached")
+		d.FieldBool("mem_discardable")
+		d.FieldBool("lnk_nreloc_ovfl")
+	})
+}
+
+func coffDecodeRelocations(d *decode.D, cc *coffContext, s section) {
+	offset := int64(s.pointerToRelocations)
+	n := s.numberOfRelocations
+	// more than 0xffff relocations, real count including the first relocation
+	// is stored as virtual address of first relocation
+	if s.characteristics&IMAGE_SCN_LNK_NRELOC_OVFL != 0 && n == 0xffff {
+		if c, ok := cc.readU32(d, offset); ok {
+			n = c
+		}
+	}
+	if !cc.inFile(offset, int64(n*relocationSize)) {
+		return
+	}
+	d.RangeFn(offset*8, int64(n*relocationSize)*8, func(d *decode.D) {
+		d.FieldArray("relocations", func(d *decode.D) {
+			for i := uint64(0); i < n; i++ {
+				d.FieldStruct("relocation", func(d *decode.D) {
+					d.FieldU32("virtual_address", scalar.UintHex)
+					d.FieldU32("symbol_table_index", cc.symbolNames)
+					d.FieldU16("type", relocationTypeNames[cc.machine])
+				})
+			}
+		})
+	})
+}
+
+func coffDecodeSectionHeader(d *decode.D, cc *coffContext, i int, s section) {
+	d.FieldUTF8NullFixedLen("name", 8, sectionNameMapper(cc.strTab))
+	d.FieldU32("virtual_size")
+	d.FieldU32("virtual_address", scalar.UintHex)
+	d.FieldU32("size_of_raw_data")
+	d.FieldU32("pointer_to_raw_data", scalar.UintHex)
+	d.FieldU32("pointer_to_relocations", scalar.UintHex)
+	d.FieldU32("pointer_to_linenumbers", scalar.UintHex)
+	d.FieldU16("number_of_relocations")
+	d.FieldU16("number_of_linenumbers")
+	coffDecodeSectionFlags(d)
+
+	if offset, dataSize, size, ok := cc.sectionDataRange(s); ok {
+		d.RangeFn(offset*8, dataSize*8, func(d *decode.D) {
+			if name, zdebug, ok := dwarf.SectionName(s.name); ok {
+				dwarf.FieldSection(d, name, zdebug, cc.dwarf, cc.addrSize)
+			} else if cc.disassemble && s.characteristics&(IMAGE_SCN_CNT_CODE|IMAGE_SCN_MEM_EXECUTE) != 0 {
+				coffDecodeCode(d, cc, i, s)
+			} else {
+				d.FieldRawLen("data", d.BitsLeft())
+			}
+		})
+		if size > dataSize {
+			d.RangeFn((offset+dataSize)*8, (size-dataSize)*8, func(d *decode.D) {
+				d.FieldRawLen("padding", d.BitsLeft())
+			})
+		}
+	}
+
+	if s.pointerToRelocations != 0 && s.numberOfRelocations > 0 {
+		coffDecodeRelocations(d, cc, s)
+	}
+}
+
+func coffDecodeSectionHeaders(d *decode.D, cc *coffContext) {
+	d.FieldArray("section_headers", func(d *decode.D) {
+		for i, s := range cc.sections {
+			d.FieldStruct("section_header", func(d *decode.D) {
+				coffDecodeSectionHeader(d, cc, i, s)
+			})
+		}
+	})
+}
+
+func coffDecodeAuxSymbol(d *decode.D, cc *coffContext, storageClass uint64, complexType uint64, sectionNumber int64, value uint64) {
+	switch {
+	case storageClass == IMAGE_SYM_CLASS_EXTERNAL && complexType == IMAGE_SYM_DTYPE_FUNCTION && sectionNumber > 0:
+		d.FieldStruct("function_definition", func(d *decode.D) {
+			d.FieldU32("tag_index", cc.symbolNames)
+			d.FieldU32("total_size")
+			d.FieldU32("pointer_to_linenumber", scalar.UintHex)
+			d.FieldU32("pointer_to_next_function", cc.symbolNames)
+			d.FieldRawLen("unused", 2*8)
+		})
+	case storageClass == IMAGE_SYM_CLASS_FUNCTION:
+		d.FieldStruct("function_line", func(d *decode.D) {
+			d.FieldRawLen("unused0", 4*8)
+			d.FieldU16("linenumber")
+			d.FieldRawLen("unused1", 6*8)
+			d.FieldU32("pointer_to_next_function", cc.symbolNames)
+			d.FieldRawLen("unused2", 2*8)
+		})
+	case storageClass == IMAGE_SYM_CLASS_WEAK_EXTERNAL:
+		d.FieldStruct("weak_external", func(d *decode.D) {
+			d.FieldU32("tag_index", cc.symbolNames)
+			d.FieldU32("characteristics", weakExternalCharacteristicsNames)
+			d.FieldRawLen("unused", 10*8)
+		})
+	case storageClass == IMAGE_SYM_CLASS_STATIC && value == 0 && complexType != IMAGE_SYM_DTYPE_FUNCTION:
+		d.FieldStruct("section_definition", func(d *decode.D) {
+			d.FieldU32("length")
+			d.FieldU16("number_of_relocations")
+			d.FieldU16("number_of_linenumbers")
+			d.FieldU32("check_sum", scalar.UintHex)
+			d.FieldU16("number")
+			d.FieldU8("selection", comdatSelectionNames)
+			d.FieldRawLen("unused", 3*8)
+		})
+	default:
+		d.FieldRawLen("aux_symbol", symbolSize*8)
+	}
+}
+
+func coffDecodeSymbolTable(d *decode.D, cc *coffContext) {
+	n := cc.symbolTableEntries()
+	if n == 0 {
+		return
+	}
+	d.RangeFn(int64(cc.pointerToSymbolTable)*8, int64(n*symbolSize)*8, func(d *decode.D) {
+		d.FieldArray("symbols", func(d *decode.D) {
+			for i := uint64(0); i < n; i++ {
+				d.FieldStruct("symbol", func(d *decode.D) {
+					if d.PeekUintBits(32) == 0 {
+						d.FieldU32("zeroes")
+						d.FieldU32("name", strTable(cc.strTab))
+					} else {
+						d.FieldUTF8NullFixedLen("name", 8)
+					}
+					value := d.FieldU32("value", scalar.UintHex)
+					sectionNumber := d.FieldS16("section_number", sectionNumberNames(cc.sections))
+					typ := d.FieldU16("type", scalar.UintHex)
+					d.FieldValueUint("base_type", typ&0xf, symbolBaseTypeNames)
+					complexType := (typ >> 4) & 0x3
+					d.FieldValueUint("complex_type", complexType, symbolComplexTypeNames)
+					storageClass := d.FieldU8("storage_class", storageClassNames)
+					numberOfAuxSymbols := mathex.Min(d.FieldU8("number_of_aux_symbols"), n-i-1)
+					if numberOfAuxSymbols == 0 {
+						return
+					}
+					i += numberOfAuxSymbols
+					if storageClass == IMAGE_SYM_CLASS_FILE {
+						d.FieldUTF8NullFixedLen("file_name", int(numberOfAuxSymbols)*symbolSize)
+						return
+					}
+					d.FieldArray("aux_symbols", func(d *decode.D) {
+						for j := uint64(0); j < numberOfAuxSymbols; j++ {
+							coffDecodeAuxSymbol(d, cc, storageClass, complexType, sectionNumber, value)
+						}
+					})
+				})
+			}
+		})
+	})
+}
+
+func coffDecodeStringTable(d *decode.D, cc *coffContext) {
+	if len(cc.strTab) < 4 {
+		return
+	}
+	offset := int64(cc.pointerToSymbolTable + cc.numberOfSymbols*symbolSize)
+	d.RangeFn(offset*8, int64(len(cc.strTab))*8, func(d *decode.D) {
+		d.FieldStruct("string_table", func(d *decode.D) {
+			d.FieldU32("size")
+			d.FieldArray("strings", func(d *decode.D) {
+				for !d.End() {
+					d.FieldUTF8Null("string")
+				}
+			})
+		})
+	})
+}
+
+// coffDecodeSymbols decodes symbol and string table, deprecated for images
+// but used by for example mingw
+func coffDecodeSymbols(d *decode.D, cc *coffContext) {
+	coffDecodeSymbolTable(d, cc)
+	coffDecodeStringTable(d, cc)
+}
+
+// coffReadTables does a first pass to read string table, section headers, symbol names
+// and DWARF sections needed to resolve names
+func coffReadTables(d *decode.D, cc *coffContext, sectionHeadersOffset int64) {
+	cc.fileSize = d.Len() / 8
+	coffReadStringTable(d, cc)
+	coffReadSectionHeaders(d, cc, sectionHeadersOffset)
+	coffReadSymbolNames(d, cc)
+	coffReadDWARFSections(d, cc)
+	if cc.disassemble {
+		coffReadAddrNames(cc)
+	}
+}
+
+func coffDecode(d *decode.D) any {
+	var ci format.CoffIn
+	d.ArgAs(&ci)
+
+	d.Endian = decode.LittleEndian
+
+	var cc coffContext
+	cc.disassemble = ci.Disassemble
+	d.FieldStruct("coff_header", func(d *decode.D) {
+		coffDecodeHeader(d, &cc)
+	})
+	if cc.sizeOfOptionalHeader > 0 {
+		d.FieldRawLen("optional_header", int64(cc.sizeOfOptionalHeader)*8)
+	}
+
+	coffReadTables(d, &cc, fileHeaderSize+int64(cc.sizeOfOptionalHeader))
+	coffDecodeSectionHeaders(d, &cc)
+	coffDecodeSymbols(d, &cc)
+
+	return nil
+}
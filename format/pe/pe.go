@@ -0,0 +1,377 @@
+package pe
+
+// https://learn.microsoft.com/en-us/windows/win32/debug/pe-format
+// https://learn.microsoft.com/en-us/windows/win32/api/winnt/ns-winnt-image_dos_header
+
+import (
+	"embed"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed pe.md
+var peFS embed.FS
+
+var asn1BerFormat decode.Group
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:        format.PE,
+		Description: "Portable Executable (Windows executable and DLL)",
+		Groups:      []string{format.PROBE},
+		DecodeFn:    peDecode,
+		DefaultInArg: format.PeIn{
+			Disassemble: false,
+		},
+		Dependencies: append([]decode.Dependency{
+			{Names: []string{format.ASN1_BER}, Group: &asn1BerFormat},
+		}, codeDependencies...),
+	})
+	interp.RegisterFS(peFS)
+}
+
+const (
+	IMAGE_NT_OPTIONAL_HDR32_MAGIC = 0x10b
+	IMAGE_NT_OPTIONAL_HDR64_MAGIC = 0x20b
+	IMAGE_ROM_OPTIONAL_HDR_MAGIC  = 0x107
+)
+
+var optionalHeaderMagicNames = scalar.UintMapSymStr{
+	IMAGE_NT_OPTIONAL_HDR32_MAGIC: "pe32",
+	IMAGE_NT_OPTIONAL_HDR64_MAGIC: "pe32+",
+	IMAGE_ROM_OPTIONAL_HDR_MAGIC:  "rom",
+}
+
+var subsystemNames = scalar.UintMapSymStr{
+	0:  "unknown",
+	1:  "native",
+	2:  "windows_gui",
+	3:  "windows_cui",
+	5:  "os2_cui",
+	7:  "posix_cui",
+	8:  "native_windows",
+	9:  "windows_ce_gui",
+	10: "efi_application",
+	11: "efi_boot_service_driver",
+	12: "efi_runtime_driver",
+	13: "efi_rom",
+	14: "xbox",
+	16: "windows_boot_application",
+}
+
+const (
+	IMAGE_DIRECTORY_ENTRY_EXPORT         = 0
+	IMAGE_DIRECTORY_ENTRY_IMPORT         = 1
+	IMAGE_DIRECTORY_ENTRY_RESOURCE       = 2
+	IMAGE_DIRECTORY_ENTRY_EXCEPTION      = 3
+	IMAGE_DIRECTORY_ENTRY_SECURITY       = 4
+	IMAGE_DIRECTORY_ENTRY_BASERELOC      = 5
+	IMAGE_DIRECTORY_ENTRY_DEBUG          = 6
+	IMAGE_DIRECTORY_ENTRY_ARCHITECTURE   = 7
+	IMAGE_DIRECTORY_ENTRY_GLOBALPTR      = 8
+	IMAGE_DIRECTORY_ENTRY_TLS            = 9
+	IMAGE_DIRECTORY_ENTRY_LOAD_CONFIG    = 10
+	IMAGE_DIRECTORY_ENTRY_BOUND_IMPORT   = 11
+	IMAGE_DIRECTORY_ENTRY_IAT            = 12
+	IMAGE_DIRECTORY_ENTRY_DELAY_IMPORT   = 13
+	IMAGE_DIRECTORY_ENTRY_COM_DESCRIPTOR = 14
+)
+
+var dataDirectoryNames = map[int]string{
+	IMAGE_DIRECTORY_ENTRY_EXPORT:         "export_table",
+	IMAGE_DIRECTORY_ENTRY_IMPORT:         "import_table",
+	IMAGE_DIRECTORY_ENTRY_RESOURCE:       "resource_table",
+	IMAGE_DIRECTORY_ENTRY_EXCEPTION:      "exception_table",
+	IMAGE_DIRECTORY_ENTRY_SECURITY:       "certificate_table",
+	IMAGE_DIRECTORY_ENTRY_BASERELOC:      "base_relocation_table",
+	IMAGE_DIRECTORY_ENTRY_DEBUG:          "debug",
+	IMAGE_DIRECTORY_ENTRY_ARCHITECTURE:   "architecture",
+	IMAGE_DIRECTORY_ENTRY_GLOBALPTR:      "global_ptr",
+	IMAGE_DIRECTORY_ENTRY_TLS:            "tls_table",
+	IMAGE_DIRECTORY_ENTRY_LOAD_CONFIG:    "load_config_table",
+	IMAGE_DIRECTORY_ENTRY_BOUND_IMPORT:   "bound_import",
+	IMAGE_DIRECTORY_ENTRY_IAT:            "iat",
+	IMAGE_DIRECTORY_ENTRY_DELAY_IMPORT:   "delay_import_descriptor",
+	IMAGE_DIRECTORY_ENTRY_COM_DESCRIPTOR: "clr_runtime_header",
+	15:                                   "reserved",
+}
+
+const dosHeaderSize = 64
+
+type dataDirectory struct {
+	virtualAddress uint64
+	size           uint64
+}
+
+type peContext struct {
+	coffContext
+	sizeOfHeaders   uint64
+	dataDirectories []dataDirectory
+}
+
+// rvaToOffset returns file byte offset for relative virtual address
+func (pc *peContext) rvaToOffset(rva uint64) (int64, bool) {
+	if rva < pc.sizeOfHeaders && int64(rva) < pc.fileSize {
+		return int64(rva), true
+	}
+	for _, s := range pc.sections {
+		if rva >= s.virtualAddress && rva-s.virtualAddress < s.sizeOfRawData {
+			offset := int64(s.pointerToRawData + rva - s.virtualAddress)
+			if offset >= pc.fileSize {
+				return 0, false
+			}
+			return offset, true
+		}
+	}
+	return 0, false
+}
+
+// vaToOffset returns file byte offset for virtual address
+func (pc *peContext) vaToOffset(va uint64) (int64, bool) {
+	if va < pc.imageBase {
+		return 0, false
+	}
+	return pc.rvaToOffset(va - pc.imageBase)
+}
+
+// atOffset decodes fn at byte offset with rest of file available so that
+// nested ranges can be anywhere in the file
+func (pc *peContext) atOffset(d *decode.D, offset int64, fn func(d *decode.D)) {
+	d.RangeFn(offset*8, (pc.fileSize-offset)*8, fn)
+}
+
+func (pc *peContext) readUint(d *decode.D, offset int64, size int) (uint64, bool) {
+	bs, ok := pc.readBytes(d, offset, int64(size))
+	if !ok {
+		return 0, false
+	}
+	switch size {
+	case 2:
+		return uint64(binary.LittleEndian.Uint16(bs)), true
+	case 4:
+		return uint64(binary.LittleEndian.Uint32(bs)), true
+	case 8:
+		return binary.LittleEndian.Uint64(bs), true
+	}
+	return 0, false
+}
+
+const maxStrLen = 4096
+
+func (pc *peContext) rvaStr(d *decode.D, rva uint64) (string, bool) {
+	offset, ok := pc.rvaToOffset(rva)
+	if !ok {
+		return "", false
+	}
+	bs := d.BytesRange(offset*8, int(mathex.Min(maxStrLen, pc.fileSize-offset)))
+	return strIndexNull(0, string(bs)), true
+}
+
+// rva, or virtual address if base is not zero, to null terminated string
+type rvaStrMapper struct {
+	pc   *peContext
+	d    *decode.D
+	base uint64
+}
+
+func (m rvaStrMapper) MapUint(s scalar.Uint) (scalar.Uint, error) {
+	if s.Actual == 0 || s.Actual < m.base {
+		return s, nil
+	}
+	if str, ok := m.pc.rvaStr(m.d, s.Actual-m.base); ok {
+		s.Sym = str
+	}
+	return s, nil
+}
+
+// microsoft GUID with first three parts in little endian
+var rawGUID = scalar.BitBufFn(func(s scalar.BitBuf) (scalar.BitBuf, error) {
+	return scalar.RawSym(s, -1, func(b []byte) string {
+		return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+			binary.LittleEndian.Uint32(b[0:4]),
+			binary.LittleEndian.Uint16(b[4:6]),
+			binary.LittleEndian.Uint16(b[6:8]),
+			b[8:10],
+			b[10:16],
+		)
+	})
+})
+
+func peDecodeDOSHeader(d *decode.D) int64 {
+	d.FieldRawLen("e_magic", 2*8, d.AssertBitBuf([]byte("MZ")))
+	d.FieldU16("e_cblp")
+	d.FieldU16("e_cp")
+	d.FieldU16("e_crlc")
+	d.FieldU16("e_cparhdr")
+	d.FieldU16("e_minalloc")
+	d.FieldU16("e_maxalloc")
+	d.FieldU16("e_ss", scalar.UintHex)
+	d.FieldU16("e_sp", scalar.UintHex)
+	d.FieldU16("e_csum", scalar.UintHex)
+	d.FieldU16("e_ip", scalar.UintHex)
+	d.FieldU16("e_cs", scalar.UintHex)
+	d.FieldU16("e_lfarlc", scalar.UintHex)
+	d.FieldU16("e_ovno")
+	d.FieldRawLen("e_res", 4*2*8)
+	d.FieldU16("e_oemid")
+	d.FieldU16("e_oeminfo")
+	d.FieldRawLen("e_res2", 10*2*8)
+	return int64(d.FieldU32("e_lfanew", scalar.UintHex))
+}
+
+func peDecodeOptionalHeader(d *decode.D, pc *peContext) {
+	magic := d.FieldU16("magic", optionalHeaderMagicNames, scalar.UintHex)
+	switch magic {
+	case IMAGE_NT_OPTIONAL_HDR32_MAGIC:
+		pc.addrSize = 4
+	case IMAGE_NT_OPTIONAL_HDR64_MAGIC:
+		pc.addrSize = 8
+	default:
+		d.FieldRawLen("data", d.BitsLeft())
+		return
+	}
+	addrBits := pc.addrSize * 8
+
+	d.FieldU8("major_linker_version")
+	d.FieldU8("minor_linker_version")
+	d.FieldU32("size_of_code")
+	d.FieldU32("size_of_initialized_data")
+	d.FieldU32("size_of_uninitialized_data")
+	d.FieldU32("address_of_entry_point", scalar.UintHex)
+	d.FieldU32("base_of_code", scalar.UintHex)
+	if magic == IMAGE_NT_OPTIONAL_HDR32_MAGIC {
+		d.FieldU32("base_of_data", scalar.UintHex)
+	}
+	pc.imageBase = d.FieldU("image_base", addrBits, scalar.UintHex)
+	d.FieldU32("section_alignment")
+	d.FieldU32("file_alignment")
+	d.FieldU16("major_operating_system_version")
+	d.FieldU16("minor_operating_system_version")
+	d.FieldU16("major_image_version")
+	d.FieldU16("minor_image_version")
+	d.FieldU16("major_subsystem_version")
+	d.FieldU16("minor_subsystem_version")
+	d.FieldU32("win32_version_value")
+	d.FieldU32("size_of_image")
+	pc.sizeOfHeaders = d.FieldU32("size_of_headers")
+	d.FieldU32("check_sum", scalar.UintHex)
+	d.FieldU16("subsystem", subsystemNames)
+	// 16 bit little endian flags, low byte first
+	d.FieldStruct("dll_characteristics", func(d *decode.D) {
+		d.FieldBool("force_integrity")
+		d.FieldBool("dynamic_base")
+		d.FieldBool("high_entropy_va")
+		d.FieldU5("reserved")
+		d.FieldBool("terminal_server_aware")
+		d.FieldBool("guard_cf")
+		d.FieldBool("wdm_driver")
+		d.FieldBool("appcontainer")
+		d.FieldBool("no_bind")
+		d.FieldBool("no_seh")
+		d.FieldBool("no_isolation")
+		d.FieldBool("nx_compat")
+	})
+	d.FieldU("size_of_stack_reserve", addrBits)
+	d.FieldU("size_of_stack_commit", addrBits)
+	d.FieldU("size_of_heap_reserve", addrBits)
+	d.FieldU("size_of_heap_commit", addrBits)
+	d.FieldU32("loader_flags")
+	numberOfRvaAndSizes := d.FieldU32("number_of_rva_and_sizes")
+	numberOfRvaAndSizes = mathex.Min(numberOfRvaAndSizes, uint64(d.BitsLeft()/(8*8)))
+
+	d.FieldArray("data_directories", func(d *decode.D) {
+		for i := 0; i < int(numberOfRvaAndSizes); i++ {
+			name, ok := dataDirectoryNames[i]
+			if !ok {
+				name = "data_directory"
+			}
+			d.FieldStruct(name, func(d *decode.D) {
+				pc.dataDirectories = append(pc.dataDirectories, dataDirectory{
+					virtualAddress: d.FieldU32("virtual_address", scalar.UintHex),
+					size:           d.FieldU32("size"),
+				})
+			})
+		}
+	})
+	if d.BitsLeft() > 0 {
+		d.FieldRawLen("unknown", d.BitsLeft())
+	}
+}
+
+func peDecodeDirectories(d *decode.D, pc *peContext) {
+	for i, dd := range pc.dataDirectories {
+		if dd.virtualAddress == 0 || dd.size == 0 {
+			continue
+		}
+		switch i {
+		case IMAGE_DIRECTORY_ENTRY_EXPORT:
+			peDecodeExports(d, pc, dd)
+		case IMAGE_DIRECTORY_ENTRY_IMPORT:
+			peDecodeImports(d, pc, dd)
+		case IMAGE_DIRECTORY_ENTRY_RESOURCE:
+			peDecodeResources(d, pc, dd)
+		case IMAGE_DIRECTORY_ENTRY_SECURITY:
+			peDecodeCertificates(d, pc, dd)
+		case IMAGE_DIRECTORY_ENTRY_BASERELOC:
+			peDecodeBaseRelocations(d, pc, dd)
+		case IMAGE_DIRECTORY_ENTRY_DEBUG:
+			peDecodeDebugDirectory(d, pc, dd)
+		case IMAGE_DIRECTORY_ENTRY_TLS:
+			peDecodeTLS(d, pc, dd)
+		case IMAGE_DIRECTORY_ENTRY_DELAY_IMPORT:
+			peDecodeDelayImports(d, pc, dd)
+		}
+	}
+}
+
+func peDecode(d *decode.D) any {
+	var pi format.PeIn
+	d.ArgAs(&pi)
+
+	d.Endian = decode.LittleEndian
+
+	pc := &peContext{}
+	pc.image = true
+	pc.disassemble = pi.Disassemble
+	pc.fileSize = d.Len() / 8
+
+	var lfanew int64
+	d.FieldStruct("dos_header", func(d *decode.D) {
+		lfanew = peDecodeDOSHeader(d)
+	})
+	if lfanew < dosHeaderSize || !pc.inFile(lfanew, 4+fileHeaderSize) {
+		d.Fatalf("invalid e_lfanew %d", lfanew)
+	}
+	if lfanew > dosHeaderSize {
+		d.FieldRawLen("dos_stub", (lfanew-dosHeaderSize)*8)
+	}
+	d.FieldRawLen("signature", 4*8, d.AssertBitBuf([]byte("PE\x00\x00")))
+	d.FieldStruct("coff_header", func(d *decode.D) {
+		coffDecodeHeader(d, &pc.coffContext)
+	})
+
+	optionalHeaderSize := int64(pc.sizeOfOptionalHeader)
+	if optionalHeaderSize > 0 {
+		d.FieldStruct("optional_header", func(d *decode.D) {
+			d.FramedFn(optionalHeaderSize*8, func(d *decode.D) {
+				peDecodeOptionalHeader(d, pc)
+			})
+		})
+	}
+
+	coffReadTables(d, &pc.coffContext, d.Pos()/8)
+	if pc.disassemble {
+		peReadAddrNames(d, pc)
+	}
+	coffDecodeSectionHeaders(d, &pc.coffContext)
+	coffDecodeSymbols(d, &pc.coffContext)
+	peDecodeDirectories(d, pc)
+
+	return nil
+}
@@ -0,0 +1,526 @@
+package pe
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+const (
+	exportDirectorySize       = 40
+	importDescriptorSize      = 20
+	delayImportDescriptorSize = 32
+	debugDirectorySize        = 28
+	certificateHeaderSize     = 8
+	baseRelocationBlockSize   = 8
+)
+
+const (
+	IMAGE_DEBUG_TYPE_CODEVIEW = 2
+	IMAGE_DEBUG_TYPE_REPRO    = 16
+)
+
+var debugTypeNames = scalar.UintMapSymStr{
+	0:                         "unknown",
+	1:                         "coff",
+	IMAGE_DEBUG_TYPE_CODEVIEW: "codeview",
+	3:                         "fpo",
+	4:                         "misc",
+	5:                         "exception",
+	6:                         "fixup",
+	7:                         "omap_to_src",
+	8:                         "omap_from_src",
+	9:                         "borland",
+	10:                        "reserved10",
+	11:                        "clsid",
+	12:                        "vc_feature",
+	13:                        "pogo",
+	14:                        "iltcg",
+	15:                        "mpx",
+	IMAGE_DEBUG_TYPE_REPRO:    "repro",
+	17:                        "embedded_portable_pdb",
+	19:                        "pdbchecksum",
+	20:                        "ex_dllcharacteristics",
+}
+
+var baseRelocationTypeNames = scalar.UintMapSymStr{
+	0:  "absolute",
+	1:  "high",
+	2:  "low",
+	3:  "highlow",
+	4:  "highadj",
+	5:  "machine_specific_5",
+	6:  "reserved",
+	7:  "machine_specific_7",
+	8:  "machine_specific_8",
+	9:  "machine_specific_9",
+	10: "dir64",
+}
+
+const WIN_CERT_TYPE_PKCS_SIGNED_DATA = 0x0002
+
+var certificateRevisionNames = scalar.UintMapSymStr{
+	0x0100: "revision_1_0",
+	0x0200: "revision_2_0",
+}
+
+var certificateTypeNames = scalar.UintMapSymStr{
+	0x0001:                         "x509",
+	WIN_CERT_TYPE_PKCS_SIGNED_DATA: "pkcs_signed_data",
+	0x0003:                         "reserved_1",
+	0x0004:                         "ts_stack_signed",
+}
+
+// peDecodeLookupTable decodes import lookup or name table entries at rva. For old style
+// delay imports base is image base as entries are virtual addresses.
+func peDecodeLookupTable(d *decode.D, pc *peContext, rva uint64, base uint64) {
+	if rva < base {
+		return
+	}
+	offset, ok := pc.rvaToOffset(rva - base)
+	if !ok {
+		return
+	}
+	entryBits := pc.addrSize * 8
+
+	pc.atOffset(d, offset, func(d *decode.D) {
+		d.FieldArray("entries", func(d *decode.D) {
+			for {
+				if v, ok := pc.readUint(d, d.Pos()/8, pc.addrSize); !ok || v == 0 {
+					break
+				}
+				d.FieldStruct("entry", func(d *decode.D) {
+					v := d.FieldU("value", entryBits, scalar.UintHex)
+					importByOrdinal := v>>(entryBits-1) == 1
+					d.FieldValueBool("import_by_ordinal", importByOrdinal)
+					if importByOrdinal {
+						d.FieldValueUint("ordinal", v&0xffff)
+						return
+					}
+					hintNameRVA := v & 0x7fff_ffff
+					if hintNameRVA < base {
+						return
+					}
+					if offset, ok := pc.rvaToOffset(hintNameRVA - base); ok && pc.inFile(offset, 2) {
+						pc.atOffset(d, offset, func(d *decode.D) {
+							d.FieldU16("hint")
+							d.FieldUTF8Null("name")
+						})
+					}
+				})
+			}
+		})
+	})
+}
+
+func isZero(bs []byte) bool {
+	for _, b := range bs {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func peDecodeExports(d *decode.D, pc *peContext, dd dataDirectory) {
+	offset, ok := pc.rvaToOffset(dd.virtualAddress)
+	if !ok || !pc.inFile(offset, exportDirectorySize) {
+		return
+	}
+
+	pc.atOffset(d, offset, func(d *decode.D) {
+		d.FieldStruct("exports", func(d *decode.D) {
+			d.FieldU32("export_flags")
+			d.FieldU32("time_date_stamp", scalar.UintActualUnixTime(time.RFC3339))
+			d.FieldU16("major_version")
+			d.FieldU16("minor_version")
+			d.FieldU32("name", rvaStrMapper{pc: pc, d: d})
+			ordinalBase := d.FieldU32("ordinal_base")
+			addressTableEntries := d.FieldU32("address_table_entries")
+			numberOfNamePointers := d.FieldU32("number_of_name_pointers")
+			exportAddressTableRVA := d.FieldU32("export_address_table_rva", scalar.UintHex)
+			namePointerRVA := d.FieldU32("name_pointer_rva", scalar.UintHex)
+			ordinalTableRVA := d.FieldU32("ordinal_table_rva", scalar.UintHex)
+
+			// name pointer and ordinal table are parallel arrays, ordinal table has
+			// index into export address table for each name
+			functionNames := map[uint64]string{}
+			namePointerOffset, namePointerOk := pc.rvaToOffset(namePointerRVA)
+			ordinalTableOffset, ordinalTableOk := pc.rvaToOffset(ordinalTableRVA)
+			namesOk := namePointerOk && ordinalTableOk &&
+				pc.inFile(namePointerOffset, int64(numberOfNamePointers*4)) &&
+				pc.inFile(ordinalTableOffset, int64(numberOfNamePointers*2))
+			if namesOk {
+				for i := uint64(0); i < numberOfNamePointers; i++ {
+					nameRVA, _ := pc.readUint(d, namePointerOffset+int64(i*4), 4)
+					index, _ := pc.readUint(d, ordinalTableOffset+int64(i*2), 2)
+					if name, ok := pc.rvaStr(d, nameRVA); ok {
+						functionNames[index] = name
+					}
+				}
+			}
+
+			if offset, ok := pc.rvaToOffset(exportAddressTableRVA); ok && pc.inFile(offset, int64(addressTableEntries*4)) {
+				pc.atOffset(d, offset, func(d *decode.D) {
+					d.FieldArray("functions", func(d *decode.D) {
+						for i := uint64(0); i < addressTableEntries; i++ {
+							d.FieldStruct("function", func(d *decode.D) {
+								rva := d.FieldU32("rva", scalar.UintHex)
+								d.FieldValueUint("ordinal", ordinalBase+i)
+								if name, ok := functionNames[i]; ok {
+									d.FieldValueStr("name", name)
+								}
+								// rva inside export directory is a forwarder string, ex: "NTDLL.RtlAllocateHeap"
+								if rva >= dd.virtualAddress && rva < dd.virtualAddress+dd.size {
+									if forwarder, ok := pc.rvaStr(d, rva); ok {
+										d.FieldValueStr("forwarder", forwarder)
+									}
+								}
+							})
+						}
+					})
+				})
+			}
+
+			if namesOk {
+				pc.atOffset(d, namePointerOffset, func(d *decode.D) {
+					d.FieldArray("name_pointers", func(d *decode.D) {
+						for i := uint64(0); i < numberOfNamePointers; i++ {
+							d.FieldU32("name", rvaStrMapper{pc: pc, d: d})
+						}
+					})
+				})
+				pc.atOffset(d, ordinalTableOffset, func(d *decode.D) {
+					d.FieldArray("ordinals", func(d *decode.D) {
+						for i := uint64(0); i < numberOfNamePointers; i++ {
+							d.FieldU16("ordinal")
+						}
+					})
+				})
+			}
+		})
+	})
+}
+
+func peDecodeImports(d *decode.D, pc *peContext, dd dataDirectory) {
+	offset, ok := pc.rvaToOffset(dd.virtualAddress)
+	if !ok {
+		return
+	}
+
+	pc.atOffset(d, offset, func(d *decode.D) {
+		d.FieldArray("imports", func(d *decode.D) {
+			for {
+				// ends with zero descriptor
+				if bs, ok := pc.readBytes(d, d.Pos()/8, importDescriptorSize); !ok || isZero(bs) {
+					break
+				}
+				d.FieldStruct("import", func(d *decode.D) {
+					importLookupTableRVA := d.FieldU32("import_lookup_table_rva", scalar.UintHex)
+					d.FieldU32("time_date_stamp")
+					d.FieldU32("forwarder_chain")
+					d.FieldU32("name", rvaStrMapper{pc: pc, d: d})
+					importAddressTableRVA := d.FieldU32("import_address_table_rva", scalar.UintHex)
+					// some linkers leave out lookup table, address table has same content until bound
+					if importLookupTableRVA == 0 {
+						importLookupTableRVA = importAddressTableRVA
+					}
+					peDecodeLookupTable(d, pc, importLookupTableRVA, 0)
+				})
+			}
+		})
+	})
+}
+
+// peReadAddrNames adds exported functions and import address table entries, as "__imp_<name>",
+// by virtual address to names used when disassembling
+func peReadAddrNames(d *decode.D, pc *peContext) {
+	addName := func(rva uint64, name string) {
+		addr := pc.imageBase + rva
+		if _, ok := pc.addrNames[addr]; !ok && name != "" {
+			pc.addrNames[addr] = name
+		}
+	}
+
+	if len(pc.dataDirectories) > IMAGE_DIRECTORY_ENTRY_EXPORT {
+		dd := pc.dataDirectories[IMAGE_DIRECTORY_ENTRY_EXPORT]
+		if offset, ok := pc.rvaToOffset(dd.virtualAddress); ok && pc.inFile(offset, exportDirectorySize) {
+			numberOfNamePointers, _ := pc.readUint(d, offset+24, 4)
+			exportAddressTableRVA, _ := pc.readUint(d, offset+28, 4)
+			namePointerRVA, _ := pc.readUint(d, offset+32, 4)
+			ordinalTableRVA, _ := pc.readUint(d, offset+36, 4)
+			eatOffset, eatOk := pc.rvaToOffset(exportAddressTableRVA)
+			namePointerOffset, namePointerOk := pc.rvaToOffset(namePointerRVA)
+			ordinalTableOffset, ordinalTableOk := pc.rvaToOffset(ordinalTableRVA)
+			for i := uint64(0); eatOk && namePointerOk && ordinalTableOk && i < numberOfNamePointers; i++ {
+				nameRVA, ok1 := pc.readUint(d, namePointerOffset+int64(i*4), 4)
+				index, ok2 := pc.readUint(d, ordinalTableOffset+int64(i*2), 2)
+				rva, ok3 := pc.readUint(d, eatOffset+int64(index*4), 4)
+				if !ok1 || !ok2 || !ok3 {
+					break
+				}
+				// skip forwarders
+				if rva >= dd.virtualAddress && rva < dd.virtualAddress+dd.size {
+					continue
+				}
+				if name, ok := pc.rvaStr(d, nameRVA); ok {
+					addName(rva, name)
+				}
+			}
+		}
+	}
+
+	if len(pc.dataDirectories) > IMAGE_DIRECTORY_ENTRY_IMPORT {
+		dd := pc.dataDirectories[IMAGE_DIRECTORY_ENTRY_IMPORT]
+		offset, ok := pc.rvaToOffset(dd.virtualAddress)
+		for ok {
+			bs, ok := pc.readBytes(d, offset, importDescriptorSize)
+			if !ok || isZero(bs) {
+				break
+			}
+			offset += importDescriptorSize
+			importLookupTableRVA := uint64(binary.LittleEndian.Uint32(bs[0:4]))
+			importAddressTableRVA := uint64(binary.LittleEndian.Uint32(bs[16:20]))
+			if importLookupTableRVA == 0 {
+				importLookupTableRVA = importAddressTableRVA
+			}
+			lookupOffset, ok := pc.rvaToOffset(importLookupTableRVA)
+			if !ok {
+				continue
+			}
+			entryBits := pc.addrSize * 8
+			for j := uint64(0); ; j++ {
+				v, ok := pc.readUint(d, lookupOffset+int64(j)*int64(pc.addrSize), pc.addrSize)
+				if !ok || v == 0 {
+					break
+				}
+				if v>>(entryBits-1) == 1 {
+					continue
+				}
+				if name, ok := pc.rvaStr(d, (v&0x7fff_ffff)+2); ok {
+					addName(importAddressTableRVA+j*uint64(pc.addrSize), "__imp_"+name)
+				}
+			}
+		}
+	}
+}
+
+func peDecodeDelayImports(d *decode.D, pc *peContext, dd dataDirectory) {
+	offset, ok := pc.rvaToOffset(dd.virtualAddress)
+	if !ok {
+		return
+	}
+
+	pc.atOffset(d, offset, func(d *decode.D) {
+		d.FieldArray("delay_imports", func(d *decode.D) {
+			for {
+				if bs, ok := pc.readBytes(d, d.Pos()/8, delayImportDescriptorSize); !ok || isZero(bs) {
+					break
+				}
+				d.FieldStruct("delay_import", func(d *decode.D) {
+					var base uint64
+					d.FieldStruct("attributes", func(d *decode.D) {
+						// 32 bit little endian, only lowest bit defined
+						d.FieldU7("reserved0")
+						if !d.FieldBool("rva_based") {
+							// old style uses virtual addresses
+							base = pc.imageBase
+						}
+						d.FieldU24("reserved1")
+					})
+					d.FieldU32("name", rvaStrMapper{pc: pc, d: d, base: base})
+					d.FieldU32("module_handle_rva", scalar.UintHex)
+					d.FieldU32("delay_import_address_table_rva", scalar.UintHex)
+					delayImportNameTableRVA := d.FieldU32("delay_import_name_table_rva", scalar.UintHex)
+					d.FieldU32("bound_delay_import_table_rva", scalar.UintHex)
+					d.FieldU32("unload_delay_import_table_rva", scalar.UintHex)
+					d.FieldU32("time_date_stamp")
+					peDecodeLookupTable(d, pc, delayImportNameTableRVA, base)
+				})
+			}
+		})
+	})
+}
+
+func peDecodeBaseRelocations(d *decode.D, pc *peContext, dd dataDirectory) {
+	offset, ok := pc.rvaToOffset(dd.virtualAddress)
+	if !ok {
+		return
+	}
+	size := mathex.Min(int64(dd.size), pc.fileSize-offset)
+
+	d.RangeFn(offset*8, size*8, func(d *decode.D) {
+		d.FieldArray("base_relocations", func(d *decode.D) {
+			for d.BitsLeft() >= baseRelocationBlockSize*8 {
+				var blockSize uint64
+				d.FieldStruct("block", func(d *decode.D) {
+					pageRVA := d.FieldU32("page_rva", scalar.UintHex)
+					blockSize = d.FieldU32("block_size")
+					if blockSize < baseRelocationBlockSize {
+						return
+					}
+					n := mathex.Min((blockSize-baseRelocationBlockSize)/2, uint64(d.BitsLeft()/16))
+					d.FieldArray("entries", func(d *decode.D) {
+						for i := uint64(0); i < n; i++ {
+							d.FieldStruct("entry", func(d *decode.D) {
+								// 16 bit little endian, 4 bit type and 12 bit offset
+								v := d.FieldU16("value", scalar.UintHex)
+								offset := v & 0xfff
+								d.FieldValueUint("type", v>>12, baseRelocationTypeNames)
+								d.FieldValueUint("offset", offset, scalar.UintHex)
+								d.FieldValueUint("rva", pageRVA+offset, scalar.UintHex)
+							})
+						}
+					})
+				})
+				if blockSize < baseRelocationBlockSize {
+					break
+				}
+			}
+		})
+	})
+}
+
+func peDecodeCodeView(d *decode.D) {
+	signature := d.FieldUTF8("signature", 4)
+	switch signature {
+	case "RSDS":
+		d.FieldRawLen("guid", 16*8, rawGUID)
+		d.FieldU32("age")
+		d.FieldUTF8NullFixedLen("pdb_file_name", int(d.BitsLeft()/8))
+	case "NB10":
+		d.FieldU32("offset")
+		d.FieldU32("signature_time_date_stamp", scalar.UintActualUnixTime(time.RFC3339))
+		d.FieldU32("age")
+		d.FieldUTF8NullFixedLen("pdb_file_name", int(d.BitsLeft()/8))
+	default:
+		d.FieldRawLen("data", d.BitsLeft())
+	}
+}
+
+func peDecodeDebugDirectory(d *decode.D, pc *peContext, dd dataDirectory) {
+	offset, ok := pc.rvaToOffset(dd.virtualAddress)
+	if !ok {
+		return
+	}
+	n := mathex.Min(int64(dd.size), pc.fileSize-offset) / debugDirectorySize
+
+	pc.atOffset(d, offset, func(d *decode.D) {
+		d.FieldArray("debug_directory", func(d *decode.D) {
+			for i := int64(0); i < n; i++ {
+				d.FieldStruct("entry", func(d *decode.D) {
+					d.FieldU32("characteristics")
+					d.FieldU32("time_date_stamp", scalar.UintActualUnixTime(time.RFC3339))
+					d.FieldU16("major_version")
+					d.FieldU16("minor_version")
+					typ := d.FieldU32("type", debugTypeNames)
+					sizeOfData := d.FieldU32("size_of_data")
+					d.FieldU32("address_of_raw_data", scalar.UintHex)
+					pointerToRawData := d.FieldU32("pointer_to_raw_data", scalar.UintHex)
+
+					if pointerToRawData == 0 || sizeOfData == 0 || !pc.inFile(int64(pointerToRawData), int64(sizeOfData)) {
+						return
+					}
+					d.RangeFn(int64(pointerToRawData)*8, int64(sizeOfData)*8, func(d *decode.D) {
+						switch {
+						case typ == IMAGE_DEBUG_TYPE_CODEVIEW && sizeOfData >= 4:
+							d.FieldStruct("codeview", peDecodeCodeView)
+						case typ == IMAGE_DEBUG_TYPE_REPRO && sizeOfData >= 4:
+							d.FieldStruct("repro", func(d *decode.D) {
+								hashSize := d.FieldU32("hash_size")
+								d.FieldRawLen("hash", mathex.Min(int64(hashSize)*8, d.BitsLeft()))
+								if d.BitsLeft() > 0 {
+									d.FieldRawLen("unknown", d.BitsLeft())
+								}
+							})
+						default:
+							d.FieldRawLen("data", d.BitsLeft())
+						}
+					})
+				})
+			}
+		})
+	})
+}
+
+func peDecodeTLS(d *decode.D, pc *peContext, dd dataDirectory) {
+	offset, ok := pc.rvaToOffset(dd.virtualAddress)
+	if !ok || !pc.inFile(offset, int64(4*pc.addrSize+8)) {
+		return
+	}
+	addrBits := pc.addrSize * 8
+
+	pc.atOffset(d, offset, func(d *decode.D) {
+		d.FieldStruct("tls", func(d *decode.D) {
+			d.FieldU("start_address_of_raw_data", addrBits, scalar.UintHex)
+			d.FieldU("end_address_of_raw_data", addrBits, scalar.UintHex)
+			d.FieldU("address_of_index", addrBits, scalar.UintHex)
+			addressOfCallbacks := d.FieldU("address_of_callbacks", addrBits, scalar.UintHex)
+			d.FieldU32("size_of_zero_fill")
+			d.FieldU32("characteristics", scalar.UintHex)
+
+			// null terminated array of virtual addresses
+			callbacksOffset, ok := pc.vaToOffset(addressOfCallbacks)
+			if !ok {
+				return
+			}
+			pc.atOffset(d, callbacksOffset, func(d *decode.D) {
+				d.FieldArray("callbacks", func(d *decode.D) {
+					for {
+						if v, ok := pc.readUint(d, d.Pos()/8, pc.addrSize); !ok || v == 0 {
+							break
+						}
+						d.FieldU("callback", addrBits, scalar.UintHex)
+					}
+				})
+			})
+		})
+	})
+}
+
+// certificate table uses file offset instead of rva and entries are 8 byte aligned
+func peDecodeCertificates(d *decode.D, pc *peContext, dd dataDirectory) {
+	offset := int64(dd.virtualAddress)
+	if !pc.inFile(offset, 0) {
+		return
+	}
+	size := mathex.Min(int64(dd.size), pc.fileSize-offset)
+
+	d.RangeFn(offset*8, size*8, func(d *decode.D) {
+		d.FieldArray("certificates", func(d *decode.D) {
+			for d.BitsLeft() >= certificateHeaderSize*8 {
+				valid := true
+				d.FieldStruct("certificate", func(d *decode.D) {
+					length := int64(d.FieldU32("length"))
+					d.FieldU16("revision", certificateRevisionNames, scalar.UintHex)
+					typ := d.FieldU16("certificate_type", certificateTypeNames)
+					dataLength := length - certificateHeaderSize
+					if dataLength < 0 || dataLength*8 > d.BitsLeft() {
+						valid = false
+						d.FieldRawLen("data", d.BitsLeft())
+						return
+					}
+
+					switch typ {
+					case WIN_CERT_TYPE_PKCS_SIGNED_DATA:
+						if dv, _, _ := d.TryFieldFormatLen("certificate", dataLength*8, asn1BerFormat, nil); dv == nil {
+							d.FieldRawLen("certificate", dataLength*8)
+						}
+					default:
+						d.FieldRawLen("certificate", dataLength*8)
+					}
+
+					if paddingLength := mathex.Min(int64((8-length%8)%8)*8, d.BitsLeft()); paddingLength > 0 {
+						d.FieldRawLen("padding", paddingLength)
+					}
+				})
+				if !valid {
+					break
+				}
+			}
+		})
+	})
+}
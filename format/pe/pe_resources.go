@@ -0,0 +1,271 @@
+package pe
+
+// https://learn.microsoft.com/en-us/windows/win32/debug/pe-format#the-rsrc-section
+// https://learn.microsoft.com/en-us/windows/win32/menurc/vs-versioninfo
+
+import (
+	"fmt"
+
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+const (
+	RT_VERSION  = 16
+	RT_MANIFEST = 24
+)
+
+var resourceTypeNames = scalar.UintMapSymStr{
+	1:           "cursor",
+	2:           "bitmap",
+	3:           "icon",
+	4:           "menu",
+	5:           "dialog",
+	6:           "string",
+	7:           "fontdir",
+	8:           "font",
+	9:           "accelerator",
+	10:          "rcdata",
+	11:          "messagetable",
+	12:          "group_cursor",
+	14:          "group_icon",
+	RT_VERSION:  "version",
+	17:          "dlginclude",
+	19:          "plugplay",
+	20:          "vxd",
+	21:          "anicursor",
+	22:          "aniicon",
+	23:          "html",
+	RT_MANIFEST: "manifest",
+}
+
+// tree is normally type, name and language
+const maxResourceDepth = 8
+
+const resourceDirectorySize = 16
+
+func peDecodeResourceData(d *decode.D, typ uint64) {
+	switch typ {
+	case RT_VERSION:
+		if dv, _, _ := d.TryFieldFormatLen("version_info", d.BitsLeft(), versionInfoFormat, nil); dv != nil {
+			return
+		}
+	case RT_MANIFEST:
+		d.FieldUTF8("manifest", int(d.BitsLeft()/8))
+		return
+	}
+	d.FieldRawLen("data", d.BitsLeft())
+}
+
+// peDecodeResourceDirectory decodes directory at current position, offsets are relative to
+// resource table start. typ is resource type from first level.
+func peDecodeResourceDirectory(d *decode.D, pc *peContext, tableOffset int64, depth int, typ uint64) {
+	d.FieldU32("characteristics")
+	d.FieldU32("time_date_stamp")
+	d.FieldU16("major_version")
+	d.FieldU16("minor_version")
+	numberOfNameEntries := d.FieldU16("number_of_name_entries")
+	numberOfIDEntries := d.FieldU16("number_of_id_entries")
+	n := numberOfNameEntries + numberOfIDEntries
+	if !pc.inFile(d.Pos()/8, int64(n*8)) {
+		return
+	}
+
+	d.FieldArray("entries", func(d *decode.D) {
+		for i := uint64(0); i < n; i++ {
+			d.FieldStruct("entry", func(d *decode.D) {
+				entryType := typ
+				// high bit set for name instead of id
+				if nameOrID, _ := pc.readUint(d, d.Pos()/8, 4); nameOrID&0x8000_0000 != 0 {
+					d.FieldU32("name_offset", scalar.UintHex)
+					nameOffset := tableOffset + int64(nameOrID&0x7fff_ffff)
+					if pc.inFile(nameOffset, 2) {
+						pc.atOffset(d, nameOffset, func(d *decode.D) {
+							length := d.FieldU16("name_length")
+							d.FieldUTF16LE("name", int(length)*2)
+						})
+					}
+				} else if depth == 0 {
+					entryType = d.FieldU32("id", resourceTypeNames)
+				} else {
+					d.FieldU32("id")
+				}
+
+				// high bit set for subdirectory instead of data entry
+				offset := d.FieldU32("offset", scalar.UintHex)
+				entryOffset := tableOffset + int64(offset&0x7fff_ffff)
+				if offset&0x8000_0000 != 0 {
+					if depth+1 >= maxResourceDepth || !pc.inFile(entryOffset, resourceDirectorySize) {
+						return
+					}
+					pc.atOffset(d, entryOffset, func(d *decode.D) {
+						d.FieldStruct("directory", func(d *decode.D) {
+							peDecodeResourceDirectory(d, pc, tableOffset, depth+1, entryType)
+						})
+					})
+					return
+				}
+
+				if !pc.inFile(entryOffset, 16) {
+					return
+				}
+				pc.atOffset(d, entryOffset, func(d *decode.D) {
+					d.FieldStruct("data_entry", func(d *decode.D) {
+						dataRVA := d.FieldU32("data_rva", scalar.UintHex)
+						size := d.FieldU32("size")
+						d.FieldU32("code_page")
+						d.FieldU32("reserved")
+
+						dataOffset, ok := pc.rvaToOffset(dataRVA)
+						if !ok || size == 0 || !pc.inFile(dataOffset, int64(size)) {
+							return
+						}
+						d.RangeFn(dataOffset*8, int64(size)*8, func(d *decode.D) {
+							peDecodeResourceData(d, entryType)
+						})
+					})
+				})
+			})
+		}
+	})
+}
+
+func peDecodeResources(d *decode.D, pc *peContext, dd dataDirectory) {
+	offset, ok := pc.rvaToOffset(dd.virtualAddress)
+	if !ok || !pc.inFile(offset, resourceDirectorySize) {
+		return
+	}
+
+	pc.atOffset(d, offset, func(d *decode.D) {
+		d.FieldStruct("resources", func(d *decode.D) {
+			peDecodeResourceDirectory(d, pc, offset, 0, 0)
+		})
+	})
+}
+
+const VS_FFI_SIGNATURE = 0xfeef04bd
+
+var versionInfoValueTypeNames = scalar.UintMapSymStr{
+	0: "binary",
+	1: "text",
+}
+
+var fileOSNames = scalar.UintMapSymStr{
+	0x0000_0000: "unknown",
+	0x0000_0001: "windows16",
+	0x0000_0002: "pm16",
+	0x0000_0003: "pm32",
+	0x0000_0004: "windows32",
+	0x0001_0000: "dos",
+	0x0001_0001: "dos_windows16",
+	0x0001_0004: "dos_windows32",
+	0x0002_0000: "os216",
+	0x0003_0000: "os232",
+	0x0004_0000: "nt",
+	0x0004_0004: "nt_windows32",
+}
+
+var fileTypeNames = scalar.UintMapSymStr{
+	0: "unknown",
+	1: "app",
+	2: "dll",
+	3: "drv",
+	4: "font",
+	5: "vxd",
+	7: "static_lib",
+}
+
+const versionInfoHeaderSize = 6
+
+var versionInfoFormat = decode.FormatFn(func(d *decode.D) any {
+	d.Endian = decode.LittleEndian
+	versionInfoDecodeNode(d, 0)
+	return nil
+})
+
+// structures are 32 bit aligned relative to start of version resource
+func versionInfoAlign(d *decode.D, name string) {
+	if n := (32 - d.Pos()%32) % 32; n > 0 && d.BitsLeft() >= n {
+		d.FieldRawLen(name, n)
+	}
+}
+
+func versionInfoDecodeFixedFileInfo(d *decode.D) {
+	d.FieldU32("signature", scalar.UintHex, d.UintAssert(VS_FFI_SIGNATURE))
+	d.FieldU32("struc_version", scalar.UintHex)
+	fileVersionMS := d.FieldU32("file_version_ms", scalar.UintHex)
+	fileVersionLS := d.FieldU32("file_version_ls", scalar.UintHex)
+	d.FieldValueStr("file_version", versionString(fileVersionMS, fileVersionLS))
+	productVersionMS := d.FieldU32("product_version_ms", scalar.UintHex)
+	productVersionLS := d.FieldU32("product_version_ls", scalar.UintHex)
+	d.FieldValueStr("product_version", versionString(productVersionMS, productVersionLS))
+	d.FieldU32("file_flags_mask", scalar.UintHex)
+	d.FieldU32("file_flags", scalar.UintHex)
+	d.FieldU32("file_os", fileOSNames, scalar.UintHex)
+	d.FieldU32("file_type", fileTypeNames)
+	d.FieldU32("file_subtype")
+	d.FieldU32("file_date_ms")
+	d.FieldU32("file_date_ls")
+}
+
+func versionString(ms uint64, ls uint64) string {
+	return fmt.Sprintf("%d.%d.%d.%d", ms>>16, ms&0xffff, ls>>16, ls&0xffff)
+}
+
+// versionInfoDecodeNode decodes VS_VERSIONINFO and its StringFileInfo, StringTable, String,
+// VarFileInfo and Var children that all share the same layout
+func versionInfoDecodeNode(d *decode.D, depth int) {
+	length := d.FieldU16("length")
+	if length < versionInfoHeaderSize || int64(length)*8-16 > d.BitsLeft() {
+		d.Fatalf("invalid length %d", length)
+	}
+
+	d.FramedFn(int64(length)*8-16, func(d *decode.D) {
+		valueLength := int64(d.FieldU16("value_length"))
+		typ := d.FieldU16("type", versionInfoValueTypeNames)
+		key := d.FieldUTF16LENull("key")
+		versionInfoAlign(d, "key_padding")
+
+		if valueLength > 0 {
+			switch {
+			case key == "VS_VERSION_INFO":
+				d.FieldStruct("value", versionInfoDecodeFixedFileInfo)
+			case typ == 1:
+				// text length is in 16 bit words
+				d.FieldUTF16LE("value", int(mathex.Min(valueLength*2, d.BitsLeft()/8)), scalar.StrActualTrim("\x00"))
+			case key == "Translation":
+				d.FieldArray("value", func(d *decode.D) {
+					for i := int64(0); i < valueLength/4; i++ {
+						d.FieldStruct("translation", func(d *decode.D) {
+							d.FieldU16("language", scalar.UintHex)
+							d.FieldU16("code_page")
+						})
+					}
+				})
+			default:
+				d.FieldRawLen("value", mathex.Min(valueLength*8, d.BitsLeft()))
+			}
+			versionInfoAlign(d, "value_padding")
+		}
+
+		if d.BitsLeft() == 0 {
+			return
+		}
+		if depth >= maxResourceDepth {
+			d.FieldRawLen("children", d.BitsLeft())
+			return
+		}
+		d.FieldArray("children", func(d *decode.D) {
+			for d.BitsLeft() >= versionInfoHeaderSize*8 {
+				d.FieldStruct("child", func(d *decode.D) {
+					versionInfoDecodeNode(d, depth+1)
+					versionInfoAlign(d, "padding")
+				})
+			}
+		})
+		if d.BitsLeft() > 0 {
+			d.FieldRawLen("unknown", d.BitsLeft())
+		}
+	})
+}
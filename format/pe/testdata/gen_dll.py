@@ -0,0 +1,391 @@
+#!/usr/bin/env python3
+# Generates test.dll, a minimal PE32+ amd64 DLL with export, import and delay import
+# tables, resources (version info and manifest), debug directory, base relocations and
+# a PKCS#7 certificate table. No linker for windows targets is needed.
+#
+# Usage: python3 gen_dll.py (requires openssl)
+
+import os
+import struct
+import subprocess
+import tempfile
+
+IMAGE_BASE = 0x1_8000_0000
+FILE_ALIGN = 0x200
+SECTION_ALIGN = 0x1000
+HEADERS_SIZE = 0x400
+TIME_DATE_STAMP = 0x6543_2100
+
+
+def align(n, a):
+    return (n + a - 1) // a * a
+
+
+class Section:
+    def __init__(self, name, rva, characteristics):
+        self.name = name
+        self.rva = rva
+        self.characteristics = characteristics
+        self.data = bytearray()
+        self.pointer = 0
+
+    def here(self):
+        return self.rva + len(self.data)
+
+    def align(self, a):
+        self.data += b"\x00" * (align(len(self.data), a) - len(self.data))
+
+    def add(self, b, a=1):
+        self.align(a)
+        rva = self.here()
+        self.data += b
+        return rva
+
+    def reserve(self, n, a=1):
+        return self.add(b"\x00" * n, a)
+
+    def patch(self, rva, b):
+        o = rva - self.rva
+        self.data[o : o + len(b)] = b
+
+    def cstr(self, s):
+        return self.add(s + b"\x00")
+
+    def hint_name(self, hint, name):
+        return self.add(struct.pack("<H", hint) + name + b"\x00", 2)
+
+    def offset(self, rva):
+        return self.pointer + rva - self.rva
+
+
+text = Section(b".text", 0x1000, 0x6000_0020)
+rdata = Section(b".rdata", 0x2000, 0x4000_0040)
+data = Section(b".data", 0x3000, 0xC000_0040)
+rsrc = Section(b".rsrc", 0x4000, 0x4000_0040)
+reloc = Section(b".reloc", 0x5000, 0x4200_0040)
+sections = [text, rdata, data, rsrc, reloc]
+
+# .text
+add_rva = text.add(bytes.fromhex("8d0411c3"), 16)  # lea eax,[rcx+rdx]; ret
+sub_rva = text.add(bytes.fromhex("89c829d0c3"), 16)  # mov eax,ecx; sub eax,edx; ret
+zero_rva = text.add(bytes.fromhex("31c0c3"), 16)  # xor eax,eax; ret
+entry_rva = text.add(bytes.fromhex("b801000000c3"), 16)  # mov eax,1; ret
+
+# .data, pointer that needs base relocation followed by import address tables
+ptr_rva = data.add(struct.pack("<Q", IMAGE_BASE + add_rva))
+
+# export table, forwarder string has to be inside the export directory
+export_rva = rdata.reserve(40, 4)
+export_address_table = rdata.reserve(4 * 4, 4)
+name_pointer_table = rdata.reserve(4 * 3, 4)
+ordinal_table = rdata.reserve(2 * 3, 2)
+dll_name = rdata.cstr(b"test.dll")
+name_add = rdata.cstr(b"add")
+name_sleep = rdata.cstr(b"sleep")
+name_sub = rdata.cstr(b"sub")
+forwarder = rdata.cstr(b"KERNEL32.Sleep")
+export_size = rdata.here() - export_rva
+rdata.patch(
+    export_rva,
+    struct.pack(
+        "<IIHHIIIIIII",
+        0,
+        TIME_DATE_STAMP,
+        0,
+        0,
+        dll_name,
+        1,
+        4,
+        3,
+        export_address_table,
+        name_pointer_table,
+        ordinal_table,
+    ),
+)
+# ordinal 4 is exported by ordinal only
+rdata.patch(export_address_table, struct.pack("<IIII", add_rva, sub_rva, forwarder, zero_rva))
+# names sorted for binary search
+rdata.patch(name_pointer_table, struct.pack("<III", name_add, name_sleep, name_sub))
+rdata.patch(ordinal_table, struct.pack("<HHH", 0, 2, 1))
+
+# import table
+import_rva = rdata.reserve(20 * 3, 4)
+import_size = 20 * 3
+kernel32_ilt = rdata.reserve(8 * 3, 8)
+ws2_32_ilt = rdata.reserve(8 * 2, 8)
+hint_name_sleep = rdata.hint_name(0x5A6, b"Sleep")
+hint_name_exit_process = rdata.hint_name(0x164, b"ExitProcess")
+kernel32_name = rdata.cstr(b"KERNEL32.dll")
+ws2_32_name = rdata.cstr(b"WS2_32.dll")
+kernel32_entries = struct.pack("<QQQ", hint_name_sleep, hint_name_exit_process, 0)
+# import by ordinal 115, WSAStartup
+ws2_32_entries = struct.pack("<QQ", (1 << 63) | 115, 0)
+rdata.patch(kernel32_ilt, kernel32_entries)
+rdata.patch(ws2_32_ilt, ws2_32_entries)
+iat_rva = data.add(kernel32_entries, 8)
+kernel32_iat = iat_rva
+ws2_32_iat = data.add(ws2_32_entries, 8)
+iat_size = data.here() - iat_rva
+rdata.patch(
+    import_rva,
+    struct.pack("<IIIII", kernel32_ilt, 0, 0, kernel32_name, kernel32_iat)
+    + struct.pack("<IIIII", ws2_32_ilt, 0, 0, ws2_32_name, ws2_32_iat),
+)
+
+# delay import table
+delay_import_rva = rdata.reserve(32 * 2, 4)
+delay_import_size = 32 * 2
+user32_int = rdata.reserve(8 * 2, 8)
+hint_name_message_box = rdata.hint_name(0, b"MessageBoxA")
+user32_name = rdata.cstr(b"USER32.dll")
+user32_entries = struct.pack("<QQ", hint_name_message_box, 0)
+rdata.patch(user32_int, user32_entries)
+user32_module_handle = data.reserve(8, 8)
+user32_iat = data.add(struct.pack("<QQ", IMAGE_BASE + entry_rva, 0), 8)
+rdata.patch(
+    delay_import_rva,
+    struct.pack("<IIIIIIII", 1, user32_name, user32_module_handle, user32_iat, user32_int, 0, 0, 0),
+)
+
+# debug directory, data is patched when file offsets are known
+debug_rva = rdata.reserve(28 * 2, 4)
+debug_size = 28 * 2
+guid = bytes.fromhex("78563412" "3412" "7856" "0102030405060708")
+codeview = b"RSDS" + guid + struct.pack("<I", 1) + b"C:\\src\\test\\test.pdb\x00"
+codeview_rva = rdata.add(codeview, 4)
+repro = struct.pack("<I", 32) + bytes(range(32))
+repro_rva = rdata.add(repro, 4)
+
+
+# resources
+def utf16z(s):
+    return s.encode("utf-16-le") + b"\x00\x00"
+
+
+def version_node(key, value=b"", value_length=0, typ=0, children=()):
+    b = bytearray(6) + utf16z(key)
+    b += b"\x00" * (align(len(b), 4) - len(b))
+    b += value
+    for c in children:
+        b += b"\x00" * (align(len(b), 4) - len(b))
+        b += c
+    struct.pack_into("<HHH", b, 0, len(b), value_length, typ)
+    return bytes(b)
+
+
+def version_string(key, value):
+    v = utf16z(value)
+    return version_node(key, v, len(v) // 2, 1)
+
+
+fixed_file_info = struct.pack(
+    "<13I",
+    0xFEEF04BD,
+    0x0001_0000,
+    (1 << 16) | 2,
+    (3 << 16) | 4,
+    (1 << 16) | 2,
+    (3 << 16) | 4,
+    0x3F,
+    0,
+    0x0004_0004,
+    2,
+    0,
+    0,
+    0,
+)
+version_info = version_node(
+    "VS_VERSION_INFO",
+    fixed_file_info,
+    len(fixed_file_info),
+    0,
+    [
+        version_node(
+            "StringFileInfo",
+            typ=1,
+            children=[
+                version_node(
+                    "040904b0",
+                    typ=1,
+                    children=[
+                        version_string("CompanyName", "fq"),
+                        version_string("FileDescription", "fq test DLL"),
+                        version_string("FileVersion", "1.2.3.4"),
+                        version_string("ProductName", "fq"),
+                    ],
+                )
+            ],
+        ),
+        version_node(
+            "VarFileInfo",
+            typ=1,
+            children=[version_node("Translation", struct.pack("<HH", 0x409, 1200), 4, 0)],
+        ),
+    ],
+)
+manifest = (
+    b'<?xml version="1.0" encoding="UTF-8" standalone="yes"?>\r\n'
+    b'<assembly xmlns="urn:schemas-microsoft-com:asm.v1" manifestVersion="1.0">\r\n'
+    b'  <assemblyIdentity name="fq.test" version="1.2.3.4" type="win32"/>\r\n'
+    b"</assembly>\r\n"
+)
+text_data = b"hello"
+
+
+def resource_directory(named, ids):
+    return struct.pack("<IIHHHH", 0, 0, 0, 0, named, ids)
+
+
+# tree is type/name/language, one named type and two types by id
+LANG_EN_US = 0x409
+resources = [
+    ("TEXT", 1, text_data),
+    (16, 1, version_info),
+    (24, 2, manifest),
+]
+root = rsrc.reserve(16 + 8 * len(resources), 4)
+type_dirs = [rsrc.reserve(16 + 8, 4) for _ in resources]
+lang_dirs = [rsrc.reserve(16 + 8, 4) for _ in resources]
+data_entries = [rsrc.reserve(16, 4) for _ in resources]
+type_name = rsrc.add(struct.pack("<H", 4) + "TEXT".encode("utf-16-le"), 2)
+data_rvas = [rsrc.add(r[2], 4) for r in resources]
+
+
+def rel(rva):
+    return rva - rsrc.rva
+
+
+root_entries = b""
+for i, (typ, name, _) in enumerate(resources):
+    type_id = (0x8000_0000 | rel(type_name)) if isinstance(typ, str) else typ
+    root_entries += struct.pack("<II", type_id, 0x8000_0000 | rel(type_dirs[i]))
+    rsrc.patch(type_dirs[i], resource_directory(0, 1) + struct.pack("<II", name, 0x8000_0000 | rel(lang_dirs[i])))
+    rsrc.patch(lang_dirs[i], resource_directory(0, 1) + struct.pack("<II", LANG_EN_US, rel(data_entries[i])))
+    rsrc.patch(data_entries[i], struct.pack("<IIII", data_rvas[i], len(resources[i][2]), 0, 0))
+rsrc.patch(root, resource_directory(1, len(resources) - 1) + root_entries)
+
+# base relocations, one dir64 and absolute entry for padding
+base_relocations = struct.pack("<IIHH", ptr_rva & ~0xFFF, 12, (10 << 12) | (ptr_rva & 0xFFF), 0)
+reloc.add(base_relocations)
+
+# layout
+pointer = HEADERS_SIZE
+for s in sections:
+    s.pointer = pointer
+    pointer += align(len(s.data), FILE_ALIGN)
+file_end = pointer
+
+rdata.patch(
+    debug_rva,
+    struct.pack("<IIHHIIII", 0, TIME_DATE_STAMP, 0, 0, 2, len(codeview), codeview_rva, rdata.offset(codeview_rva))
+    + struct.pack("<IIHHIIII", 0, TIME_DATE_STAMP, 0, 0, 16, len(repro), repro_rva, rdata.offset(repro_rva)),
+)
+
+# degenerate PKCS#7 signed data with only a self signed certificate
+with tempfile.TemporaryDirectory() as tmp:
+    key = os.path.join(tmp, "key.pem")
+    cert = os.path.join(tmp, "cert.pem")
+    p7 = os.path.join(tmp, "cert.p7")
+    subprocess.run(
+        [
+            "openssl", "req", "-x509", "-newkey", "ec", "-pkeyopt", "ec_paramgen_curve:prime256v1",
+            "-nodes", "-subj", "/CN=fq test", "-days", "1", "-keyout", key, "-out", cert,
+        ],
+        check=True,
+        capture_output=True,
+    )
+    subprocess.run(
+        ["openssl", "crl2pkcs7", "-nocrl", "-certfile", cert, "-outform", "DER", "-out", p7],
+        check=True,
+        capture_output=True,
+    )
+    with open(p7, "rb") as f:
+        pkcs7 = f.read()
+win_certificate = struct.pack("<IHH", 8 + len(pkcs7), 0x0200, 2) + pkcs7
+win_certificate += b"\x00" * (align(len(win_certificate), 8) - len(win_certificate))
+
+data_directories = [
+    (export_rva, export_size),
+    (import_rva, import_size),
+    (rsrc.rva, len(rsrc.data)),
+    (0, 0),
+    (file_end, len(win_certificate)),
+    (reloc.rva, len(reloc.data)),
+    (debug_rva, debug_size),
+    (0, 0),
+    (0, 0),
+    (0, 0),
+    (0, 0),
+    (0, 0),
+    (iat_rva, iat_size),
+    (delay_import_rva, delay_import_size),
+    (0, 0),
+    (0, 0),
+]
+
+size_of_image = align(sections[-1].rva + len(sections[-1].data), SECTION_ALIGN)
+optional_header = struct.pack(
+    "<HBBIIIIIQIIHHHHHHIIIIHHQQQQII",
+    0x20B,
+    14,
+    0,
+    align(len(text.data), FILE_ALIGN),
+    sum(align(len(s.data), FILE_ALIGN) for s in sections[1:]),
+    0,
+    entry_rva,
+    text.rva,
+    IMAGE_BASE,
+    SECTION_ALIGN,
+    FILE_ALIGN,
+    6,
+    0,
+    1,
+    2,
+    6,
+    0,
+    0,
+    size_of_image,
+    HEADERS_SIZE,
+    0,
+    2,
+    0x0160,
+    0x10_0000,
+    0x1000,
+    0x10_0000,
+    0x1000,
+    0,
+    len(data_directories),
+) + b"".join(struct.pack("<II", va, size) for va, size in data_directories)
+
+dos_stub = bytes.fromhex("0e1fba0e00b409cd21b8014ccd21") + b"This program cannot be run in DOS mode.\r\r\n$"
+dos_stub += b"\x00" * (0x40 - len(dos_stub))
+e_lfanew = 0x40 + len(dos_stub)
+dos_header = struct.pack("<2s13H8sHH20sI", b"MZ", 0x90, 3, 0, 4, 0, 0xFFFF, 0, 0xB8, 0, 0, 0, 0x40, 0, b"", 0, 0, b"", e_lfanew)
+
+coff_header = struct.pack("<HHIIIHH", 0x8664, len(sections), TIME_DATE_STAMP, 0, 0, len(optional_header), 0x2022)
+
+section_headers = b"".join(
+    struct.pack(
+        "<8sIIIIIIHHI",
+        s.name,
+        len(s.data),
+        s.rva,
+        align(len(s.data), FILE_ALIGN),
+        s.pointer,
+        0,
+        0,
+        0,
+        0,
+        s.characteristics,
+    )
+    for s in sections
+)
+
+headers = dos_header + dos_stub + b"PE\x00\x00" + coff_header + optional_header + section_headers
+out = headers + b"\x00" * (HEADERS_SIZE - len(headers))
+for s in sections:
+    out += s.data + b"\x00" * (align(len(s.data), FILE_ALIGN) - len(s.data))
+out += win_certificate
+
+with open("test.dll", "wb") as f:
+    f.write(out)
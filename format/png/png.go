@@ -6,6 +6,7 @@ package png
 
 import (
 	"compress/zlib"
+	"embed"
 	"hash/crc32"
 
 	"github.com/wader/fq/format"
@@ -18,17 +19,24 @@ import (
 var iccProfileFormat decode.Group
 var exifFormat decode.Group
 
+//go:embed png.md
+var pngFS embed.FS
+
 func init() {
 	interp.RegisterFormat(decode.Format{
 		Name:        format.PNG,
 		Description: "Portable Network Graphics file",
 		Groups:      []string{format.PROBE, format.IMAGE},
 		DecodeFn:    pngDecode,
+		DefaultInArg: format.PngIn{
+			Unfilter: false,
+		},
 		Dependencies: []decode.Dependency{
 			{Names: []string{format.ICC_PROFILE}, Group: &iccProfileFormat},
 			{Names: []string{format.EXIF}, Group: &exifFormat},
 		},
 	})
+	interp.RegisterFS(pngFS)
 }
 
 const (
@@ -77,9 +85,22 @@ var colorTypeMap = scalar.UintMapSymStr{
 	colorTypeRGBA:               "rgba",
 }
 
+type apngFrame struct {
+	width  int
+	height int
+	data   []byte
+}
+
 func pngDecode(d *decode.D) any {
+	var pi format.PngIn
+	d.ArgAs(&pi)
+
 	iEndFound := false
 	var colorType uint64
+	var h imageHeader
+	var idat []byte
+	var frames []*apngFrame
+	var frame *apngFrame
 
 	d.FieldRawLen("signature", 8*8, d.AssertBitBuf([]byte("\x89PNG\r\n\x1a\n")))
 	d.FieldStructArrayLoop("chunks", "chunk", func() bool { return d.NotEnd() && !iEndFound }, func(d *decode.D) {
@@ -101,17 +122,18 @@ func pngDecode(d *decode.D) any {
 		d.FramedFn(int64(chunkLength)*8, func(d *decode.D) {
 			switch chunkType {
 			case "IHDR":
-				d.FieldU32("width")
-				d.FieldU32("height")
-				d.FieldU8("bit_depth")
+				h.width = int(d.FieldU32("width"))
+				h.height = int(d.FieldU32("height"))
+				h.bitDepth = int(d.FieldU8("bit_depth"))
 				colorType = d.FieldU8("color_type", colorTypeMap)
+				h.colorType = colorType
 				d.FieldU8("compression_method", compressionNames)
 				d.FieldU8("filter_method", scalar.UintMapSymStr{
 					0: "adaptive_filtering",
 				})
-				d.FieldU8("interlace_method", scalar.UintMapSymStr{
-					0: "none",
-					1: "adam7",
+				h.interlaceMethod = d.FieldU8("interlace_method", scalar.UintMapSymStr{
+					interlaceMethodNone:  "none",
+					interlaceMethodAdam7: "adam7",
 				})
 			case "tEXt":
 				d.FieldUTF8Null("keyword")
@@ -182,8 +204,10 @@ func pngDecode(d *decode.D) any {
 				d.FieldU32("num_plays")
 			case "fcTL":
 				d.FieldU32("sequence_number")
-				d.FieldU32("width")
-				d.FieldU32("height")
+				frame = &apngFrame{}
+				frames = append(frames, frame)
+				frame.width = int(d.FieldU32("width"))
+				frame.height = int(d.FieldU32("height"))
 				d.FieldU32("x_offset")
 				d.FieldU32("y_offset")
 				d.FieldU16("delay_num")
@@ -192,14 +216,21 @@ func pngDecode(d *decode.D) any {
 				d.FieldU8("blend_op", blendOpNames)
 			case "fdAT":
 				d.FieldU32("sequence_number")
-				d.FieldRawLen("data", d.BitsLeft()-32)
+				data := d.ReadAllBits(d.FieldRawLen("data", d.BitsLeft()))
+				if frame != nil {
+					frame.data = append(frame.data, data...)
+				}
+			case "IDAT":
+				idat = append(idat, d.ReadAllBits(d.FieldRawLen("data", d.BitsLeft()))...)
 			case "PLTE":
 				d.FieldArray("palette", func(d *decode.D) {
 					for !d.End() {
 						d.FieldStruct("color", func(d *decode.D) {
-							d.FieldU8("r")
-							d.FieldU8("g")
-							d.FieldU8("b")
+							h.palette = append(h.palette, [3]byte{
+								byte(d.FieldU8("r")),
+								byte(d.FieldU8("g")),
+								byte(d.FieldU8("b")),
+							})
 						})
 					}
 				})
@@ -214,7 +245,7 @@ func pngDecode(d *decode.D) any {
 				case colorTypePalette:
 					d.FieldArray("alphas", func(d *decode.D) {
 						for !d.End() {
-							d.FieldU8("alpha")
+							h.paletteAlphas = append(h.paletteAlphas, byte(d.FieldU8("alpha")))
 						}
 					})
 				}
@@ -232,5 +263,30 @@ func pngDecode(d *decode.D) any {
 		d.FieldU32("crc", d.UintValidateBytes(chunkCRC.Sum(nil)), scalar.UintHex)
 	})
 
+	if len(idat) > 0 {
+		decodeImageData(d, h, idat, pi.Unfilter)
+	}
+
+	// APNG frames after the default image, same header but fcTL dimensions
+	hasFrameData := false
+	for _, f := range frames {
+		hasFrameData = hasFrameData || len(f.data) > 0
+	}
+	if hasFrameData {
+		d.FieldArray("frames", func(d *decode.D) {
+			for _, f := range frames {
+				if len(f.data) == 0 {
+					continue
+				}
+				fh := h
+				fh.width = f.width
+				fh.height = f.height
+				d.FieldStruct("frame", func(d *decode.D) {
+					decodeImageData(d, fh, f.data, pi.Unfilter)
+				})
+			}
+		})
+	}
+
 	return nil
 }
@@ -0,0 +1,396 @@
+package png
+
+// Image data inflate, scanline filtering and Adam7 interlacing
+// http://www.libpng.org/pub/png/spec/1.2/PNG-Filters.html
+// http://www.libpng.org/pub/png/spec/1.2/PNG-DataRep.html#DR.Interlaced-data-order
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/wader/fq/internal/mathex"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+const (
+	filterTypeNone    = 0
+	filterTypeSub     = 1
+	filterTypeUp      = 2
+	filterTypeAverage = 3
+	filterTypePaeth   = 4
+)
+
+var filterTypeNames = scalar.UintMapSymStr{
+	filterTypeNone:    "none",
+	filterTypeSub:     "sub",
+	filterTypeUp:      "up",
+	filterTypeAverage: "average",
+	filterTypePaeth:   "paeth",
+}
+
+const (
+	interlaceMethodNone  = 0
+	interlaceMethodAdam7 = 1
+)
+
+type imageHeader struct {
+	width           int
+	height          int
+	bitDepth        int
+	colorType       uint64
+	interlaceMethod uint64
+	palette         [][3]byte
+	paletteAlphas   []byte
+}
+
+func (h imageHeader) samplesPerPixel() int {
+	switch h.colorType {
+	case colorTypeRGB:
+		return 3
+	case colorTypeGrayscaleWithAlpha:
+		return 2
+	case colorTypeRGBA:
+		return 4
+	default:
+		return 1
+	}
+}
+
+func (h imageHeader) bitsPerPixel() int { return h.samplesPerPixel() * h.bitDepth }
+
+func (h imageHeader) rowBytes(width int) int { return (width*h.bitsPerPixel() + 7) / 8 }
+
+// filter byte distance to previous pixel, at least one byte
+func (h imageHeader) filterBytesPerPixel() int { return mathex.Max(1, h.bitsPerPixel()/8) }
+
+type pass struct {
+	width  int
+	height int
+	// position and step in full image
+	x0, y0 int
+	dx, dy int
+}
+
+var adam7Passes = [][4]int{
+	{0, 0, 8, 8},
+	{4, 0, 8, 8},
+	{0, 4, 4, 8},
+	{2, 0, 4, 4},
+	{0, 2, 2, 4},
+	{1, 0, 2, 2},
+	{0, 1, 1, 2},
+}
+
+// passes returns reduced images in data order, one for non-interlaced
+func (h imageHeader) passes() []pass {
+	if h.interlaceMethod != interlaceMethodAdam7 {
+		return []pass{{width: h.width, height: h.height, dx: 1, dy: 1}}
+	}
+	var ps []pass
+	for _, a := range adam7Passes {
+		x0, y0, dx, dy := a[0], a[1], a[2], a[3]
+		p := pass{x0: x0, y0: y0, dx: dx, dy: dy}
+		if h.width > x0 {
+			p.width = (h.width - x0 + dx - 1) / dx
+		}
+		if h.height > y0 {
+			p.height = (h.height - y0 + dy - 1) / dy
+		}
+		ps = append(ps, p)
+	}
+	return ps
+}
+
+func paethPredictor(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	} else if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// unfilterRow reverses filter in place using previous unfiltered row, prev is all
+// zero for first row in a pass
+func unfilterRow(filterType byte, bpp int, row []byte, prev []byte) {
+	switch filterType {
+	case filterTypeSub:
+		for i := bpp; i < len(row); i++ {
+			row[i] += row[i-bpp]
+		}
+	case filterTypeUp:
+		for i := range row {
+			row[i] += prev[i]
+		}
+	case filterTypeAverage:
+		for i := range row {
+			var a byte
+			if i >= bpp {
+				a = row[i-bpp]
+			}
+			row[i] += byte((int(a) + int(prev[i])) / 2)
+		}
+	case filterTypePaeth:
+		for i := range row {
+			var a, c byte
+			if i >= bpp {
+				a = row[i-bpp]
+				c = prev[i-bpp]
+			}
+			row[i] += paethPredictor(a, prev[i], c)
+		}
+	}
+}
+
+func getPixel(row []byte, x int, bitsPerPixel int) uint64 {
+	if bitsPerPixel >= 8 {
+		n := bitsPerPixel / 8
+		var v uint64
+		for _, b := range row[x*n : x*n+n] {
+			v = v<<8 | uint64(b)
+		}
+		return v
+	}
+	bitPos := x * bitsPerPixel
+	shift := 8 - bitsPerPixel - bitPos%8
+	return uint64(row[bitPos/8]>>shift) & (1<<bitsPerPixel - 1)
+}
+
+func setPixel(row []byte, x int, bitsPerPixel int, v uint64) {
+	if bitsPerPixel >= 8 {
+		n := bitsPerPixel / 8
+		for i := n - 1; i >= 0; i-- {
+			row[x*n+i] = byte(v)
+			v >>= 8
+		}
+		return
+	}
+	bitPos := x * bitsPerPixel
+	shift := 8 - bitsPerPixel - bitPos%8
+	mask := byte(1<<bitsPerPixel-1) << shift
+	row[bitPos/8] = row[bitPos/8]&^mask | byte(v)<<shift&mask
+}
+
+// unfilterRows returns number of full image rows to unfilter from dataLen bytes of
+// inflated data. Width and height come from the file so make sure the image fits in
+// memory by not including rows missing in a truncated non-interlaced stream and fail
+// if the image is larger than the data can describe.
+func (h imageHeader) unfilterRows(dataLen int) (int, error) {
+	if h.width <= 0 || h.height <= 0 {
+		return 0, fmt.Errorf("invalid image size %dx%d", h.width, h.height)
+	}
+	fullRowBytes := (uint64(h.width)*uint64(h.bitsPerPixel()) + 7) / 8
+	if fullRowBytes > uint64(dataLen) {
+		return 0, fmt.Errorf("row size %d larger than image data %d", fullRowBytes, dataLen)
+	}
+	if h.interlaceMethod != interlaceMethodAdam7 {
+		// each row is a filter type byte and row bytes, last row might be partial
+		present := (uint64(dataLen) + fullRowBytes) / (fullRowBytes + 1)
+		return int(mathex.Min(uint64(h.height), present)), nil
+	}
+	// interlaced rows are spread over passes so all rows are needed
+	if uint64(h.height)*fullRowBytes > uint64(dataLen) {
+		return 0, fmt.Errorf("image size %d larger than image data %d", uint64(h.height)*fullRowBytes, dataLen)
+	}
+	return h.height, nil
+}
+
+// unfilter returns the first rows of unfiltered and deinterlaced image data as
+// full image rows without filter type bytes. Missing data in a truncated stream
+// is left as zero.
+func unfilter(h imageHeader, data []byte, rows int) []byte {
+	bpp := h.filterBytesPerPixel()
+	bitsPerPixel := h.bitsPerPixel()
+	fullRowBytes := h.rowBytes(h.width)
+	image := make([]byte, fullRowBytes*rows)
+
+	pos := 0
+	for _, p := range h.passes() {
+		if p.width == 0 || p.height == 0 {
+			continue
+		}
+		rowBytes := h.rowBytes(p.width)
+		prev := make([]byte, rowBytes)
+		row := make([]byte, rowBytes)
+		for y := 0; y < p.height && p.y0+y*p.dy < rows; y++ {
+			var filterType byte
+			if pos < len(data) {
+				filterType = data[pos]
+				pos++
+			}
+			for i := range row {
+				row[i] = 0
+			}
+			pos += copy(row, data[mathex.Min(pos, len(data)):])
+			unfilterRow(filterType, bpp, row, prev)
+
+			imageRow := image[(p.y0+y*p.dy)*fullRowBytes:][:fullRowBytes]
+			if h.interlaceMethod != interlaceMethodAdam7 {
+				copy(imageRow, row)
+			} else {
+				for x := 0; x < p.width; x++ {
+					setPixel(imageRow, p.x0+x*p.dx, bitsPerPixel, getPixel(row, x, bitsPerPixel))
+				}
+			}
+			prev, row = row, prev
+		}
+	}
+
+	return image
+}
+
+// dataSize returns size of filtered image data described by the header, each
+// row in each pass is a filter type byte and row bytes. Saturates at max int64
+// for bogus sizes.
+func (h imageHeader) dataSize() int64 {
+	var n int64
+	for _, p := range h.passes() {
+		if p.width <= 0 || p.height <= 0 {
+			continue
+		}
+		rowBytes := (uint64(p.width)*uint64(h.bitsPerPixel())+7)/8 + 1
+		if rowBytes > uint64(math.MaxInt64-n)/uint64(p.height) {
+			return math.MaxInt64
+		}
+		n += int64(rowBytes * uint64(p.height))
+	}
+	return n
+}
+
+// inflate at most maxLen bytes so that a small zlib stream can't inflate to more
+// than the image can use
+func inflate(data []byte, maxLen int64) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	// on error return what could be inflated, useful for corrupt streams
+	inflated, err := io.ReadAll(io.LimitReader(zr, maxLen))
+	if err != nil {
+		return inflated, err
+	}
+	if n, _ := zr.Read(make([]byte, 1)); n > 0 {
+		return inflated, fmt.Errorf("image data larger than %d bytes described by header", maxLen)
+	}
+	return inflated, nil
+}
+
+func decodeScanlines(d *decode.D, h imageHeader, width int, height int) {
+	rowBytes := int64(h.rowBytes(width))
+	y := 0
+	// truncated stream ends with a short or no scanline
+	d.FieldStructArrayLoop("scanlines", "scanline", func() bool { return y < height && d.NotEnd() }, func(d *decode.D) {
+		d.FieldU8("filter_type", filterTypeNames)
+		d.FieldRawLen("data", mathex.Min(rowBytes*8, d.BitsLeft()))
+		y++
+	})
+}
+
+func (h imageHeader) paletteColorMap() scalar.UintMapDescription {
+	m := scalar.UintMapDescription{}
+	for i, c := range h.palette {
+		if i < len(h.paletteAlphas) {
+			m[uint64(i)] = fmt.Sprintf("#%02x%02x%02x%02x", c[0], c[1], c[2], h.paletteAlphas[i])
+		} else {
+			m[uint64(i)] = fmt.Sprintf("#%02x%02x%02x", c[0], c[1], c[2])
+		}
+	}
+	return m
+}
+
+func decodePixelRows(d *decode.D, h imageHeader, rows int) {
+	var sampleNames []string
+	switch h.colorType {
+	case colorTypeGrayscale:
+		sampleNames = []string{"gray"}
+	case colorTypeRGB:
+		sampleNames = []string{"r", "g", "b"}
+	case colorTypeGrayscaleWithAlpha:
+		sampleNames = []string{"gray", "alpha"}
+	case colorTypeRGBA:
+		sampleNames = []string{"r", "g", "b", "alpha"}
+	}
+	paletteMap := h.paletteColorMap()
+	rowBits := int64(h.rowBytes(h.width)) * 8
+	pixelBits := int64(h.width) * int64(h.bitsPerPixel())
+
+	// lazy so that only used rows are decoded when lazy decoding is enabled
+	d.FieldArray("rows", func(d *decode.D) {
+		for y := 0; y < rows; y++ {
+			rowStart := d.Pos()
+			d.FieldArrayLazyFn("row", pixelBits, func(d *decode.D) {
+				for x := 0; x < h.width; x++ {
+					d.FieldStruct("pixel", func(d *decode.D) {
+						if h.colorType == colorTypePalette {
+							d.FieldU("index", h.bitDepth, paletteMap)
+							return
+						}
+						for _, n := range sampleNames {
+							d.FieldU(n, h.bitDepth)
+						}
+					})
+				}
+			})
+			// rows are padded to whole bytes
+			d.SeekAbs(rowStart + rowBits)
+		}
+	})
+}
+
+// decodeImageData adds inflated image data split into scanlines and optionally
+// unfiltered pixel rows
+func decodeImageData(d *decode.D, h imageHeader, data []byte, unfilterPixels bool) {
+	inflated, err := inflate(data, h.dataSize())
+
+	v := d.FieldStructRootBitBufFn("image", bitio.NewBitReader(inflated, -1), func(d *decode.D) {
+		ps := h.passes()
+		if h.interlaceMethod != interlaceMethodAdam7 {
+			decodeScanlines(d, h, h.width, h.height)
+		} else {
+			d.FieldStructArrayLoop("passes", "pass", func() bool { return len(ps) > 0 }, func(d *decode.D) {
+				p := ps[0]
+				ps = ps[1:]
+				d.FieldValueUint("width", uint64(p.width))
+				d.FieldValueUint("height", uint64(p.height))
+				// empty pass has no scanlines, not even filter type bytes
+				if p.width == 0 {
+					p.height = 0
+				}
+				decodeScanlines(d, h, p.width, p.height)
+			})
+		}
+	})
+	if err != nil {
+		v.Err = decode.FormatError{Err: fmt.Errorf("inflate: %w", err), Format: *d.Value.FormatRoot().Format}
+	}
+
+	if unfilterPixels {
+		rows, err := h.unfilterRows(len(inflated))
+		var pixels []byte
+		if err == nil {
+			pixels = unfilter(h, inflated, rows)
+		}
+		v := d.FieldStructRootBitBufFn("pixels", bitio.NewBitReader(pixels, -1), func(d *decode.D) {
+			if err == nil {
+				decodePixelRows(d, h, rows)
+			}
+		})
+		if err != nil {
+			v.Err = decode.FormatError{Err: fmt.Errorf("unfilter: %w", err), Format: *d.Value.FormatRoot().Format}
+		}
+	}
+}
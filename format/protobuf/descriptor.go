@@ -0,0 +1,300 @@
+package protobuf
+
+// FileDescriptorSet parser, ex: protoc --include_imports --descriptor_set_out=file.pb file.proto
+// https://github.com/protocolbuffers/protobuf/blob/main/src/google/protobuf/descriptor.proto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/wader/fq/format"
+)
+
+// FieldDescriptorProto.Type to format.ProtoBufType*, group is not supported
+var descriptorTypes = map[uint64]int{
+	1:  format.ProtoBufTypeDouble,
+	2:  format.ProtoBufTypeFloat,
+	3:  format.ProtoBufTypeInt64,
+	4:  format.ProtoBufTypeUInt64,
+	5:  format.ProtoBufTypeInt32,
+	6:  format.ProtoBufTypeFixed64,
+	7:  format.ProtoBufTypeFixed32,
+	8:  format.ProtoBufTypeBool,
+	9:  format.ProtoBufTypeString,
+	11: format.ProtoBufTypeMessage,
+	12: format.ProtoBufTypeBytes,
+	13: format.ProtoBufTypeUInt32,
+	14: format.ProtoBufTypeEnum,
+	15: format.ProtoBufTypeSFixed32,
+	16: format.ProtoBufTypeSFixed64,
+	17: format.ProtoBufTypeSInt32,
+	18: format.ProtoBufTypeSInt64,
+}
+
+var errInvalidWire = errors.New("invalid wire format")
+
+type wireField struct {
+	number uint64
+	value  uint64 // varint, 32 and 64 bit
+	bytes  []byte // length delimited
+}
+
+func readVarint(b []byte) (uint64, int, error) {
+	n, l := binary.Uvarint(b)
+	if l <= 0 {
+		return 0, 0, errInvalidWire
+	}
+	return n, l, nil
+}
+
+func readWireFields(b []byte) ([]wireField, error) {
+	var fs []wireField
+	for len(b) > 0 {
+		key, l, err := readVarint(b)
+		if err != nil {
+			return nil, err
+		}
+		b = b[l:]
+		f := wireField{number: key >> 3}
+		switch key & 0x7 {
+		case wireTypeVarint:
+			if f.value, l, err = readVarint(b); err != nil {
+				return nil, err
+			}
+			b = b[l:]
+		case wireType64Bit:
+			if len(b) < 8 {
+				return nil, errInvalidWire
+			}
+			f.value = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireTypeLengthDelimited:
+			n, l, err := readVarint(b)
+			if err != nil {
+				return nil, err
+			}
+			b = b[l:]
+			if uint64(len(b)) < n {
+				return nil, errInvalidWire
+			}
+			f.bytes = b[0:n]
+			b = b[n:]
+		case wireType32Bit:
+			if len(b) < 4 {
+				return nil, errInvalidWire
+			}
+			f.value = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		default:
+			return nil, errInvalidWire
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+func parseFileDescriptorSet(b []byte, sc *schema) error {
+	fs, err := readWireFields(b)
+	if err != nil {
+		return fmt.Errorf("FileDescriptorSet: %w", err)
+	}
+	for _, f := range fs {
+		if f.number != 1 {
+			continue
+		}
+		if err := parseFileDescriptor(f.bytes, sc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseFileDescriptor(b []byte, sc *schema) error {
+	fs, err := readWireFields(b)
+	if err != nil {
+		return fmt.Errorf("FileDescriptorProto: %w", err)
+	}
+	pkg := ""
+	for _, f := range fs {
+		if f.number == 2 {
+			pkg = string(f.bytes)
+		}
+	}
+	for _, f := range fs {
+		var err error
+		switch f.number {
+		case 4:
+			err = parseDescriptor(f.bytes, pkg, sc)
+		case 5:
+			err = parseEnumDescriptor(f.bytes, pkg, sc)
+		case 6:
+			err = parseServiceDescriptor(f.bytes, pkg, sc)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseDescriptor(b []byte, scope string, sc *schema) error {
+	fs, err := readWireFields(b)
+	if err != nil {
+		return fmt.Errorf("DescriptorProto: %w", err)
+	}
+	md := &messageDesc{}
+	var oneofs []string
+	for _, f := range fs {
+		switch f.number {
+		case 1:
+			md.fullName = joinName(scope, string(f.bytes))
+		case 8:
+			ofs, err := readWireFields(f.bytes)
+			if err != nil {
+				return fmt.Errorf("OneofDescriptorProto: %w", err)
+			}
+			name := ""
+			for _, of := range ofs {
+				if of.number == 1 {
+					name = string(of.bytes)
+				}
+			}
+			oneofs = append(oneofs, name)
+		}
+	}
+	sc.addMessage(md)
+
+	for _, f := range fs {
+		var err error
+		switch f.number {
+		case 2:
+			err = parseFieldDescriptor(f.bytes, md, oneofs)
+		case 3:
+			err = parseDescriptor(f.bytes, md.fullName, sc)
+		case 4:
+			err = parseEnumDescriptor(f.bytes, md.fullName, sc)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseFieldDescriptor(b []byte, md *messageDesc, oneofs []string) error {
+	fs, err := readWireFields(b)
+	if err != nil {
+		return fmt.Errorf("FieldDescriptorProto: %w", err)
+	}
+	fd := fieldDesc{scope: md.fullName}
+	var typ uint64
+	oneofIndex := -1
+	proto3Optional := false
+	for _, f := range fs {
+		switch f.number {
+		case 1:
+			fd.name = string(f.bytes)
+		case 3:
+			fd.number = int(f.value)
+		case 5:
+			typ = f.value
+		case 6:
+			fd.typeName = string(f.bytes)
+		case 9:
+			oneofIndex = int(f.value)
+		case 17:
+			proto3Optional = f.value != 0
+		}
+	}
+	t, ok := descriptorTypes[typ]
+	if !ok {
+		// group or unknown, skip field
+		return nil
+	}
+	// type_name is used for message and enum
+	if t != format.ProtoBufTypeMessage && t != format.ProtoBufTypeEnum {
+		fd.typeName = ""
+	}
+	fd.typ = t
+	// proto3 optional fields are in synthetic oneofs
+	if !proto3Optional && oneofIndex >= 0 && oneofIndex < len(oneofs) {
+		fd.oneof = oneofs[oneofIndex]
+	}
+	md.fields = append(md.fields, fd)
+
+	return nil
+}
+
+func parseEnumDescriptor(b []byte, scope string, sc *schema) error {
+	fs, err := readWireFields(b)
+	if err != nil {
+		return fmt.Errorf("EnumDescriptorProto: %w", err)
+	}
+	fullName := ""
+	values := map[uint64]string{}
+	for _, f := range fs {
+		switch f.number {
+		case 1:
+			fullName = joinName(scope, string(f.bytes))
+		case 2:
+			vfs, err := readWireFields(f.bytes)
+			if err != nil {
+				return fmt.Errorf("EnumValueDescriptorProto: %w", err)
+			}
+			name := ""
+			var n uint64
+			for _, vf := range vfs {
+				switch vf.number {
+				case 1:
+					name = string(vf.bytes)
+				case 2:
+					n = vf.value
+				}
+			}
+			if _, ok := values[n]; !ok {
+				values[n] = name
+			}
+		}
+	}
+	sc.addEnum(fullName, values)
+
+	return nil
+}
+
+func parseServiceDescriptor(b []byte, scope string, sc *schema) error {
+	fs, err := readWireFields(b)
+	if err != nil {
+		return fmt.Errorf("ServiceDescriptorProto: %w", err)
+	}
+	fullName := ""
+	for _, f := range fs {
+		if f.number == 1 {
+			fullName = joinName(scope, string(f.bytes))
+		}
+	}
+	for _, f := range fs {
+		if f.number != 2 {
+			continue
+		}
+		mfs, err := readWireFields(f.bytes)
+		if err != nil {
+			return fmt.Errorf("MethodDescriptorProto: %w", err)
+		}
+		name := ""
+		md := methodDesc{scope: scope}
+		for _, mf := range mfs {
+			switch mf.number {
+			case 1:
+				name = string(mf.bytes)
+			case 2:
+				md.input = string(mf.bytes)
+			case 3:
+				md.output = string(mf.bytes)
+			}
+		}
+		sc.addMethod(fullName, name, md)
+	}
+
+	return nil
+}
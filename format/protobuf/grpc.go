@@ -0,0 +1,102 @@
+package protobuf
+
+// https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-HTTP2.md
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"embed"
+	"io"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed grpc.md
+var grpcFS embed.FS
+
+var grpcProtoBufFormat decode.Group
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:         format.GRPC,
+		Description:  "gRPC length-prefixed messages",
+		DecodeFn:     grpcDecode,
+		DefaultInArg: format.GRPCIn{},
+		Dependencies: []decode.Dependency{
+			{Names: []string{format.PROTOBUF}, Group: &grpcProtoBufFormat},
+		},
+	})
+	interp.RegisterFS(grpcFS)
+}
+
+var grpcCompressedMap = scalar.UintMapSymBool{
+	0: false,
+	1: true,
+}
+
+func grpcDecode(d *decode.D) any {
+	var gi format.GRPCIn
+	d.ArgAs(&gi)
+
+	// empty message means decode without schema if method is not known
+	pbi := format.ProtoBufIn{Schema: gi.Schema, Message: format.ProtoBufMessage{}}
+	if gi.Schema != "" {
+		sc, err := cachedParseSchema(gi.Schema)
+		if err != nil {
+			d.Fatalf("schema: %s", err)
+		}
+		if name, ok := sc.methodMessage(gi.Path, gi.IsResponse); ok {
+			if pbi.Message, err = sc.message(name); err != nil {
+				d.Fatalf("schema: %s", err)
+			}
+			pbi.MessageName = name
+			d.FieldValueStr("message_name", name)
+		}
+	}
+
+	d.FieldArray("messages", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("message", func(d *decode.D) {
+				compressed := d.FieldU8("compressed", grpcCompressedMap)
+				length := int64(d.FieldU32("length"))
+				if compressed == 0 {
+					d.FieldFormatOrRawLen("data", length*8, grpcProtoBufFormat, pbi)
+					return
+				}
+
+				var newReader func(r io.Reader) (io.Reader, error)
+				switch gi.Encoding {
+				case "gzip":
+					newReader = func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }
+				case "deflate":
+					newReader = func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) }
+				default:
+					d.FieldRawLen("data", length*8)
+					return
+				}
+
+				compressedBytes := d.BytesRange(d.Pos(), int(length))
+				d.FieldRawLen("compressed_data", length*8)
+				r, err := newReader(bytes.NewReader(compressedBytes))
+				if err != nil {
+					return
+				}
+				b, err := io.ReadAll(r)
+				if err != nil {
+					return
+				}
+				br := bitio.NewBitReader(b, -1)
+				if dv, _, _ := d.TryFieldFormatBitBuf("data", br, grpcProtoBufFormat, pbi); dv == nil {
+					d.FieldRootBitBuf("data", br)
+				}
+			})
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,412 @@
+package protobuf
+
+// .proto source parser, only collects what is needed to decode messages
+// https://protobuf.dev/reference/protobuf/proto3-spec/
+// https://protobuf.dev/reference/protobuf/proto2-spec/
+// TODO: imports, groups, extensions, editions
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type protoToken struct {
+	s     string
+	str   bool // quoted string
+	line  int
+	isEOF bool
+}
+
+type protoParseError struct {
+	err error
+}
+
+type protoParser struct {
+	ts []protoToken
+	i  int
+	sc *schema
+}
+
+func lexProto(s string) ([]protoToken, error) {
+	var ts []protoToken
+	line := 1
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			i++
+		case strings.HasPrefix(s[i:], "//"):
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end == -1 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(s[i:i+2+end], "\n")
+			i += 2 + end + 2
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(s) && s[j] != c {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			str, err := strconv.Unquote(`"` + strings.ReplaceAll(s[i+1:j], `"`, `\"`) + `"`)
+			if err != nil {
+				str = s[i+1 : j]
+			}
+			ts = append(ts, protoToken{s: str, str: true, line: line})
+			i = j + 1
+		case c == '_' || c == '.' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+			j := i
+			for j < len(s) && (s[j] == '_' || s[j] == '.' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			ts = append(ts, protoToken{s: s[i:j], line: line})
+			i = j
+		default:
+			ts = append(ts, protoToken{s: string(c), line: line})
+			i++
+		}
+	}
+	ts = append(ts, protoToken{line: line, isEOF: true})
+
+	return ts, nil
+}
+
+func (p *protoParser) errorf(format string, a ...any) {
+	panic(protoParseError{fmt.Errorf("line %d: %s", p.peek().line, fmt.Sprintf(format, a...))})
+}
+
+func (p *protoParser) peek() protoToken { return p.ts[p.i] }
+
+func (p *protoParser) next() protoToken {
+	t := p.ts[p.i]
+	if !t.isEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *protoParser) is(s string) bool {
+	t := p.peek()
+	return !t.str && !t.isEOF && t.s == s
+}
+
+func (p *protoParser) expect(s string) {
+	if !p.is(s) {
+		p.errorf("expected %q found %q", s, p.peek().s)
+	}
+	p.next()
+}
+
+func (p *protoParser) ident() string {
+	t := p.next()
+	if t.str || t.isEOF || t.s == "" || !(t.s[0] == '_' || t.s[0] == '.' || unicode.IsLetter(rune(t.s[0]))) {
+		p.errorf("expected identifier found %q", t.s)
+	}
+	return t.s
+}
+
+func (p *protoParser) int() int64 {
+	neg := false
+	if p.is("-") {
+		p.next()
+		neg = true
+	}
+	t := p.next()
+	n, err := strconv.ParseInt(t.s, 0, 64)
+	if t.str || err != nil {
+		p.errorf("expected integer found %q", t.s)
+	}
+	if neg {
+		n = -n
+	}
+	return n
+}
+
+// skip statement until ";" or a block
+func (p *protoParser) skipStatement() {
+	depth := 0
+	for {
+		t := p.next()
+		switch {
+		case t.isEOF:
+			p.errorf("unexpected end")
+		case t.str:
+		case t.s == "{" || t.s == "[" || t.s == "(" || t.s == "<":
+			depth++
+		case t.s == "}" || t.s == "]" || t.s == ")" || t.s == ">":
+			depth--
+			if depth == 0 && t.s == "}" && !p.is(";") {
+				return
+			}
+		case t.s == ";" && depth == 0:
+			return
+		}
+	}
+}
+
+// skip [...] field options if any
+func (p *protoParser) skipFieldOptions() {
+	if !p.is("[") {
+		return
+	}
+	depth := 0
+	for {
+		t := p.next()
+		switch {
+		case t.isEOF:
+			p.errorf("unexpected end")
+		case t.str:
+		case t.s == "[":
+			depth++
+		case t.s == "]":
+			depth--
+			if depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+func joinName(scope string, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+func parseProto(s string, sc *schema) (err error) {
+	ts, err := lexProto(s)
+	if err != nil {
+		return err
+	}
+	p := &protoParser{ts: ts, sc: sc}
+
+	defer func() {
+		if r := recover(); r != nil {
+			pe, ok := r.(protoParseError)
+			if !ok {
+				panic(r)
+			}
+			err = pe.err
+		}
+	}()
+
+	pkg := ""
+	for !p.peek().isEOF {
+		switch {
+		case p.is(";"):
+			p.next()
+		case p.is("package"):
+			p.next()
+			pkg = p.ident()
+			p.expect(";")
+		case p.is("syntax"), p.is("edition"), p.is("import"), p.is("option"), p.is("extend"):
+			p.skipStatement()
+		case p.is("service"):
+			p.next()
+			p.parseService(pkg)
+		case p.is("message"):
+			p.next()
+			p.parseMessage(pkg)
+		case p.is("enum"):
+			p.next()
+			p.parseEnum(pkg)
+		default:
+			p.errorf("unexpected %q", p.peek().s)
+		}
+	}
+
+	return nil
+}
+
+func (p *protoParser) parseMessage(scope string) {
+	md := &messageDesc{fullName: joinName(scope, p.ident())}
+	p.sc.addMessage(md)
+	p.expect("{")
+	p.parseMessageBody(md, "")
+}
+
+func (p *protoParser) parseField(md *messageDesc, oneof string) {
+	switch {
+	case p.is("optional"), p.is("required"), p.is("repeated"):
+		p.next()
+	}
+	// groups are deprecated and not supported, skip field but keep nested message
+	if p.is("group") {
+		p.next()
+		name := p.ident()
+		p.expect("=")
+		p.int()
+		p.skipFieldOptions()
+		p.expect("{")
+		gmd := &messageDesc{fullName: joinName(md.fullName, name)}
+		p.sc.addMessage(gmd)
+		p.parseMessageBody(gmd, "")
+		return
+	}
+	typ := p.ident()
+	name := p.ident()
+	p.expect("=")
+	number := p.int()
+	p.skipFieldOptions()
+	p.expect(";")
+
+	fd := fieldDesc{name: name, number: int(number), scope: md.fullName, oneof: oneof}
+	if t, ok := scalarTypes[typ]; ok {
+		fd.typ = t
+	} else {
+		fd.typeName = typ
+	}
+	md.fields = append(md.fields, fd)
+}
+
+// map<K, V> name = N; is a repeated nested message NameEntry {K key = 1; V value = 2;}
+func (p *protoParser) parseMapField(md *messageDesc) {
+	p.expect("map")
+	p.expect("<")
+	keyType := p.ident()
+	p.expect(",")
+	valueType := p.ident()
+	p.expect(">")
+	name := p.ident()
+	p.expect("=")
+	number := p.int()
+	p.skipFieldOptions()
+	p.expect(";")
+
+	entryName := ""
+	for _, part := range strings.Split(name, "_") {
+		if part != "" {
+			entryName += strings.ToUpper(part[0:1]) + part[1:]
+		}
+	}
+	entryName += "Entry"
+	emd := &messageDesc{fullName: joinName(md.fullName, entryName)}
+	for i, ft := range []string{keyType, valueType} {
+		fd := fieldDesc{name: []string{"key", "value"}[i], number: i + 1, scope: emd.fullName}
+		if t, ok := scalarTypes[ft]; ok {
+			fd.typ = t
+		} else {
+			fd.typeName = ft
+		}
+		emd.fields = append(emd.fields, fd)
+	}
+	p.sc.addMessage(emd)
+
+	md.fields = append(md.fields, fieldDesc{name: name, number: int(number), typeName: "." + emd.fullName})
+}
+
+func (p *protoParser) parseMessageBody(md *messageDesc, oneof string) {
+	for {
+		switch {
+		case p.peek().isEOF:
+			p.errorf("unexpected end")
+		case p.is("}"):
+			p.next()
+			return
+		case p.is(";"):
+			p.next()
+		case p.is("option"), p.is("reserved"), p.is("extensions"), p.is("extend"):
+			p.skipStatement()
+		case p.is("message") && oneof == "":
+			p.next()
+			p.parseMessage(md.fullName)
+		case p.is("enum") && oneof == "":
+			p.next()
+			p.parseEnum(md.fullName)
+		case p.is("oneof") && oneof == "":
+			p.next()
+			name := p.ident()
+			p.expect("{")
+			p.parseMessageBody(md, name)
+		case p.is("map") && oneof == "":
+			p.parseMapField(md)
+		default:
+			p.parseField(md, oneof)
+		}
+	}
+}
+
+func (p *protoParser) parseEnum(scope string) {
+	fullName := joinName(scope, p.ident())
+	values := map[uint64]string{}
+	p.expect("{")
+	for {
+		switch {
+		case p.peek().isEOF:
+			p.errorf("unexpected end")
+		case p.is("}"):
+			p.next()
+			p.sc.addEnum(fullName, values)
+			return
+		case p.is(";"):
+			p.next()
+		case p.is("option"), p.is("reserved"):
+			p.skipStatement()
+		default:
+			name := p.ident()
+			p.expect("=")
+			n := p.int()
+			p.skipFieldOptions()
+			p.expect(";")
+			// first name wins for aliases
+			if _, ok := values[uint64(n)]; !ok {
+				values[uint64(n)] = name
+			}
+		}
+	}
+}
+
+// service Name { rpc Method (stream Input) returns (stream Output); }
+func (p *protoParser) parseService(scope string) {
+	fullName := joinName(scope, p.ident())
+	p.expect("{")
+	for {
+		switch {
+		case p.peek().isEOF:
+			p.errorf("unexpected end")
+		case p.is("}"):
+			p.next()
+			return
+		case p.is(";"):
+			p.next()
+		case p.is("rpc"):
+			p.next()
+			name := p.ident()
+			typ := func() string {
+				p.expect("(")
+				if p.is("stream") {
+					p.next()
+				}
+				t := p.ident()
+				p.expect(")")
+				return t
+			}
+			input := typ()
+			p.expect("returns")
+			output := typ()
+			p.sc.addMethod(fullName, name, methodDesc{input: input, output: output, scope: scope})
+			if p.is("{") {
+				p.skipStatement()
+			} else {
+				p.expect(";")
+			}
+		default:
+			p.skipStatement()
+		}
+	}
+}
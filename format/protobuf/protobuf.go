@@ -4,6 +4,9 @@ package protobuf
 
 import (
 	"embed"
+	"math"
+	"strings"
+	"time"
 
 	"github.com/wader/fq/format"
 	"github.com/wader/fq/internal/mathex"
@@ -18,9 +21,10 @@ var protobufFS embed.FS
 
 func init() {
 	interp.RegisterFormat(decode.Format{
-		Name:        format.PROTOBUF,
-		Description: "Protobuf",
-		DecodeFn:    protobufDecode,
+		Name:         format.PROTOBUF,
+		Description:  "Protobuf",
+		DecodeFn:     protobufDecode,
+		DefaultInArg: format.ProtoBufIn{},
 	})
 	interp.RegisterFS(protobufFS)
 }
@@ -36,103 +40,247 @@ var wireTypeNames = scalar.UintMapSymStr{
 	0: "varint",
 	1: "64bit",
 	2: "length_delimited",
+	3: "start_group",
+	4: "end_group",
 	5: "32bit",
 }
 
-func protobufDecodeField(d *decode.D, pbm *format.ProtoBufMessage) {
+// value of a field in a message, used by well-known types
+type wireValue struct {
+	value  uint64
+	start  int64
+	length int64
+}
+
+func enumSintMapper(enums map[uint64]string) scalar.SintMapSymStr {
+	m := scalar.SintMapSymStr{}
+	for k, v := range enums {
+		m[int64(k)] = v
+	}
+	return m
+}
+
+// value of varint, 32 or 64 bit field
+func protobufFieldValue(d *decode.D, pbf format.ProtoBufField, value uint64) {
+	switch pbf.Type {
+	case format.ProtoBufTypeInt32, format.ProtoBufTypeInt64:
+		v := int64(value)
+		d.FieldValueSint("value", v)
+		if len(pbf.Enums) > 0 {
+			d.FieldValueStr("enum", pbf.Enums[uint64(v)])
+		}
+	case format.ProtoBufTypeUInt32, format.ProtoBufTypeUInt64:
+		d.FieldValueUint("value", value)
+		if len(pbf.Enums) > 0 {
+			d.FieldValueStr("enum", pbf.Enums[value])
+		}
+	case format.ProtoBufTypeSInt32, format.ProtoBufTypeSInt64:
+		v := mathex.ZigZag[uint64, int64](value)
+		d.FieldValueSint("value", v)
+		if len(pbf.Enums) > 0 {
+			d.FieldValueStr("enum", pbf.Enums[uint64(v)])
+		}
+	case format.ProtoBufTypeBool:
+		d.FieldValueBool("value", value != 0)
+	case format.ProtoBufTypeEnum:
+		d.FieldValueStr("enum", pbf.Enums[value])
+	case format.ProtoBufTypeFixed64:
+		d.FieldValueUint("value", value)
+	case format.ProtoBufTypeSFixed64:
+		d.FieldValueSint("value", int64(value))
+	case format.ProtoBufTypeDouble:
+		d.FieldValueFlt("value", math.Float64frombits(value))
+	case format.ProtoBufTypeFixed32:
+		d.FieldValueUint("value", value)
+	case format.ProtoBufTypeSFixed32:
+		d.FieldValueSint("value", int64(int32(value)))
+	case format.ProtoBufTypeFloat:
+		d.FieldValueFlt("value", float64(math.Float32frombits(uint32(value))))
+	}
+}
+
+// element of a packed repeated field
+func protobufDecodePacked(d *decode.D, pbf format.ProtoBufField) {
+	var sms []scalar.SintMapper
+	if len(pbf.Enums) > 0 {
+		sms = append(sms, enumSintMapper(pbf.Enums))
+	}
+
+	switch pbf.Type {
+	case format.ProtoBufTypeInt32, format.ProtoBufTypeInt64, format.ProtoBufTypeEnum:
+		d.FieldSintFn("value", func(d *decode.D) int64 { return int64(d.ULEB128()) }, sms...)
+	case format.ProtoBufTypeUInt32, format.ProtoBufTypeUInt64:
+		d.FieldULEB128("value")
+	case format.ProtoBufTypeSInt32, format.ProtoBufTypeSInt64:
+		d.FieldSintFn("value", func(d *decode.D) int64 { return mathex.ZigZag[uint64, int64](d.ULEB128()) }, sms...)
+	case format.ProtoBufTypeBool:
+		d.FieldBoolFn("value", func(d *decode.D) bool { return d.ULEB128() != 0 })
+	case format.ProtoBufTypeFixed64:
+		d.FieldU64LE("value")
+	case format.ProtoBufTypeSFixed64:
+		d.FieldS64LE("value")
+	case format.ProtoBufTypeDouble:
+		d.FieldF64LE("value")
+	case format.ProtoBufTypeFixed32:
+		d.FieldU32LE("value")
+	case format.ProtoBufTypeSFixed32:
+		d.FieldS32LE("value")
+	case format.ProtoBufTypeFloat:
+		d.FieldF32LE("value")
+	default:
+		d.Fatalf("%s can't be packed", format.ProtoBufTypeNames[uint64(pbf.Type)])
+	}
+}
+
+func protobufDecodeField(d *decode.D, pbm *format.ProtoBufMessage, sc *schema) (uint64, wireValue) {
+	var fieldNumber uint64
+	var wv wireValue
+
 	d.FieldStruct("field", func(d *decode.D) {
 		keyN := d.FieldULEB128("key_n")
-		fieldNumber := keyN >> 3
+		fieldNumber = keyN >> 3
 		wireType := keyN & 0x7
 		d.FieldValueUint("field_number", fieldNumber)
 		d.FieldValueUint("wire_type", wireType, scalar.UintSym(wireTypeNames[wireType]))
 
-		var value uint64
-		var length uint64
-		var valueStart int64
+		var pbf format.ProtoBufField
+		hasField := false
+		if pbm != nil {
+			pbf, hasField = (*pbm)[int(fieldNumber)]
+		}
+
 		switch wireType {
 		case wireTypeVarint:
-			value = d.FieldULEB128("wire_value")
+			wv.value = d.FieldULEB128("wire_value")
 		case wireType64Bit:
-			value = d.FieldU64("wire_value")
+			wv.value = d.FieldU64LE("wire_value")
 		case wireTypeLengthDelimited:
-			length = d.FieldULEB128("length")
-			valueStart = d.Pos()
-			d.FieldRawLen("wire_value", int64(length)*8)
+			wv.length = int64(d.FieldULEB128("length"))
+			wv.start = d.Pos()
+			// message and packed repeated values are decoded as value instead
+			if !hasField || pbf.Type == format.ProtoBufTypeString || pbf.Type == format.ProtoBufTypeBytes {
+				d.FieldRawLen("wire_value", wv.length*8)
+			}
 		case wireType32Bit:
-			value = d.FieldU32("wire_value")
+			wv.value = d.FieldU32LE("wire_value")
 		}
 
-		if pbm != nil {
-			if pbf, ok := (*pbm)[int(fieldNumber)]; ok {
-				d.FieldValueStr("name", pbf.Name)
-				d.FieldValueStr("type", format.ProtoBufTypeNames[uint64(pbf.Type)])
-
-				switch pbf.Type {
-				case format.ProtoBufTypeInt32, format.ProtoBufTypeInt64:
-					v := mathex.ZigZag[uint64, int64](value)
-					d.FieldValueSint("value", v)
-					if len(pbf.Enums) > 0 {
-						d.FieldValueStr("enum", pbf.Enums[uint64(v)])
-					}
-				case format.ProtoBufTypeUInt32, format.ProtoBufTypeUInt64:
-					d.FieldValueUint("value", value)
-					if len(pbf.Enums) > 0 {
-						d.FieldValueStr("enum", pbf.Enums[value])
-					}
-				case format.ProtoBufTypeSInt32, format.ProtoBufTypeSInt64:
-					// TODO: correct? 32 different?
-					v := mathex.TwosComplement(64, value)
-					d.FieldValueSint("value", v)
-					if len(pbf.Enums) > 0 {
-						d.FieldValueStr("enum", pbf.Enums[uint64(v)])
+		if !hasField {
+			return
+		}
+
+		d.FieldValueStr("name", pbf.Name)
+		d.FieldValueStr("type", format.ProtoBufTypeNames[uint64(pbf.Type)])
+		if pbf.Oneof != "" {
+			d.FieldValueStr("oneof", pbf.Oneof)
+		}
+
+		if wireType != wireTypeLengthDelimited {
+			protobufFieldValue(d, pbf, wv.value)
+			return
+		}
+
+		switch pbf.Type {
+		case format.ProtoBufTypeString:
+			d.FieldValueStr("value", string(d.BytesRange(wv.start, int(wv.length))))
+		case format.ProtoBufTypeBytes:
+			d.FieldValueBitBuf("value", bitio.NewBitReader(d.BytesRange(wv.start, int(wv.length)), -1))
+		case format.ProtoBufTypeMessage:
+			d.FramedFn(wv.length*8, func(d *decode.D) {
+				d.FieldStruct("value", func(d *decode.D) {
+					protobufDecodeMessage(d, pbf, sc)
+				})
+			})
+		default:
+			d.FramedFn(wv.length*8, func(d *decode.D) {
+				d.FieldArray("value", func(d *decode.D) {
+					for d.BitsLeft() > 0 {
+						protobufDecodePacked(d, pbf)
 					}
-				case format.ProtoBufTypeBool:
-					d.FieldValueBool("value", value != 0)
-				case format.ProtoBufTypeEnum:
-					d.FieldValueStr("enum", pbf.Enums[value])
-				case format.ProtoBufTypeFixed64:
-					// TODO:
-				case format.ProtoBufTypeSFixed64:
-					// TODO:
-				case format.ProtoBufTypeDouble:
-					// TODO:
-				case format.ProtoBufTypeString:
-					d.FieldValueStr("value", string(d.BytesRange(valueStart, int(length))))
-				case format.ProtoBufTypeBytes:
-					d.FieldValueBitBuf("value", bitio.NewBitReader(d.BytesRange(valueStart, int(length)), -1))
-				case format.ProtoBufTypeMessage:
-					// TODO: test
-					d.FramedFn(int64(length)*8, func(d *decode.D) {
-						protobufDecodeFields(d, &pbf.Message)
-					})
-				case format.ProtoBufTypePackedRepeated:
-					// TODO:
-				case format.ProtoBufTypeFixed32:
-					// TODO:
-				case format.ProtoBufTypeSFixed32:
-					// TODO:
-				case format.ProtoBufTypeFloat:
-					// TODO:
-				}
-			}
+				})
+			})
 		}
 	})
+
+	return fieldNumber, wv
 }
 
-func protobufDecodeFields(d *decode.D, pbm *format.ProtoBufMessage) {
+func protobufDecodeFields(d *decode.D, pbm *format.ProtoBufMessage, sc *schema) map[uint64]wireValue {
+	wvs := map[uint64]wireValue{}
 	d.FieldArray("fields", func(d *decode.D) {
 		for d.BitsLeft() > 0 {
-			protobufDecodeField(d, pbm)
+			n, wv := protobufDecodeField(d, pbm, sc)
+			wvs[n] = wv
 		}
 	})
+	return wvs
+}
+
+// decode message fields and add values for well-known types
+func protobufDecodeMessage(d *decode.D, pbf format.ProtoBufField, sc *schema) {
+	wvs := protobufDecodeFields(d, &pbf.Message, sc)
+
+	switch pbf.TypeName {
+	case "google.protobuf.Timestamp":
+		t := time.Unix(int64(wvs[1].value), int64(int32(wvs[2].value)))
+		d.FieldValueStr("time", t.UTC().Format(time.RFC3339Nano))
+	case "google.protobuf.Duration":
+		dur := time.Duration(int64(wvs[1].value))*time.Second + time.Duration(int32(wvs[2].value))
+		d.FieldValueStr("duration", dur.String())
+	case "google.protobuf.Any":
+		if sc == nil {
+			return
+		}
+		typeURL, hasTypeURL := wvs[1]
+		value, hasValue := wvs[2]
+		if !hasTypeURL || !hasValue {
+			return
+		}
+		// type.googleapis.com/package.Message
+		typeName := string(d.BytesRange(typeURL.start, int(typeURL.length)))
+		if i := strings.LastIndex(typeName, "/"); i != -1 {
+			typeName = typeName[i+1:]
+		}
+		pbm, err := sc.message(typeName)
+		if err != nil {
+			return
+		}
+		d.RangeFn(value.start, value.length*8, func(d *decode.D) {
+			d.FieldStruct("any_value", func(d *decode.D) {
+				protobufDecodeMessage(d, format.ProtoBufField{Message: pbm, TypeName: typeName}, sc)
+			})
+		})
+	}
 }
 
 func protobufDecode(d *decode.D) any {
 	var pbi format.ProtoBufIn
 	d.ArgAs(&pbi)
 
-	protobufDecodeFields(d, &pbi.Message)
+	// message can be provided by other formats like widevine and grpc, schema
+	// is then only used to decode Any
+	pbf := format.ProtoBufField{Message: pbi.Message, TypeName: pbi.MessageName}
+	var sc *schema
+	if pbi.Schema != "" {
+		var err error
+		sc, err = cachedParseSchema(pbi.Schema)
+		switch {
+		case pbf.Message != nil:
+			// only used for Any, ignore errors
+		case err != nil:
+			d.Fatalf("schema: %s", err)
+		default:
+			if pbf.TypeName, err = sc.findMessage(pbi.MessageName); err != nil {
+				d.Fatalf("schema: %s", err)
+			}
+			if pbf.Message, err = sc.message(pbf.TypeName); err != nil {
+				d.Fatalf("schema: %s", err)
+			}
+			d.FieldValueStr("message_name", pbf.TypeName)
+		}
+	}
+
+	protobufDecodeMessage(d, pbf, sc)
 
 	return nil
 }
@@ -0,0 +1,284 @@
+package protobuf
+
+// Messages and enums from .proto source or a FileDescriptorSet are collected by
+// fully qualified name and then resolved into a format.ProtoBufMessage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/wader/fq/format"
+)
+
+// well-known types that are usually imported
+const wellKnownProto = `
+syntax = "proto3";
+package google.protobuf;
+message Any { string type_url = 1; bytes value = 2; }
+message Duration { int64 seconds = 1; int32 nanos = 2; }
+message Empty {}
+message FieldMask { repeated string paths = 1; }
+message Timestamp { int64 seconds = 1; int32 nanos = 2; }
+message DoubleValue { double value = 1; }
+message FloatValue { float value = 1; }
+message Int64Value { int64 value = 1; }
+message UInt64Value { uint64 value = 1; }
+message Int32Value { int32 value = 1; }
+message UInt32Value { uint32 value = 1; }
+message BoolValue { bool value = 1; }
+message StringValue { string value = 1; }
+message BytesValue { bytes value = 1; }
+message Struct { map<string, Value> fields = 1; }
+message Value {
+  oneof kind {
+    NullValue null_value = 1;
+    double number_value = 2;
+    string string_value = 3;
+    bool bool_value = 4;
+    Struct struct_value = 5;
+    ListValue list_value = 6;
+  }
+}
+enum NullValue { NULL_VALUE = 0; }
+message ListValue { repeated Value values = 1; }
+`
+
+var scalarTypes = map[string]int{
+	"double":   format.ProtoBufTypeDouble,
+	"float":    format.ProtoBufTypeFloat,
+	"int32":    format.ProtoBufTypeInt32,
+	"int64":    format.ProtoBufTypeInt64,
+	"uint32":   format.ProtoBufTypeUInt32,
+	"uint64":   format.ProtoBufTypeUInt64,
+	"sint32":   format.ProtoBufTypeSInt32,
+	"sint64":   format.ProtoBufTypeSInt64,
+	"fixed32":  format.ProtoBufTypeFixed32,
+	"fixed64":  format.ProtoBufTypeFixed64,
+	"sfixed32": format.ProtoBufTypeSFixed32,
+	"sfixed64": format.ProtoBufTypeSFixed64,
+	"bool":     format.ProtoBufTypeBool,
+	"string":   format.ProtoBufTypeString,
+	"bytes":    format.ProtoBufTypeBytes,
+}
+
+type fieldDesc struct {
+	name     string
+	number   int
+	typ      int    // scalar type, ignored if typeName is set
+	typeName string // message or enum type name relative to scope or absolute if starts with "."
+	scope    string
+	oneof    string
+}
+
+type messageDesc struct {
+	fullName string
+	fields   []fieldDesc
+}
+
+// rpc method, input and output are type names relative to scope
+type methodDesc struct {
+	input  string
+	output string
+	scope  string
+}
+
+type schema struct {
+	messages map[string]*messageDesc
+	enums    map[string]map[uint64]string
+	methods  map[string]methodDesc // by gRPC path, ex: /package.Service/Method
+	order    []string              // messages in definition order excluding well-known types
+	resolved map[string]format.ProtoBufMessage
+}
+
+func newSchema() *schema {
+	sc := &schema{
+		messages: map[string]*messageDesc{},
+		enums:    map[string]map[uint64]string{},
+		methods:  map[string]methodDesc{},
+		resolved: map[string]format.ProtoBufMessage{},
+	}
+	if err := parseProto(wellKnownProto, sc); err != nil {
+		panic(err)
+	}
+	sc.order = nil
+
+	return sc
+}
+
+// source is .proto text if it looks like text otherwise a FileDescriptorSet
+func isProtoSource(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' {
+			return false
+		}
+	}
+	return true
+}
+
+func parseSchema(s string) (*schema, error) {
+	sc := newSchema()
+	var err error
+	if isProtoSource(s) {
+		err = parseProto(s, sc)
+	} else {
+		err = parseFileDescriptorSet([]byte(s), sc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// last parsed schema, same schema is usually used for many messages
+var schemaCache struct {
+	sync.Mutex
+	s   string
+	sc  *schema
+	err error
+}
+
+func cachedParseSchema(s string) (*schema, error) {
+	schemaCache.Lock()
+	defer schemaCache.Unlock()
+	if schemaCache.sc == nil || schemaCache.s != s {
+		schemaCache.s = s
+		schemaCache.sc, schemaCache.err = parseSchema(s)
+	}
+	return schemaCache.sc, schemaCache.err
+}
+
+func (sc *schema) addMessage(md *messageDesc) {
+	if _, ok := sc.messages[md.fullName]; !ok {
+		sc.order = append(sc.order, md.fullName)
+	}
+	sc.messages[md.fullName] = md
+}
+
+func (sc *schema) addEnum(fullName string, values map[uint64]string) {
+	sc.enums[fullName] = values
+}
+
+func (sc *schema) addMethod(service string, name string, md methodDesc) {
+	sc.methods["/"+service+"/"+name] = md
+}
+
+// message name for request or response of a gRPC method path
+func (sc *schema) methodMessage(path string, isResponse bool) (string, bool) {
+	md, ok := sc.methods[path]
+	if !ok {
+		return "", false
+	}
+	name := md.input
+	if isResponse {
+		name = md.output
+	}
+	n, ok := sc.resolveType(md.scope, name)
+	if _, isMessage := sc.messages[n]; !ok || !isMessage {
+		return "", false
+	}
+	return n, true
+}
+
+// resolve type name using protobuf scoping rules, search from innermost scope and outwards
+func (sc *schema) resolveType(scope string, name string) (string, bool) {
+	has := func(n string) bool {
+		_, isMessage := sc.messages[n]
+		_, isEnum := sc.enums[n]
+		return isMessage || isEnum
+	}
+
+	if strings.HasPrefix(name, ".") {
+		n := name[1:]
+		return n, has(n)
+	}
+	parts := strings.Split(scope, ".")
+	if scope == "" {
+		parts = nil
+	}
+	for i := len(parts); i >= 0; i-- {
+		n := strings.Join(append(append([]string{}, parts[0:i]...), name), ".")
+		if has(n) {
+			return n, true
+		}
+	}
+	return "", false
+}
+
+// find message by fully qualified name or unique unqualified name, empty name
+// is first message in schema
+func (sc *schema) findMessage(name string) (string, error) {
+	name = strings.TrimPrefix(name, ".")
+	if name == "" {
+		if len(sc.order) == 0 {
+			return "", fmt.Errorf("schema has no messages")
+		}
+		return sc.order[0], nil
+	}
+	if _, ok := sc.messages[name]; ok {
+		return name, nil
+	}
+	var found []string
+	for n := range sc.messages {
+		if strings.HasSuffix(n, "."+name) {
+			found = append(found, n)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("message %q not found", name)
+	case 1:
+		return found[0], nil
+	default:
+		sort.Strings(found)
+		return "", fmt.Errorf("message %q is ambiguous, found %s", name, strings.Join(found, ", "))
+	}
+}
+
+// message resolves messages into a format.ProtoBufMessage, recursive messages
+// share the same map
+func (sc *schema) message(fullName string) (format.ProtoBufMessage, error) {
+	if m, ok := sc.resolved[fullName]; ok {
+		return m, nil
+	}
+	md, ok := sc.messages[fullName]
+	if !ok {
+		return nil, fmt.Errorf("message %q not found", fullName)
+	}
+
+	m := format.ProtoBufMessage{}
+	sc.resolved[fullName] = m
+
+	for _, fd := range md.fields {
+		f := format.ProtoBufField{
+			Type:  fd.typ,
+			Name:  fd.name,
+			Oneof: fd.oneof,
+		}
+		if fd.typeName != "" {
+			n, ok := sc.resolveType(fd.scope, fd.typeName)
+			if !ok {
+				return nil, fmt.Errorf("%s.%s: type %q not found", fullName, fd.name, fd.typeName)
+			}
+			f.TypeName = n
+			if enums, ok := sc.enums[n]; ok {
+				f.Type = format.ProtoBufTypeEnum
+				f.Enums = enums
+			} else {
+				f.Type = format.ProtoBufTypeMessage
+				var err error
+				if f.Message, err = sc.message(n); err != nil {
+					return nil, err
+				}
+			}
+		}
+		m[fd.number] = f
+	}
+
+	return m, nil
+}
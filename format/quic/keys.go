@@ -0,0 +1,216 @@
+package quic
+
+// Packet and header protection
+// https://www.rfc-editor.org/rfc/rfc9001#section-5
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// https://www.rfc-editor.org/rfc/rfc9001#section-5.2
+var initialSaltV1 = []byte{
+	0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+	0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a,
+}
+
+const (
+	cipherSuiteAES128GCMSHA256        = 0x1301
+	cipherSuiteAES256GCMSHA384        = 0x1302
+	cipherSuiteChaCha20Poly1305SHA256 = 0x1303
+)
+
+type cipherSuite struct {
+	hash   func() hash.Hash
+	keyLen int
+	aead   func(key []byte) (cipher.AEAD, error)
+	hp     func(key []byte) (func(sample []byte) []byte, error)
+}
+
+func aesGCM(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+// https://www.rfc-editor.org/rfc/rfc9001#section-5.4.3
+func aesHeaderProtection(key []byte) (func(sample []byte) []byte, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return func(sample []byte) []byte {
+		mask := make([]byte, aes.BlockSize)
+		b.Encrypt(mask, sample[0:aes.BlockSize])
+		return mask[0:5]
+	}, nil
+}
+
+// https://www.rfc-editor.org/rfc/rfc9001#section-5.4.4
+func chaChaHeaderProtection(key []byte) (func(sample []byte) []byte, error) {
+	return func(sample []byte) []byte {
+		c, err := chacha20.NewUnauthenticatedCipher(key, sample[4:16])
+		if err != nil {
+			panic(err)
+		}
+		c.SetCounter(binary.LittleEndian.Uint32(sample[0:4]))
+		mask := make([]byte, 5)
+		c.XORKeyStream(mask, mask)
+		return mask
+	}, nil
+}
+
+var cipherSuites = map[uint64]cipherSuite{
+	cipherSuiteAES128GCMSHA256:        {hash: sha256.New, keyLen: 16, aead: aesGCM, hp: aesHeaderProtection},
+	cipherSuiteAES256GCMSHA384:        {hash: sha512.New384, keyLen: 32, aead: aesGCM, hp: aesHeaderProtection},
+	cipherSuiteChaCha20Poly1305SHA256: {hash: sha256.New, keyLen: 32, aead: chacha20poly1305.New, hp: chaChaHeaderProtection},
+}
+
+// https://www.rfc-editor.org/rfc/rfc8446#section-7.1
+func hkdfExpandLabel(h func() hash.Hash, secret []byte, label string, length int) []byte {
+	l := "tls13 " + label
+	info := []byte{byte(length >> 8), byte(length), byte(len(l))}
+	info = append(info, l...)
+	info = append(info, 0) // empty context
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.Expand(h, secret, info), out); err != nil {
+		panic(err)
+	}
+	return out
+}
+
+type keys struct {
+	suite  cipherSuite
+	secret []byte
+	aead   cipher.AEAD
+	iv     []byte
+	hp     func(sample []byte) []byte
+}
+
+func newKeys(suite cipherSuite, secret []byte) (*keys, error) {
+	aead, err := suite.aead(hkdfExpandLabel(suite.hash, secret, "quic key", suite.keyLen))
+	if err != nil {
+		return nil, err
+	}
+	hp, err := suite.hp(hkdfExpandLabel(suite.hash, secret, "quic hp", suite.keyLen))
+	if err != nil {
+		return nil, err
+	}
+	return &keys{
+		suite:  suite,
+		secret: secret,
+		aead:   aead,
+		iv:     hkdfExpandLabel(suite.hash, secret, "quic iv", aead.NonceSize()),
+		hp:     hp,
+	}, nil
+}
+
+// keys for next key phase, header protection key is not updated
+// https://www.rfc-editor.org/rfc/rfc9001#section-6
+func (k *keys) next() (*keys, error) {
+	secret := hkdfExpandLabel(k.suite.hash, k.secret, "quic ku", k.suite.hash().Size())
+	aead, err := k.suite.aead(hkdfExpandLabel(k.suite.hash, secret, "quic key", k.suite.keyLen))
+	if err != nil {
+		return nil, err
+	}
+	return &keys{
+		suite:  k.suite,
+		secret: secret,
+		aead:   aead,
+		iv:     hkdfExpandLabel(k.suite.hash, secret, "quic iv", aead.NonceSize()),
+		hp:     k.hp,
+	}, nil
+}
+
+// https://www.rfc-editor.org/rfc/rfc9001#section-5.2
+func newInitialKeys(dcid []byte, isClient bool) *keys {
+	initialSecret := hkdf.Extract(sha256.New, dcid, initialSaltV1)
+	label := "server in"
+	if isClient {
+		label = "client in"
+	}
+	k, err := newKeys(
+		cipherSuites[cipherSuiteAES128GCMSHA256],
+		hkdfExpandLabel(sha256.New, initialSecret, label, sha256.Size),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return k
+}
+
+// https://www.rfc-editor.org/rfc/rfc9000#appendix-A.3
+func decodePacketNumber(largest int64, truncated uint64, bits int) uint64 {
+	expected := uint64(largest + 1)
+	win := uint64(1) << bits
+	hwin := win / 2
+	mask := win - 1
+	candidate := (expected &^ mask) | truncated
+	switch {
+	case candidate+hwin <= expected && candidate < (1<<62)-win:
+		return candidate + win
+	case candidate > expected+hwin && candidate >= win:
+		return candidate - win
+	default:
+		return candidate
+	}
+}
+
+type unprotected struct {
+	firstByte byte
+	pnLen     int
+	pn        uint64
+	plain     []byte
+}
+
+// unprotect removes header protection and decrypts packet, pnOffset is offset
+// of packet number in bytes and packet is the whole packet
+func (k *keys) unprotect(packet []byte, pnOffset int, isLong bool, largest int64) (unprotected, bool) {
+	const sampleLen = 16
+	if len(packet) < pnOffset+4+sampleLen {
+		return unprotected{}, false
+	}
+	mask := k.hp(packet[pnOffset+4 : pnOffset+4+sampleLen])
+
+	header := append([]byte(nil), packet[0:pnOffset+4]...)
+	if isLong {
+		header[0] ^= mask[0] & 0x0f
+	} else {
+		header[0] ^= mask[0] & 0x1f
+	}
+	pnLen := int(header[0]&0x03) + 1
+	header = header[0 : pnOffset+pnLen]
+	var truncated uint64
+	for i := 0; i < pnLen; i++ {
+		header[pnOffset+i] ^= mask[1+i]
+		truncated = truncated<<8 | uint64(header[pnOffset+i])
+	}
+	pn := decodePacketNumber(largest, truncated, pnLen*8)
+
+	nonce := append([]byte(nil), k.iv...)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+	plain, err := k.aead.Open(nil, nonce, packet[pnOffset+pnLen:], header)
+	if err != nil {
+		return unprotected{}, false
+	}
+
+	return unprotected{
+		firstByte: header[0],
+		pnLen:     pnLen,
+		pn:        pn,
+		plain:     plain,
+	}, true
+}
@@ -0,0 +1,824 @@
+package quic
+
+// https://www.rfc-editor.org/rfc/rfc9000
+// https://www.rfc-editor.org/rfc/rfc9001
+//
+// TODO: QUIC version 2, different salt and packet types
+// TODO: 0-RTT decryption, needs cipher suite from resumed session
+// TODO: decode HTTP/3 streams
+
+import (
+	"bytes"
+	"embed"
+	"encoding/binary"
+	"sort"
+
+	"github.com/wader/fq/format"
+	"github.com/wader/fq/format/tls/keylog"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/interp"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+//go:embed quic.md
+var quicFS embed.FS
+
+var tlsFormat decode.Group
+
+func init() {
+	interp.RegisterFormat(decode.Format{
+		Name:         format.QUIC,
+		Description:  "QUIC",
+		Groups:       []string{format.UDP_PAYLOAD, format.UDP_FLOW},
+		DecodeFn:     quicDecode,
+		DefaultInArg: format.QUICIn{},
+		Dependencies: []decode.Dependency{
+			{Names: []string{format.TLS}, Group: &tlsFormat},
+		},
+	})
+	interp.RegisterFS(quicFS)
+}
+
+const (
+	version1                 = 0x00000001
+	versionNegotiation       = 0x00000000
+	version2                 = 0x6b3343cf
+	maxConnectionIDLength    = 20
+	headerFormLong           = 1
+	headerFormShort          = 0
+	longPacketTypeInitial    = 0
+	longPacketTypeZeroRTT    = 1
+	longPacketTypeHandshake  = 2
+	longPacketTypeRetry      = 3
+	retryIntegrityTagLength  = 16
+	statelessResetTokenBytes = 16
+)
+
+var versionNames = scalar.UintMapSymStr{
+	versionNegotiation: "version_negotiation",
+	version1:           "v1",
+	version2:           "v2",
+}
+
+var headerFormNames = scalar.UintMapSymStr{
+	headerFormLong:  "long",
+	headerFormShort: "short",
+}
+
+var longPacketTypeNames = scalar.UintMapSymStr{
+	longPacketTypeInitial:   "initial",
+	longPacketTypeZeroRTT:   "0rtt",
+	longPacketTypeHandshake: "handshake",
+	longPacketTypeRetry:     "retry",
+}
+
+// packet number spaces
+const (
+	spaceInitial = iota
+	spaceHandshake
+	spaceApplication
+	spaceCount
+)
+
+// https://www.rfc-editor.org/rfc/rfc9000#section-19
+const (
+	frameTypePadding            = 0x00
+	frameTypePing               = 0x01
+	frameTypeAck                = 0x02
+	frameTypeAckECN             = 0x03
+	frameTypeResetStream        = 0x04
+	frameTypeStopSending        = 0x05
+	frameTypeCrypto             = 0x06
+	frameTypeNewToken           = 0x07
+	frameTypeStream             = 0x08 // 0x08-0x0f, low bits are OFF, LEN and FIN
+	frameTypeMaxData            = 0x10
+	frameTypeMaxStreamData      = 0x11
+	frameTypeMaxStreamsBidi     = 0x12
+	frameTypeMaxStreamsUni      = 0x13
+	frameTypeDataBlocked        = 0x14
+	frameTypeStreamDataBlocked  = 0x15
+	frameTypeStreamsBlockedBidi = 0x16
+	frameTypeStreamsBlockedUni  = 0x17
+	frameTypeNewConnectionID    = 0x18
+	frameTypeRetireConnectionID = 0x19
+	frameTypePathChallenge      = 0x1a
+	frameTypePathResponse       = 0x1b
+	frameTypeConnectionClose    = 0x1c
+	frameTypeConnectionCloseApp = 0x1d
+	frameTypeHandshakeDone      = 0x1e
+	frameTypeDatagram           = 0x30 // https://www.rfc-editor.org/rfc/rfc9221
+	frameTypeDatagramLen        = 0x31
+)
+
+const (
+	streamFlagOffset = 0x04
+	streamFlagLength = 0x02
+	streamFlagFin    = 0x01
+)
+
+var frameTypeNames = scalar.UintMapSymStr{
+	frameTypePadding:            "padding",
+	frameTypePing:               "ping",
+	frameTypeAck:                "ack",
+	frameTypeAckECN:             "ack_ecn",
+	frameTypeResetStream:        "reset_stream",
+	frameTypeStopSending:        "stop_sending",
+	frameTypeCrypto:             "crypto",
+	frameTypeNewToken:           "new_token",
+	0x08:                        "stream",
+	0x09:                        "stream",
+	0x0a:                        "stream",
+	0x0b:                        "stream",
+	0x0c:                        "stream",
+	0x0d:                        "stream",
+	0x0e:                        "stream",
+	0x0f:                        "stream",
+	frameTypeMaxData:            "max_data",
+	frameTypeMaxStreamData:      "max_stream_data",
+	frameTypeMaxStreamsBidi:     "max_streams_bidi",
+	frameTypeMaxStreamsUni:      "max_streams_uni",
+	frameTypeDataBlocked:        "data_blocked",
+	frameTypeStreamDataBlocked:  "stream_data_blocked",
+	frameTypeStreamsBlockedBidi: "streams_blocked_bidi",
+	frameTypeStreamsBlockedUni:  "streams_blocked_uni",
+	frameTypeNewConnectionID:    "new_connection_id",
+	frameTypeRetireConnectionID: "retire_connection_id",
+	frameTypePathChallenge:      "path_challenge",
+	frameTypePathResponse:       "path_response",
+	frameTypeConnectionClose:    "connection_close",
+	frameTypeConnectionCloseApp: "connection_close_application",
+	frameTypeHandshakeDone:      "handshake_done",
+	frameTypeDatagram:           "datagram",
+	frameTypeDatagramLen:        "datagram",
+}
+
+// https://www.rfc-editor.org/rfc/rfc9000#section-20.1
+var transportErrorNames = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	names := map[uint64]string{
+		0x00: "no_error",
+		0x01: "internal_error",
+		0x02: "connection_refused",
+		0x03: "flow_control_error",
+		0x04: "stream_limit_error",
+		0x05: "stream_state_error",
+		0x06: "final_size_error",
+		0x07: "frame_encoding_error",
+		0x08: "transport_parameter_error",
+		0x09: "connection_id_limit_error",
+		0x0a: "protocol_violation",
+		0x0b: "invalid_token",
+		0x0c: "application_error",
+		0x0d: "crypto_buffer_exceeded",
+		0x0e: "key_update_error",
+		0x0f: "aead_limit_reached",
+		0x10: "no_viable_path",
+	}
+	if n, ok := names[s.Actual]; ok {
+		s.Sym = n
+	} else if s.Actual >= 0x0100 && s.Actual <= 0x01ff {
+		// TLS alert is in the low byte
+		s.Sym = "crypto_error"
+	}
+	return s, nil
+})
+
+// https://www.rfc-editor.org/rfc/rfc9000#section-2.1
+var streamIDTypeNames = scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	s.Sym = [...]string{"client_bidi", "server_bidi", "client_uni", "server_uni"}[s.Actual&0x3]
+	return s, nil
+})
+
+// https://www.rfc-editor.org/rfc/rfc9000#section-16
+func varint(d *decode.D) uint64 {
+	n := d.U2()
+	return d.U(int(8<<n) - 2)
+}
+
+func fieldVarint(d *decode.D, name string, sms ...scalar.UintMapper) uint64 {
+	return d.FieldUintFn(name, varint, sms...)
+}
+
+// byte offset and data of a CRYPTO or STREAM frame
+type chunk struct {
+	offset uint64
+	data   []byte
+}
+
+// contiguous data from offset zero, overlapping chunks are assumed to have same data
+func reassemble(chunks []chunk) []byte {
+	sorted := append([]chunk(nil), chunks...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].offset < sorted[j].offset })
+
+	var b []byte
+	for _, c := range sorted {
+		if c.offset > uint64(len(b)) {
+			break
+		}
+		if end := c.offset + uint64(len(c.data)); end > uint64(len(b)) {
+			b = append(b, c.data[uint64(len(b))-c.offset:]...)
+		}
+	}
+	return b
+}
+
+type stream struct {
+	id       uint64
+	isClient bool
+	chunks   []chunk
+}
+
+// state for one direction of a connection
+type endpoint struct {
+	crypto    [spaceCount][]chunk
+	largestPN [spaceCount]int64
+	keys      [spaceCount]*keys
+	keyPhase  bool
+	// length of destination connection ID in short header packets sent by this endpoint
+	cidLength int
+}
+
+type conn struct {
+	keylog      keylog.Map
+	initialDCID []byte
+	client      endpoint
+	server      endpoint
+	streams     []*stream
+}
+
+func newConn(kl keylog.Map) *conn {
+	c := &conn{keylog: kl}
+	for _, e := range []*endpoint{&c.client, &c.server} {
+		for i := range e.largestPN {
+			e.largestPN[i] = -1
+		}
+		e.cidLength = -1
+	}
+	return c
+}
+
+func (c *conn) endpoint(isClient bool) *endpoint {
+	if isClient {
+		return &c.client
+	}
+	return &c.server
+}
+
+// client random from client hello and cipher suite from server hello
+// https://www.rfc-editor.org/rfc/rfc8446#section-4.1.2
+func (c *conn) handshakeParams() ([32]byte, uint64, bool) {
+	var random [32]byte
+	ch := reassemble(c.client.crypto[spaceInitial])
+	if len(ch) < 38 || ch[0] != 1 {
+		return random, 0, false
+	}
+	copy(random[:], ch[6:38])
+
+	sh := reassemble(c.server.crypto[spaceInitial])
+	if len(sh) < 39 || sh[0] != 2 {
+		return random, 0, false
+	}
+	sessionIDEnd := 39 + int(sh[38])
+	if len(sh) < sessionIDEnd+2 {
+		return random, 0, false
+	}
+	return random, uint64(binary.BigEndian.Uint16(sh[sessionIDEnd:])), true
+}
+
+// keys for packets sent by client or server in a packet number space, nil if not known
+func (c *conn) keys(isClient bool, space int) *keys {
+	e := c.endpoint(isClient)
+	if e.keys[space] != nil {
+		return e.keys[space]
+	}
+
+	switch space {
+	case spaceInitial:
+		if c.initialDCID == nil {
+			return nil
+		}
+		e.keys[space] = newInitialKeys(c.initialDCID, isClient)
+	default:
+		if c.keylog == nil {
+			return nil
+		}
+		random, suiteID, ok := c.handshakeParams()
+		if !ok {
+			return nil
+		}
+		suite, ok := cipherSuites[suiteID]
+		if !ok {
+			return nil
+		}
+		label := map[[2]bool]int{
+			{false, false}: keylog.ServerHandshakeTrafficSecret,
+			{true, false}:  keylog.ClientHandshakeTrafficSecret,
+			{false, true}:  keylog.ServerTrafficSecret0,
+			{true, true}:   keylog.ClientTrafficSecret0,
+		}[[2]bool{isClient, space == spaceApplication}]
+		secret, ok := c.keylog.Lookup(label, random)
+		if !ok {
+			return nil
+		}
+		k, err := newKeys(suite, secret)
+		if err != nil {
+			return nil
+		}
+		e.keys[space] = k
+	}
+
+	return e.keys[space]
+}
+
+func (c *conn) stream(id uint64, isClient bool) *stream {
+	for _, s := range c.streams {
+		if s.id == id && s.isClient == isClient {
+			return s
+		}
+	}
+	s := &stream{id: id, isClient: isClient}
+	c.streams = append(c.streams, s)
+	return s
+}
+
+func decodeFrames(d *decode.D, c *conn, isClient bool, space int) {
+	e := c.endpoint(isClient)
+
+	for !d.End() {
+		d.FieldStruct("frame", func(d *decode.D) {
+			typ := fieldVarint(d, "type", frameTypeNames)
+
+			switch {
+			case typ == frameTypePadding:
+				// padding is usually many zero bytes, combine into one frame
+				n := 0
+				for b := d.PeekBytes(int(d.BitsLeft() / 8)); n < len(b) && b[n] == 0; n++ {
+				}
+				if n > 0 {
+					d.FieldRawLen("padding", int64(n)*8)
+				}
+			case typ == frameTypePing,
+				typ == frameTypeHandshakeDone:
+			case typ == frameTypeAck,
+				typ == frameTypeAckECN:
+				fieldVarint(d, "largest_acknowledged")
+				fieldVarint(d, "ack_delay")
+				rangeCount := fieldVarint(d, "ack_range_count")
+				fieldVarint(d, "first_ack_range")
+				d.FieldArray("ack_ranges", func(d *decode.D) {
+					for i := uint64(0); i < rangeCount; i++ {
+						d.FieldStruct("ack_range", func(d *decode.D) {
+							fieldVarint(d, "gap")
+							fieldVarint(d, "ack_range_length")
+						})
+					}
+				})
+				if typ == frameTypeAckECN {
+					d.FieldStruct("ecn_counts", func(d *decode.D) {
+						fieldVarint(d, "ect0_count")
+						fieldVarint(d, "ect1_count")
+						fieldVarint(d, "ecn_ce_count")
+					})
+				}
+			case typ == frameTypeResetStream:
+				fieldVarint(d, "stream_id", streamIDTypeNames)
+				fieldVarint(d, "application_protocol_error_code")
+				fieldVarint(d, "final_size")
+			case typ == frameTypeStopSending:
+				fieldVarint(d, "stream_id", streamIDTypeNames)
+				fieldVarint(d, "application_protocol_error_code")
+			case typ == frameTypeCrypto:
+				offset := fieldVarint(d, "offset")
+				length := fieldVarint(d, "length")
+				e.crypto[space] = append(e.crypto[space], chunk{
+					offset: offset,
+					data:   d.PeekBytes(int(length)),
+				})
+				d.FieldRawLen("data", int64(length)*8)
+			case typ == frameTypeNewToken:
+				length := fieldVarint(d, "token_length")
+				d.FieldRawLen("token", int64(length)*8)
+			case typ >= frameTypeStream && typ <= frameTypeStream|0x07:
+				id := fieldVarint(d, "stream_id", streamIDTypeNames)
+				var offset uint64
+				if typ&streamFlagOffset != 0 {
+					offset = fieldVarint(d, "offset")
+				}
+				length := uint64(d.BitsLeft() / 8)
+				if typ&streamFlagLength != 0 {
+					length = fieldVarint(d, "length")
+				}
+				d.FieldValueBool("fin", typ&streamFlagFin != 0)
+				s := c.stream(id, isClient)
+				s.chunks = append(s.chunks, chunk{
+					offset: offset,
+					data:   d.PeekBytes(int(length)),
+				})
+				d.FieldRawLen("data", int64(length)*8)
+			case typ == frameTypeMaxData,
+				typ == frameTypeDataBlocked:
+				fieldVarint(d, "maximum_data")
+			case typ == frameTypeMaxStreamData,
+				typ == frameTypeStreamDataBlocked:
+				fieldVarint(d, "stream_id", streamIDTypeNames)
+				fieldVarint(d, "maximum_stream_data")
+			case typ == frameTypeMaxStreamsBidi,
+				typ == frameTypeMaxStreamsUni,
+				typ == frameTypeStreamsBlockedBidi,
+				typ == frameTypeStreamsBlockedUni:
+				fieldVarint(d, "maximum_streams")
+			case typ == frameTypeNewConnectionID:
+				fieldVarint(d, "sequence_number")
+				fieldVarint(d, "retire_prior_to")
+				length := d.FieldU8("length")
+				d.FieldRawLen("connection_id", int64(length)*8)
+				d.FieldRawLen("stateless_reset_token", statelessResetTokenBytes*8)
+			case typ == frameTypeRetireConnectionID:
+				fieldVarint(d, "sequence_number")
+			case typ == frameTypePathChallenge,
+				typ == frameTypePathResponse:
+				d.FieldRawLen("data", 8*8)
+			case typ == frameTypeConnectionClose,
+				typ == frameTypeConnectionCloseApp:
+				if typ == frameTypeConnectionClose {
+					fieldVarint(d, "error_code", transportErrorNames)
+					fieldVarint(d, "frame_type", frameTypeNames)
+				} else {
+					fieldVarint(d, "error_code")
+				}
+				length := fieldVarint(d, "reason_phrase_length")
+				d.FieldUTF8("reason_phrase", int(length))
+			case typ == frameTypeDatagram:
+				d.FieldRawLen("data", d.BitsLeft())
+			case typ == frameTypeDatagramLen:
+				length := fieldVarint(d, "length")
+				d.FieldRawLen("data", int64(length)*8)
+			default:
+				// unknown frame, can't know length
+				d.FieldRawLen("data", d.BitsLeft())
+			}
+		})
+	}
+}
+
+// long header layout, offsets in bytes from start of packet
+type longHeader struct {
+	version    uint64
+	packetType uint64
+	dcid       []byte
+	scid       []byte
+	pnOffset   int
+	length     int // packet length including header
+}
+
+// parse long header without decoding fields, returns false if not valid
+func parseLongHeader(b []byte) (longHeader, bool) {
+	var lh longHeader
+	if len(b) < 7 || b[0]&0x80 == 0 {
+		return lh, false
+	}
+	lh.version = uint64(binary.BigEndian.Uint32(b[1:5]))
+	lh.packetType = uint64(b[0]>>4) & 0x3
+
+	p := 5
+	readCID := func() ([]byte, bool) {
+		if p >= len(b) {
+			return nil, false
+		}
+		l := int(b[p])
+		p++
+		// only v1 has a connection ID length limit
+		if (lh.version == version1 && l > maxConnectionIDLength) || p+l > len(b) {
+			return nil, false
+		}
+		cid := b[p : p+l]
+		p += l
+		return cid, true
+	}
+	var ok bool
+	if lh.dcid, ok = readCID(); !ok {
+		return lh, false
+	}
+	if lh.scid, ok = readCID(); !ok {
+		return lh, false
+	}
+	if lh.version != version1 {
+		// version negotiation or unknown version takes rest of datagram
+		lh.length = len(b)
+		return lh, true
+	}
+	if b[0]&0x40 == 0 {
+		// fixed bit must be set
+		return lh, false
+	}
+
+	readVarint := func() (uint64, bool) {
+		if p >= len(b) {
+			return 0, false
+		}
+		l := 1 << (b[p] >> 6)
+		if p+l > len(b) {
+			return 0, false
+		}
+		v := uint64(b[p] & 0x3f)
+		for i := 1; i < l; i++ {
+			v = v<<8 | uint64(b[p+i])
+		}
+		p += l
+		return v, true
+	}
+
+	switch lh.packetType {
+	case longPacketTypeRetry:
+		if len(b)-p < retryIntegrityTagLength {
+			return lh, false
+		}
+		lh.length = len(b)
+		return lh, true
+	case longPacketTypeInitial:
+		tokenLength, ok := readVarint()
+		if !ok || uint64(len(b)-p) < tokenLength {
+			return lh, false
+		}
+		p += int(tokenLength)
+	}
+
+	length, ok := readVarint()
+	if !ok || uint64(len(b)-p) < length {
+		return lh, false
+	}
+	lh.pnOffset = p
+	lh.length = p + int(length)
+
+	return lh, true
+}
+
+func packetSpace(packetType uint64) int {
+	switch packetType {
+	case longPacketTypeInitial:
+		return spaceInitial
+	case longPacketTypeHandshake:
+		return spaceHandshake
+	default:
+		return spaceApplication
+	}
+}
+
+// packet number and payload of a protected packet
+func decodeProtectedPayload(d *decode.D, c *conn, isClient bool, space int, u unprotected, payloadLen int) {
+	d.FieldUintFn("packet_number", func(d *decode.D) uint64 {
+		d.SeekRel(int64(u.pnLen) * 8)
+		return u.pn
+	})
+	d.FieldRawLen("payload", int64(payloadLen-u.pnLen)*8)
+	d.FieldArrayRootBitBufFn("frames", bitio.NewBitReader(u.plain, -1), func(d *decode.D) {
+		decodeFrames(d, c, isClient, space)
+	})
+}
+
+func decodeLongPacket(d *decode.D, c *conn, isClient bool, lh longHeader, b []byte) {
+	if lh.version == version1 && lh.packetType == longPacketTypeInitial && isClient && c.initialDCID == nil {
+		c.initialDCID = append([]byte(nil), lh.dcid...)
+	}
+
+	space := packetSpace(lh.packetType)
+	var u unprotected
+	var unprotectedOk bool
+	hasPacketNumber := lh.version == version1 && lh.packetType != longPacketTypeRetry
+	if hasPacketNumber && lh.packetType != longPacketTypeZeroRTT {
+		if k := c.keys(isClient, space); k != nil {
+			e := c.endpoint(isClient)
+			u, unprotectedOk = k.unprotect(b[0:lh.length], lh.pnOffset, true, e.largestPN[space])
+			if unprotectedOk && int64(u.pn) > e.largestPN[space] {
+				e.largestPN[space] = int64(u.pn)
+			}
+		}
+	}
+	if lh.version == version1 {
+		// short header packets sent by the peer use our source connection ID
+		c.endpoint(!isClient).cidLength = len(lh.scid)
+	}
+
+	d.FieldU1("header_form", headerFormNames)
+	switch {
+	case lh.version == versionNegotiation:
+		d.FieldU7("unused")
+	case unprotectedOk:
+		d.FieldU1("fixed_bit")
+		d.FieldU2("long_packet_type", longPacketTypeNames)
+		d.FieldUintFn("reserved_bits", func(d *decode.D) uint64 {
+			d.SeekRel(2)
+			return uint64(u.firstByte>>2) & 0x3
+		})
+		d.FieldUintFn("packet_number_length", func(d *decode.D) uint64 {
+			d.SeekRel(2)
+			return uint64(u.firstByte & 0x3)
+		}, scalar.UintActualAdd(1))
+	case hasPacketNumber:
+		d.FieldU1("fixed_bit")
+		d.FieldU2("long_packet_type", longPacketTypeNames)
+		d.FieldU4("protected_bits")
+	default:
+		d.FieldU1("fixed_bit")
+		d.FieldU2("long_packet_type", longPacketTypeNames)
+		d.FieldU4("type_specific_bits")
+	}
+	d.FieldU32("version", versionNames, scalar.UintHex)
+	dcidLength := d.FieldU8("destination_connection_id_length")
+	d.FieldRawLen("destination_connection_id", int64(dcidLength)*8)
+	scidLength := d.FieldU8("source_connection_id_length")
+	d.FieldRawLen("source_connection_id", int64(scidLength)*8)
+
+	switch {
+	case lh.version == versionNegotiation:
+		d.FieldArray("supported_versions", func(d *decode.D) {
+			for !d.End() {
+				d.FieldU32("version", versionNames, scalar.UintHex)
+			}
+		})
+		return
+	case lh.version != version1:
+		d.FieldRawLen("data", d.BitsLeft())
+		return
+	case lh.packetType == longPacketTypeRetry:
+		// client uses the new connection ID to derive initial keys
+		if !isClient {
+			c.initialDCID = append([]byte(nil), lh.scid...)
+			c.client.keys[spaceInitial] = nil
+			c.server.keys[spaceInitial] = nil
+		}
+		d.FieldRawLen("retry_token", d.BitsLeft()-retryIntegrityTagLength*8)
+		d.FieldRawLen("retry_integrity_tag", retryIntegrityTagLength*8)
+		return
+	case lh.packetType == longPacketTypeInitial:
+		tokenLength := fieldVarint(d, "token_length")
+		d.FieldRawLen("token", int64(tokenLength)*8)
+	}
+
+	length := fieldVarint(d, "length")
+	if unprotectedOk {
+		decodeProtectedPayload(d, c, isClient, space, u, int(length))
+	} else {
+		d.FieldRawLen("protected_payload", int64(length)*8)
+	}
+}
+
+func decodeShortPacket(d *decode.D, c *conn, isClient bool, b []byte) {
+	e := c.endpoint(isClient)
+
+	var u unprotected
+	var unprotectedOk bool
+	k := c.keys(isClient, spaceApplication)
+	if k != nil && e.cidLength >= 0 {
+		u, unprotectedOk = k.unprotect(b, 1+e.cidLength, false, e.largestPN[spaceApplication])
+		if unprotectedOk && (u.firstByte&0x04 != 0) != e.keyPhase {
+			unprotectedOk = false
+		}
+		if !unprotectedOk {
+			// might be a key update, header protection key is the same
+			if nk, err := k.next(); err == nil {
+				if u, unprotectedOk = nk.unprotect(b, 1+e.cidLength, false, e.largestPN[spaceApplication]); unprotectedOk {
+					e.keys[spaceApplication] = nk
+					e.keyPhase = u.firstByte&0x04 != 0
+				}
+			}
+		}
+		if unprotectedOk && int64(u.pn) > e.largestPN[spaceApplication] {
+			e.largestPN[spaceApplication] = int64(u.pn)
+		}
+	}
+
+	d.FieldU1("header_form", headerFormNames)
+	d.FieldU1("fixed_bit")
+	d.FieldU1("spin_bit")
+	if !unprotectedOk {
+		d.FieldU5("protected_bits")
+		if e.cidLength >= 0 {
+			d.FieldRawLen("destination_connection_id", int64(e.cidLength)*8)
+		}
+		d.FieldRawLen("protected_payload", d.BitsLeft())
+		return
+	}
+	d.FieldUintFn("reserved_bits", func(d *decode.D) uint64 {
+		d.SeekRel(2)
+		return uint64(u.firstByte>>3) & 0x3
+	})
+	d.FieldUintFn("key_phase", func(d *decode.D) uint64 {
+		d.SeekRel(1)
+		return uint64(u.firstByte>>2) & 0x1
+	})
+	d.FieldUintFn("packet_number_length", func(d *decode.D) uint64 {
+		d.SeekRel(2)
+		return uint64(u.firstByte & 0x3)
+	}, scalar.UintActualAdd(1))
+	d.FieldRawLen("destination_connection_id", int64(e.cidLength)*8)
+	decodeProtectedPayload(d, c, isClient, spaceApplication, u, int(d.BitsLeft()/8))
+}
+
+// decode packets in a datagram, long header packets can be coalesced and a short
+// header packet takes the rest of the datagram
+func decodeDatagram(d *decode.D, c *conn, isClient bool) {
+	d.FieldArray("packets", func(d *decode.D) {
+		for !d.End() {
+			b := d.PeekBytes(int(d.BitsLeft() / 8))
+			if b[0] == 0 {
+				// datagram padding after last packet
+				d.FieldRawLen("padding", d.BitsLeft())
+				return
+			}
+
+			if b[0]&0x80 == 0 {
+				d.FieldStruct("packet", func(d *decode.D) { decodeShortPacket(d, c, isClient, b) })
+				return
+			}
+
+			lh, ok := parseLongHeader(b)
+			if !ok {
+				d.FieldRawLen("data", d.BitsLeft())
+				return
+			}
+			d.FieldStruct("packet", func(d *decode.D) {
+				d.FramedFn(int64(lh.length)*8, func(d *decode.D) {
+					decodeLongPacket(d, c, isClient, lh, b)
+				})
+			})
+		}
+	})
+}
+
+func fieldCrypto(d *decode.D, name string, e *endpoint) {
+	buf := &bytes.Buffer{}
+	for _, chunks := range e.crypto {
+		buf.Write(reassemble(chunks))
+	}
+	if buf.Len() == 0 {
+		return
+	}
+	br := bitio.NewBitReader(buf.Bytes(), -1)
+	if dv, _, _ := d.TryFieldFormatBitBuf(name, br, tlsFormat, format.TLSHandshakeIn{}); dv == nil {
+		d.FieldRootBitBuf(name, br)
+	}
+}
+
+func quicDecode(d *decode.D) any {
+	var qi format.QUICIn
+	d.ArgAs(&qi)
+
+	var kl keylog.Map
+	if qi.Keylog != "" {
+		var err error
+		if kl, err = keylog.Parse(qi.Keylog); err != nil {
+			d.Fatalf("failed to parse keylog: %s", err)
+		}
+	}
+	c := newConn(kl)
+
+	var ufi format.UDPFlowIn
+	if !d.ArgAs(&ufi) {
+		// single datagram, only long header packets can be decoded without connection state
+		b := d.PeekBytes(int(d.BitsLeft() / 8))
+		if len(b) == 0 || b[0]&0x80 == 0 {
+			d.Fatalf("not a long header packet")
+		}
+		if _, ok := parseLongHeader(b); !ok {
+			d.Fatalf("invalid long header")
+		}
+		// assume it's from client, initial keys are derived from client destination connection ID
+		decodeDatagram(d, c, true)
+		fieldCrypto(d, "client_crypto", &c.client)
+		return nil
+	}
+
+	if len(ufi.Datagrams) == 0 || !ufi.Datagrams[0].IsClient {
+		d.Fatalf("flow should start with a client datagram")
+	}
+	if lh, ok := parseLongHeader(d.PeekBytes(ufi.Datagrams[0].Length)); !ok || lh.version != version1 || lh.packetType != longPacketTypeInitial {
+		d.Fatalf("flow should start with a v1 initial packet")
+	}
+
+	d.FieldArray("datagrams", func(d *decode.D) {
+		for _, dg := range ufi.Datagrams {
+			d.FieldStruct("datagram", func(d *decode.D) {
+				d.FieldValueBool("is_client", dg.IsClient)
+				d.FramedFn(int64(dg.Length)*8, func(d *decode.D) {
+					decodeDatagram(d, c, dg.IsClient)
+				})
+			})
+		}
+	})
+
+	fieldCrypto(d, "client_crypto", &c.client)
+	fieldCrypto(d, "server_crypto", &c.server)
+
+	d.FieldArray("streams", func(d *decode.D) {
+		for _, s := range c.streams {
+			d.FieldStruct("stream", func(d *decode.D) {
+				d.FieldValueUint("stream_id", s.id, streamIDTypeNames)
+				d.FieldValueBool("is_client", s.isClient)
+				d.FieldRootBitBuf("data", bitio.NewBitReader(reassemble(s.chunks), -1))
+			})
+		}
+	})
+
+	return nil
+}
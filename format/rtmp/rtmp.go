@@ -5,21 +5,21 @@ package rtmp
 
 // TODO: split to rtmp/rtmp_message?
 // TODO: support to skip handshake?
-// TODO: keep track of message stream format, decode aac etc
 
 import (
 	"bytes"
 	"embed"
 
 	"github.com/wader/fq/format"
+	"github.com/wader/fq/format/flvtag"
 	"github.com/wader/fq/pkg/bitio"
 	"github.com/wader/fq/pkg/decode"
 	"github.com/wader/fq/pkg/interp"
 	"github.com/wader/fq/pkg/scalar"
 )
 
-var rtmpAmf0Group decode.Group
-var rtmpMpegASCFormat decode.Group
+// audio, video and amf0 data is decoded same as flv tags
+var rtmpTagGroups flvtag.Groups
 
 //go:embed rtmp.md
 var rtmpFS embed.FS
@@ -31,11 +31,8 @@ func init() {
 		Groups: []string{
 			format.TCP_STREAM,
 		},
-		DecodeFn: rtmpDecode,
-		Dependencies: []decode.Dependency{
-			{Names: []string{format.AMF0}, Group: &rtmpAmf0Group},
-			{Names: []string{format.MPEG_ASC}, Group: &rtmpMpegASCFormat},
-		},
+		DecodeFn:     rtmpDecode,
+		Dependencies: rtmpTagGroups.Dependencies(),
 	})
 	interp.RegisterFS(rtmpFS)
 }
@@ -118,81 +115,6 @@ var timestampUintDescription = scalar.UintMapDescription{
 	timestampExtended: "extended",
 }
 
-const (
-	audioMessageCodecAAC = 10
-)
-
-// based on https://github.com/wireshark/wireshark/blob/master/epan/dissectors/packet-rtmpt.c
-// which in turn is based on rtmp and swf specifications and FLV v10.1 section E.4.3.1
-var audioMessageCodecNames = scalar.UintMapSymStr{
-	0:                    "uncompressed",
-	1:                    "adpcm",
-	2:                    "mp3",
-	3:                    "uncompressed_le",
-	4:                    "nellymoser_16khz",
-	5:                    "nellymoser_8khz",
-	6:                    "nellymoser",
-	7:                    "g711a",
-	8:                    "g711u",
-	9:                    "nellymoser_16khz",
-	audioMessageCodecAAC: "aac",
-	11:                   "speex",
-}
-
-const (
-	audioMessageAACPacketTypeASC = 0
-	audioMessageAACPacketTypeRaw = 1
-)
-
-var audioMessageAACPacketTypeNames = scalar.UintMapSymStr{
-	audioMessageAACPacketTypeASC: "asc",
-	audioMessageAACPacketTypeRaw: "raw",
-}
-
-var audioMessageRateNames = scalar.UintMapSymUint{
-	0: 5500,
-	1: 11025,
-	2: 22050,
-	3: 44100,
-}
-
-var audioMessageSampleSize = scalar.UintMapSymUint{
-	0: 8,
-	1: 16,
-}
-
-var audioMessageChannels = scalar.UintMapSymUint{
-	0: 1,
-	1: 2,
-}
-
-var videoMessageTypeNames = scalar.UintMapSymStr{
-	1: "keyframe",
-	2: "inter_frame",
-	3: "disposable_inter_frame",
-	4: "generated_key_frame",
-	5: "video_info_or_command_frame",
-}
-
-const (
-	videoMessageCodecH264 = 7
-)
-
-var videoMessageCodecNames = scalar.UintMapSymStr{
-	2:                     "h263",
-	3:                     "screen_video",
-	4:                     "vp6",
-	5:                     "vp6_alpha",
-	6:                     "screen_video_v2",
-	videoMessageCodecH264: "h264",
-}
-
-var videoMessageH264PacketTypeNames = scalar.UintMapSymStr{
-	0: "dcr",
-	1: "au", // TODO: is access unit?
-	2: "empty",
-}
-
 // TODO: invalid warning that timestampDelta is unused
 
 //nolint:unused
@@ -204,7 +126,7 @@ type messageHeader struct {
 	messageTypeID   uint64
 }
 
-func rtmpDecodeMessageType(d *decode.D, typ int, chunkSize *int) {
+func rtmpDecodeMessageType(d *decode.D, typ int, chunkSize *int, tags *flvtag.Decoder) {
 	switch typ {
 	case messageTypeSetChunkSize:
 		// TODO: zero bit, verify size? message size is 24 bit
@@ -242,16 +164,16 @@ func rtmpDecodeMessageType(d *decode.D, typ int, chunkSize *int) {
 	case messageTypeDataMessage:
 		d.FieldArray("messages", func(d *decode.D) {
 			for !d.End() {
-				d.FieldFormat("message", rtmpAmf0Group, nil)
+				d.FieldFormat("message", rtmpTagGroups.AMF0, nil)
 			}
 		})
 	case messageTypeCommandMessage:
-		d.FieldFormat("command_name", rtmpAmf0Group, nil)
-		d.FieldFormat("transaction_id", rtmpAmf0Group, nil)
-		d.FieldFormat("command_object", rtmpAmf0Group, nil)
+		d.FieldFormat("command_name", rtmpTagGroups.AMF0, nil)
+		d.FieldFormat("transaction_id", rtmpTagGroups.AMF0, nil)
+		d.FieldFormat("command_object", rtmpTagGroups.AMF0, nil)
 		d.FieldArray("arguments", func(d *decode.D) {
 			for !d.End() {
-				d.FieldFormat("argument", rtmpAmf0Group, nil)
+				d.FieldFormat("argument", rtmpTagGroups.AMF0, nil)
 			}
 		})
 	case messageTypeAggregateMessage:
@@ -265,7 +187,7 @@ func rtmpDecodeMessageType(d *decode.D, typ int, chunkSize *int) {
 					h.messageStreamID = d.FieldU24("message_stream_id")
 					// TODO: possible to set chunk size in aggregated message?
 					d.FramedFn(int64(h.messageLength*8), func(d *decode.D) {
-						rtmpDecodeMessageType(d, int(h.messageTypeID), chunkSize)
+						rtmpDecodeMessageType(d, int(h.messageTypeID), chunkSize, tags)
 					})
 					d.FieldU32("back_pointer")
 				})
@@ -275,33 +197,12 @@ func rtmpDecodeMessageType(d *decode.D, typ int, chunkSize *int) {
 		if d.BitsLeft() == 0 {
 			return
 		}
-		codec := d.FieldU4("codec", audioMessageCodecNames)
-		d.FieldU2("sample_rate", audioMessageRateNames)
-		d.FieldU1("sample_size", audioMessageSampleSize)
-		d.FieldU1("channels", audioMessageChannels)
-		if codec == audioMessageCodecAAC {
-			switch d.FieldU8("type", audioMessageAACPacketTypeNames) {
-			case audioMessageAACPacketTypeASC:
-				d.FieldFormat("data", rtmpMpegASCFormat, nil)
-			default:
-				d.FieldRawLen("data", d.BitsLeft())
-			}
-		} else {
-			d.FieldRawLen("data", d.BitsLeft())
-		}
+		tags.DecodeAudioData(d)
 	case messageTypeVideoMessage:
 		if d.BitsLeft() == 0 {
 			return
 		}
-		d.FieldU4("type", videoMessageTypeNames)
-		codec := d.FieldU4("codec", videoMessageCodecNames)
-		// TODO: flv header + h263 format?
-		// TODO: ffmpeg rtmp proto seems to recrate a flv stream and demux it
-		if codec == videoMessageCodecH264 {
-			d.FieldU8("type", videoMessageH264PacketTypeNames)
-		}
-
-		d.FieldRawLen("data", d.BitsLeft())
+		tags.DecodeVideoData(d)
 	default:
 		d.FieldRawLen("data", d.BitsLeft())
 	}
@@ -318,6 +219,7 @@ func rtmpDecode(d *decode.D) any {
 
 	// chunk size is global for one direction
 	chunkSize := defaultChunkSize
+	tags := flvtag.NewDecoder(&rtmpTagGroups)
 
 	name := "s"
 	if isClient {
@@ -469,7 +371,7 @@ func rtmpDecode(d *decode.D) any {
 					messages.FieldStructRootBitBufFn("message", messageBR, func(d *decode.D) {
 						d.FieldValueUint("message_stream_id", h.messageStreamID)
 						d.FieldValueUint("message_type_id", m.typ, rtmpMessageTypeIDNames)
-						rtmpDecodeMessageType(d, int(m.typ), &chunkSize)
+						rtmpDecodeMessageType(d, int(m.typ), &chunkSize, tags)
 					})
 
 					// delete so that we create a new message{} with a new bytes.Buffer to
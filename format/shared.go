@@ -45,10 +45,12 @@ var ProtoBufTypeNames = scalar.UintMapSymStr{
 }
 
 type ProtoBufField struct {
-	Type    int
-	Name    string
-	Message ProtoBufMessage
-	Enums   map[uint64]string
+	Type     int
+	Name     string
+	Message  ProtoBufMessage
+	Enums    map[uint64]string
+	TypeName string // fully qualified message or enum name, ex: google.protobuf.Timestamp
+	Oneof    string
 }
 
 type ProtoBufMessage map[int]ProtoBufField
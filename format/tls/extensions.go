@@ -129,3 +129,62 @@ var extensionNames = scalar.UintMapSymStr{
 	extensionDnssecChain:                         "dnssec_chain",
 	extensionRenegotiationInfo:                   "renegotiation_info",
 }
+
+// https://www.iana.org/assignments/quic/quic.xhtml#quic-transport
+const (
+	quicTransportParameterOriginalDestinationConnectionID = 0x00
+	quicTransportParameterMaxIdleTimeout                  = 0x01
+	quicTransportParameterStatelessResetToken             = 0x02
+	quicTransportParameterMaxUDPPayloadSize               = 0x03
+	quicTransportParameterInitialMaxData                  = 0x04
+	quicTransportParameterInitialMaxStreamDataBidiLocal   = 0x05
+	quicTransportParameterInitialMaxStreamDataBidiRemote  = 0x06
+	quicTransportParameterInitialMaxStreamDataUni         = 0x07
+	quicTransportParameterInitialMaxStreamsBidi           = 0x08
+	quicTransportParameterInitialMaxStreamsUni            = 0x09
+	quicTransportParameterAckDelayExponent                = 0x0a
+	quicTransportParameterMaxAckDelay                     = 0x0b
+	quicTransportParameterDisableActiveMigration          = 0x0c
+	quicTransportParameterPreferredAddress                = 0x0d
+	quicTransportParameterActiveConnectionIDLimit         = 0x0e
+	quicTransportParameterInitialSourceConnectionID       = 0x0f
+	quicTransportParameterRetrySourceConnectionID         = 0x10
+	quicTransportParameterMaxDatagramFrameSize            = 0x20
+)
+
+var quicTransportParameterNames = scalar.UintMapSymStr{
+	quicTransportParameterOriginalDestinationConnectionID: "original_destination_connection_id",
+	quicTransportParameterMaxIdleTimeout:                  "max_idle_timeout",
+	quicTransportParameterStatelessResetToken:             "stateless_reset_token",
+	quicTransportParameterMaxUDPPayloadSize:               "max_udp_payload_size",
+	quicTransportParameterInitialMaxData:                  "initial_max_data",
+	quicTransportParameterInitialMaxStreamDataBidiLocal:   "initial_max_stream_data_bidi_local",
+	quicTransportParameterInitialMaxStreamDataBidiRemote:  "initial_max_stream_data_bidi_remote",
+	quicTransportParameterInitialMaxStreamDataUni:         "initial_max_stream_data_uni",
+	quicTransportParameterInitialMaxStreamsBidi:           "initial_max_streams_bidi",
+	quicTransportParameterInitialMaxStreamsUni:            "initial_max_streams_uni",
+	quicTransportParameterAckDelayExponent:                "ack_delay_exponent",
+	quicTransportParameterMaxAckDelay:                     "max_ack_delay",
+	quicTransportParameterDisableActiveMigration:          "disable_active_migration",
+	quicTransportParameterPreferredAddress:                "preferred_address",
+	quicTransportParameterActiveConnectionIDLimit:         "active_connection_id_limit",
+	quicTransportParameterInitialSourceConnectionID:       "initial_source_connection_id",
+	quicTransportParameterRetrySourceConnectionID:         "retry_source_connection_id",
+	quicTransportParameterMaxDatagramFrameSize:            "max_datagram_frame_size",
+}
+
+// transport parameters with a variable-length integer value
+var quicTransportParameterIsInt = map[uint64]bool{
+	quicTransportParameterMaxIdleTimeout:                 true,
+	quicTransportParameterMaxUDPPayloadSize:              true,
+	quicTransportParameterInitialMaxData:                 true,
+	quicTransportParameterInitialMaxStreamDataBidiLocal:  true,
+	quicTransportParameterInitialMaxStreamDataBidiRemote: true,
+	quicTransportParameterInitialMaxStreamDataUni:        true,
+	quicTransportParameterInitialMaxStreamsBidi:          true,
+	quicTransportParameterInitialMaxStreamsUni:           true,
+	quicTransportParameterAckDelayExponent:               true,
+	quicTransportParameterMaxAckDelay:                    true,
+	quicTransportParameterActiveConnectionIDLimit:        true,
+	quicTransportParameterMaxDatagramFrameSize:           true,
+}
@@ -40,6 +40,7 @@ import (
 var tlsFS embed.FS
 
 var asn1BerFormat decode.Group
+var tcpStreamFormat decode.Group
 
 func init() {
 	interp.RegisterFormat(decode.Format{
@@ -50,6 +51,7 @@ func init() {
 		DefaultInArg: format.TLSIn{},
 		Dependencies: []decode.Dependency{
 			{Names: []string{format.ASN1_BER}, Group: &asn1BerFormat},
+			{Names: []string{format.TCP_STREAM}, Group: &tcpStreamFormat},
 		},
 	})
 	interp.RegisterFS(tlsFS)
@@ -101,31 +103,42 @@ var recordTypeValid = []uint64{
 }
 
 const (
-	handshakeMsgTypeHelloRequest       = 0
-	handshakeMsgTypeClientHello        = 1
-	handshakeMsgTypeServerHello        = 2
-	handshakeMsgTypeNewSessionTicket   = 4
-	handshakeMsgTypeCertificate        = 11
-	handshakeMsgTypeServerKeyExchange  = 12
-	handshakeMsgTypeCertificateRequest = 13
-	handshakeMsgTypeServerHelloDone    = 14
-	handshakeMsgTypeCertificateVerify  = 15
-	handshakeMsgTypeClientKeyExchange  = 16
-	handshakeMsgTypeFinished           = 20
+	handshakeMsgTypeHelloRequest        = 0
+	handshakeMsgTypeClientHello         = 1
+	handshakeMsgTypeServerHello         = 2
+	handshakeMsgTypeNewSessionTicket    = 4
+	handshakeMsgTypeEndOfEarlyData      = 5
+	handshakeMsgTypeEncryptedExtensions = 8
+	handshakeMsgTypeCertificate         = 11
+	handshakeMsgTypeServerKeyExchange   = 12
+	handshakeMsgTypeCertificateRequest  = 13
+	handshakeMsgTypeServerHelloDone     = 14
+	handshakeMsgTypeCertificateVerify   = 15
+	handshakeMsgTypeClientKeyExchange   = 16
+	handshakeMsgTypeFinished            = 20
+	handshakeMsgTypeKeyUpdate           = 24
 )
 
 var handshakeMsgTypeNames = scalar.UintMapSymStr{
-	handshakeMsgTypeHelloRequest:       "hello_request",
-	handshakeMsgTypeClientHello:        "client_hello",
-	handshakeMsgTypeServerHello:        "server_hello",
-	handshakeMsgTypeNewSessionTicket:   "new_session_ticket",
-	handshakeMsgTypeCertificate:        "certificate",
-	handshakeMsgTypeServerKeyExchange:  "server_key_exchange",
-	handshakeMsgTypeCertificateRequest: "certificate_request",
-	handshakeMsgTypeServerHelloDone:    "server_hello_done",
-	handshakeMsgTypeCertificateVerify:  "certificate_verify",
-	handshakeMsgTypeClientKeyExchange:  "client_key_exchange",
-	handshakeMsgTypeFinished:           "finished",
+	handshakeMsgTypeHelloRequest:        "hello_request",
+	handshakeMsgTypeClientHello:         "client_hello",
+	handshakeMsgTypeServerHello:         "server_hello",
+	handshakeMsgTypeNewSessionTicket:    "new_session_ticket",
+	handshakeMsgTypeEndOfEarlyData:      "end_of_early_data",
+	handshakeMsgTypeEncryptedExtensions: "encrypted_extensions",
+	handshakeMsgTypeCertificate:         "certificate",
+	handshakeMsgTypeServerKeyExchange:   "server_key_exchange",
+	handshakeMsgTypeCertificateRequest:  "certificate_request",
+	handshakeMsgTypeServerHelloDone:     "server_hello_done",
+	handshakeMsgTypeCertificateVerify:   "certificate_verify",
+	handshakeMsgTypeClientKeyExchange:   "client_key_exchange",
+	handshakeMsgTypeFinished:            "finished",
+	handshakeMsgTypeKeyUpdate:           "key_update",
+}
+
+var keyUpdateRequestNames = scalar.UintMapSymStr{
+	0: "update_not_requested",
+	1: "update_requested",
 }
 
 const (
@@ -212,9 +225,12 @@ type keyExchange struct {
 
 type tlsCtx struct {
 	rootD *decode.D
+	tsi   format.TCPStreamIn
 
 	version uint64
 	random  [32]byte
+	// only handshake messages, ex: QUIC, always TLS 1.3
+	isTLS13 bool
 
 	// server only
 	server struct {
@@ -232,6 +248,12 @@ type tlsCtx struct {
 	clientCtx *tlsCtx
 }
 
+// https://www.rfc-editor.org/rfc/rfc9000#section-16
+func quicVarint(d *decode.D) uint64 {
+	n := d.U2()
+	return d.U(int(8<<n) - 2)
+}
+
 func decodeTLSExtension(d *decode.D) {
 	typ := d.FieldU16("type", extensionNames)
 	length := d.FieldU16("length")
@@ -284,6 +306,22 @@ func decodeTLSExtension(d *decode.D) {
 					}
 				})
 			})
+		case extensionQuicTransportParameters:
+			d.FieldArray("parameters", func(d *decode.D) {
+				for !d.End() {
+					d.FieldStruct("parameter", func(d *decode.D) {
+						id := d.FieldUintFn("id", quicVarint, quicTransportParameterNames)
+						length := d.FieldUintFn("length", quicVarint)
+						if quicTransportParameterIsInt[id] {
+							d.FramedFn(int64(length)*8, func(d *decode.D) {
+								d.FieldUintFn("value", quicVarint)
+							})
+						} else {
+							d.FieldRawLen("value", int64(length)*8)
+						}
+					})
+				}
+			})
 		case extensionSignatureAlgorithms:
 			protocolsLength := d.FieldU16("signature_algorithm_length")
 			d.FieldArray("signature_algorithms", func(d *decode.D) {
@@ -359,7 +397,20 @@ func decodeTLSHandshake(d *decode.D, tc *tlsCtx) {
 					})
 				})
 			}
+		case handshakeMsgTypeEncryptedExtensions:
+			extensionsLength := d.FieldU16("extensions_length")
+			d.FramedFn(int64(extensionsLength)*8, func(d *decode.D) {
+				d.FieldArray("extensions", func(d *decode.D) {
+					for !d.End() {
+						d.FieldStruct("extension", decodeTLSExtension)
+					}
+				})
+			})
 		case handshakeMsgTypeCertificate:
+			if tc.isTLS13 {
+				contextLength := d.FieldU8("certificate_request_context_length")
+				d.FieldRawLen("certificate_request_context", int64(contextLength)*8)
+			}
 			certificatesLength := d.FieldU24("certificates_length")
 			d.FramedFn(int64(certificatesLength)*8, func(d *decode.D) {
 				d.FieldArray("certificates", func(d *decode.D) {
@@ -367,6 +418,10 @@ func decodeTLSHandshake(d *decode.D, tc *tlsCtx) {
 						d.FieldStruct("certificate", func(d *decode.D) {
 							length := d.FieldU24("length")
 							d.FieldFormatLen("data", int64(length)*8, asn1BerFormat, nil)
+							if tc.isTLS13 {
+								extensionsLength := d.FieldU16("extensions_length")
+								d.FieldRawLen("extensions", int64(extensionsLength)*8)
+							}
 						})
 					}
 				})
@@ -383,12 +438,30 @@ func decodeTLSHandshake(d *decode.D, tc *tlsCtx) {
 				r:       ranges.Range{Start: d.Pos(), Len: d.Pos() - start},
 				dataV:   dataV,
 			}
+		case handshakeMsgTypeCertificateVerify:
+			d.FieldStruct("signature_algorithm", func(d *decode.D) {
+				d.FieldU8("hash", hashAlgorithmNames)
+				d.FieldU8("signature", signatureAlgorithmNames)
+			})
+			signatureLength := d.FieldU16("signature_length")
+			d.FieldRawLen("signature", int64(signatureLength)*8)
 		case handshakeMsgTypeFinished:
 			d.FieldRawLen("verify_data", d.BitsLeft())
 		case handshakeMsgTypeNewSessionTicket:
 			d.FieldU32("lifetime_hint")
+			if tc.isTLS13 {
+				d.FieldU32("age_add")
+				nonceLength := d.FieldU8("nonce_length")
+				d.FieldRawLen("nonce", int64(nonceLength)*8)
+			}
 			ticketLength := d.FieldU16("ticket_length")
 			d.FieldRawLen("ticket", int64(ticketLength)*8)
+			if tc.isTLS13 {
+				extensionsLength := d.FieldU16("extensions_length")
+				d.FieldRawLen("extensions", int64(extensionsLength)*8)
+			}
+		case handshakeMsgTypeKeyUpdate:
+			d.FieldU8("request_update", keyUpdateRequestNames)
 		default:
 			d.FieldRawLen("data", d.BitsLeft())
 		}
@@ -437,14 +510,15 @@ func decodeTLSRecordMessage(d *decode.D, tc *tlsCtx, recordType uint64) {
 	}
 }
 
-func decodeTLSPostEncryptedRecords(rootD *decode.D, tc *tlsCtx, kl keylog.Map) {
+// returns output of application data stream decode, a format.TCPStreamOut if decoded by a TCP stream format
+func decodeTLSPostEncryptedRecords(rootD *decode.D, tc *tlsCtx, kl keylog.Map) any {
 	// to decrypt tls we need:
 	//  - client random to look up shared master secret
 	//  - client and server random to generate cipher iv/key in both directions
 	masterSecret, _ := kl.Lookup(keylog.ClientRandom, tc.clientCtx.random)
 	if masterSecret == nil {
 		// TODO: info/warn?
-		return
+		return nil
 	}
 
 	td := tlsdecrypt.Decryptor{
@@ -509,10 +583,20 @@ func decodeTLSPostEncryptedRecords(rootD *decode.D, tc *tlsCtx, kl keylog.Map) {
 		}
 	}
 
-	if hasApplicationStream {
-		applicationBytes := applicationStream.Bytes()
-		rootD.FieldRootBitBuf("stream", bitio.NewBitReader(applicationBytes, -1))
+	if !hasApplicationStream {
+		return nil
+	}
+
+	// try decode application data as a TCP stream, ex: http2
+	br := bitio.NewBitReader(applicationStream.Bytes(), -1)
+	tsi := tc.tsi
+	tsi.HasStart = true
+	if dv, outV, _ := rootD.TryFieldFormatBitBuf("stream", br, tcpStreamFormat, tsi); dv != nil {
+		return outV
 	}
+	rootD.FieldRootBitBuf("stream", br)
+
+	return nil
 }
 
 func decodeTLSPostKeyExchange(tc *tlsCtx) {
@@ -674,12 +758,33 @@ func decodeV2ClientHello(d *decode.D, tc *tlsCtx) {
 	d.FieldRawLen("challenge", int64(challengeLength)*8)
 }
 
+// handshake messages without record layer
+func decodeTLSHandshakeMessages(d *decode.D) {
+	tc := &tlsCtx{
+		rootD:   d,
+		isTLS13: true,
+	}
+	d.FieldArray("messages", func(d *decode.D) {
+		for !d.End() {
+			d.FieldStruct("message", func(d *decode.D) {
+				decodeTLSHandshake(d, tc)
+			})
+		}
+	})
+}
+
 func decodeTLS(d *decode.D) any {
 	var ti format.TLSIn
 	d.ArgAs(&ti)
 
 	isClient := false
 
+	var thi format.TLSHandshakeIn
+	if d.ArgAs(&thi) {
+		decodeTLSHandshakeMessages(d)
+		return nil
+	}
+
 	var tsi format.TCPStreamIn
 	if d.ArgAs(&tsi) {
 		if !tsi.HasStart {
@@ -690,6 +795,7 @@ func decodeTLS(d *decode.D) any {
 
 	tc := &tlsCtx{
 		rootD: d,
+		tsi:   tsi,
 	}
 	tc.server.currentCipherSuit = ciphersuites.TLS_NULL_WITH_NULL_NULL
 	tc.server.nextCipherSuit = ciphersuites.TLS_NULL_WITH_NULL_NULL
@@ -745,8 +851,20 @@ func decodeTLS(d *decode.D) any {
 				d.Fatalf("failed to parse keylog: %s", err)
 			}
 
-			decodeTLSPostEncryptedRecords(clientTc.rootD, clientTc, km)
-			decodeTLSPostEncryptedRecords(serverTc.rootD, serverTc, km)
+			clientV := decodeTLSPostEncryptedRecords(clientTc.rootD, clientTc, km)
+			serverV := decodeTLSPostEncryptedRecords(serverTc.rootD, serverTc, km)
+
+			// same as for a TCP connection, let application streams post process each other
+			clientTo, clientToOk := clientV.(format.TCPStreamOut)
+			serverTo, serverToOk := serverV.(format.TCPStreamOut)
+			if clientToOk && serverToOk {
+				if clientTo.PostFn != nil {
+					clientTo.PostFn(serverTo.InArg)
+				}
+				if serverTo.PostFn != nil {
+					serverTo.PostFn(clientTo.InArg)
+				}
+			}
 		},
 		InArg: tc,
 	}
@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"compress/flate"
 	"embed"
+	"encoding/binary"
 	"io"
 
 	"github.com/wader/fq/format"
@@ -142,6 +143,52 @@ func fieldMSDOSDate(d *decode.D) {
 	d.FieldU5("day")
 }
 
+// local file header as found in central directory
+type localFile struct {
+	offset         uint64
+	compressedSize uint64
+}
+
+// localFileLen returns length in bits of local file at current position if it can be
+// decoded lazily, that is if the size can be known without decompressing
+func localFileLen(d *decode.D, lf localFile, uncompress bool) (int64, bool) {
+	const headerLen = 30
+	if d.BitsLeft() < headerLen*8 || !bytes.Equal(d.PeekBytes(4), localFileSignature) {
+		return 0, false
+	}
+	header := d.PeekBytes(headerLen)
+	hasDataDescriptor := header[6]&0b1000 != 0
+	localCompressedSize := binary.LittleEndian.Uint32(header[18:])
+	fileNameLength := binary.LittleEndian.Uint16(header[26:])
+	extraFieldLength := binary.LittleEndian.Uint16(header[28:])
+	compressionMethod := binary.LittleEndian.Uint16(header[8:])
+	// zip64 or sizes that don't agree, decoded size might differ
+	if localCompressedSize == 0xffffffff || (localCompressedSize != 0 && uint64(localCompressedSize) != lf.compressedSize) {
+		return 0, false
+	}
+	// size is in data descriptor, only known when decoding if decompressed
+	if localCompressedSize == 0 && lf.compressedSize != 0 && !(uncompress && compressionMethod == compressionMethodDeflated) {
+		return 0, false
+	}
+
+	n := int64(headerLen) + int64(fileNameLength) + int64(extraFieldLength) + int64(lf.compressedSize)
+	if hasDataDescriptor {
+		const dataIndicatorLen = 12
+		if d.BitsLeft() < (n+4+dataIndicatorLen)*8 {
+			return 0, false
+		}
+		n += dataIndicatorLen
+		if bytes.Equal(d.BytesRange(d.Pos()+(n-dataIndicatorLen)*8, 4), dataIndicatorSignature) {
+			n += 4
+		}
+	}
+	if n*8 > d.BitsLeft() {
+		return 0, false
+	}
+
+	return n * 8, true
+}
+
 func zipDecode(d *decode.D) any {
 	var zi format.ZipIn
 	d.ArgAs(&zi)
@@ -216,7 +263,7 @@ func zipDecode(d *decode.D) any {
 		})
 	}
 
-	var localFileOffsets []uint64
+	var localFiles []localFile
 
 	d.SeekAbs(int64(offsetCD) * 8)
 	d.FieldArray("central_directories", func(d *decode.D) {
@@ -247,7 +294,7 @@ func zipDecode(d *decode.D) any {
 					d.FieldStruct("last_modification_date", fieldMSDOSTime)
 					d.FieldStruct("last_modification_time", fieldMSDOSDate)
 					d.FieldU32("crc32_uncompressed", scalar.UintHex)
-					d.FieldU32("compressed_size")
+					compressedSize := d.FieldU32("compressed_size")
 					d.FieldU32("uncompressed_size")
 					fileNameLength := d.FieldU16("file_name_length")
 					extraFieldLength := d.FieldU16("extra_field_length")
@@ -269,7 +316,7 @@ func zipDecode(d *decode.D) any {
 											d.FieldU64("uncompressed_size")
 											// TODO: spec says these should be here but real zip64 seems to not have them? optional?
 											if !d.End() {
-												d.FieldU64("compressed_size")
+												compressedSize = d.FieldU64("compressed_size")
 											}
 											if !d.End() {
 												localFileOffset = d.FieldU64("relative_offset_of_local_file_header")
@@ -288,7 +335,10 @@ func zipDecode(d *decode.D) any {
 					d.FieldUTF8("file_comment", int(fileCommentLength))
 
 					if diskNrStart == diskNr {
-						localFileOffsets = append(localFileOffsets, localFileOffset)
+						localFiles = append(localFiles, localFile{
+							offset:         localFileOffset,
+							compressedSize: compressedSize,
+						})
 					}
 				})
 			}
@@ -296,113 +346,119 @@ func zipDecode(d *decode.D) any {
 	})
 
 	d.FieldArray("local_files", func(d *decode.D) {
-		for _, o := range localFileOffsets {
-			d.SeekAbs(int64(o) * 8)
-			d.FieldStruct("local_file", func(d *decode.D) {
-				var hasDataDescriptor bool
-				d.FieldRawLen("signature", 4*8, d.AssertBitBuf(localFileSignature))
-				d.FieldU16("version_needed")
-				d.FieldStruct("flags", func(d *decode.D) {
-					// TODO: 16LE, should have some kind of native endian flag reader helper?
-					d.FieldU1("unused0")
-					d.FieldBool("strong_encryption")
-					d.FieldBool("compressed_patched_data")
-					d.FieldBool("enhanced_deflation")
-					hasDataDescriptor = d.FieldBool("data_descriptor")
-					d.FieldBool("compression0")
-					d.FieldBool("compression1")
-					d.FieldBool("encrypted")
-
-					d.FieldU2("reserved0")
-					d.FieldBool("mask_header_values")
-					d.FieldBool("reserved1")
-					d.FieldBool("language_encoding")
-					d.FieldU3("unused1")
-				})
-				compressionMethod := d.FieldU16("compression_method", compressionMethodMap)
-				d.FieldStruct("last_modification_date", fieldMSDOSTime)
-				d.FieldStruct("last_modification_time", fieldMSDOSDate)
-				d.FieldU32("crc32_uncompressed", scalar.UintHex)
-				compressedSizeBytes := d.FieldU32("compressed_size")
-				d.FieldU32("uncompressed_size")
-				fileNameLength := d.FieldU16("file_name_length")
-				extraFieldLength := d.FieldU16("extra_field_length")
-				d.FieldUTF8("file_name", int(fileNameLength))
-				d.FieldArray("extra_fields", func(d *decode.D) {
-					d.FramedFn(int64(extraFieldLength)*8, func(d *decode.D) {
-						for !d.End() {
-							d.FieldStruct("extra_field", func(d *decode.D) {
-								headerID := d.FieldU16("header_id", headerIDMap, scalar.UintHex)
-								dataSize := d.FieldU16("data_size")
-								d.FramedFn(int64(dataSize)*8, func(d *decode.D) {
-									switch headerID {
-									case headerIDZip64ExtendedInformation:
-										d.FieldU64("uncompressed_size")
-										// TODO: spec says these should be here but real zip64 seems to not have them? optional?
-										if !d.End() {
-											compressedSizeBytes = d.FieldU64("compressed_size")
-										}
-									default:
-										d.FieldRawLen("data", int64(dataSize)*8)
-									}
-								})
-							})
-						}
-					})
-				})
-				compressedSize := int64(compressedSizeBytes) * 8
-				compressedStart := d.Pos()
+		for _, lf := range localFiles {
+			d.SeekAbs(int64(lf.offset) * 8)
+			if nBits, ok := localFileLen(d, lf, zi.Uncompress); ok {
+				d.FieldStructLazyFn("local_file", nBits, func(d *decode.D) { decodeLocalFile(d, zi) })
+			} else {
+				d.FieldStruct("local_file", func(d *decode.D) { decodeLocalFile(d, zi) })
+			}
+		}
+	})
 
-				compressedLimit := compressedSize
-				if compressedLimit == 0 {
-					compressedLimit = d.BitsLeft()
-				}
+	return nil
+}
 
-				if compressionMethod == compressionMethodNone {
-					d.FieldFormatOrRawLen("uncompressed", compressedSize, probeFormat, nil)
-				} else {
-					var rFn func(r io.Reader) io.Reader
-					if zi.Uncompress {
-						switch compressionMethod {
-						case compressionMethodDeflated:
-							// bitio.NewIOReadSeeker implements io.ByteReader so that deflate don't do own
-							// buffering and might read more than needed messing up knowing compressed size
-							rFn = func(r io.Reader) io.Reader { return flate.NewReader(r) }
+func decodeLocalFile(d *decode.D, zi format.ZipIn) {
+	var hasDataDescriptor bool
+	d.FieldRawLen("signature", 4*8, d.AssertBitBuf(localFileSignature))
+	d.FieldU16("version_needed")
+	d.FieldStruct("flags", func(d *decode.D) {
+		// TODO: 16LE, should have some kind of native endian flag reader helper?
+		d.FieldU1("unused0")
+		d.FieldBool("strong_encryption")
+		d.FieldBool("compressed_patched_data")
+		d.FieldBool("enhanced_deflation")
+		hasDataDescriptor = d.FieldBool("data_descriptor")
+		d.FieldBool("compression0")
+		d.FieldBool("compression1")
+		d.FieldBool("encrypted")
+
+		d.FieldU2("reserved0")
+		d.FieldBool("mask_header_values")
+		d.FieldBool("reserved1")
+		d.FieldBool("language_encoding")
+		d.FieldU3("unused1")
+	})
+	compressionMethod := d.FieldU16("compression_method", compressionMethodMap)
+	d.FieldStruct("last_modification_date", fieldMSDOSTime)
+	d.FieldStruct("last_modification_time", fieldMSDOSDate)
+	d.FieldU32("crc32_uncompressed", scalar.UintHex)
+	compressedSizeBytes := d.FieldU32("compressed_size")
+	d.FieldU32("uncompressed_size")
+	fileNameLength := d.FieldU16("file_name_length")
+	extraFieldLength := d.FieldU16("extra_field_length")
+	d.FieldUTF8("file_name", int(fileNameLength))
+	d.FieldArray("extra_fields", func(d *decode.D) {
+		d.FramedFn(int64(extraFieldLength)*8, func(d *decode.D) {
+			for !d.End() {
+				d.FieldStruct("extra_field", func(d *decode.D) {
+					headerID := d.FieldU16("header_id", headerIDMap, scalar.UintHex)
+					dataSize := d.FieldU16("data_size")
+					d.FramedFn(int64(dataSize)*8, func(d *decode.D) {
+						switch headerID {
+						case headerIDZip64ExtendedInformation:
+							d.FieldU64("uncompressed_size")
+							// TODO: spec says these should be here but real zip64 seems to not have them? optional?
+							if !d.End() {
+								compressedSizeBytes = d.FieldU64("compressed_size")
+							}
+						default:
+							d.FieldRawLen("data", int64(dataSize)*8)
 						}
-					}
+					})
+				})
+			}
+		})
+	})
+	compressedSize := int64(compressedSizeBytes) * 8
+	compressedStart := d.Pos()
 
-					if rFn != nil {
-						readCompressedSize, uncompressedBR, dv, _, _ := d.TryFieldReaderRangeFormat("uncompressed", d.Pos(), compressedLimit, rFn, probeFormat, nil)
-						if dv == nil && uncompressedBR != nil {
-							d.FieldRootBitBuf("uncompressed", uncompressedBR)
-						}
-						if compressedSize == 0 {
-							compressedSize = readCompressedSize
-						}
-						d.FieldRawLen("compressed", compressedSize)
+	compressedLimit := compressedSize
+	if compressedLimit == 0 {
+		compressedLimit = d.BitsLeft()
+	}
 
-					} else {
-						if compressedSize != 0 {
-							d.FieldRawLen("compressed", compressedSize)
-						}
-					}
-				}
+	if compressionMethod == compressionMethodNone {
+		d.FieldFormatOrRawLen("uncompressed", compressedSize, probeFormat, nil)
+	} else {
+		var rFn func(r io.Reader) io.Reader
+		if zi.Uncompress {
+			switch compressionMethod {
+			case compressionMethodDeflated:
+				// bitio.NewIOReadSeeker implements io.ByteReader so that deflate don't do own
+				// buffering and might read more than needed messing up knowing compressed size
+				rFn = func(r io.Reader) io.Reader { return flate.NewReader(r) }
+			}
+		}
 
-				d.SeekAbs(compressedStart + compressedSize)
+		if rFn != nil {
+			readCompressedSize, uncompressedBR, dv, _, _ := d.TryFieldReaderRangeFormat("uncompressed", d.Pos(), compressedLimit, rFn, probeFormat, nil)
+			if dv == nil && uncompressedBR != nil {
+				d.FieldRootBitBuf("uncompressed", uncompressedBR)
+			}
+			if compressedSize == 0 {
+				compressedSize = readCompressedSize
+			}
+			d.FieldRawLen("compressed", compressedSize)
 
-				if hasDataDescriptor {
-					d.FieldStruct("data_indicator", func(d *decode.D) {
-						if bytes.Equal(d.PeekBytes(4), dataIndicatorSignature) {
-							d.FieldRawLen("signature", 4*8, d.AssertBitBuf(dataIndicatorSignature))
-						}
-						d.FieldU32("crc32_uncompressed", scalar.UintHex)
-						d.FieldU32("compressed_size")
-						d.FieldU32("uncompressed_size")
-					})
-				}
-			})
+		} else {
+			if compressedSize != 0 {
+				d.FieldRawLen("compressed", compressedSize)
+			}
 		}
-	})
+	}
 
-	return nil
+	d.SeekAbs(compressedStart + compressedSize)
+
+	if hasDataDescriptor {
+		d.FieldStruct("data_indicator", func(d *decode.D) {
+			if bytes.Equal(d.PeekBytes(4), dataIndicatorSignature) {
+				d.FieldRawLen("signature", 4*8, d.AssertBitBuf(dataIndicatorSignature))
+			}
+			d.FieldU32("crc32_uncompressed", scalar.UintHex)
+			d.FieldU32("compressed_size")
+			d.FieldU32("uncompressed_size")
+		})
+	}
 }
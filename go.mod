@@ -49,6 +49,11 @@ require (
 	// bump: gomod-go-difflib link "Source diff $CURRENT..$LATEST" https://github.com/pmezard/go-difflib/compare/v$CURRENT..v$LATEST
 	github.com/pmezard/go-difflib v1.0.0
 
+	// bump: gomod-golang-x-arch /golang\.org\/x\/arch v(.*)/ https://github.com/golang/arch.git|^0
+	// bump: gomod-golang-x-arch command go get -d golang.org/x/arch@v$LATEST && go mod tidy
+	// bump: gomod-golang-x-arch link "Tags" https://github.com/golang/arch/tags
+	golang.org/x/arch v0.14.0
+
 	// bump: gomod-golang-x-crypto /golang\.org\/x\/crypto v(.*)/ https://github.com/golang/crypto.git|^0
 	// bump: gomod-golang-x-crypto command go get -d golang.org/x/crypto@v$LATEST && go mod tidy
 	// bump: gomod-golang-x-crypto link "Tags" https://github.com/golang/crypto/tags
@@ -9,6 +9,8 @@ import (
 	"github.com/wader/fq/pkg/bitio"
 )
 
+var ErrOutsideBuffer = errors.New("outside buffer")
+
 func CopyBitsBuffer(dst io.Writer, src bitio.Reader, buf []byte) (int64, error) {
 	return io.CopyBuffer(dst, bitio.NewIOReader(src), buf)
 }
@@ -42,7 +44,7 @@ func Range(br bitio.ReadAtSeeker, firstBitOffset int64, nBits int64) (bitio.Read
 		return nil, errors.New("negative nBits")
 	}
 	if firstBitOffset+nBits > l {
-		return nil, errors.New("outside buffer")
+		return nil, ErrOutsideBuffer
 	}
 	return bitio.NewSectionReader(br, firstBitOffset, nBits), nil
 }
@@ -86,6 +86,12 @@ func ToGoJQValue(v any) (any, bool) {
 			vvs[k] = v
 		}
 		return vvs, true
+	case map[string]string:
+		vvs := make(map[string]any, len(vv))
+		for k, v := range vv {
+			vvs[k] = v
+		}
+		return vvs, true
 	default:
 		return nil, false
 	}
@@ -5,7 +5,9 @@ package mapstruct
 // TODO: implement own version as we don't need much?
 
 import (
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/creasty/defaults"
@@ -21,14 +23,31 @@ func CamelToSnake(s string) string {
 	}))
 }
 
+// strings to integers, JSON object keys are always strings but can be used for
+// maps with integer keys, ex: {"0x1000": "main"}
+func stringToIntHook(from reflect.Type, to reflect.Type, data any) (any, error) {
+	s, ok := data.(string)
+	if !ok || from.Kind() != reflect.String {
+		return data, nil
+	}
+	switch to.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(s, 0, to.Bits())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(s, 0, to.Bits())
+	}
+	return data, nil
+}
+
 func ToStruct(m any, v any) error {
 	_ = defaults.Set(v)
 	ms, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
 		MatchName: func(mapKey, fieldName string) bool {
 			return CamelToSnake(fieldName) == mapKey
 		},
-		TagName: "mapstruct",
-		Result:  v,
+		DecodeHook: stringToIntHook,
+		TagName:    "mapstruct",
+		Result:     v,
 	})
 	if err != nil {
 		return err
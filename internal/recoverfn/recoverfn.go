@@ -83,6 +83,10 @@ func (r Raw) frames(startSkip int, bottomSkip int, bottomPC uintptr) []runtime.F
 }
 
 func (r Raw) Frames() []runtime.Frame {
+	// no stacktrace, ex: error not from a recovered panic
+	if len(r.PCs) == 0 {
+		return nil
+	}
 	// 3 to skip runtime.Callers, Recover help function and runtime.gopanic
 	// 1 to skip Recover defer recover() function
 	return r.frames(3, 1, r.RecoverPC)
@@ -38,6 +38,8 @@ type Options struct {
 	InArg         any
 	FormatInArgFn func(init any) any
 	ReadBuf       *[]byte
+	Lazy          bool       // decode some compound values first time they are used, see FieldStructLazyFn
+	LazyCache     *LazyCache // if not nil evict decoded lazy values
 }
 
 // Decode try decode group and return first success and all other decoder errors
@@ -66,8 +68,14 @@ func decode(ctx context.Context, br bitio.ReaderAtSeeker, group Group, opts Opti
 		var inArgs []any
 
 		// figure out if there are format specific arg passed as options
+		// if in arg from parent format is of same type as default in arg format
+		// options are applied on top of it instead, ex: grpc method path from http2
+		// is kept when using a schema option
 		hasFormatOpts := false
 		formatArg := f.DefaultInArg
+		if opts.InArg != nil && formatArg != nil && reflect.TypeOf(opts.InArg) == reflect.TypeOf(formatArg) {
+			formatArg = opts.InArg
+		}
 		if formatArg != nil && opts.FormatInArgFn != nil {
 			formatOptArg := opts.FormatInArgFn(formatArg)
 			if formatOptArg != nil {
@@ -132,27 +140,10 @@ func decode(ctx context.Context, br bitio.ReaderAtSeeker, group Group, opts Opti
 			}
 		}
 
-		// TODO: maybe move to Format* funcs?
-		if opts.FillGaps {
-			d.FillGaps(ranges.Range{Start: 0, Len: decodeRange.Len}, "gap")
-		}
-
-		var minMaxRange ranges.Range
-		if err := d.Value.WalkRootPreOrder(func(v *Value, _ *Value, _ int, _ int) error {
-			minMaxRange = ranges.MinMax(minMaxRange, v.Range)
-			v.Range.Start += decodeRange.Start
-			v.RootReader = br
-			return nil
-		}); err != nil {
+		if err := d.done(br, decodeRange); err != nil {
 			return nil, nil, err
 		}
 
-		d.Value.Range = ranges.Range{Start: decodeRange.Start, Len: minMaxRange.Len}
-
-		if opts.IsRoot {
-			d.Value.postProcess()
-		}
-
 		if len(formatsErr.Errs) > 0 {
 			return d.Value, decodeV, formatsErr
 		}
@@ -163,6 +154,33 @@ func decode(ctx context.Context, br bitio.ReaderAtSeeker, group Group, opts Opti
 	return nil, nil, formatsErr
 }
 
+// done fills gaps, makes ranges relative to br and post process if root
+func (d *D) done(br bitio.ReaderAtSeeker, decodeRange ranges.Range) error {
+	// TODO: maybe move to Format* funcs?
+	if d.Options.FillGaps {
+		d.FillGaps(ranges.Range{Start: 0, Len: decodeRange.Len}, "gap")
+	}
+
+	var minMaxRange ranges.Range
+	if err := d.Value.walkNoLazy(true, func(v *Value, _ *Value, _ int, _ int) error {
+		minMaxRange = ranges.MinMax(minMaxRange, v.Range)
+		v.translate(decodeRange.Start, br)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	d.Value.Range = ranges.Range{Start: decodeRange.Start, Len: minMaxRange.Len}
+	d.doneDelta = decodeRange.Start
+	d.doneRootReader = br
+
+	if d.Options.IsRoot {
+		d.Value.postProcess()
+	}
+
+	return nil
+}
+
 type D struct {
 	Ctx     context.Context
 	Endian  Endian
@@ -172,8 +190,14 @@ type D struct {
 	bitBuf bitio.ReaderAtSeeker
 
 	readBuf *[]byte
+	// endian used by last read, used by encoders
+	readEndian Endian
 
 	inArgs []any
+
+	// used by PostDecodeFn to translate values added after done
+	doneDelta      int64
+	doneRootReader bitio.ReaderAtSeeker
 }
 
 // TODO: new struct decoder?
@@ -199,6 +223,7 @@ func newDecoder(ctx context.Context, format Format, br bitio.ReaderAtSeeker, opt
 			Range:      ranges.Range{Start: 0, Len: 0},
 			IsRoot:     opts.IsRoot,
 			Format:     &format,
+			Options:    &opts,
 		},
 		Options: opts,
 
@@ -314,9 +339,8 @@ func (d *D) SharedReadBuf(n int) []byte {
 func (d *D) FillGaps(r ranges.Range, namePrefix string) {
 	makeWalkFn := func(fn func(iv *Value)) func(iv *Value, rootV *Value, depth int, rootDepth int) error {
 		return func(iv *Value, _ *Value, _ int, _ int) error {
-			switch iv.V.(type) {
-			case *Compound:
-			default:
+			// lazy values not decoded yet covers their range
+			if _, ok := iv.V.(*Compound); !ok || iv.IsLazy() {
 				fn(iv)
 			}
 			return nil
@@ -326,10 +350,10 @@ func (d *D) FillGaps(r ranges.Range, namePrefix string) {
 	// TODO: redo this, tries to get rid of slice grow
 	// TODO: pre-sorted somehow?
 	n := 0
-	_ = d.Value.WalkRootPreOrder(makeWalkFn(func(_ *Value) { n++ }))
+	_ = d.Value.walkNoLazy(true, makeWalkFn(func(_ *Value) { n++ }))
 	valueRanges := make([]ranges.Range, n)
 	i := 0
-	_ = d.Value.WalkRootPreOrder(makeWalkFn(func(iv *Value) {
+	_ = d.Value.walkNoLazy(true, makeWalkFn(func(iv *Value) {
 		valueRanges[i] = iv.Range
 		i++
 	}))
@@ -356,6 +380,40 @@ func (d *D) FillGaps(r ranges.Range, namePrefix string) {
 	}
 }
 
+// PostDecodeFn decodes more fields into a value that is already done using fn,
+// ex: in a TCP stream PostFn that depends on state from the peer stream.
+// Gaps are filled again and new fields are made relative to the root reader.
+func (d *D) PostDecodeFn(fn func(d *D)) {
+	c, ok := d.Value.V.(*Compound)
+	if !ok {
+		d.Fatalf("PostDecodeFn: not a compound")
+	}
+	for _, v := range append([]*Value{}, c.Children...) {
+		if bb, ok := v.V.(*scalar.BitBuf); ok && bb.Gap {
+			if err := v.Remove(); err != nil {
+				panic(err)
+			}
+		}
+	}
+	n := len(c.Children)
+
+	fn(d)
+	if d.Options.FillGaps {
+		d.FillGaps(ranges.Range{Start: 0, Len: d.Len()}, "gap")
+	}
+
+	for _, cv := range c.Children[n:] {
+		_ = cv.walkNoLazy(true, func(v *Value, _ *Value, _ int, _ int) error {
+			v.translate(d.doneDelta, d.doneRootReader)
+			return nil
+		})
+	}
+
+	vRange := d.Value.Range
+	d.Value.postProcess()
+	d.Value.Range = vRange
+}
+
 // Errorf stops decode with a reason unless forced
 func (d *D) Errorf(format string, a ...any) {
 	if !d.Options.Force {
@@ -960,6 +1018,8 @@ func (d *D) Format(group Group, inArg any) any {
 		InArg:         inArg,
 		FormatInArgFn: d.Options.FormatInArgFn,
 		ReadBuf:       d.readBuf,
+		Lazy:          d.Options.Lazy,
+		LazyCache:     d.Options.LazyCache,
 	})
 	if dv == nil || dv.Errors() != nil {
 		d.IOPanic(err, "Format: decode")
@@ -991,6 +1051,8 @@ func (d *D) TryFieldFormat(name string, group Group, inArg any) (*Value, any, er
 		InArg:         inArg,
 		FormatInArgFn: d.Options.FormatInArgFn,
 		ReadBuf:       d.readBuf,
+		Lazy:          d.Options.Lazy,
+		LazyCache:     d.Options.LazyCache,
 	})
 	if dv == nil || dv.Errors() != nil {
 		return nil, nil, err
@@ -1030,6 +1092,8 @@ func (d *D) TryFieldFormatLen(name string, nBits int64, group Group, inArg any)
 		InArg:         inArg,
 		FormatInArgFn: d.Options.FormatInArgFn,
 		ReadBuf:       d.readBuf,
+		Lazy:          d.Options.Lazy,
+		LazyCache:     d.Options.LazyCache,
 	})
 	if dv == nil || dv.Errors() != nil {
 		return nil, nil, err
@@ -1070,6 +1134,8 @@ func (d *D) TryFieldFormatRange(name string, firstBit int64, nBits int64, group
 		InArg:         inArg,
 		FormatInArgFn: d.Options.FormatInArgFn,
 		ReadBuf:       d.readBuf,
+		Lazy:          d.Options.Lazy,
+		LazyCache:     d.Options.LazyCache,
 	})
 	if dv == nil || dv.Errors() != nil {
 		return nil, nil, err
@@ -1098,6 +1164,8 @@ func (d *D) TryFieldFormatBitBuf(name string, br bitio.ReaderAtSeeker, group Gro
 		InArg:         inArg,
 		FormatInArgFn: d.Options.FormatInArgFn,
 		ReadBuf:       d.readBuf,
+		Lazy:          d.Options.Lazy,
+		LazyCache:     d.Options.LazyCache,
 	})
 	if dv == nil || dv.Errors() != nil {
 		return nil, nil, err
@@ -1151,6 +1219,7 @@ func (d *D) FieldArrayRootBitBufFn(name string, br bitio.ReaderAtSeeker, fn func
 	fn(cd)
 
 	cd.Value.postProcess()
+	cd.Value.Range.Start = d.Pos()
 
 	return cd.Value
 }
@@ -1163,6 +1232,7 @@ func (d *D) FieldStructRootBitBufFn(name string, br bitio.ReaderAtSeeker, fn fun
 	fn(cd)
 
 	cd.Value.postProcess()
+	cd.Value.Range.Start = d.Pos()
 
 	return cd.Value
 }
@@ -1221,16 +1291,28 @@ func (d *D) FieldReaderRangeFormat(name string, startBit int64, nBits int64, fn
 	return cz, rBR, dv, v
 }
 
-func (d *D) TryFieldValue(name string, fn func() (*Value, error)) (*Value, error) {
+// TryValue reads a value using fn without adding it as a field, can be added later using AddChild
+func (d *D) TryValue(fn func() (*Value, error)) (*Value, error) {
 	start := d.Pos()
+	d.readEndian = d.Endian
 	v, err := fn()
 	stop := d.Pos()
-	v.Name = name
+	if err != nil {
+		return nil, err
+	}
 	v.RootReader = d.bitBuf
 	v.Range = ranges.Range{Start: start, Len: stop - start}
+	v.Endian = d.readEndian
+
+	return v, nil
+}
+
+func (d *D) TryFieldValue(name string, fn func() (*Value, error)) (*Value, error) {
+	v, err := d.TryValue(fn)
 	if err != nil {
 		return nil, err
 	}
+	v.Name = name
 	d.AddChild(v)
 
 	return v, err
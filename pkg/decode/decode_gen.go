@@ -69,7 +69,7 @@ func (d *D) TryFieldScalarAnyFn(name string, fn func(d *D) (scalar.Any, error),
 				return &Value{V: &s}, err
 			}
 		}
-		return &Value{V: &s}, nil
+		return &Value{V: &s, Expected: mappersExpected(sms)}, nil
 	})
 	if err != nil {
 		return &scalar.Any{}, err
@@ -140,7 +140,7 @@ func (d *D) TryFieldScalarBigIntFn(name string, fn func(d *D) (scalar.BigInt, er
 				return &Value{V: &s}, err
 			}
 		}
-		return &Value{V: &s}, nil
+		return &Value{V: &s, Expected: mappersExpected(sms)}, nil
 	})
 	if err != nil {
 		return &scalar.BigInt{}, err
@@ -211,7 +211,7 @@ func (d *D) TryFieldScalarBitBufFn(name string, fn func(d *D) (scalar.BitBuf, er
 				return &Value{V: &s}, err
 			}
 		}
-		return &Value{V: &s}, nil
+		return &Value{V: &s, Expected: mappersExpected(sms)}, nil
 	})
 	if err != nil {
 		return &scalar.BitBuf{}, err
@@ -282,7 +282,7 @@ func (d *D) TryFieldScalarBoolFn(name string, fn func(d *D) (scalar.Bool, error)
 				return &Value{V: &s}, err
 			}
 		}
-		return &Value{V: &s}, nil
+		return &Value{V: &s, Expected: mappersExpected(sms)}, nil
 	})
 	if err != nil {
 		return &scalar.Bool{}, err
@@ -353,7 +353,7 @@ func (d *D) TryFieldScalarFltFn(name string, fn func(d *D) (scalar.Flt, error),
 				return &Value{V: &s}, err
 			}
 		}
-		return &Value{V: &s}, nil
+		return &Value{V: &s, Expected: mappersExpected(sms)}, nil
 	})
 	if err != nil {
 		return &scalar.Flt{}, err
@@ -424,7 +424,7 @@ func (d *D) TryFieldScalarSintFn(name string, fn func(d *D) (scalar.Sint, error)
 				return &Value{V: &s}, err
 			}
 		}
-		return &Value{V: &s}, nil
+		return &Value{V: &s, Expected: mappersExpected(sms)}, nil
 	})
 	if err != nil {
 		return &scalar.Sint{}, err
@@ -495,7 +495,7 @@ func (d *D) TryFieldScalarStrFn(name string, fn func(d *D) (scalar.Str, error),
 				return &Value{V: &s}, err
 			}
 		}
-		return &Value{V: &s}, nil
+		return &Value{V: &s, Expected: mappersExpected(sms)}, nil
 	})
 	if err != nil {
 		return &scalar.Str{}, err
@@ -566,7 +566,7 @@ func (d *D) TryFieldScalarUintFn(name string, fn func(d *D) (scalar.Uint, error)
 				return &Value{V: &s}, err
 			}
 		}
-		return &Value{V: &s}, nil
+		return &Value{V: &s, Expected: mappersExpected(sms)}, nil
 	})
 	if err != nil {
 		return &scalar.Uint{}, err
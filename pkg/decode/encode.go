@@ -0,0 +1,247 @@
+package decode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"unicode/utf8"
+
+	"github.com/wader/fq/internal/bitioex"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+// TODO: varint, non-UTF8 strings, values mapped by actual functions etc
+
+var ErrEncodeUnknown = errors.New("unknown encoding")
+
+// Encode encodes a as a replacement for the actual value of v. Only fixed width
+// values can be encoded and it's verified that the current actual value can be
+// encoded to the current bits in range of v, to catch values that has been
+// transformed in some way.
+func (v *Value) Encode(a any) (bitio.ReaderAtSeeker, error) {
+	nBits := v.Range.Len
+	br, err := bitioex.Range(v.RootReader, v.Range.Start, nBits)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, bitio.BitsByteCount(nBits))
+	if _, err := bitio.ReadAtFull(br, buf, nBits, 0); err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	switch s := v.V.(type) {
+	case *scalar.Uint:
+		n, err := encodeUint(a)
+		if err != nil {
+			return nil, err
+		}
+		out, err = encodeInt(buf, nBits, v.Endian, s.Actual, n, false)
+		if err != nil {
+			return nil, err
+		}
+	case *scalar.Sint:
+		n, err := encodeSint(a)
+		if err != nil {
+			return nil, err
+		}
+		out, err = encodeInt(buf, nBits, v.Endian, uint64(s.Actual), uint64(n), true)
+		if err != nil {
+			return nil, err
+		}
+	case *scalar.Bool:
+		b, ok := a.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected a boolean")
+		}
+		if nBits != 1 {
+			return nil, ErrEncodeUnknown
+		}
+		out = []byte{0}
+		if b {
+			out[0] = 0x80
+		}
+	case *scalar.Flt:
+		f, err := encodeFlt(a)
+		if err != nil {
+			return nil, err
+		}
+		out, err = encodeFloat(buf, nBits, v.Endian, s.Actual, f)
+		if err != nil {
+			return nil, err
+		}
+	case *scalar.Str:
+		str, ok := a.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a string")
+		}
+		out, err = encodeStr(buf, nBits, s.Actual, str)
+		if err != nil {
+			return nil, err
+		}
+	case *scalar.BitBuf:
+		b, ok := a.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("expected a binary")
+		}
+		if int64(len(b))*8 < nBits || int64(len(b))*8-nBits >= 8 {
+			return nil, fmt.Errorf("binary length must be %d bits, got %d bytes", nBits, len(b))
+		}
+		out = b
+	case *Compound:
+		return nil, fmt.Errorf("can't encode a compound value")
+	default:
+		return nil, ErrEncodeUnknown
+	}
+
+	return bitio.NewBitReader(out, nBits), nil
+}
+
+func encodeUint(a any) (uint64, error) {
+	switch a := a.(type) {
+	case uint64:
+		return a, nil
+	case int64:
+		if a < 0 {
+			return 0, fmt.Errorf("%d is negative", a)
+		}
+		return uint64(a), nil
+	case float64:
+		if a != math.Trunc(a) {
+			return 0, fmt.Errorf("%v is not an integer", a)
+		}
+		if a < 0 {
+			return 0, fmt.Errorf("%v is negative", a)
+		}
+		// float64(math.MaxUint64) rounds up to 1<<64
+		if a >= math.MaxUint64 {
+			return 0, fmt.Errorf("%v does not fit in 64 bit", a)
+		}
+		return uint64(a), nil
+	case *big.Int:
+		if a.Sign() < 0 {
+			return 0, fmt.Errorf("%s is negative", a)
+		}
+		if !a.IsUint64() {
+			return 0, fmt.Errorf("%s does not fit in 64 bit", a)
+		}
+		return a.Uint64(), nil
+	default:
+		return 0, fmt.Errorf("expected a number")
+	}
+}
+
+func encodeSint(a any) (int64, error) {
+	switch a := a.(type) {
+	case uint64:
+		if a > math.MaxInt64 {
+			return 0, fmt.Errorf("%d does not fit in 64 bit", a)
+		}
+		return int64(a), nil
+	case int64:
+		return a, nil
+	case float64:
+		if a != math.Trunc(a) {
+			return 0, fmt.Errorf("%v is not an integer", a)
+		}
+		// float64(math.MaxInt64) rounds up to 1<<63
+		if a < math.MinInt64 || a >= math.MaxInt64 {
+			return 0, fmt.Errorf("%v does not fit in 64 bit", a)
+		}
+		return int64(a), nil
+	case *big.Int:
+		if !a.IsInt64() {
+			return 0, fmt.Errorf("%s does not fit in 64 bit", a)
+		}
+		return a.Int64(), nil
+	default:
+		return 0, fmt.Errorf("expected a number")
+	}
+}
+
+func encodeFlt(a any) (float64, error) {
+	switch a := a.(type) {
+	case uint64:
+		return float64(a), nil
+	case int64:
+		return float64(a), nil
+	case float64:
+		return a, nil
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(a).Float64()
+		return f, nil
+	default:
+		return 0, fmt.Errorf("expected a number")
+	}
+}
+
+// verify that actual is the current bits using endian
+func encodeVerify(buf []byte, nBits int64, endian Endian, actual uint64, signed bool) error {
+	if nBits > 64 {
+		return ErrEncodeUnknown
+	}
+	if signed && nBits < 64 {
+		actual &= 1<<nBits - 1
+	}
+	n := bitio.Read64(buf, 0, nBits)
+	if endian == LittleEndian {
+		n = bitio.ReverseBytes64(int(nBits), n)
+	}
+	if n != actual {
+		return ErrEncodeUnknown
+	}
+	return nil
+}
+
+func encodeInt(buf []byte, nBits int64, endian Endian, actual uint64, n uint64, signed bool) ([]byte, error) {
+	if err := encodeVerify(buf, nBits, endian, actual, signed); err != nil {
+		return nil, err
+	}
+	if nBits < 64 {
+		if signed {
+			min := -(int64(1) << (nBits - 1))
+			max := int64(1)<<(nBits-1) - 1
+			if int64(n) < min || int64(n) > max {
+				return nil, fmt.Errorf("%d does not fit in %d bit signed", int64(n), nBits)
+			}
+			n &= 1<<nBits - 1
+		} else if n >= 1<<nBits {
+			return nil, fmt.Errorf("%d does not fit in %d bit unsigned", n, nBits)
+		}
+	}
+	if endian == LittleEndian {
+		n = bitio.ReverseBytes64(int(nBits), n)
+	}
+	out := make([]byte, len(buf))
+	bitio.Write64(n, nBits, out, 0)
+	return out, nil
+}
+
+func encodeFloat(buf []byte, nBits int64, endian Endian, actual float64, f float64) ([]byte, error) {
+	var toBits func(f float64) uint64
+	switch nBits {
+	case 32:
+		toBits = func(f float64) uint64 { return uint64(math.Float32bits(float32(f))) }
+	case 64:
+		toBits = math.Float64bits
+	default:
+		return nil, ErrEncodeUnknown
+	}
+	return encodeInt(buf, nBits, endian, toBits(actual), toBits(f), false)
+}
+
+// only UTF-8 (or ASCII) strings possibly zero padded for now
+func encodeStr(buf []byte, nBits int64, actual string, str string) ([]byte, error) {
+	if nBits%8 != 0 || !utf8.Valid(buf) || string(bytes.TrimRight(buf, "\x00")) != actual {
+		return nil, ErrEncodeUnknown
+	}
+	if int64(len(str))*8 > nBits {
+		return nil, fmt.Errorf("string %q is longer than %d bytes", str, nBits/8)
+	}
+	out := make([]byte, len(buf))
+	copy(out, str)
+	return out, nil
+}
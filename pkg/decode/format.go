@@ -19,6 +19,10 @@ type Format struct {
 	Dependencies       []Dependency
 	Functions          []string
 	SkipDecodeFunction bool
+	// StreamFn if set format can be decoded as a stream of records, see StreamDecoder.
+	// Should decode one record and return state to be passed to next call, state is
+	// nil for first record.
+	StreamFn func(d *D, state any) any
 }
 
 func FormatFn(d func(d *D) any) Group {
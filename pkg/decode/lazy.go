@@ -0,0 +1,221 @@
+package decode
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/wader/fq/internal/recoverfn"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/ranges"
+)
+
+// lazy is the state needed to decode a compound value on first use
+type lazy struct {
+	d     D // decoder state when the value was added, Value is replaced when decoding
+	r     ranges.Range
+	fn    func(d *D)
+	delta int64 // translates ranges relative to d to ranges relative to rootReader
+
+	rootReader bitio.ReaderAtSeeker
+	decoded    bool
+}
+
+// LazyCache keeps track of decoded lazy values and evicts the least recently
+// decoded value when more than Max values are decoded. Evicted values are
+// decoded again when used.
+type LazyCache struct {
+	Max int // max decoded values, evicting a value also evicts decoded lazy values nested in it
+
+	vs []*Value
+}
+
+func (lc *LazyCache) add(v *Value) {
+	lc.vs = append(lc.vs, v)
+	if lc.Max <= 0 || len(lc.vs) <= lc.Max {
+		return
+	}
+	evict := lc.vs[0]
+	lc.vs[0] = nil
+	lc.vs = lc.vs[1:]
+	lc.evict(evict)
+}
+
+// evict value and nested decoded lazy values so that no nested value is kept
+// in the cache after its parent has been evicted
+func (lc *LazyCache) evict(v *Value) {
+	nested := map[*Value]bool{}
+	_ = v.Walk(WalkOpts{PreOrder: true, skipLazy: true, Fn: func(wv *Value, _ *Value, _ int, _ int) error {
+		if wv != v && wv.isDecodedLazy() {
+			nested[wv] = true
+		}
+		return nil
+	}})
+	if len(nested) > 0 {
+		vs := lc.vs[:0]
+		for _, cv := range lc.vs {
+			if !nested[cv] {
+				vs = append(vs, cv)
+			}
+		}
+		for i := len(vs); i < len(lc.vs); i++ {
+			lc.vs[i] = nil
+		}
+		lc.vs = vs
+		for nv := range nested {
+			nv.EvictLazy()
+		}
+	}
+	v.EvictLazy()
+}
+
+// IsLazy is true if value is not decoded yet
+func (v *Value) IsLazy() bool {
+	c, ok := v.V.(*Compound)
+	return ok && c.lazy != nil && !c.lazy.decoded
+}
+
+func (v *Value) isDecodedLazy() bool {
+	c, ok := v.V.(*Compound)
+	return ok && c.lazy != nil && c.lazy.decoded
+}
+
+// DecodeLazy decodes value if it's lazy and not decoded yet. Decode errors are
+// set as Err on the value, same as for a failed format decode.
+func (v *Value) DecodeLazy() {
+	c, ok := v.V.(*Compound)
+	if !ok || c.lazy == nil || c.lazy.decoded {
+		return
+	}
+	l := c.lazy
+	l.decoded = true
+
+	nd := l.d
+	nd.Value = v
+	// value might be decoded after the decode context is done, ex: in a later REPL
+	// evaluation, so don't fail because of that
+	if nd.Ctx != nil && nd.Ctx.Err() != nil {
+		nd.Ctx = context.Background()
+	}
+
+	r, rOk := recoverfn.Run(func() {
+		nd.SeekAbs(l.r.Start)
+		l.fn(&nd)
+		// zero length values, ex: arrays of values with own root, has no gaps
+		if nd.Options.FillGaps && l.r.Len > 0 {
+			nd.FillGaps(l.r, "gap")
+		}
+	})
+	if !rOk {
+		panicErr, ok := r.RecoverV.(error)
+		if !ok {
+			panicErr = fmt.Errorf("recoverable non-panic error :%v", r.RecoverV)
+		}
+		formatErr := FormatError{Err: panicErr, Stacktrace: r}
+		if fv := v.FormatRoot(); fv.Format != nil {
+			formatErr.Format = *fv.Format
+		}
+		v.Err = formatErr
+	}
+
+	_ = v.walkNoLazy(true, func(wv *Value, _ *Value, _ int, _ int) error {
+		if wv == v {
+			return nil
+		}
+		wv.translate(l.delta, l.rootReader)
+		return nil
+	})
+
+	// keep range and index from before decode to be consistent with what has been seen
+	vRange, vIndex := v.Range, v.Index
+	v.postProcess()
+	v.Range, v.Index = vRange, vIndex
+
+	if l.d.Options.LazyCache != nil {
+		l.d.Options.LazyCache.add(v)
+	}
+}
+
+// EvictLazy removes decoded children of a lazy value, will be decoded again when used
+func (v *Value) EvictLazy() {
+	c, ok := v.V.(*Compound)
+	if !ok || c.lazy == nil || !c.lazy.decoded {
+		return
+	}
+	c.Children = nil
+	c.ByName = nil
+	c.lazy.decoded = false
+	v.Err = nil
+}
+
+// translate moves range of value, and of lazy children not decoded yet, delta bits
+// and make them relative to rootReader
+func (v *Value) translate(delta int64, rootReader bitio.ReaderAtSeeker) {
+	v.Range.Start += delta
+	v.RootReader = rootReader
+	if c, ok := v.V.(*Compound); ok && c.lazy != nil {
+		c.lazy.delta += delta
+		c.lazy.rootReader = rootReader
+	}
+}
+
+// FieldStructLazyFn decode struct nBits from current position using fn. If lazy
+// decoding is enabled fn is called the first time the struct is used instead.
+// This means fn should not have side effects that rest of the decoder depends on.
+// When done position will be nBits forward.
+func (d *D) FieldStructLazyFn(name string, nBits int64, fn func(d *D)) *Value {
+	return d.fieldCompoundLazyFn(name, nBits, false, fn)
+}
+
+// FieldArrayLazyFn same as FieldStructLazyFn but for an array
+func (d *D) FieldArrayLazyFn(name string, nBits int64, fn func(d *D)) *Value {
+	return d.fieldCompoundLazyFn(name, nBits, true, fn)
+}
+
+func (d *D) fieldCompoundLazyFn(name string, nBits int64, isArray bool, fn func(d *D)) *Value {
+	if nBits < 0 {
+		d.Fatalf("%d nBits < 0", nBits)
+	}
+
+	if !d.Options.Lazy {
+		var v *Value
+		d.FramedFn(nBits, func(d *D) {
+			if isArray {
+				v = d.FieldArray(name, fn).Value
+			} else {
+				v = d.FieldStruct(name, fn).Value
+			}
+		})
+		return v
+	}
+
+	pos := d.Pos()
+	// own reader with same positions so that decoding later don't affect others
+	br := d.BitBufRange(0, pos+nBits)
+	if _, err := br.SeekBits(pos, io.SeekStart); err != nil {
+		d.IOPanic(err, "fieldCompoundLazyFn: SeekAbs")
+	}
+
+	ld := *d
+	ld.Value = nil
+	ld.bitBuf = br
+	r := ranges.Range{Start: pos, Len: nBits}
+	v := &Value{
+		Name: name,
+		V: &Compound{
+			IsArray: isArray,
+			lazy: &lazy{
+				d:          ld,
+				r:          r,
+				fn:         fn,
+				rootReader: d.bitBuf,
+			},
+		},
+		Range:      r,
+		RootReader: d.bitBuf,
+	}
+	d.AddChild(v)
+	d.SeekRel(nBits)
+
+	return v
+}
@@ -25,6 +25,7 @@ func (d *D) tryUEndian(nBits int, endian Endian) (uint64, error) {
 	if err != nil {
 		return 0, err
 	}
+	d.readEndian = endian
 	if endian == LittleEndian {
 		n = bitio.ReverseBytes64(nBits, n)
 	}
@@ -93,6 +94,7 @@ func (d *D) tryFEndian(nBits int, endian Endian) (float64, error) {
 	if err != nil {
 		return 0, err
 	}
+	d.readEndian = endian
 	if endian == LittleEndian {
 		ReverseBytes(b)
 	}
@@ -154,11 +156,12 @@ func (d *D) tryTextLenPrefixed(lenBits int, fixedBytes int, e encoding.Encoding)
 	if lenBits < 0 {
 		return "", fmt.Errorf("tryTextLenPrefixed lenBits must be >= 0 (%d)", lenBits)
 	}
-	if fixedBytes < 0 {
-		return "", fmt.Errorf("tryTextLenPrefixed fixedBytes must be >= 0 (%d)", fixedBytes)
+	// -1 for not fixed
+	if fixedBytes < -1 {
+		return "", fmt.Errorf("tryTextLenPrefixed fixedBytes must be >= -1 (%d)", fixedBytes)
 	}
 	bytesLeft := d.BitsLeft() / 8
-	if int64(fixedBytes) > bytesLeft {
+	if fixedBytes != -1 && int64(fixedBytes) > bytesLeft {
 		return "", fmt.Errorf("tryTextLenPrefixed fixedBytes %d outside, %d bytes left", fixedBytes, bytesLeft)
 	}
 
@@ -262,7 +265,8 @@ func (d *D) tryULEB128() (uint64, error) {
 
 	for {
 		b := d.U8()
-		if shift >= 63 && b != 0 {
+		// 10th byte can only have the highest bit of 64 bits
+		if (shift == 63 && b&0x7f > 1) || (shift > 63 && b&0x7f != 0) {
 			return 0, fmt.Errorf("overflow when reading unsigned leb128, shift %d >= 63", shift)
 		}
 		result |= (b & 0x7f) << shift
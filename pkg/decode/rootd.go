@@ -0,0 +1,58 @@
+package decode
+
+import (
+	"context"
+
+	"github.com/wader/fq/internal/bitioex"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/ranges"
+)
+
+// RootD is a root decoder driven by the caller instead of by a format decode
+// function, ex: decoders written in jq. Reads and fields are done using the
+// embedded D and Done is called when done.
+type RootD struct {
+	*D
+
+	br bitio.ReaderAtSeeker
+	r  ranges.Range
+}
+
+// NewRootD returns a decoder for opts.Range of br, or whole br if zero. Root
+// value will have format as format.
+func NewRootD(ctx context.Context, br bitio.ReaderAtSeeker, format Format, opts Options) (*RootD, error) {
+	brLen, err := bitioex.Len(br)
+	if err != nil {
+		return nil, err
+	}
+
+	decodeRange := opts.Range
+	if decodeRange.IsZero() {
+		decodeRange = ranges.Range{Len: brLen}
+	}
+
+	cBR, err := bitioex.Range(br, decodeRange.Start, decodeRange.Len)
+	if err != nil {
+		return nil, IOError{Err: err, Op: "BitBufRange", ReadSize: decodeRange.Len, Pos: decodeRange.Start}
+	}
+
+	return &RootD{
+		D:  newDecoder(ctx, format, cBR, opts),
+		br: br,
+		r:  decodeRange,
+	}, nil
+}
+
+// Done finishes decoding the same way as a format decode, fill gaps etc, and
+// returns the root value. If decodeErr is not nil it's set as decode error of the
+// root value.
+func (rd *RootD) Done(decodeErr error) (*Value, error) {
+	if decodeErr != nil {
+		rd.Value.Err = FormatError{Err: decodeErr, Format: *rd.Value.Format}
+	}
+	if err := rd.done(rd.br, rd.r); err != nil {
+		return nil, err
+	}
+
+	return rd.Value, nil
+}
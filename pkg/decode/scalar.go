@@ -99,10 +99,16 @@ func (d *D) AssertBitBuf(bss ...[]byte) scalar.BitBufMapper {
 	})
 }
 
+// ValidateBitBuf validates that bits equals one of bss. If only one is given
+// it is used as expected value when encoding, ex: a checksum.
 func (d *D) ValidateBitBuf(bss ...[]byte) scalar.BitBufMapper {
-	return scalar.BitBufFn(func(s scalar.BitBuf) (scalar.BitBuf, error) {
+	m := scalar.BitBufFn(func(s scalar.BitBuf) (scalar.BitBuf, error) {
 		return assertBitBuf(s, false, bss...)
 	})
+	if len(bss) != 1 {
+		return m
+	}
+	return expectedBitBufMapper{BitBufMapper: m, expected: bss[0]}
 }
 
 func UintAssertBytes(s scalar.Uint, isErr bool, endian Endian, bss ...[]byte) (scalar.Uint, error) {
@@ -149,10 +155,17 @@ func (d *D) UintAssertBytes(bss ...[]byte) scalar.UintMapper {
 		return UintAssertBytes(s, true, d.Endian, bss...)
 	})
 }
+
+// UintValidateBytes validates that value equals one of bss as big-endian. If only
+// one is given it is used as expected value when encoding, ex: a checksum.
 func (d *D) UintValidateBytes(bss ...[]byte) scalar.UintMapper {
-	return scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
+	m := scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
 		return UintAssertBytes(s, false, d.Endian, bss...)
 	})
+	if len(bss) != 1 {
+		return m
+	}
+	return expectedUintMapper{UintMapper: m, expected: bytesToUint(bss[0])}
 }
 func (d *D) AssertULEBytes(bss ...[]byte) scalar.UintMapper {
 	return scalar.UintFn(func(s scalar.Uint) (scalar.Uint, error) {
@@ -174,3 +187,42 @@ func (d *D) UintValidateBEBytes(bss ...[]byte) scalar.UintMapper {
 		return UintAssertBytes(s, false, BigEndian, bss...)
 	})
 }
+
+// ExpectedMapper is implemented by mappers that validate against a known
+// expected value. Used by encoders to know what value to write, for example
+// when recomputing a checksum after the bytes it covers has been modified.
+type ExpectedMapper interface {
+	Expected() any
+}
+
+type expectedUintMapper struct {
+	scalar.UintMapper
+	expected uint64
+}
+
+func (m expectedUintMapper) Expected() any { return m.expected }
+
+type expectedBitBufMapper struct {
+	scalar.BitBufMapper
+	expected []byte
+}
+
+func (m expectedBitBufMapper) Expected() any { return m.expected }
+
+func mappersExpected[T any](sms []T) any {
+	var e any
+	for _, sm := range sms {
+		if em, ok := any(sm).(ExpectedMapper); ok {
+			e = em.Expected()
+		}
+	}
+	return e
+}
+
+func bytesToUint(bs []byte) uint64 {
+	var n uint64
+	for _, b := range bs {
+		n = n<<8 | uint64(b)
+	}
+	return n
+}
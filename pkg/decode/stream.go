@@ -0,0 +1,239 @@
+package decode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/wader/fq/internal/bitioex"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/ranges"
+)
+
+const streamReadSize = 64 * 1024
+
+// StreamMaxWindow is max number of bytes buffered while decoding one record
+const StreamMaxWindow = 64 * 1024 * 1024
+
+// StreamDecoder decodes records from a reader using Format.StreamFn. Only the
+// input needed to decode the current record is kept in memory.
+type StreamDecoder struct {
+	ctx   context.Context
+	r     io.Reader
+	opts  Options
+	group Group // formats left to probe, one when probed
+	state any
+
+	buf []byte
+	eof bool
+	pos int64 // position in stream of start of buf in bytes
+}
+
+// NewStreamDecoder returns a decoder that decodes records read from r using
+// first format in group that can decode the first record.
+func NewStreamDecoder(ctx context.Context, r io.Reader, group Group, opts Options) (*StreamDecoder, error) {
+	var streamGroup Group
+	for _, f := range group {
+		if f.StreamFn != nil {
+			streamGroup = append(streamGroup, f)
+		}
+	}
+	if len(streamGroup) == 0 {
+		return nil, errors.New("format can't be decoded as a stream")
+	}
+
+	return &StreamDecoder{
+		ctx:   ctx,
+		r:     r,
+		opts:  opts,
+		group: streamGroup,
+	}, nil
+}
+
+// stream decode errors that might go away with more input, ex: a sub format decode
+// that failed because of a too short read
+func isStreamNeedMoreErr(err error) bool {
+	var ioErr IOError
+	if errors.As(err, &ioErr) {
+		return errors.Is(ioErr.Err, io.EOF) ||
+			errors.Is(ioErr.Err, io.ErrUnexpectedEOF) ||
+			errors.Is(ioErr.Err, bitioex.ErrOutsideBuffer) ||
+			isStreamNeedMoreErr(ioErr.Err)
+	}
+	var formatsErr FormatsError
+	if errors.As(err, &formatsErr) {
+		for _, fe := range formatsErr.Errs {
+			if isStreamNeedMoreErr(fe.Err) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// streamNeedBits returns a lower bound of bits needed to decode a record, 0 if
+// not known. Only failed reads by the record decoder itself are used as reads by
+// sub formats can be relative to other buffers.
+func streamNeedBits(err error) int64 {
+	var formatsErr FormatsError
+	if !errors.As(err, &formatsErr) {
+		return 0
+	}
+	var n int64
+	for _, fe := range formatsErr.Errs {
+		if ioErr, ok := fe.Err.(IOError); ok && ioErr.Pos+ioErr.ReadSize > n {
+			n = ioErr.Pos + ioErr.ReadSize
+		}
+	}
+	return n
+}
+
+// fill does one read and appends what was read to buf. Spare capacity of buf
+// grows with it so that a large record is not decoded again for each small read.
+func (sd *StreamDecoder) fill() error {
+	if cap(sd.buf)-len(sd.buf) < streamReadSize {
+		newCap := 2 * cap(sd.buf)
+		if newCap < len(sd.buf)+streamReadSize {
+			newCap = len(sd.buf) + streamReadSize
+		}
+		if newCap > StreamMaxWindow {
+			newCap = StreamMaxWindow
+		}
+		if newCap <= len(sd.buf) {
+			return fmt.Errorf("record at byte position %d is larger than max window size %d", sd.pos, StreamMaxWindow)
+		}
+		buf := make([]byte, len(sd.buf), newCap)
+		copy(buf, sd.buf)
+		sd.buf = buf
+	}
+	n, err := sd.r.Read(sd.buf[len(sd.buf):cap(sd.buf)])
+	sd.buf = sd.buf[0 : len(sd.buf)+n]
+	if errors.Is(err, io.EOF) {
+		sd.eof = true
+		return nil
+	}
+	return err
+}
+
+func (sd *StreamDecoder) decodeRecord(f Format) (*Value, any, error) {
+	var state any
+	rf := f
+	rf.DecodeFn = func(d *D) any {
+		state = f.StreamFn(d, sd.state)
+		return nil
+	}
+
+	dv, _, err := decode(sd.ctx, bitio.NewBitReader(sd.buf, -1), Group{rf}, Options{
+		IsRoot:        true,
+		Force:         sd.opts.Force,
+		InArg:         sd.opts.InArg,
+		FormatInArgFn: sd.opts.FormatInArgFn,
+		ReadBuf:       sd.opts.ReadBuf,
+		Lazy:          sd.opts.Lazy,
+		LazyCache:     sd.opts.LazyCache,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dv, state, nil
+}
+
+// Next decodes and returns next record, returns io.EOF when there are no more records
+func (sd *StreamDecoder) Next() (*Value, error) {
+	// lower bound of bytes needed for current record, known after a failed decode
+	var needBytes int64
+
+	for {
+		if len(sd.buf) == 0 && !sd.eof {
+			if err := sd.fill(); err != nil {
+				return nil, err
+			}
+		}
+		if len(sd.buf) == 0 && sd.eof {
+			return nil, io.EOF
+		}
+		// no need to decode again until there is input for the failed read
+		if int64(len(sd.buf)) < needBytes && !sd.eof {
+			if err := sd.fill(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var dv *Value
+		var state any
+		var left Group
+		needMore := false
+		truncated := false
+		formatsErr := FormatsError{}
+		for i, f := range sd.group {
+			fdv, fstate, err := sd.decodeRecord(f)
+			if err == nil {
+				dv, state = fdv, fstate
+				sd.group = Group{f}
+				break
+			}
+			if ctxErr := sd.ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			if isStreamNeedMoreErr(err) {
+				if !sd.eof {
+					// keep probe order, read more and try again
+					needMore = true
+					needBytes = bitio.BitsByteCount(streamNeedBits(err))
+					left = append(left, sd.group[i:]...)
+					break
+				}
+				truncated = true
+			}
+			var fe FormatsError
+			if !errors.As(err, &fe) {
+				return nil, err
+			}
+			formatsErr.Errs = append(formatsErr.Errs, fe.Errs...)
+		}
+
+		if dv == nil {
+			if !needMore {
+				if truncated {
+					return nil, fmt.Errorf("truncated record at byte position %d, %d bytes left: %w", sd.pos, len(sd.buf), formatsErr)
+				}
+				return nil, formatsErr
+			}
+			sd.group = left
+			if err := sd.fill(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		sd.state = state
+		needBytes = 0
+
+		n := int(bitio.BitsByteCount(dv.Range.Len))
+		if n == 0 {
+			return nil, fmt.Errorf("record at byte position %d is empty", sd.pos)
+		}
+		recordBuf := sd.buf[0:n:n]
+		recordPos := sd.pos
+		sd.buf = sd.buf[n:]
+		sd.pos += int64(n)
+
+		c, ok := dv.V.(*Compound)
+		if ok && len(c.Children) == 0 {
+			// record without any fields, ex: only whitespace
+			continue
+		}
+
+		// make record only reference its own bytes
+		br := bitio.NewBitReader(recordBuf, -1)
+		_ = dv.walkNoLazy(true, func(v *Value, _ *Value, _ int, _ int) error {
+			v.translate(0, br)
+			return nil
+		})
+		dv.Range = ranges.Range{Start: recordPos * 8, Len: dv.Range.Len}
+
+		return dv, nil
+	}
+}
@@ -15,6 +15,8 @@ type Compound struct {
 	Children    []*Value
 	ByName      map[string]*Value
 	Description string
+
+	lazy *lazy
 }
 
 // TODO: Encoding, u16le, varint etc, encode?
@@ -27,10 +29,13 @@ type Value struct {
 	Index       int // index in parent array/struct
 	Range       ranges.Range
 	RootReader  bitio.ReaderAtSeeker
-	IsRoot      bool    // TODO: rework?
-	Format      *Format // TODO: rework
+	IsRoot      bool     // TODO: rework?
+	Format      *Format  // TODO: rework
+	Options     *Options // options used to decode format, set together with Format
 	Description string
 	Err         error
+	Endian      Endian // endian used when reading value, used when encoding
+	Expected    any    // expected actual value, ex: a checksum, used when encoding
 }
 
 type WalkFn func(v *Value, rootV *Value, depth int, rootDepth int) error
@@ -43,6 +48,8 @@ type WalkOpts struct {
 	PreOrder bool
 	OneRoot  bool
 	Fn       WalkFn
+
+	skipLazy bool // don't decode lazy values, used when decoding
 }
 
 func (v *Value) Walk(opts WalkOpts) error {
@@ -74,6 +81,10 @@ func (v *Value) Walk(opts WalkOpts) error {
 			}
 		}
 
+		if !opts.skipLazy {
+			wv.DecodeLazy()
+		}
+
 		switch wvv := wv.V.(type) {
 		case *Compound:
 			for _, wv := range wvv.Children {
@@ -143,6 +154,15 @@ func (v *Value) WalkRootPostOrder(fn WalkFn) error {
 	})
 }
 
+func (v *Value) walkNoLazy(preOrder bool, fn WalkFn) error {
+	return v.Walk(WalkOpts{
+		PreOrder: preOrder,
+		OneRoot:  true,
+		Fn:       fn,
+		skipLazy: true,
+	})
+}
+
 func (v *Value) root(findSubRoot bool, findFormatRoot bool) *Value {
 	rootV := v
 	for rootV.Parent != nil {
@@ -164,12 +184,12 @@ func (v *Value) FormatRoot() *Value { return v.root(true, true) }
 
 func (v *Value) Errors() []error {
 	var errs []error
-	_ = v.WalkPreOrder(func(v *Value, _ *Value, _ int, _ int) error {
+	_ = v.Walk(WalkOpts{PreOrder: true, skipLazy: true, Fn: func(v *Value, _ *Value, _ int, _ int) error {
 		if v.Err != nil {
 			errs = append(errs, v.Err)
 		}
 		return nil
-	})
+	}})
 	return errs
 }
 
@@ -181,7 +201,7 @@ func (v *Value) InnerRange() ranges.Range {
 }
 
 func (v *Value) postProcess() {
-	if err := v.WalkRootPostOrder(func(v *Value, _ *Value, _ int, _ int) error {
+	if err := v.walkNoLazy(false, func(v *Value, _ *Value, _ int, _ int) error {
 		switch vv := v.V.(type) {
 		case *Compound:
 			first := true
@@ -235,6 +255,9 @@ func (v *Value) TryUintScalarFn(sms ...scalar.UintMapper) error {
 		}
 	}
 	v.V = &s
+	if e := mappersExpected(sms); e != nil {
+		v.Expected = e
+	}
 	return err
 }
 
@@ -252,6 +275,9 @@ func (v *Value) TryBitBufScalarFn(sms ...scalar.BitBufMapper) error {
 		}
 	}
 	v.V = &s
+	if e := mappersExpected(sms); e != nil {
+		v.Expected = e
+	}
 	return err
 }
 
@@ -267,7 +293,7 @@ func (v *Value) Remove() error {
 			if _, ok := fv.ByName[v.Name]; !ok {
 				return fmt.Errorf("d not in parent ByName")
 			}
-			delete(fv.ByName, p.Name)
+			delete(fv.ByName, v.Name)
 		}
 		found := false
 		var cs []*Value
@@ -170,12 +170,37 @@ func (i *Interp) _toValue(c any, opts map[string]any) any {
 	)
 }
 
+// max number of decoded lazy values to keep per decode
+const decodeLazyCacheSize = 1024
+
 type decodeOpts struct {
 	Force    bool
+	Lazy     bool
 	Progress string
 	Remain   map[string]any `mapstruct:",remain"`
 }
 
+// formatInArg returns format arg from decode options or nil if same as init
+func (opts decodeOpts) formatInArg(init any) any {
+	v, err := copystructure.Copy(init)
+	if err != nil {
+		return nil
+	}
+
+	if len(opts.Remain) > 0 {
+		if err := mapstruct.ToStruct(opts.Remain, &v); err != nil {
+			// TODO: currently ignores failed struct mappings
+			return nil
+		}
+	}
+	// nil if same as init
+	if reflect.DeepEqual(init, v) {
+		return nil
+	}
+
+	return v
+}
+
 func (i *Interp) _decode(c any, format string, opts decodeOpts) any {
 	var filename string
 
@@ -236,30 +261,14 @@ func (i *Interp) _decode(c any, format string, opts decodeOpts) any {
 
 	dv, formatOut, err := decode.Decode(i.EvalInstance.Ctx, bv.br, decodeFormat,
 		decode.Options{
-			IsRoot:      true,
-			FillGaps:    true,
-			Force:       opts.Force,
-			Range:       bv.r,
-			Description: filename,
-			FormatInArgFn: func(init any) any {
-				v, err := copystructure.Copy(init)
-				if err != nil {
-					return nil
-				}
-
-				if len(opts.Remain) > 0 {
-					if err := mapstruct.ToStruct(opts.Remain, &v); err != nil {
-						// TODO: currently ignores failed struct mappings
-						return nil
-					}
-				}
-				// nil if same as init
-				if reflect.DeepEqual(init, v) {
-					return nil
-				}
-
-				return v
-			},
+			IsRoot:        true,
+			FillGaps:      true,
+			Force:         opts.Force,
+			Lazy:          opts.Lazy,
+			LazyCache:     &decode.LazyCache{Max: decodeLazyCacheSize},
+			Range:         bv.r,
+			Description:   filename,
+			FormatInArgFn: opts.formatInArg,
 		},
 	)
 	if dv == nil {
@@ -566,6 +575,7 @@ func (dvb decodeValueBase) JQValueKey(name string) any {
 		}
 
 	case "_error":
+		dv.DecodeLazy()
 		var formatErr decode.FormatError
 		if errors.As(dv.Err, &formatErr) {
 			return formatErr.Value()
@@ -649,9 +659,16 @@ func NewArrayDecodeValue(dv *decode.Value, out any, c *decode.Compound) ArrayDec
 func (v ArrayDecodeValue) JQValueKey(name string) any {
 	return valueKey(name, v.decodeValueBase.JQValueKey, v.Base.JQValueKey)
 }
-func (v ArrayDecodeValue) JQValueSliceLen() any { return len(v.Compound.Children) }
-func (v ArrayDecodeValue) JQValueLength() any   { return len(v.Compound.Children) }
+func (v ArrayDecodeValue) JQValueSliceLen() any {
+	v.dv.DecodeLazy()
+	return len(v.Compound.Children)
+}
+func (v ArrayDecodeValue) JQValueLength() any {
+	v.dv.DecodeLazy()
+	return len(v.Compound.Children)
+}
 func (v ArrayDecodeValue) JQValueIndex(index int) any {
+	v.dv.DecodeLazy()
 	// -1 outside after string, -2 outside before string
 	if index < 0 {
 		return nil
@@ -659,6 +676,7 @@ func (v ArrayDecodeValue) JQValueIndex(index int) any {
 	return makeDecodeValue((v.Compound.Children)[index], decodeValueValue)
 }
 func (v ArrayDecodeValue) JQValueSlice(start int, end int) any {
+	v.dv.DecodeLazy()
 	vs := make([]any, end-start)
 	for i, e := range (v.Compound.Children)[start:end] {
 		vs[i] = makeDecodeValue(e, decodeValueValue)
@@ -666,6 +684,7 @@ func (v ArrayDecodeValue) JQValueSlice(start int, end int) any {
 	return vs
 }
 func (v ArrayDecodeValue) JQValueEach() any {
+	v.dv.DecodeLazy()
 	props := make([]gojq.PathValue, len(v.Compound.Children))
 	for i, f := range v.Compound.Children {
 		props[i] = gojq.PathValue{Path: i, Value: makeDecodeValue(f, decodeValueValue)}
@@ -673,6 +692,7 @@ func (v ArrayDecodeValue) JQValueEach() any {
 	return props
 }
 func (v ArrayDecodeValue) JQValueKeys() any {
+	v.dv.DecodeLazy()
 	vs := make([]any, len(v.Compound.Children))
 	for i := range v.Compound.Children {
 		vs[i] = i
@@ -680,6 +700,7 @@ func (v ArrayDecodeValue) JQValueKeys() any {
 	return vs
 }
 func (v ArrayDecodeValue) JQValueHas(key any) any {
+	v.dv.DecodeLazy()
 	return valueHas(
 		key,
 		v.decodeValueBase.JQValueKey,
@@ -692,6 +713,7 @@ func (v ArrayDecodeValue) JQValueHas(key any) any {
 		})
 }
 func (v ArrayDecodeValue) JQValueToGoJQ() any {
+	v.dv.DecodeLazy()
 	vs := make([]any, len(v.Compound.Children))
 	for i, f := range v.Compound.Children {
 		vs[i] = makeDecodeValue(f, decodeValueValue)
@@ -717,12 +739,19 @@ func NewStructDecodeValue(dv *decode.Value, out any, c *decode.Compound) StructD
 	}
 }
 
-func (v StructDecodeValue) JQValueLength() any   { return len(v.Compound.Children) }
-func (v StructDecodeValue) JQValueSliceLen() any { return len(v.Compound.Children) }
+func (v StructDecodeValue) JQValueLength() any {
+	v.dv.DecodeLazy()
+	return len(v.Compound.Children)
+}
+func (v StructDecodeValue) JQValueSliceLen() any {
+	v.dv.DecodeLazy()
+	return len(v.Compound.Children)
+}
 func (v StructDecodeValue) JQValueKey(name string) any {
 	if strings.HasPrefix(name, "_") {
 		return v.decodeValueBase.JQValueKey(name)
 	}
+	v.dv.DecodeLazy()
 	if v.Compound.ByName != nil {
 		if f, ok := v.Compound.ByName[name]; ok {
 			return makeDecodeValue(f, decodeValueValue)
@@ -732,6 +761,7 @@ func (v StructDecodeValue) JQValueKey(name string) any {
 	return nil
 }
 func (v StructDecodeValue) JQValueEach() any {
+	v.dv.DecodeLazy()
 	props := make([]gojq.PathValue, len(v.Compound.Children))
 	for i, f := range v.Compound.Children {
 		props[i] = gojq.PathValue{Path: f.Name, Value: makeDecodeValue(f, decodeValueValue)}
@@ -739,6 +769,7 @@ func (v StructDecodeValue) JQValueEach() any {
 	return props
 }
 func (v StructDecodeValue) JQValueKeys() any {
+	v.dv.DecodeLazy()
 	vs := make([]any, len(v.Compound.Children))
 	for i, f := range v.Compound.Children {
 		vs[i] = f.Name
@@ -746,6 +777,7 @@ func (v StructDecodeValue) JQValueKeys() any {
 	return vs
 }
 func (v StructDecodeValue) JQValueHas(key any) any {
+	v.dv.DecodeLazy()
 	return valueHas(
 		key,
 		v.decodeValueBase.JQValueKey,
@@ -764,6 +796,7 @@ func (v StructDecodeValue) JQValueHas(key any) any {
 	)
 }
 func (v StructDecodeValue) JQValueToGoJQ() any {
+	v.dv.DecodeLazy()
 	vm := make(map[string]any, len(v.Compound.Children))
 	for _, f := range v.Compound.Children {
 		vm[f.Name] = makeDecodeValue(f, decodeValueValue)
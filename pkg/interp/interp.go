@@ -38,6 +38,7 @@ import (
 //go:embed options.jq
 //go:embed binary.jq
 //go:embed decode.jq
+//go:embed jqdecode.jq
 //go:embed registry_include.jq
 //go:embed format_decode.jq
 //go:embed format_func.jq
@@ -338,6 +339,7 @@ type EvalInstance struct {
 	IsCompleting bool
 
 	includeSeen map[string]struct{}
+	jqDecoders  []*jqDecoder // active decoders written in jq, see jqdecode.go
 }
 
 type Interp struct {
@@ -0,0 +1,259 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/wader/fq/internal/gojqex"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/scalar"
+)
+
+// functions used by decoders written in jq, see jqdecode.jq
+
+func init() {
+	RegisterFunc1("_jqdecode_begin", (*Interp)._jqDecodeBegin)
+	RegisterFunc1("_jqdecode_end", (*Interp)._jqDecodeEnd)
+	RegisterFunc2("_jqdecode_read", (*Interp)._jqDecodeRead)
+	RegisterFunc0("_jqdecode_pos", (*Interp)._jqDecodePos)
+	RegisterFunc0("_jqdecode_bits_left", (*Interp)._jqDecodeBitsLeft)
+	RegisterFunc2("_jqdecode_field", (*Interp)._jqDecodeField)
+	RegisterFunc2("_jqdecode_compound_begin", (*Interp)._jqDecodeCompoundBegin)
+	RegisterFunc1("_jqdecode_compound_end", (*Interp)._jqDecodeCompoundEnd)
+	RegisterFunc2("_jqdecode_format", (*Interp)._jqDecodeFormat)
+}
+
+var errNotInJQDecode = errors.New("not inside a jqdecode")
+
+type jqDecoder struct {
+	rd *decode.RootD
+	ds []*decode.D // decoders for current compounds, first is root
+}
+
+func (jd *jqDecoder) d() *decode.D { return jd.ds[len(jd.ds)-1] }
+
+// calls fn with the current jq decoder, reads etc panics on errors so recover and
+// return them as errors instead
+func (i *Interp) jqDecodeFn(fn func(jd *jqDecoder) any) (r any) {
+	jds := i.EvalInstance.jqDecoders
+	if len(jds) == 0 {
+		return errNotInJQDecode
+	}
+
+	defer func() {
+		if recoverV := recover(); recoverV != nil {
+			if re, ok := recoverV.(decode.RecoverableErrorer); ok && re.IsRecoverableError() {
+				r = recoverV.(error)
+				return
+			}
+			panic(recoverV)
+		}
+	}()
+
+	return fn(jds[len(jds)-1])
+}
+
+func (i *Interp) _jqDecodeBegin(c any, name string) any {
+	bv, err := toBinary(c)
+	if err != nil {
+		return err
+	}
+
+	rd, err := decode.NewRootD(
+		i.EvalInstance.Ctx,
+		bv.br,
+		decode.Format{Name: name},
+		decode.Options{
+			IsRoot:   true,
+			FillGaps: true,
+			Range:    bv.r,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	i.EvalInstance.jqDecoders = append(i.EvalInstance.jqDecoders, &jqDecoder{
+		rd: rd,
+		ds: []*decode.D{rd.D},
+	})
+
+	return nil
+}
+
+// decodeErr is null or the error caught while decoding
+func (i *Interp) _jqDecodeEnd(_ any, decodeErr any) any {
+	var err error
+	switch decodeErr := decodeErr.(type) {
+	case nil:
+	case string:
+		err = errors.New(decodeErr)
+	default:
+		err = fmt.Errorf("%v", decodeErr)
+	}
+
+	r := i.jqDecodeFn(func(jd *jqDecoder) any {
+		dv, err := jd.rd.Done(err)
+		if err != nil {
+			return err
+		}
+		return makeDecodeValue(dv, decodeValueValue)
+	})
+	if jds := i.EvalInstance.jqDecoders; len(jds) > 0 {
+		i.EvalInstance.jqDecoders = jds[0 : len(jds)-1]
+	}
+
+	return r
+}
+
+type jqDecodeReadOpts struct {
+	Bits   int
+	Endian string
+}
+
+// reads a value without adding it, _jqdecode_field adds it
+func (i *Interp) _jqDecodeRead(_ any, kind string, opts jqDecodeReadOpts) any {
+	return i.jqDecodeFn(func(jd *jqDecoder) any {
+		d := jd.d()
+
+		endian := d.Endian
+		switch opts.Endian {
+		case "", "be":
+		case "le":
+			endian = decode.LittleEndian
+		default:
+			return fmt.Errorf("unknown endian %q, should be be or le", opts.Endian)
+		}
+
+		pos := d.Pos()
+		v, err := d.TryValue(func() (*decode.Value, error) {
+			var s any
+			var err error
+			switch kind {
+			case "u":
+				var n uint64
+				n, err = d.TryUE(opts.Bits, endian)
+				s = &scalar.Uint{Actual: n}
+			case "s":
+				var n int64
+				n, err = d.TrySE(opts.Bits, endian)
+				s = &scalar.Sint{Actual: n}
+			case "f":
+				var n float64
+				n, err = d.TryFE(opts.Bits, endian)
+				s = &scalar.Flt{Actual: n}
+			case "bool":
+				var b bool
+				b, err = d.TryBool()
+				s = &scalar.Bool{Actual: b}
+			case "utf8":
+				var str string
+				str, err = d.TryUTF8(opts.Bits / 8)
+				s = &scalar.Str{Actual: str}
+			case "raw":
+				br, rErr := d.TryRawLen(int64(opts.Bits))
+				err = rErr
+				s = &scalar.BitBuf{Actual: br}
+			default:
+				return nil, fmt.Errorf("unknown read kind %q", kind)
+			}
+			return &decode.Value{V: s}, err
+		})
+		if err != nil {
+			return decode.IOError{Err: err, Op: kind, ReadSize: int64(opts.Bits), Pos: pos}
+		}
+
+		return makeDecodeValue(v, decodeValueValue)
+	})
+}
+
+func (i *Interp) _jqDecodePos(_ any) any {
+	return i.jqDecodeFn(func(jd *jqDecoder) any { return int(jd.d().Pos()) })
+}
+
+func (i *Interp) _jqDecodeBitsLeft(_ any) any {
+	return i.jqDecodeFn(func(jd *jqDecoder) any { return int(jd.d().BitsLeft()) })
+}
+
+// adds c as field name. If c is a value read using _jqdecode_read it's added as is
+// otherwise a value ranging from start to current position is added
+func (i *Interp) _jqDecodeField(c any, name string, start int) any {
+	return i.jqDecodeFn(func(jd *jqDecoder) any {
+		d := jd.d()
+
+		if dv, ok := c.(DecodeValue); ok {
+			v := dv.DecodeValue()
+			if _, isScalar := v.V.(Scalarable); isScalar && v.Parent == nil && !v.IsRoot && v.Name == "" {
+				v.Name = name
+				d.AddChild(v)
+				return makeDecodeValue(v, decodeValueValue)
+			}
+		}
+
+		a, ok := gojqex.ToGoJQValue(c)
+		if !ok {
+			return fmt.Errorf("%s: can't be a field value", gojqex.TypeErrorPreview(c))
+		}
+		v := d.FieldRangeFn(name, int64(start), d.Pos()-int64(start), func() *decode.Value {
+			return &decode.Value{V: &scalar.Any{Actual: a}}
+		})
+
+		return makeDecodeValue(v, decodeValueValue)
+	})
+}
+
+// returns depth of compound to be used with _jqdecode_compound_end
+func (i *Interp) _jqDecodeCompoundBegin(_ any, name string, isArray bool) any {
+	return i.jqDecodeFn(func(jd *jqDecoder) any {
+		var cd *decode.D
+		if isArray {
+			cd = jd.d().FieldArrayValue(name)
+		} else {
+			cd = jd.d().FieldStructValue(name)
+		}
+		jd.ds = append(jd.ds, cd)
+		return len(jd.ds)
+	})
+}
+
+// ends compound at depth and compounds inside it that might have been left if
+// there was an error
+func (i *Interp) _jqDecodeCompoundEnd(_ any, depth int) any {
+	return i.jqDecodeFn(func(jd *jqDecoder) any {
+		if depth < 2 || depth > len(jd.ds) {
+			return fmt.Errorf("invalid compound depth %d", depth)
+		}
+		cd := jd.ds[depth-1]
+		jd.ds = jd.ds[0 : depth-1]
+		return makeDecodeValue(cd.Value, decodeValueValue)
+	})
+}
+
+type jqDecodeFormatOpts struct {
+	Format string
+	Bits   int // -1 means to end
+}
+
+// decodes format as a field from current position
+func (i *Interp) _jqDecodeFormat(_ any, name string, opts jqDecodeFormatOpts) any {
+	group, err := i.Registry.FormatGroup(opts.Format)
+	if err != nil {
+		return err
+	}
+
+	return i.jqDecodeFn(func(jd *jqDecoder) any {
+		d := jd.d()
+
+		var dv *decode.Value
+		var err error
+		if opts.Bits < 0 {
+			dv, _, err = d.TryFieldFormat(name, group, nil)
+		} else {
+			dv, _, err = d.TryFieldFormatLen(name, int64(opts.Bits), group, nil)
+		}
+		if dv == nil || dv.Errors() != nil {
+			return err
+		}
+
+		return makeDecodeValue(dv, decodeValueValue)
+	})
+}
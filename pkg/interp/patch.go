@@ -0,0 +1,224 @@
+package interp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/wader/fq/internal/bitioex"
+	"github.com/wader/fq/internal/gojqex"
+	"github.com/wader/fq/pkg/bitio"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/fq/pkg/ranges"
+	"golang.org/x/exp/slices"
+)
+
+func init() {
+	RegisterFunc1("_patch", (*Interp)._patch)
+}
+
+// max number of re-decodes to recompute expected values like checksums, more than
+// one might be needed if a checksum covers another checksum
+const patchMaxExpectedPasses = 8
+
+type patch struct {
+	r  ranges.Range
+	br bitio.ReaderAtSeeker
+}
+
+type patches []patch
+
+func (ps patches) add(p patch) (patches, error) {
+	for i, op := range ps {
+		if op.r == p.r {
+			ps[i] = p
+			return ps, nil
+		}
+		if op.r.Start < p.r.Stop() && p.r.Start < op.r.Stop() {
+			return nil, fmt.Errorf("patch range %s overlaps %s", p.r, op.r)
+		}
+	}
+	ps = append(ps, p)
+	slices.SortFunc(ps, func(a, b patch) bool { return a.r.Start < b.r.Start })
+	return ps, nil
+}
+
+func (ps patches) reader(br bitio.ReaderAtSeeker) (bitio.ReaderAtSeeker, error) {
+	brLen, err := bitioex.Len(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []bitio.ReadAtSeeker
+	pos := int64(0)
+	for _, p := range ps {
+		if p.r.Start > pos {
+			pbr, err := bitioex.Range(br, pos, p.r.Start-pos)
+			if err != nil {
+				return nil, err
+			}
+			rs = append(rs, pbr)
+		}
+		rs = append(rs, p.br)
+		pos = p.r.Stop()
+	}
+	if pos < brLen {
+		pbr, err := bitioex.Range(br, pos, brLen-pos)
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, pbr)
+	}
+
+	return bitio.NewMultiReader(rs...)
+}
+
+// toEncodeValue converts a jq value into a go value accepted by decode.Value.Encode
+func toEncodeValue(v any) (any, error) {
+	switch vv := v.(type) {
+	case DecodeValue:
+		dv := vv.DecodeValue()
+		if _, ok := dv.V.(*decode.Compound); ok {
+			return nil, fmt.Errorf("can't use compound decode value as patch value")
+		}
+		return toEncodeValue(toValue(nil, vv))
+	case Binary:
+		return toBytes(vv)
+	case int:
+		return int64(vv), nil
+	case float64:
+		if vv == float64(int64(vv)) {
+			return int64(vv), nil
+		}
+		return vv, nil
+	case *big.Int, string, bool:
+		return vv, nil
+	default:
+		return nil, fmt.Errorf("%v can't be used as patch value", v)
+	}
+}
+
+func (i *Interp) _patch(c any, a []any) any {
+	cdv, ok := c.(DecodeValue)
+	if !ok {
+		return errors.New("expected a decode value")
+	}
+	rootDV := cdv.DecodeValue().BufferRoot()
+	rootBR := rootDV.RootReader
+
+	var ps patches
+	// ranges explicitly patched, are not recomputed even if they have an expected value
+	userRanges := map[ranges.Range]bool{}
+	patchValue := func(dv *decode.Value, v any) error {
+		br, err := dv.Encode(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", valuePathExprDecorated(dv, PlainDecorator), err)
+		}
+		ps, err = ps.add(patch{r: dv.Range, br: br})
+		return err
+	}
+
+	for _, e := range a {
+		pair, ok := e.([]any)
+		if !ok || len(pair) != 2 {
+			return errors.New("expected [decode value, value] pairs")
+		}
+		pdv, ok := pair[0].(DecodeValue)
+		if !ok {
+			return fmt.Errorf("patch target must be a decode value, got %s", gojqex.TypeErrorPreview(pair[0]))
+		}
+		dv := pdv.DecodeValue()
+		if dv.BufferRoot() != rootDV {
+			return fmt.Errorf("%s: can only patch values in same buffer", valuePathExprDecorated(dv, PlainDecorator))
+		}
+		v, err := toEncodeValue(pair[1])
+		if err != nil {
+			return err
+		}
+		if err := patchValue(dv, v); err != nil {
+			return err
+		}
+		userRanges[dv.Range] = true
+	}
+
+	br, err := ps.reader(rootBR)
+	if err != nil {
+		return err
+	}
+
+	// re-decode patched buffer and patch values that now differs from their expected value
+	if rootDV.Format != nil {
+		group, err := i.Registry.FormatGroup(rootDV.Format.Name)
+		if err != nil {
+			return err
+		}
+
+		// re-decode using same in arg and format options as original decode
+		var opts decode.Options
+		if rootDV.Options != nil {
+			opts = *rootDV.Options
+		}
+		opts.IsRoot = true
+		opts.FillGaps = true
+		opts.Force = true
+		opts.Range = rootDV.Range
+		opts.Lazy = false
+		opts.LazyCache = nil
+		opts.ReadBuf = nil
+
+		for pass := 0; pass < patchMaxExpectedPasses; pass++ {
+			dv, _, _ := decode.Decode(i.EvalInstance.Ctx, br, group, opts)
+			if dv == nil {
+				break
+			}
+
+			changed := false
+			if err := dv.WalkRootPreOrder(func(v *decode.Value, _ *decode.Value, _ int, _ int) error {
+				if v.Expected == nil || userRanges[v.Range] {
+					return nil
+				}
+				sv, ok := v.V.(Scalarable)
+				if !ok {
+					return nil
+				}
+				expected := v.Expected
+				switch e := expected.(type) {
+				case []byte:
+					abr, ok := sv.ScalarActual().(bitio.ReaderAtSeeker)
+					if !ok {
+						return nil
+					}
+					actual, err := toBytes(Binary{br: abr, r: ranges.Range{Len: v.Range.Len}, unit: 8})
+					if err != nil {
+						return err
+					}
+					if bytes.Equal(actual, e) {
+						return nil
+					}
+				default:
+					if sv.ScalarActual() == expected {
+						return nil
+					}
+				}
+				changed = true
+				return patchValue(v, expected)
+			}); err != nil {
+				return err
+			}
+			if !changed {
+				break
+			}
+
+			if br, err = ps.reader(rootBR); err != nil {
+				return err
+			}
+		}
+	}
+
+	bb, err := NewBinaryFromBitReader(br, 8, 0)
+	if err != nil {
+		return err
+	}
+	return bb
+}
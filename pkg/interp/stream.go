@@ -0,0 +1,99 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/wader/fq/internal/ctxreadseeker"
+	"github.com/wader/fq/internal/ioex"
+	"github.com/wader/fq/pkg/decode"
+	"github.com/wader/gojq"
+)
+
+func init() {
+	RegisterIter2("_decode_stream", (*Interp)._decodeStream)
+}
+
+type decodeStreamIter struct {
+	sd *decode.StreamDecoder
+	c  io.Closer // nil for stdin
+}
+
+func (it *decodeStreamIter) Next() (any, bool) {
+	if it.sd == nil {
+		return nil, false
+	}
+	dv, err := it.sd.Next()
+	if err != nil {
+		it.sd = nil
+		if it.c != nil {
+			it.c.Close()
+		}
+		if errors.Is(err, io.EOF) {
+			return nil, false
+		}
+		return err, true
+	}
+
+	return makeDecodeValue(dv, decodeValueValue), true
+}
+
+// decode file or stdin if input is null as a stream of records, only keeps
+// input for the current record in memory
+func (i *Interp) _decodeStream(c any, format string, opts decodeOpts) gojq.Iter {
+	if i.EvalInstance.IsCompleting {
+		return gojq.NewIter()
+	}
+
+	decodeFormat, err := i.Registry.FormatGroup(format)
+	if err != nil {
+		return gojq.NewIter(err)
+	}
+
+	var r io.Reader
+	var closer io.Closer
+	switch c.(type) {
+	case nil:
+		r = i.OS.Stdin()
+	default:
+		path, err := toString(c)
+		if err != nil {
+			return gojq.NewIter(fmt.Errorf("%s: %w", path, err))
+		}
+		f, err := i.OS.FS().Open(path)
+		if err != nil {
+			// path context added in jq error code
+			var pe *fs.PathError
+			if errors.As(err, &pe) {
+				return gojq.NewIter(pe.Err)
+			}
+			return gojq.NewIter(err)
+		}
+		r = f
+		closer = f
+	}
+
+	ctx := i.EvalInstance.Ctx
+	sd, err := decode.NewStreamDecoder(
+		ctx,
+		// ctxreadseeker is used to make sure reads can be canceled
+		ctxreadseeker.New(ctx, &ioex.ReadErrSeeker{Reader: r}),
+		decodeFormat,
+		decode.Options{
+			Force:         opts.Force,
+			Lazy:          opts.Lazy,
+			LazyCache:     &decode.LazyCache{Max: decodeLazyCacheSize},
+			FormatInArgFn: opts.formatInArg,
+		},
+	)
+	if err != nil {
+		if closer != nil {
+			closer.Close()
+		}
+		return gojq.NewIter(err)
+	}
+
+	return &decodeStreamIter{sd: sd, c: closer}
+}